@@ -19,18 +19,28 @@ type configScript struct {
 	Messengers    []string   `json:"messengers"`
 	MediaProvider string     `json:"mediaProvider"`
 	NeedsRestart  bool       `json:"needsRestart"`
+	ReadOnly      bool       `json:"readOnly"`
 	Update        *AppUpdate `json:"update"`
+	User          string     `json:"user"`
+	Role          string     `json:"role"`
+	Permissions   []string   `json:"permissions"`
+	CSRFToken     string     `json:"csrfToken"`
 }
 
 // handleGetConfigScript returns general configuration as a Javascript
 // variable that can be included in an HTML page directly.
 func handleGetConfigScript(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
-		out = configScript{
+		app     = c.Get("app").(*App)
+		csrf, _ = c.Get("csrf").(string)
+		out     = configScript{
 			RootURL:       app.constants.RootURL,
 			FromEmail:     app.constants.FromEmail,
 			MediaProvider: app.constants.MediaProvider,
+			User:          getSessionUser(c).Username,
+			Role:          getSessionUser(c).Role,
+			Permissions:   app.rolePerms.get(getSessionUser(c).Role),
+			CSRFToken:     csrf,
 		}
 	)
 
@@ -48,6 +58,7 @@ func handleGetConfigScript(c echo.Context) error {
 
 	app.Lock()
 	out.NeedsRestart = app.needsRestart
+	out.ReadOnly = app.readOnly.Enabled
 	out.Update = app.update
 	app.Unlock()
 
@@ -90,6 +101,40 @@ func handleGetDashboardCounts(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// handleGetDashboardStats returns instance-wide aggregates for the dashboard
+// and status boards with the changes in the current `?period=` (default: month).
+func handleGetDashboardStats(c echo.Context) error {
+	var (
+		app    = c.Get("app").(*App)
+		period = c.QueryParam("period")
+		out    types.JSONText
+	)
+
+	if period == "" {
+		period = "month"
+	}
+	if period != "day" && period != "week" && period != "month" && period != "year" {
+		return newFieldError("period", "Invalid period. Use day, week, month, or year.")
+	}
+
+	// The open and click rates of a year would be of the raw events that
+	// are left after pruning. The shorter periods are within the minimum
+	// tracking retention.
+	now := time.Now()
+	if since := trackingSince(app); period == "year" && since.After(time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())) {
+		return newFieldError("period",
+			fmt.Sprintf("Tracking data before %s has been pruned. Use a shorter period.", since.Format(dateFormat)))
+	}
+
+	if err := app.queries.GetDashboardStats.Get(&out, period); err != nil {
+		getLogger(c).Errorf("error fetching dashboard stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching dashboard stats: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // handleReloadApp restarts the app.
 func handleReloadApp(c echo.Context) error {
 	app := c.Get("app").(*App)
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// expander adds related records to the objects of an API response.
+type expander func(objs []map[string]interface{}, app *App) error
+
+// Expansions of the routes that support `?expand=`, which is the name of
+// the field the related records are added as.
+var (
+	campaignExpanders = map[string]expander{
+		"template": expandCampaignTemplate,
+		"lists":    expandCampaignLists,
+	}
+	listExpanders = map[string]expander{
+		"group": expandListGroup,
+	}
+)
+
+// bufferedWriter is an http.ResponseWriter that holds on to the response
+// so that it can be rewritten.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// bufferResponse runs a handler with its response buffered and resets the
+// response so that it can be written again.
+func bufferResponse(c echo.Context, next echo.HandlerFunc) (*bufferedWriter, error) {
+	var (
+		resp = c.Response()
+		buf  = &bufferedWriter{ResponseWriter: resp.Writer, status: http.StatusOK}
+	)
+	resp.Writer = buf
+	err := next(c)
+
+	resp.Writer = buf.ResponseWriter
+	resp.Committed = false
+	resp.Size = 0
+
+	return buf, err
+}
+
+// writeTo writes the buffered response to the response.
+func (w *bufferedWriter) writeTo(resp *echo.Response) error {
+	resp.WriteHeader(w.status)
+	_, err := resp.Write(w.body.Bytes())
+	return err
+}
+
+// responseFields returns a middleware that trims the objects in the JSON
+// responses of a GET route to the comma separated fields in `?fields=`
+// and adds the related records named in `?expand=` with the given
+// expanders. `id` and expanded fields are always returned. The objects are
+// the response data, the items of a response array, or the items of
+// `results` in paginated responses.
+func responseFields(expanders map[string]expander) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var (
+				fields = splitParams(c.QueryParams()["fields"])
+				expand = splitParams(c.QueryParams()["expand"])
+			)
+			if len(fields) == 0 && len(expand) == 0 {
+				return next(c)
+			}
+
+			for _, e := range expand {
+				if _, ok := expanders[e]; !ok {
+					return newFieldError("expand", fmt.Sprintf("Unknown expansion: %s", e))
+				}
+			}
+
+			buf, err := bufferResponse(c, next)
+			if err != nil {
+				return err
+			}
+			if buf.status != http.StatusOK {
+				return buf.writeTo(c.Response())
+			}
+
+			var out struct {
+				Data interface{} `json:"data"`
+			}
+			dec := json.NewDecoder(&buf.body)
+			dec.UseNumber()
+			if err := dec.Decode(&out); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError,
+					fmt.Sprintf("Error reading response: %v", err))
+			}
+
+			objs := responseObjects(out.Data)
+			for _, e := range expand {
+				if err := expanders[e](objs, c.Get("app").(*App)); err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError,
+						fmt.Sprintf("Error fetching %s: %s", e, pqErrMsg(err)))
+				}
+			}
+
+			if len(fields) > 0 {
+				keep := map[string]bool{"id": true}
+				for _, f := range append(fields, expand...) {
+					keep[f] = true
+				}
+				for _, o := range objs {
+					for k := range o {
+						if !keep[k] {
+							delete(o, k)
+						}
+					}
+				}
+			}
+
+			return c.JSON(http.StatusOK, okResp{out.Data})
+		}
+	}
+}
+
+// responseObjects returns the objects in decoded response data.
+func responseObjects(data interface{}) []map[string]interface{} {
+	var items []interface{}
+	switch v := data.(type) {
+	case []interface{}:
+		items = v
+	case map[string]interface{}:
+		res, ok := v["results"].([]interface{})
+		if !ok {
+			return []map[string]interface{}{v}
+		}
+		items = res
+	}
+
+	out := make([]map[string]interface{}, 0, len(items))
+	for _, it := range items {
+		if o, ok := it.(map[string]interface{}); ok {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// splitParams splits the values of a repeatable, comma separated query
+// param.
+func splitParams(vals []string) []string {
+	var out []string
+	for _, v := range vals {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// jsonID returns the integer value of a decoded JSON field.
+func jsonID(v interface{}) int64 {
+	n, _ := v.(json.Number)
+	id, _ := n.Int64()
+	return id
+}
+
+// expandCampaignTemplate adds the templates of campaigns without their
+// bodies as `template`.
+func expandCampaignTemplate(objs []map[string]interface{}, app *App) error {
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, 0, true, "", pq.StringArray{}); err != nil {
+		return err
+	}
+
+	tplMap := make(map[int64]models.Template, len(tpls))
+	for _, t := range tpls {
+		if t.Thumb != "" {
+			t.ThumbURL = app.media.Get(t.Thumb)
+		}
+		tplMap[int64(t.ID)] = t
+	}
+
+	for _, o := range objs {
+		if t, ok := tplMap[jsonID(o["template_id"])]; ok {
+			o["template"] = t
+		} else {
+			o["template"] = nil
+		}
+	}
+	return nil
+}
+
+// expandCampaignLists replaces the {id, name} lists of campaigns with the
+// full lists. Lists that have since been deleted are left as they are.
+func expandCampaignLists(objs []map[string]interface{}, app *App) error {
+	var ids []int64
+	for _, o := range objs {
+		ls, _ := o["lists"].([]interface{})
+		for _, l := range ls {
+			if m, ok := l.(map[string]interface{}); ok {
+				ids = append(ids, jsonID(m["id"]))
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var lists []models.List
+	if err := app.queries.GetListsByOptin.Select(&lists, "", pq.Int64Array(ids), nil); err != nil {
+		return err
+	}
+	listMap := make(map[int64]models.List, len(lists))
+	for _, l := range lists {
+		listMap[int64(l.ID)] = l
+	}
+
+	for _, o := range objs {
+		ls, _ := o["lists"].([]interface{})
+		for i, l := range ls {
+			m, ok := l.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if full, ok := listMap[jsonID(m["id"])]; ok {
+				ls[i] = full
+			}
+		}
+	}
+	return nil
+}
+
+// expandListGroup adds the groups of lists as `group`.
+func expandListGroup(objs []map[string]interface{}, app *App) error {
+	var groups []models.ListGroup
+	if err := app.queries.GetListGroups.Select(&groups, 0); err != nil {
+		return err
+	}
+
+	groupMap := make(map[int64]models.ListGroup, len(groups))
+	for _, g := range groups {
+		groupMap[int64(g.ID)] = g
+	}
+
+	for _, o := range objs {
+		if g, ok := groupMap[jsonID(o["group_id"])]; ok {
+			o["group"] = g
+		} else {
+			o["group"] = nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+)
+
+// Interval at which the buffered API usage counts are written to the DB.
+const apiUsageFlushInterval = time.Minute
+
+// apiUsage buffers the API request counts of users in memory between
+// writes to the DB so that requests don't each incur a write.
+type apiUsage struct {
+	sync.Mutex
+	users map[int]*apiUsageItem
+}
+
+type apiUsageItem struct {
+	requests    int64
+	errors      int64
+	lastIP      string
+	lastSeenAt  time.Time
+	lastErrorAt time.Time
+}
+
+func newAPIUsage() *apiUsage {
+	return &apiUsage{users: make(map[int]*apiUsageItem)}
+}
+
+// record records a request by a user and whether it failed.
+func (a *apiUsage) record(userID int, ip string, failed bool) {
+	now := time.Now()
+
+	a.Lock()
+	defer a.Unlock()
+
+	it, ok := a.users[userID]
+	if !ok {
+		it = &apiUsageItem{}
+		a.users[userID] = it
+	}
+	it.requests++
+	it.lastIP = ip
+	it.lastSeenAt = now
+	if failed {
+		it.errors++
+		it.lastErrorAt = now
+	}
+}
+
+// flush writes the buffered counts to the DB and resets them.
+func (a *apiUsage) flush(app *App) {
+	a.Lock()
+	users := a.users
+	a.users = make(map[int]*apiUsageItem)
+	a.Unlock()
+
+	for id, it := range users {
+		var lastErr interface{}
+		if !it.lastErrorAt.IsZero() {
+			lastErr = it.lastErrorAt
+		}
+
+		if _, err := app.queries.UpsertAPIUsage.Exec(id, it.requests, it.errors,
+			it.lastIP, it.lastSeenAt, lastErr); err != nil {
+			app.log.Errorf("error recording API usage: %v", err)
+		}
+	}
+}
+
+// trackAPIUsage middleware counts the requests and failed requests made
+// with the BasicAuth credentials of users. Requests from admin UI sessions
+// aren't counted.
+func trackAPIUsage(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u := getSessionUser(c)
+		if _, ok := c.Get("session").(string); ok || u.ID == 0 {
+			return next(c)
+		}
+
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			status = http.StatusInternalServerError
+			if e, ok := err.(*echo.HTTPError); ok {
+				status = e.Code
+			}
+		}
+
+		app := c.Get("app").(*App)
+		app.apiUsage.record(u.ID, c.RealIP(), status >= http.StatusBadRequest)
+		return err
+	}
+}
+
+// handleGetAPIUsage handles retrieval of the API usage of all users. Users
+// that have never made API requests have no `last_seen_at`.
+func handleGetAPIUsage(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		out []models.APIUsage
+	)
+
+	// Include the counts that are yet to be written.
+	app.apiUsage.flush(app)
+
+	if err := app.queries.GetAPIUsage.Select(&out); err != nil {
+		getLogger(c).Errorf("error fetching API usage: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching API usage: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	for i, u := range out {
+		if u.Requests > 0 {
+			out[i].ErrorRate = float64(u.Errors) / float64(u.Requests)
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// flushAPIUsage periodically writes the buffered API usage counts to the
+// DB. It blocks and should be invoked as a goroutine.
+func flushAPIUsage(app *App) {
+	for range time.Tick(apiUsageFlushInterval) {
+		app.apiUsage.flush(app)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// apiVersion is the current version of the API. Its routes are served
+// under /api/{version}/ and the unversioned /api/ routes are deprecated
+// aliases that are kept for existing integrations.
+const apiVersion = "v1"
+
+// versionAPI is a pre-routing middleware that serves the versioned API
+// paths with the API routes by rewriting them to the unversioned paths,
+// so that the routes are registered and referred to (eg: permissions and
+// the audit log) once. Requests to the unversioned paths get Deprecation
+// and successor Link headers, and a Sunset header if a date on which
+// they'll be removed is set.
+func versionAPI(cs *constants) echo.MiddlewareFunc {
+	prefix := "/api/" + apiVersion
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				return next(c)
+			}
+
+			if p := strings.TrimPrefix(r.URL.Path, prefix); p != r.URL.Path {
+				if p != "" && p[0] != '/' {
+					return next(c)
+				}
+				r.URL.Path = "/api" + p
+				if r.URL.RawPath != "" {
+					r.URL.RawPath = "/api" + strings.TrimPrefix(r.URL.RawPath, prefix)
+				}
+				c.Response().Header().Set("API-Version", apiVersion)
+				return next(c)
+			}
+
+			// The admin UI's config script isn't part of the API.
+			if r.URL.Path == "/api/config.js" {
+				return next(c)
+			}
+
+			h := c.Response().Header()
+			h.Set("Deprecation", "true")
+			h.Set("Link", `<`+prefix+strings.TrimPrefix(r.URL.Path, "/api")+`>; rel="successor-version"`)
+			if !cs.LegacyAPISunset.IsZero() {
+				h.Set("Sunset", cs.LegacyAPISunset.Format(http.TimeFormat))
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+)
+
+// Interval at which old entries are deleted from the audit log.
+const auditLogPruneInterval = time.Hour
+
+// Actions of the admin routes that are recorded in the audit log when
+// they succeed. Read-only routes such as previews aren't recorded.
+var auditActions = map[string]string{
+	"PUT /api/settings":        "settings.update",
+	"POST /api/admin/reload":   "app.reload",
+	"PUT /api/admin/read-only": "app.read_only",
+
+	"PUT /api/roles/:role":         "role.update",
+	"POST /api/users":              "user.create",
+	"PUT /api/users/:id":           "user.update",
+	"DELETE /api/users/:id":        "user.delete",
+	"DELETE /api/users/:id/2fa":    "user.reset_2fa",
+	"POST /api/logout":             "auth.logout",
+	"PUT /api/profile":             "profile.update",
+	"POST /api/profile/2fa/verify": "profile.enable_2fa",
+	"DELETE /api/profile/2fa":      "profile.disable_2fa",
+
+	"POST /api/subscribers":                "subscriber.create",
+	"PUT /api/subscribers/:id":             "subscriber.update",
+	"POST /api/subscribers/batch":          "subscriber.batch",
+	"PUT /api/subscribers/blocklist":       "subscriber.blocklist",
+	"PUT /api/subscribers/:id/blocklist":   "subscriber.blocklist",
+	"PUT /api/subscribers/lists/:id":       "subscriber.manage_lists",
+	"PUT /api/subscribers/lists":           "subscriber.manage_lists",
+	"POST /api/subscribers/lists/batch":    "subscriber.batch_manage_lists",
+	"DELETE /api/subscribers/:id":          "subscriber.delete",
+	"DELETE /api/subscribers":              "subscriber.delete",
+	"POST /api/subscribers/query/delete":   "subscriber.delete_by_query",
+	"PUT /api/subscribers/query/blocklist": "subscriber.blocklist_by_query",
+	"PUT /api/subscribers/query/lists":     "subscriber.manage_lists_by_query",
+	"GET /api/subscribers/export":          "subscriber.export",
+	"GET /api/subscribers/:id/export":      "subscriber.export_data",
+	"POST /api/import/subscribers":         "import.start",
+	"DELETE /api/import/subscribers":       "import.stop",
+
+	"POST /api/lists":                        "list.create",
+	"PUT /api/lists/:id":                     "list.update",
+	"POST /api/lists/:id/clone":              "list.clone",
+	"POST /api/lists/:id/merge":              "list.merge",
+	"PUT /api/lists/:id/archive":             "list.archive",
+	"DELETE /api/lists/:id":                  "list.delete",
+	"POST /api/lists/:id/webhooks":           "list.create_webhook",
+	"PUT /api/lists/:id/webhooks/:hookID":    "list.update_webhook",
+	"DELETE /api/lists/:id/webhooks/:hookID": "list.delete_webhook",
+	"POST /api/lists/groups":                 "list_group.create",
+	"PUT /api/lists/groups/:id":              "list_group.update",
+	"DELETE /api/lists/groups/:id":           "list_group.delete",
+
+	"POST /api/webhooks":                      "webhook.create",
+	"PUT /api/webhooks/:id":                   "webhook.update",
+	"DELETE /api/webhooks/:id":                "webhook.delete",
+	"POST /api/webhooks/deliveries/:id/retry": "webhook.retry_delivery",
+
+	"POST /api/campaigns":           "campaign.create",
+	"PUT /api/campaigns/:id":        "campaign.update",
+	"PUT /api/campaigns/:id/status": "campaign.status",
+	"POST /api/campaigns/:id/test":  "campaign.test",
+	"DELETE /api/campaigns/:id":     "campaign.delete",
+	"POST /api/media":               "media.upload",
+	"DELETE /api/media/:id":         "media.delete",
+
+	"POST /api/templates":                              "template.create",
+	"POST /api/templates/import":                       "template.import",
+	"PUT /api/templates/:id":                           "template.update",
+	"POST /api/templates/:id/clone":                    "template.clone",
+	"PUT /api/templates/:id/default":                   "template.set_default",
+	"DELETE /api/templates/:id":                        "template.delete",
+	"PUT /api/templates/:id/fallback":                  "template.set_fallback",
+	"DELETE /api/templates/:id/fallback":               "template.unset_fallback",
+	"PUT /api/templates/:id/variants/:lang":            "template.update_variant",
+	"DELETE /api/templates/:id/variants/:lang":         "template.delete_variant",
+	"POST /api/templates/:id/fixtures":                 "template.create_fixture",
+	"PUT /api/templates/:id/fixtures/:fixtureID":       "template.update_fixture",
+	"DELETE /api/templates/:id/fixtures/:fixtureID":    "template.delete_fixture",
+	"POST /api/templates/:id/revisions/:revID/restore": "template.restore_revision",
+	"PUT /api/templates/system/:name/:lang":            "system_template.update",
+	"DELETE /api/templates/system/:name/:lang":         "system_template.delete",
+	"POST /api/templates/partials":                     "template_partial.create",
+	"PUT /api/templates/partials/:id":                  "template_partial.update",
+	"DELETE /api/templates/partials/:id":               "template_partial.delete",
+}
+
+type auditLogWrap struct {
+	Results []models.AuditLog `json:"results"`
+
+	Total   int `json:"total"`
+	PerPage int `json:"per_page"`
+	Page    int `json:"page"`
+}
+
+// handleGetAuditLog handles retrieval of the audit log.
+func handleGetAuditLog(c echo.Context) error {
+	var (
+		app    = c.Get("app").(*App)
+		pg     = getPagination(c.QueryParams(), 20, 50)
+		out    auditLogWrap
+		user   = strings.TrimSpace(c.FormValue("username"))
+		action = strings.TrimSpace(c.FormValue("action"))
+	)
+
+	from, err := parseAuditDate(c.FormValue("from"))
+	if err != nil {
+		return newFieldError("from", "Invalid `from` date.")
+	}
+	to, err := parseAuditDate(c.FormValue("to"))
+	if err != nil {
+		return newFieldError("to", "Invalid `to` date.")
+	}
+
+	if err := app.queries.QueryAuditLog.Select(&out.Results, user, action, from, to, pg.Offset, pg.Limit); err != nil {
+		getLogger(c).Errorf("error fetching audit log: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching audit log: %s", pqErrMsg(err)))
+	}
+	if len(out.Results) == 0 {
+		out.Results = []models.AuditLog{}
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	out.Total = out.Results[0].Total
+	out.Page = pg.Page
+	out.PerPage = pg.PerPage
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// auditLog middleware records the successful administrative actions of
+// the admin routes in the audit log.
+func auditLog(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		action, ok := auditActions[c.Request().Method+" "+c.Path()]
+		if !ok {
+			return next(c)
+		}
+
+		if err := next(c); err != nil {
+			return err
+		}
+		if c.Response().Status >= http.StatusBadRequest {
+			return nil
+		}
+
+		// Route params and the details set by the handler.
+		meta, _ := c.Get("audit").(map[string]interface{})
+		if meta == nil {
+			meta = make(map[string]interface{})
+		}
+		for i, p := range c.ParamNames() {
+			meta[p] = c.ParamValues()[i]
+		}
+
+		recordAudit(c, action, meta)
+		return nil
+	}
+}
+
+// setAuditMeta adds a detail of an action to its audit log entry.
+func setAuditMeta(c echo.Context, key string, val interface{}) {
+	meta, _ := c.Get("audit").(map[string]interface{})
+	if meta == nil {
+		meta = make(map[string]interface{})
+		c.Set("audit", meta)
+	}
+	meta[key] = val
+}
+
+// recordAudit records an action by the user making the request in the
+// audit log.
+func recordAudit(c echo.Context, action string, meta map[string]interface{}) {
+	var (
+		app = c.Get("app").(*App)
+		u   = getSessionUser(c)
+	)
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		getLogger(c).Errorf("error marshalling audit log meta: %v", err)
+		b = []byte("{}")
+	}
+
+	if _, err := app.queries.InsertAuditLog.Exec(u.ID, u.Username, action, string(b), c.RealIP()); err != nil {
+		getLogger(c).Errorf("error recording audit log (%s): %v", action, err)
+	}
+}
+
+// parseAuditDate parses an optional date or timestamp filter.
+func parseAuditDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", s); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// pruneAuditLog is a blocking function that deletes audit log entries
+// older than the retention period at intervals.
+func pruneAuditLog(retention time.Duration, app *App) {
+	ticker := time.NewTicker(auditLogPruneInterval)
+	for ; true; <-ticker.C {
+		res, err := app.queries.DeleteAuditLog.Exec(time.Now().Add(-retention))
+		if err != nil {
+			app.log.Errorf("error pruning audit log: %v", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			app.log.Infof("pruned %d old audit log entries", n)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/webhooks"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+const (
+	bounceActionNone      = "none"
+	bounceActionBlocklist = "blocklist"
+	bounceActionDelete    = "delete"
+)
+
+// bounceAction is the action taken on a subscriber when the number of their
+// bounces of a type reaches Count.
+type bounceAction struct {
+	Count  int    `json:"count" koanf:"count"`
+	Action string `json:"action" koanf:"action"`
+}
+
+var errBounceNoSubscriber = errors.New("subscriber not found")
+
+// handleBounceWebhook receives bounce and complaint notifications from
+// e-mail services and queues them for recording.
+func handleBounceWebhook(c echo.Context) error {
+	var (
+		app     = c.Get("app").(*App)
+		service = c.Param("service")
+	)
+
+	if app.bounce == nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Bounce processing is disabled.")
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, 1<<20))
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Error reading request body.")
+	}
+
+	var bounces []models.Bounce
+	switch {
+	// Amazon SES notifications via SNS.
+	case service == "ses" && app.bounce.SES != nil:
+		bs, err := app.bounce.SES.ProcessBounce(body)
+		if err != nil {
+			getLogger(c).Errorf("error processing SES notification: %v", err)
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Invalid SES notification.")
+		}
+		bounces = bs
+
+	// SendGrid signed event webhook.
+	case service == "sendgrid" && app.bounce.SendGrid != nil:
+		var (
+			sig = c.Request().Header.Get("X-Twilio-Email-Event-Webhook-Signature")
+			ts  = c.Request().Header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+		)
+		bs, delivered, err := app.bounce.SendGrid.ProcessBounce(sig, ts, body)
+		if err != nil {
+			getLogger(c).Errorf("error processing SendGrid events: %v", err)
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Invalid SendGrid events.")
+		}
+		bounces = bs
+
+		for campUUID, n := range delivered {
+			if !reUUID.MatchString(campUUID) {
+				continue
+			}
+			if _, err := app.queries.RegisterCampDelivery.Exec(campUUID, n); err != nil {
+				getLogger(c).Errorf("error registering campaign deliveries: %v", err)
+			}
+		}
+
+	// Mailgun signed webhook.
+	case service == "mailgun" && app.bounce.Mailgun != nil:
+		bs, err := app.bounce.Mailgun.ProcessBounce(body)
+		if err != nil {
+			getLogger(c).Errorf("error processing Mailgun webhook: %v", err)
+
+			// Mailgun retries webhooks that fail with anything but 406.
+			return echo.NewHTTPError(http.StatusNotAcceptable, "Invalid Mailgun webhook.")
+		}
+		bounces = bs
+
+	default:
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Unknown bounce service.")
+	}
+
+	for _, b := range bounces {
+		if err := app.bounce.Record(b); err != nil {
+			getLogger(c).Errorf("error queuing %s bounce: %v", service, err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error recording bounce.")
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// recordBounce records a bounce against a subscriber and, if the subscriber's
+// bounces of the type reach the configured count, applies the bounce action.
+func recordBounce(b models.Bounce, app *App) error {
+	if b.SubscriberUUID == "" && b.Email == "" {
+		return errBounceNoSubscriber
+	}
+	if b.SubscriberUUID != "" && !reUUID.MatchString(b.SubscriberUUID) {
+		return errBounceNoSubscriber
+	}
+	if !reUUID.MatchString(b.CampaignUUID) {
+		b.CampaignUUID = ""
+	}
+	if len(b.Meta) == 0 {
+		b.Meta = json.RawMessage("{}")
+	}
+
+	// Complaints are recorded separately and the complainer is suppressed
+	// regardless of the bounce actions.
+	if b.Type == models.BounceTypeComplaint {
+		return recordComplaint(b, app)
+	}
+
+	var res struct {
+		SubscriberID int64 `db:"subscriber_id"`
+		Count        int   `db:"count"`
+	}
+	if err := app.queries.RecordBounce.Get(&res, b.SubscriberUUID, strings.TrimSpace(b.Email),
+		b.CampaignUUID, b.Type, b.Source, b.Meta); err != nil {
+		if err == sql.ErrNoRows {
+			return errBounceNoSubscriber
+		}
+		return fmt.Errorf("error recording bounce: %v", pqErrMsg(err))
+	}
+	pushWebhooks(webhooks.EventBounce, b, app)
+
+	act, ok := app.constants.BounceActions[b.Type]
+	if !ok || act.Count < 1 || res.Count < act.Count {
+		return nil
+	}
+
+	switch act.Action {
+	case bounceActionBlocklist:
+		if _, err := app.queries.BlocklistSubscribers.Exec(pq.Int64Array{res.SubscriberID}); err != nil {
+			return fmt.Errorf("error blocklisting bounced subscriber: %v", pqErrMsg(err))
+		}
+	case bounceActionDelete:
+		if _, err := app.queries.DeleteSubscribers.Exec(pq.Int64Array{res.SubscriberID}, nil); err != nil {
+			return fmt.Errorf("error deleting bounced subscriber: %v", pqErrMsg(err))
+		}
+	}
+
+	return nil
+}
+
+// recordComplaint records a spam complaint against a subscriber and blocklists
+// the subscriber.
+func recordComplaint(b models.Bounce, app *App) error {
+	var subID int64
+	if err := app.queries.RecordComplaint.Get(&subID, b.SubscriberUUID, strings.TrimSpace(b.Email),
+		b.CampaignUUID, b.Source, b.Meta); err != nil {
+		if err == sql.ErrNoRows {
+			return errBounceNoSubscriber
+		}
+		return fmt.Errorf("error recording complaint: %v", pqErrMsg(err))
+	}
+	pushWebhooks(webhooks.EventBounce, b, app)
+
+	return nil
+}
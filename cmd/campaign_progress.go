@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// campProgress is the sending progress of a campaign.
+type campProgress struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+	Sent   int    `json:"sent"`
+	ToSend int    `json:"to_send"`
+	Errors int    `json:"errors"`
+
+	// Messages sent per minute.
+	Rate float64 `json:"rate"`
+
+	// Estimated time at which the campaign will finish sending.
+	ETA null.Time `json:"eta"`
+}
+
+// handleCampaignProgressStream streams the sending progress of a campaign
+// as server-sent `progress` events while it's running or scheduled. When
+// the campaign stops, the last progress is followed by an `end` event and
+// the stream is closed. Requests for campaigns that aren't running or
+// scheduled get 204 No Content, which stops clients from reconnecting.
+func handleCampaignProgressStream(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	p, err := getCampaignProgress(id, app)
+	if err != nil {
+		return err
+	}
+	if !isCampaignActive(p.Status) {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentType, "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	c.Response().WriteHeader(http.StatusOK)
+
+	fmt.Fprint(c.Response(), "retry: 5000\n\n")
+	if err := writeProgressEvent(c, p); err != nil {
+		return nil
+	}
+
+	var (
+		poll      = time.NewTicker(eventsPollInterval)
+		keepAlive = time.NewTicker(eventsKeepAlive)
+	)
+	defer poll.Stop()
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Response(), ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			c.Response().Flush()
+
+		case <-poll.C:
+			next, err := getCampaignProgress(id, app)
+			if err != nil {
+				// The campaign may have been deleted.
+				fmt.Fprint(c.Response(), "event: end\ndata: {}\n\n")
+				c.Response().Flush()
+				return nil
+			}
+			if next == p {
+				continue
+			}
+
+			p = next
+			if err := writeProgressEvent(c, p); err != nil {
+				return nil
+			}
+			if !isCampaignActive(p.Status) {
+				fmt.Fprint(c.Response(), "event: end\ndata: {}\n\n")
+				c.Response().Flush()
+				return nil
+			}
+		}
+	}
+}
+
+// getCampaignProgress returns the sending progress of a campaign.
+func getCampaignProgress(id int, app *App) (campProgress, error) {
+	var s campaignStats
+	if err := app.queries.GetCampaignProgress.Get(&s, id); err != nil {
+		if err == sql.ErrNoRows {
+			return campProgress{}, newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
+		}
+
+		app.log.Errorf("error fetching campaign progress: %v", err)
+		return campProgress{}, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+
+	out := campProgress{
+		ID:     s.ID,
+		Status: s.Status,
+		Sent:   s.Sent,
+		ToSend: s.ToSend,
+		Errors: app.manager.CampaignErrors(s.ID),
+		Rate:   getCampaignRate(s),
+	}
+	if s.Status == models.CampaignStatusRunning && out.Rate > 0 && s.ToSend > s.Sent {
+		mins := float64(s.ToSend-s.Sent) / out.Rate
+		out.ETA = null.TimeFrom(s.UpdatedAt.Time.Add(time.Duration(mins * float64(time.Minute))).
+			Truncate(time.Second))
+	}
+	return out, nil
+}
+
+// writeProgressEvent writes a campaign's progress as a server-sent event.
+func writeProgressEvent(c echo.Context, p campProgress) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Response(), "event: progress\ndata: %s\n\n", b); err != nil {
+		return err
+	}
+	c.Response().Flush()
+	return nil
+}
+
+func isCampaignActive(status string) bool {
+	return status == models.CampaignStatusRunning || status == models.CampaignStatusScheduled
+}
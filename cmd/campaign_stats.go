@@ -0,0 +1,600 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Intervals of the buckets of stats timelines.
+var statsIntervals = map[string]bool{
+	"hour":  true,
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+// campViewStats represents the open (view) stats of a campaign. The
+// timeline is a list of {timestamp, views, unique_views}.
+type campViewStats struct {
+	Views       int            `db:"views" json:"views"`
+	UniqueViews int            `db:"unique_views" json:"unique_views"`
+	Timeline    types.JSONText `db:"timeline" json:"timeline"`
+}
+
+// subViewStats represents the opens (views) of a campaign by a subscriber.
+type subViewStats struct {
+	CampaignID   int       `db:"campaign_id" json:"campaign_id"`
+	CampaignName string    `db:"campaign_name" json:"campaign_name"`
+	Views        int       `db:"views" json:"views"`
+	FirstViewAt  null.Time `db:"first_view_at" json:"first_view_at"`
+	LastViewAt   null.Time `db:"last_view_at" json:"last_view_at"`
+}
+
+// handleGetCampaignViewStats handles retrieval of the open stats of a
+// campaign with a timeline in buckets of `?interval=` (default: day).
+func handleGetCampaignViewStats(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		id, _    = strconv.Atoi(c.Param("id"))
+		interval = c.QueryParam("interval")
+		out      campViewStats
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+	if interval == "" {
+		interval = "day"
+	}
+	if !statsIntervals[interval] {
+		return newFieldError("interval", "Invalid interval. Use hour, day, week, or month.")
+	}
+
+	if err := app.queries.GetCampaignViewStats.Get(&out, id, interval); err != nil {
+		getLogger(c).Errorf("error fetching campaign view stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// campLinkStats represents the click stats of a tracked link in a campaign.
+// The top subscribers are a list of {id, uuid, email, name, clicks}.
+type campLinkStats struct {
+	URL            string         `db:"url" json:"url"`
+	Clicks         int            `db:"clicks" json:"clicks"`
+	UniqueClicks   int            `db:"unique_clicks" json:"unique_clicks"`
+	FirstClickAt   null.Time      `db:"first_click_at" json:"first_click_at"`
+	LastClickAt    null.Time      `db:"last_click_at" json:"last_click_at"`
+	TopSubscribers types.JSONText `db:"top_subscribers" json:"top_subscribers"`
+}
+
+// handleGetCampaignLinkStats handles retrieval of the click stats of every
+// tracked link in a campaign with the `?top=` (default: 5) subscribers
+// that clicked each link the most. Links in the campaign's body that
+// haven't been clicked are included with no clicks.
+func handleGetCampaignLinkStats(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		top   = 5
+		out   []campLinkStats
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+	if v := c.QueryParam("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 50 {
+			return newFieldError("top", "Invalid value for `top`. It should be between 0 and 50.")
+		}
+		top = n
+	}
+
+	var camp models.Campaign
+	if err := app.queries.GetCampaign.Get(&camp, id, nil); err != nil {
+		if err == sql.ErrNoRows {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
+		}
+
+		getLogger(c).Errorf("error fetching campaign: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign: %s", pqErrMsg(err)))
+	}
+
+	if err := app.queries.GetCampaignLinkStats.Select(&out, id, top); err != nil {
+		getLogger(c).Errorf("error fetching campaign link stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+
+	clicked := make(map[string]bool, len(out))
+	for _, l := range out {
+		clicked[l.URL] = true
+	}
+	for _, u := range camp.TrackedLinks() {
+		if !clicked[u] {
+			out = append(out, campLinkStats{URL: u, TopSubscribers: types.JSONText(`[]`)})
+		}
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// campClientStats represents the views and clicks of a campaign from an
+// e-mail client or browser, or a device class. Unidentified ones have no name.
+type campClientStats struct {
+	Name   string `db:"name" json:"name"`
+	Views  int    `db:"views" json:"views"`
+	Clicks int    `db:"clicks" json:"clicks"`
+}
+
+// handleGetCampaignClientStats handles retrieval of the views and clicks of
+// a campaign by e-mail client, or by device class with `?by=device`.
+func handleGetCampaignClientStats(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		by    = c.QueryParam("by")
+		out   []campClientStats
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+	if by == "" {
+		by = "client"
+	}
+	if by != "client" && by != "device" {
+		return newFieldError("by", "Invalid value for `by`. Use client or device.")
+	}
+
+	if err := app.queries.GetCampaignClientStats.Select(&out, id, by == "device"); err != nil {
+		getLogger(c).Errorf("error fetching campaign client stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// campLinkHeatmap represents the clicks of the link at a position in a
+// campaign's rendered message. Percent is the share of the campaign's clicks.
+type campLinkHeatmap struct {
+	Position     int     `db:"position" json:"position"`
+	URL          string  `db:"url" json:"url"`
+	Clicks       int     `db:"clicks" json:"clicks"`
+	UniqueClicks int     `db:"unique_clicks" json:"unique_clicks"`
+	Percent      float64 `db:"-" json:"percent"`
+}
+
+// handleGetCampaignLinkHeatmap handles retrieval of the clicks of a campaign
+// by the position of the tracked links in its rendered message for drawing
+// a heatmap over it. Positions start at 1 in the order of the TrackLink
+// calls, and links in campaign previews carry them in the `p` query param.
+func handleGetCampaignLinkHeatmap(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		out   []campLinkHeatmap
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetCampaignLinkHeatmap.Select(&out, id); err != nil {
+		getLogger(c).Errorf("error fetching campaign link heatmap: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	total := 0
+	for _, h := range out {
+		total += h.Clicks
+	}
+	for i := range out {
+		out[i].Percent = percent(out[i].Clicks, total)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// campUnsubStats represents the unsubscriptions from a campaign. Rate is
+// the percentage of the messages sent. Timing has the unsubscriptions in
+// buckets of time after the delivery of the message, eg: "1h - 6h".
+type campUnsubStats struct {
+	Unsubscribes  int     `json:"unsubscribes"`
+	Sent          int     `json:"sent"`
+	Rate          float64 `json:"rate"`
+	MedianSeconds int64   `json:"median_seconds"`
+	Timing        []struct {
+		After string `json:"after"`
+		Count int    `json:"count"`
+	} `json:"timing"`
+	Reasons []struct {
+		Reason string `json:"reason"`
+		Count  int    `json:"count"`
+	} `json:"reasons"`
+}
+
+// handleGetCampaignUnsubStats handles retrieval of the unsubscription
+// stats of a campaign.
+func handleGetCampaignUnsubStats(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		out   types.JSONText
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetCampaignUnsubStats.Get(&out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
+		}
+
+		getLogger(c).Errorf("error fetching campaign unsubscribe stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// campFunnel represents the funnel of a campaign from the subscribers it
+// was queued for to the ones who converted. Conversions is the total of
+// the conversions, which a subscriber may have more than one of.
+type campFunnel struct {
+	Queued      int `db:"queued" json:"-"`
+	Delivered   int `db:"delivered" json:"-"`
+	Opened      int `db:"opened" json:"-"`
+	Clicked     int `db:"clicked" json:"-"`
+	Converted   int `db:"converted" json:"-"`
+	Conversions int `db:"conversions" json:"conversions"`
+
+	Stages []campFunnelStage `db:"-" json:"stages"`
+}
+
+// campFunnelStage represents a stage of a campaign's funnel. Rate is the
+// percentage of the previous stage and overall, of the first stage.
+type campFunnelStage struct {
+	Stage   string  `json:"stage"`
+	Count   int     `json:"count"`
+	Rate    float64 `json:"rate"`
+	Overall float64 `json:"overall"`
+}
+
+// handleGetCampaignFunnel handles retrieval of the unique subscribers at
+// each stage of a campaign's funnel: queued, delivered, opened, clicked,
+// and converted.
+func handleGetCampaignFunnel(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		out   campFunnel
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetCampaignFunnel.Get(&out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
+		}
+
+		getLogger(c).Errorf("error fetching campaign funnel: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+
+	stages := []struct {
+		name  string
+		count int
+	}{
+		{"queued", out.Queued},
+		{"delivered", out.Delivered},
+		{"opened", out.Opened},
+		{"clicked", out.Clicked},
+		{"converted", out.Converted},
+	}
+	out.Stages = make([]campFunnelStage, 0, len(stages))
+	for i, s := range stages {
+		st := campFunnelStage{Stage: s.name, Count: s.count, Rate: 100, Overall: 100}
+		if i > 0 {
+			st.Rate = percent(s.count, stages[i-1].count)
+			st.Overall = percent(s.count, stages[0].count)
+		}
+		out.Stages = append(out.Stages, st)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetSubscriberViewStats handles retrieval of the opens of campaigns
+// by a subscriber. Opens are recorded per subscriber only with individual
+// tracking.
+func handleGetSubscriberViewStats(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		out   []subViewStats
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetSubscriberViewStats.Select(&out, id); err != nil {
+		getLogger(c).Errorf("error fetching subscriber view stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching subscriber stats: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// subEngagement represents the lifetime engagement of a subscriber. Rates
+// are the percentages of the campaigns received that were opened and clicked.
+type subEngagement struct {
+	SubscriberID      int       `db:"subscriber_id" json:"subscriber_id"`
+	CampaignsReceived int       `db:"campaigns_received" json:"campaigns_received"`
+	CampaignsOpened   int       `db:"campaigns_opened" json:"campaigns_opened"`
+	CampaignsClicked  int       `db:"campaigns_clicked" json:"campaigns_clicked"`
+	Opens             int       `db:"opens" json:"opens"`
+	Clicks            int       `db:"clicks" json:"clicks"`
+	OpenRate          float64   `db:"-" json:"open_rate"`
+	ClickRate         float64   `db:"-" json:"click_rate"`
+	LastOpenAt        null.Time `db:"last_open_at" json:"last_open_at"`
+	LastClickAt       null.Time `db:"last_click_at" json:"last_click_at"`
+	LastActivityAt    null.Time `db:"-" json:"last_activity_at"`
+}
+
+// handleGetSubscriberEngagement handles retrieval of the lifetime engagement
+// of a subscriber.
+func handleGetSubscriberEngagement(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		out   subEngagement
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetSubscriberEngagement.Get(&out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Subscriber not found.")
+		}
+
+		getLogger(c).Errorf("error fetching subscriber engagement: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching subscriber stats: %s", pqErrMsg(err)))
+	}
+
+	out.OpenRate = percent(out.CampaignsOpened, out.CampaignsReceived)
+	out.ClickRate = percent(out.CampaignsClicked, out.CampaignsReceived)
+
+	out.LastActivityAt = out.LastOpenAt
+	if out.LastClickAt.Time.After(out.LastActivityAt.Time) {
+		out.LastActivityAt = out.LastClickAt
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// Maximum number of campaigns that can be compared by ID.
+const maxCompareCampaigns = 50
+
+// campComparison represents the metrics of a campaign compared with other
+// campaigns. Rates are percentages of the messages delivered, except the
+// delivery and bounce rates that are of the messages sent.
+type campComparison struct {
+	ID           int       `db:"id" json:"id"`
+	Name         string    `db:"name" json:"name"`
+	Status       string    `db:"status" json:"status"`
+	StartedAt    null.Time `db:"started_at" json:"started_at"`
+	Sent         int       `db:"sent" json:"sent"`
+	Delivered    int       `db:"delivered" json:"delivered"`
+	Opens        int       `db:"opens" json:"opens"`
+	Clicks       int       `db:"clicks" json:"clicks"`
+	Unsubscribes int       `db:"unsubscribes" json:"unsubscribes"`
+	Bounces      int       `db:"bounces" json:"bounces"`
+
+	DeliveryRate    float64 `db:"-" json:"delivery_rate"`
+	OpenRate        float64 `db:"-" json:"open_rate"`
+	ClickRate       float64 `db:"-" json:"click_rate"`
+	UnsubscribeRate float64 `db:"-" json:"unsubscribe_rate"`
+	BounceRate      float64 `db:"-" json:"bounce_rate"`
+}
+
+// campComparisonResp is the comparison of campaigns. Series has the rates
+// of each metric in the order of the campaigns for charting them.
+type campComparisonResp struct {
+	Campaigns []campComparison     `json:"campaigns"`
+	Series    map[string][]float64 `json:"series"`
+}
+
+// handleGetCampaignComparison handles the comparison of the metrics of the
+// campaigns with the given `?id=` params, or of the campaigns started in
+// the `?from=` and `?to=` (YYYY-MM-DD) date range, which defaults to the
+// last 90 days.
+func handleGetCampaignComparison(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		out []campComparison
+	)
+
+	ids, err := parseStringIDs(c.QueryParams()["id"])
+	if err != nil {
+		return newFieldError("id", "Invalid ID.")
+	}
+	if len(ids) > maxCompareCampaigns {
+		return newFieldError("id", fmt.Sprintf("Up to %d campaigns can be compared.", maxCompareCampaigns))
+	}
+
+	var (
+		now  = time.Now()
+		to   = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+		from = to.AddDate(0, 0, -90)
+	)
+	if s := c.QueryParam("from"); s != "" {
+		if from, err = time.ParseInLocation("2006-01-02", s, now.Location()); err != nil {
+			return newFieldError("from", "Invalid date. Use YYYY-MM-DD.")
+		}
+	}
+	if s := c.QueryParam("to"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, now.Location())
+		if err != nil {
+			return newFieldError("to", "Invalid date. Use YYYY-MM-DD.")
+		}
+		// The end date is inclusive.
+		to = t.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return newFieldError("from", "The from date should be before the to date.")
+	}
+
+	// Opens and clicks are only counted from the raw events that haven't
+	// been pruned.
+	if since := trackingSince(app); len(ids) == 0 && from.Before(since) {
+		return newFieldError("from",
+			fmt.Sprintf("Tracking data before %s has been pruned. Use a later from date.", since.Format(dateFormat)))
+	}
+
+	if err := app.queries.GetCampaignComparison.Select(&out, pq.Array(ids), from, to); err != nil {
+		getLogger(c).Errorf("error fetching campaign comparison: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+
+	resp := campComparisonResp{
+		Campaigns: make([]campComparison, 0, len(out)),
+		Series: map[string][]float64{
+			"delivery_rate":    {},
+			"open_rate":        {},
+			"click_rate":       {},
+			"unsubscribe_rate": {},
+			"bounce_rate":      {},
+		},
+	}
+	for _, m := range out {
+		m.DeliveryRate = percent(m.Delivered, m.Sent)
+		m.BounceRate = percent(m.Bounces, m.Sent)
+		m.OpenRate = percent(m.Opens, m.Delivered)
+		m.ClickRate = percent(m.Clicks, m.Delivered)
+		m.UnsubscribeRate = percent(m.Unsubscribes, m.Delivered)
+
+		resp.Campaigns = append(resp.Campaigns, m)
+		resp.Series["delivery_rate"] = append(resp.Series["delivery_rate"], m.DeliveryRate)
+		resp.Series["open_rate"] = append(resp.Series["open_rate"], m.OpenRate)
+		resp.Series["click_rate"] = append(resp.Series["click_rate"], m.ClickRate)
+		resp.Series["unsubscribe_rate"] = append(resp.Series["unsubscribe_rate"], m.UnsubscribeRate)
+		resp.Series["bounce_rate"] = append(resp.Series["bounce_rate"], m.BounceRate)
+	}
+
+	return c.JSON(http.StatusOK, okResp{resp})
+}
+
+// percent returns n as a percentage of total rounded to two decimals.
+func percent(n, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return math.Round(float64(n)*10000/float64(total)) / 100
+}
+
+// domainStats represents the deliverability events of a recipient domain
+// in a time bucket, or in total if there's no timestamp.
+type domainStats struct {
+	Domain      string    `db:"domain" json:"domain"`
+	Timestamp   null.Time `db:"timestamp" json:"timestamp"`
+	Bounces     int       `db:"bounces" json:"bounces"`
+	HardBounces int       `db:"hard_bounces" json:"hard_bounces"`
+	Deferrals   int       `db:"deferrals" json:"deferrals"`
+	Complaints  int       `db:"complaints" json:"complaints"`
+}
+
+// handleGetDomainStats handles retrieval of the bounces, deferrals, and
+// complaints by recipient domain of a campaign, or of all messages on the
+// route without a campaign ID. Events between the `?from=` and `?to=` dates
+// (default: the last 30 days) are totalled per domain, or bucketed by
+// `?interval=` to chart them over time.
+func handleGetDomainStats(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		interval = c.QueryParam("interval")
+		out      []domainStats
+	)
+
+	id := 0
+	if c.Param("id") != "" {
+		if id, _ = strconv.Atoi(c.Param("id")); id < 1 {
+			return newFieldError("id", "Invalid ID.")
+		}
+	}
+	if interval != "" && !statsIntervals[interval] {
+		return newFieldError("interval", "Invalid interval. Use hour, day, week, or month.")
+	}
+
+	var (
+		to   = time.Now()
+		from = to.AddDate(0, 0, -30)
+		err  error
+	)
+	if s := c.QueryParam("from"); s != "" {
+		if from, err = time.Parse(dateFormat, s); err != nil {
+			return newFieldError("from", "Invalid date. Use YYYY-MM-DD.")
+		}
+	}
+	if s := c.QueryParam("to"); s != "" {
+		t, err := time.Parse(dateFormat, s)
+		if err != nil {
+			return newFieldError("to", "Invalid date. Use YYYY-MM-DD.")
+		}
+		// The end date is inclusive.
+		to = t.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return newFieldError("from", "The from date should be before the to date.")
+	}
+
+	if err := app.queries.GetDomainDeliverability.Select(&out, id, from, to, interval); err != nil {
+		getLogger(c).Errorf("error fetching domain deliverability stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching deliverability stats: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
-	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -35,9 +33,17 @@ type campaignReq struct {
 	// to the outside world.
 	ListIDs pq.Int64Array `db:"-" json:"lists"`
 
+	// IDs of list groups whose lists are added to the campaign's
+	// target lists during creation and updation.
+	ListGroupIDs pq.Int64Array `db:"-" json:"list_groups"`
+
 	// This is only relevant to campaign test requests.
 	SubscriberEmails pq.StringArray `json:"subscribers"`
 
+	// Allows starting or scheduling a campaign that violates the
+	// send frequency rules of its lists.
+	OverrideFrequency bool `db:"-" json:"override_frequency"`
+
 	Type string `json:"type"`
 }
 
@@ -87,53 +93,19 @@ func handleGetCampaigns(c echo.Context) error {
 	if id > 0 {
 		single = true
 	}
-	if query != "" {
-		query = `%` +
-			string(regexFullTextQuery.ReplaceAll([]byte(query), []byte("&"))) + `%`
-	}
 
-	// Sort params.
-	if !strSliceContains(orderBy, campaignQuerySortFields) {
-		orderBy = "created_at"
-	}
-	if order != sortAsc && order != sortDesc {
-		order = sortDesc
-	}
-
-	stmt := fmt.Sprintf(app.queries.QueryCampaigns, orderBy, order)
-
-	// Unsafe to ignore scanning fields not present in models.Campaigns.
-	if err := db.Select(&out.Results, stmt, id, pq.StringArray(status), query, pg.Offset, pg.Limit); err != nil {
-		app.log.Printf("error fetching campaigns: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			fmt.Sprintf("Error fetching campaigns: %s", pqErrMsg(err)))
+	res, err := queryCampaigns(id, status, query, orderBy, order, noBody, pg, app)
+	if err != nil {
+		return err
 	}
-	if single && len(out.Results) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Campaign not found.")
+	if single && len(res) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
 	}
+	out.Results = res
 	if len(out.Results) == 0 {
-		out.Results = []models.Campaign{}
 		return c.JSON(http.StatusOK, okResp{out})
 	}
 
-	for i := 0; i < len(out.Results); i++ {
-		// Replace null tags.
-		if out.Results[i].Tags == nil {
-			out.Results[i].Tags = make(pq.StringArray, 0)
-		}
-
-		if noBody {
-			out.Results[i].Body = ""
-		}
-	}
-
-	// Lazy load stats.
-	if err := out.Results.LoadStats(app.queries.GetCampaignStats); err != nil {
-		app.log.Printf("error fetching campaign stats: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			fmt.Sprintf("Error fetching campaign stats: %v", pqErrMsg(err)))
-	}
-
 	if single {
 		return c.JSON(http.StatusOK, okResp{out.Results[0]})
 	}
@@ -157,28 +129,34 @@ func handlePreviewCampaign(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	err := app.queries.GetCampaignForPreview.Get(camp, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "Campaign not found.")
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
 		}
 
-		app.log.Printf("error fetching campaign: %v", err)
+		getLogger(c).Errorf("error fetching campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching campaign: %s", pqErrMsg(err)))
 	}
 
-	var sub models.Subscriber
-	// Get a random subscriber from the campaign.
-	if err := app.queries.GetOneCampaignSubscriber.Get(&sub, camp.ID); err != nil {
+	// Use the requested subscriber, or a random one from the campaign.
+	sub, ok, err := getPreviewSubscriber(c, app)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		err = app.queries.GetOneCampaignSubscriber.Get(&sub, camp.ID)
+	}
+	if err != nil {
 		if err == sql.ErrNoRows {
 			// There's no subscriber. Mock one.
 			sub = dummySubscriber
 		} else {
-			app.log.Printf("error fetching subscriber: %v", err)
+			getLogger(c).Errorf("error fetching subscriber: %v", err)
 			return echo.NewHTTPError(http.StatusInternalServerError,
 				fmt.Sprintf("Error fetching subscriber: %s", pqErrMsg(err)))
 		}
@@ -186,20 +164,24 @@ func handlePreviewCampaign(c echo.Context) error {
 
 	// Compile the template.
 	if body != "" {
-		camp.Body = body
+		b, err := compileCampaignBody(camp.ContentType, body, app)
+		if err != nil {
+			return newFieldError("body", err.Error())
+		}
+		camp.Body = b
 	}
 
 	if err := camp.CompileTemplate(app.manager.TemplateFuncs(camp)); err != nil {
-		app.log.Printf("error compiling template: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
+		getLogger(c).Errorf("error compiling template: %v", err)
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			fmt.Sprintf("Error compiling template: %v", err))
 	}
 
 	// Render the message body.
 	m := app.manager.NewCampaignMessage(camp, sub)
 	if err := m.Render(); err != nil {
-		app.log.Printf("error rendering message: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
+		getLogger(c).Errorf("error rendering message: %v", err)
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			fmt.Sprintf("Error rendering message: %v", err))
 	}
 
@@ -214,10 +196,19 @@ func handleCreateCampaign(c echo.Context) error {
 		o   campaignReq
 	)
 
+	// Opens are tracked unless disabled.
+	o.TrackOpens = true
 	if err := c.Bind(&o); err != nil {
 		return err
 	}
 
+	// Expand list groups into their lists.
+	listIDs, err := getListIDsByGroups(o.ListIDs, o.ListGroupIDs, app)
+	if err != nil {
+		return err
+	}
+	o.ListIDs = listIDs
+
 	// If the campaign's 'opt-in', prepare a default message.
 	if o.Type == models.CampaignTypeOptin {
 		op, err := makeOptinCampaignMessage(o, app)
@@ -229,14 +220,14 @@ func handleCreateCampaign(c echo.Context) error {
 
 	// Validate.
 	if c, err := validateCampaignFields(o, app); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return newValidationError(err)
 	} else {
 		o = c
 	}
 
 	uu, err := uuid.NewV4()
 	if err != nil {
-		app.log.Printf("error generating UUID: %v", err)
+		getLogger(c).Errorf("error generating UUID: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Error generating UUID")
 	}
 
@@ -255,13 +246,17 @@ func handleCreateCampaign(c echo.Context) error {
 		o.Messenger,
 		o.TemplateID,
 		o.ListIDs,
+		o.ReplyTo,
+		o.BodySource,
+		o.TemplateVars,
+		o.TrackOpens,
 	); err != nil {
 		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 				"There aren't any subscribers in the target lists to create the campaign.")
 		}
 
-		app.log.Printf("error creating campaign: %v", err)
+		getLogger(c).Errorf("error creating campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error creating campaign: %v", pqErrMsg(err)))
 	}
@@ -281,38 +276,46 @@ func handleUpdateCampaign(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	var cm models.Campaign
 	if err := app.queries.GetCampaign.Get(&cm, id, nil); err != nil {
 		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "Campaign not found.")
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
 		}
 
-		app.log.Printf("error fetching campaign: %v", err)
+		getLogger(c).Errorf("error fetching campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching campaign: %s", pqErrMsg(err)))
 	}
 
 	if isCampaignalMutable(cm.Status) {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newHTTPError(http.StatusBadRequest, errCodeConflict,
 			"Cannot update a running or a finished campaign.")
 	}
 
 	// Incoming params.
 	var o campaignReq
+	o.TrackOpens = cm.TrackOpens
 	if err := c.Bind(&o); err != nil {
 		return err
 	}
 
+	// Expand list groups into their lists.
+	listIDs, err := getListIDsByGroups(o.ListIDs, o.ListGroupIDs, app)
+	if err != nil {
+		return err
+	}
+	o.ListIDs = listIDs
+
 	if c, err := validateCampaignFields(o, app); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return newValidationError(err)
 	} else {
 		o = c
 	}
 
-	_, err := app.queries.UpdateCampaign.Exec(cm.ID,
+	_, err = app.queries.UpdateCampaign.Exec(cm.ID,
 		o.Name,
 		o.Subject,
 		o.FromEmail,
@@ -323,9 +326,13 @@ func handleUpdateCampaign(c echo.Context) error {
 		pq.StringArray(normalizeTags(o.Tags)),
 		o.Messenger,
 		o.TemplateID,
-		o.ListIDs)
+		o.ListIDs,
+		o.ReplyTo,
+		o.BodySource,
+		o.TemplateVars,
+		o.TrackOpens)
 	if err != nil {
-		app.log.Printf("error updating campaign: %v", err)
+		getLogger(c).Errorf("error updating campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error updating campaign: %s", pqErrMsg(err)))
 	}
@@ -341,16 +348,16 @@ func handleUpdateCampaignStatus(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	var cm models.Campaign
 	if err := app.queries.GetCampaign.Get(&cm, id, nil); err != nil {
 		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "Campaign not found.")
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
 		}
 
-		app.log.Printf("error fetching campaign: %v", err)
+		getLogger(c).Errorf("error fetching campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching campaign: %s", pqErrMsg(err)))
 	}
@@ -390,23 +397,70 @@ func handleUpdateCampaignStatus(c echo.Context) error {
 	}
 
 	if len(errMsg) > 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+		return newHTTPError(http.StatusBadRequest, errCodeConflict, errMsg)
+	}
+
+	// Enforce the send frequency rules of the campaign's lists
+	// unless explicitly overridden.
+	if (o.Status == models.CampaignStatusRunning && cm.Status == models.CampaignStatusDraft) ||
+		o.Status == models.CampaignStatusScheduled {
+		if !o.OverrideFrequency {
+			sendAt := time.Now()
+			if o.Status == models.CampaignStatusScheduled {
+				sendAt = cm.SendAt.Time
+			}
+			if err := checkCampaignFrequency(cm.ID, sendAt, app); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Check the template variables before the campaign is sent.
+	if o.Status == models.CampaignStatusRunning || o.Status == models.CampaignStatusScheduled {
+		if _, err := cm.TemplateVariables.Resolve(cm.TemplateVars); err != nil {
+			return newFieldError("template_vars",
+				fmt.Sprintf("Error in template variables: %v", err))
+		}
 	}
 
 	res, err := app.queries.UpdateCampaignStatus.Exec(cm.ID, o.Status)
 	if err != nil {
-		app.log.Printf("error updating campaign status: %v", err)
+		getLogger(c).Errorf("error updating campaign status: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error updating campaign status: %s", pqErrMsg(err)))
 	}
 
 	if n, _ := res.RowsAffected(); n == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Campaign not found.")
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
 	}
+	setAuditMeta(c, "name", cm.Name)
+	setAuditMeta(c, "status", o.Status)
 
 	return handleGetCampaigns(c)
 }
 
+// checkCampaignFrequency checks whether sending a campaign at the given time
+// violates the send frequency rule of any of its lists.
+func checkCampaignFrequency(campID int, sendAt time.Time, app *App) error {
+	var lists []models.List
+	if err := app.queries.GetFrequencyViolations.Select(&lists, campID, sendAt); err != nil {
+		app.log.Errorf("error checking list send frequency: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error checking list send frequency: %s", pqErrMsg(err)))
+	}
+	if len(lists) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(lists))
+	for _, l := range lists {
+		names = append(names, fmt.Sprintf("%s (%d in %d days)", l.Name, l.MaxCampaigns, l.MaxCampaignsDays))
+	}
+	return newHTTPError(http.StatusBadRequest, errCodeConflict,
+		fmt.Sprintf("Campaign exceeds the send frequency of the lists: %s. Set `override_frequency` to send anyway.",
+			strings.Join(names, ", ")))
+}
+
 // handleDeleteCampaign handles campaign deletion.
 // Only scheduled campaigns that have not started yet can be deleted.
 func handleDeleteCampaign(c echo.Context) error {
@@ -416,22 +470,22 @@ func handleDeleteCampaign(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	var cm models.Campaign
 	if err := app.queries.GetCampaign.Get(&cm, id, nil); err != nil {
 		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "Campaign not found.")
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
 		}
 
-		app.log.Printf("error fetching campaign: %v", err)
+		getLogger(c).Errorf("error fetching campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching campaign: %s", pqErrMsg(err)))
 	}
 
 	if _, err := app.queries.DeleteCampaign.Exec(cm.ID); err != nil {
-		app.log.Printf("error deleting campaign: %v", err)
+		getLogger(c).Errorf("error deleting campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error deleting campaign: %v", pqErrMsg(err)))
 	}
@@ -441,41 +495,60 @@ func handleDeleteCampaign(c echo.Context) error {
 
 // handleGetRunningCampaignStats returns stats of a given set of campaign IDs.
 func handleGetRunningCampaignStats(c echo.Context) error {
-	var (
-		app = c.Get("app").(*App)
-		out []campaignStats
-	)
+	app := c.Get("app").(*App)
+
+	out, err := getRunningCampaignStats(app)
+	if err != nil {
+		return err
+	} else if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
 
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// getRunningCampaignStats returns the stats of running campaigns with
+// their sending rates.
+func getRunningCampaignStats(app *App) ([]campaignStats, error) {
+	var out []campaignStats
 	if err := app.queries.GetCampaignStatus.Select(&out, models.CampaignStatusRunning); err != nil {
 		if err == sql.ErrNoRows {
-			return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+			return nil, nil
 		}
 
-		app.log.Printf("error fetching campaign stats: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
+		app.log.Errorf("error fetching campaign stats: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
-	} else if len(out) == 0 {
-		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
 	}
 
 	// Compute rate.
 	for i, c := range out {
-		if c.Started.Valid && c.UpdatedAt.Valid {
-			diff := c.UpdatedAt.Time.Sub(c.Started.Time).Minutes()
-			if diff > 0 {
-				var (
-					sent = float64(c.Sent)
-					rate = sent / diff
-				)
-				if rate > sent || rate > float64(c.ToSend) {
-					rate = sent
-				}
-				out[i].Rate = rate
-			}
-		}
+		out[i].Rate = getCampaignRate(c)
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
+	return out, nil
+}
+
+// getCampaignRate returns the average number of messages sent per minute
+// by a campaign since it started.
+func getCampaignRate(c campaignStats) float64 {
+	if !c.Started.Valid || !c.UpdatedAt.Valid {
+		return 0
+	}
+
+	diff := c.UpdatedAt.Time.Sub(c.Started.Time).Minutes()
+	if diff <= 0 {
+		return 0
+	}
+
+	var (
+		sent = float64(c.Sent)
+		rate = sent / diff
+	)
+	if rate > sent || rate > float64(c.ToSend) {
+		rate = sent
+	}
+	return rate
 }
 
 // handleTestCampaign handles the sending of a campaign message to
@@ -488,7 +561,7 @@ func handleTestCampaign(c echo.Context) error {
 	)
 
 	if campID < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid campaign ID.")
+		return newFieldError("id", "Invalid campaign ID.")
 	}
 
 	// Get and validate fields.
@@ -498,12 +571,12 @@ func handleTestCampaign(c echo.Context) error {
 
 	// Validate.
 	if c, err := validateCampaignFields(req, app); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return newValidationError(err)
 	} else {
 		req = c
 	}
 	if len(req.SubscriberEmails) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "No subscribers to target.")
+		return newFieldError("subscribers", "No subscribers to target.")
 	}
 
 	// Get the subscribers.
@@ -512,21 +585,21 @@ func handleTestCampaign(c echo.Context) error {
 	}
 	var subs models.Subscribers
 	if err := app.queries.GetSubscribersByEmails.Select(&subs, req.SubscriberEmails); err != nil {
-		app.log.Printf("error fetching subscribers: %v", err)
+		getLogger(c).Errorf("error fetching subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching subscribers: %s", pqErrMsg(err)))
 	} else if len(subs) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "No known subscribers given.")
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "No known subscribers given.")
 	}
 
 	// The campaign.
 	var camp models.Campaign
 	if err := app.queries.GetCampaignForPreview.Get(&camp, campID); err != nil {
 		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "Campaign not found.")
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign not found.")
 		}
 
-		app.log.Printf("error fetching campaign: %v", err)
+		getLogger(c).Errorf("error fetching campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching campaign: %s", pqErrMsg(err)))
 	}
@@ -539,12 +612,18 @@ func handleTestCampaign(c echo.Context) error {
 	camp.Messenger = req.Messenger
 	camp.ContentType = req.ContentType
 	camp.TemplateID = req.TemplateID
+	camp.TemplateVars = req.TemplateVars
+	body, err := compileCampaignBody(camp.ContentType, camp.Body, app)
+	if err != nil {
+		return newFieldError("body", err.Error())
+	}
+	camp.Body = body
 
 	// Send the test messages.
 	for _, s := range subs {
 		sub := s
 		if err := sendTestMessage(sub, &camp, app); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 				fmt.Sprintf("Error sending test: %v", err))
 		}
 	}
@@ -555,15 +634,15 @@ func handleTestCampaign(c echo.Context) error {
 // sendTestMessage takes a campaign and a subsriber and sends out a sample campaign message.
 func sendTestMessage(sub models.Subscriber, camp *models.Campaign, app *App) error {
 	if err := camp.CompileTemplate(app.manager.TemplateFuncs(camp)); err != nil {
-		app.log.Printf("error compiling template: %v", err)
+		app.log.Errorf("error compiling template: %v", err)
 		return fmt.Errorf("Error compiling template: %v", err)
 	}
 
 	// Render the message body.
 	m := app.manager.NewCampaignMessage(camp, sub)
 	if err := m.Render(); err != nil {
-		app.log.Printf("error rendering message: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
+		app.log.Errorf("error rendering message: %v", err)
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			fmt.Sprintf("Error rendering message: %v", err))
 	}
 
@@ -580,19 +659,48 @@ func sendTestMessage(sub models.Subscriber, camp *models.Campaign, app *App) err
 
 // validateCampaignFields validates incoming campaign field values.
 func validateCampaignFields(c campaignReq, app *App) (campaignReq, error) {
+	// Inherit the sender identity of the target lists if it's not overridden.
+	if c.FromEmail == "" || c.ReplyTo == "" {
+		from, replyTo, err := getListsSender(c.ListIDs, app)
+		if err != nil {
+			return c, err
+		}
+		if c.FromEmail == "" {
+			c.FromEmail = from
+		}
+		if c.ReplyTo == "" {
+			c.ReplyTo = replyTo
+		}
+	}
+
 	if c.FromEmail == "" {
 		c.FromEmail = app.constants.FromEmail
 	} else if !regexFromAddress.Match([]byte(c.FromEmail)) {
 		if !subimporter.IsEmail(c.FromEmail) {
-			return c, errors.New("invalid `from_email`")
+			return c, fieldError{Field: "from_email", Message: "invalid `from_email`"}
 		}
 	}
+	if c.ReplyTo != "" && !subimporter.IsEmail(c.ReplyTo) {
+		return c, fieldError{Field: "reply_to", Message: "invalid `reply_to`"}
+	}
 
 	if !strHasLen(c.Name, 1, stdInputMaxLen) {
-		return c, errors.New("invalid length for `name`")
+		return c, fieldError{Field: "name", Message: "invalid length for `name`"}
 	}
 	if !strHasLen(c.Subject, 1, stdInputMaxLen) {
-		return c, errors.New("invalid length for `subject`")
+		return c, fieldError{Field: "subject", Message: "invalid length for `subject`"}
+	}
+
+	// MJML and blocks bodies are compiled to HTML and the source is
+	// retained for editing.
+	if (c.ContentType == models.CampaignContentTypeMJML ||
+		c.ContentType == models.CampaignContentTypeBlocks) && c.Body != "" {
+		body, err := compileCampaignBody(c.ContentType, c.Body, app)
+		if err != nil {
+			return c, err
+		}
+		c.BodySource = c.Body
+		c.Body = body
 	}
 
 	// if !hasLen(c.Body, 1, bodyMaxLen) {
@@ -602,16 +710,37 @@ func validateCampaignFields(c campaignReq, app *App) (campaignReq, error) {
 	// If there's a "send_at" date, it should be in the future.
 	if c.SendAt.Valid {
 		if c.SendAt.Time.Before(time.Now()) {
-			return c, errors.New("`send_at` date should be in the future")
+			return c, fieldError{Field: "send_at", Message: "`send_at` date should be in the future"}
 		}
 	}
 
 	if len(c.ListIDs) == 0 {
-		return c, errors.New("no lists selected")
+		return c, fieldError{Field: "lists", Message: "no lists selected"}
+	}
+
+	// Archived lists can't be targeted.
+	var archived []models.List
+	if err := app.queries.GetArchivedLists.Select(&archived, c.ListIDs); err != nil {
+		return c, fmt.Errorf("error fetching lists: %v", pqErrMsg(err))
+	}
+	if len(archived) > 0 {
+		return c, fieldError{Field: "lists",
+			Message: fmt.Sprintf("archived list `%s` cannot be targeted", archived[0].Name)}
 	}
 
 	if !app.manager.HasMessenger(c.Messenger) {
-		return c, fmt.Errorf("unknown messenger %s", c.Messenger)
+		return c, fieldError{Field: "messenger", Message: fmt.Sprintf("unknown messenger %s", c.Messenger)}
+	}
+
+	// Transactional templates can't be used for campaigns.
+	if c.TemplateID > 0 {
+		var tpls []models.Template
+		if err := app.queries.GetTemplates.Select(&tpls, c.TemplateID, true, "", pq.StringArray{}); err != nil {
+			return c, fmt.Errorf("error fetching template: %v", pqErrMsg(err))
+		}
+		if len(tpls) > 0 && tpls[0].Type == models.TemplateTypeTx {
+			return c, fieldError{Field: "template_id", Message: "transactional templates can't be used for campaigns"}
+		}
 	}
 
 	camp := models.Campaign{Body: c.Body, TemplateBody: tplTag}
@@ -622,6 +751,36 @@ func validateCampaignFields(c campaignReq, app *App) (campaignReq, error) {
 	return c, nil
 }
 
+// getListsSender returns the first default From and Reply-To addresses
+// set on the given lists, in the order of the given list IDs.
+func getListsSender(listIDs []int64, app *App) (string, string, error) {
+	if len(listIDs) == 0 {
+		return "", "", nil
+	}
+
+	var lists []models.List
+	if err := app.queries.GetListsByOptin.Select(&lists, "", pq.Int64Array(listIDs), nil); err != nil {
+		return "", "", fmt.Errorf("error fetching lists: %v", pqErrMsg(err))
+	}
+
+	byID := make(map[int64]models.List, len(lists))
+	for _, l := range lists {
+		byID[int64(l.ID)] = l
+	}
+
+	var from, replyTo string
+	for _, id := range listIDs {
+		l := byID[id]
+		if from == "" {
+			from = l.FromEmail
+		}
+		if replyTo == "" {
+			replyTo = l.ReplyTo
+		}
+	}
+	return from, replyTo, nil
+}
+
 // isCampaignalMutable tells if a campaign's in a state where it's
 // properties can be mutated.
 func isCampaignalMutable(status string) bool {
@@ -633,21 +792,21 @@ func isCampaignalMutable(status string) bool {
 // makeOptinCampaignMessage makes a default opt-in campaign message body.
 func makeOptinCampaignMessage(o campaignReq, app *App) (campaignReq, error) {
 	if len(o.ListIDs) == 0 {
-		return o, echo.NewHTTPError(http.StatusBadRequest, "Invalid list IDs.")
+		return o, newFieldError("lists", "Invalid list IDs.")
 	}
 
 	// Fetch double opt-in lists from the given list IDs.
 	var lists []models.List
 	err := app.queries.GetListsByOptin.Select(&lists, models.ListOptinDouble, pq.Int64Array(o.ListIDs), nil)
 	if err != nil {
-		app.log.Printf("error fetching lists for opt-in: %s", pqErrMsg(err))
+		app.log.Errorf("error fetching lists for opt-in: %s", pqErrMsg(err))
 		return o, echo.NewHTTPError(http.StatusInternalServerError,
 			"Error fetching opt-in lists.")
 	}
 
 	// No opt-in lists.
 	if len(lists) == 0 {
-		return o, echo.NewHTTPError(http.StatusBadRequest,
+		return o, newHTTPError(http.StatusBadRequest, errCodeNotFound,
 			"No opt-in lists found to create campaign.")
 	}
 
@@ -660,16 +819,67 @@ func makeOptinCampaignMessage(o campaignReq, app *App) (campaignReq, error) {
 	optinURLAttr := template.HTMLAttr(fmt.Sprintf(`href="{{ OptinURL }}%s"`, listIDs.Encode()))
 
 	// Prepare sample opt-in message for the campaign.
-	var b bytes.Buffer
-	if err := app.notifTpls.ExecuteTemplate(&b, "optin-campaign", struct {
+	_, b, err := renderSystemTpl(notifOptinCampaign, "", struct {
 		Lists        []models.List
 		OptinURLAttr template.HTMLAttr
-	}{lists, optinURLAttr}); err != nil {
-		app.log.Printf("error compiling 'optin-campaign' template: %v", err)
+	}{lists, optinURLAttr}, app)
+	if err != nil {
 		return o, echo.NewHTTPError(http.StatusInternalServerError,
 			"Error compiling opt-in campaign template.")
 	}
 
-	o.Body = b.String()
+	o.Body = string(b)
 	return o, nil
 }
+
+// queryCampaigns returns a page of campaigns, optionally filtered by ID,
+// status, and a name/subject search query, with their stats.
+func queryCampaigns(id int, status []string, query, orderBy, order string, noBody bool,
+	pg pagination, app *App) (models.Campaigns, error) {
+	var out models.Campaigns
+
+	if query != "" {
+		query = `%` +
+			string(regexFullTextQuery.ReplaceAll([]byte(query), []byte("&"))) + `%`
+	}
+
+	// Sort params.
+	if !strSliceContains(orderBy, campaignQuerySortFields) {
+		orderBy = "created_at"
+	}
+	if order != sortAsc && order != sortDesc {
+		order = sortDesc
+	}
+
+	stmt := fmt.Sprintf(app.queries.QueryCampaigns, orderBy, order)
+
+	// Unsafe to ignore scanning fields not present in models.Campaigns.
+	if err := db.Select(&out, stmt, id, pq.StringArray(status), query, pg.Offset, pg.Limit); err != nil {
+		app.log.Errorf("error fetching campaigns: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaigns: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return models.Campaigns{}, nil
+	}
+
+	for i := 0; i < len(out); i++ {
+		// Replace null tags.
+		if out[i].Tags == nil {
+			out[i].Tags = make(pq.StringArray, 0)
+		}
+
+		if noBody {
+			out[i].Body = ""
+		}
+	}
+
+	// Lazy load stats.
+	if err := out.LoadStats(app.queries.GetCampaignStats); err != nil {
+		app.log.Errorf("error fetching campaign stats: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %v", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/labstack/echo"
+)
+
+// conversionReq represents a conversion attributed to a campaign that's
+// reported by an external system, eg: a shop's order webhook. The
+// subscriber is identified by the UUID, or the e-mail if there's no UUID,
+// and is optional.
+type conversionReq struct {
+	CampaignUUID   string          `json:"campaign_uuid"`
+	SubscriberUUID string          `json:"subscriber_uuid"`
+	Email          string          `json:"email"`
+	Meta           json.RawMessage `json:"meta"`
+}
+
+// handleRecordConversion handles the recording of a conversion attributed
+// to a campaign for the campaign's funnel.
+func handleRecordConversion(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req conversionReq
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if !reUUID.MatchString(req.CampaignUUID) {
+		return newFieldError("campaign_uuid", "Invalid campaign UUID.")
+	}
+	if req.SubscriberUUID != "" && !reUUID.MatchString(req.SubscriberUUID) {
+		return newFieldError("subscriber_uuid", "Invalid subscriber UUID.")
+	}
+	if req.SubscriberUUID == "" && req.Email != "" && !subimporter.IsEmail(req.Email) {
+		return newFieldError("email", "Invalid e-mail.")
+	}
+	if len(req.Meta) == 0 {
+		req.Meta = json.RawMessage("{}")
+	}
+
+	var id int64
+	if err := app.queries.RecordConversion.Get(&id, req.CampaignUUID, req.SubscriberUUID,
+		req.Email, req.Meta); err != nil {
+		if err == sql.ErrNoRows {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Campaign or subscriber not found.")
+		}
+
+		getLogger(c).Errorf("error recording conversion: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			"Error recording conversion: "+pqErrMsg(err))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
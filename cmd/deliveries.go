@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+)
+
+// Interval at which old entries are deleted from the delivery log.
+const deliveryLogPruneInterval = time.Hour
+
+type deliveryLogWrap struct {
+	Results []models.DeliveryLog `json:"results"`
+
+	Total   int `json:"total"`
+	PerPage int `json:"per_page"`
+	Page    int `json:"page"`
+}
+
+// handleGetDeliveryLog handles retrieval of the delivery log of outgoing
+// messages.
+func handleGetDeliveryLog(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		pg        = getPagination(c.QueryParams(), 20, 50)
+		out       deliveryLogWrap
+		campID, _ = strconv.Atoi(c.FormValue("campaign_id"))
+		subID, _  = strconv.Atoi(c.FormValue("subscriber_id"))
+		st        = c.FormValue("status")
+		email     = strings.TrimSpace(c.FormValue("email"))
+	)
+
+	switch st {
+	case "", models.DeliveryStatusSent, models.DeliveryStatusFailed:
+	default:
+		return newFieldError("status", "Invalid `status`.")
+	}
+	if email != "" {
+		email = "%" + email + "%"
+	}
+
+	if err := app.queries.QueryDeliveryLog.Select(&out.Results, campID, subID, st, email, pg.Offset, pg.Limit); err != nil {
+		getLogger(c).Errorf("error fetching delivery log: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching delivery log: %s", pqErrMsg(err)))
+	}
+	if len(out.Results) == 0 {
+		out.Results = []models.DeliveryLog{}
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	out.Total = out.Results[0].Total
+	out.Page = pg.Page
+	out.PerPage = pg.PerPage
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// recordDelivery records the outcome of a message push in the transactional
+// message log, if it's a transactional message, and in the delivery log,
+// if it's enabled.
+func recordDelivery(d manager.Delivery, logDelivery bool, q *Queries) {
+	var (
+		txStatus = models.TxStatusSent
+		status   = models.DeliveryStatusSent
+		resp     = ""
+	)
+	if d.Err != nil {
+		txStatus = models.TxStatusFailed
+		status = models.DeliveryStatusFailed
+		resp = d.Err.Error()
+	}
+
+	if d.TxLogID > 0 {
+		if _, err := q.UpdateTxLog.Exec(d.TxLogID, txStatus, d.Attempts, resp); err != nil {
+			lo.Errorf("error updating transactional log: %v", err)
+		}
+	}
+
+	if !logDelivery {
+		return
+	}
+
+	var campID int
+	if d.Message.Campaign != nil {
+		campID = d.Message.Campaign.ID
+	}
+	var queuedAt *time.Time
+	if !d.QueuedAt.IsZero() {
+		queuedAt = &d.QueuedAt
+	}
+	if _, err := q.InsertDeliveryLog.Exec(campID, d.Message.Subscriber.ID,
+		strings.Join(d.Message.To, ", "), d.Messenger, d.Message.Subject,
+		status, d.Attempts, resp, queuedAt); err != nil {
+		lo.Errorf("error recording delivery: %v", err)
+	}
+}
+
+// pruneDeliveryLog is a blocking function that deletes delivery log entries
+// older than the retention period at intervals.
+func pruneDeliveryLog(retention time.Duration, app *App) {
+	ticker := time.NewTicker(deliveryLogPruneInterval)
+	for ; true; <-ticker.C {
+		res, err := app.queries.DeleteDeliveryLog.Exec(time.Now().Add(-retention))
+		if err != nil {
+			app.log.Errorf("error pruning delivery log: %v", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			app.log.Infof("pruned %d old delivery log entries", n)
+		}
+	}
+}
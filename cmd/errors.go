@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// Machine-readable codes of API errors. Errors without an explicit code
+// get one based on their HTTP status.
+const (
+	errCodeInvalid      = "invalid_request"
+	errCodeValidation   = "validation_failed"
+	errCodeNotFound     = "not_found"
+	errCodeExists       = "already_exists"
+	errCodeUnauthorized = "unauthorized"
+	errCodeForbidden    = "forbidden"
+	errCodeConflict     = "conflict"
+	errCodeRateLimited  = "rate_limited"
+	errCodeInternal     = "internal_error"
+
+	errCodeCSRF              = "invalid_csrf_token"
+	errCodeTwoFactorRequired = "two_factor_required"
+	errCodeIdempotencyReused = "idempotency_key_reused"
+	errCodeIdempotencyBusy   = "idempotency_key_in_use"
+	errCodeReadOnly          = "read_only"
+)
+
+// apiError is the body of API error responses. Message is the human
+// readable message and Code is stable for clients to branch on.
+type apiError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"`
+}
+
+func (e apiError) Error() string {
+	return e.Message
+}
+
+// fieldError is the validation error of a field of a request.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e fieldError) Error() string {
+	return e.Message
+}
+
+// newHTTPError returns an HTTP error with an error code.
+func newHTTPError(status int, code, msg string) *echo.HTTPError {
+	return echo.NewHTTPError(status, apiError{Code: code, Message: msg})
+}
+
+// newFieldError returns the HTTP error of an invalid request field.
+func newFieldError(field, msg string) *echo.HTTPError {
+	return newValidationError(fieldError{Field: field, Message: msg})
+}
+
+// newValidationError returns a bad request HTTP error for an error from
+// a validation function. fieldErrors are returned with their field.
+func newValidationError(err error) *echo.HTTPError {
+	if e, ok := err.(fieldError); ok {
+		return echo.NewHTTPError(http.StatusBadRequest, apiError{
+			Code:    errCodeValidation,
+			Message: e.Message,
+			Fields:  []fieldError{e},
+		})
+	}
+	return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+}
+
+// getAPIError returns the HTTP status and the API error of an error
+// returned by a handler.
+func getAPIError(err error) (int, apiError) {
+	he, ok := err.(*echo.HTTPError)
+	if !ok {
+		return http.StatusInternalServerError, apiError{
+			Code:    errCodeInternal,
+			Message: http.StatusText(http.StatusInternalServerError),
+		}
+	}
+
+	switch m := he.Message.(type) {
+	case apiError:
+		return he.Code, m
+	case string:
+		return he.Code, apiError{Code: getErrCode(he.Code), Message: m}
+	default:
+		return he.Code, apiError{Code: getErrCode(he.Code), Message: fmt.Sprintf("%v", m)}
+	}
+}
+
+// getErrCode returns the default error code of an HTTP status.
+func getErrCode(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return errCodeUnauthorized
+	case http.StatusForbidden:
+		return errCodeForbidden
+	case http.StatusNotFound:
+		return errCodeNotFound
+	case http.StatusConflict:
+		return errCodeConflict
+	case http.StatusTooManyRequests:
+		return errCodeRateLimited
+	}
+
+	if status >= http.StatusInternalServerError {
+		return errCodeInternal
+	}
+	return errCodeInvalid
+}
+
+// handleHTTPError is the error handler of the HTTP server that writes
+// the errors of handlers as apiError JSON responses.
+func handleHTTPError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status, e := getAPIError(err)
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, e)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// conditionalGet middleware sets an ETag of the body of successful GET
+// responses and a Last-Modified header from the `updated_at` of single
+// objects, unless the handler has set one. Requests whose If-None-Match
+// or If-Modified-Since preconditions match get a 304 Not Modified
+// response without the body.
+func conditionalGet(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		buf, err := bufferResponse(c, next)
+		if err != nil {
+			return err
+		}
+
+		resp := c.Response()
+		if buf.status != http.StatusOK {
+			return buf.writeTo(resp)
+		}
+
+		var (
+			body = buf.body.Bytes()
+			sum  = sha256.Sum256(body)
+			etag = `W/"` + hex.EncodeToString(sum[:16]) + `"`
+			h    = resp.Header()
+		)
+		h.Set("ETag", etag)
+		if h.Get("Last-Modified") == "" {
+			if t := getUpdatedAt(body); !t.IsZero() {
+				h.Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+			}
+		}
+
+		// Clients should revalidate cached responses before using them.
+		h.Set("Cache-Control", "no-cache")
+
+		if isNotModified(c.Request(), etag, h.Get("Last-Modified")) {
+			resp.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		return buf.writeTo(resp)
+	}
+}
+
+// getUpdatedAt returns the `updated_at` of the object in a JSON response.
+// Lists of objects don't have one as items can be deleted without the
+// timestamps of the remaining ones changing.
+func getUpdatedAt(body []byte) time.Time {
+	var out struct {
+		Data struct {
+			UpdatedAt time.Time `json:"updated_at"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return time.Time{}
+	}
+	return out.Data.UpdatedAt
+}
+
+// isNotModified checks whether the If-None-Match precondition of a request
+// matches the ETag or, if there's none, whether the resource hasn't been
+// modified since If-Modified-Since.
+func isNotModified(r *http.Request, etag, lastMod string) bool {
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if v := r.Header.Get("If-Modified-Since"); v != "" && lastMod != "" {
+		since, err := http.ParseTime(v)
+		if err != nil {
+			return false
+		}
+		mod, err := http.ParseTime(lastMod)
+		if err != nil {
+			return false
+		}
+		return !mod.After(since)
+	}
+
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/events"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+)
+
+const (
+	// Interval at which the progress of running campaigns and imports is
+	// checked for changes when there are event subscribers.
+	eventsPollInterval = time.Second * 2
+
+	// Interval of the keep-alive comments on idle event streams.
+	eventsKeepAlive = time.Second * 15
+)
+
+// Permissions required to receive each type of event. Error events carry
+// log lines and are only streamed to admins.
+var eventPerms = map[string]string{
+	events.TypeCampaignProgress: permCampaignsGet,
+	events.TypeCampaignStatus:   permCampaignsGet,
+	events.TypeImportProgress:   permSubscribersImport,
+	events.TypeSubscription:     permSubscribersGet,
+}
+
+// subscriptionEvent is the data of a subscription event, which is one of
+// the list webhook events.
+type subscriptionEvent struct {
+	Event      string            `json:"event"`
+	Subscriber models.Subscriber `json:"subscriber"`
+	ListIDs    []int64           `json:"list_ids,omitempty"`
+	ListUUIDs  []string          `json:"list_uuids,omitempty"`
+}
+
+// campaignStatusEvent is the data of a campaign status event.
+type campaignStatusEvent struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Sent   int    `json:"sent"`
+	ToSend int    `json:"to_send"`
+	Reason string `json:"reason"`
+}
+
+// logEvent is the data of an error event.
+type logEvent struct {
+	Message string `json:"message"`
+}
+
+// handleEventStream streams admin events as server-sent events. The types
+// of events can be filtered with one or more `type` query params. Users
+// only receive the types of events that their role has permissions for.
+func handleEventStream(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		u     = getSessionUser(c)
+		types = c.QueryParams()["type"]
+	)
+
+	for _, t := range types {
+		if !strSliceContains(t, events.Types) {
+			return newFieldError("type", fmt.Sprintf("Unknown event type: %s", t))
+		}
+		if !canGetEvent(u, t, app) {
+			return echo.NewHTTPError(http.StatusForbidden,
+				fmt.Sprintf("You don't have the permission to get %s events.", t))
+		}
+	}
+
+	// Without a filter, stream all the types that the user can get.
+	if len(types) == 0 {
+		for _, t := range events.Types {
+			if canGetEvent(u, t, app) {
+				types = append(types, t)
+			}
+		}
+		if len(types) == 0 {
+			return echo.NewHTTPError(http.StatusForbidden,
+				"You don't have the permission to get any events.")
+		}
+	}
+
+	ch, unsub := app.events.Subscribe(types)
+	defer unsub()
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentType, "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	c.Response().WriteHeader(http.StatusOK)
+
+	// Ask clients to wait a few seconds before reconnecting.
+	fmt.Fprint(c.Response(), "retry: 5000\n\n")
+	c.Response().Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Response(), ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+
+		case e := <-ch:
+			b, err := json.Marshal(e)
+			if err != nil {
+				getLogger(c).Errorf("error marshalling event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Response(), "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+				return nil
+			}
+		}
+		c.Response().Flush()
+	}
+}
+
+// canGetEvent checks whether a user can receive a type of event.
+func canGetEvent(u models.User, typ string, app *App) bool {
+	if u.Role == models.UserRoleAdmin {
+		return true
+	}
+	perm, ok := eventPerms[typ]
+	return ok && app.rolePerms.has(u.Role, perm)
+}
+
+// watchEvents publishes the progress of running campaigns and imports
+// when it changes. It only queries the progress while there are event
+// subscribers. It blocks and should be invoked as a goroutine.
+func watchEvents(app *App) {
+	var (
+		lastCamps  []campaignStats
+		lastImport subimporter.Status
+	)
+	for range time.Tick(eventsPollInterval) {
+		if app.events.Count() == 0 {
+			lastCamps = nil
+			lastImport = subimporter.Status{}
+			continue
+		}
+
+		if out, err := getRunningCampaignStats(app); err == nil {
+			if len(out) > 0 && !reflect.DeepEqual(out, lastCamps) {
+				app.events.Publish(events.TypeCampaignProgress, out)
+			}
+			lastCamps = out
+		}
+
+		if s := app.importer.GetStats(); s != lastImport {
+			if s.Status != subimporter.StatusNone {
+				app.events.Publish(events.TypeImportProgress, s)
+			}
+			lastImport = s
+		}
+	}
+}
+
+// errorLogWriter is an io.Writer for the app log that publishes error log
+// lines as events.
+type errorLogWriter struct {
+	hub *events.Hub
+}
+
+func (w errorLogWriter) Write(b []byte) (int, error) {
+	if w.hub.Count() == 0 {
+		return len(b), nil
+	}
+
+	line := strings.TrimSpace(string(b))
+
+	// JSON log lines have a level field.
+	if strings.HasPrefix(line, "{") {
+		var l struct {
+			Level string `json:"level"`
+		}
+		if err := json.Unmarshal(b, &l); err == nil && l.Level == "error" {
+			w.hub.Publish(events.TypeError, logEvent{Message: line})
+		}
+		return len(b), nil
+	}
+
+	// Text log lines are of the form: date time file:line: LEVEL message.
+	if p := strings.SplitN(line, " ", 5); len(p) == 5 && p[3] == "ERROR" {
+		w.hub.Publish(events.TypeError, logEvent{Message: line})
+	}
+	return len(b), nil
+}
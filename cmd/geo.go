@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// Options of truncating the IPs recorded with tracking events.
+const (
+	// The full IP is recorded.
+	ipTruncateNone = "none"
+
+	// The last octet of IPv4 and the last 80 bits of IPv6 IPs are zeroed.
+	ipTruncatePartial = "partial"
+
+	// No IP is recorded.
+	ipTruncateFull = "full"
+)
+
+// campGeoStats represents the views and clicks of a campaign from a
+// country or a region. Events whose location is unknown have no country.
+type campGeoStats struct {
+	Country string `db:"country" json:"country"`
+	Region  string `db:"region" json:"region"`
+	Views   int    `db:"views" json:"views"`
+	Clicks  int    `db:"clicks" json:"clicks"`
+}
+
+// getTrackingGeo returns the IP of a tracking request truncated as per the
+// privacy settings, and the country and the region of the IP if a GeoIP
+// DB is configured. The location is looked up with the full IP which is
+// never stored.
+func getTrackingGeo(c echo.Context, app *App) (string, string, string) {
+	ip := net.ParseIP(c.RealIP())
+	if ip == nil {
+		return "", "", ""
+	}
+
+	var country, region string
+	if app.geoip != nil {
+		loc, err := app.geoip.Lookup(ip)
+		if err != nil {
+			getLogger(c).Errorf("error looking up IP location: %v", err)
+		}
+		country, region = loc.Country, loc.Region
+	}
+
+	return truncateIP(ip, app.constants.Privacy.IPTruncation), country, region
+}
+
+// truncateIP truncates an IP as per the given truncation option.
+func truncateIP(ip net.IP, opt string) string {
+	switch opt {
+	case ipTruncateNone:
+		return ip.String()
+	case ipTruncateFull:
+		return ""
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// handleGetCampaignGeoStats handles retrieval of the views and clicks of a
+// campaign by country, or by region with `?by=region`.
+func handleGetCampaignGeoStats(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		by    = c.QueryParam("by")
+		out   []campGeoStats
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+	if by == "" {
+		by = "country"
+	}
+	if by != "country" && by != "region" {
+		return newFieldError("by", "Invalid value for `by`. Use country or region.")
+	}
+
+	if err := app.queries.GetCampaignGeoStats.Select(&out, id, by == "region"); err != nil {
+		getLogger(c).Errorf("error fetching campaign geo stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching campaign stats: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
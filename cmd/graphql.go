@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/graphql"
+	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// linkCount represents the number of clicks on a link in a campaign.
+type linkCount struct {
+	URL   string `db:"url" json:"url"`
+	Count int    `db:"count" json:"count"`
+}
+
+// gqlUserKey is the key of the session user in the context of GraphQL
+// queries.
+type gqlUserKey struct{}
+
+// handleGraphQL handles read-only GraphQL queries over subscribers, lists,
+// campaigns, templates, and media. Queries are accepted as JSON POST
+// bodies or as GET query params. Each root field requires the same
+// permission as its REST route.
+func handleGraphQL(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req graphql.Request
+	)
+
+	if c.Request().Method == http.MethodGet {
+		req.Query = c.QueryParam("query")
+		req.OperationName = c.QueryParam("operationName")
+		if v := c.QueryParam("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return newFieldError("variables", "Invalid `variables`.")
+			}
+		}
+	} else if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		return newFieldError("query", "`query` is required.")
+	}
+
+	ctx := context.WithValue(c.Request().Context(), gqlUserKey{}, getSessionUser(c))
+	return c.JSON(http.StatusOK, graphql.Execute(ctx, app.gqlSchema, req))
+}
+
+// initGraphQLSchema returns the query root of the GraphQL API. The types
+// have the same fields as the JSON responses of the REST API.
+func initGraphQLSchema(app *App) *graphql.Object {
+	var (
+		sub     = graphql.NewObject("Subscriber", models.Subscriber{})
+		subs    = graphql.NewObject("Subscribers", subsWrap{})
+		list    = graphql.NewObject("List", models.List{})
+		lists   = graphql.NewObject("Lists", listsWrap{})
+		camp    = graphql.NewObject("Campaign", models.Campaign{})
+		camps   = graphql.NewObject("Campaigns", campsWrap{})
+		stats   = graphql.NewObject("CampaignStats", models.CampaignMeta{})
+		link    = graphql.NewObject("LinkCount", linkCount{})
+		tpl     = graphql.NewObject("Template", models.Template{})
+		mediaOb = graphql.NewObject("Media", media.Media{})
+	)
+	subs.Fields["results"].Type = sub
+	lists.Fields["results"].Type = list
+	camps.Fields["results"].Type = camp
+
+	// Campaign -> stats -> links.
+	camp.Fields["stats"] = &graphql.Field{
+		Type: stats,
+		Resolve: func(p graphql.Params) (interface{}, error) {
+			return p.Source, nil
+		},
+	}
+	stats.Fields["links"] = &graphql.Field{
+		Type: link,
+		Resolve: func(p graphql.Params) (interface{}, error) {
+			var (
+				c     = p.Source.(models.Campaign)
+				limit = p.Args.Int("limit", 20)
+				out   = []linkCount{}
+			)
+			if err := app.queries.GetCampaignLinkCounts.Select(&out, c.ID, limit); err != nil {
+				app.log.Errorf("error fetching campaign link counts: %v", err)
+				return nil, fmt.Errorf("Error fetching campaign link counts: %s", pqErrMsg(err))
+			}
+			return out, nil
+		},
+	}
+	camp.Fields["template"] = &graphql.Field{
+		Type: tpl,
+		Resolve: func(p graphql.Params) (interface{}, error) {
+			c := p.Source.(models.Campaign)
+			if c.TemplateID < 1 {
+				return nil, nil
+			}
+			if err := gqlCheckPerm(p, permTemplatesGet, app); err != nil {
+				return nil, err
+			}
+			out, err := gqlTemplates(c.TemplateID, p.Args, app)
+			if err != nil || len(out) == 0 {
+				return nil, err
+			}
+			return out[0], nil
+		},
+	}
+
+	return &graphql.Object{
+		Name: "Query",
+		Fields: map[string]*graphql.Field{
+			"subscribers": {
+				Type: subs,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permSubscribersGet, app); err != nil {
+						return nil, err
+					}
+					out, err := querySubscribers(sanitizeSQLExp(p.Args.String("query")), p.Args.Int("list_id", 0),
+						p.Args.String("order_by"), p.Args.String("order"), gqlPagination(p.Args, 30, 100), app)
+					return out, gqlErr(err)
+				},
+			},
+			"subscriber": {
+				Type: sub,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permSubscribersGet, app); err != nil {
+						return nil, err
+					}
+					out, err := getSubscriber(p.Args.Int("id", 0), app)
+					return out, gqlErr(err)
+				},
+			},
+
+			"lists": {
+				Type: lists,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permListsGet, app); err != nil {
+						return nil, err
+					}
+					var (
+						pg  = gqlPagination(p.Args, 20, 50)
+						out = listsWrap{Results: []models.List{}}
+					)
+					res, err := queryLists(0, p.Args.Int("group_id", 0), p.Args.String("archived"),
+						strings.TrimSpace(p.Args.String("query")), p.Args.Strings("tag"),
+						p.Args.String("order_by"), p.Args.String("order"), pg, app)
+					if err != nil {
+						return nil, gqlErr(err)
+					}
+					if len(res) > 0 {
+						out = listsWrap{Results: res, Total: res[0].Total, Page: pg.Page, PerPage: pg.PerPage}
+					}
+					return out, nil
+				},
+			},
+			"list": {
+				Type: list,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permListsGet, app); err != nil {
+						return nil, err
+					}
+					id := p.Args.Int("id", 0)
+					if id < 1 {
+						return nil, errors.New("Invalid ID.")
+					}
+					res, err := queryLists(id, 0, "", "", nil, "", "", gqlPagination(p.Args, 20, 50), app)
+					if err != nil || len(res) == 0 {
+						return nil, gqlErr(err)
+					}
+					return res[0], nil
+				},
+			},
+
+			"campaigns": {
+				Type: camps,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permCampaignsGet, app); err != nil {
+						return nil, err
+					}
+					var (
+						pg  = gqlPagination(p.Args, 20, 50)
+						out = campsWrap{Results: models.Campaigns{}}
+					)
+					res, err := queryCampaigns(0, p.Args.Strings("status"), strings.TrimSpace(p.Args.String("query")),
+						p.Args.String("order_by"), p.Args.String("order"), p.Args.Bool("no_body"), pg, app)
+					if err != nil {
+						return nil, gqlErr(err)
+					}
+					if len(res) > 0 {
+						out = campsWrap{Results: res, Total: res[0].Total, Page: pg.Page, PerPage: pg.PerPage}
+					}
+					return out, nil
+				},
+			},
+			"campaign": {
+				Type: camp,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permCampaignsGet, app); err != nil {
+						return nil, err
+					}
+					id := p.Args.Int("id", 0)
+					if id < 1 {
+						return nil, errors.New("Invalid ID.")
+					}
+					res, err := queryCampaigns(id, nil, "", "", "", p.Args.Bool("no_body"), gqlPagination(p.Args, 20, 50), app)
+					if err != nil || len(res) == 0 {
+						return nil, gqlErr(err)
+					}
+					return res[0], nil
+				},
+			},
+
+			"templates": {
+				Type: tpl,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permTemplatesGet, app); err != nil {
+						return nil, err
+					}
+					return gqlTemplates(0, p.Args, app)
+				},
+			},
+			"template": {
+				Type: tpl,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permTemplatesGet, app); err != nil {
+						return nil, err
+					}
+					id := p.Args.Int("id", 0)
+					if id < 1 {
+						return nil, errors.New("Invalid ID.")
+					}
+					out, err := gqlTemplates(id, p.Args, app)
+					if err != nil || len(out) == 0 {
+						return nil, err
+					}
+					return out[0], nil
+				},
+			},
+
+			"media": {
+				Type: mediaOb,
+				Resolve: func(p graphql.Params) (interface{}, error) {
+					if err := gqlCheckPerm(p, permMediaGet, app); err != nil {
+						return nil, err
+					}
+					out := []media.Media{}
+					if err := app.queries.GetMedia.Select(&out, app.constants.MediaProvider); err != nil {
+						return nil, fmt.Errorf("Error fetching media list: %s", pqErrMsg(err))
+					}
+					for i := 0; i < len(out); i++ {
+						out[i].URL = app.media.Get(out[i].Filename)
+						out[i].ThumbURL = app.media.Get(out[i].Thumb)
+					}
+					return out, nil
+				},
+			},
+		},
+	}
+}
+
+// gqlCheckPerm checks whether the user of a query has a permission.
+func gqlCheckPerm(p graphql.Params, perm string, app *App) error {
+	u, _ := p.Context.Value(gqlUserKey{}).(models.User)
+	if !app.rolePerms.has(u.Role, perm) {
+		return fmt.Errorf("You don't have the permission to do this (%s).", perm)
+	}
+	return nil
+}
+
+// gqlTemplates returns the templates filtered by ID, folder, and tags.
+func gqlTemplates(id int, args graphql.Args, app *App) ([]models.Template, error) {
+	out := []models.Template{}
+	if err := app.queries.GetTemplates.Select(&out, id, args.Bool("no_body"),
+		strings.TrimSpace(args.String("folder")), pq.StringArray(args.Strings("tag"))); err != nil {
+		return nil, fmt.Errorf("Error fetching templates: %s", pqErrMsg(err))
+	}
+
+	for i := 0; i < len(out); i++ {
+		if out[i].Tags == nil {
+			out[i].Tags = make(pq.StringArray, 0)
+		}
+		if out[i].Thumb != "" {
+			out[i].ThumbURL = app.media.Get(out[i].Thumb)
+		}
+	}
+	return out, nil
+}
+
+// gqlPagination returns the pagination of the page and per_page args.
+func gqlPagination(args graphql.Args, perPage, maxPerPage int) pagination {
+	return getPagination(url.Values{
+		"page":     {strconv.Itoa(args.Int("page", 1))},
+		"per_page": {strconv.Itoa(args.Int("per_page", perPage))},
+	}, perPage, maxPerPage)
+}
+
+// gqlErr returns the message of an HTTP error of the REST handlers as a
+// GraphQL field error.
+func gqlErr(err error) error {
+	if e, ok := err.(*echo.HTTPError); ok {
+		return fmt.Errorf("%v", e.Message)
+	}
+	return err
+}
@@ -6,9 +6,10 @@ import (
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 
+	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo"
-	"github.com/labstack/echo/middleware"
 )
 
 const (
@@ -35,28 +36,69 @@ var reUUID = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[
 
 // registerHandlers registers HTTP handlers.
 func registerHTTPHandlers(e *echo.Echo) {
-	// Group of private handlers with BasicAuth.
-	g := e.Group("", middleware.BasicAuth(basicAuth))
+	// Group of private handlers that require a session or BasicAuth. The
+	// requests of API clients are counted, the requests of each session or
+	// user are rate limited, the role of the user is checked for the
+	// permission required by each route, changes are rejected in read-only mode,
+	// and administrative actions are recorded in the audit log. Requests
+	// from IPs outside the admin IP allowlist are rejected first.
+	g := e.Group("", checkAllowedIP, authenticate, trackAPIUsage, limitAPI, checkRole, checkReadOnly, auditLog)
 	g.GET("/", handleIndexPage)
 	g.GET("/api/health", handleHealthCheck)
 	g.GET("/api/config.js", handleGetConfigScript)
+	g.GET("/api/openapi.json", handleGetOpenAPI)
+	g.GET("/api/graphql", handleGraphQL)
+	g.POST("/api/graphql", handleGraphQL)
 	g.GET("/api/dashboard/charts", handleGetDashboardCharts)
 	g.GET("/api/dashboard/counts", handleGetDashboardCounts)
+	g.GET("/api/dashboard/stats", handleGetDashboardStats)
 
-	g.GET("/api/settings", handleGetSettings)
+	g.GET("/api/settings", handleGetSettings, conditionalGet)
 	g.PUT("/api/settings", handleUpdateSettings)
+	g.GET("/api/settings/smtp/status", handleGetSMTPStatus)
+	g.POST("/api/settings/smtp/test", handleTestSMTP)
+	g.GET("/api/settings/messengers/metrics", handleGetMessengerMetrics)
 	g.POST("/api/admin/reload", handleReloadApp)
+	g.GET("/api/admin/read-only", handleGetReadOnly)
+	g.PUT("/api/admin/read-only", handleUpdateReadOnly)
 	g.GET("/api/logs", handleGetLogs)
-
-	g.GET("/api/subscribers/:id", handleGetSubscriber)
+	g.GET("/api/events", handleEventStream)
+	g.GET("/api/audit-log", handleGetAuditLog)
+
+	g.GET("/api/roles", handleGetRoles)
+	g.GET("/api/roles/permissions", handleGetPermissions)
+	g.PUT("/api/roles/:role", handleUpdateRole)
+
+	g.GET("/api/users", handleGetUsers)
+	g.GET("/api/users/usage", handleGetAPIUsage)
+	g.GET("/api/users/:id", handleGetUsers)
+	g.POST("/api/users", handleCreateUser)
+	g.PUT("/api/users/:id", handleUpdateUser)
+	g.DELETE("/api/users/:id", handleDeleteUser)
+	g.DELETE("/api/users/:id/2fa", handleResetUserTwoFactor)
+	g.POST("/api/logout", handleLogout)
+	g.GET("/api/profile", handleGetProfile)
+	g.PUT("/api/profile", handleUpdateProfile)
+	g.POST("/api/profile/2fa", handleEnrollTwoFactor)
+	g.POST("/api/profile/2fa/verify", handleEnableTwoFactor)
+	g.POST("/api/profile/2fa/recovery-codes", handleRegenRecoveryCodes)
+	g.DELETE("/api/profile/2fa", handleDisableTwoFactor)
+
+	g.GET("/api/subscribers/export", handleExportSubscribers)
+	g.GET("/api/subscribers/cohorts", handleGetSubscriberCohorts)
+	g.GET("/api/subscribers/:id", handleGetSubscriber, responseFields(nil))
+	g.GET("/api/subscribers/:id/stats/views", handleGetSubscriberViewStats)
+	g.GET("/api/subscribers/:id/stats/engagement", handleGetSubscriberEngagement)
 	g.GET("/api/subscribers/:id/export", handleExportSubscriberData)
-	g.POST("/api/subscribers", handleCreateSubscriber)
+	g.POST("/api/subscribers", handleCreateSubscriber, idempotent)
+	g.POST("/api/subscribers/batch", handleBatchSubscribers, idempotent)
 	g.PUT("/api/subscribers/:id", handleUpdateSubscriber)
 	g.POST("/api/subscribers/:id/optin", handleSubscriberSendOptin)
 	g.PUT("/api/subscribers/blocklist", handleBlocklistSubscribers)
 	g.PUT("/api/subscribers/:id/blocklist", handleBlocklistSubscribers)
 	g.PUT("/api/subscribers/lists/:id", handleManageSubscriberLists)
 	g.PUT("/api/subscribers/lists", handleManageSubscriberLists)
+	g.POST("/api/subscribers/lists/batch", handleBatchSubscriberLists, idempotent)
 	g.DELETE("/api/subscribers/:id", handleDeleteSubscribers)
 	g.DELETE("/api/subscribers", handleDeleteSubscribers)
 
@@ -65,42 +107,117 @@ func registerHTTPHandlers(e *echo.Echo) {
 	g.POST("/api/subscribers/query/delete", handleDeleteSubscribersByQuery)
 	g.PUT("/api/subscribers/query/blocklist", handleBlocklistSubscribersByQuery)
 	g.PUT("/api/subscribers/query/lists", handleManageSubscriberListsByQuery)
-	g.GET("/api/subscribers", handleQuerySubscribers)
+	g.GET("/api/subscribers", handleQuerySubscribers, responseFields(nil))
 
 	g.GET("/api/import/subscribers", handleGetImportSubscribers)
 	g.GET("/api/import/subscribers/logs", handleGetImportSubscriberStats)
 	g.POST("/api/import/subscribers", handleImportSubscribers)
 	g.DELETE("/api/import/subscribers", handleStopImportSubscribers)
 
-	g.GET("/api/lists", handleGetLists)
-	g.GET("/api/lists/:id", handleGetLists)
+	g.GET("/api/lists", handleGetLists, responseFields(listExpanders))
+	g.GET("/api/lists/:id", handleGetLists, responseFields(listExpanders))
+	g.GET("/api/lists/:id/stats", handleGetListGrowthStats)
+	g.GET("/api/lists/:id/snapshots", handleGetListSnapshots)
+	g.GET("/api/lists/:id/waitlist", handleGetListWaitlist)
 	g.POST("/api/lists", handleCreateList)
 	g.PUT("/api/lists/:id", handleUpdateList)
+	g.POST("/api/lists/:id/clone", handleCloneList)
+	g.POST("/api/lists/:id/merge", handleMergeList)
+	g.PUT("/api/lists/:id/archive", handleArchiveList)
 	g.DELETE("/api/lists/:id", handleDeleteLists)
 
-	g.GET("/api/campaigns", handleGetCampaigns)
-	g.GET("/api/campaigns/running/stats", handleGetRunningCampaignStats)
-	g.GET("/api/campaigns/:id", handleGetCampaigns)
+	g.GET("/api/lists/:id/webhooks", handleGetListWebhooks)
+	g.GET("/api/lists/:id/webhooks/:hookID", handleGetListWebhooks)
+	g.POST("/api/lists/:id/webhooks", handleCreateListWebhook)
+	g.PUT("/api/lists/:id/webhooks/:hookID", handleUpdateListWebhook)
+	g.DELETE("/api/lists/:id/webhooks/:hookID", handleDeleteListWebhook)
+
+	g.GET("/api/webhooks", handleGetWebhooks)
+	g.GET("/api/webhooks/deliveries", handleGetWebhookDeliveries)
+	g.POST("/api/webhooks/deliveries/:id/retry", handleRetryWebhookDelivery)
+	g.GET("/api/webhooks/:id", handleGetWebhooks)
+	g.POST("/api/webhooks", handleCreateWebhook)
+	g.PUT("/api/webhooks/:id", handleUpdateWebhook)
+	g.DELETE("/api/webhooks/:id", handleDeleteWebhook)
+
+	g.GET("/api/lists/groups", handleGetListGroups)
+	g.GET("/api/lists/groups/:id", handleGetListGroups)
+	g.POST("/api/lists/groups", handleCreateListGroup)
+	g.PUT("/api/lists/groups/:id", handleUpdateListGroup)
+	g.DELETE("/api/lists/groups/:id", handleDeleteListGroup)
+
+	g.GET("/api/campaigns", handleGetCampaigns, conditionalGet, responseFields(campaignExpanders))
+	g.GET("/api/campaigns/running/stats", handleGetRunningCampaignStats, conditionalGet)
+	g.GET("/api/campaigns/compare", handleGetCampaignComparison)
+	g.GET("/api/campaigns/stats/domains", handleGetDomainStats)
+	g.GET("/api/campaigns/events/export", handleExportTrackingEvents)
+	g.GET("/api/campaigns/:id", handleGetCampaigns, conditionalGet, responseFields(campaignExpanders))
+	g.GET("/api/campaigns/:id/stats/views", handleGetCampaignViewStats)
+	g.GET("/api/campaigns/:id/stats/links", handleGetCampaignLinkStats)
+	g.GET("/api/campaigns/:id/stats/geo", handleGetCampaignGeoStats)
+	g.GET("/api/campaigns/:id/stats/clients", handleGetCampaignClientStats)
+	g.GET("/api/campaigns/:id/stats/heatmap", handleGetCampaignLinkHeatmap)
+	g.GET("/api/campaigns/:id/stats/unsubscribes", handleGetCampaignUnsubStats)
+	g.GET("/api/campaigns/:id/stats/funnel", handleGetCampaignFunnel)
+	g.GET("/api/campaigns/:id/stats/domains", handleGetDomainStats)
+	g.GET("/api/campaigns/:id/progress", handleCampaignProgressStream)
 	g.GET("/api/campaigns/:id/preview", handlePreviewCampaign)
 	g.POST("/api/campaigns/:id/preview", handlePreviewCampaign)
 	g.POST("/api/campaigns/:id/test", handleTestCampaign)
 	g.POST("/api/campaigns", handleCreateCampaign)
+	g.POST("/api/campaigns/conversions", handleRecordConversion)
+	g.POST("/api/tx", handleSendTxMessage)
+	g.GET("/api/tx/log", handleGetTxLog)
+	g.GET("/api/deliveries", handleGetDeliveryLog)
 	g.PUT("/api/campaigns/:id", handleUpdateCampaign)
 	g.PUT("/api/campaigns/:id/status", handleUpdateCampaignStatus)
 	g.DELETE("/api/campaigns/:id", handleDeleteCampaign)
 
-	g.GET("/api/media", handleGetMedia)
+	g.GET("/api/media", handleGetMedia, responseFields(nil))
 	g.POST("/api/media", handleUploadMedia)
 	g.DELETE("/api/media/:id", handleDeleteMedia)
 
-	g.GET("/api/templates", handleGetTemplates)
-	g.GET("/api/templates/:id", handleGetTemplates)
+	g.GET("/api/templates", handleGetTemplates, conditionalGet, responseFields(nil))
+	g.GET("/api/templates/folders", handleGetTemplateFolders)
+	g.GET("/api/templates/system", handleGetSystemTemplates)
+	g.GET("/api/templates/system/:name", handleGetSystemTemplates)
+	g.PUT("/api/templates/system/:name/:lang", handleUpdateSystemTemplate)
+	g.DELETE("/api/templates/system/:name/:lang", handleDeleteSystemTemplate)
+	g.GET("/api/templates/partials", handleGetTemplatePartials)
+	g.GET("/api/templates/partials/:id", handleGetTemplatePartials)
+	g.POST("/api/templates/partials", handleCreateTemplatePartial)
+	g.PUT("/api/templates/partials/:id", handleUpdateTemplatePartial)
+	g.DELETE("/api/templates/partials/:id", handleDeleteTemplatePartial)
+	g.GET("/api/templates/:id", handleGetTemplates, conditionalGet, responseFields(nil))
 	g.GET("/api/templates/:id/preview", handlePreviewTemplate)
+	g.POST("/api/templates/:id/preview", handlePreviewTemplate)
 	g.POST("/api/templates/preview", handlePreviewTemplate)
+	g.POST("/api/templates/lint", handleLintTemplate)
+	g.GET("/api/templates/funcs", handleGetTemplateFuncs)
+	g.POST("/api/templates/:id/render", handleRenderTxTemplate)
+	g.POST("/api/templates/:id/clone", handleCloneTemplate)
+	g.POST("/api/templates/:id/thumbnail", handleGenerateTemplateThumb)
 	g.POST("/api/templates", handleCreateTemplate)
+	g.POST("/api/templates/import", handleImportTemplate)
+	g.GET("/api/templates/:id/export", handleExportTemplate)
 	g.PUT("/api/templates/:id", handleUpdateTemplate)
 	g.PUT("/api/templates/:id/default", handleTemplateSetDefault)
+	g.PUT("/api/templates/:id/fallback", handleTemplateSetFallback)
+	g.DELETE("/api/templates/:id/fallback", handleTemplateUnsetFallback)
 	g.DELETE("/api/templates/:id", handleDeleteTemplate)
+	g.GET("/api/templates/:id/variants", handleGetTemplateVariants)
+	g.GET("/api/templates/:id/variants/:lang", handleGetTemplateVariants)
+	g.PUT("/api/templates/:id/variants/:lang", handleUpdateTemplateVariant)
+	g.DELETE("/api/templates/:id/variants/:lang", handleDeleteTemplateVariant)
+	g.GET("/api/templates/:id/fixtures", handleGetTemplateFixtures)
+	g.GET("/api/templates/:id/fixtures/:fixtureID", handleGetTemplateFixtures)
+	g.POST("/api/templates/:id/fixtures", handleCreateTemplateFixture)
+	g.PUT("/api/templates/:id/fixtures/:fixtureID", handleUpdateTemplateFixture)
+	g.DELETE("/api/templates/:id/fixtures/:fixtureID", handleDeleteTemplateFixture)
+	g.GET("/api/templates/:id/revisions", handleGetTemplateRevisions)
+	g.GET("/api/templates/:id/revisions/:revID", handleGetTemplateRevisions)
+	g.GET("/api/templates/:id/revisions/:revID/diff", handleDiffTemplateRevision)
+	g.POST("/api/templates/:id/revisions/:revID/restore", handleRestoreTemplateRevision)
 
 	// Static admin views.
 	g.GET("/lists", handleIndexPage)
@@ -116,24 +233,39 @@ func registerHTTPHandlers(e *echo.Echo) {
 	g.GET("/settings", handleIndexPage)
 	g.GET("/settings/logs", handleIndexPage)
 
-	// Public subscriber facing views.
-	e.POST("/subscription/form", handleSubscriptionForm)
+	// Admin login.
+	e.GET("/admin/login", handleLoginPage, checkAllowedIP, limitPublic)
+	e.POST("/admin/login", handleLoginPage, checkAllowedIP, limitPublic)
+	e.POST("/api/login", handleLogin, checkAllowedIP, limitPublic)
+
+	// Public subscriber facing views. These and the tracking endpoints are
+	// rate limited by client IP.
+	e.GET("/subscription/lists", handleListDirectoryPage, limitPublic)
+	e.GET("/api/public/lists", handleGetPublicLists, limitPublic)
+	e.POST("/subscription/form", handleSubscriptionForm, limitPublic)
 	e.GET("/subscription/:campUUID/:subUUID", validateUUID(subscriberExists(handleSubscriptionPage),
-		"campUUID", "subUUID"))
+		"campUUID", "subUUID"), limitPublic)
 	e.POST("/subscription/:campUUID/:subUUID", validateUUID(subscriberExists(handleSubscriptionPage),
-		"campUUID", "subUUID"))
-	e.GET("/subscription/optin/:subUUID", validateUUID(subscriberExists(handleOptinPage), "subUUID"))
-	e.POST("/subscription/optin/:subUUID", validateUUID(subscriberExists(handleOptinPage), "subUUID"))
+		"campUUID", "subUUID"), limitPublic)
+	e.GET("/subscription/:campUUID/:subUUID/unsubscribe", validateUUID(subscriberExists(handleSubscriptionPage),
+		"campUUID", "subUUID"), limitPublic)
+	e.POST("/subscription/:campUUID/:subUUID/unsubscribe", validateUUID(handleOneClickUnsubscribe,
+		"campUUID", "subUUID"), limitPublic)
+	e.GET("/subscription/optin/:subUUID", validateUUID(subscriberExists(handleOptinPage), "subUUID"), limitPublic)
+	e.POST("/subscription/optin/:subUUID", validateUUID(subscriberExists(handleOptinPage), "subUUID"), limitPublic)
 	e.POST("/subscription/export/:subUUID", validateUUID(subscriberExists(handleSelfExportSubscriberData),
-		"subUUID"))
+		"subUUID"), limitPublic)
 	e.POST("/subscription/wipe/:subUUID", validateUUID(subscriberExists(handleWipeSubscriberData),
-		"subUUID"))
+		"subUUID"), limitPublic)
 	e.GET("/link/:linkUUID/:campUUID/:subUUID", validateUUID(handleLinkRedirect,
-		"linkUUID", "campUUID", "subUUID"))
+		"linkUUID", "campUUID", "subUUID"), limitTracking)
 	e.GET("/campaign/:campUUID/:subUUID", validateUUID(handleViewCampaignMessage,
-		"campUUID", "subUUID"))
+		"campUUID", "subUUID"), limitPublic)
 	e.GET("/campaign/:campUUID/:subUUID/px.png", validateUUID(handleRegisterCampaignView,
-		"campUUID", "subUUID"))
+		"campUUID", "subUUID"), limitTracking)
+
+	// Bounce and complaint notifications from e-mail services.
+	e.POST("/webhooks/service/:service", handleBounceWebhook)
 }
 
 // handleIndex is the root handler that renders the Javascript frontend.
@@ -154,21 +286,80 @@ func handleHealthCheck(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
-// basicAuth middleware does an HTTP BasicAuth authentication for admin handlers.
-func basicAuth(username, password string, c echo.Context) (bool, error) {
-	app := c.Get("app").(*App)
+// getRequestUser returns the name of the admin user making the request.
+func getRequestUser(c echo.Context) string {
+	return getSessionUser(c).Username
+}
 
-	// Auth is disabled.
-	if len(app.constants.AdminUsername) == 0 &&
-		len(app.constants.AdminPassword) == 0 {
-		return true, nil
-	}
+// authenticate middleware authenticates requests to the admin handlers
+// with the session cookie of the admin UI or the BasicAuth credentials of
+// API clients and sets the authenticated user on the context. Requests
+// with sessions should have the session's CSRF token in a header unless
+// they're read-only.
+func authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		app := c.Get("app").(*App)
+
+		if ck, err := c.Cookie(sessionCookie); err == nil && ck.Value != "" {
+			s, ok, err := getSession(ck.Value, app)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if !isSafeMethod(c.Request().Method) &&
+					subtle.ConstantTimeCompare([]byte(c.Request().Header.Get(csrfHeader)), []byte(s.CSRFToken)) != 1 {
+					return newHTTPError(http.StatusForbidden, errCodeCSRF, "Invalid CSRF token.")
+				}
+
+				c.Set("user", s.User)
+				c.Set("session", ck.Value)
+				c.Set("csrf", s.CSRFToken)
+				return next(c)
+			}
+		}
 
-	if subtle.ConstantTimeCompare([]byte(username), app.constants.AdminUsername) == 1 &&
-		subtle.ConstantTimeCompare([]byte(password), app.constants.AdminPassword) == 1 {
-		return true, nil
+		if username, password, ok := c.Request().BasicAuth(); ok {
+			// Failed attempts are limited before the API rate limit as
+			// that only applies to authenticated requests.
+			if err := checkAuthLimit(c); err != nil {
+				return err
+			}
+
+			u, ok, err := authenticateUser(username, password, app)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				countAuthFailure(c)
+			}
+
+			// Users with 2FA can only log in to sessions.
+			if ok && u.TOTPEnabled {
+				return newHTTPError(http.StatusUnauthorized, errCodeTwoFactorRequired,
+					"Users with 2FA enabled should log in.")
+			}
+			if ok {
+				c.Set("user", u)
+				return next(c)
+			}
+		}
+
+		// Auth is disabled if there are no users and every request is
+		// treated as an admin's.
+		app.Lock()
+		hasUsers := app.hasUsers
+		app.Unlock()
+		if !hasUsers {
+			c.Set("user", models.User{Role: models.UserRoleAdmin, Status: models.UserStatusEnabled})
+			return next(c)
+		}
+
+		// Send admin UI page requests to the login page.
+		if c.Request().Method == http.MethodGet && !strings.HasPrefix(c.Path(), "/api/") {
+			return c.Redirect(http.StatusFound, "/admin/login?next="+url.QueryEscape(c.Request().URL.RequestURI()))
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired session.")
 	}
-	return false, nil
 }
 
 // validateUUID middleware validates the UUID string format for a given set of params.
@@ -196,7 +387,7 @@ func subscriberExists(next echo.HandlerFunc, params ...string) echo.HandlerFunc
 
 		var exists bool
 		if err := app.queries.SubscriberExists.Get(&exists, 0, subUUID); err != nil {
-			app.log.Printf("error checking subscriber existence: %v", err)
+			app.log.Errorf("error checking subscriber existence: %v", err)
 			return c.Render(http.StatusInternalServerError, tplMessage,
 				makeMsgTpl("Error", "",
 					`Error processing request. Please retry.`))
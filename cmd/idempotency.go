@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+
+	// Header that's set on responses that are replayed.
+	idempotencyReplayHeader = "Idempotent-Replayed"
+
+	// Duration for which the responses of idempotency keys are kept.
+	idempotencyKeyTTL = time.Hour * 24
+
+	// Interval at which expired idempotency keys are deleted.
+	idempotencyPruneInterval = time.Hour
+)
+
+// idempotencyKey represents a recorded request with an idempotency key.
+type idempotencyKey struct {
+	Key         string    `db:"key"`
+	UserID      int       `db:"user_id"`
+	RequestHash string    `db:"request_hash"`
+	Status      null.Int  `db:"status"`
+	Response    []byte    `db:"response"`
+	CreatedAt   null.Time `db:"created_at"`
+}
+
+// responseRecorder is an http.ResponseWriter that keeps a copy of the
+// response body.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent is a middleware that makes a route safe to retry. The response
+// of a request with an Idempotency-Key header is recorded and replayed for
+// subsequent requests by the same user with the same key instead of
+// processing them again. Requests without the header are processed as is.
+func idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(idempotencyHeader)
+		if key == "" {
+			return next(c)
+		}
+		if !strHasLen(key, 1, stdInputMaxLen) {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Invalid length for the idempotency key.")
+		}
+
+		var (
+			app  = c.Get("app").(*App)
+			u, _ = c.Get("user").(models.User)
+		)
+
+		// Read the body to hash the request and put it back for the handler.
+		body, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Error reading request.")
+		}
+		c.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		h.Write([]byte(c.Request().Method + " " + c.Request().URL.Path + "\n"))
+		h.Write(body)
+		hash := hex.EncodeToString(h.Sum(nil))
+
+		// Claim the key. If it's already been claimed, replay the response.
+		var claimed string
+		if err := app.queries.InsertIdempotencyKey.Get(&claimed, key, u.ID, hash); err != nil {
+			if err != sql.ErrNoRows {
+				app.log.Errorf("error recording idempotency key: %v", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Error recording idempotency key.")
+			}
+			return replayIdempotentResponse(c, key, hash, u.ID, app)
+		}
+
+		rec := &responseRecorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = rec
+		err = next(c)
+		c.Response().Writer = rec.ResponseWriter
+
+		// Failed requests aren't recorded so that they can be retried.
+		if err != nil || c.Response().Status >= http.StatusBadRequest {
+			if _, err := app.queries.DeleteIdempotencyKey.Exec(key, u.ID); err != nil {
+				app.log.Errorf("error deleting idempotency key: %v", err)
+			}
+			return err
+		}
+
+		if _, err := app.queries.UpdateIdempotencyKey.Exec(key, u.ID, c.Response().Status, rec.body.Bytes()); err != nil {
+			app.log.Errorf("error recording idempotent response: %v", err)
+		}
+		return nil
+	}
+}
+
+// replayIdempotentResponse writes the recorded response of an idempotency
+// key that's already been used.
+func replayIdempotentResponse(c echo.Context, key, hash string, userID int, app *App) error {
+	var k idempotencyKey
+	if err := app.queries.GetIdempotencyKey.Get(&k, key, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusConflict,
+				"The request with the idempotency key failed. Retry the request.")
+		}
+
+		getLogger(c).Errorf("error fetching idempotency key: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error fetching idempotency key.")
+	}
+
+	if k.RequestHash != hash {
+		return newHTTPError(http.StatusUnprocessableEntity, errCodeIdempotencyReused,
+			"The idempotency key has already been used for a different request.")
+	}
+	if !k.Status.Valid {
+		return newHTTPError(http.StatusConflict, errCodeIdempotencyBusy,
+			"A request with the idempotency key is still being processed.")
+	}
+
+	c.Response().Header().Set(idempotencyReplayHeader, "true")
+	return c.Blob(k.Status.Int, echo.MIMEApplicationJSONCharsetUTF8, k.Response)
+}
+
+// pruneIdempotencyKeys periodically deletes the idempotency keys that are
+// older than idempotencyKeyTTL. It blocks and should be invoked as a
+// goroutine.
+func pruneIdempotencyKeys(app *App) {
+	ticker := time.NewTicker(idempotencyPruneInterval)
+	for ; true; <-ticker.C {
+		if _, err := app.queries.DeleteExpiredIdempotencyKeys.Exec(time.Now().Add(-idempotencyKeyTTL)); err != nil {
+			app.log.Errorf("error pruning idempotency keys: %v", err)
+		}
+	}
+}
@@ -27,29 +27,28 @@ func handleImportSubscribers(c echo.Context) error {
 
 	// Is an import already running?
 	if app.importer.GetStats().Status == subimporter.StatusImporting {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newHTTPError(http.StatusBadRequest, errCodeConflict,
 			"An import is already running. Wait for it to finish or stop it before trying again.")
 	}
 
 	// Unmarsal the JSON params.
 	var r reqImport
 	if err := json.Unmarshal([]byte(c.FormValue("params")), &r); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newFieldError("params",
 			fmt.Sprintf("Invalid `params` field: %v", err))
 	}
 
 	if r.Mode != subimporter.ModeSubscribe && r.Mode != subimporter.ModeBlocklist {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid `mode`")
+		return newFieldError("mode", "Invalid `mode`")
 	}
 
 	if len(r.Delim) != 1 {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			"`delim` should be a single character")
+		return newFieldError("delim", "`delim` should be a single character")
 	}
 
 	file, err := c.FormFile("file")
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newFieldError("file",
 			fmt.Sprintf("Invalid `file`: %v", err))
 	}
 
@@ -74,7 +73,7 @@ func handleImportSubscribers(c echo.Context) error {
 	// Start the importer session.
 	impSess, err := app.importer.NewSession(file.Filename, r.Mode, r.Overwrite, r.ListIDs)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			fmt.Sprintf("Error starting import session: %v", err))
 	}
 	go impSess.Start()
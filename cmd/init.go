@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,41 +21,90 @@ import (
 	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/posflag"
+	"github.com/knadh/listmonk/internal/bounce"
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/dkim"
+	"github.com/knadh/listmonk/internal/events"
+	"github.com/knadh/listmonk/internal/geoip"
+	"github.com/knadh/listmonk/internal/logger"
+	"github.com/knadh/listmonk/internal/mailsign"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/media/providers/filesystem"
 	"github.com/knadh/listmonk/internal/media/providers/s3"
 	"github.com/knadh/listmonk/internal/messenger"
 	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/messenger/mailgun"
 	"github.com/knadh/listmonk/internal/messenger/postback"
+	"github.com/knadh/listmonk/internal/messenger/postmark"
+	"github.com/knadh/listmonk/internal/messenger/sendgrid"
+	"github.com/knadh/listmonk/internal/messenger/sendmail"
+	"github.com/knadh/listmonk/internal/messenger/ses"
+	"github.com/knadh/listmonk/internal/messenger/sms"
+	"github.com/knadh/listmonk/internal/messenger/sparkpost"
+	"github.com/knadh/listmonk/internal/mjml"
+	"github.com/knadh/listmonk/internal/ratelimit"
+	"github.com/knadh/listmonk/internal/screenshot"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/webhooks"
+	"github.com/knadh/listmonk/models"
 	"github.com/knadh/stuffbin"
 	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
 	flag "github.com/spf13/pflag"
 )
 
 const (
 	queryFilePath = "queries.sql"
+
+	// Interval at which the connectivity of SMTP servers is checked.
+	smtpHealthCheckInterval = time.Minute
 )
 
 // constants contains static, constant config values required by the app.
 type constants struct {
-	RootURL      string   `koanf:"root_url"`
-	LogoURL      string   `koanf:"logo_url"`
-	FaviconURL   string   `koanf:"favicon_url"`
-	FromEmail    string   `koanf:"from_email"`
-	NotifyEmails []string `koanf:"notify_emails"`
-	Privacy      struct {
+	RootURL                   string   `koanf:"root_url"`
+	LogoURL                   string   `koanf:"logo_url"`
+	FaviconURL                string   `koanf:"favicon_url"`
+	FromEmail                 string   `koanf:"from_email"`
+	NotifyEmails              []string `koanf:"notify_emails"`
+	EnablePublicListDirectory bool     `koanf:"enable_public_list_directory"`
+	MJMLPath                  string   `koanf:"mjml_path"`
+	WkhtmltoimagePath         string   `koanf:"wkhtmltoimage_path"`
+	GeoIPPath                 string   `koanf:"geoip_path"`
+	Privacy                   struct {
 		IndividualTracking bool            `koanf:"individual_tracking"`
 		AllowBlocklist     bool            `koanf:"allow_blocklist"`
 		AllowExport        bool            `koanf:"allow_export"`
 		AllowWipe          bool            `koanf:"allow_wipe"`
+		IPTruncation       string          `koanf:"ip_truncation"`
 		Exportable         map[string]bool `koanf:"-"`
 	} `koanf:"privacy"`
 	AdminUsername []byte `koanf:"admin_username"`
 	AdminPassword []byte `koanf:"admin_password"`
 
+	// Admin UI session cookies.
+	SessionLifetime       time.Duration `koanf:"session_lifetime"`
+	SessionCookieSecure   bool          `koanf:"session_cookie_secure"`
+	SessionCookieSameSite string        `koanf:"session_cookie_samesite"`
+
+	// Retention of the raw campaign views and link clicks. 0 keeps them forever.
+	ViewsRetention  time.Duration `koanf:"views_retention"`
+	ClicksRetention time.Duration `koanf:"clicks_retention"`
+
+	// CORS policy of the public subscription and API endpoints.
+	CORSOrigins []string `koanf:"cors_origins"`
+	CORSMethods []string `koanf:"cors_methods"`
+	CORSHeaders []string `koanf:"cors_headers"`
+
+	// Date on which the deprecated unversioned API routes will be removed.
+	LegacyAPISunset time.Time `koanf:"-"`
+
+	// Actions taken on subscribers by bounce type.
+	BounceActions map[string]bounceAction `koanf:"-"`
+
 	UnsubURL      string
+	UnsubOneClick string
 	LinkTrackURL  string
 	ViewTrackURL  string
 	OptinURL      string
@@ -91,7 +141,7 @@ func initFlags() {
 // initConfigFiles loads the given config files into the koanf instance.
 func initConfigFiles(files []string, ko *koanf.Koanf) {
 	for _, f := range files {
-		lo.Printf("reading config: %s", f)
+		lo.Infof("reading config: %s", f)
 		if err := ko.Load(file.Provider(f), toml.Parser()); err != nil {
 			if os.IsNotExist(err) {
 				lo.Fatal("config file not found. If there isn't one yet, run --new-config to generate one.")
@@ -115,8 +165,8 @@ func initFS(staticDir string) stuffbin.FileSystem {
 	if err != nil {
 		// Running in local mode. Load local assets into
 		// the in-memory stuffbin.FileSystem.
-		lo.Printf("unable to initialize embedded filesystem: %v", err)
-		lo.Printf("using local filesystem for static assets")
+		lo.Warnf("unable to initialize embedded filesystem: %v", err)
+		lo.Infof("using local filesystem for static assets")
 		files := []string{
 			"config.toml.sample",
 			"queries.sql",
@@ -141,7 +191,7 @@ func initFS(staticDir string) stuffbin.FileSystem {
 
 	// Optional static directory to override files.
 	if staticDir != "" {
-		lo.Printf("loading static files from: %v", staticDir)
+		lo.Infof("loading static files from: %v", staticDir)
 		fStatic, err := stuffbin.NewLocalFS("/", []string{
 			filepath.Join(staticDir, "/email-templates") + ":/static/email-templates",
 
@@ -167,7 +217,7 @@ func initDB() *sqlx.DB {
 		lo.Fatalf("error loading db config: %v", err)
 	}
 
-	lo.Printf("connecting to db: %s:%d/%s", dbCfg.Host, dbCfg.Port, dbCfg.DBName)
+	lo.Infof("connecting to db: %s:%d/%s", dbCfg.Host, dbCfg.Port, dbCfg.DBName)
 	db, err := connectDB(dbCfg)
 	if err != nil {
 		lo.Fatalf("error connecting to DB: %v", err)
@@ -219,6 +269,50 @@ func initSettings(q *Queries) {
 	}
 }
 
+// initLogger sets the level and the format of the app log from the settings.
+func initLogger() {
+	lvl, err := logger.ParseLevel(ko.String("app.log_level"))
+	if err != nil {
+		lo.Warnf("%v. Using info", err)
+	}
+	lo.SetLevel(lvl)
+
+	if f := ko.String("app.log_format"); f != "" {
+		if err := lo.SetFormat(f); err != nil {
+			lo.Warnf("%v. Using text", err)
+		}
+	}
+}
+
+// initAdminUser creates the first admin user with the admin credentials
+// in the config if there are no users in the DB. With neither, admin
+// authentication is disabled. It returns whether there are users.
+func initAdminUser(q *Queries, cs *constants) bool {
+	var n userCount
+	if err := q.GetUserCount.Get(&n); err != nil {
+		lo.Fatalf("error fetching users: %s", pqErrMsg(err))
+	}
+	if n.Total > 0 {
+		return true
+	}
+
+	if len(cs.AdminUsername) == 0 || len(cs.AdminPassword) == 0 {
+		lo.Warnf("there are no admin users and admin authentication is disabled")
+		return false
+	}
+
+	hash, err := hashPassword(string(cs.AdminPassword))
+	if err != nil {
+		lo.Fatalf("error hashing admin password: %v", err)
+	}
+	if _, err := q.CreateUser.Exec(string(cs.AdminUsername), "", "", hash,
+		models.UserRoleAdmin, models.UserStatusEnabled); err != nil {
+		lo.Fatalf("error creating admin user: %s", pqErrMsg(err))
+	}
+	lo.Infof("created admin user '%s' from the config", cs.AdminUsername)
+	return true
+}
+
 func initConstants() *constants {
 	// Read constants.
 	var c constants
@@ -228,15 +322,28 @@ func initConstants() *constants {
 	if err := ko.Unmarshal("privacy", &c.Privacy); err != nil {
 		lo.Fatalf("error loading app config: %v", err)
 	}
+	if err := ko.Unmarshal("bounce.actions", &c.BounceActions); err != nil {
+		lo.Fatalf("error loading bounce config: %v", err)
+	}
 
 	c.RootURL = strings.TrimRight(c.RootURL, "/")
 	c.Privacy.Exportable = maps.StringSliceToLookupMap(ko.Strings("privacy.exportable"))
 	c.MediaProvider = ko.String("upload.provider")
+	if s := ko.String("app.legacy_api_sunset"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			lo.Fatalf("invalid app.legacy_api_sunset date: %s", s)
+		}
+		c.LegacyAPISunset = t
+	}
 
 	// Static URLS.
 	// url.com/subscription/{campaign_uuid}/{subscriber_uuid}
 	c.UnsubURL = fmt.Sprintf("%s/subscription/%%s/%%s", c.RootURL)
 
+	// url.com/subscription/{campaign_uuid}/{subscriber_uuid}/unsubscribe
+	c.UnsubOneClick = fmt.Sprintf("%s/subscription/%%s/%%s/unsubscribe", c.RootURL)
+
 	// url.com/subscription/optin/{subscriber_uuid}
 	c.OptinURL = fmt.Sprintf("%s/subscription/optin/%%s?%%s", c.RootURL)
 
@@ -254,6 +361,17 @@ func initConstants() *constants {
 // initCampaignManager initializes the campaign manager.
 func initCampaignManager(q *Queries, cs *constants, app *App) *manager.Manager {
 	campNotifCB := func(subject string, data interface{}) error {
+		if d, ok := data.(map[string]interface{}); ok {
+			e := campaignStatusEvent{}
+			e.ID, _ = d["ID"].(int)
+			e.Name, _ = d["Name"].(string)
+			e.Status, _ = d["Status"].(string)
+			e.Sent, _ = d["Sent"].(int)
+			e.ToSend, _ = d["ToSend"].(int)
+			e.Reason, _ = d["Reason"].(string)
+			app.events.Publish(events.TypeCampaignStatus, e)
+			pushWebhooks(webhooks.EventCampaignStatus, e, app)
+		}
 		return app.sendNotification(cs.NotifyEmails, subject, notifTplCampaign, data)
 	}
 
@@ -264,7 +382,18 @@ func initCampaignManager(q *Queries, cs *constants, app *App) *manager.Manager {
 		lo.Fatal("app.message_rate should be at least 1")
 	}
 
-	return manager.New(manager.Config{
+	var headers []manager.Header
+	if err := ko.UnmarshalWithConf("app.message_headers", &headers, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading message headers: %v", err)
+	}
+
+	// The List-Id of campaign messages is derived from the root URL's host.
+	listID := ""
+	if u, err := url.Parse(cs.RootURL); err == nil && u.Hostname() != "" {
+		listID = "listmonk." + u.Hostname()
+	}
+
+	m := manager.New(manager.Config{
 		BatchSize:          ko.Int("app.batch_size"),
 		Concurrency:        ko.Int("app.concurrency"),
 		MessageRate:        ko.Int("app.message_rate"),
@@ -272,13 +401,33 @@ func initCampaignManager(q *Queries, cs *constants, app *App) *manager.Manager {
 		FromEmail:          cs.FromEmail,
 		IndividualTracking: ko.Bool("privacy.individual_tracking"),
 		UnsubURL:           cs.UnsubURL,
+		UnsubOneClickURL:   cs.UnsubOneClick,
 		OptinURL:           cs.OptinURL,
 		LinkTrackURL:       cs.LinkTrackURL,
 		ViewTrackURL:       cs.ViewTrackURL,
 		MessageURL:         cs.MessageURL,
 		UnsubHeader:        ko.Bool("privacy.unsubscribe_header"),
-	}, newManagerDB(q), campNotifCB, lo)
+		VERP:               initVERP(),
+		ListID:             listID,
+		Headers:            headers,
+
+		GreylistDelay:        ko.Duration("app.greylist_delay"),
+		GreylistMaxDeferrals: ko.Int("app.greylist_max_deferrals"),
+	}, newManagerDB(q, db, ko.Duration("app.dynamic_list_sync_interval")), campNotifCB, lo)
+
+	var domLimits []manager.DomainLimit
+	if err := ko.UnmarshalWithConf("app.domain_limits", &domLimits, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading domain limits: %v", err)
+	}
+	m.SetDomainLimits(domLimits)
+
+	// Record the outcome of messages in the transactional and delivery logs.
+	logDelivery := ko.Bool("app.delivery_log")
+	m.SetDeliveryCallback(func(d manager.Delivery) {
+		recordDelivery(d, logDelivery, q)
+	})
 
+	return m
 }
 
 // initImporter initializes the bulk subscriber importer.
@@ -295,11 +444,187 @@ func initImporter(q *Queries, db *sqlx.DB, app *App) *subimporter.Importer {
 		}, db.DB)
 }
 
+// initWebhooks initializes the dispatcher that posts events to webhooks
+// and list webhooks. Deliveries that were being posted when the app was
+// stopped are requeued.
+func initWebhooks(q *Queries) *webhooks.Dispatcher {
+	if _, err := q.ResetWebhookDeliveries.Exec(); err != nil {
+		lo.Errorf("error resetting webhook deliveries: %v", err)
+	}
+
+	return webhooks.New(webhooks.Opt{
+		Workers:      2,
+		BatchSize:    100,
+		PollInterval: time.Second * 5,
+		Timeout:      time.Second * 5,
+		MaxAttempts:  6,
+		Backoff:      time.Second * 30,
+		MaxBackoff:   time.Hour,
+	}, newWebhooksDB(q), lo)
+}
+
+// initBounceManager initializes the bounce manager that scans the enabled
+// bounce mailboxes, processes e-mail service webhooks, and records bounces
+// against subscribers.
+func initBounceManager(app *App) *bounce.Manager {
+	if !ko.Bool("bounce.enabled") {
+		return nil
+	}
+
+	var mbs []mailbox.Opt
+	for _, item := range ko.Slices("bounce.mailboxes") {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		var o mailbox.Opt
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading bounce mailbox config: %v", err)
+		}
+		mbs = append(mbs, o)
+
+		lo.Infof("loaded bounce mailbox: %s (%s)", o.Host, o.Type)
+	}
+
+	var rules []bounce.Rule
+	if err := ko.UnmarshalWithConf("bounce.rules", &rules, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading bounce rules: %v", err)
+	}
+
+	b, err := bounce.New(bounce.Opt{
+		Mailboxes:  mbs,
+		Rules:      rules,
+		QueueSize:  1000,
+		SESEnabled: ko.Bool("bounce.ses_enabled"),
+
+		SESTopicArns: ko.Strings("bounce.ses_topic_arns"),
+
+		SendGridEnabled: ko.Bool("bounce.sendgrid_enabled"),
+		SendGridKey:     ko.String("bounce.sendgrid_key"),
+
+		MailgunEnabled: ko.Bool("bounce.mailgun_enabled"),
+		MailgunKey:     ko.String("bounce.mailgun_key"),
+
+		VERP: initVERP(),
+
+		RecordBounceCB: func(b models.Bounce) error {
+			return recordBounce(b, app)
+		},
+	}, lo)
+	if err != nil {
+		lo.Fatalf("error initializing bounce manager: %v", err)
+	}
+
+	return b
+}
+
+// initVERP initializes the VERP return addresses of campaign messages
+// if they're enabled.
+func initVERP() *bounce.VERP {
+	if !ko.Bool("bounce.verp_enabled") {
+		return nil
+	}
+
+	v, err := bounce.NewVERP(ko.String("bounce.verp_pattern"), ko.String("bounce.verp_domain"))
+	if err != nil {
+		lo.Fatalf("error initializing VERP: %v", err)
+	}
+	return v
+}
+
+// initAdminAllowlist initializes the IP allowlist of the admin UI and the API.
+func initAdminAllowlist() *ipAllowlist {
+	l, err := newIPAllowlist(ko.Strings("app.admin_allowed_ips"), ko.Strings("app.trusted_proxies"))
+	if err != nil {
+		lo.Fatalf("error loading admin IP allowlist: %v", err)
+	}
+	if len(l.allowed) > 0 {
+		lo.Infof("admin access restricted to %d IP network(s)", len(l.allowed))
+	}
+	return l
+}
+
+// initGeoIP opens the GeoIP DB for locating the IPs of tracking events if
+// one is configured.
+func initGeoIP(cs *constants) *geoip.DB {
+	if cs.GeoIPPath == "" {
+		return nil
+	}
+	db, err := geoip.Open(cs.GeoIPPath)
+	if err != nil {
+		lo.Fatalf("error opening GeoIP DB: %v", err)
+	}
+	lo.Infof("GeoIP DB: %s", cs.GeoIPPath)
+	return db
+}
+
+// initMJML initializes the MJML compiler if an mjml binary is configured.
+func initMJML(cs *constants) *mjml.Compiler {
+	if cs.MJMLPath == "" {
+		return nil
+	}
+	lo.Infof("MJML compiler: %s", cs.MJMLPath)
+	return mjml.New(cs.MJMLPath, time.Second*10)
+}
+
+// initScreenshot initializes the HTML to image renderer for template
+// thumbnails if a wkhtmltoimage binary is configured.
+func initScreenshot(cs *constants) *screenshot.Renderer {
+	if cs.WkhtmltoimagePath == "" {
+		return nil
+	}
+	lo.Infof("template thumbnail renderer: %s", cs.WkhtmltoimagePath)
+	return screenshot.New(cs.WkhtmltoimagePath, time.Second*20)
+}
+
+// initDKIM initializes the DKIM signer with the keys of the sending
+// domains, if there are any.
+func initDKIM() *dkim.Signer {
+	var keys []dkim.Key
+	if err := ko.UnmarshalWithConf("dkim", &keys, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading DKIM config: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	s, err := dkim.New(keys)
+	if err != nil {
+		lo.Fatalf("error loading DKIM keys: %v", err)
+	}
+	for _, k := range keys {
+		lo.Infof("loaded DKIM key: %s._domainkey.%s", k.Selector, k.Domain)
+	}
+	return s
+}
+
+// initMessageSigning initializes the S/MIME and PGP signer with the keys of
+// the sending identities, if there are any.
+func initMessageSigning() *mailsign.Signer {
+	var keys []mailsign.Key
+	if err := ko.UnmarshalWithConf("message_signing", &keys, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading message signing config: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	s, err := mailsign.New(keys)
+	if err != nil {
+		lo.Fatalf("error loading message signing keys: %v", err)
+	}
+	for _, k := range keys {
+		lo.Infof("loaded %s signing key: %s", k.Type, k.Identity)
+	}
+	return s
+}
+
 // initSMTPMessenger initializes the SMTP messenger.
-func initSMTPMessenger(m *manager.Manager) messenger.Messenger {
+func initSMTPMessenger(m *manager.Manager, dk *dkim.Signer, sg *mailsign.Signer) messenger.Messenger {
 	var (
 		mapKeys = ko.MapKeys("smtp")
 		servers = make([]email.Server, 0, len(mapKeys))
+		retry   messenger.Retry
 	)
 
 	items := ko.Slices("smtp")
@@ -318,9 +643,27 @@ func initSMTPMessenger(m *manager.Manager) messenger.Messenger {
 		if err := item.UnmarshalWithConf("", &s, koanf.UnmarshalConf{Tag: "json"}); err != nil {
 			lo.Fatalf("error reading SMTP config: %v", err)
 		}
+		s.DKIM = dk
+		s.Signer = sg
+
+		// The SMTP servers share the e-mail messenger and a failed message
+		// may be retried via any of them. The most lenient retry policy of
+		// the servers applies. Retries are made only by the manager and not
+		// by the server's pool as well, which would multiply the attempts.
+		s.MaxMessageRetries = 1
+		r := initMessengerRetry(item)
+		if r.MaxAttempts > retry.MaxAttempts {
+			retry.MaxAttempts = r.MaxAttempts
+		}
+		if r.Backoff > retry.Backoff {
+			retry.Backoff = r.Backoff
+		}
+		if r.MaxBackoff > retry.MaxBackoff {
+			retry.MaxBackoff = r.MaxBackoff
+		}
 
 		servers = append(servers, s)
-		lo.Printf("loaded email (SMTP) messenger: %s@%s",
+		lo.Infof("loaded email (SMTP) messenger: %s@%s",
 			item.String("username"), item.String("host"))
 	}
 	if len(servers) == 0 {
@@ -332,10 +675,298 @@ func initSMTPMessenger(m *manager.Manager) messenger.Messenger {
 	if err != nil {
 		lo.Fatalf("error loading e-mail messenger: %v", err)
 	}
+	msgr.RunHealthChecks(smtpHealthCheckInterval)
+	m.SetMessengerRetry(msgr.Name(), retry)
 
 	return msgr
 }
 
+// initMessengerRetry reads the retry policy of a messenger config block.
+func initMessengerRetry(item *koanf.Koanf) messenger.Retry {
+	var r messenger.Retry
+	if err := item.UnmarshalWithConf("", &r, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading messenger retry config: %v", err)
+	}
+
+	// Postback messengers had a fixed retry wait before backoff was configurable.
+	if r.Backoff == 0 {
+		r.Backoff = item.Duration("retry_wait")
+	}
+	return r
+}
+
+// initSESMessengers initializes and returns all the enabled
+// Amazon SES API messenger backends.
+func initSESMessengers(m *manager.Manager, dk *dkim.Signer, sg *mailsign.Signer) []messenger.Messenger {
+	items := ko.Slices("ses")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []messenger.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		// Read the SES config.
+		var (
+			name = item.String("name")
+			o    ses.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading SES config: %v", err)
+		}
+		o.DKIM = dk
+		o.Signer = sg
+
+		// Initialize the Messenger.
+		s, err := ses.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing SES messenger %s: %v", name, err)
+		}
+		out = append(out, s)
+		m.SetMessengerLimit(name, ratelimit.New(item.Int("message_rate"), item.Int("hourly_limit")))
+		m.SetMessengerRetry(name, initMessengerRetry(item))
+
+		lo.Infof("loaded SES messenger: %s (%s)", name, o.Region)
+	}
+
+	return out
+}
+
+// initSendGridMessengers initializes and returns all the enabled
+// SendGrid API messenger backends.
+func initSendGridMessengers(m *manager.Manager) []messenger.Messenger {
+	items := ko.Slices("sendgrid")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []messenger.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		// Read the SendGrid config.
+		var (
+			name = item.String("name")
+			o    sendgrid.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading SendGrid config: %v", err)
+		}
+
+		// Initialize the Messenger.
+		s, err := sendgrid.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing SendGrid messenger %s: %v", name, err)
+		}
+		out = append(out, s)
+		m.SetMessengerLimit(name, ratelimit.New(item.Int("message_rate"), item.Int("hourly_limit")))
+		m.SetMessengerRetry(name, initMessengerRetry(item))
+
+		lo.Infof("loaded SendGrid messenger: %s", name)
+	}
+
+	return out
+}
+
+// initMailgunMessengers initializes and returns all the enabled
+// Mailgun API messenger backends.
+func initMailgunMessengers(m *manager.Manager, dk *dkim.Signer, sg *mailsign.Signer) []messenger.Messenger {
+	items := ko.Slices("mailgun")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []messenger.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		// Read the Mailgun config.
+		var (
+			name = item.String("name")
+			o    mailgun.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading Mailgun config: %v", err)
+		}
+		o.DKIM = dk
+		o.Signer = sg
+
+		// Initialize the Messenger.
+		g, err := mailgun.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing Mailgun messenger %s: %v", name, err)
+		}
+		out = append(out, g)
+		m.SetMessengerLimit(name, ratelimit.New(item.Int("message_rate"), item.Int("hourly_limit")))
+		m.SetMessengerRetry(name, initMessengerRetry(item))
+
+		lo.Infof("loaded Mailgun messenger: %s (%s)", name, o.Domain)
+	}
+
+	return out
+}
+
+// initPostmarkMessengers initializes and returns all the enabled
+// Postmark API messenger backends.
+func initPostmarkMessengers(m *manager.Manager) []messenger.Messenger {
+	items := ko.Slices("postmark")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []messenger.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		// Read the Postmark config.
+		var (
+			name = item.String("name")
+			o    postmark.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading Postmark config: %v", err)
+		}
+
+		// Initialize the Messenger.
+		p, err := postmark.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing Postmark messenger %s: %v", name, err)
+		}
+		out = append(out, p)
+		m.SetMessengerLimit(name, ratelimit.New(item.Int("message_rate"), item.Int("hourly_limit")))
+		m.SetMessengerRetry(name, initMessengerRetry(item))
+
+		lo.Infof("loaded Postmark messenger: %s", name)
+	}
+
+	return out
+}
+
+// initSparkPostMessengers initializes and returns all the enabled
+// SparkPost API messenger backends.
+func initSparkPostMessengers(m *manager.Manager) []messenger.Messenger {
+	items := ko.Slices("sparkpost")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []messenger.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		// Read the SparkPost config.
+		var (
+			name = item.String("name")
+			o    sparkpost.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading SparkPost config: %v", err)
+		}
+
+		// Initialize the Messenger.
+		s, err := sparkpost.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing SparkPost messenger %s: %v", name, err)
+		}
+		out = append(out, s)
+		m.SetMessengerLimit(name, ratelimit.New(item.Int("message_rate"), item.Int("hourly_limit")))
+		m.SetMessengerRetry(name, initMessengerRetry(item))
+
+		lo.Infof("loaded SparkPost messenger: %s", name)
+	}
+
+	return out
+}
+
+// initSendmailMessengers initializes and returns all the enabled
+// local sendmail binary messenger backends.
+func initSendmailMessengers(m *manager.Manager, dk *dkim.Signer, sg *mailsign.Signer) []messenger.Messenger {
+	items := ko.Slices("sendmail")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []messenger.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		// Read the sendmail config.
+		var (
+			name = item.String("name")
+			o    sendmail.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading sendmail config: %v", err)
+		}
+		o.DKIM = dk
+		o.Signer = sg
+
+		// Initialize the Messenger.
+		s, err := sendmail.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing sendmail messenger %s: %v", name, err)
+		}
+		out = append(out, s)
+		m.SetMessengerLimit(name, ratelimit.New(item.Int("message_rate"), item.Int("hourly_limit")))
+		m.SetMessengerRetry(name, initMessengerRetry(item))
+
+		lo.Infof("loaded sendmail messenger: %s (%s)", name, o.Path)
+	}
+
+	return out
+}
+
+// initSMSMessengers initializes and returns all the enabled
+// SMS messenger backends.
+func initSMSMessengers(m *manager.Manager) []messenger.Messenger {
+	items := ko.Slices("sms")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []messenger.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		// Read the SMS config.
+		var (
+			name = item.String("name")
+			o    sms.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading SMS config: %v", err)
+		}
+
+		// Initialize the Messenger.
+		s, err := sms.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing SMS messenger %s: %v", name, err)
+		}
+		out = append(out, s)
+		m.SetMessengerLimit(name, ratelimit.New(item.Int("message_rate"), item.Int("hourly_limit")))
+		m.SetMessengerRetry(name, initMessengerRetry(item))
+
+		lo.Infof("loaded SMS messenger: %s (%s)", name, o.Provider)
+	}
+
+	return out
+}
+
 // initPostbackMessengers initializes and returns all the enabled
 // HTTP postback messenger backends.
 func initPostbackMessengers(m *manager.Manager) []messenger.Messenger {
@@ -365,8 +996,10 @@ func initPostbackMessengers(m *manager.Manager) []messenger.Messenger {
 			lo.Fatalf("error initializing Postback messenger %s: %v", name, err)
 		}
 		out = append(out, p)
+		m.SetMessengerLimit(name, ratelimit.New(item.Int("message_rate"), item.Int("hourly_limit")))
+		m.SetMessengerRetry(name, initMessengerRetry(item))
 
-		lo.Printf("loaded Postback messenger: %s", name)
+		lo.Infof("loaded Postback messenger: %s", name)
 	}
 
 	return out
@@ -382,7 +1015,7 @@ func initMediaStore() media.Store {
 		if err != nil {
 			lo.Fatalf("error initializing s3 upload provider %s", err)
 		}
-		lo.Println("media upload provider: s3")
+		lo.Infof("media upload provider: s3")
 		return up
 
 	case "filesystem":
@@ -396,7 +1029,7 @@ func initMediaStore() media.Store {
 		if err != nil {
 			lo.Fatalf("error initializing filesystem upload provider %s", err)
 		}
-		lo.Println("media upload provider: filesystem")
+		lo.Infof("media upload provider: filesystem")
 		return up
 
 	default:
@@ -430,6 +1063,12 @@ func initHTTPServer(app *App) *echo.Echo {
 	var srv = echo.New()
 	srv.HideBanner = true
 
+	// Write errors with machine-readable codes.
+	srv.HTTPErrorHandler = handleHTTPError
+
+	// Serve the versioned API paths.
+	srv.Pre(versionAPI(app.constants))
+
 	// Register app (*App) to be injected into all HTTP handlers.
 	srv.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -437,6 +1076,27 @@ func initHTTPServer(app *App) *echo.Echo {
 			return next(c)
 		}
 	})
+	srv.Use(requestID)
+
+	// Apply the CORS policy to the public subscription and API endpoints so
+	// that they can be called from other origins, eg: JS widgets. CORS is
+	// disabled if there are no allowed origins.
+	if len(app.constants.CORSOrigins) > 0 {
+		srv.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			Skipper: func(c echo.Context) bool {
+				p := c.Request().URL.Path
+				return !strings.HasPrefix(p, "/api/") && !strings.HasPrefix(p, "/subscription/")
+			},
+			AllowOrigins: app.constants.CORSOrigins,
+			AllowMethods: app.constants.CORSMethods,
+			AllowHeaders: app.constants.CORSHeaders,
+			ExposeHeaders: []string{
+				"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+				"ETag", "Last-Modified", "API-Version", "Deprecation", "Sunset", "Link",
+				headerRequestID,
+			},
+		}))
+	}
 
 	// Parse and load user facing templates.
 	tpl, err := stuffbin.ParseTemplatesGlob(nil, app.fs, "/public/templates/*.html")
@@ -465,7 +1125,7 @@ func initHTTPServer(app *App) *echo.Echo {
 	go func() {
 		if err := srv.Start(ko.String("app.address")); err != nil {
 			if strings.Contains(err.Error(), "Server closed") {
-				lo.Println("HTTP server shut down")
+				lo.Infof("HTTP server shut down")
 			} else {
 				lo.Fatalf("error starting HTTP server: %v", err)
 			}
@@ -490,7 +1150,7 @@ func awaitReload(sigChan chan os.Signal, closerWait chan bool, closer func()) ch
 	// Listen for reload signal.
 	go func() {
 		for range sigChan {
-			lo.Println("reloading on signal ...")
+			lo.Infof("reloading on signal ...")
 
 			go closer()
 			select {
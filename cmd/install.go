@@ -62,6 +62,23 @@ func install(lastVer string, db *sqlx.DB, fs stuffbin.FileSystem, prompt bool) {
 		models.ListTypePrivate,
 		models.ListOptinSingle,
 		pq.StringArray{"test"},
+		"",
+		0,
+		"",
+		"",
+		"",
+		false,
+		"",
+		"",
+		"",
+		"",
+		0,
+		"",
+		"",
+		models.ListFields{},
+		"",
+		0,
+		0,
 	); err != nil {
 		lo.Fatalf("Error creating list: %v", err)
 	}
@@ -71,6 +88,23 @@ func install(lastVer string, db *sqlx.DB, fs stuffbin.FileSystem, prompt bool) {
 		models.ListTypePublic,
 		models.ListOptinDouble,
 		pq.StringArray{"test"},
+		"",
+		0,
+		"",
+		"",
+		"",
+		false,
+		"",
+		"",
+		"",
+		"",
+		0,
+		"",
+		"",
+		models.ListFields{},
+		"",
+		0,
+		0,
 	); err != nil {
 		lo.Fatalf("Error creating list: %v", err)
 	}
@@ -105,12 +139,21 @@ func install(lastVer string, db *sqlx.DB, fs stuffbin.FileSystem, prompt bool) {
 	if err := q.CreateTemplate.Get(&tplID,
 		"Default template",
 		string(tplBody),
+		"",
+		models.TemplateTypeCampaign,
+		"",
+		models.TemplateVariables{},
+		"",
+		pq.StringArray{},
 	); err != nil {
 		lo.Fatalf("error creating default template: %v", err)
 	}
 	if _, err := q.SetDefaultTemplate.Exec(tplID); err != nil {
 		lo.Fatalf("error setting default template: %v", err)
 	}
+	if _, err := q.InsertTplRevision.Exec(tplID, ""); err != nil {
+		lo.Fatalf("error recording template revision: %v", err)
+	}
 
 	// Sample campaign.
 	if _, err := q.CreateCampaign.Exec(uuid.Must(uuid.NewV4()),
@@ -126,12 +169,16 @@ func install(lastVer string, db *sqlx.DB, fs stuffbin.FileSystem, prompt bool) {
 		emailMsgr,
 		1,
 		pq.Int64Array{1},
+		"",
+		"",
+		models.TemplateVarValues{},
+		true,
 	); err != nil {
 		lo.Fatalf("error creating sample campaign: %v", err)
 	}
 
-	lo.Printf("Setup complete")
-	lo.Printf(`Run the program and access the dashboard at %s`, ko.MustString("app.address"))
+	lo.Infof("Setup complete")
+	lo.Infof(`Run the program and access the dashboard at %s`, ko.MustString("app.address"))
 }
 
 // installSchema executes the SQL schema and creates the necessary tables and types.
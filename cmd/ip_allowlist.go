@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// ipAllowlist is the list of networks that are allowed to access the admin
+// UI and the API. Public subscription pages and tracking aren't affected.
+type ipAllowlist struct {
+	allowed []*net.IPNet
+
+	// Reverse proxies whose X-Forwarded-For and X-Real-IP headers are
+	// trusted for the client IP. The headers of other clients are ignored
+	// as they can be spoofed to get around the allowlist.
+	proxies []*net.IPNet
+}
+
+// newIPAllowlist returns an allowlist of the given IPs and CIDR networks.
+// An empty allowlist allows all IPs.
+func newIPAllowlist(allowed, proxies []string) (*ipAllowlist, error) {
+	a, err := parseIPNets(allowed)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseIPNets(proxies)
+	if err != nil {
+		return nil, err
+	}
+	return &ipAllowlist{allowed: a, proxies: p}, nil
+}
+
+// parseIPNets parses a list of CIDR networks. Single IPs are parsed as
+// networks of one IP.
+func parseIPNets(vals []string) ([]*net.IPNet, error) {
+	out := make([]*net.IPNet, 0, len(vals))
+	for _, v := range vals {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP: %s", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR network: %s", v)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// allows checks whether a request is from an allowed IP.
+func (l *ipAllowlist) allows(r *http.Request) bool {
+	if len(l.allowed) == 0 {
+		return true
+	}
+	ip := l.clientIP(r)
+	return ip != nil && ipInNets(ip, l.allowed)
+}
+
+// clientIP returns the IP of the client of a request. If the request is
+// from a trusted proxy, it's the last IP in X-Forwarded-For that isn't
+// a trusted proxy, or X-Real-IP.
+func (l *ipAllowlist) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ipInNets(ip, l.proxies) {
+		return ip
+	}
+
+	if v := r.Header.Get(echo.HeaderXForwardedFor); v != "" {
+		hops := strings.Split(v, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			h := net.ParseIP(strings.TrimSpace(hops[i]))
+			if h == nil {
+				return nil
+			}
+			ip = h
+			if !ipInNets(h, l.proxies) {
+				break
+			}
+		}
+		return ip
+	}
+
+	if v := r.Header.Get(echo.HeaderXRealIP); v != "" {
+		return net.ParseIP(strings.TrimSpace(v))
+	}
+	return ip
+}
+
+// ipInNets checks whether an IP is in one of the given networks.
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllowedIP middleware rejects requests from IPs that aren't in the
+// admin IP allowlist.
+func checkAllowedIP(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		app := c.Get("app").(*App)
+		if !app.adminAllowlist.allows(c.Request()) {
+			return newHTTPError(http.StatusForbidden, errCodeForbidden,
+				"Access from this IP is not allowed.")
+		}
+		return next(c)
+	}
+}
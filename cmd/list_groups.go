@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// handleGetListGroups handles retrieval of list groups.
+func handleGetListGroups(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		out   []models.ListGroup
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if err := app.queries.GetListGroups.Select(&out, id); err != nil {
+		getLogger(c).Errorf("error fetching list groups: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching list groups: %s", pqErrMsg(err)))
+	}
+
+	if id > 0 {
+		if len(out) == 0 {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "List group not found.")
+		}
+		return c.JSON(http.StatusOK, okResp{out[0]})
+	}
+
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateListGroup handles list group creation.
+func handleCreateListGroup(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		o   = models.ListGroup{}
+	)
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return newFieldError("name", "Invalid length for the name field.")
+	}
+
+	var newID int
+	if err := app.queries.CreateListGroup.Get(&newID, o.Name); err != nil {
+		getLogger(c).Errorf("error creating list group: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating list group: %s", pqErrMsg(err)))
+	}
+
+	// Hand over to the GET handler to return the last insertion.
+	return handleGetListGroups(copyEchoCtx(c, map[string]string{
+		"id": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// handleUpdateListGroup handles list group modification.
+func handleUpdateListGroup(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	var o models.ListGroup
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return newFieldError("name", "Invalid length for the name field.")
+	}
+
+	res, err := app.queries.UpdateListGroup.Exec(id, o.Name)
+	if err != nil {
+		getLogger(c).Errorf("error updating list group: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating list group: %s", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "List group not found.")
+	}
+
+	return handleGetListGroups(c)
+}
+
+// handleDeleteListGroup handles list group deletion. Lists in the group
+// are not deleted and are merely ungrouped.
+func handleDeleteListGroup(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if _, err := app.queries.DeleteListGroup.Exec(id); err != nil {
+		getLogger(c).Errorf("error deleting list group: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error deleting list group: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// getListIDsByGroups returns the IDs of all the lists in the given groups
+// merged with the given list IDs.
+func getListIDsByGroups(listIDs, groupIDs []int64, app *App) ([]int64, error) {
+	if len(groupIDs) == 0 {
+		return listIDs, nil
+	}
+
+	var ids []int64
+	if err := app.queries.GetListIDsByGroups.Select(&ids, pq.Int64Array(groupIDs)); err != nil {
+		app.log.Errorf("error fetching list group lists: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching list group lists: %s", pqErrMsg(err)))
+	}
+
+	// Merge and dedupe the IDs.
+	var (
+		out  = make([]int64, 0, len(listIDs)+len(ids))
+		seen = make(map[int64]bool)
+	)
+	for _, id := range append(listIDs, ids...) {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
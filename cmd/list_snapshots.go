@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Interval at which the day's snapshot of list subscription counts is
+// recorded. The snapshot is updated through the day so that the last one
+// of the day has its closing counts.
+const listSnapshotInterval = time.Hour
+
+// listSnapshot represents the subscription counts of a list by status on
+// a day. NetChange is the change in the subscribers who haven't
+// unsubscribed since the previous snapshot.
+type listSnapshot struct {
+	Date         string `db:"date" json:"date"`
+	Unconfirmed  int    `db:"unconfirmed" json:"unconfirmed"`
+	Confirmed    int    `db:"confirmed" json:"confirmed"`
+	Unsubscribed int    `db:"unsubscribed" json:"unsubscribed"`
+	NetChange    int    `db:"net_change" json:"net_change"`
+}
+
+// handleGetListSnapshots handles retrieval of the daily snapshots of a
+// list's subscription counts between the `from` and `to` dates, which
+// default to the last 30 days.
+func handleGetListSnapshots(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		to    = time.Now()
+		from  = to.AddDate(0, 0, -30)
+		out   []listSnapshot
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if v := c.FormValue("from"); v != "" {
+		t, err := time.Parse(dateFormat, v)
+		if err != nil {
+			return newFieldError("from", "Invalid `from` date.")
+		}
+		from = t
+	}
+	if v := c.FormValue("to"); v != "" {
+		t, err := time.Parse(dateFormat, v)
+		if err != nil {
+			return newFieldError("to", "Invalid `to` date.")
+		}
+		to = t
+	}
+	if from.After(to) || to.Sub(from) > listStatsMaxRange {
+		return newFieldError("from", "Invalid date range.")
+	}
+
+	if err := app.queries.GetListSnapshots.Select(&out, id,
+		from.Format(dateFormat), to.Format(dateFormat)); err != nil {
+		getLogger(c).Errorf("error fetching list snapshots: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching list stats: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// recordListSnapshots is a blocking function that records the day's
+// snapshot of the subscription counts of all lists at intervals.
+func recordListSnapshots(app *App) {
+	ticker := time.NewTicker(listSnapshotInterval)
+	for ; true; <-ticker.C {
+		if _, err := app.queries.RecordListSnapshots.Exec(); err != nil {
+			app.log.Errorf("error recording list snapshots: %v", err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/internal/events"
+	"github.com/knadh/listmonk/internal/webhooks"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+type listWebhookReq struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Secret  string   `json:"secret"`
+	Enabled bool     `json:"enabled"`
+}
+
+// listWebhookEvent is the data that's posted to list webhooks.
+type listWebhookEvent struct {
+	List       listWebhookList   `json:"list"`
+	Subscriber models.Subscriber `json:"subscriber"`
+}
+
+type listWebhookList struct {
+	ID   int    `json:"id"`
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// handleGetListWebhooks handles retrieval of the webhooks of a list.
+func handleGetListWebhooks(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		out       []models.ListWebhook
+		listID, _ = strconv.Atoi(c.Param("id"))
+		hookID, _ = strconv.Atoi(c.Param("hookID"))
+	)
+
+	if listID < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetListWebhooks.Select(&out, listID); err != nil {
+		getLogger(c).Errorf("error fetching list webhooks: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching list webhooks: %s", pqErrMsg(err)))
+	}
+
+	// Single webhook.
+	if hookID > 0 {
+		for _, h := range out {
+			if h.ID == hookID {
+				return c.JSON(http.StatusOK, okResp{h})
+			}
+		}
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Webhook not found.")
+	}
+
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateListWebhook handles the creation of a list webhook.
+func handleCreateListWebhook(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		listID, _ = strconv.Atoi(c.Param("id"))
+		o         = listWebhookReq{Enabled: true}
+	)
+
+	if listID < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if err := validateListWebhook(o); err != nil {
+		return err
+	}
+
+	var newID int
+	if err := app.queries.CreateListWebhook.Get(&newID, listID, o.URL,
+		pq.StringArray(o.Events), o.Secret, o.Enabled); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "list_webhooks_list_id_fkey" {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "List not found.")
+		}
+
+		getLogger(c).Errorf("error creating list webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating list webhook: %s", pqErrMsg(err)))
+	}
+
+	// Hand over to the GET handler to return the last insertion.
+	return handleGetListWebhooks(copyEchoCtx(c, map[string]string{
+		"id":     c.Param("id"),
+		"hookID": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// handleUpdateListWebhook handles the modification of a list webhook.
+// An empty secret retains the existing one.
+func handleUpdateListWebhook(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		listID, _ = strconv.Atoi(c.Param("id"))
+		hookID, _ = strconv.Atoi(c.Param("hookID"))
+	)
+
+	if listID < 1 || hookID < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	var o listWebhookReq
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if err := validateListWebhook(o); err != nil {
+		return err
+	}
+
+	res, err := app.queries.UpdateListWebhook.Exec(hookID, listID, o.URL,
+		pq.StringArray(o.Events), o.Secret, o.Enabled)
+	if err != nil {
+		getLogger(c).Errorf("error updating list webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating list webhook: %s", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Webhook not found.")
+	}
+
+	return handleGetListWebhooks(c)
+}
+
+// handleDeleteListWebhook handles the deletion of a list webhook.
+func handleDeleteListWebhook(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		listID, _ = strconv.Atoi(c.Param("id"))
+		hookID, _ = strconv.Atoi(c.Param("hookID"))
+	)
+
+	if listID < 1 || hookID < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if _, err := app.queries.DeleteListWebhook.Exec(hookID, listID); err != nil {
+		getLogger(c).Errorf("error deleting list webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error deleting list webhook: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// validateListWebhook validates a list webhook's URL and events.
+func validateListWebhook(o listWebhookReq) error {
+	return validateHook(o.URL, o.Events, o.Secret, webhooks.ListEvents)
+}
+
+// pushListWebhooks queues an event for the webhooks of the given lists
+// (by IDs, or UUIDs if there are no IDs) and the webhooks that are
+// subscribed to it, and publishes it to the admin event stream.
+// Errors are only logged as webhooks shouldn't hold up subscriptions.
+func pushListWebhooks(event string, sub models.Subscriber, listIDs []int64, listUUIDs []string, app *App) {
+	if len(listIDs) == 0 && len(listUUIDs) == 0 {
+		return
+	}
+
+	ev := subscriptionEvent{
+		Event:      event,
+		Subscriber: sub,
+		ListIDs:    listIDs,
+		ListUUIDs:  listUUIDs,
+	}
+	app.events.Publish(events.TypeSubscription, ev)
+	pushWebhooks(event, ev, app)
+
+	var hooks []models.ListWebhook
+	if err := app.queries.GetListWebhooksByEvent.Select(&hooks, event,
+		pq.Int64Array(listIDs), pq.StringArray(listUUIDs)); err != nil {
+		app.log.Errorf("error fetching list webhooks: %v", err)
+		return
+	}
+
+	for _, h := range hooks {
+		data := listWebhookEvent{
+			List:       listWebhookList{ID: h.ListID, UUID: h.ListUUID, Name: h.ListName},
+			Subscriber: sub,
+		}
+		if err := app.webhooks.Push([]webhooks.Hook{{ID: h.ID, Kind: hookKindList, URL: h.URL}}, event, data); err != nil {
+			app.log.Errorf("error queueing list webhook: %v", err)
+		}
+	}
+}
+
+// pushListWebhooksByUUID is pushListWebhooks for when only the subscriber's
+// UUID is known, such as on the public pages.
+func pushListWebhooksByUUID(event string, subUUID string, listIDs []int64, app *App) {
+	if len(listIDs) == 0 {
+		return
+	}
+
+	var out models.Subscribers
+	if err := app.queries.GetSubscriber.Select(&out, 0, subUUID); err != nil {
+		app.log.Errorf("error fetching subscriber: %v", err)
+		return
+	}
+	if len(out) == 0 {
+		return
+	}
+
+	pushListWebhooks(event, out[0], listIDs, nil, app)
+}
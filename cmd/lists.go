@@ -1,13 +1,20 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
+	"html/template"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 
 	"github.com/labstack/echo"
 )
@@ -20,8 +27,61 @@ type listsWrap struct {
 	Page    int `json:"page"`
 }
 
+// waitlistedSub represents a subscriber waitlisted on a list.
+type waitlistedSub struct {
+	models.Subscriber
+
+	WaitlistedAt null.Time `db:"waitlisted_at" json:"waitlisted_at"`
+}
+
+type waitlistWrap struct {
+	Results []waitlistedSub `json:"results"`
+
+	Total   int `json:"total"`
+	PerPage int `json:"per_page"`
+	Page    int `json:"page"`
+}
+
+// listGrowthStat represents the subscription counts of a list on a given day.
+type listGrowthStat struct {
+	Date            string `db:"date" json:"date"`
+	Subscriptions   int    `db:"subscriptions" json:"subscriptions"`
+	Confirmations   int    `db:"confirmations" json:"confirmations"`
+	Unsubscriptions int    `db:"unsubscriptions" json:"unsubscriptions"`
+}
+
+// listCloneReq represents the params for cloning a list.
+type listCloneReq struct {
+	Name               string `json:"name"`
+	IncludeSubscribers bool   `json:"include_subscribers"`
+}
+
+// listArchiveReq represents the params for archiving or unarchiving a list.
+type listArchiveReq struct {
+	Archived bool `json:"archived"`
+}
+
+// listMergeReq represents the params for merging a list into another.
+type listMergeReq struct {
+	SourceID int `json:"source_id"`
+}
+
+const (
+	dateFormat = "2006-01-02"
+
+	// listStatsMaxRange is the maximum date range for list statistics.
+	listStatsMaxRange = time.Hour * 24 * 366
+
+	// Interval at which waitlisted subscribers are moved into lists
+	// that have space.
+	listWaitlistInterval = time.Minute
+)
+
 var (
 	listQuerySortFields = []string{"name", "type", "subscriber_count", "created_at", "updated_at"}
+
+	listUnsubActions = []string{models.ListUnsubActionList, models.ListUnsubActionAll,
+		models.ListUnsubActionBlocklist}
 )
 
 // handleGetLists handles retrieval of lists.
@@ -30,11 +90,15 @@ func handleGetLists(c echo.Context) error {
 		app = c.Get("app").(*App)
 		out listsWrap
 
-		pg        = getPagination(c.QueryParams(), 20, 50)
-		orderBy   = c.FormValue("order_by")
-		order     = c.FormValue("order")
-		listID, _ = strconv.Atoi(c.Param("id"))
-		single    = false
+		pg         = getPagination(c.QueryParams(), 20, 50)
+		orderBy    = c.FormValue("order_by")
+		order      = c.FormValue("order")
+		listID, _  = strconv.Atoi(c.Param("id"))
+		groupID, _ = strconv.Atoi(c.FormValue("group_id"))
+		archived   = c.FormValue("archived")
+		query      = strings.TrimSpace(c.FormValue("query"))
+		tags       = c.QueryParams()["tag"]
+		single     = false
 	)
 
 	// Fetch one list.
@@ -42,6 +106,43 @@ func handleGetLists(c echo.Context) error {
 		single = true
 	}
 
+	res, err := queryLists(listID, groupID, archived, query, tags, orderBy, order, pg, app)
+	if err != nil {
+		return err
+	}
+	if single && len(res) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "List not found.")
+	}
+	if len(res) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	out.Results = res
+
+	if single {
+		return c.JSON(http.StatusOK, okResp{out.Results[0]})
+	}
+
+	// Meta.
+	out.Total = out.Results[0].Total
+	out.Page = pg.Page
+	out.PerPage = pg.PerPage
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// queryLists returns a page of lists, optionally filtered by ID, group,
+// archive status, a name search query, and tags.
+func queryLists(listID, groupID int, archived, query string, tags []string, orderBy, order string,
+	pg pagination, app *App) ([]models.List, error) {
+	var out []models.List
+
+	// Search by name.
+	if query != "" {
+		query = "%" + query + "%"
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
 	// Sort params.
 	if !strSliceContains(orderBy, listQuerySortFields) {
 		orderBy = "created_at"
@@ -50,34 +151,21 @@ func handleGetLists(c echo.Context) error {
 		order = sortAsc
 	}
 
-	if err := db.Select(&out.Results, fmt.Sprintf(app.queries.GetLists, orderBy, order), listID, pg.Offset, pg.Limit); err != nil {
-		app.log.Printf("error fetching lists: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
+	if err := db.Select(&out, fmt.Sprintf(app.queries.GetLists, orderBy, order),
+		listID, pg.Offset, pg.Limit, groupID, archived, query, pq.StringArray(tags)); err != nil {
+		app.log.Errorf("error fetching lists: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching lists: %s", pqErrMsg(err)))
 	}
-	if single && len(out.Results) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "List not found.")
-	}
-	if len(out.Results) == 0 {
-		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
-	}
 
 	// Replace null tags.
-	for i, v := range out.Results {
+	for i, v := range out {
 		if v.Tags == nil {
-			out.Results[i].Tags = make(pq.StringArray, 0)
+			out[i].Tags = make(pq.StringArray, 0)
 		}
 	}
 
-	if single {
-		return c.JSON(http.StatusOK, okResp{out.Results[0]})
-	}
-
-	// Meta.
-	out.Total = out.Results[0].Total
-	out.Page = pg.Page
-	out.PerPage = pg.PerPage
-	return c.JSON(http.StatusOK, okResp{out})
+	return out, nil
 }
 
 // handleCreateList handles list creation.
@@ -93,13 +181,42 @@ func handleCreateList(c echo.Context) error {
 
 	// Validate.
 	if !strHasLen(o.Name, 1, stdInputMaxLen) {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			"Invalid length for the name field.")
+		return newFieldError("name", "Invalid length for the name field.")
+	}
+	if err := validateListQuery(&o, app); err != nil {
+		return err
+	}
+	if err := validateListSender(o); err != nil {
+		return err
+	}
+	if o.UnsubAction != "" && !strSliceContains(o.UnsubAction, listUnsubActions) {
+		return newFieldError("unsub_action", "Invalid `unsub_action`.")
+	}
+	if o.MaxSubscribers < 0 {
+		return newFieldError("max_subscribers", "Invalid `max_subscribers`.")
+	}
+	if o.MaxCampaigns < 0 || o.MaxCampaignsDays < 0 {
+		return newFieldError("max_campaigns", "Invalid send frequency.")
+	}
+	if o.CapAction != "" && o.CapAction != models.ListCapActionReject &&
+		o.CapAction != models.ListCapActionWaitlist {
+		return newFieldError("cap_action", "Invalid `cap_action`.")
+	}
+	for _, f := range o.Fields {
+		if !strHasLen(f.Key, 1, stdInputMaxLen) {
+			return newFieldError("fields", "Invalid field `key`.")
+		}
+	}
+	if o.OptinEmailBody != "" {
+		if _, err := compileListOptinTpl(o.OptinEmailBody, app); err != nil {
+			return newFieldError("optin_email_body",
+				fmt.Sprintf("Error compiling opt-in e-mail body: %v", err))
+		}
 	}
 
 	uu, err := uuid.NewV4()
 	if err != nil {
-		app.log.Printf("error generating UUID: %v", err)
+		getLogger(c).Errorf("error generating UUID: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Error generating UUID")
 	}
 
@@ -111,12 +228,38 @@ func handleCreateList(c echo.Context) error {
 		o.Name,
 		o.Type,
 		o.Optin,
-		pq.StringArray(normalizeTags(o.Tags))); err != nil {
-		app.log.Printf("error creating list: %v", err)
+		pq.StringArray(normalizeTags(o.Tags)),
+		o.Query,
+		o.GroupID.Int,
+		o.FromEmail,
+		o.ReplyTo,
+		o.UnsubAction,
+		o.ShowInPrefs,
+		o.OptinEmailSubject,
+		o.OptinEmailBody,
+		o.OptinPageMessage,
+		o.OptinConfirmMessage,
+		o.MaxSubscribers,
+		o.CapAction,
+		o.CapMessage,
+		o.Fields,
+		o.Description,
+		o.MaxCampaigns,
+		o.MaxCampaignsDays); err != nil {
+		getLogger(c).Errorf("error creating list: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error creating list: %s", pqErrMsg(err)))
 	}
 
+	// Populate the dynamic list's subscriptions.
+	if o.Query != "" {
+		if err := syncDynamicList(newID, o.Query, app.queries, app.db); err != nil {
+			getLogger(c).Errorf("error syncing dynamic list: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error syncing dynamic list: %s", pqErrMsg(err)))
+		}
+	}
+
 	// Hand over to the GET handler to return the last insertion.
 	return handleGetLists(copyEchoCtx(c, map[string]string{
 		"id": fmt.Sprintf("%d", newID),
@@ -131,7 +274,7 @@ func handleUpdateList(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	// Incoming params.
@@ -139,17 +282,314 @@ func handleUpdateList(c echo.Context) error {
 	if err := c.Bind(&o); err != nil {
 		return err
 	}
+	if err := validateListQuery(&o, app); err != nil {
+		return err
+	}
+	if err := validateListSender(o); err != nil {
+		return err
+	}
+	if o.UnsubAction != "" && !strSliceContains(o.UnsubAction, listUnsubActions) {
+		return newFieldError("unsub_action", "Invalid `unsub_action`.")
+	}
+	if o.MaxSubscribers < 0 {
+		return newFieldError("max_subscribers", "Invalid `max_subscribers`.")
+	}
+	if o.MaxCampaigns < 0 || o.MaxCampaignsDays < 0 {
+		return newFieldError("max_campaigns", "Invalid send frequency.")
+	}
+	if o.CapAction != "" && o.CapAction != models.ListCapActionReject &&
+		o.CapAction != models.ListCapActionWaitlist {
+		return newFieldError("cap_action", "Invalid `cap_action`.")
+	}
+	for _, f := range o.Fields {
+		if !strHasLen(f.Key, 1, stdInputMaxLen) {
+			return newFieldError("fields", "Invalid field `key`.")
+		}
+	}
+	if o.OptinEmailBody != "" {
+		if _, err := compileListOptinTpl(o.OptinEmailBody, app); err != nil {
+			return newFieldError("optin_email_body",
+				fmt.Sprintf("Error compiling opt-in e-mail body: %v", err))
+		}
+	}
 
 	res, err := app.queries.UpdateList.Exec(id,
-		o.Name, o.Type, o.Optin, pq.StringArray(normalizeTags(o.Tags)))
+		o.Name, o.Type, o.Optin, pq.StringArray(normalizeTags(o.Tags)), o.Query, o.GroupID.Int,
+		o.FromEmail, o.ReplyTo, o.UnsubAction, o.ShowInPrefs,
+		o.OptinEmailSubject, o.OptinEmailBody, o.OptinPageMessage, o.OptinConfirmMessage,
+		o.MaxSubscribers, o.CapAction, o.CapMessage, o.Fields, o.Description,
+		o.MaxCampaigns, o.MaxCampaignsDays)
 	if err != nil {
-		app.log.Printf("error updating list: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
+		getLogger(c).Errorf("error updating list: %v", err)
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			fmt.Sprintf("Error updating list: %s", pqErrMsg(err)))
 	}
 
 	if n, _ := res.RowsAffected(); n == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "List not found.")
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "List not found.")
+	}
+
+	// Refresh the dynamic list's subscriptions.
+	if o.Query != "" {
+		if err := syncDynamicList(id, o.Query, app.queries, app.db); err != nil {
+			getLogger(c).Errorf("error syncing dynamic list: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error syncing dynamic list: %s", pqErrMsg(err)))
+		}
+	}
+
+	// The cap may have been raised or removed.
+	if err := promoteWaitlistedSubscribers(app); err != nil {
+		getLogger(c).Errorf("error promoting waitlisted subscribers: %v", err)
+	}
+
+	return handleGetLists(c)
+}
+
+// handleGetListWaitlist returns the subscribers waitlisted on a list
+// that has reached its subscriber cap.
+func handleGetListWaitlist(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		pg    = getPagination(c.QueryParams(), 20, 100)
+		out   waitlistWrap
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetListWaitlist.Select(&out.Results, id, pg.Offset, pg.Limit); err != nil {
+		getLogger(c).Errorf("error fetching list waitlist: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching list waitlist: %s", pqErrMsg(err)))
+	}
+
+	if len(out.Results) == 0 {
+		out.Results = make([]waitlistedSub, 0)
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	out.Total = out.Results[0].Total
+	out.Page = pg.Page
+	out.PerPage = pg.PerPage
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// promoteListWaitlists is a blocking function that moves waitlisted
+// subscribers into lists as space frees up under their caps at intervals.
+func promoteListWaitlists(app *App) {
+	ticker := time.NewTicker(listWaitlistInterval)
+	for ; true; <-ticker.C {
+		if err := promoteWaitlistedSubscribers(app); err != nil {
+			app.log.Errorf("error promoting waitlisted subscribers: %v", err)
+		}
+	}
+}
+
+// promoteWaitlistedSubscribers moves waitlisted subscribers, earliest first,
+// into the lists that have space and sends the opt-in confirmations of the
+// double opt-in ones.
+func promoteWaitlistedSubscribers(app *App) error {
+	var res []struct {
+		SubscriberID int   `db:"subscriber_id"`
+		ListID       int64 `db:"list_id"`
+	}
+	if err := app.queries.PromoteListWaitlists.Select(&res); err != nil {
+		return err
+	}
+
+	subLists := make(map[int][]int64)
+	for _, r := range res {
+		subLists[r.SubscriberID] = append(subLists[r.SubscriberID], r.ListID)
+	}
+	for id, listIDs := range subLists {
+		sub, err := getSubscriber(id, app)
+		if err != nil {
+			continue
+		}
+		sendOptinConfirmation(sub, listIDs, app)
+	}
+	return nil
+}
+
+// handleGetListGrowthStats returns the daily subscription, confirmation, and
+// unsubscription counts of a list between the optional `from` and `to` dates
+// (YYYY-MM-DD). The default range is the last 30 days.
+func handleGetListGrowthStats(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		to    = time.Now()
+		from  = to.AddDate(0, 0, -30)
+		out   []listGrowthStat
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if v := c.FormValue("from"); v != "" {
+		t, err := time.Parse(dateFormat, v)
+		if err != nil {
+			return newFieldError("from", "Invalid `from` date.")
+		}
+		from = t
+	}
+	if v := c.FormValue("to"); v != "" {
+		t, err := time.Parse(dateFormat, v)
+		if err != nil {
+			return newFieldError("to", "Invalid `to` date.")
+		}
+		to = t
+	}
+	if from.After(to) || to.Sub(from) > listStatsMaxRange {
+		return newFieldError("from", "Invalid date range.")
+	}
+
+	if err := app.queries.GetListGrowthStats.Select(&out, id,
+		from.Format(dateFormat), to.Format(dateFormat)); err != nil {
+		getLogger(c).Errorf("error fetching list stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching list stats: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCloneList handles cloning of a list's settings and optionally,
+// its subscriber memberships.
+func handleCloneList(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		o     listCloneReq
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if o.Name != "" && !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return newFieldError("name", "Invalid length for the name field.")
+	}
+
+	uu, err := uuid.NewV4()
+	if err != nil {
+		getLogger(c).Errorf("error generating UUID: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error generating UUID")
+	}
+
+	var newID int
+	if err := app.queries.CloneList.Get(&newID, id, uu.String(), o.Name, o.IncludeSubscribers); err != nil {
+		if err == sql.ErrNoRows {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "List not found.")
+		}
+
+		getLogger(c).Errorf("error cloning list: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error cloning list: %s", pqErrMsg(err)))
+	}
+
+	// Hand over to the GET handler to return the new list.
+	return handleGetLists(copyEchoCtx(c, map[string]string{
+		"id": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// handleMergeList handles merging of a source list's subscriptions and
+// campaign references into the list in the URI. The source list is archived.
+func handleMergeList(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		o     listMergeReq
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if o.SourceID < 1 || o.SourceID == id {
+		return newFieldError("source_id", "Invalid `source_id`.")
+	}
+
+	// Validate both the lists before merging.
+	var lists []models.List
+	if err := app.queries.GetListsByOptin.Select(&lists, "", pq.Int64Array{int64(id), int64(o.SourceID)}, nil); err != nil {
+		getLogger(c).Errorf("error fetching lists: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching lists: %s", pqErrMsg(err)))
+	}
+	var target, source *models.List
+	for i := range lists {
+		switch lists[i].ID {
+		case id:
+			target = &lists[i]
+		case o.SourceID:
+			source = &lists[i]
+		}
+	}
+	if target == nil {
+		return newHTTPError(http.StatusNotFound, errCodeNotFound, "List not found.")
+	}
+	if source == nil {
+		return newHTTPError(http.StatusNotFound, errCodeNotFound, "Source list not found.")
+	}
+	if target.Archived {
+		return newFieldError("id", "Can't merge into an archived list.")
+	}
+
+	res, err := app.queries.MergeLists.Exec(id, o.SourceID)
+	if err != nil {
+		getLogger(c).Errorf("error merging lists: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error merging lists: %s", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusNotFound, errCodeNotFound, "List not found.")
+	}
+
+	return handleGetLists(c)
+}
+
+// handleArchiveList handles archiving and unarchiving of a list. Archived
+// lists retain their subscriptions and stats but can't be targeted by
+// campaigns or subscribed to.
+func handleArchiveList(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		o     listArchiveReq
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	res, err := app.queries.ArchiveList.Exec(id, o.Archived)
+	if err != nil {
+		getLogger(c).Errorf("error archiving list: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error archiving list: %s", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "List not found.")
 	}
 
 	return handleGetLists(c)
@@ -165,7 +605,7 @@ func handleDeleteLists(c echo.Context) error {
 	)
 
 	if id < 1 && len(ids) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	if id > 0 {
@@ -173,10 +613,103 @@ func handleDeleteLists(c echo.Context) error {
 	}
 
 	if _, err := app.queries.DeleteLists.Exec(ids); err != nil {
-		app.log.Printf("error deleting lists: %v", err)
+		getLogger(c).Errorf("error deleting lists: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error deleting: %v", err))
 	}
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
+
+// validateListQuery validates the subscriber query of a dynamic list
+// by dry running it in a readonly transaction. Dynamic lists are always
+// single opt-in as their subscriptions are derived from the query.
+func validateListQuery(o *models.List, app *App) error {
+	o.Query = sanitizeSQLExp(o.Query)
+	if o.Query == "" {
+		return nil
+	}
+
+	if _, err := app.queries.compileSubscriberQueryTpl(o.Query, app.db); err != nil {
+		return newFieldError("query",
+			fmt.Sprintf("Invalid list query: %s", pqErrMsg(err)))
+	}
+	o.Optin = models.ListOptinSingle
+	return nil
+}
+
+// validateListSender validates the optional default sender identity of a list.
+func validateListSender(o models.List) error {
+	if o.FromEmail != "" && !regexFromAddress.MatchString(o.FromEmail) &&
+		!subimporter.IsEmail(o.FromEmail) {
+		return newFieldError("from_email", "Invalid `from_email`.")
+	}
+	if o.ReplyTo != "" && !subimporter.IsEmail(o.ReplyTo) {
+		return newFieldError("reply_to", "Invalid `reply_to`.")
+	}
+	return nil
+}
+
+// compileListOptinTpl compiles a list's opt-in e-mail body override along
+// with the notification templates so that it can use the common header
+// and footer templates.
+func compileListOptinTpl(body string, app *App) (*template.Template, error) {
+	return compileNotifTpl(notifSubscriberOptin+"-list", body, app)
+}
+
+// getListFields returns the subscriber fields declared
+// by the given lists (IDs or UUIDs).
+func getListFields(listIDs []int64, listUUIDs []string, app *App) (models.ListFields, error) {
+	if len(listIDs) == 0 && len(listUUIDs) == 0 {
+		return nil, nil
+	}
+
+	var (
+		ids   interface{}
+		uuids interface{}
+	)
+	if len(listIDs) > 0 {
+		ids = pq.Int64Array(listIDs)
+	} else {
+		uuids = pq.StringArray(listUUIDs)
+	}
+
+	var lists []models.List
+	if err := app.queries.GetListsByOptin.Select(&lists, "", ids, uuids); err != nil {
+		app.log.Errorf("error fetching lists: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching lists: %s", pqErrMsg(err)))
+	}
+
+	var out models.ListFields
+	for _, l := range lists {
+		out = append(out, l.Fields...)
+	}
+	return out, nil
+}
+
+// validateListFields checks that the given subscriber attributes contain
+// all the fields that are required by the given lists.
+func validateListFields(fields models.ListFields, attribs models.SubscriberAttribs) error {
+	for _, f := range fields {
+		if !f.Required {
+			continue
+		}
+
+		if v, ok := attribs[f.Key]; !ok || v == nil || v == "" {
+			name := f.Label
+			if name == "" {
+				name = f.Key
+			}
+			return newFieldError("attribs",
+				fmt.Sprintf("The field '%s' is required.", name))
+		}
+	}
+	return nil
+}
+
+// syncDynamicList refreshes the subscriptions of a dynamic list
+// with the subscribers that currently match its query.
+func syncDynamicList(listID int, query string, q *Queries, db *sqlx.DB) error {
+	return q.execSubscriberQueryTpl(query, q.SyncDynamicListByQuery, nil, db, listID)
+}
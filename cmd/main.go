@@ -16,11 +16,20 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/listmonk/internal/bounce"
 	"github.com/knadh/listmonk/internal/buflog"
+	"github.com/knadh/listmonk/internal/events"
+	"github.com/knadh/listmonk/internal/geoip"
+	"github.com/knadh/listmonk/internal/graphql"
+	"github.com/knadh/listmonk/internal/logger"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/messenger"
+	"github.com/knadh/listmonk/internal/mjml"
+	"github.com/knadh/listmonk/internal/ratelimit"
+	"github.com/knadh/listmonk/internal/screenshot"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/webhooks"
 	"github.com/knadh/stuffbin"
 )
 
@@ -37,11 +46,50 @@ type App struct {
 	constants  *constants
 	manager    *manager.Manager
 	importer   *subimporter.Importer
+	webhooks   *webhooks.Dispatcher
+	events     *events.Hub
+	bounce     *bounce.Manager
+	mjml       *mjml.Compiler
+	screenshot *screenshot.Renderer
 	messengers map[string]messenger.Messenger
 	media      media.Store
 	notifTpls  *template.Template
-	log        *log.Logger
-	bufLog     *buflog.BufLog
+
+	// Rate limiter for the transactional message API.
+	txLimit *ratelimit.Limiter
+
+	// Per-client request rate limiters for the public subscription pages,
+	// the tracking endpoints, and the admin API.
+	publicLimit *ratelimit.KeyedLimiter
+	trackLimit  *ratelimit.KeyedLimiter
+	apiLimit    *ratelimit.KeyedLimiter
+
+	// GeoIP DB for locating the IPs of tracking events.
+	geoip *geoip.DB
+
+	// Networks that are allowed to access the admin UI and the API.
+	adminAllowlist *ipAllowlist
+
+	// Permissions of the non-admin user roles.
+	rolePerms *rolePerms
+
+	// Query root of the read-only GraphQL API.
+	gqlSchema *graphql.Object
+
+	// Cache of authenticated admin user credentials of API clients and
+	// the last used TOTP codes.
+	authCache *authCache
+	totpSteps *totpSteps
+
+	// Buffered API request counts of users.
+	apiUsage *apiUsage
+
+	// Pristine copy of notifTpls that's never executed. html/template
+	// templates can't be cloned once executed, so templates that extend
+	// the notification templates are cloned from this.
+	notifTplsBase *template.Template
+	log           *logger.Logger
+	bufLog        *buflog.BufLog
 
 	// Channel for passing reload signals.
 	sigChan chan os.Signal
@@ -50,6 +98,13 @@ type App struct {
 	// after a settings update.
 	needsRestart bool
 
+	// Read-only (maintenance) mode of the API.
+	readOnly readOnlyMode
+
+	// Whether there are users. Authentication is disabled without users.
+	// It's updated as users are created and deleted.
+	hasUsers bool
+
 	// Global state that stores data on an available remote update.
 	update *AppUpdate
 	sync.Mutex
@@ -58,8 +113,10 @@ type App struct {
 var (
 	// Buffered log writer for storing N lines of log entries for the UI.
 	bufLog = buflog.New(5000)
-	lo     = log.New(io.MultiWriter(os.Stdout, bufLog), "",
-		log.Ldate|log.Ltime|log.Lshortfile)
+
+	// Hub of the live admin events that error log lines are published to.
+	evHub = events.New(100)
+	lo    = logger.New(io.MultiWriter(os.Stdout, bufLog, errorLogWriter{evHub}))
 
 	ko      = koanf.New(".")
 	fs      stuffbin.FileSystem
@@ -79,15 +136,15 @@ func init() {
 		os.Exit(0)
 	}
 
-	lo.Println(buildString)
+	lo.Infof("%s", buildString)
 
 	// Generate new config.
 	if ko.Bool("new-config") {
 		if err := newConfigFile(); err != nil {
-			lo.Println(err)
+			lo.Errorf("%v", err)
 			os.Exit(1)
 		}
-		lo.Println("generated config.toml. Edit and run --install")
+		lo.Infof("generated config.toml. Edit and run --install")
 		os.Exit(0)
 	}
 
@@ -134,6 +191,7 @@ func init() {
 
 	// Load settings from DB.
 	initSettings(queries)
+	initLogger()
 }
 
 func main() {
@@ -147,20 +205,91 @@ func main() {
 		messengers: make(map[string]messenger.Messenger),
 		log:        lo,
 		bufLog:     bufLog,
+		events:     evHub,
+		authCache:  newAuthCache(),
+		totpSteps:  newTOTPSteps(),
+		apiUsage:   newAPIUsage(),
+		rolePerms:  &rolePerms{},
+		readOnly: readOnlyMode{
+			Enabled: ko.Bool("app.read_only"),
+			Reason:  ko.String("app.read_only_reason"),
+		},
 	}
 	_, app.queries = initQueries(queryFilePath, db, fs, true)
+	app.hasUsers = initAdminUser(app.queries, app.constants)
 	app.manager = initCampaignManager(app.queries, app.constants, app)
 	app.importer = initImporter(app.queries, db, app)
 	app.notifTpls = initNotifTemplates("/email-templates/*.html", fs, app.constants)
+	app.notifTplsBase = template.Must(app.notifTpls.Clone())
+	app.webhooks = initWebhooks(app.queries)
+	app.bounce = initBounceManager(app)
+	app.mjml = initMJML(app.constants)
+	app.geoip = initGeoIP(app.constants)
+	app.screenshot = initScreenshot(app.constants)
+	app.txLimit = ratelimit.New(ko.Int("app.tx_message_rate"), ko.Int("app.tx_hourly_limit"))
+	app.publicLimit = ratelimit.NewKeyed(ko.Int("app.rate_limit_public"), rateLimitWindow)
+	app.trackLimit = ratelimit.NewKeyed(ko.Int("app.rate_limit_tracking"), rateLimitWindow)
+	app.apiLimit = ratelimit.NewKeyed(ko.Int("app.rate_limit_api"), rateLimitWindow)
+	app.adminAllowlist = initAdminAllowlist()
+	if err := loadRolePerms(app); err != nil {
+		lo.Fatalf("error loading role permissions: %v", err)
+	}
+	app.gqlSchema = initGraphQLSchema(app)
+
+	// Load the template partials into the campaign manager.
+	if err := loadTplPartials(app); err != nil {
+		lo.Fatalf("error loading template partials: %v", err)
+	}
 
 	// Initialize the default SMTP (`email`) messenger.
-	app.messengers[emailMsgr] = initSMTPMessenger(app.manager)
+	// Messages are S/MIME or PGP signed and DKIM signed by messengers that
+	// send raw MIME messages.
+	var (
+		dk = initDKIM()
+		sg = initMessageSigning()
+	)
+	app.messengers[emailMsgr] = initSMTPMessenger(app.manager, dk, sg)
 
 	// Initialize any additional postback messengers.
 	for _, m := range initPostbackMessengers(app.manager) {
 		app.messengers[m.Name()] = m
 	}
 
+	// Initialize any Amazon SES API messengers.
+	for _, m := range initSESMessengers(app.manager, dk, sg) {
+		app.messengers[m.Name()] = m
+	}
+
+	// Initialize any SendGrid API messengers.
+	for _, m := range initSendGridMessengers(app.manager) {
+		app.messengers[m.Name()] = m
+	}
+
+	// Initialize any Mailgun API messengers.
+	for _, m := range initMailgunMessengers(app.manager, dk, sg) {
+		app.messengers[m.Name()] = m
+	}
+
+	// Initialize any Postmark API messengers.
+	for _, m := range initPostmarkMessengers(app.manager) {
+		app.messengers[m.Name()] = m
+	}
+
+	// Initialize any SparkPost API messengers.
+	for _, m := range initSparkPostMessengers(app.manager) {
+		app.messengers[m.Name()] = m
+	}
+
+	// Initialize any local sendmail binary messengers.
+	for _, m := range initSendmailMessengers(app.manager, dk, sg) {
+		app.messengers[m.Name()] = m
+	}
+
+	// Initialize any SMS messengers.
+	for _, m := range initSMSMessengers(app.manager) {
+		app.messengers[m.Name()] = m
+	}
+
 	// Attach all messengers to the campaign manager.
 	for _, m := range app.messengers {
 		app.manager.AddMessenger(m)
@@ -170,9 +299,56 @@ func main() {
 	// messages) get processed at the specified interval.
 	go app.manager.Run(time.Second * 5)
 
+	// Start the list webhook workers.
+	go app.webhooks.Run()
+
+	// Start publishing campaign and import progress to event subscribers.
+	go watchEvents(app)
+
+	// Start the bounce mailbox scanners and processor.
+	if app.bounce != nil {
+		go app.bounce.Run()
+	}
+
 	// Start the app server.
 	srv := initHTTPServer(app)
 
+	// Start the delivery log pruner.
+	if ko.Bool("app.delivery_log") && ko.Duration("app.delivery_log_retention") > 0 {
+		go pruneDeliveryLog(ko.Duration("app.delivery_log_retention"), app)
+	}
+
+	// Start the expired session pruner.
+	go pruneSessions(app)
+
+	// Start the expired idempotency key pruner.
+	go pruneIdempotencyKeys(app)
+
+	// Start the webhook delivery pruner.
+	go pruneWebhookDeliveries(app)
+
+	// Start writing the API usage counts to the DB.
+	go flushAPIUsage(app)
+
+	// Start recording the daily snapshots of list subscription counts.
+	go recordListSnapshots(app)
+
+	// Start moving waitlisted subscribers into lists as space frees up.
+	go promoteListWaitlists(app)
+
+	// Start recording the monthly engagement of subscriber cohorts.
+	go recordSubscriberCohorts(app)
+
+	// Start the audit log pruner. A retention of 0 keeps the log forever.
+	if ko.Duration("app.audit_log_retention") > 0 {
+		go pruneAuditLog(ko.Duration("app.audit_log_retention"), app)
+	}
+
+	// Start the raw tracking event pruner. A retention of 0 keeps the events forever.
+	if app.constants.ViewsRetention > 0 || app.constants.ClicksRetention > 0 {
+		go pruneTrackingEvents(app.constants.ViewsRetention, app.constants.ClicksRetention, app)
+	}
+
 	// Star the update checker.
 	go checkUpdates(versionString, time.Hour*24, app)
 
@@ -192,6 +368,17 @@ func main() {
 		// Close the campaign manager.
 		app.manager.Close()
 
+		// Flush pending webhooks.
+		app.webhooks.Close()
+
+		// Record pending bounces.
+		if app.bounce != nil {
+			app.bounce.Close()
+		}
+
+		// Record the buffered API usage counts.
+		app.apiUsage.flush(app)
+
 		// Close the DB pool.
 		app.db.DB.Close()
 
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/models"
 	"github.com/lib/pq"
 )
@@ -10,16 +15,36 @@ import (
 // database.
 type runnerDB struct {
 	queries *Queries
+	db      *sqlx.DB
+
+	// How often dynamic lists are synced with their queries.
+	syncInterval time.Duration
 }
 
-func newManagerDB(q *Queries) *runnerDB {
+func newManagerDB(q *Queries, db *sqlx.DB, syncInterval time.Duration) *runnerDB {
 	return &runnerDB{
-		queries: q,
+		queries:      q,
+		db:           db,
+		syncInterval: syncInterval,
 	}
 }
 
 // NextCampaigns retrieves active campaigns ready to be processed.
+// Before that, the subscriptions of the dynamic lists of those campaigns
+// that haven't been synced within the sync interval are refreshed from
+// their queries so that they reflect the current data.
 func (r *runnerDB) NextCampaigns(excludeIDs []int64) ([]*models.Campaign, error) {
+	var lists []models.List
+	if err := r.queries.GetCampaignDynamicLists.Select(&lists, pq.Int64Array(excludeIDs),
+		r.syncInterval.Seconds()); err != nil {
+		return nil, err
+	}
+	for _, l := range lists {
+		if err := syncDynamicList(l.ID, l.Query, r.queries, r.db); err != nil {
+			return nil, fmt.Errorf("error syncing dynamic list (%s): %v", l.Name, err)
+		}
+	}
+
 	var out []*models.Campaign
 	err := r.queries.NextCampaigns.Select(&out, pq.Int64Array(excludeIDs))
 	return out, err
@@ -64,3 +89,71 @@ func (r *runnerDB) CreateLink(url string) (string, error) {
 
 	return out, nil
 }
+
+// NextQueuedSubscribers retrieves a subset of subscribers of a given campaign
+// whose messages were pending in the message queue.
+func (r *runnerDB) NextQueuedSubscribers(campID, limit int) ([]models.Subscriber, error) {
+	var out []models.Subscriber
+	err := r.queries.NextQueuedCampaignSubs.Select(&out, campID, limit)
+	return out, err
+}
+
+// QueueMessage records a non-campaign message in the message queue and
+// returns its queue ID.
+func (r *runnerDB) QueueMessage(messenger string, msg []byte) (int64, error) {
+	var id int64
+	err := r.queries.QueueMessage.Get(&id, messenger, msg)
+	return id, err
+}
+
+// NextQueuedMessages retrieves a batch of non-campaign messages that were
+// pending in the message queue.
+func (r *runnerDB) NextQueuedMessages(limit int) ([]manager.QueuedMessage, error) {
+	var out []manager.QueuedMessage
+	err := r.queries.NextQueuedMessages.Select(&out, limit)
+	return out, err
+}
+
+// UpdateQueuedMessages updates the status of messages in the message queue.
+func (r *runnerDB) UpdateQueuedMessages(keys []manager.QueueKey, status string) error {
+	ids, campIDs, subIDs := splitQueueKeys(keys)
+	_, err := r.queries.UpdateQueuedMessages.Exec(ids, campIDs, subIDs, status)
+	return err
+}
+
+// DeleteQueuedMessages removes messages from the message queue.
+func (r *runnerDB) DeleteQueuedMessages(keys []manager.QueueKey) error {
+	ids, campIDs, subIDs := splitQueueKeys(keys)
+	_, err := r.queries.DeleteQueuedMessages.Exec(ids, campIDs, subIDs)
+	return err
+}
+
+// RecordDeferral records the deferral of a message by the recipient's domain.
+func (r *runnerDB) RecordDeferral(campID int, email string) error {
+	_, err := r.queries.RecordDeferral.Exec(campID, email)
+	return err
+}
+
+// ResetMessageQueue recovers the message queue on startup and returns the
+// number of messages that were being sent and are sent again.
+func (r *runnerDB) ResetMessageQueue() (int, error) {
+	var n int
+	err := r.queries.ResetMessageQueue.Get(&n)
+	return n, err
+}
+
+// splitQueueKeys splits message queue keys into the queue IDs of the
+// non-campaign messages and the campaign and subscriber ID pairs of the
+// campaign messages.
+func splitQueueKeys(keys []manager.QueueKey) (ids, campIDs, subIDs pq.Int64Array) {
+	ids, campIDs, subIDs = pq.Int64Array{}, pq.Int64Array{}, pq.Int64Array{}
+	for _, k := range keys {
+		if k.ID > 0 {
+			ids = append(ids, k.ID)
+			continue
+		}
+		campIDs = append(campIDs, int64(k.CampaignID))
+		subIDs = append(subIDs, int64(k.SubscriberID))
+	}
+	return ids, campIDs, subIDs
+}
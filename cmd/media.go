@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"strconv"
@@ -34,14 +35,14 @@ func handleUploadMedia(c echo.Context) error {
 	)
 	file, err := c.FormFile("file")
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newFieldError("file",
 			fmt.Sprintf("Invalid file uploaded: %v", err))
 	}
 
 	// Validate MIME type with the list of allowed types.
 	var typ = file.Header.Get("Content-type")
 	if ok := validateMIME(typ, imageMimes); !ok {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newFieldError("file",
 			fmt.Sprintf("Unsupported file type (%s) uploaded.", typ))
 	}
 
@@ -51,7 +52,7 @@ func handleUploadMedia(c echo.Context) error {
 	// Read file contents in memory
 	src, err := file.Open()
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			fmt.Sprintf("Error reading file: %s", err))
 	}
 	defer src.Close()
@@ -59,7 +60,7 @@ func handleUploadMedia(c echo.Context) error {
 	// Upload the file.
 	fName, err = app.media.Put(fName, typ, src)
 	if err != nil {
-		app.log.Printf("error uploading file: %v", err)
+		getLogger(c).Errorf("error uploading file: %v", err)
 		cleanUp = true
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error uploading file: %s", err))
@@ -78,7 +79,7 @@ func handleUploadMedia(c echo.Context) error {
 	thumbFile, err := createThumbnail(file)
 	if err != nil {
 		cleanUp = true
-		app.log.Printf("error resizing image: %v", err)
+		getLogger(c).Errorf("error resizing image: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error resizing image: %s", err))
 	}
@@ -87,21 +88,21 @@ func handleUploadMedia(c echo.Context) error {
 	thumbfName, err := app.media.Put(thumbPrefix+fName, typ, thumbFile)
 	if err != nil {
 		cleanUp = true
-		app.log.Printf("error saving thumbnail: %v", err)
+		getLogger(c).Errorf("error saving thumbnail: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error saving thumbnail: %s", err))
 	}
 
 	uu, err := uuid.NewV4()
 	if err != nil {
-		app.log.Printf("error generating UUID: %v", err)
+		getLogger(c).Errorf("error generating UUID: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Error generating UUID")
 	}
 
 	// Write to the DB.
 	if _, err := app.queries.InsertMedia.Exec(uu, fName, thumbfName, app.constants.MediaProvider); err != nil {
 		cleanUp = true
-		app.log.Printf("error inserting uploaded file to db: %v", err)
+		getLogger(c).Errorf("error inserting uploaded file to db: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error saving uploaded file to db: %s", pqErrMsg(err)))
 	}
@@ -136,7 +137,7 @@ func handleDeleteMedia(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	var m media.Media
@@ -158,6 +159,11 @@ func createThumbnail(file *multipart.FileHeader) (*bytes.Reader, error) {
 	}
 	defer src.Close()
 
+	return makeThumbnail(src)
+}
+
+// makeThumbnail reads an image and returns a smaller image.
+func makeThumbnail(src io.Reader) (*bytes.Reader, error) {
 	img, err := imaging.Decode(src)
 	if err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"html/template"
 
 	"github.com/knadh/listmonk/internal/manager"
 )
@@ -11,6 +12,7 @@ const (
 	notifTplCampaign     = "campaign-status"
 	notifSubscriberOptin = "subscriber-optin"
 	notifSubscriberData  = "subscriber-data"
+	notifOptinCampaign   = "optin-campaign"
 )
 
 // notifData represents params commonly used across different notification
@@ -24,18 +26,35 @@ type notifData struct {
 func (app *App) sendNotification(toEmails []string, subject, tplName string, data interface{}) error {
 	var b bytes.Buffer
 	if err := app.notifTpls.ExecuteTemplate(&b, tplName, data); err != nil {
-		app.log.Printf("error compiling notification template '%s': %v", tplName, err)
+		app.log.Errorf("error compiling notification template '%s': %v", tplName, err)
 		return err
 	}
 
+	return app.pushNotification(toEmails, subject, b.Bytes(), manager.PriorityNormal)
+}
+
+// compileNotifTpl compiles a template body along with the notification
+// templates so that it can use the common header and footer templates.
+func compileNotifTpl(name, body string, app *App) (*template.Template, error) {
+	tpl, err := app.notifTplsBase.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return tpl.New(name).Parse(body)
+}
+
+// pushNotification pushes an already compiled e-mail notification to the given
+// e-mails with the given manager priority.
+func (app *App) pushNotification(toEmails []string, subject string, body []byte, priority int) error {
 	m := manager.Message{}
 	m.From = app.constants.FromEmail
 	m.To = toEmails
 	m.Subject = subject
-	m.Body = b.Bytes()
+	m.Body = body
 	m.Messenger = emailMsgr
+	m.Priority = priority
 	if err := app.manager.PushMessage(m); err != nil {
-		app.log.Printf("error sending admin notification (%s): %v", subject, err)
+		app.log.Errorf("error sending admin notification (%s): %v", subject, err)
 		return err
 	}
 	return nil
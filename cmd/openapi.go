@@ -0,0 +1,269 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/knadh/listmonk/internal/graphql"
+	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/openapi"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+)
+
+// apiSpec describes the request and response bodies of an API route in
+// the OpenAPI document. The summaries, tags, path params, and auth of
+// routes are derived from the registered routes and their handlers.
+type apiSpec struct {
+	Req  interface{}
+	Resp interface{}
+
+	// ContentType of the response if it isn't JSON.
+	ContentType string
+
+	// Public routes don't require authentication.
+	Public bool
+}
+
+// Request and response bodies of the API routes. Routes without a spec
+// are documented with untyped bodies.
+var apiSpecs = map[string]apiSpec{
+	"GET /api/settings":        {Resp: settings{}},
+	"PUT /api/settings":        {Req: settings{}, Resp: true},
+	"GET /api/audit-log":       {Resp: auditLogWrap{}},
+	"GET /api/admin/read-only": {Resp: readOnlyMode{}},
+	"PUT /api/admin/read-only": {Req: readOnlyMode{}, Resp: readOnlyMode{}},
+	"POST /api/login":          {Req: loginReq{}, Resp: loginResp{}, Public: true},
+	"POST /api/logout":         {Resp: true},
+	"GET /api/public/lists":    {Resp: []publicList{}, Public: true},
+	"POST /api/graphql":        {Req: graphql.Request{}},
+	"GET /api/events":          {ContentType: "text/event-stream"},
+
+	"GET /api/roles":             {Resp: []models.Role{}},
+	"GET /api/roles/permissions": {Resp: []string{}},
+	"PUT /api/roles/:role":       {Req: roleReq{}, Resp: models.Role{}},
+
+	"GET /api/users":        {Resp: []models.User{}},
+	"GET /api/users/usage":  {Resp: []models.APIUsage{}},
+	"GET /api/users/:id":    {Resp: models.User{}},
+	"POST /api/users":       {Req: userReq{}, Resp: models.User{}},
+	"PUT /api/users/:id":    {Req: userReq{}, Resp: models.User{}},
+	"DELETE /api/users/:id": {Resp: true},
+	"GET /api/profile":      {Resp: models.User{}},
+	"PUT /api/profile":      {Req: userReq{}, Resp: models.User{}},
+
+	"GET /api/subscribers":                      {Resp: subsWrap{}},
+	"GET /api/subscribers/:id":                  {Resp: models.Subscriber{}},
+	"GET /api/subscribers/:id/stats/views":      {Resp: []subViewStats{}},
+	"GET /api/subscribers/:id/stats/engagement": {Resp: subEngagement{}},
+	"GET /api/subscribers/cohorts":              {Resp: []subCohort{}},
+	"GET /api/subscribers/export":               {ContentType: "text/csv"},
+	"GET /api/subscribers/:id/export":           {Resp: subProfileData{}},
+	"POST /api/subscribers":                     {Req: subimporter.SubReq{}, Resp: models.Subscriber{}},
+	"PUT /api/subscribers/:id":                  {Req: subimporter.SubReq{}, Resp: models.Subscriber{}},
+	"PUT /api/subscribers/blocklist":            {Req: subQueryReq{}, Resp: true},
+	"PUT /api/subscribers/lists":                {Req: subQueryReq{}, Resp: true},
+	"POST /api/subscribers/batch":               {Req: subBatchReq{}, Resp: []batchResult{}},
+	"POST /api/subscribers/lists/batch":         {Req: subListsBatchReq{}, Resp: []batchResult{}},
+	"DELETE /api/subscribers/:id":               {Resp: true},
+	"DELETE /api/subscribers":                   {Resp: true},
+	"POST /api/subscribers/query/delete":        {Req: subQueryReq{}, Resp: true},
+	"PUT /api/subscribers/query/blocklist":      {Req: subQueryReq{}, Resp: true},
+	"PUT /api/subscribers/query/lists":          {Req: subQueryReq{}, Resp: true},
+
+	"GET /api/lists":               {Resp: listsWrap{}},
+	"GET /api/lists/:id":           {Resp: models.List{}},
+	"GET /api/lists/:id/stats":     {Resp: []listGrowthStat{}},
+	"GET /api/lists/:id/snapshots": {Resp: []listSnapshot{}},
+	"POST /api/lists":              {Req: models.List{}, Resp: models.List{}},
+	"PUT /api/lists/:id":           {Req: models.List{}, Resp: models.List{}},
+	"POST /api/lists/:id/clone":    {Req: listCloneReq{}, Resp: models.List{}},
+	"POST /api/lists/:id/merge":    {Req: listMergeReq{}, Resp: true},
+	"PUT /api/lists/:id/archive":   {Req: listArchiveReq{}, Resp: true},
+	"DELETE /api/lists/:id":        {Resp: true},
+	"GET /api/lists/:id/webhooks":  {Resp: []models.ListWebhook{}},
+	"POST /api/lists/:id/webhooks": {Req: listWebhookReq{}, Resp: models.ListWebhook{}},
+	"GET /api/lists/groups":        {Resp: []models.ListGroup{}},
+	"GET /api/lists/groups/:id":    {Resp: models.ListGroup{}},
+	"POST /api/lists/groups":       {Req: models.ListGroup{}, Resp: models.ListGroup{}},
+	"PUT /api/lists/groups/:id":    {Req: models.ListGroup{}, Resp: models.ListGroup{}},
+	"DELETE /api/lists/groups/:id": {Resp: true},
+
+	"GET /api/webhooks":                       {Resp: []models.Webhook{}},
+	"GET /api/webhooks/:id":                   {Resp: models.Webhook{}},
+	"POST /api/webhooks":                      {Req: webhookReq{}, Resp: models.Webhook{}},
+	"PUT /api/webhooks/:id":                   {Req: webhookReq{}, Resp: models.Webhook{}},
+	"DELETE /api/webhooks/:id":                {Resp: true},
+	"GET /api/webhooks/deliveries":            {Resp: webhookDeliveriesWrap{}},
+	"POST /api/webhooks/deliveries/:id/retry": {Resp: true},
+
+	"GET /api/campaigns":                        {Resp: campsWrap{}},
+	"GET /api/campaigns/:id":                    {Resp: models.Campaign{}},
+	"GET /api/campaigns/running/stats":          {Resp: []campaignStats{}},
+	"GET /api/campaigns/:id/stats/views":        {Resp: campViewStats{}},
+	"GET /api/campaigns/:id/stats/links":        {Resp: []campLinkStats{}},
+	"GET /api/campaigns/:id/stats/geo":          {Resp: []campGeoStats{}},
+	"GET /api/campaigns/:id/stats/clients":      {Resp: []campClientStats{}},
+	"GET /api/campaigns/:id/stats/heatmap":      {Resp: []campLinkHeatmap{}},
+	"GET /api/campaigns/:id/stats/unsubscribes": {Resp: campUnsubStats{}},
+	"GET /api/campaigns/:id/stats/funnel":       {Resp: campFunnel{}},
+	"POST /api/campaigns/conversions":           {Req: conversionReq{}, Resp: true},
+	"GET /api/campaigns/compare":                {Resp: campComparisonResp{}},
+	"GET /api/campaigns/events/export":          {ContentType: "text/csv"},
+	"GET /api/campaigns/stats/domains":          {Resp: []domainStats{}},
+	"GET /api/campaigns/:id/stats/domains":      {Resp: []domainStats{}},
+	"GET /api/campaigns/:id/progress":           {ContentType: "text/event-stream"},
+	"GET /api/campaigns/:id/preview":            {ContentType: "text/html"},
+	"POST /api/campaigns/:id/preview":           {ContentType: "text/html"},
+	"POST /api/campaigns":                       {Req: campaignReq{}, Resp: models.Campaign{}},
+	"PUT /api/campaigns/:id":                    {Req: campaignReq{}, Resp: models.Campaign{}},
+	"PUT /api/campaigns/:id/status":             {Req: campaignReq{}, Resp: models.Campaign{}},
+	"POST /api/campaigns/:id/test":              {Req: campaignReq{}, Resp: true},
+	"DELETE /api/campaigns/:id":                 {Resp: true},
+	"POST /api/tx":                              {Req: txMessageReq{}},
+	"GET /api/tx/log":                           {Resp: txLogWrap{}},
+	"GET /api/deliveries":                       {Resp: deliveryLogWrap{}},
+
+	"GET /api/media":        {Resp: []media.Media{}},
+	"DELETE /api/media/:id": {Resp: true},
+
+	"GET /api/templates":                 {Resp: []models.Template{}},
+	"GET /api/templates/:id":             {Resp: models.Template{}},
+	"GET /api/templates/:id/preview":     {ContentType: "text/html"},
+	"POST /api/templates/:id/preview":    {ContentType: "text/html"},
+	"POST /api/templates/preview":        {ContentType: "text/html"},
+	"POST /api/templates":                {Req: models.Template{}, Resp: models.Template{}},
+	"PUT /api/templates/:id":             {Req: models.Template{}, Resp: models.Template{}},
+	"GET /api/templates/:id/export":      {ContentType: "application/zip"},
+	"DELETE /api/templates/:id":          {Resp: true},
+	"GET /api/templates/:id/revisions":   {Resp: tplRevisionsWrap{}},
+	"GET /api/templates/partials":        {Resp: []models.TemplatePartial{}},
+	"GET /api/templates/partials/:id":    {Resp: models.TemplatePartial{}},
+	"POST /api/templates/partials":       {Req: models.TemplatePartial{}, Resp: models.TemplatePartial{}},
+	"PUT /api/templates/partials/:id":    {Req: models.TemplatePartial{}, Resp: models.TemplatePartial{}},
+	"DELETE /api/templates/partials/:id": {Resp: true},
+}
+
+// handleGetOpenAPI returns the OpenAPI document of the API generated from
+// the registered API routes.
+func handleGetOpenAPI(c echo.Context) error {
+	doc := openapi.New("listmonk", versionString)
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		"basicAuth":  {Type: "http", Scheme: "basic"},
+		"cookieAuth": {Type: "apiKey", In: "cookie", Name: sessionCookie},
+	}
+	doc.Security = []map[string][]string{{"basicAuth": {}}, {"cookieAuth": {}}}
+
+	routes := c.Echo().Routes()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path == routes[j].Path {
+			return routes[i].Method < routes[j].Method
+		}
+		return routes[i].Path < routes[j].Path
+	})
+
+	var (
+		errResp = openapi.Response{
+			Description: "Error",
+			Content: map[string]openapi.MediaType{
+				"application/json": {Schema: &openapi.Schema{
+					Type:       "object",
+					Properties: map[string]*openapi.Schema{"message": {Type: "string"}},
+				}},
+			},
+		}
+		opIDs = make(map[string]bool)
+	)
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, "/api/") || r.Path == "/api/config.js" {
+			continue
+		}
+
+		var (
+			spec  = apiSpecs[r.Method+" "+r.Path]
+			name  = strings.TrimPrefix(r.Name[strings.LastIndex(r.Name, ".")+1:], "handle")
+			words = splitCamelCase(name)
+			op    = &openapi.Operation{
+				OperationID: strings.ToLower(name[:1]) + name[1:],
+				Summary:     strings.Join(words, " "),
+				Tags:        []string{strings.Split(strings.TrimPrefix(r.Path, "/api/"), "/")[0]},
+				Responses:   map[string]openapi.Response{"default": errResp},
+			}
+		)
+
+		// Handlers that serve multiple routes, eg: /lists and /lists/:id,
+		// get unique operation IDs.
+		if opIDs[op.OperationID] {
+			op.OperationID += "_" + strings.ToLower(r.Method) + strings.NewReplacer("/", "_", ":", "", "-", "_").
+				Replace(strings.TrimPrefix(r.Path, "/api"))
+		}
+		opIDs[op.OperationID] = true
+
+		if spec.Req != nil {
+			op.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content: map[string]openapi.MediaType{
+					"application/json": {Schema: doc.Schema(spec.Req)},
+				},
+			}
+		}
+
+		if spec.ContentType != "" {
+			op.Responses["200"] = openapi.Response{
+				Description: "OK",
+				Content: map[string]openapi.MediaType{
+					spec.ContentType: {Schema: &openapi.Schema{Type: "string"}},
+				},
+			}
+		} else {
+			op.Responses["200"] = openapi.Response{
+				Description: "OK",
+				Content: map[string]openapi.MediaType{
+					"application/json": {Schema: &openapi.Schema{
+						Type:       "object",
+						Properties: map[string]*openapi.Schema{"data": doc.Schema(spec.Resp)},
+					}},
+				},
+			}
+		}
+
+		if spec.Public {
+			op.Security = &[]map[string][]string{}
+		}
+
+		doc.Add(r.Method, "/api/"+apiVersion+strings.TrimPrefix(r.Path, "/api"), op)
+	}
+
+	return c.JSON(http.StatusOK, doc)
+}
+
+// splitCamelCase splits a CamelCase name into words in lowercase except
+// for acronyms, eg: GetSMTPStatus => get SMTP status.
+func splitCamelCase(s string) []string {
+	var (
+		r     = []rune(s)
+		words []string
+		start = 0
+	)
+	for i := 1; i <= len(r); i++ {
+		if i < len(r) && !(unicode.IsUpper(r[i]) &&
+			(unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1])))) {
+			continue
+		}
+
+		w := string(r[start:i])
+		if len(w) == 1 || strings.ToUpper(w) != w {
+			w = strings.ToLower(w)
+		}
+		words = append(words, w)
+		start = i
+	}
+
+	if len(words) > 0 {
+		words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+	}
+	return words
+}
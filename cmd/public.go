@@ -14,6 +14,8 @@ import (
 
 	"github.com/knadh/listmonk/internal/messenger"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/useragent"
+	"github.com/knadh/listmonk/internal/webhooks"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo"
 	"github.com/lib/pq"
@@ -21,8 +23,20 @@ import (
 
 const (
 	tplMessage = "message"
+
+	// Maximum length (in characters) of the reasons for unsubscribing.
+	unsubReasonMaxLen = 200
 )
 
+// unsubReasons are the reasons for unsubscribing that are offered on the
+// unsubscribe page in addition to writing one in.
+var unsubReasons = []string{
+	"I receive too many e-mails",
+	"The content isn't relevant to me",
+	"I no longer want to receive these e-mails",
+	"I never signed up for this mailing list",
+}
+
 // tplRenderer wraps a template.tplRenderer for echo.
 type tplRenderer struct {
 	templates  *template.Template
@@ -51,6 +65,8 @@ type unsubTpl struct {
 	AllowBlocklist bool
 	AllowExport    bool
 	AllowWipe      bool
+	Reasons        []string
+	Lists          []models.List
 }
 
 type optinTpl struct {
@@ -58,6 +74,23 @@ type optinTpl struct {
 	SubUUID   string
 	ListUUIDs []string      `query:"l" form:"l"`
 	Lists     []models.List `query:"-" form:"-"`
+	Message   string        `query:"-" form:"-"`
+}
+
+type listDirectoryTpl struct {
+	publicTpl
+	Lists []publicList
+}
+
+// publicList is the subset of a list's fields that's exposed
+// on the public list directory.
+type publicList struct {
+	UUID            string   `json:"uuid"`
+	Name            string   `json:"name"`
+	Description     string   `json:"description"`
+	Optin           string   `json:"optin"`
+	Tags            []string `json:"tags"`
+	SubscriberCount int      `json:"subscriber_count"`
 }
 
 type msgTpl struct {
@@ -102,7 +135,7 @@ func handleViewCampaignMessage(c echo.Context) error {
 				makeMsgTpl("Not found", "", `The e-mail campaign was not found.`))
 		}
 
-		app.log.Printf("error fetching campaign: %v", err)
+		getLogger(c).Errorf("error fetching campaign: %v", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error", "", `Error fetching e-mail campaign.`))
 	}
@@ -115,14 +148,14 @@ func handleViewCampaignMessage(c echo.Context) error {
 				makeMsgTpl("Not found", "", `The e-mail message was not found.`))
 		}
 
-		app.log.Printf("error fetching campaign subscriber: %v", err)
+		getLogger(c).Errorf("error fetching campaign subscriber: %v", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error", "", `Error fetching e-mail message.`))
 	}
 
 	// Compile the template.
 	if err := camp.CompileTemplate(app.manager.TemplateFuncs(&camp)); err != nil {
-		app.log.Printf("error compiling template: %v", err)
+		getLogger(c).Errorf("error compiling template: %v", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error", "", `Error compiling e-mail template.`))
 	}
@@ -130,7 +163,7 @@ func handleViewCampaignMessage(c echo.Context) error {
 	// Render the message body.
 	m := app.manager.NewCampaignMessage(&camp, sub)
 	if err := m.Render(); err != nil {
-		app.log.Printf("error rendering message: %v", err)
+		getLogger(c).Errorf("error rendering message: %v", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error", "", `Error rendering e-mail message.`))
 	}
@@ -155,6 +188,7 @@ func handleSubscriptionPage(c echo.Context) error {
 	out.AllowBlocklist = app.constants.Privacy.AllowBlocklist
 	out.AllowExport = app.constants.Privacy.AllowExport
 	out.AllowWipe = app.constants.Privacy.AllowWipe
+	out.Reasons = unsubReasons
 
 	// Unsubscribe.
 	if unsub {
@@ -163,21 +197,64 @@ func handleSubscriptionPage(c echo.Context) error {
 			blocklist = false
 		}
 
-		if _, err := app.queries.Unsubscribe.Exec(campUUID, subUUID, blocklist); err != nil {
-			app.log.Printf("error unsubscribing: %v", err)
+		// The optional reason for unsubscribing, picked or written in.
+		reason := c.FormValue("reason")
+		if reason == "other" {
+			reason = c.FormValue("reason_other")
+		}
+		reason = strings.TrimSpace(reason)
+		if r := []rune(reason); len(r) > unsubReasonMaxLen {
+			reason = string(r[:unsubReasonMaxLen])
+		}
+
+		var listIDs []int64
+		if err := app.queries.Unsubscribe.Select(&listIDs, campUUID, subUUID, blocklist, reason); err != nil {
+			getLogger(c).Errorf("error unsubscribing: %v", err)
 			return c.Render(http.StatusInternalServerError, tplMessage,
 				makeMsgTpl("Error", "",
 					`Error processing request. Please retry.`))
 		}
+		pushListWebhooksByUUID(webhooks.EventUnsubscribe, subUUID, listIDs, app)
 
 		return c.Render(http.StatusOK, tplMessage,
 			makeMsgTpl("Unsubscribed", "",
 				`You have been successfully unsubscribed.`))
 	}
 
+	// Get the subscriptions that are visible on the preferences page.
+	if err := app.queries.GetSubscriberPreferenceLists.Select(&out.Lists, subUUID); err != nil {
+		getLogger(c).Errorf("error fetching subscriber lists: %v", err)
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl("Error", "", `Error fetching lists. Please retry.`))
+	}
+
 	return c.Render(http.StatusOK, "subscription", out)
 }
 
+// handleOneClickUnsubscribe handles RFC 8058 one-click unsubscriptions
+// that mail clients POST to the List-Unsubscribe URL of campaign messages.
+// No page is rendered.
+func handleOneClickUnsubscribe(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		campUUID = c.Param("campUUID")
+		subUUID  = c.Param("subUUID")
+	)
+
+	if c.FormValue("List-Unsubscribe") != "One-Click" {
+		return newFieldError("List-Unsubscribe", "Invalid one-click unsubscribe request.")
+	}
+
+	var listIDs []int64
+	if err := app.queries.Unsubscribe.Select(&listIDs, campUUID, subUUID, false, ""); err != nil {
+		getLogger(c).Errorf("error unsubscribing: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error processing request. Please retry.")
+	}
+	pushListWebhooksByUUID(webhooks.EventUnsubscribe, subUUID, listIDs, app)
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
 // handleOptinPage renders the double opt-in confirmation page that subscribers
 // see when they click on the "Confirm subscription" button in double-optin
 // notifications.
@@ -211,7 +288,7 @@ func handleOptinPage(c echo.Context) error {
 	// Get the list of subscription lists where the subscriber hasn't confirmed.
 	if err := app.queries.GetSubscriberLists.Select(&out.Lists, 0, subUUID,
 		nil, pq.StringArray(out.ListUUIDs), models.SubscriptionStatusUnconfirmed, nil); err != nil {
-		app.log.Printf("error fetching lists for opt-in: %s", pqErrMsg(err))
+		getLogger(c).Errorf("error fetching lists for opt-in: %s", pqErrMsg(err))
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error", "", `Error fetching lists. Please retry.`))
 	}
@@ -225,15 +302,32 @@ func handleOptinPage(c echo.Context) error {
 
 	// Confirm.
 	if confirm {
-		if _, err := app.queries.ConfirmSubscriptionOptin.Exec(subUUID, pq.StringArray(out.ListUUIDs)); err != nil {
-			app.log.Printf("error unsubscribing: %v", err)
+		var listIDs []int64
+		if err := app.queries.ConfirmSubscriptionOptin.Select(&listIDs, subUUID, pq.StringArray(out.ListUUIDs)); err != nil {
+			getLogger(c).Errorf("error unsubscribing: %v", err)
 			return c.Render(http.StatusInternalServerError, tplMessage,
 				makeMsgTpl("Error", "",
 					`Error processing request. Please retry.`))
 		}
+		pushListWebhooksByUUID(webhooks.EventConfirm, subUUID, listIDs, app)
+
+		// If any of the lists override the confirmation message, the first one is used.
+		msg := `Your subscriptions have been confirmed.`
+		for _, l := range out.Lists {
+			if l.OptinConfirmMessage != "" {
+				msg = l.OptinConfirmMessage
+				break
+			}
+		}
 		return c.Render(http.StatusOK, tplMessage,
-			makeMsgTpl("Confirmed", "",
-				`Your subscriptions have been confirmed.`))
+			makeMsgTpl("Confirmed", "", msg))
+	}
+
+	for _, l := range out.Lists {
+		if l.OptinPageMessage != "" {
+			out.Message = l.OptinPageMessage
+			break
+		}
 	}
 
 	return c.Render(http.StatusOK, "optin", out)
@@ -270,18 +364,153 @@ func handleSubscriptionForm(c echo.Context) error {
 			makeMsgTpl("Error", "", err.Error()))
 	}
 
+	// Collect the subscriber attributes declared by the lists
+	// from the `attribs.$key` form fields.
+	fields, err := getListFields(nil, req.SubListUUIDs, app)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl("Error", "", `Error processing request. Please retry.`))
+	}
+	req.Attribs = make(models.SubscriberAttribs)
+	for _, f := range fields {
+		if v := strings.TrimSpace(c.FormValue("attribs." + f.Key)); v != "" {
+			req.Attribs[f.Key] = v
+		}
+	}
+	if err := validateListFields(fields, req.Attribs); err != nil {
+		return c.Render(http.StatusBadRequest, tplMessage,
+			makeMsgTpl("Error", "", fmt.Sprintf("%s", err.(*echo.HTTPError).Message)))
+	}
+
+	// Lists that have reached their subscriber cap either reject
+	// the signup or waitlist the subscriber.
+	var capped []models.List
+	if err := app.queries.GetCappedLists.Select(&capped, pq.StringArray(req.SubListUUIDs)); err != nil {
+		getLogger(c).Errorf("error fetching capped lists: %v", err)
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl("Error", "", `Error processing request. Please retry.`))
+	}
+
+	var (
+		waitlistIDs pq.Int64Array
+		waitlistMsg string
+		skipUUIDs   = make(map[string]bool)
+	)
+	for _, l := range capped {
+		if l.CapAction != models.ListCapActionWaitlist {
+			msg := l.CapMessage
+			if msg == "" {
+				msg = fmt.Sprintf("The list '%s' is full.", l.Name)
+			}
+			return c.Render(http.StatusBadRequest, tplMessage, makeMsgTpl("Error", "", msg))
+		}
+
+		waitlistIDs = append(waitlistIDs, int64(l.ID))
+		skipUUIDs[l.UUID] = true
+		if waitlistMsg == "" {
+			waitlistMsg = l.CapMessage
+		}
+	}
+
 	// Insert the subscriber into the DB.
 	req.Status = models.SubscriberStatusEnabled
-	req.ListUUIDs = pq.StringArray(req.SubListUUIDs)
-	if _, err := insertSubscriber(req.SubReq, app); err != nil {
+	req.ListUUIDs = make(pq.StringArray, 0, len(req.SubListUUIDs))
+	for _, u := range req.SubListUUIDs {
+		if !skipUUIDs[u] {
+			req.ListUUIDs = append(req.ListUUIDs, u)
+		}
+	}
+	sub, err := insertSubscriber(req.SubReq, app)
+	if err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error", "", fmt.Sprintf("%s", err.(*echo.HTTPError).Message)))
 	}
 
+	// Waitlist the subscriber on the capped lists.
+	if len(waitlistIDs) > 0 {
+		if _, err := app.queries.AddToListWaitlist.Exec(sub.ID, waitlistIDs); err != nil {
+			getLogger(c).Errorf("error waitlisting subscriber: %v", err)
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl("Error", "", `Error processing request. Please retry.`))
+		}
+
+		if waitlistMsg == "" {
+			waitlistMsg = `One or more lists are full. You have been added to the waitlist.`
+		}
+		return c.Render(http.StatusOK, tplMessage, makeMsgTpl("Waitlisted", "", waitlistMsg))
+	}
+
 	return c.Render(http.StatusOK, tplMessage,
 		makeMsgTpl("Done", "", `Subscribed successfully.`))
 }
 
+// handleListDirectoryPage renders the public list directory from where
+// visitors can subscribe to one or more public lists.
+func handleListDirectoryPage(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		out = listDirectoryTpl{}
+	)
+	out.Title = "Mailing lists"
+
+	if !app.constants.EnablePublicListDirectory {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl("Not found", "", `The list directory is not available.`))
+	}
+
+	lists, err := getPublicLists(app)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl("Error", "", `Error fetching lists. Please retry.`))
+	}
+	out.Lists = lists
+
+	return c.Render(http.StatusOK, "lists", out)
+}
+
+// handleGetPublicLists returns the lists on the public list directory.
+func handleGetPublicLists(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	if !app.constants.EnablePublicListDirectory {
+		return echo.NewHTTPError(http.StatusNotFound, "The list directory is not available.")
+	}
+
+	out, err := getPublicLists(app)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error fetching lists.")
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// getPublicLists returns the non-archived public lists.
+func getPublicLists(app *App) ([]publicList, error) {
+	var lists []models.List
+	if err := app.queries.GetPublicLists.Select(&lists); err != nil {
+		app.log.Errorf("error fetching public lists: %v", err)
+		return nil, err
+	}
+
+	out := make([]publicList, 0, len(lists))
+	for _, l := range lists {
+		if l.Tags == nil {
+			l.Tags = []string{}
+		}
+		out = append(out, publicList{
+			UUID:            l.UUID,
+			Name:            l.Name,
+			Description:     l.Description,
+			Optin:           l.Optin,
+			Tags:            l.Tags,
+			SubscriberCount: l.SubscriberCount,
+		})
+	}
+	return out, nil
+}
+
 // handleLinkRedirect redirects a link UUID to its original underlying link
 // after recording the link click for a particular subscriber in the particular
 // campaign. These links are generated by {{ TrackLink }} tags in campaigns.
@@ -298,14 +527,25 @@ func handleLinkRedirect(c echo.Context) error {
 		subUUID = ""
 	}
 
-	var url string
-	if err := app.queries.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID); err != nil {
+	// The position of the link in the message for heatmaps.
+	pos, err := strconv.ParseInt(c.QueryParam("p"), 10, 32)
+	if err != nil || pos < 0 {
+		pos = 0
+	}
+
+	var (
+		ip, country, region = getTrackingGeo(c, app)
+		ua                  = useragent.Parse(c.Request().UserAgent())
+		url                 string
+	)
+	if err := app.queries.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID,
+		ip, country, region, ua.Client, ua.Device, c.Request().UserAgent(), pos); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "link_id" {
 			return c.Render(http.StatusNotFound, tplMessage,
 				makeMsgTpl("Invalid link", "", "The requested link is invalid."))
 		}
 
-		app.log.Printf("error fetching redirect link: %s", err)
+		getLogger(c).Errorf("error fetching redirect link: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error opening link", "", "There was an error opening the link. Please try later."))
 	}
@@ -331,8 +571,13 @@ func handleRegisterCampaignView(c echo.Context) error {
 
 	// Exclude dummy hits from template previews.
 	if campUUID != dummyUUID && subUUID != dummyUUID {
-		if _, err := app.queries.RegisterCampaignView.Exec(campUUID, subUUID); err != nil {
-			app.log.Printf("error registering campaign view: %s", err)
+		var (
+			ip, country, region = getTrackingGeo(c, app)
+			ua                  = useragent.Parse(c.Request().UserAgent())
+		)
+		if _, err := app.queries.RegisterCampaignView.Exec(campUUID, subUUID,
+			ip, country, region, ua.Client, ua.Device, c.Request().UserAgent()); err != nil {
+			getLogger(c).Errorf("error registering campaign view: %s", err)
 		}
 	}
 
@@ -360,17 +605,22 @@ func handleSelfExportSubscriberData(c echo.Context) error {
 	// private lists are replaced with "Private list".
 	data, b, err := exportSubscriberData(0, subUUID, app.constants.Privacy.Exportable, app)
 	if err != nil {
-		app.log.Printf("error exporting subscriber data: %s", err)
+		getLogger(c).Errorf("error exporting subscriber data: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error processing request", "",
 				"There was an error processing your request. Please try later."))
 	}
 
-	// Prepare the attachment e-mail.
-	var msg bytes.Buffer
-	if err := app.notifTpls.ExecuteTemplate(&msg, notifSubscriberData, data); err != nil {
-		app.log.Printf("error compiling notification template '%s': %v",
-			notifSubscriberData, err)
+	// Prepare the attachment e-mail in the subscriber's language.
+	var (
+		lang string
+		subs models.Subscribers
+	)
+	if err := app.queries.GetSubscriber.Select(&subs, 0, subUUID); err == nil && len(subs) > 0 {
+		lang = subscriberLang(subs[0].Attribs)
+	}
+	subject, msg, err := renderSystemTpl(notifSubscriberData, lang, data, app)
+	if err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error preparing data", "",
 				"There was an error preparing your data. Please try later."))
@@ -381,8 +631,8 @@ func handleSelfExportSubscriberData(c echo.Context) error {
 	if err := app.messengers[emailMsgr].Push(messenger.Message{
 		From:    app.constants.FromEmail,
 		To:      []string{data.Email},
-		Subject: "Your data",
-		Body:    msg.Bytes(),
+		Subject: subject,
+		Body:    msg,
 		Attachments: []messenger.Attachment{
 			{
 				Name:    fname,
@@ -391,7 +641,7 @@ func handleSelfExportSubscriberData(c echo.Context) error {
 			},
 		},
 	}); err != nil {
-		app.log.Printf("error e-mailing subscriber profile: %s", err)
+		getLogger(c).Errorf("error e-mailing subscriber profile: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error e-mailing data", "",
 				"There was an error e-mailing your data. Please try later."))
@@ -418,7 +668,7 @@ func handleWipeSubscriberData(c echo.Context) error {
 	}
 
 	if _, err := app.queries.DeleteSubscribers.Exec(nil, pq.StringArray{subUUID}); err != nil {
-		app.log.Printf("error wiping subscriber data: %s", err)
+		getLogger(c).Errorf("error wiping subscriber data: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl("Error processing request", "",
 				"There was an error processing your request. Please try later."))
@@ -14,6 +14,7 @@ import (
 type Queries struct {
 	GetDashboardCharts *sqlx.Stmt `query:"get-dashboard-charts"`
 	GetDashboardCounts *sqlx.Stmt `query:"get-dashboard-counts"`
+	GetDashboardStats  *sqlx.Stmt `query:"get-dashboard-stats"`
 
 	InsertSubscriber                *sqlx.Stmt `query:"insert-subscriber"`
 	UpsertSubscriber                *sqlx.Stmt `query:"upsert-subscriber"`
@@ -32,22 +33,64 @@ type Queries struct {
 	DeleteSubscribers               *sqlx.Stmt `query:"delete-subscribers"`
 	Unsubscribe                     *sqlx.Stmt `query:"unsubscribe"`
 	ExportSubscriberData            *sqlx.Stmt `query:"export-subscriber-data"`
+	GetSubscriberPreferenceLists    *sqlx.Stmt `query:"get-subscriber-preference-lists"`
 
 	// Non-prepared arbitrary subscriber queries.
 	QuerySubscribers                       string `query:"query-subscribers"`
+	QuerySubscribersForExport              string `query:"query-subscribers-for-export"`
+	QuerySubscriberAttribKeysForExport     string `query:"query-subscriber-attrib-keys-for-export"`
 	QuerySubscribersTpl                    string `query:"query-subscribers-template"`
 	DeleteSubscribersByQuery               string `query:"delete-subscribers-by-query"`
 	AddSubscribersToListsByQuery           string `query:"add-subscribers-to-lists-by-query"`
 	BlocklistSubscribersByQuery            string `query:"blocklist-subscribers-by-query"`
+	SyncDynamicListByQuery                 string `query:"sync-dynamic-list-by-query"`
 	DeleteSubscriptionsByQuery             string `query:"delete-subscriptions-by-query"`
 	UnsubscribeSubscribersFromListsByQuery string `query:"unsubscribe-subscribers-from-lists-by-query"`
 
-	CreateList      *sqlx.Stmt `query:"create-list"`
-	GetLists        string     `query:"get-lists"`
-	GetListsByOptin *sqlx.Stmt `query:"get-lists-by-optin"`
-	UpdateList      *sqlx.Stmt `query:"update-list"`
-	UpdateListsDate *sqlx.Stmt `query:"update-lists-date"`
-	DeleteLists     *sqlx.Stmt `query:"delete-lists"`
+	CreateList              *sqlx.Stmt `query:"create-list"`
+	GetLists                string     `query:"get-lists"`
+	GetPublicLists          *sqlx.Stmt `query:"get-public-lists"`
+	GetListsByOptin         *sqlx.Stmt `query:"get-lists-by-optin"`
+	UpdateList              *sqlx.Stmt `query:"update-list"`
+	CloneList               *sqlx.Stmt `query:"clone-list"`
+	MergeLists              *sqlx.Stmt `query:"merge-lists"`
+	ArchiveList             *sqlx.Stmt `query:"archive-list"`
+	GetArchivedLists        *sqlx.Stmt `query:"get-archived-lists"`
+	GetListGrowthStats      *sqlx.Stmt `query:"get-list-growth-stats"`
+	RecordListSnapshots     *sqlx.Stmt `query:"record-list-snapshots"`
+	GetListSnapshots        *sqlx.Stmt `query:"get-list-snapshots"`
+	GetCappedLists          *sqlx.Stmt `query:"get-capped-lists"`
+	AddToListWaitlist       *sqlx.Stmt `query:"add-to-list-waitlist"`
+	GetListWaitlist         *sqlx.Stmt `query:"get-list-waitlist"`
+	PromoteListWaitlists    *sqlx.Stmt `query:"promote-list-waitlists"`
+	GetCampaignDynamicLists *sqlx.Stmt `query:"get-campaign-dynamic-lists"`
+	UpdateListsDate         *sqlx.Stmt `query:"update-lists-date"`
+	DeleteLists             *sqlx.Stmt `query:"delete-lists"`
+
+	GetListGroups      *sqlx.Stmt `query:"get-list-groups"`
+	GetListIDsByGroups *sqlx.Stmt `query:"get-list-ids-by-groups"`
+	CreateListGroup    *sqlx.Stmt `query:"create-list-group"`
+	UpdateListGroup    *sqlx.Stmt `query:"update-list-group"`
+	DeleteListGroup    *sqlx.Stmt `query:"delete-list-group"`
+
+	GetListWebhooks        *sqlx.Stmt `query:"get-list-webhooks"`
+	GetListWebhooksByEvent *sqlx.Stmt `query:"get-list-webhooks-by-event"`
+	CreateListWebhook      *sqlx.Stmt `query:"create-list-webhook"`
+	UpdateListWebhook      *sqlx.Stmt `query:"update-list-webhook"`
+	DeleteListWebhook      *sqlx.Stmt `query:"delete-list-webhook"`
+
+	GetWebhooks             *sqlx.Stmt `query:"get-webhooks"`
+	GetWebhooksByEvent      *sqlx.Stmt `query:"get-webhooks-by-event"`
+	CreateWebhook           *sqlx.Stmt `query:"create-webhook"`
+	UpdateWebhook           *sqlx.Stmt `query:"update-webhook"`
+	DeleteWebhook           *sqlx.Stmt `query:"delete-webhook"`
+	CreateWebhookDelivery   *sqlx.Stmt `query:"create-webhook-delivery"`
+	NextWebhookDeliveries   *sqlx.Stmt `query:"next-webhook-deliveries"`
+	UpdateWebhookDelivery   *sqlx.Stmt `query:"update-webhook-delivery"`
+	ResetWebhookDeliveries  *sqlx.Stmt `query:"reset-webhook-deliveries"`
+	QueryWebhookDeliveries  *sqlx.Stmt `query:"query-webhook-deliveries"`
+	RetryWebhookDelivery    *sqlx.Stmt `query:"retry-webhook-delivery"`
+	DeleteWebhookDeliveries *sqlx.Stmt `query:"delete-webhook-deliveries"`
 
 	CreateCampaign           *sqlx.Stmt `query:"create-campaign"`
 	QueryCampaigns           string     `query:"query-campaigns"`
@@ -55,13 +98,31 @@ type Queries struct {
 	GetCampaignForPreview    *sqlx.Stmt `query:"get-campaign-for-preview"`
 	GetCampaignStats         *sqlx.Stmt `query:"get-campaign-stats"`
 	GetCampaignStatus        *sqlx.Stmt `query:"get-campaign-status"`
+	GetCampaignProgress      *sqlx.Stmt `query:"get-campaign-progress"`
+	GetCampaignComparison    *sqlx.Stmt `query:"get-campaign-comparison"`
+	RecordDeferral           *sqlx.Stmt `query:"record-deferral"`
+	GetDomainDeliverability  *sqlx.Stmt `query:"get-domain-deliverability"`
+	ExportCampaignViews      *sqlx.Stmt `query:"export-campaign-views"`
+	ExportLinkClicks         *sqlx.Stmt `query:"export-link-clicks"`
 	NextCampaigns            *sqlx.Stmt `query:"next-campaigns"`
 	NextCampaignSubscribers  *sqlx.Stmt `query:"next-campaign-subscribers"`
+	NextQueuedCampaignSubs   *sqlx.Stmt `query:"next-queued-campaign-subscribers"`
+	QueueMessage             *sqlx.Stmt `query:"queue-message"`
+	NextQueuedMessages       *sqlx.Stmt `query:"next-queued-messages"`
+	UpdateQueuedMessages     *sqlx.Stmt `query:"update-queued-messages-status"`
+	DeleteQueuedMessages     *sqlx.Stmt `query:"delete-queued-messages"`
+	ResetMessageQueue        *sqlx.Stmt `query:"reset-message-queue"`
 	GetOneCampaignSubscriber *sqlx.Stmt `query:"get-one-campaign-subscriber"`
 	UpdateCampaign           *sqlx.Stmt `query:"update-campaign"`
 	UpdateCampaignStatus     *sqlx.Stmt `query:"update-campaign-status"`
+	GetFrequencyViolations   *sqlx.Stmt `query:"get-campaign-frequency-violations"`
 	UpdateCampaignCounts     *sqlx.Stmt `query:"update-campaign-counts"`
 	RegisterCampaignView     *sqlx.Stmt `query:"register-campaign-view"`
+	GetCampaignViewStats     *sqlx.Stmt `query:"get-campaign-view-stats"`
+	GetSubscriberViewStats   *sqlx.Stmt `query:"get-subscriber-view-stats"`
+	GetSubscriberEngagement  *sqlx.Stmt `query:"get-subscriber-engagement"`
+	RecordSubscriberCohorts  *sqlx.Stmt `query:"record-subscriber-cohorts"`
+	GetSubscriberCohorts     *sqlx.Stmt `query:"get-subscriber-cohorts"`
 	DeleteCampaign           *sqlx.Stmt `query:"delete-campaign"`
 
 	InsertMedia *sqlx.Stmt `query:"insert-media"`
@@ -70,15 +131,98 @@ type Queries struct {
 
 	CreateTemplate     *sqlx.Stmt `query:"create-template"`
 	GetTemplates       *sqlx.Stmt `query:"get-templates"`
+	GetTemplateFolders *sqlx.Stmt `query:"get-template-folders"`
+	GetTxTemplate      *sqlx.Stmt `query:"get-tx-template"`
+	InsertTxLog        *sqlx.Stmt `query:"insert-tx-log"`
+	UpdateTxLog        *sqlx.Stmt `query:"update-tx-log"`
+	QueryTxLog         *sqlx.Stmt `query:"query-tx-log"`
+	InsertDeliveryLog  *sqlx.Stmt `query:"insert-delivery-log"`
+	QueryDeliveryLog   *sqlx.Stmt `query:"query-delivery-log"`
+	DeleteDeliveryLog  *sqlx.Stmt `query:"delete-delivery-log"`
+	PruneCampaignViews *sqlx.Stmt `query:"prune-campaign-views"`
+	PruneLinkClicks    *sqlx.Stmt `query:"prune-link-clicks"`
 	UpdateTemplate     *sqlx.Stmt `query:"update-template"`
 	SetDefaultTemplate *sqlx.Stmt `query:"set-default-template"`
+	SetFallbackTpl     *sqlx.Stmt `query:"set-fallback-template"`
+	UnsetFallbackTpl   *sqlx.Stmt `query:"unset-fallback-template"`
+	GetTplActiveCamps  *sqlx.Stmt `query:"get-template-active-campaigns"`
+	UpdateTplThumb     *sqlx.Stmt `query:"update-template-thumb"`
+	InsertTplRevision  *sqlx.Stmt `query:"insert-template-revision"`
+
+	GetTplFixtures   *sqlx.Stmt `query:"get-template-fixtures"`
+	CreateTplFixture *sqlx.Stmt `query:"create-template-fixture"`
+	UpdateTplFixture *sqlx.Stmt `query:"update-template-fixture"`
+	DeleteTplFixture *sqlx.Stmt `query:"delete-template-fixture"`
+
+	GetTplVariants   *sqlx.Stmt `query:"get-template-variants"`
+	UpsertTplVariant *sqlx.Stmt `query:"upsert-template-variant"`
+	DeleteTplVariant *sqlx.Stmt `query:"delete-template-variant"`
+
+	GetTplPartials    *sqlx.Stmt `query:"get-template-partials"`
+	CreateTplPartial  *sqlx.Stmt `query:"create-template-partial"`
+	UpdateTplPartial  *sqlx.Stmt `query:"update-template-partial"`
+	DeleteTplPartial  *sqlx.Stmt `query:"delete-template-partial"`
+	GetTplPartialRefs *sqlx.Stmt `query:"get-template-partial-refs"`
+
+	GetSystemTpls   *sqlx.Stmt `query:"get-system-templates"`
+	GetSystemTpl    *sqlx.Stmt `query:"get-system-template"`
+	UpsertSystemTpl *sqlx.Stmt `query:"upsert-system-template"`
+	DeleteSystemTpl *sqlx.Stmt `query:"delete-system-template"`
+
+	GetTplRevisions    *sqlx.Stmt `query:"get-template-revisions"`
+	RestoreTplRevision *sqlx.Stmt `query:"restore-template-revision"`
 	DeleteTemplate     *sqlx.Stmt `query:"delete-template"`
 
-	CreateLink        *sqlx.Stmt `query:"create-link"`
-	RegisterLinkClick *sqlx.Stmt `query:"register-link-click"`
+	CreateLink             *sqlx.Stmt `query:"create-link"`
+	RegisterLinkClick      *sqlx.Stmt `query:"register-link-click"`
+	GetCampaignLinkCounts  *sqlx.Stmt `query:"get-campaign-link-counts"`
+	GetCampaignLinkStats   *sqlx.Stmt `query:"get-campaign-link-stats"`
+	GetCampaignGeoStats    *sqlx.Stmt `query:"get-campaign-geo-stats"`
+	GetCampaignClientStats *sqlx.Stmt `query:"get-campaign-client-stats"`
+	GetCampaignLinkHeatmap *sqlx.Stmt `query:"get-campaign-link-heatmap"`
+	GetCampaignUnsubStats  *sqlx.Stmt `query:"get-campaign-unsubscribe-stats"`
+	RecordConversion       *sqlx.Stmt `query:"record-conversion"`
+	GetCampaignFunnel      *sqlx.Stmt `query:"get-campaign-funnel"`
+
+	RecordBounce         *sqlx.Stmt `query:"record-bounce"`
+	RecordComplaint      *sqlx.Stmt `query:"record-complaint"`
+	RegisterCampDelivery *sqlx.Stmt `query:"register-campaign-delivery"`
+
+	GetSettings          *sqlx.Stmt `query:"get-settings"`
+	GetSettingsUpdatedAt *sqlx.Stmt `query:"get-settings-updated-at"`
+	UpdateSettings       *sqlx.Stmt `query:"update-settings"`
+
+	GetUsers          *sqlx.Stmt `query:"get-users"`
+	GetUserByUsername *sqlx.Stmt `query:"get-user-by-username"`
+	GetUserCount      *sqlx.Stmt `query:"get-user-count"`
+	CreateUser        *sqlx.Stmt `query:"create-user"`
+	UpdateUser        *sqlx.Stmt `query:"update-user"`
+	UpdateUserLogin   *sqlx.Stmt `query:"update-user-login"`
+	UpdateUserTOTP    *sqlx.Stmt `query:"update-user-totp"`
+	ConsumeRecCode    *sqlx.Stmt `query:"consume-user-recovery-code"`
+	DeleteUser        *sqlx.Stmt `query:"delete-user"`
+
+	CreateSession         *sqlx.Stmt `query:"create-session"`
+	GetSession            *sqlx.Stmt `query:"get-session"`
+	DeleteSession         *sqlx.Stmt `query:"delete-session"`
+	DeleteUserSessions    *sqlx.Stmt `query:"delete-user-sessions"`
+	DeleteExpiredSessions *sqlx.Stmt `query:"delete-expired-sessions"`
+
+	GetRoles   *sqlx.Stmt `query:"get-roles"`
+	UpdateRole *sqlx.Stmt `query:"update-role"`
+
+	UpsertAPIUsage *sqlx.Stmt `query:"upsert-api-usage"`
+	GetAPIUsage    *sqlx.Stmt `query:"get-api-usage"`
+
+	InsertAuditLog *sqlx.Stmt `query:"insert-audit-log"`
+	QueryAuditLog  *sqlx.Stmt `query:"query-audit-log"`
+	DeleteAuditLog *sqlx.Stmt `query:"delete-audit-log"`
 
-	GetSettings    *sqlx.Stmt `query:"get-settings"`
-	UpdateSettings *sqlx.Stmt `query:"update-settings"`
+	InsertIdempotencyKey         *sqlx.Stmt `query:"insert-idempotency-key"`
+	GetIdempotencyKey            *sqlx.Stmt `query:"get-idempotency-key"`
+	UpdateIdempotencyKey         *sqlx.Stmt `query:"update-idempotency-key"`
+	DeleteIdempotencyKey         *sqlx.Stmt `query:"delete-idempotency-key"`
+	DeleteExpiredIdempotencyKeys *sqlx.Stmt `query:"delete-expired-idempotency-keys"`
 
 	// GetStats *sqlx.Stmt `query:"get-stats"`
 }
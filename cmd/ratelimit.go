@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/knadh/listmonk/internal/ratelimit"
+	"github.com/labstack/echo"
+)
+
+// Window of the configured per-minute request limits.
+const rateLimitWindow = time.Minute
+
+// Rate limiting middlewares of the public subscription pages, the public
+// tracking endpoints, and the admin API.
+var (
+	limitPublic   = rateLimit(func(app *App) *ratelimit.KeyedLimiter { return app.publicLimit }, rateLimitKey)
+	limitTracking = rateLimit(func(app *App) *ratelimit.KeyedLimiter { return app.trackLimit }, trackLimitKey)
+	limitAPI      = rateLimit(func(app *App) *ratelimit.KeyedLimiter { return app.apiLimit }, rateLimitKey)
+)
+
+// rateLimit returns a middleware that limits the requests of each key
+// returned by getKey with the limiter returned by getLimiter and sets the
+// RateLimit-* headers on responses.
+func rateLimit(getLimiter func(*App) *ratelimit.KeyedLimiter, getKey func(echo.Context) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			l := getLimiter(c.Get("app").(*App))
+			if l == nil {
+				return next(c)
+			}
+
+			var (
+				st    = l.Reserve(getKey(c))
+				reset = strconv.Itoa(int(math.Ceil(st.Reset.Seconds())))
+				h     = c.Response().Header()
+			)
+			h.Set("RateLimit-Limit", strconv.Itoa(st.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(st.Remaining))
+			h.Set("RateLimit-Reset", reset)
+
+			if !st.Allowed {
+				h.Set("Retry-After", reset)
+				return echo.NewHTTPError(http.StatusTooManyRequests, "Too many requests. Try again later.")
+			}
+			return next(c)
+		}
+	}
+}
+
+// rateLimitKey returns the key that a request is limited by. Authenticated
+// requests are limited by their session or user and others by their IP.
+func rateLimitKey(c echo.Context) string {
+	if token, ok := c.Get("session").(string); ok {
+		return "session:" + hashSessionToken(token)
+	}
+	if u := getSessionUser(c); u.ID > 0 {
+		return "user:" + strconv.Itoa(u.ID)
+	}
+	return "ip:" + requestIP(c)
+}
+
+// trackLimitKey returns the key that a tracking request is limited by.
+// Views and clicks are limited per subscriber of a campaign and not by IP
+// as the image proxies of mail providers fetch for many subscribers from
+// a few IPs.
+func trackLimitKey(c echo.Context) string {
+	return "track:" + c.Param("campUUID") + ":" + c.Param("subUUID")
+}
+
+// requestIP returns the IP of the client of a request. X-Forwarded-For and
+// X-Real-IP are only trusted from the configured trusted proxies. Requests
+// with invalid forwarded headers are keyed by the IP of the peer without
+// the port as the port changes with every connection.
+func requestIP(c echo.Context) string {
+	app := c.Get("app").(*App)
+	if ip := app.adminAllowlist.clientIP(c.Request()); ip != nil {
+		return ip.String()
+	}
+
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		return c.Request().RemoteAddr
+	}
+	return host
+}
+
+// checkAuthLimit checks whether the failed login attempts of a request's
+// IP have reached the public rate limit.
+func checkAuthLimit(c echo.Context) error {
+	app := c.Get("app").(*App)
+	if app.publicLimit.Exceeded("auth:" + requestIP(c)) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many failed login attempts. Try again later.")
+	}
+	return nil
+}
+
+// countAuthFailure counts a failed login attempt of a request's IP.
+func countAuthFailure(c echo.Context) {
+	app := c.Get("app").(*App)
+	app.publicLimit.Reserve("auth:" + requestIP(c))
+}
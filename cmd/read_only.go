@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// readOnlyMode is the read-only (maintenance) mode of the API in which
+// requests that change data are rejected, eg: during DB migrations and
+// backups. Public pages and tracking aren't affected.
+type readOnlyMode struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+// Non-GET routes that don't change data and are allowed in read-only mode.
+var readOnlyAllowedRoutes = map[string]bool{
+	"POST /api/campaigns/:id/preview": true,
+	"POST /api/templates/:id/preview": true,
+	"POST /api/templates/preview":     true,
+	"POST /api/templates/lint":        true,
+	"POST /api/templates/:id/render":  true,
+	"POST /api/graphql":               true,
+	"POST /api/logout":                true,
+	"PUT /api/admin/read-only":        true,
+}
+
+// handleGetReadOnly handles retrieval of the read-only mode.
+func handleGetReadOnly(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	app.Lock()
+	out := app.readOnly
+	app.Unlock()
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateReadOnly handles turning the read-only mode on and off.
+func handleUpdateReadOnly(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req readOnlyMode
+	)
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if !req.Enabled {
+		req.Reason = ""
+	}
+	if !strHasLen(req.Reason, 0, stdInputMaxLen) {
+		return newFieldError("reason", "Invalid length for `reason`.")
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"app.read_only":        req.Enabled,
+		"app.read_only_reason": req.Reason,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error encoding settings: %v", err))
+	}
+
+	// Persist the mode so that it survives restarts.
+	if _, err := app.queries.UpdateSettings.Exec(b); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating settings: %s", pqErrMsg(err)))
+	}
+
+	app.Lock()
+	app.readOnly = req
+	app.Unlock()
+
+	if req.Enabled {
+		getLogger(c).Infof("API read-only mode enabled: %s", req.Reason)
+	} else {
+		getLogger(c).Infof("API read-only mode disabled")
+	}
+
+	return c.JSON(http.StatusOK, okResp{req})
+}
+
+// checkReadOnly middleware rejects requests that change data with a 503
+// when the API is in read-only mode.
+func checkReadOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if isSafeMethod(c.Request().Method) ||
+			readOnlyAllowedRoutes[c.Request().Method+" "+c.Path()] {
+			return next(c)
+		}
+
+		app := c.Get("app").(*App)
+		app.Lock()
+		mode := app.readOnly
+		app.Unlock()
+
+		if !mode.Enabled {
+			return next(c)
+		}
+
+		msg := "The API is in read-only mode."
+		if mode.Reason != "" {
+			msg += " " + mode.Reason
+		}
+		c.Response().Header().Set("Retry-After", "60")
+		return newHTTPError(http.StatusServiceUnavailable, errCodeReadOnly, msg)
+	}
+}
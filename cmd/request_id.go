@@ -0,0 +1,39 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/knadh/listmonk/internal/logger"
+	"github.com/labstack/echo"
+)
+
+const headerRequestID = "X-Request-ID"
+
+// Request IDs from clients and proxies that don't match this are replaced.
+var reRequestID = regexp.MustCompile(`^[a-zA-Z0-9._\-]{1,64}$`)
+
+// requestID middleware assigns an ID to every request that is returned in
+// the X-Request-ID header and logged with the log entries of the request.
+// A valid ID set by the client or a proxy in the header is used as is.
+func requestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get(headerRequestID)
+		if !reRequestID.MatchString(id) {
+			id, _ = generateRandomString(20)
+		}
+
+		c.Set("requestID", id)
+		c.Response().Header().Set(headerRequestID, id)
+		return next(c)
+	}
+}
+
+// getLogger returns the app log with the request's ID as a context field.
+// It shouldn't be used outside the request's lifetime, eg: in goroutines.
+func getLogger(c echo.Context) *logger.Logger {
+	app := c.Get("app").(*App)
+	if id, ok := c.Get("requestID").(string); ok && id != "" {
+		return app.log.With("request_id", id)
+	}
+	return app.log
+}
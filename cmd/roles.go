@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// Permissions that can be granted to the non-admin roles. Admins have all
+// the permissions and are the only ones that can access the admin routes.
+const (
+	permSubscribersGet    = "subscribers:get"
+	permSubscribersManage = "subscribers:manage"
+	permSubscribersImport = "subscribers:import"
+	permSubscribersExport = "subscribers:export"
+	permListsGet          = "lists:get"
+	permListsManage       = "lists:manage"
+	permCampaignsGet      = "campaigns:get"
+	permCampaignsManage   = "campaigns:manage"
+	permCampaignsSend     = "campaigns:send"
+	permTemplatesGet      = "templates:get"
+	permTemplatesManage   = "templates:manage"
+	permMediaGet          = "media:get"
+	permMediaManage       = "media:manage"
+	permTxGet             = "tx:get"
+	permTxSend            = "tx:send"
+)
+
+var permissions = []string{
+	permSubscribersGet, permSubscribersManage, permSubscribersImport, permSubscribersExport,
+	permListsGet, permListsManage,
+	permCampaignsGet, permCampaignsManage, permCampaignsSend,
+	permTemplatesGet, permTemplatesManage,
+	permMediaGet, permMediaManage,
+	permTxGet, permTxSend,
+}
+
+// Permissions required by the routes under a path prefix to read (GET)
+// and to make changes. Routes that aren't under any of the prefixes, such
+// as the dashboard and the user's own profile, can be accessed by all users.
+// GraphQL queries and the event stream check the permissions of the data
+// they return themselves.
+var routePermGroups = []struct {
+	prefix string
+	get    string
+	manage string
+}{
+	{"/api/subscribers", permSubscribersGet, permSubscribersManage},
+	{"/api/import", permSubscribersGet, permSubscribersImport},
+	{"/api/lists", permListsGet, permListsManage},
+	{"/api/campaigns", permCampaignsGet, permCampaignsManage},
+	{"/api/deliveries", permCampaignsGet, permCampaignsManage},
+	{"/api/templates", permTemplatesGet, permTemplatesManage},
+	{"/api/media", permMediaGet, permMediaManage},
+	{"/api/tx", permTxGet, permTxSend},
+}
+
+// Permissions of the routes that don't follow the defaults of their groups.
+var routePerms = map[string]string{
+	// Subscriber data exports.
+	"GET /api/subscribers/export":      permSubscribersExport,
+	"GET /api/subscribers/:id/export":  permSubscribersExport,
+	"GET /api/campaigns/events/export": permSubscribersExport,
+
+	// Starting, pausing, and cancelling campaigns.
+	"PUT /api/campaigns/:id/status": permCampaignsSend,
+
+	// Read-only previews and renders.
+	"POST /api/campaigns/:id/preview": permCampaignsGet,
+	"POST /api/templates/:id/preview": permTemplatesGet,
+	"POST /api/templates/preview":     permTemplatesGet,
+	"POST /api/templates/lint":        permTemplatesGet,
+	"POST /api/templates/:id/render":  permTemplatesGet,
+}
+
+// rolePerms holds the permissions of the non-admin roles.
+type rolePerms struct {
+	sync.Mutex
+	roles map[string]map[string]bool
+}
+
+// roleReq represents a role update request.
+type roleReq struct {
+	Permissions []string `json:"permissions"`
+}
+
+// load replaces the permissions of the roles.
+func (r *rolePerms) load(roles []models.Role) {
+	out := make(map[string]map[string]bool, len(roles))
+	for _, ro := range roles {
+		perms := make(map[string]bool, len(ro.Permissions))
+		for _, p := range ro.Permissions {
+			perms[p] = true
+		}
+		out[ro.Role] = perms
+	}
+
+	r.Lock()
+	r.roles = out
+	r.Unlock()
+}
+
+// has checks whether a role has a permission.
+func (r *rolePerms) has(role, perm string) bool {
+	if role == models.UserRoleAdmin {
+		return true
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	return r.roles[role][perm]
+}
+
+// get returns the permissions of a role.
+func (r *rolePerms) get(role string) []string {
+	out := []string{}
+	for _, p := range permissions {
+		if r.has(role, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// handleGetRoles handles retrieval of the roles and their permissions.
+func handleGetRoles(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		out []models.Role
+	)
+
+	if err := app.queries.GetRoles.Select(&out); err != nil {
+		getLogger(c).Errorf("error fetching roles: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching roles: %s", pqErrMsg(err)))
+	}
+
+	// Admins always have all the permissions.
+	out = append([]models.Role{{Role: models.UserRoleAdmin, Permissions: permissions}}, out...)
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetPermissions handles retrieval of the permissions that can be
+// granted to roles.
+func handleGetPermissions(c echo.Context) error {
+	return c.JSON(http.StatusOK, okResp{permissions})
+}
+
+// handleUpdateRole handles updating the permissions of a role.
+func handleUpdateRole(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		role = c.Param("role")
+		req  roleReq
+	)
+
+	if role == models.UserRoleAdmin {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"The permissions of admins can't be changed.")
+	}
+	if role != models.UserRoleCampaignManager && role != models.UserRoleViewer {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Role not found.")
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	perms := make(pq.StringArray, 0, len(req.Permissions))
+	for _, p := range req.Permissions {
+		p = strings.TrimSpace(p)
+		if !strSliceContains(p, permissions) {
+			return newFieldError("permissions", fmt.Sprintf("Unknown permission: %s", p))
+		}
+		if !strSliceContains(p, perms) {
+			perms = append(perms, p)
+		}
+	}
+
+	var out models.Role
+	if err := app.queries.UpdateRole.Get(&out, role, perms); err != nil {
+		getLogger(c).Errorf("error updating role: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating role: %s", pqErrMsg(err)))
+	}
+
+	if err := loadRolePerms(app); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching roles: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// loadRolePerms loads the permissions of the roles from the DB.
+func loadRolePerms(app *App) error {
+	var roles []models.Role
+	if err := app.queries.GetRoles.Select(&roles); err != nil {
+		app.log.Errorf("error fetching roles: %v", err)
+		return err
+	}
+	app.rolePerms.load(roles)
+	return nil
+}
+
+// checkRole middleware checks whether the authenticated user's role has
+// the permission required by the requested route.
+func checkRole(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var (
+			app  = c.Get("app").(*App)
+			u    = getSessionUser(c)
+			path = c.Path()
+		)
+
+		for _, p := range adminRoutes {
+			if (path == p || strings.HasPrefix(path, p+"/")) && u.Role != models.UserRoleAdmin {
+				return echo.NewHTTPError(http.StatusForbidden,
+					"You don't have the permission to do this.")
+			}
+		}
+
+		if perm := getRoutePerm(c.Request().Method, path); perm != "" && !app.rolePerms.has(u.Role, perm) {
+			return echo.NewHTTPError(http.StatusForbidden,
+				fmt.Sprintf("You don't have the permission to do this (%s).", perm))
+		}
+		return next(c)
+	}
+}
+
+// getRoutePerm returns the permission required to access a route. Routes
+// that don't require one return an empty string.
+func getRoutePerm(method, path string) string {
+	if p, ok := routePerms[method+" "+path]; ok {
+		return p
+	}
+
+	for _, g := range routePermGroups {
+		if path == g.prefix || strings.HasPrefix(path, g.prefix+"/") {
+			if isSafeMethod(method) {
+				return g.get
+			}
+			return g.manage
+		}
+	}
+	return ""
+}
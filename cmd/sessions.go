@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+)
+
+const (
+	tplLogin = "login"
+
+	sessionCookie = "listmonk_session"
+	csrfHeader    = "X-CSRF-Token"
+
+	// Interval at which expired sessions are deleted.
+	sessionPruneInterval = time.Hour
+)
+
+// session represents the user and CSRF token of an admin session.
+type session struct {
+	models.User
+
+	CSRFToken string `db:"csrf_token"`
+}
+
+// loginReq represents a login request from the login page or the API.
+type loginReq struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+
+	// Code is the TOTP code or a recovery code of users with 2FA enabled.
+	Code string `json:"code" form:"code"`
+	Next string `json:"-" form:"next"`
+}
+
+// loginResp represents the response of a successful login from the API.
+type loginResp struct {
+	User      models.User `json:"user"`
+	CSRFToken string      `json:"csrf_token"`
+}
+
+type loginTpl struct {
+	publicTpl
+	Username string
+	Next     string
+	Error    string
+}
+
+// handleLoginPage renders the login page of the admin UI and handles its
+// form submissions.
+func handleLoginPage(c echo.Context) error {
+	var req loginReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	out := loginTpl{
+		publicTpl: publicTpl{Title: "Login"},
+		Username:  req.Username,
+		Next:      req.Next,
+	}
+	if c.Request().Method == http.MethodGet {
+		return c.Render(http.StatusOK, tplLogin, out)
+	}
+
+	if _, _, err := login(c, req); err != nil {
+		if e, ok := err.(*echo.HTTPError); ok {
+			out.Error = fmt.Sprintf("%v", e.Message)
+			return c.Render(e.Code, tplLogin, out)
+		}
+		return err
+	}
+
+	// Only redirect to local paths and not to other hosts (//host).
+	next := req.Next
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/"
+	}
+	return c.Redirect(http.StatusFound, next)
+}
+
+// handleLogin handles a login request from the API and returns the user
+// and the CSRF token of the new session.
+func handleLogin(c echo.Context) error {
+	var req loginReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	u, csrf, err := login(c, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{loginResp{u, csrf}})
+}
+
+// handleLogout ends the session of the request.
+func handleLogout(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	if token, ok := c.Get("session").(string); ok {
+		if _, err := app.queries.DeleteSession.Exec(hashSessionToken(token)); err != nil {
+			getLogger(c).Errorf("error deleting session: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error logging out: %s", pqErrMsg(err)))
+		}
+	}
+	setSessionCookie(c, "", time.Unix(0, 0), app)
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// login checks the credentials and the 2FA code of a login request, starts
+// a session, and returns the user and the session's CSRF token.
+func login(c echo.Context, req loginReq) (models.User, string, error) {
+	app := c.Get("app").(*App)
+
+	var u models.User
+	if err := app.queries.GetUserByUsername.Get(&u, strings.TrimSpace(req.Username)); err != nil {
+		if err != sql.ErrNoRows {
+			getLogger(c).Errorf("error fetching user: %v", err)
+			return u, "", echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error fetching user: %s", pqErrMsg(err)))
+		}
+	}
+
+	if !checkPassword(u, req.Password) {
+		getLogger(c).Warnf("failed login for user '%s' from %s", req.Username, c.RealIP())
+		return u, "", echo.NewHTTPError(http.StatusUnauthorized, "Invalid username or password.")
+	}
+
+	if u.TOTPEnabled {
+		if req.Code == "" {
+			return u, "", newHTTPError(http.StatusUnauthorized, errCodeTwoFactorRequired, "Enter the 2FA code.")
+		}
+		ok, err := verifyTwoFactor(u, req.Code, app)
+		if err != nil {
+			return u, "", err
+		}
+		if !ok {
+			getLogger(c).Warnf("failed 2FA login for user '%s' from %s", u.Username, c.RealIP())
+			return u, "", echo.NewHTTPError(http.StatusUnauthorized, "Invalid 2FA code.")
+		}
+	}
+
+	var (
+		token  = randomToken()
+		csrf   = randomToken()
+		expiry = time.Now().Add(app.constants.SessionLifetime)
+	)
+	if token == "" || csrf == "" {
+		return u, "", echo.NewHTTPError(http.StatusInternalServerError, "Error generating session.")
+	}
+	if _, err := app.queries.CreateSession.Exec(hashSessionToken(token), u.ID, csrf,
+		c.RealIP(), c.Request().UserAgent(), expiry); err != nil {
+		getLogger(c).Errorf("error creating session: %v", err)
+		return u, "", echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating session: %s", pqErrMsg(err)))
+	}
+	if _, err := app.queries.UpdateUserLogin.Exec(u.ID); err != nil {
+		getLogger(c).Errorf("error updating user login: %v", err)
+	}
+	setSessionCookie(c, token, expiry, app)
+
+	c.Set("user", u)
+	recordAudit(c, "auth.login", map[string]interface{}{})
+
+	return u, csrf, nil
+}
+
+// getSession returns the session of a session token if it's valid.
+func getSession(token string, app *App) (session, bool, error) {
+	var s session
+	if err := app.queries.GetSession.Get(&s, hashSessionToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return s, false, nil
+		}
+		app.log.Errorf("error fetching session: %v", err)
+		return s, false, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching session: %s", pqErrMsg(err)))
+	}
+	return s, true, nil
+}
+
+// deleteUserSessions ends the sessions of a user except for the session of
+// the request, eg: after a password change.
+func deleteUserSessions(c echo.Context, userID int, app *App) {
+	token, _ := c.Get("session").(string)
+	if _, err := app.queries.DeleteUserSessions.Exec(userID, hashSessionToken(token)); err != nil {
+		getLogger(c).Errorf("error deleting user sessions: %v", err)
+	}
+}
+
+// setSessionCookie sets the session cookie with the configured flags.
+func setSessionCookie(c echo.Context, token string, expiry time.Time, app *App) {
+	sameSite := http.SameSiteLaxMode
+	switch app.constants.SessionCookieSameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   app.constants.SessionCookieSecure,
+		SameSite: sameSite,
+	})
+}
+
+// pruneSessions is a blocking function that deletes expired sessions at
+// intervals.
+func pruneSessions(app *App) {
+	ticker := time.NewTicker(sessionPruneInterval)
+	for ; true; <-ticker.C {
+		if _, err := app.queries.DeleteExpiredSessions.Exec(); err != nil {
+			app.log.Errorf("error pruning sessions: %v", err)
+		}
+	}
+}
+
+// isSafeMethod checks whether an HTTP method is read-only.
+func isSafeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions
+}
+
+// randomToken returns a random hex token or an empty string if the
+// random source fails.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func hashSessionToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"syscall"
@@ -11,9 +14,33 @@ import (
 
 	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/internal/bounce"
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/dkim"
+	"github.com/knadh/listmonk/internal/logger"
+	"github.com/knadh/listmonk/internal/mailsign"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/messenger"
+	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/messenger/sendmail"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
+// msgrOpts are the options common to the messenger blocks other than SMTP.
+type msgrOpts struct {
+	UUID            string `json:"uuid"`
+	Enabled         bool   `json:"enabled"`
+	Name            string `json:"name"`
+	MaxMsgRetries   int    `json:"max_msg_retries"`
+	RetryBackoff    string `json:"retry_backoff"`
+	RetryMaxBackoff string `json:"retry_max_backoff"`
+	MessageRate     int    `json:"message_rate"`
+	HourlyLimit     int    `json:"hourly_limit"`
+}
+
 type settings struct {
 	AppRootURL       string   `json:"app.root_url"`
 	AppLogoURL       string   `json:"app.logo_url"`
@@ -24,12 +51,57 @@ type settings struct {
 	AppConcurrency   int      `json:"app.concurrency"`
 	AppMaxSendErrors int      `json:"app.max_send_errors"`
 	AppMessageRate   int      `json:"app.message_rate"`
+	AppTxMessageRate int      `json:"app.tx_message_rate"`
+	AppTxHourlyLimit int      `json:"app.tx_hourly_limit"`
+
+	AppRateLimitPublic   int `json:"app.rate_limit_public"`
+	AppRateLimitTracking int `json:"app.rate_limit_tracking"`
+	AppRateLimitAPI      int `json:"app.rate_limit_api"`
+
+	AppCORSOrigins []string `json:"app.cors_origins"`
+	AppCORSMethods []string `json:"app.cors_methods"`
+	AppCORSHeaders []string `json:"app.cors_headers"`
+
+	AppLegacyAPISunset string `json:"app.legacy_api_sunset"`
+
+	AppAdminAllowedIPs []string `json:"app.admin_allowed_ips"`
+	AppTrustedProxies  []string `json:"app.trusted_proxies"`
+
+	AppDomainLimits []manager.DomainLimit `json:"app.domain_limits"`
+
+	AppDeliveryLog          bool   `json:"app.delivery_log"`
+	AppDeliveryLogRetention string `json:"app.delivery_log_retention"`
+	AppAuditLogRetention    string `json:"app.audit_log_retention"`
+
+	// Retention of the raw campaign views and link clicks. Their daily
+	// counts are kept forever, but unique subscribers, locations, clients,
+	// and link positions are only reported for the retention period.
+	AppViewsRetention  string `json:"app.views_retention"`
+	AppClicksRetention string `json:"app.clicks_retention"`
+
+	AppLogLevel  string `json:"app.log_level"`
+	AppLogFormat string `json:"app.log_format"`
+
+	AppSessionLifetime       string `json:"app.session_lifetime"`
+	AppSessionCookieSecure   bool   `json:"app.session_cookie_secure"`
+	AppSessionCookieSameSite string `json:"app.session_cookie_samesite"`
+
+	AppMessageHeaders []manager.Header `json:"app.message_headers"`
+
+	AppGreylistDelay        string `json:"app.greylist_delay"`
+	AppGreylistMaxDeferrals int    `json:"app.greylist_max_deferrals"`
+
+	// How often the subscriptions of dynamic lists are refreshed from their queries.
+	AppDynamicListSyncInterval string `json:"app.dynamic_list_sync_interval"`
+
+	AppEnablePublicListDirectory bool `json:"app.enable_public_list_directory"`
 
 	PrivacyIndividualTracking bool     `json:"privacy.individual_tracking"`
 	PrivacyUnsubHeader        bool     `json:"privacy.unsubscribe_header"`
 	PrivacyAllowBlocklist     bool     `json:"privacy.allow_blocklist"`
 	PrivacyAllowExport        bool     `json:"privacy.allow_export"`
 	PrivacyAllowWipe          bool     `json:"privacy.allow_wipe"`
+	PrivacyIPTruncation       string   `json:"privacy.ip_truncation"`
 	PrivacyExportable         []string `json:"privacy.exportable"`
 
 	UploadProvider             string `json:"upload.provider"`
@@ -45,38 +117,158 @@ type settings struct {
 	UploadS3Expiry             string `json:"upload.s3.expiry"`
 
 	SMTP []struct {
-		UUID          string              `json:"uuid"`
-		Enabled       bool                `json:"enabled"`
-		Host          string              `json:"host"`
-		HelloHostname string              `json:"hello_hostname"`
-		Port          int                 `json:"port"`
-		AuthProtocol  string              `json:"auth_protocol"`
-		Username      string              `json:"username"`
-		Password      string              `json:"password,omitempty"`
-		EmailHeaders  []map[string]string `json:"email_headers"`
-		MaxConns      int                 `json:"max_conns"`
-		MaxMsgRetries int                 `json:"max_msg_retries"`
-		IdleTimeout   string              `json:"idle_timeout"`
-		WaitTimeout   string              `json:"wait_timeout"`
-		TLSEnabled    bool                `json:"tls_enabled"`
-		TLSSkipVerify bool                `json:"tls_skip_verify"`
+		UUID            string              `json:"uuid"`
+		Enabled         bool                `json:"enabled"`
+		Host            string              `json:"host"`
+		HelloHostname   string              `json:"hello_hostname"`
+		Port            int                 `json:"port"`
+		AuthProtocol    string              `json:"auth_protocol"`
+		Username        string              `json:"username"`
+		Password        string              `json:"password,omitempty"`
+		EmailHeaders    []map[string]string `json:"email_headers"`
+		MaxConns        int                 `json:"max_conns"`
+		MaxMsgRetries   int                 `json:"max_msg_retries"`
+		RetryBackoff    string              `json:"retry_backoff"`
+		RetryMaxBackoff string              `json:"retry_max_backoff"`
+		IdleTimeout     string              `json:"idle_timeout"`
+		WaitTimeout     string              `json:"wait_timeout"`
+		TLSEnabled      bool                `json:"tls_enabled"`
+		TLSSkipVerify   bool                `json:"tls_skip_verify"`
+		Weight          int                 `json:"weight"`
+		MessageRate     int                 `json:"message_rate"`
+		HourlyLimit     int                 `json:"hourly_limit"`
 	} `json:"smtp"`
 
 	Messengers []struct {
+		msgrOpts
+
+		RootURL    string `json:"root_url"`
+		Username   string `json:"username"`
+		Password   string `json:"password,omitempty"`
+		AuthHeader string `json:"auth_header,omitempty"`
+		MaxConns   int    `json:"max_conns"`
+		Timeout    string `json:"timeout"`
+	} `json:"messengers"`
+
+	SES []struct {
+		msgrOpts
+
+		Region           string `json:"region"`
+		AccessKey        string `json:"access_key"`
+		SecretKey        string `json:"secret_key,omitempty"`
+		ConfigurationSet string `json:"configuration_set"`
+		EmailFormat      string `json:"email_format"`
+		MaxConns         int    `json:"max_conns"`
+		Timeout          string `json:"timeout"`
+	} `json:"ses"`
+
+	SendGrid []struct {
+		msgrOpts
+
+		APIKey      string   `json:"api_key,omitempty"`
+		Categories  []string `json:"categories"`
+		EmailFormat string   `json:"email_format"`
+		BatchSize   int      `json:"batch_size"`
+		BatchWait   string   `json:"batch_wait"`
+		MaxConns    int      `json:"max_conns"`
+		Timeout     string   `json:"timeout"`
+	} `json:"sendgrid"`
+
+	Mailgun []struct {
+		msgrOpts
+
+		APIKey      string   `json:"api_key,omitempty"`
+		Domain      string   `json:"domain"`
+		Region      string   `json:"region"`
+		Tags        []string `json:"tags"`
+		EmailFormat string   `json:"email_format"`
+		MaxConns    int      `json:"max_conns"`
+		Timeout     string   `json:"timeout"`
+	} `json:"mailgun"`
+
+	Postmark []struct {
+		msgrOpts
+
+		BroadcastStream string `json:"broadcast_stream"`
+		BroadcastToken  string `json:"broadcast_token,omitempty"`
+		TxStream        string `json:"tx_stream"`
+		TxToken         string `json:"tx_token,omitempty"`
+		EmailFormat     string `json:"email_format"`
+		MaxConns        int    `json:"max_conns"`
+		Timeout         string `json:"timeout"`
+	} `json:"postmark"`
+
+	SparkPost []struct {
+		msgrOpts
+
+		APIKey        string `json:"api_key,omitempty"`
+		Region        string `json:"region"`
+		Substitutions bool   `json:"substitutions"`
+		EmailFormat   string `json:"email_format"`
+		MaxConns      int    `json:"max_conns"`
+		Timeout       string `json:"timeout"`
+	} `json:"sparkpost"`
+
+	Sendmail []struct {
+		msgrOpts
+
+		Path        string   `json:"path"`
+		Args        []string `json:"args"`
+		EmailFormat string   `json:"email_format"`
+		Timeout     string   `json:"timeout"`
+	} `json:"sendmail"`
+
+	SMS []struct {
+		msgrOpts
+
+		Provider    string `json:"provider"`
+		PhoneAttrib string `json:"phone_attrib"`
+		Twilio      struct {
+			AccountSID          string `json:"account_sid"`
+			AuthToken           string `json:"auth_token,omitempty"`
+			From                string `json:"from"`
+			MessagingServiceSID string `json:"messaging_service_sid"`
+		} `json:"twilio"`
+		MaxConns int    `json:"max_conns"`
+		Timeout  string `json:"timeout"`
+	} `json:"sms"`
+
+	DKIM           []dkim.Key     `json:"dkim"`
+	MessageSigning []mailsign.Key `json:"message_signing"`
+
+	BounceEnabled    bool                    `json:"bounce.enabled"`
+	BounceSESEnabled bool                    `json:"bounce.ses_enabled"`
+	BounceSESTopics  []string                `json:"bounce.ses_topic_arns"`
+	BounceSGEnabled  bool                    `json:"bounce.sendgrid_enabled"`
+	BounceSGKey      string                  `json:"bounce.sendgrid_key,omitempty"`
+	BounceMGEnabled  bool                    `json:"bounce.mailgun_enabled"`
+	BounceMGKey      string                  `json:"bounce.mailgun_key,omitempty"`
+	BounceActions    map[string]bounceAction `json:"bounce.actions"`
+	BounceRules      []bounce.Rule           `json:"bounce.rules"`
+	BounceMailboxes  []struct {
 		UUID          string `json:"uuid"`
 		Enabled       bool   `json:"enabled"`
-		Name          string `json:"name"`
-		RootURL       string `json:"root_url"`
+		Type          string `json:"type"`
+		Host          string `json:"host"`
+		Port          int    `json:"port"`
 		Username      string `json:"username"`
 		Password      string `json:"password,omitempty"`
-		MaxConns      int    `json:"max_conns"`
-		Timeout       string `json:"timeout"`
-		MaxMsgRetries int    `json:"max_msg_retries"`
-	} `json:"messengers"`
+		TLSEnabled    bool   `json:"tls_enabled"`
+		TLSSkipVerify bool   `json:"tls_skip_verify"`
+		Folder        string `json:"folder"`
+		ScanInterval  string `json:"scan_interval"`
+	} `json:"bounce.mailboxes"`
+
+	BounceVERPEnabled bool   `json:"bounce.verp_enabled"`
+	BounceVERPPattern string `json:"bounce.verp_pattern"`
+	BounceVERPDomain  string `json:"bounce.verp_domain"`
 }
 
 var (
 	reAlphaNum = regexp.MustCompile(`[^a-z0-9\-]`)
+
+	// Header field names (RFC 5322).
+	reHeaderName = regexp.MustCompile(`^[!-9;-~]+$`)
 )
 
 // handleGetSettings returns settings from the DB.
@@ -94,9 +286,48 @@ func handleGetSettings(c echo.Context) error {
 	}
 	for i := 0; i < len(s.Messengers); i++ {
 		s.Messengers[i].Password = ""
+		s.Messengers[i].AuthHeader = ""
+	}
+	for i := 0; i < len(s.SES); i++ {
+		s.SES[i].SecretKey = ""
+	}
+	for i := 0; i < len(s.SendGrid); i++ {
+		s.SendGrid[i].APIKey = ""
+	}
+	for i := 0; i < len(s.Mailgun); i++ {
+		s.Mailgun[i].APIKey = ""
+	}
+	for i := 0; i < len(s.Postmark); i++ {
+		s.Postmark[i].BroadcastToken = ""
+		s.Postmark[i].TxToken = ""
+	}
+	for i := 0; i < len(s.SparkPost); i++ {
+		s.SparkPost[i].APIKey = ""
+	}
+	for i := 0; i < len(s.SMS); i++ {
+		s.SMS[i].Twilio.AuthToken = ""
 	}
+	for i := 0; i < len(s.DKIM); i++ {
+		s.DKIM[i].PrivateKey = ""
+	}
+	for i := 0; i < len(s.MessageSigning); i++ {
+		s.MessageSigning[i].PrivateKey = ""
+		s.MessageSigning[i].Passphrase = ""
+	}
+	for i := 0; i < len(s.BounceMailboxes); i++ {
+		s.BounceMailboxes[i].Password = ""
+	}
+	s.BounceSGKey = ""
+	s.BounceMGKey = ""
 	s.UploadS3AwsSecretAccessKey = ""
 
+	var updatedAt null.Time
+	if err := app.queries.GetSettingsUpdatedAt.Get(&updatedAt); err != nil {
+		getLogger(c).Errorf("error fetching settings timestamp: %v", err)
+	} else if updatedAt.Valid {
+		c.Response().Header().Set("Last-Modified", updatedAt.Time.UTC().Format(http.TimeFormat))
+	}
+
 	return c.JSON(http.StatusOK, okResp{s})
 }
 
@@ -118,12 +349,176 @@ func handleUpdateSettings(c echo.Context) error {
 		return err
 	}
 
+	if set.AppTxMessageRate < 0 || set.AppTxHourlyLimit < 0 {
+		return newFieldError("app.tx_message_rate",
+			"Transactional rate limits should be 0 (unlimited) or more.")
+	}
+	if set.AppRateLimitPublic < 0 || set.AppRateLimitTracking < 0 || set.AppRateLimitAPI < 0 {
+		return newFieldError("app.rate_limit_public",
+			"Request rate limits should be 0 (unlimited) or more.")
+	}
+
+	// CORS origins should be * or scheme://host[:port] without a path.
+	for i, o := range set.AppCORSOrigins {
+		o = strings.TrimRight(strings.TrimSpace(o), "/")
+		if o != "*" {
+			u, err := url.Parse(o)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+				u.Path != "" || u.RawQuery != "" {
+				return newFieldError("app.cors_origins", fmt.Sprintf("Invalid CORS origin: %s", o))
+			}
+		}
+		set.AppCORSOrigins[i] = o
+	}
+	for i, m := range set.AppCORSMethods {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		switch m {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			return newFieldError("app.cors_methods", fmt.Sprintf("Invalid CORS method: %s", m))
+		}
+		set.AppCORSMethods[i] = m
+	}
+	for i, h := range set.AppCORSHeaders {
+		set.AppCORSHeaders[i] = strings.TrimSpace(h)
+		if !reHeaderName.MatchString(set.AppCORSHeaders[i]) {
+			return newFieldError("app.cors_headers", fmt.Sprintf("Invalid CORS header: %s", h))
+		}
+	}
+
+	if set.AppLegacyAPISunset != "" {
+		if _, err := time.Parse("2006-01-02", set.AppLegacyAPISunset); err != nil {
+			return newFieldError("app.legacy_api_sunset", "Invalid legacy API sunset date. Use YYYY-MM-DD.")
+		}
+	}
+
+	// Admins shouldn't be able to lock themselves out with the allowlist.
+	for i, v := range set.AppAdminAllowedIPs {
+		set.AppAdminAllowedIPs[i] = strings.TrimSpace(v)
+	}
+	for i, v := range set.AppTrustedProxies {
+		set.AppTrustedProxies[i] = strings.TrimSpace(v)
+	}
+	al, err := newIPAllowlist(set.AppAdminAllowedIPs, set.AppTrustedProxies)
+	if err != nil {
+		return newFieldError("app.admin_allowed_ips", fmt.Sprintf("Invalid IP allowlist: %v", err))
+	}
+	if !al.allows(c.Request()) {
+		return newFieldError("app.admin_allowed_ips",
+			fmt.Sprintf("The IP allowlist should include the IP of this request (%s).", al.clientIP(c.Request())))
+	}
+
+	for _, d := range set.AppDomainLimits {
+		if len(d.Domains) == 0 {
+			return newFieldError("app.domain_limits", "Domain limits should have at least one domain.")
+		}
+		if d.Concurrency < 0 || d.MessageRate < 0 || d.HourlyLimit < 0 {
+			return newFieldError("app.domain_limits",
+				"Domain limits should be 0 (unlimited) or more.")
+		}
+	}
+
+	for i, h := range set.AppMessageHeaders {
+		set.AppMessageHeaders[i].Name = strings.TrimSpace(h.Name)
+		if !reHeaderName.MatchString(set.AppMessageHeaders[i].Name) {
+			return newFieldError("app.message_headers", fmt.Sprintf("Invalid header name: %s", h.Name))
+		}
+		if strings.ContainsAny(h.Value, "\r\n") {
+			return newFieldError("app.message_headers", fmt.Sprintf("Invalid value for header: %s", h.Name))
+		}
+	}
+
+	if set.AppDeliveryLogRetention != "" {
+		if d, err := time.ParseDuration(set.AppDeliveryLogRetention); err != nil || d < 0 {
+			return newFieldError("app.delivery_log_retention", "Invalid delivery log retention duration.")
+		}
+	}
+	if set.AppAuditLogRetention != "" {
+		if d, err := time.ParseDuration(set.AppAuditLogRetention); err != nil || d < 0 {
+			return newFieldError("app.audit_log_retention", "Invalid audit log retention duration.")
+		}
+	}
+	if set.AppViewsRetention != "" {
+		d, err := time.ParseDuration(set.AppViewsRetention)
+		if err != nil || d < 0 {
+			return newFieldError("app.views_retention", "Invalid views retention duration.")
+		}
+		if d > 0 && d < trackingMinRetention {
+			return newFieldError("app.views_retention", "The views retention should be at least 90 days (2160h).")
+		}
+	}
+	if set.AppClicksRetention != "" {
+		d, err := time.ParseDuration(set.AppClicksRetention)
+		if err != nil || d < 0 {
+			return newFieldError("app.clicks_retention", "Invalid clicks retention duration.")
+		}
+		if d > 0 && d < trackingMinRetention {
+			return newFieldError("app.clicks_retention", "The clicks retention should be at least 90 days (2160h).")
+		}
+	}
+
+	if _, err := logger.ParseLevel(set.AppLogLevel); err != nil {
+		return newFieldError("app.log_level", "Invalid log level. Use debug, info, warn, or error.")
+	}
+	if set.AppLogFormat != logger.FormatText && set.AppLogFormat != logger.FormatJSON {
+		return newFieldError("app.log_format", "Invalid log format. Use text or json.")
+	}
+
+	switch set.PrivacyIPTruncation {
+	case ipTruncateNone, ipTruncatePartial, ipTruncateFull:
+	default:
+		return newFieldError("privacy.ip_truncation", "Invalid IP truncation. Use none, partial, or full.")
+	}
+
+	if d, err := time.ParseDuration(set.AppSessionLifetime); err != nil || d < time.Minute {
+		return newFieldError("app.session_lifetime",
+			"Invalid session lifetime. It should be at least a minute.")
+	}
+	switch set.AppSessionCookieSameSite {
+	case "lax", "strict":
+	case "none":
+		// Browsers reject SameSite=None cookies without Secure.
+		if !set.AppSessionCookieSecure {
+			return newFieldError("app.session_cookie_samesite",
+				"SameSite none session cookies should be secure.")
+		}
+	default:
+		return newFieldError("app.session_cookie_samesite", "Invalid session cookie SameSite value.")
+	}
+
+	if set.AppGreylistDelay != "" {
+		if d, err := time.ParseDuration(set.AppGreylistDelay); err != nil || d < 0 {
+			return newFieldError("app.greylist_delay", "Invalid greylisting delay.")
+		}
+	}
+	if set.AppGreylistMaxDeferrals < 0 {
+		return newFieldError("app.greylist_max_deferrals", "Greylisting deferrals should be 0 or more.")
+	}
+	if set.AppDynamicListSyncInterval != "" {
+		if d, err := time.ParseDuration(set.AppDynamicListSyncInterval); err != nil || d < time.Minute {
+			return newFieldError("app.dynamic_list_sync_interval",
+				"Invalid dynamic list sync interval. It should be at least a minute.")
+		}
+	}
+
 	// There should be at least one SMTP block that's enabled.
 	has := false
 	for i, s := range set.SMTP {
+		if s.MessageRate < 0 || s.HourlyLimit < 0 {
+			return newFieldError("smtp",
+				"Messenger rate limits should be 0 (unlimited) or more.")
+		}
+		if err := validateRetry("smtp", s.MaxMsgRetries, s.RetryBackoff, s.RetryMaxBackoff); err != nil {
+			return err
+		}
+
 		if s.Enabled {
 			has = true
 		}
+		if s.Weight < 0 || s.Weight > 1000 {
+			return newFieldError("smtp",
+				"SMTP weight should be between 1 and 1000.")
+		}
 
 		// Assign a UUID. The frontend only sends a password when the user explictly
 		// changes the password. In other cases, the existing password in the DB
@@ -144,7 +539,7 @@ func handleUpdateSettings(c echo.Context) error {
 		}
 	}
 	if !has {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newFieldError("smtp",
 			"At least one SMTP block should be enabled.")
 	}
 
@@ -152,31 +547,280 @@ func handleUpdateSettings(c echo.Context) error {
 	// and "email" is a reserved name.
 	names := map[string]bool{emailMsgr: true}
 
-	for i, m := range set.Messengers {
+	for i := range set.Messengers {
 		// UUID to keep track of password changes similar to the SMTP logic above.
-		if m.UUID == "" {
-			set.Messengers[i].UUID = uuid.Must(uuid.NewV4()).String()
+		m := &set.Messengers[i]
+		if err := validateMsgr("messengers", &m.msgrOpts, []*string{&m.Password, &m.AuthHeader}, func(id string) []string {
+			for _, c := range cur.Messengers {
+				if c.UUID == id {
+					return []string{c.Password, c.AuthHeader}
+				}
+			}
+			return nil
+		}, names); err != nil {
+			return err
+		}
+	}
+
+	// SES messengers share the namespace of postback messengers.
+	for i := range set.SES {
+		m := &set.SES[i]
+		if err := validateMsgr("ses", &m.msgrOpts, []*string{&m.SecretKey}, func(id string) []string {
+			for _, c := range cur.SES {
+				if c.UUID == id {
+					return []string{c.SecretKey}
+				}
+			}
+			return nil
+		}, names); err != nil {
+			return err
+		}
+		if m.Enabled && m.Region == "" {
+			return newFieldError("ses",
+				fmt.Sprintf("Invalid region for SES messenger `%s`.", m.Name))
+		}
+	}
+
+	for i := range set.SendGrid {
+		m := &set.SendGrid[i]
+		if err := validateMsgr("sendgrid", &m.msgrOpts, []*string{&m.APIKey}, func(id string) []string {
+			for _, c := range cur.SendGrid {
+				if c.UUID == id {
+					return []string{c.APIKey}
+				}
+			}
+			return nil
+		}, names); err != nil {
+			return err
 		}
+		if m.Enabled && m.APIKey == "" {
+			return newFieldError("sendgrid",
+				fmt.Sprintf("Invalid API key for SendGrid messenger `%s`.", m.Name))
+		}
+		if m.BatchSize < 0 || m.BatchSize > 1000 {
+			return newFieldError("sendgrid",
+				"SendGrid batch size should be between 1 and 1000.")
+		}
+	}
 
+	for i := range set.Mailgun {
+		m := &set.Mailgun[i]
+		if err := validateMsgr("mailgun", &m.msgrOpts, []*string{&m.APIKey}, func(id string) []string {
+			for _, c := range cur.Mailgun {
+				if c.UUID == id {
+					return []string{c.APIKey}
+				}
+			}
+			return nil
+		}, names); err != nil {
+			return err
+		}
+		if m.Enabled && (m.Domain == "" || m.APIKey == "") {
+			return newFieldError("mailgun",
+				fmt.Sprintf("Invalid domain or API key for Mailgun messenger `%s`.", m.Name))
+		}
+		if m.Region != "" && m.Region != "us" && m.Region != "eu" {
+			return newFieldError("mailgun",
+				fmt.Sprintf("Invalid region for Mailgun messenger `%s`.", m.Name))
+		}
+	}
+
+	for i := range set.Postmark {
+		// Tokens are copied individually as either can be changed.
+		m := &set.Postmark[i]
+		if err := validateMsgr("postmark", &m.msgrOpts, []*string{&m.BroadcastToken, &m.TxToken}, func(id string) []string {
+			for _, c := range cur.Postmark {
+				if c.UUID == id {
+					return []string{c.BroadcastToken, c.TxToken}
+				}
+			}
+			return nil
+		}, names); err != nil {
+			return err
+		}
+		if m.Enabled && m.BroadcastToken == "" && m.TxToken == "" {
+			return newFieldError("postmark",
+				fmt.Sprintf("No server token for Postmark messenger `%s`.", m.Name))
+		}
+	}
+
+	for i := range set.SparkPost {
+		m := &set.SparkPost[i]
+		if err := validateMsgr("sparkpost", &m.msgrOpts, []*string{&m.APIKey}, func(id string) []string {
+			for _, c := range cur.SparkPost {
+				if c.UUID == id {
+					return []string{c.APIKey}
+				}
+			}
+			return nil
+		}, names); err != nil {
+			return err
+		}
+		if m.Enabled && m.APIKey == "" {
+			return newFieldError("sparkpost",
+				fmt.Sprintf("Invalid API key for SparkPost messenger `%s`.", m.Name))
+		}
+		if m.Region != "" && m.Region != "us" && m.Region != "eu" {
+			return newFieldError("sparkpost",
+				fmt.Sprintf("Invalid region for SparkPost messenger `%s`.", m.Name))
+		}
+	}
+
+	for i := range set.Sendmail {
+		m := &set.Sendmail[i]
+		if err := validateMsgr("sendmail", &m.msgrOpts, nil, nil, names); err != nil {
+			return err
+		}
+		if m.Path != "" && !filepath.IsAbs(m.Path) {
+			return newFieldError("sendmail",
+				fmt.Sprintf("Sendmail path for messenger `%s` should be absolute.", m.Name))
+		}
+		if m.Enabled {
+			path := m.Path
+			if path == "" {
+				path = sendmail.DefaultPath
+			}
+			if _, err := exec.LookPath(path); err != nil {
+				return newFieldError("sendmail",
+					fmt.Sprintf("Invalid sendmail binary for messenger `%s`: %v", m.Name, err))
+			}
+		}
+	}
+
+	for i := range set.SMS {
+		m := &set.SMS[i]
+		if err := validateMsgr("sms", &m.msgrOpts, []*string{&m.Twilio.AuthToken}, func(id string) []string {
+			for _, c := range cur.SMS {
+				if c.UUID == id {
+					return []string{c.Twilio.AuthToken}
+				}
+			}
+			return nil
+		}, names); err != nil {
+			return err
+		}
+		if m.Provider != "twilio" {
+			return newFieldError("sms",
+				fmt.Sprintf("Unknown SMS provider for messenger `%s`.", m.Name))
+		}
+	}
+
+	// DKIM keys are matched by domain. Parse them to validate.
+	for i, k := range set.DKIM {
+		if k.PrivateKey != "" {
+			continue
+		}
+		for _, c := range cur.DKIM {
+			if strings.EqualFold(k.Domain, c.Domain) {
+				set.DKIM[i].PrivateKey = c.PrivateKey
+			}
+		}
+	}
+	if _, err := dkim.New(set.DKIM); err != nil {
+		return newFieldError("dkim", err.Error())
+	}
+
+	// S/MIME and PGP signing keys are matched by identity. Parse them to validate.
+	for i, k := range set.MessageSigning {
+		for _, c := range cur.MessageSigning {
+			if !strings.EqualFold(k.Identity, c.Identity) || k.Type != c.Type {
+				continue
+			}
+			if k.PrivateKey == "" {
+				set.MessageSigning[i].PrivateKey = c.PrivateKey
+				if k.Passphrase == "" {
+					set.MessageSigning[i].Passphrase = c.Passphrase
+				}
+			}
+		}
+	}
+	if _, err := mailsign.New(set.MessageSigning); err != nil {
+		return newFieldError("message_signing", err.Error())
+	}
+
+	// Bounce actions.
+	for typ, a := range set.BounceActions {
+		if typ != models.BounceTypeSoft && typ != models.BounceTypeHard &&
+			typ != models.BounceTypeBlock && typ != models.BounceTypeAutoReply {
+			return newFieldError("bounce.actions",
+				fmt.Sprintf("Unknown bounce type `%s`.", typ))
+		}
+		if a.Action != bounceActionNone && a.Action != bounceActionBlocklist && a.Action != bounceActionDelete {
+			return newFieldError("bounce.actions",
+				fmt.Sprintf("Invalid action for bounce type `%s`.", typ))
+		}
+		if a.Count < 0 {
+			return newFieldError("bounce.actions",
+				fmt.Sprintf("Invalid count for bounce type `%s`.", typ))
+		}
+	}
+
+	// Bounce classification rules.
+	if _, err := bounce.NewClassifier(set.BounceRules); err != nil {
+		return newFieldError("bounce.rules", err.Error())
+	}
+
+	// SNS topics that SES notifications are accepted from.
+	for _, t := range set.BounceSESTopics {
+		if !strings.HasPrefix(strings.TrimSpace(t), "arn:aws") {
+			return newFieldError("bounce.ses_topic_arns", fmt.Sprintf("Invalid SNS topic ARN: %s", t))
+		}
+	}
+	if set.BounceSESEnabled && len(set.BounceSESTopics) == 0 {
+		return newFieldError("bounce.ses_topic_arns",
+			"Enter the ARNs of the SNS topics to accept SES notifications from.")
+	}
+
+	// SendGrid webhook verification key.
+	if set.BounceSGKey == "" {
+		set.BounceSGKey = cur.BounceSGKey
+	}
+	if set.BounceSGEnabled {
+		if _, err := bounce.NewSendGrid(set.BounceSGKey); err != nil {
+			return newFieldError("bounce.sendgrid_key",
+				fmt.Sprintf("Invalid SendGrid verification key: %v", err))
+		}
+	}
+
+	// Mailgun webhook signing key.
+	if set.BounceMGKey == "" {
+		set.BounceMGKey = cur.BounceMGKey
+	}
+	if set.BounceMGEnabled && strings.TrimSpace(set.BounceMGKey) == "" {
+		return newFieldError("bounce.mailgun_key", "Invalid Mailgun webhook signing key.")
+	}
+
+	// VERP return addresses.
+	if set.BounceVERPEnabled {
+		if _, err := bounce.NewVERP(set.BounceVERPPattern, set.BounceVERPDomain); err != nil {
+			return newFieldError("bounce.verp_pattern", fmt.Sprintf("Invalid VERP settings: %v", err))
+		}
+	}
+
+	// Bounce mailboxes are matched by UUID to copy the existing passwords.
+	for i, m := range set.BounceMailboxes {
+		if m.UUID == "" {
+			set.BounceMailboxes[i].UUID = uuid.Must(uuid.NewV4()).String()
+		}
 		if m.Password == "" {
-			for _, c := range cur.Messengers {
+			for _, c := range cur.BounceMailboxes {
 				if m.UUID == c.UUID {
-					set.Messengers[i].Password = c.Password
+					set.BounceMailboxes[i].Password = c.Password
 				}
 			}
 		}
 
-		name := reAlphaNum.ReplaceAllString(strings.ToLower(m.Name), "")
-		if _, ok := names[name]; ok {
-			return echo.NewHTTPError(http.StatusBadRequest,
-				fmt.Sprintf("Duplicate messenger name `%s`.", name))
+		if m.Type != mailbox.TypePOP && m.Type != mailbox.TypeIMAP {
+			return newFieldError("bounce.mailboxes",
+				fmt.Sprintf("Invalid type for bounce mailbox `%s`.", m.Host))
 		}
-		if len(name) == 0 {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid messenger name.")
+		if strings.TrimSpace(m.Host) == "" {
+			return newFieldError("bounce.mailboxes", "Invalid bounce mailbox host.")
+		}
+		if d, err := time.ParseDuration(m.ScanInterval); err != nil || d < time.Minute {
+			return newFieldError("bounce.mailboxes",
+				fmt.Sprintf("Scan interval for bounce mailbox `%s` should be at least 1m.", m.Host))
 		}
-
-		set.Messengers[i].Name = name
-		names[name] = true
 	}
 
 	// S3 password?
@@ -218,12 +862,127 @@ func handleUpdateSettings(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// validateMsgr validates the options common to the messenger blocks. A new
+// block is assigned a UUID and the secrets left empty in an existing block
+// are copied from its saved version, which saved returns in the order of
+// secrets. The sanitized name is checked against and added to names. field
+// is the settings key of the block in errors.
+func validateMsgr(field string, m *msgrOpts, secrets []*string, saved func(uuid string) []string, names map[string]bool) error {
+	if m.MessageRate < 0 || m.HourlyLimit < 0 {
+		return newFieldError(field,
+			"Messenger rate limits should be 0 (unlimited) or more.")
+	}
+	if err := validateRetry(field, m.MaxMsgRetries, m.RetryBackoff, m.RetryMaxBackoff); err != nil {
+		return err
+	}
+
+	if m.UUID == "" {
+		m.UUID = uuid.Must(uuid.NewV4()).String()
+	} else if saved != nil {
+		if old := saved(m.UUID); old != nil {
+			for i, s := range secrets {
+				if *s == "" {
+					*s = old[i]
+				}
+			}
+		}
+	}
+
+	name := reAlphaNum.ReplaceAllString(strings.ToLower(m.Name), "")
+	if _, ok := names[name]; ok {
+		return newFieldError(field,
+			fmt.Sprintf("Duplicate messenger name `%s`.", name))
+	}
+	if len(name) == 0 {
+		return newFieldError(field, "Invalid messenger name.")
+	}
+
+	m.Name = name
+	names[name] = true
+	return nil
+}
+
+// validateRetry validates the retry policy of the messenger block with the
+// settings key field.
+func validateRetry(field string, attempts int, backoff, maxBackoff string) error {
+	if attempts < 0 || attempts > 100 {
+		return newFieldError(field,
+			"Messenger retries should be between 1 and 100.")
+	}
+	for _, v := range []string{backoff, maxBackoff} {
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			return newFieldError(field,
+				fmt.Sprintf("Invalid messenger retry backoff `%s`.", v))
+		}
+	}
+	return nil
+}
+
 // handleGetLogs returns the log entries stored in the log buffer.
 func handleGetLogs(c echo.Context) error {
 	app := c.Get("app").(*App)
 	return c.JSON(http.StatusOK, okResp{app.bufLog.Lines()})
 }
 
+// handleGetSMTPStatus returns the health and usage of the SMTP servers.
+func handleGetSMTPStatus(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	em, ok := app.messengers[emailMsgr].(*email.Emailer)
+	if !ok {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{em.Status()})
+}
+
+// handleGetMessengerMetrics returns the runtime metrics of the messengers
+// and the depth of the message queues.
+func handleGetMessengerMetrics(c echo.Context) error {
+	app := c.Get("app").(*App)
+	return c.JSON(http.StatusOK, okResp{app.manager.Metrics()})
+}
+
+// handleTestSMTP tests the connectivity and authentication of the SMTP
+// servers live. If an e-mail is given, a probe message is sent to it via
+// every server.
+func handleTestSMTP(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req struct {
+			ToEmail string `json:"to_email"`
+		}
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	em, ok := app.messengers[emailMsgr].(*email.Emailer)
+	if !ok {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "SMTP messenger not found.")
+	}
+
+	var probe *messenger.Message
+	if req.ToEmail != "" {
+		to := strings.ToLower(strings.TrimSpace(req.ToEmail))
+		if !subimporter.IsEmail(to) {
+			return newFieldError("to_email", "Invalid `to_email`.")
+		}
+
+		probe = &messenger.Message{
+			From:    app.constants.FromEmail,
+			To:      []string{to},
+			Subject: "listmonk SMTP test",
+			Body:    []byte("<p>This is a test message from listmonk to verify the SMTP settings.</p>"),
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{em.Test(probe)})
+}
+
 func getSettings(app *App) (settings, error) {
 	var (
 		b   types.JSONText
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	// Interval at which the engagement of subscriber cohorts is recorded.
+	subCohortsInterval = time.Hour
+
+	// Maximum number of monthly cohorts that can be fetched at once.
+	subCohortsMaxMonths = 60
+
+	monthFormat = "2006-01"
+)
+
+// subCohortMonth represents the engagement of a cohort's subscribers in a
+// month. MonthIndex is the number of months since the cohort's month, and
+// the rates are percentages of the cohort's subscribers.
+type subCohortMonth struct {
+	Cohort      string  `db:"cohort" json:"-"`
+	Subscribers int     `db:"subscribers" json:"-"`
+	Month       string  `db:"month" json:"month"`
+	MonthIndex  int     `db:"month_index" json:"month_index"`
+	Opened      int     `db:"opened" json:"opened"`
+	Clicked     int     `db:"clicked" json:"clicked"`
+	Engaged     int     `db:"engaged" json:"engaged"`
+	OpenRate    float64 `db:"-" json:"open_rate"`
+	ClickRate   float64 `db:"-" json:"click_rate"`
+	EngagedRate float64 `db:"-" json:"engaged_rate"`
+}
+
+// subCohort represents the subscribers who signed up in a month and their
+// engagement in the months since.
+type subCohort struct {
+	Cohort      string           `json:"cohort"`
+	Subscribers int              `json:"subscribers"`
+	Months      []subCohortMonth `json:"months"`
+}
+
+// handleGetSubscriberCohorts handles retrieval of the monthly engagement of
+// the cohorts of subscribers who signed up between the `from` and `to`
+// months (YYYY-MM), which default to the last 12 months.
+func handleGetSubscriberCohorts(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		now  = time.Now()
+		to   = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		from = to.AddDate(0, -11, 0)
+		out  []subCohortMonth
+	)
+
+	if v := c.FormValue("from"); v != "" {
+		t, err := time.Parse(monthFormat, v)
+		if err != nil {
+			return newFieldError("from", "Invalid `from` month.")
+		}
+		from = t
+	}
+	if v := c.FormValue("to"); v != "" {
+		t, err := time.Parse(monthFormat, v)
+		if err != nil {
+			return newFieldError("to", "Invalid `to` month.")
+		}
+		to = t
+	}
+	if from.After(to) || from.AddDate(0, subCohortsMaxMonths, 0).Before(to) {
+		return newFieldError("from", "Invalid month range.")
+	}
+
+	if err := app.queries.GetSubscriberCohorts.Select(&out,
+		from.Format(dateFormat), to.Format(dateFormat)); err != nil {
+		getLogger(c).Errorf("error fetching subscriber cohorts: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching subscriber stats: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	// Group the months by cohort. The rows are ordered by cohort.
+	var cohorts []subCohort
+	for _, m := range out {
+		m.OpenRate = percent(m.Opened, m.Subscribers)
+		m.ClickRate = percent(m.Clicked, m.Subscribers)
+		m.EngagedRate = percent(m.Engaged, m.Subscribers)
+
+		if n := len(cohorts); n == 0 || cohorts[n-1].Cohort != m.Cohort {
+			cohorts = append(cohorts, subCohort{Cohort: m.Cohort})
+		}
+
+		// The cohort's size is its latest recorded one.
+		co := &cohorts[len(cohorts)-1]
+		co.Subscribers = m.Subscribers
+		co.Months = append(co.Months, m)
+	}
+
+	return c.JSON(http.StatusOK, okResp{cohorts})
+}
+
+// recordSubscriberCohorts is a blocking function that records the monthly
+// engagement of subscriber cohorts at intervals.
+func recordSubscriberCohorts(app *App) {
+	ticker := time.NewTicker(subCohortsInterval)
+	for ; true; <-ticker.C {
+		if _, err := app.queries.RecordSubscriberCohorts.Exec(); err != nil {
+			app.log.Errorf("error recording subscriber cohorts: %v", err)
+		}
+	}
+}
@@ -1,24 +1,37 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/webhooks"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 const (
 	dummyUUID = "00000000-0000-0000-0000-000000000000"
+
+	// subExportBatchSize is the number of subscribers fetched from the DB
+	// at a time while streaming a CSV export.
+	subExportBatchSize = 1000
+
+	// subBatchMaxItems is the maximum number of items in a batch request.
+	subBatchMaxItems = 1000
 )
 
 // subQueryReq is a "catch all" struct for reading various
@@ -31,6 +44,29 @@ type subQueryReq struct {
 	Action        string        `json:"action"`
 }
 
+// subBatchReq represents a batch of subscribers to create, or update if
+// they have IDs.
+type subBatchReq struct {
+	Subscribers []subimporter.SubReq `json:"subscribers"`
+}
+
+// subListsBatchReq represents a batch of list membership changes.
+type subListsBatchReq struct {
+	Items []subQueryReq `json:"items"`
+}
+
+// batchResult represents the outcome of an item in a batch request.
+type batchResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+
+	// Error message and its machine-readable code and field errors.
+	Error  string       `json:"error,omitempty"`
+	Code   string       `json:"code,omitempty"`
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
 type subsWrap struct {
 	Results models.Subscribers `json:"results"`
 
@@ -96,14 +132,32 @@ func handleQuerySubscribers(c echo.Context) error {
 		query   = sanitizeSQLExp(c.FormValue("query"))
 		orderBy = c.FormValue("order_by")
 		order   = c.FormValue("order")
-		out     subsWrap
 	)
 
-	listIDs := pq.Int64Array{}
-	if listID < 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid `list_id`.")
-	} else if listID > 0 {
-		listIDs = append(listIDs, int64(listID))
+	out, err := querySubscribers(query, listID, orderBy, order, pg, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleExportSubscribers handles querying subscribers based on an arbitrary SQL expression
+// and streams the results as a CSV file. If `flatten_attribs` is set, every top level
+// attribute key becomes an individual column instead of a single JSON `attribs` column.
+func handleExportSubscribers(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+
+		// The "WHERE ?" bit.
+		query   = sanitizeSQLExp(c.FormValue("query"))
+		flatten = c.FormValue("flatten_attribs") == "true"
+	)
+
+	// Limit the subscribers to specific lists?
+	listIDs, err := getQueryListIDs(c.QueryParams())
+	if err != nil {
+		return err
 	}
 
 	// There's an arbitrary query condition.
@@ -112,50 +166,89 @@ func handleQuerySubscribers(c echo.Context) error {
 		cond = " AND " + query
 	}
 
-	// Sort params.
-	if !strSliceContains(orderBy, subQuerySortFields) {
-		orderBy = "updated_at"
-	}
-	if order != sortAsc && order != sortDesc {
-		order = sortAsc
-	}
-
-	stmt := fmt.Sprintf(app.queries.QuerySubscribers, cond, orderBy, order)
-
 	// Create a readonly transaction to prevent mutations.
 	tx, err := app.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
 	if err != nil {
-		app.log.Printf("error preparing subscriber query: %v", err)
+		getLogger(c).Errorf("error preparing subscriber query: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error preparing subscriber query: %v", pqErrMsg(err)))
 	}
 	defer tx.Rollback()
 
-	// Run the query. stmt is the raw SQL query.
-	if err := tx.Select(&out.Results, stmt, listIDs, pg.Offset, pg.Limit); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			fmt.Sprintf("Error querying subscribers: %v", pqErrMsg(err)))
+	// Collect the attribute keys across all matching subscribers to build
+	// the flattened column headers.
+	var attribKeys []string
+	if flatten {
+		stmt := fmt.Sprintf(app.queries.QuerySubscriberAttribKeysForExport, cond)
+		if err := tx.Select(&attribKeys, stmt, listIDs); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error querying subscribers: %v", pqErrMsg(err)))
+		}
 	}
 
-	// Lazy load lists for each subscriber.
-	if err := out.Results.LoadLists(app.queries.GetSubscriberListsLazy); err != nil {
-		app.log.Printf("error fetching subscriber lists: %v", err)
+	var (
+		stmt   = fmt.Sprintf(app.queries.QuerySubscribersForExport, cond)
+		lastID = 0
+		out    []models.SubscriberExport
+	)
+
+	// Fetch the first batch before writing anything to the response so that
+	// query errors can still be returned as regular HTTP errors.
+	if err := tx.Select(&out, stmt, listIDs, lastID, subExportBatchSize); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
-			fmt.Sprintf("Error fetching subscriber lists: %v", pqErrMsg(err)))
+			fmt.Sprintf("Error querying subscribers: %v", pqErrMsg(err)))
 	}
 
-	out.Query = query
-	if len(out.Results) == 0 {
-		out.Results = make(models.Subscribers, 0)
-		return c.JSON(http.StatusOK, okResp{out})
+	h := c.Response().Header()
+	h.Set("Content-Type", "text/csv")
+	h.Set("Content-Disposition", `attachment; filename="subscribers.csv"`)
+	h.Set("Cache-Control", "no-cache")
+	c.Response().WriteHeader(http.StatusOK)
+
+	wr := csv.NewWriter(c.Response())
+	hdr := []string{"uuid", "email", "name", "status", "created_at", "updated_at"}
+	if flatten {
+		hdr = append(hdr, attribKeys...)
+	} else {
+		hdr = append(hdr, "attribs")
+	}
+	if err := wr.Write(hdr); err != nil {
+		getLogger(c).Errorf("error writing subscriber export: %v", err)
+		return nil
 	}
 
-	// Meta.
-	out.Total = out.Results[0].Total
-	out.Page = pg.Page
-	out.PerPage = pg.PerPage
+	for len(out) > 0 {
+		for _, s := range out {
+			row := []string{s.UUID, s.Email, s.Name, s.Status,
+				formatExportTime(s.CreatedAt), formatExportTime(s.UpdatedAt)}
 
-	return c.JSON(http.StatusOK, okResp{out})
+			if flatten {
+				for _, k := range attribKeys {
+					row = append(row, formatExportAttrib(s.Attribs[k]))
+				}
+			} else {
+				row = append(row, formatExportAttrib(s.Attribs))
+			}
+
+			if err := wr.Write(row); err != nil {
+				getLogger(c).Errorf("error writing subscriber export: %v", err)
+				return nil
+			}
+		}
+		wr.Flush()
+		c.Response().Flush()
+
+		// Fetch the next batch.
+		lastID = out[len(out)-1].ID
+		out = out[:0]
+		if err := tx.Select(&out, stmt, listIDs, lastID, subExportBatchSize); err != nil {
+			getLogger(c).Errorf("error querying subscribers for export: %v", err)
+			return nil
+		}
+	}
+
+	wr.Flush()
+	return nil
 }
 
 // handleCreateSubscriber handles the creation of a new subscriber.
@@ -169,13 +262,8 @@ func handleCreateSubscriber(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
-	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
-	if err := subimporter.ValidateFields(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-	}
 
-	// Insert the subscriber into the DB.
-	sub, err := insertSubscriber(req, app)
+	sub, err := createSubscriber(req, app)
 	if err != nil {
 		return err
 	}
@@ -195,34 +283,10 @@ func handleUpdateSubscriber(c echo.Context) error {
 		return err
 	}
 
-	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
-	}
-	if req.Email != "" && !subimporter.IsEmail(req.Email) {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid `email`.")
-	}
-	if req.Name != "" && !strHasLen(req.Name, 1, stdInputMaxLen) {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid length for `name`.")
-	}
-
-	_, err := app.queries.UpdateSubscriber.Exec(req.ID,
-		strings.ToLower(strings.TrimSpace(req.Email)),
-		strings.TrimSpace(req.Name),
-		req.Status,
-		req.Attribs,
-		req.Lists)
-	if err != nil {
-		app.log.Printf("error updating subscriber: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			fmt.Sprintf("Error updating subscriber: %v", pqErrMsg(err)))
-	}
-
-	// Send a confirmation e-mail (if there are any double opt-in lists).
-	sub, err := getSubscriber(int(id), app)
+	sub, err := updateSubscriber(id, req, app)
 	if err != nil {
 		return err
 	}
-	_ = sendOptinConfirmation(sub, []int64(req.Lists), app)
 
 	return c.JSON(http.StatusOK, okResp{sub})
 }
@@ -236,22 +300,22 @@ func handleSubscriberSendOptin(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid subscriber ID.")
+		return newFieldError("id", "Invalid subscriber ID.")
 	}
 
 	// Fetch the subscriber.
 	err := app.queries.GetSubscriber.Select(&out, id, nil)
 	if err != nil {
-		app.log.Printf("error fetching subscriber: %v", err)
+		getLogger(c).Errorf("error fetching subscriber: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching subscriber: %s", pqErrMsg(err)))
 	}
 	if len(out) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Subscriber not found.")
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Subscriber not found.")
 	}
 
 	if err := sendOptinConfirmation(out[0], nil, app); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			"Error sending opt-in e-mail.")
 	}
 
@@ -271,25 +335,25 @@ func handleBlocklistSubscribers(c echo.Context) error {
 	if pID != "" {
 		id, _ := strconv.ParseInt(pID, 10, 64)
 		if id < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+			return newFieldError("id", "Invalid ID.")
 		}
 		IDs = append(IDs, id)
 	} else {
 		// Multiple IDs.
 		var req subQueryReq
 		if err := c.Bind(&req); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return newFieldError("ids",
 				fmt.Sprintf("One or more invalid IDs given: %v", err))
 		}
 		if len(req.SubscriberIDs) == 0 {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return newFieldError("ids",
 				"No IDs given.")
 		}
 		IDs = req.SubscriberIDs
 	}
 
 	if _, err := app.queries.BlocklistSubscribers.Exec(IDs); err != nil {
-		app.log.Printf("error blocklisting subscribers: %v", err)
+		getLogger(c).Errorf("error blocklisting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error blocklisting: %v", err))
 	}
@@ -311,47 +375,100 @@ func handleManageSubscriberLists(c echo.Context) error {
 	if pID != "" {
 		id, _ := strconv.ParseInt(pID, 10, 64)
 		if id < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+			return newFieldError("id", "Invalid ID.")
 		}
 		IDs = append(IDs, id)
 	}
 
 	var req subQueryReq
 	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newFieldError("ids",
 			fmt.Sprintf("One or more invalid IDs given: %v", err))
 	}
 	if len(req.SubscriberIDs) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newFieldError("ids",
 			"No IDs given.")
 	}
 	if len(IDs) == 0 {
 		IDs = req.SubscriberIDs
 	}
-	if len(req.TargetListIDs) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "No lists given.")
+
+	if err := manageSubscriberLists(IDs, req.TargetListIDs, req.Action, app); err != nil {
+		return err
 	}
 
-	// Action.
-	var err error
-	switch req.Action {
-	case "add":
-		_, err = app.queries.AddSubscribersToLists.Exec(IDs, req.TargetListIDs)
-	case "remove":
-		_, err = app.queries.DeleteSubscriptions.Exec(IDs, req.TargetListIDs)
-	case "unsubscribe":
-		_, err = app.queries.UnsubscribeSubscribersFromLists.Exec(IDs, req.TargetListIDs)
-	default:
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid action.")
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleBatchSubscribers handles the creation and modification of a batch
+// of subscribers. Subscribers with IDs are updated and the rest are created.
+// Each item is processed independently and its result is returned.
+func handleBatchSubscribers(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req subBatchReq
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if len(req.Subscribers) == 0 {
+		return newFieldError("subscribers", "No subscribers given.")
+	}
+	if len(req.Subscribers) > subBatchMaxItems {
+		return newFieldError("subscribers",
+			fmt.Sprintf("Too many subscribers. Max is %d.", subBatchMaxItems))
 	}
 
-	if err != nil {
-		app.log.Printf("error updating subscriptions: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			fmt.Sprintf("Error processing lists: %v", err))
+	out := make([]batchResult, 0, len(req.Subscribers))
+	for i, r := range req.Subscribers {
+		var (
+			sub models.Subscriber
+			err error
+		)
+		if r.ID > 0 {
+			sub, err = updateSubscriber(int64(r.ID), r, app)
+		} else {
+			sub, err = createSubscriber(r, app)
+		}
+		out = append(out, makeBatchResult(i, sub, err))
 	}
 
-	return c.JSON(http.StatusOK, okResp{true})
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleBatchSubscriberLists handles a batch of list membership changes,
+// each of which is the same as a handleManageSubscriberLists request.
+func handleBatchSubscriberLists(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req subListsBatchReq
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return newFieldError("items",
+			fmt.Sprintf("One or more invalid IDs given: %v", err))
+	}
+	if len(req.Items) == 0 {
+		return newFieldError("items", "No items given.")
+	}
+	if len(req.Items) > subBatchMaxItems {
+		return newFieldError("items",
+			fmt.Sprintf("Too many items. Max is %d.", subBatchMaxItems))
+	}
+
+	out := make([]batchResult, 0, len(req.Items))
+	for i, r := range req.Items {
+		var err error
+		if len(r.SubscriberIDs) == 0 {
+			err = newFieldError("ids", "No IDs given.")
+		} else {
+			err = manageSubscriberLists(r.SubscriberIDs, r.TargetListIDs, r.Action, app)
+		}
+		out = append(out, makeBatchResult(i, true, err))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
 // handleDeleteSubscribers handles subscriber deletion.
@@ -367,25 +484,25 @@ func handleDeleteSubscribers(c echo.Context) error {
 	if pID != "" {
 		id, _ := strconv.ParseInt(pID, 10, 64)
 		if id < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+			return newFieldError("id", "Invalid ID.")
 		}
 		IDs = append(IDs, id)
 	} else {
 		// Multiple IDs.
 		i, err := parseStringIDs(c.Request().URL.Query()["id"])
 		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return newFieldError("id",
 				fmt.Sprintf("One or more invalid IDs given: %v", err))
 		}
 		if len(i) == 0 {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return newFieldError("id",
 				"No IDs given.")
 		}
 		IDs = i
 	}
 
 	if _, err := app.queries.DeleteSubscribers.Exec(IDs, nil); err != nil {
-		app.log.Printf("error deleting subscribers: %v", err)
+		getLogger(c).Errorf("error deleting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error deleting subscribers: %v", err))
 	}
@@ -409,10 +526,12 @@ func handleDeleteSubscribersByQuery(c echo.Context) error {
 		app.queries.DeleteSubscribersByQuery,
 		req.ListIDs, app.db)
 	if err != nil {
-		app.log.Printf("error querying subscribers: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
+		getLogger(c).Errorf("error querying subscribers: %v", err)
+		return newFieldError("query",
 			fmt.Sprintf("Error: %v", err))
 	}
+	setAuditMeta(c, "query", req.Query)
+	setAuditMeta(c, "list_ids", req.ListIDs)
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
@@ -433,8 +552,8 @@ func handleBlocklistSubscribersByQuery(c echo.Context) error {
 		app.queries.BlocklistSubscribersByQuery,
 		req.ListIDs, app.db)
 	if err != nil {
-		app.log.Printf("error blocklisting subscribers: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
+		getLogger(c).Errorf("error blocklisting subscribers: %v", err)
+		return newFieldError("query",
 			fmt.Sprintf("Error: %v", err))
 	}
 
@@ -453,7 +572,7 @@ func handleManageSubscriberListsByQuery(c echo.Context) error {
 		return err
 	}
 	if len(req.TargetListIDs) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "No lists given.")
+		return newFieldError("target_list_ids", "No lists given.")
 	}
 
 	// Action.
@@ -466,14 +585,14 @@ func handleManageSubscriberListsByQuery(c echo.Context) error {
 	case "unsubscribe":
 		stmt = app.queries.UnsubscribeSubscribersFromListsByQuery
 	default:
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid action.")
+		return newFieldError("action", "Invalid action.")
 	}
 
 	err := app.queries.execSubscriberQueryTpl(sanitizeSQLExp(req.Query),
 		stmt, req.ListIDs, app.db, req.TargetListIDs)
 	if err != nil {
-		app.log.Printf("error updating subscriptions: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
+		getLogger(c).Errorf("error updating subscriptions: %v", err)
+		return newFieldError("query",
 			fmt.Sprintf("Error: %v", err))
 	}
 
@@ -491,7 +610,7 @@ func handleExportSubscriberData(c echo.Context) error {
 	)
 	id, _ := strconv.ParseInt(pID, 10, 64)
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	// Get the subscriber's data. A single query that gets the profile,
@@ -499,8 +618,8 @@ func handleExportSubscriberData(c echo.Context) error {
 	// private lists are replaced with "Private list".
 	_, b, err := exportSubscriberData(id, "", app.constants.Privacy.Exportable, app)
 	if err != nil {
-		app.log.Printf("error exporting subscriber data: %s", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
+		getLogger(c).Errorf("error exporting subscriber data: %s", err)
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			"Error exporting subscriber data.")
 	}
 
@@ -527,10 +646,10 @@ func insertSubscriber(req subimporter.SubReq, app *App) (models.Subscriber, erro
 		req.ListUUIDs)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "subscribers_email_key" {
-			return req.Subscriber, echo.NewHTTPError(http.StatusBadRequest, "The e-mail already exists.")
+			return req.Subscriber, newHTTPError(http.StatusBadRequest, errCodeExists, "The e-mail already exists.")
 		}
 
-		app.log.Printf("error inserting subscriber: %v", err)
+		app.log.Errorf("error inserting subscriber: %v", err)
 		return req.Subscriber, echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error inserting subscriber: %v", err))
 	}
@@ -543,9 +662,173 @@ func insertSubscriber(req subimporter.SubReq, app *App) (models.Subscriber, erro
 
 	// Send a confirmation e-mail (if there are any double opt-in lists).
 	_ = sendOptinConfirmation(sub, []int64(req.Lists), app)
+
+	pushListWebhooks(webhooks.EventSubscribe, sub, req.Lists, req.ListUUIDs, app)
+	return sub, nil
+}
+
+// createSubscriber validates a subscriber and the fields required by its
+// lists and inserts it.
+func createSubscriber(req subimporter.SubReq, app *App) (models.Subscriber, error) {
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if err := subimporter.ValidateFields(req); err != nil {
+		return req.Subscriber, newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+	}
+
+	// Enforce the fields required by the lists.
+	fields, err := getListFields(req.Lists, req.ListUUIDs, app)
+	if err != nil {
+		return req.Subscriber, err
+	}
+	if err := validateListFields(fields, req.Attribs); err != nil {
+		return req.Subscriber, err
+	}
+
+	// Insert the subscriber into the DB.
+	return insertSubscriber(req, app)
+}
+
+// updateSubscriber validates and updates a subscriber and returns it.
+func updateSubscriber(id int64, req subimporter.SubReq, app *App) (models.Subscriber, error) {
+	if id < 1 {
+		return req.Subscriber, newFieldError("id", "Invalid ID.")
+	}
+	if req.Email != "" && !subimporter.IsEmail(req.Email) {
+		return req.Subscriber, newFieldError("email", "Invalid `email`.")
+	}
+	if req.Name != "" && !strHasLen(req.Name, 1, stdInputMaxLen) {
+		return req.Subscriber, newFieldError("name", "Invalid length for `name`.")
+	}
+
+	_, err := app.queries.UpdateSubscriber.Exec(id,
+		strings.ToLower(strings.TrimSpace(req.Email)),
+		strings.TrimSpace(req.Name),
+		req.Status,
+		req.Attribs,
+		req.Lists)
+	if err != nil {
+		app.log.Errorf("error updating subscriber: %v", err)
+		return req.Subscriber, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating subscriber: %v", pqErrMsg(err)))
+	}
+
+	// Send a confirmation e-mail (if there are any double opt-in lists).
+	sub, err := getSubscriber(int(id), app)
+	if err != nil {
+		return sub, err
+	}
+	_ = sendOptinConfirmation(sub, []int64(req.Lists), app)
+
 	return sub, nil
 }
 
+// manageSubscriberLists adds, removes, or unsubscribes subscribers to or
+// from lists.
+func manageSubscriberLists(IDs, listIDs pq.Int64Array, action string, app *App) error {
+	if len(listIDs) == 0 {
+		return newFieldError("target_list_ids", "No lists given.")
+	}
+
+	// Action.
+	var err error
+	switch action {
+	case "add":
+		_, err = app.queries.AddSubscribersToLists.Exec(IDs, listIDs)
+	case "remove":
+		_, err = app.queries.DeleteSubscriptions.Exec(IDs, listIDs)
+	case "unsubscribe":
+		_, err = app.queries.UnsubscribeSubscribersFromLists.Exec(IDs, listIDs)
+	default:
+		return newFieldError("action", "Invalid action.")
+	}
+
+	if err != nil {
+		app.log.Errorf("error updating subscriptions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error processing lists: %v", err))
+	}
+
+	return nil
+}
+
+// makeBatchResult returns the result of an item in a batch request with
+// the status and message of its error, if any.
+func makeBatchResult(i int, data interface{}, err error) batchResult {
+	if err == nil {
+		return batchResult{Index: i, Status: http.StatusOK, Data: data}
+	}
+
+	if _, ok := err.(*echo.HTTPError); ok {
+		status, e := getAPIError(err)
+		return batchResult{Index: i, Status: status, Error: e.Message, Code: e.Code, Fields: e.Fields}
+	}
+	return batchResult{Index: i, Status: http.StatusInternalServerError, Error: err.Error(), Code: errCodeInternal}
+}
+
+// querySubscribers runs an arbitrary subscriber query condition in a
+// read-only transaction and returns a page of the matching subscribers.
+func querySubscribers(query string, listID int, orderBy, order string, pg pagination, app *App) (subsWrap, error) {
+	var out subsWrap
+
+	listIDs := pq.Int64Array{}
+	if listID < 0 {
+		return out, newFieldError("list_id", "Invalid `list_id`.")
+	} else if listID > 0 {
+		listIDs = append(listIDs, int64(listID))
+	}
+
+	// There's an arbitrary query condition.
+	cond := ""
+	if query != "" {
+		cond = " AND " + query
+	}
+
+	// Sort params.
+	if !strSliceContains(orderBy, subQuerySortFields) {
+		orderBy = "updated_at"
+	}
+	if order != sortAsc && order != sortDesc {
+		order = sortAsc
+	}
+
+	stmt := fmt.Sprintf(app.queries.QuerySubscribers, cond, orderBy, order)
+
+	// Create a readonly transaction to prevent mutations.
+	tx, err := app.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		app.log.Errorf("error preparing subscriber query: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error preparing subscriber query: %v", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	// Run the query. stmt is the raw SQL query.
+	if err := tx.Select(&out.Results, stmt, listIDs, pg.Offset, pg.Limit); err != nil {
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error querying subscribers: %v", pqErrMsg(err)))
+	}
+
+	// Lazy load lists for each subscriber.
+	if err := out.Results.LoadLists(app.queries.GetSubscriberListsLazy); err != nil {
+		app.log.Errorf("error fetching subscriber lists: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching subscriber lists: %v", pqErrMsg(err)))
+	}
+
+	out.Query = query
+	if len(out.Results) == 0 {
+		out.Results = make(models.Subscribers, 0)
+		return out, nil
+	}
+
+	// Meta.
+	out.Total = out.Results[0].Total
+	out.Page = pg.Page
+	out.PerPage = pg.PerPage
+
+	return out, nil
+}
+
 // getSubscriber gets a single subscriber by ID.
 func getSubscriber(id int, app *App) (models.Subscriber, error) {
 	var (
@@ -553,19 +836,19 @@ func getSubscriber(id int, app *App) (models.Subscriber, error) {
 	)
 
 	if id < 1 {
-		return models.Subscriber{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid subscriber ID.")
+		return models.Subscriber{}, newFieldError("id", "Invalid subscriber ID.")
 	}
 
 	if err := app.queries.GetSubscriber.Select(&out, id, nil); err != nil {
-		app.log.Printf("error fetching subscriber: %v", err)
+		app.log.Errorf("error fetching subscriber: %v", err)
 		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching subscriber: %s", pqErrMsg(err)))
 	}
 	if len(out) == 0 {
-		return models.Subscriber{}, echo.NewHTTPError(http.StatusBadRequest, "Subscriber not found.")
+		return models.Subscriber{}, newHTTPError(http.StatusBadRequest, errCodeNotFound, "Subscriber not found.")
 	}
 	if err := out.LoadLists(app.queries.GetSubscriberListsLazy); err != nil {
-		app.log.Printf("error loading subscriber lists: %v", err)
+		app.log.Errorf("error loading subscriber lists: %v", err)
 		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
 			"Error loading subscriber lists.")
 	}
@@ -573,6 +856,52 @@ func getSubscriber(id int, app *App) (models.Subscriber, error) {
 	return out[0], nil
 }
 
+// getPreviewSubscriber returns the subscriber to render previews with from
+// the request. It's either an existing subscriber (subscriber_id) or a
+// synthetic one built from the supplied name, email and attribs (JSON).
+// The bool is false if neither was supplied.
+func getPreviewSubscriber(c echo.Context, app *App) (models.Subscriber, bool, error) {
+	if v := c.FormValue("subscriber_id"); v != "" {
+		// Rendering a real subscriber's data requires access to subscribers.
+		if u := getSessionUser(c); !app.rolePerms.has(u.Role, permSubscribersGet) {
+			return models.Subscriber{}, false, echo.NewHTTPError(http.StatusForbidden,
+				fmt.Sprintf("You don't have the permission to do this (%s).", permSubscribersGet))
+		}
+
+		id, _ := strconv.Atoi(v)
+		sub, err := getSubscriber(id, app)
+		if err != nil {
+			return sub, false, err
+		}
+		return sub, true, nil
+	}
+
+	var (
+		name    = c.FormValue("name")
+		email   = c.FormValue("email")
+		attribs = c.FormValue("attribs")
+	)
+	if name == "" && email == "" && attribs == "" {
+		return models.Subscriber{}, false, nil
+	}
+
+	sub := dummySubscriber
+	sub.Attribs = models.SubscriberAttribs{}
+	if name != "" {
+		sub.Name = name
+	}
+	if email != "" {
+		sub.Email = email
+	}
+	if attribs != "" {
+		if err := json.Unmarshal([]byte(attribs), &sub.Attribs); err != nil {
+			return sub, false, newFieldError("attribs",
+				fmt.Sprintf("Invalid JSON in attribs: %v", err))
+		}
+	}
+	return sub, true, nil
+}
+
 // exportSubscriberData collates the data of a subscriber including profile,
 // subscriptions, campaign_views, link_clicks (if they're enabled in the config)
 // and returns a formatted, indented JSON payload. Either takes a numeric id
@@ -590,7 +919,7 @@ func exportSubscriberData(id int64, subUUID string, exportables map[string]bool,
 		uu = subUUID
 	}
 	if err := app.queries.ExportSubscriberData.Get(&data, id, uu); err != nil {
-		app.log.Printf("error fetching subscriber export data: %v", err)
+		app.log.Errorf("error fetching subscriber export data: %v", err)
 		return data, nil, err
 	}
 
@@ -611,7 +940,7 @@ func exportSubscriberData(id int64, subUUID string, exportables map[string]bool,
 	// Marshal the data into an indented payload.
 	b, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		app.log.Printf("error marshalling subscriber export data: %v", err)
+		app.log.Errorf("error marshalling subscriber export data: %v", err)
 		return data, nil, err
 	}
 	return data, b, nil
@@ -626,7 +955,7 @@ func sendOptinConfirmation(sub models.Subscriber, listIDs []int64, app *App) err
 	// Get the list of subscription lists where the subscriber hasn't confirmed.
 	if err := app.queries.GetSubscriberLists.Select(&lists, sub.ID, nil,
 		pq.Int64Array(listIDs), nil, models.SubscriptionStatusUnconfirmed, models.ListOptinDouble); err != nil {
-		app.log.Printf("error fetching lists for opt-in: %s", pqErrMsg(err))
+		app.log.Errorf("error fetching lists for opt-in: %s", pqErrMsg(err))
 		return err
 	}
 
@@ -645,10 +974,51 @@ func sendOptinConfirmation(sub models.Subscriber, listIDs []int64, app *App) err
 	}
 	out.OptinURL = fmt.Sprintf(app.constants.OptinURL, sub.UUID, qListIDs.Encode())
 
-	// Send the e-mail.
-	if err := app.sendNotification([]string{sub.Email},
-		"Confirm subscription", notifSubscriberOptin, out); err != nil {
-		app.log.Printf("error e-mailing subscriber profile: %s", err)
+	// If any of the lists override the opt-in e-mail, the first one is used.
+	subject := ""
+	for _, l := range out.Lists {
+		if l.OptinEmailSubject != "" {
+			subject = l.OptinEmailSubject
+			break
+		}
+	}
+	for _, l := range out.Lists {
+		if l.OptinEmailBody == "" {
+			continue
+		}
+
+		tpl, err := compileListOptinTpl(l.OptinEmailBody, app)
+		if err != nil {
+			app.log.Errorf("error compiling opt-in template for list %s: %v", l.Name, err)
+			return err
+		}
+
+		var b bytes.Buffer
+		if err := tpl.Execute(&b, out); err != nil {
+			app.log.Errorf("error executing opt-in template for list %s: %v", l.Name, err)
+			return err
+		}
+
+		if subject == "" {
+			subject = getSysTpl(notifSubscriberOptin).Subject
+		}
+		if err := app.pushNotification([]string{sub.Email}, subject, b.Bytes(), manager.PriorityHigh); err != nil {
+			app.log.Errorf("error e-mailing subscriber opt-in: %s", err)
+			return err
+		}
+		return nil
+	}
+
+	// Send the e-mail with the system template in the subscriber's language.
+	sysSubject, body, err := renderSystemTpl(notifSubscriberOptin, subscriberLang(sub.Attribs), out, app)
+	if err != nil {
+		return err
+	}
+	if subject == "" {
+		subject = sysSubject
+	}
+	if err := app.pushNotification([]string{sub.Email}, subject, body, manager.PriorityHigh); err != nil {
+		app.log.Errorf("error e-mailing subscriber profile: %s", err)
 		return err
 	}
 	return nil
@@ -668,3 +1038,46 @@ func sanitizeSQLExp(q string) string {
 	}
 	return q
 }
+
+// getQueryListIDs reads one or more `list_id` params from the given
+// query params and returns them as an Int64Array.
+func getQueryListIDs(q url.Values) (pq.Int64Array, error) {
+	out := pq.Int64Array{}
+	for _, v := range q["list_id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 1 {
+			return nil, newFieldError("list_id", "Invalid `list_id`.")
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// formatExportTime formats a timestamp for a CSV export.
+func formatExportTime(t null.Time) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339)
+}
+
+// formatExportAttrib formats an attribute value for a CSV export.
+// Strings and numbers are written as-is while nested values (maps,
+// slices etc.) are written as JSON.
+func formatExportAttrib(v interface{}) string {
+	switch a := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return a
+	case float64:
+		// JSON numbers are float64s. Write them without exponents.
+		return strconv.FormatFloat(a, 'f', -1, 64)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// sysTplDefaultLang is the language of the built-in system templates and
+// the fallback when there's no override in a subscriber's language.
+const sysTplDefaultLang = "en"
+
+// sysTpls is the list of built-in e-mail templates sent to subscribers
+// that can be overridden, in display order.
+var sysTpls = []sysTpl{
+	{Name: notifSubscriberOptin, Description: "Double opt-in confirmation", Subject: "Confirm subscription"},
+	{Name: notifOptinCampaign, Description: "Opt-in notification campaign"},
+	{Name: notifSubscriberData, Description: "Subscriber data export", Subject: "Your data"},
+}
+
+var regexpLang = regexp.MustCompile(`^[a-z]{2,3}(-[a-zA-Z0-9]{2,8})?$`)
+
+// sysTpl is a built-in system template and its language overrides.
+type sysTpl struct {
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Subject     string                  `json:"subject"`
+	Body        string                  `json:"body"`
+	Overrides   []models.SystemTemplate `json:"overrides"`
+}
+
+type sysTplReq struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// handleGetSystemTemplates handles retrieval of the system templates
+// along with their language overrides.
+func handleGetSystemTemplates(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		name = c.Param("name")
+	)
+
+	if name != "" && getSysTpl(name) == nil {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Unknown system template.")
+	}
+
+	var overrides []models.SystemTemplate
+	if err := app.queries.GetSystemTpls.Select(&overrides, name); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching system templates: %s", pqErrMsg(err)))
+	}
+
+	out := make([]sysTpl, 0, len(sysTpls))
+	for _, t := range sysTpls {
+		if name != "" && t.Name != name {
+			continue
+		}
+
+		// The built-in body for reference.
+		if tpl := app.notifTplsBase.Lookup(t.Name); tpl != nil && tpl.Tree != nil {
+			t.Body = tpl.Tree.Root.String()
+		}
+
+		t.Overrides = []models.SystemTemplate{}
+		for _, o := range overrides {
+			if o.Name == t.Name {
+				t.Overrides = append(t.Overrides, o)
+			}
+		}
+		out = append(out, t)
+	}
+
+	if name != "" {
+		return c.JSON(http.StatusOK, okResp{out[0]})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateSystemTemplate handles the creation or modification of the
+// override of a system template in a language.
+func handleUpdateSystemTemplate(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		name = c.Param("name")
+		lang = c.Param("lang")
+	)
+
+	if getSysTpl(name) == nil {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Unknown system template.")
+	}
+	if !regexpLang.MatchString(lang) {
+		return newFieldError("lang", "Invalid language code.")
+	}
+
+	var o sysTplReq
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if !strHasLen(o.Subject, 0, stdInputMaxLen) {
+		return newFieldError("subject", "Invalid length for `subject`.")
+	}
+	if o.Body == "" {
+		return newFieldError("body", "Invalid length for `body`.")
+	}
+	if _, err := compileNotifTpl(name+"-"+lang, o.Body, app); err != nil {
+		return newFieldError("body",
+			fmt.Sprintf("Error compiling template: %v", err))
+	}
+
+	if _, err := app.queries.UpsertSystemTpl.Exec(name, lang, o.Subject, o.Body); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating system template: %s", pqErrMsg(err)))
+	}
+
+	return handleGetSystemTemplates(c)
+}
+
+// handleDeleteSystemTemplate handles the deletion of the override of a
+// system template in a language, reverting it to the built-in template.
+func handleDeleteSystemTemplate(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		name = c.Param("name")
+		lang = c.Param("lang")
+	)
+
+	if getSysTpl(name) == nil {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Unknown system template.")
+	}
+
+	if _, err := app.queries.DeleteSystemTpl.Exec(name, lang); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error deleting system template: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// renderSystemTpl renders a system template in the given language. The
+// override in the language, or in the default language, is used if there's
+// one, and the built-in template otherwise. The subject is the override's
+// subject, or the built-in one if it's empty.
+func renderSystemTpl(name, lang string, data interface{}, app *App) (string, []byte, error) {
+	var (
+		t     = getSysTpl(name)
+		langs = pq.StringArray{sysTplDefaultLang}
+	)
+	if t == nil {
+		return "", nil, fmt.Errorf("unknown system template: %s", name)
+	}
+	if lang != "" && lang != sysTplDefaultLang {
+		langs = pq.StringArray{lang, sysTplDefaultLang}
+	}
+
+	var out []models.SystemTemplate
+	if err := app.queries.GetSystemTpl.Select(&out, name, langs); err != nil {
+		// Fall back to the built-in template.
+		app.log.Errorf("error fetching system template '%s': %v", name, err)
+	}
+
+	var (
+		subject = t.Subject
+		b       bytes.Buffer
+	)
+	if len(out) == 0 {
+		if err := app.notifTpls.ExecuteTemplate(&b, name, data); err != nil {
+			app.log.Errorf("error compiling notification template '%s': %v", name, err)
+			return "", nil, err
+		}
+		return subject, b.Bytes(), nil
+	}
+
+	o := out[0]
+	tpl, err := compileNotifTpl(name+"-"+o.Lang, o.Body, app)
+	if err != nil {
+		app.log.Errorf("error compiling system template '%s' (%s): %v", name, o.Lang, err)
+		return "", nil, err
+	}
+	if err := tpl.Execute(&b, data); err != nil {
+		app.log.Errorf("error executing system template '%s' (%s): %v", name, o.Lang, err)
+		return "", nil, err
+	}
+	if o.Subject != "" {
+		subject = o.Subject
+	}
+
+	return subject, b.Bytes(), nil
+}
+
+// getSysTpl returns the built-in system template by name, or nil.
+func getSysTpl(name string) *sysTpl {
+	for i := range sysTpls {
+		if sysTpls[i].Name == name {
+			return &sysTpls[i]
+		}
+	}
+	return nil
+}
+
+// subscriberLang returns the preferred language of a subscriber from the
+// `lang` attribute, if it's set.
+func subscriberLang(attribs models.SubscriberAttribs) string {
+	if l, ok := attribs["lang"].(string); ok && regexpLang.MatchString(l) {
+		return l
+	}
+	return ""
+}
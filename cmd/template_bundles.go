@@ -0,0 +1,334 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+const (
+	// tplBundleVersion is the version of the template bundle format.
+	tplBundleVersion = 1
+
+	// tplBundleMaxSize is the maximum size of a template bundle that can be imported.
+	tplBundleMaxSize = 20 * 1024 * 1024
+
+	tplBundleManifest = "template.json"
+	tplBundleMediaDir = "media/"
+)
+
+// tplBundle is the manifest of a template bundle. The bundle is a ZIP
+// archive with the manifest and the media files referenced in the template.
+type tplBundle struct {
+	Version    int                      `json:"version"`
+	Name       string                   `json:"name"`
+	Body       string                   `json:"body"`
+	BodySource string                   `json:"body_source"`
+	Type       string                   `json:"type"`
+	Subject    string                   `json:"subject,omitempty"`
+	Variables  models.TemplateVariables `json:"variables,omitempty"`
+	Folder     string                   `json:"folder,omitempty"`
+	Tags       []string                 `json:"tags,omitempty"`
+	Media      []tplBundleMedia         `json:"media"`
+}
+
+// tplBundleMedia is a media file packed in a template bundle. URL is the
+// media's URL in the template, which is rewritten on import.
+type tplBundleMedia struct {
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+}
+
+// handleExportTemplate exports a template and the media it references
+// as a portable ZIP bundle.
+func handleExportTemplate(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, id, false, "", pq.StringArray{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
+	}
+	if len(tpls) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+	}
+	tpl := tpls[0]
+
+	// Find the uploaded media that's referenced in the template.
+	var media []struct {
+		Filename string `db:"filename"`
+	}
+	if err := app.queries.GetMedia.Select(&media, app.constants.MediaProvider); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching media list: %s", pqErrMsg(err)))
+	}
+
+	var (
+		buf bytes.Buffer
+		z   = zip.NewWriter(&buf)
+		b   = tplBundle{
+			Version:    tplBundleVersion,
+			Name:       tpl.Name,
+			Body:       tpl.Body,
+			BodySource: tpl.BodySource,
+			Type:       tpl.Type,
+			Subject:    tpl.Subject,
+			Variables:  tpl.Variables,
+			Folder:     tpl.Folder,
+			Tags:       tpl.Tags,
+			Media:      []tplBundleMedia{},
+		}
+	)
+	for _, m := range media {
+		u := app.media.Get(m.Filename)
+		if !strings.Contains(tpl.Body, u) && !strings.Contains(tpl.BodySource, u) {
+			continue
+		}
+
+		blob, err := app.media.GetBlob(m.Filename)
+		if err != nil {
+			getLogger(c).Errorf("error reading media %s: %v", m.Filename, err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error reading media %s: %v", m.Filename, err))
+		}
+
+		w, err := z.Create(tplBundleMediaDir + m.Filename)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error creating bundle: %v", err))
+		}
+		if _, err := w.Write(blob); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error creating bundle: %v", err))
+		}
+
+		b.Media = append(b.Media, tplBundleMedia{Filename: m.Filename, URL: u})
+	}
+
+	// Write the manifest.
+	manifest, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating bundle: %v", err))
+	}
+	w, err := z.Create(tplBundleManifest)
+	if err == nil {
+		_, err = w.Write(manifest)
+	}
+	if err == nil {
+		err = z.Close()
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating bundle: %v", err))
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="template-%d.zip"`, tpl.ID))
+	return c.Blob(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// handleImportTemplate imports a template bundle exported from an instance
+// as a new template. The bundled media are uploaded to the media store and
+// the references to them in the template are rewritten.
+func handleImportTemplate(c echo.Context) error {
+	var (
+		app     = c.Get("app").(*App)
+		cleanUp = true
+
+		// Uploaded media (and thumbnails) to be removed if the import fails.
+		uploaded []string
+	)
+
+	defer func() {
+		if cleanUp {
+			for _, f := range uploaded {
+				app.media.Delete(f)
+			}
+		}
+	}()
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return newFieldError("file",
+			fmt.Sprintf("Invalid file uploaded: %v", err))
+	}
+	if file.Size > tplBundleMaxSize {
+		return newFieldError("file",
+			fmt.Sprintf("Bundle exceeds the maximum size of %d MB.", tplBundleMaxSize/1024/1024))
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			fmt.Sprintf("Error reading file: %s", err))
+	}
+	defer src.Close()
+
+	raw, err := ioutil.ReadAll(io.LimitReader(src, tplBundleMaxSize))
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			fmt.Sprintf("Error reading file: %s", err))
+	}
+	z, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			fmt.Sprintf("Invalid bundle: %v", err))
+	}
+
+	// Index the files in the bundle.
+	files := make(map[string]*zip.File, len(z.File))
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	// Read the manifest.
+	mf, ok := files[tplBundleManifest]
+	if !ok {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			fmt.Sprintf("Invalid bundle: %s not found.", tplBundleManifest))
+	}
+	var b tplBundle
+	if err := readZipJSON(mf, &b); err != nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			fmt.Sprintf("Invalid bundle manifest: %v", err))
+	}
+	if b.Version != tplBundleVersion {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			fmt.Sprintf("Unsupported bundle version: %d", b.Version))
+	}
+
+	// Upload the media and rewrite their references.
+	for _, m := range b.Media {
+		fName := path.Base(m.Filename)
+		f, ok := files[tplBundleMediaDir+fName]
+		if !ok {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+				fmt.Sprintf("Invalid bundle: media %s not found.", fName))
+		}
+
+		blob, err := readZipFile(f)
+		if err != nil {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+				fmt.Sprintf("Error reading media %s: %v", fName, err))
+		}
+
+		typ := http.DetectContentType(blob)
+		if ok := validateMIME(typ, imageMimes); !ok {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+				fmt.Sprintf("Unsupported media type (%s) in bundle: %s", typ, fName))
+		}
+
+		name, err := app.media.Put(generateFileName(fName), typ, bytes.NewReader(blob))
+		if err != nil {
+			getLogger(c).Errorf("error uploading file: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error uploading file: %s", err))
+		}
+		uploaded = append(uploaded, name)
+
+		thumb, err := makeThumbnail(bytes.NewReader(blob))
+		if err != nil {
+			getLogger(c).Errorf("error resizing image: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error resizing image: %s", err))
+		}
+		thumbName, err := app.media.Put(thumbPrefix+name, typ, thumb)
+		if err != nil {
+			getLogger(c).Errorf("error saving thumbnail: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error saving thumbnail: %s", err))
+		}
+		uploaded = append(uploaded, thumbName)
+
+		uu, err := uuid.NewV4()
+		if err != nil {
+			getLogger(c).Errorf("error generating UUID: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error generating UUID")
+		}
+		if _, err := app.queries.InsertMedia.Exec(uu, name, thumbName, app.constants.MediaProvider); err != nil {
+			getLogger(c).Errorf("error inserting uploaded file to db: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error saving uploaded file to db: %s", pqErrMsg(err)))
+		}
+
+		if m.URL != "" {
+			u := app.media.Get(name)
+			b.Body = strings.Replace(b.Body, m.URL, u, -1)
+			b.BodySource = strings.Replace(b.BodySource, m.URL, u, -1)
+		}
+	}
+
+	o := models.Template{
+		Name:       b.Name,
+		Body:       b.Body,
+		BodySource: b.BodySource,
+		Type:       b.Type,
+		Subject:    b.Subject,
+		Variables:  b.Variables,
+		Folder:     b.Folder,
+		Tags:       b.Tags,
+	}
+	if o.Type == "" {
+		o.Type = models.TemplateTypeCampaign
+	}
+	if name := c.FormValue("name"); name != "" {
+		o.Name = name
+	}
+	if err := validateTemplate(o); err != nil {
+		return newValidationError(err)
+	}
+
+	var newID int
+	if err := app.queries.CreateTemplate.Get(&newID, o.Name, o.Body, o.BodySource,
+		o.Type, o.Subject, o.Variables, o.Folder, pq.StringArray(normalizeTags(o.Tags))); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating template: %v", pqErrMsg(err)))
+	}
+	cleanUp = false
+	recordTplRevision(newID, c, app)
+
+	// Hand over to the GET handler to return the last insertion.
+	return handleGetTemplates(copyEchoCtx(c, map[string]string{
+		"id": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// readZipFile reads a file in a ZIP archive.
+func readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(io.LimitReader(r, tplBundleMaxSize))
+}
+
+// readZipJSON reads and unmarshals a JSON file in a ZIP archive.
+func readZipJSON(f *zip.File, out interface{}) error {
+	b, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// tplDraftTag is the tag that's added to cloned templates to mark them
+// as draft copies.
+const tplDraftTag = "draft"
+
+type tplCloneReq struct {
+	// Name of the copy. Defaults to "Copy of <name>".
+	Name string `json:"name"`
+
+	// AssetURLs is a map of URL prefixes in the body to replace,
+	// eg: {"https://old.cdn.com/brand-a/": "https://cdn.com/brand-b/"}.
+	AssetURLs map[string]string `json:"asset_urls"`
+}
+
+// handleCloneTemplate handles the duplication of a template along with
+// its language variants. The copy is tagged as a draft.
+func handleCloneTemplate(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		o     tplCloneReq
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, id, false, "", pq.StringArray{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
+	}
+	if len(tpls) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+	}
+
+	var variants []models.TemplateVariant
+	if err := app.queries.GetTplVariants.Select(&variants, id, ""); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template variants: %s", pqErrMsg(err)))
+	}
+
+	var (
+		tpl     = tpls[0]
+		rewrite = makeURLRewriter(o.AssetURLs)
+	)
+	tpl.Name = o.Name
+	if tpl.Name == "" {
+		tpl.Name = "Copy of " + tpls[0].Name
+	}
+	tpl.Body = rewrite.Replace(tpl.Body)
+	tpl.BodySource = rewrite.Replace(tpl.BodySource)
+	if !strSliceContains(tplDraftTag, tpl.Tags) {
+		tpl.Tags = append(tpl.Tags, tplDraftTag)
+	}
+
+	if err := validateTemplate(tpl); err != nil {
+		return newValidationError(err)
+	}
+
+	var newID int
+	if err := app.queries.CreateTemplate.Get(&newID,
+		tpl.Name,
+		tpl.Body,
+		tpl.BodySource,
+		tpl.Type,
+		tpl.Subject,
+		tpl.Variables,
+		tpl.Folder,
+		pq.StringArray(normalizeTags(tpl.Tags))); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "idx_templates_tx_name" {
+			return newHTTPError(http.StatusBadRequest, errCodeExists,
+				"A transactional template with the name already exists.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error cloning template: %v", pqErrMsg(err)))
+	}
+
+	for _, v := range variants {
+		if _, err := app.queries.UpsertTplVariant.Exec(newID, v.Lang,
+			rewrite.Replace(v.Body), rewrite.Replace(v.BodySource)); err != nil {
+			getLogger(c).Errorf("error cloning template variant (%s): %v", v.Lang, err)
+		}
+	}
+	recordTplRevision(newID, c, app)
+	go updateTplThumb(newID, app)
+
+	// Hand over to the GET handler to return the last insertion.
+	return handleGetTemplates(copyEchoCtx(c, map[string]string{
+		"id": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// makeURLRewriter returns a replacer that replaces the given URL prefixes.
+// Longer prefixes are matched first so that a more specific prefix
+// overrides a general one.
+func makeURLRewriter(urls map[string]string) *strings.Replacer {
+	keys := make([]string, 0, len(urls))
+	for k := range urls {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+
+	pairs := make([]string, 0, len(keys)*2)
+	for _, k := range keys {
+		pairs = append(pairs, k, urls[k])
+	}
+	return strings.NewReplacer(pairs...)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// handleGetTemplateFixtures handles retrieval of the test data fixtures
+// of a template.
+func handleGetTemplateFixtures(c echo.Context) error {
+	var (
+		app     = c.Get("app").(*App)
+		out     []models.TemplateFixture
+		id, _   = strconv.Atoi(c.Param("id"))
+		fxID, _ = strconv.Atoi(c.Param("fixtureID"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetTplFixtures.Select(&out, id, fxID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template fixtures: %s", pqErrMsg(err)))
+	}
+
+	if fxID > 0 {
+		if len(out) == 0 {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Fixture not found.")
+		}
+		return c.JSON(http.StatusOK, okResp{out[0]})
+	}
+
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateTemplateFixture handles the creation of a test data fixture
+// for a template.
+func handleCreateTemplateFixture(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		o     models.TemplateFixture
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := validateTemplateFixture(o, true); err != nil {
+		return newValidationError(err)
+	}
+
+	var newID int
+	if err := app.queries.CreateTplFixture.Get(&newID, id, o.Name,
+		o.Subscriber, o.Campaign, o.Data); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505":
+				return newHTTPError(http.StatusBadRequest, errCodeExists, "A fixture with the name already exists.")
+			case "23503":
+				return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+			}
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating template fixture: %v", pqErrMsg(err)))
+	}
+
+	// Hand over to the GET handler to return the last insertion.
+	return handleGetTemplateFixtures(copyEchoCtx(c, map[string]string{
+		"id":        c.Param("id"),
+		"fixtureID": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// handleUpdateTemplateFixture handles the modification of a test data
+// fixture of a template.
+func handleUpdateTemplateFixture(c echo.Context) error {
+	var (
+		app     = c.Get("app").(*App)
+		id, _   = strconv.Atoi(c.Param("id"))
+		fxID, _ = strconv.Atoi(c.Param("fixtureID"))
+		o       models.TemplateFixture
+	)
+
+	if id < 1 || fxID < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := validateTemplateFixture(o, false); err != nil {
+		return newValidationError(err)
+	}
+
+	res, err := app.queries.UpdateTplFixture.Exec(id, fxID, o.Name,
+		o.Subscriber, o.Campaign, o.Data)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return newHTTPError(http.StatusBadRequest, errCodeExists, "A fixture with the name already exists.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating template fixture: %s", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Fixture not found.")
+	}
+
+	return handleGetTemplateFixtures(c)
+}
+
+// handleDeleteTemplateFixture handles the deletion of a test data fixture
+// of a template.
+func handleDeleteTemplateFixture(c echo.Context) error {
+	var (
+		app     = c.Get("app").(*App)
+		id, _   = strconv.Atoi(c.Param("id"))
+		fxID, _ = strconv.Atoi(c.Param("fixtureID"))
+	)
+
+	if id < 1 || fxID < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if _, err := app.queries.DeleteTplFixture.Exec(id, fxID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error deleting template fixture: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// getTplFixture returns a test data fixture of a template.
+func getTplFixture(tplID, fxID int, app *App) (models.TemplateFixture, error) {
+	var out []models.TemplateFixture
+	if err := app.queries.GetTplFixtures.Select(&out, tplID, fxID); err != nil {
+		app.log.Errorf("error fetching template fixture: %v", err)
+		return models.TemplateFixture{}, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template fixture: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return models.TemplateFixture{}, newHTTPError(http.StatusBadRequest, errCodeNotFound, "Fixture not found.")
+	}
+	return out[0], nil
+}
+
+// fixtureSubscriber returns the dummy subscriber with the fields that are
+// set in the fixture.
+func fixtureSubscriber(fx models.TemplateFixture) models.Subscriber {
+	sub := dummySubscriber
+	if fx.Subscriber.Name != "" {
+		sub.Name = fx.Subscriber.Name
+	}
+	if fx.Subscriber.Email != "" {
+		sub.Email = fx.Subscriber.Email
+	}
+	if fx.Subscriber.Attribs != nil {
+		sub.Attribs = fx.Subscriber.Attribs
+	}
+	return sub
+}
+
+// getTplPreviewSubscriber returns the subscriber requested for a template
+// preview, or the fixture's subscriber, or the dummy subscriber.
+func getTplPreviewSubscriber(c echo.Context, fx *models.TemplateFixture, app *App) (models.Subscriber, error) {
+	sub, ok, err := getPreviewSubscriber(c, app)
+	if err != nil || ok {
+		return sub, err
+	}
+	if fx != nil {
+		return fixtureSubscriber(*fx), nil
+	}
+	return dummySubscriber, nil
+}
+
+// validateTemplateFixture validates template fixture fields. The name is
+// optional on updates.
+func validateTemplateFixture(o models.TemplateFixture, isNew bool) error {
+	minLen := 0
+	if isNew {
+		minLen = 1
+	}
+	if !strHasLen(o.Name, minLen, stdInputMaxLen) {
+		return fieldError{Field: "name", Message: "invalid length for `name`"}
+	}
+	if o.Subscriber.Email != "" && !subimporter.IsEmail(o.Subscriber.Email) {
+		return fieldError{Field: "subscriber", Message: "invalid subscriber `email`"}
+	}
+	if !strHasLen(o.Campaign.Subject, 0, stdInputMaxLen) {
+		return fieldError{Field: "campaign", Message: "invalid length for campaign `subject`"}
+	}
+	return nil
+}
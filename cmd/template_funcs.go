@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/internal/tplfuncs"
+	"github.com/labstack/echo"
+)
+
+// tplFuncsWrap is the list of functions available in templates.
+type tplFuncsWrap struct {
+	// Functions that are specific to campaigns.
+	Campaign []tplfuncs.Func `json:"campaign"`
+
+	// General purpose functions available in campaign and
+	// transactional templates.
+	Library []tplfuncs.Func `json:"library"`
+}
+
+// campaignTplFuncs describes the built-in campaign template functions
+// provided by the campaign manager.
+var campaignTplFuncs = []tplfuncs.Func{
+	{
+		Name:        "TrackLink",
+		Signature:   "TrackLink(url string, msg) string",
+		Description: "Wraps a URL in a link that tracks clicks and the link's position in the message.",
+		Example:     `<a href="{{ TrackLink "https://listmonk.app" . }}">listmonk</a>`,
+	},
+	{
+		Name:        "TrackView",
+		Signature:   "TrackView(msg) HTML",
+		Description: "Inserts a tracking pixel that records campaign views.",
+		Example:     `{{ TrackView . }}`,
+	},
+	{
+		Name:        "UnsubscribeURL",
+		Signature:   "UnsubscribeURL(msg) string",
+		Description: "Unsubscription and preferences page URL of the subscriber.",
+		Example:     `<a href="{{ UnsubscribeURL . }}">Unsubscribe</a>`,
+	},
+	{
+		Name:        "OptinURL",
+		Signature:   "OptinURL(msg) string",
+		Description: "Double opt-in confirmation URL of the subscriber.",
+		Example:     `<a href="{{ OptinURL . }}">Confirm</a>`,
+	},
+	{
+		Name:        "MessageURL",
+		Signature:   "MessageURL(msg) string",
+		Description: "URL to view the campaign in a browser.",
+		Example:     `<a href="{{ MessageURL . }}">View in browser</a>`,
+	},
+	{
+		Name:        "Date",
+		Signature:   "Date(layout string) string",
+		Description: "Current date and time formatted with a Go layout.",
+		Example:     `{{ Date "2006-01-02" }}`,
+	},
+	{
+		Name:        "Var",
+		Signature:   "Var(name string) any",
+		Description: "Value of a variable declared by the template.",
+		Example:     `{{ Var "cta_text" }}`,
+	},
+	{
+		Name:        "Partial",
+		Signature:   "Partial(name string) HTML",
+		Description: "Renders a template partial.",
+		Example:     `{{ Partial "footer" }}`,
+	},
+}
+
+// handleGetTemplateFuncs returns the functions that are available in
+// templates along with their descriptions.
+func handleGetTemplateFuncs(c echo.Context) error {
+	return c.JSON(http.StatusOK, okResp{tplFuncsWrap{
+		Campaign: campaignTplFuncs,
+		Library:  tplfuncs.Docs(),
+	}})
+}
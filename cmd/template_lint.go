@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+)
+
+const (
+	lintLevelError   = "error"
+	lintLevelWarning = "warning"
+
+	// lintMaxImages is the maximum number of image URLs that are checked.
+	lintMaxImages = 30
+
+	// lintImagesTimeout is the overall deadline for checking all the images.
+	lintImagesTimeout = 10 * time.Second
+)
+
+var (
+	// Matches the line and message in template parse errors, eg:
+	// template: lint:3: function "Foo" not defined
+	regexpTplErr = regexp.MustCompile(`^template: [^:]+:(\d+):(?:\d+:)?\s*(.+)$`)
+
+	regexpTplImg = regexp.MustCompile(`(?i)<(?:img|mj-image)\b[^>]*?\ssrc\s*=\s*["']([^"']*)["']`)
+
+	// Image checks are made by the server, so they never connect to
+	// private, loopback, or link-local addresses.
+	lintHTTPClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: lintDialControl,
+			}).DialContext,
+		},
+	}
+
+	lintPrivateNets = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+		"100.64.0.0/10", "fc00::/7")
+)
+
+type tplLintReq struct {
+	Body string `json:"body"`
+
+	// template (base template) or campaign (campaign body).
+	Type string `json:"type"`
+}
+
+// tplDiagnostic is a problem found in a template. Line is 0 when the
+// problem isn't specific to a line.
+type tplDiagnostic struct {
+	Level   string `json:"level"`
+	Code    string `json:"code"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+type tplLintResult struct {
+	Valid       bool            `json:"valid"`
+	Diagnostics []tplDiagnostic `json:"diagnostics"`
+}
+
+// handleLintTemplate checks a template or campaign body for syntax errors,
+// unknown functions, missing placeholders and broken image references.
+// Remote images are only fetched for admins.
+func handleLintTemplate(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		o   = tplLintReq{Type: "template"}
+	)
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if o.Type != "template" && o.Type != "campaign" {
+		return newFieldError("type", "Invalid type. Should be template or campaign.")
+	}
+
+	out := tplLintResult{Valid: true, Diagnostics: []tplDiagnostic{}}
+	checkRemote := getSessionUser(c).Role == models.UserRoleAdmin
+	for _, d := range lintTemplate(o.Body, o.Type == "template", checkRemote, app) {
+		if d.Level == lintLevelError {
+			out.Valid = false
+		}
+		out.Diagnostics = append(out.Diagnostics, d)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// lintTemplate returns the diagnostics for a template body. isBase
+// indicates a base template that wraps campaign bodies. checkRemote
+// indicates whether remote images are fetched to check that they load.
+func lintTemplate(body string, isBase, checkRemote bool, app *App) []tplDiagnostic {
+	var out []tplDiagnostic
+
+	if strings.TrimSpace(body) == "" {
+		return append(out, tplDiagnostic{
+			Level:   lintLevelError,
+			Code:    "empty",
+			Message: "Body is empty.",
+		})
+	}
+
+	// Syntax errors and unknown functions. The parser stops at the
+	// first error.
+	if _, err := template.New("lint").Funcs(app.manager.TemplateFuncs(&models.Campaign{})).Parse(body); err != nil {
+		out = append(out, lintParseError(err))
+	}
+
+	// Required placeholders.
+	if isBase {
+		switch n := len(regexpTplTag.FindAllStringIndex(body, -1)); {
+		case n == 0:
+			out = append(out, tplDiagnostic{
+				Level:   lintLevelError,
+				Code:    "missing_content_tag",
+				Message: "Template should contain the " + tplTag + " placeholder.",
+			})
+		case n > 1:
+			out = append(out, tplDiagnostic{
+				Level:   lintLevelError,
+				Code:    "duplicate_content_tag",
+				Message: "Template should contain the " + tplTag + " placeholder exactly once.",
+			})
+		}
+
+		if !strings.Contains(body, "UnsubscribeURL") {
+			out = append(out, tplDiagnostic{
+				Level:   lintLevelWarning,
+				Code:    "missing_unsubscribe",
+				Message: "Template has no {{ UnsubscribeURL }} link.",
+			})
+		}
+	}
+
+	return append(out, lintImages(body, checkRemote)...)
+}
+
+// lintParseError converts a template parse error to a diagnostic.
+func lintParseError(err error) tplDiagnostic {
+	d := tplDiagnostic{
+		Level:   lintLevelError,
+		Code:    "syntax",
+		Message: err.Error(),
+	}
+
+	if m := regexpTplErr.FindStringSubmatch(err.Error()); m != nil {
+		d.Line, _ = strconv.Atoi(m[1])
+		d.Message = m[2]
+	}
+
+	switch {
+	case strings.Contains(d.Message, "not defined"):
+		d.Code = "unknown_function"
+	case strings.Contains(d.Message, "unclosed action"),
+		strings.Contains(d.Message, "unexpected EOF"):
+		d.Code = "unclosed_action"
+	}
+
+	return d
+}
+
+// lintImages checks that the images referenced in a template load.
+// Dynamic (templated) and inline data URLs are skipped. Remote images
+// are fetched concurrently if checkRemote is set.
+func lintImages(body string, checkRemote bool) []tplDiagnostic {
+	type image struct {
+		src  string
+		line int
+	}
+	var (
+		out     []tplDiagnostic
+		images  []image
+		checked = make(map[string]bool)
+	)
+
+	for _, m := range regexpTplImg.FindAllStringSubmatchIndex(body, -1) {
+		var (
+			src  = strings.TrimSpace(body[m[2]:m[3]])
+			line = strings.Count(body[:m[0]], "\n") + 1
+		)
+
+		switch {
+		case src == "":
+			out = append(out, tplDiagnostic{
+				Level:   lintLevelError,
+				Code:    "broken_image",
+				Line:    line,
+				Message: "Image has an empty src.",
+			})
+			continue
+		case strings.Contains(src, "{{"), strings.HasPrefix(src, "data:"):
+			continue
+		case !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://"):
+			out = append(out, tplDiagnostic{
+				Level:   lintLevelWarning,
+				Code:    "relative_image",
+				Line:    line,
+				Message: "Image URL should be absolute to load in e-mail clients: " + src,
+			})
+			continue
+		}
+
+		if !checkRemote || checked[src] || len(checked) >= lintMaxImages {
+			continue
+		}
+		checked[src] = true
+		images = append(images, image{src: src, line: line})
+	}
+
+	// Check the images concurrently under an overall deadline.
+	var (
+		errs        = make([]error, len(images))
+		wg          sync.WaitGroup
+		ctx, cancel = context.WithTimeout(context.Background(), lintImagesTimeout)
+	)
+	defer cancel()
+	for i, img := range images {
+		wg.Add(1)
+		go func(i int, src string) {
+			defer wg.Done()
+			errs[i] = checkImageURL(ctx, src)
+		}(i, img.src)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			out = append(out, tplDiagnostic{
+				Level:   lintLevelError,
+				Code:    "broken_image",
+				Line:    images[i].line,
+				Message: "Image " + images[i].src + " doesn't load: " + err.Error(),
+			})
+		}
+	}
+
+	return out
+}
+
+// checkImageURL checks that an image URL responds with a 2xx.
+func checkImageURL(ctx context.Context, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return err
+	}
+	r, err := lintHTTPClient.Do(req)
+	if err == nil && r.StatusCode == http.StatusMethodNotAllowed {
+		r.Body.Close()
+		req, _ = http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		r, err = lintHTTPClient.Do(req)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(r.Body, 1024*1024))
+		r.Body.Close()
+	}()
+
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return fmt.Errorf("status %d", r.StatusCode)
+	}
+	return nil
+}
+
+// lintDialControl refuses connections to private, loopback, link-local
+// and unspecified addresses. It's called with the resolved address, so it
+// also applies to redirects and hostnames that resolve to such addresses.
+func lintDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return errors.New("address not allowed")
+	}
+	for _, n := range lintPrivateNets {
+		if n.Contains(ip) {
+			return errors.New("address not allowed")
+		}
+	}
+	return nil
+}
+
+// parseCIDRs parses a list of CIDR ranges and panics on invalid ones.
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	out := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+var regexpPartialName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// tplPartialRef is a template or campaign that includes a partial.
+type tplPartialRef struct {
+	Type string `db:"type"`
+	Name string `db:"name"`
+}
+
+// handleGetTemplatePartials handles retrieval of template partials.
+func handleGetTemplatePartials(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		out   []models.TemplatePartial
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if err := app.queries.GetTplPartials.Select(&out, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template partials: %s", pqErrMsg(err)))
+	}
+	if id > 0 {
+		if len(out) == 0 {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Partial not found.")
+		}
+		return c.JSON(http.StatusOK, okResp{out[0]})
+	}
+
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateTemplatePartial handles template partial creation.
+func handleCreateTemplatePartial(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		o   = models.TemplatePartial{}
+	)
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if err := validateTemplatePartial(o, app); err != nil {
+		return newValidationError(err)
+	}
+
+	var newID int
+	if err := app.queries.CreateTplPartial.Get(&newID, o.Name, o.Body); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return newHTTPError(http.StatusBadRequest, errCodeExists, "A partial with the name already exists.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating template partial: %v", pqErrMsg(err)))
+	}
+
+	if err := loadTplPartials(app); err != nil {
+		getLogger(c).Errorf("error loading template partials: %v", err)
+	}
+
+	// Hand over to the GET handler to return the last insertion.
+	return handleGetTemplatePartials(copyEchoCtx(c, map[string]string{
+		"id": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// handleUpdateTemplatePartial handles template partial modification.
+func handleUpdateTemplatePartial(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	var o models.TemplatePartial
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if err := validateTemplatePartial(o, app); err != nil {
+		return newValidationError(err)
+	}
+
+	// Renaming a partial would break the templates that include it.
+	if err := checkTplPartialRefs(id, o.Name, app); err != nil {
+		return err
+	}
+
+	res, err := app.queries.UpdateTplPartial.Exec(id, o.Name, o.Body)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return newHTTPError(http.StatusBadRequest, errCodeExists, "A partial with the name already exists.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating template partial: %s", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Partial not found.")
+	}
+
+	if err := loadTplPartials(app); err != nil {
+		getLogger(c).Errorf("error loading template partials: %v", err)
+	}
+
+	return handleGetTemplatePartials(c)
+}
+
+// handleDeleteTemplatePartial handles template partial deletion.
+func handleDeleteTemplatePartial(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := checkTplPartialRefs(id, "", app); err != nil {
+		return err
+	}
+
+	if _, err := app.queries.DeleteTplPartial.Exec(id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error deleting template partial: %v", pqErrMsg(err)))
+	}
+
+	if err := loadTplPartials(app); err != nil {
+		getLogger(c).Errorf("error loading template partials: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// validateTemplatePartial validates a template partial's name and checks
+// that its body compiles.
+func validateTemplatePartial(o models.TemplatePartial, app *App) error {
+	if !regexpPartialName.MatchString(o.Name) || !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return fieldError{Field: "name", Message: "Invalid name. Use lowercase letters, numbers, - and _"}
+	}
+
+	if o.Body == "" {
+		return fieldError{Field: "body", Message: "Invalid length for `body`"}
+	}
+
+	f := app.manager.TemplateFuncs(&models.Campaign{})
+	delete(f, "Partial")
+	if _, err := models.CompilePartial(o.Name, o.Body, f); err != nil {
+		return fieldError{Field: "body", Message: err.Error()}
+	}
+
+	return nil
+}
+
+// checkTplPartialRefs returns an error if the partial with the given ID
+// is included by templates or unfinished campaigns and is being deleted or
+// renamed to newName.
+func checkTplPartialRefs(id int, newName string, app *App) error {
+	var out []models.TemplatePartial
+	if err := app.queries.GetTplPartials.Select(&out, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template partials: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Partial not found.")
+	}
+	if newName == out[0].Name {
+		return nil
+	}
+
+	var refs []tplPartialRef
+	if err := app.queries.GetTplPartialRefs.Select(&refs, out[0].Name); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template partials: %s", pqErrMsg(err)))
+	}
+	if len(refs) > 0 {
+		names := make([]string, 0, len(refs))
+		for _, r := range refs {
+			names = append(names, r.Type+" "+r.Name)
+		}
+		return newHTTPError(http.StatusBadRequest, errCodeConflict,
+			fmt.Sprintf("The partial is used by: %s", strings.Join(names, ", ")))
+	}
+
+	return nil
+}
+
+// loadTplPartials loads all template partials from the DB into the
+// campaign manager.
+func loadTplPartials(app *App) error {
+	var out []models.TemplatePartial
+	if err := app.queries.GetTplPartials.Select(&out, 0); err != nil {
+		return err
+	}
+
+	p := make(map[string]string, len(out))
+	for _, t := range out {
+		p[t.Name] = t.Body
+	}
+	app.manager.SetPartials(p)
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+const (
+	// Viewport in which templates are rendered for thumbnails and the
+	// width the rendered images are scaled down to.
+	tplThumbViewWidth  = 800
+	tplThumbViewHeight = 1000
+	tplThumbWidth      = 240
+
+	tplThumbPrefix = "tpl_thumb_"
+)
+
+// handleGenerateTemplateThumb handles (re)generation of the preview
+// thumbnail of a template.
+func handleGenerateTemplateThumb(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+	if app.screenshot == nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"Thumbnails are not enabled. Set `app.wkhtmltoimage_path` in the config.")
+	}
+
+	if err := makeTplThumb(id, app); err != nil {
+		return err
+	}
+
+	return handleGetTemplates(c)
+}
+
+// updateTplThumb regenerates the thumbnail of a template if thumbnails are
+// enabled. It's meant to be run in the background after a template is saved
+// and errors are only logged.
+func updateTplThumb(id int, app *App) {
+	if app.screenshot == nil {
+		return
+	}
+	if err := makeTplThumb(id, app); err != nil {
+		app.log.Errorf("error generating thumbnail for template %d: %v", id, err)
+	}
+}
+
+// makeTplThumb renders a template with dummy content, stores a scaled down
+// image of it in the media store, and replaces the template's previous
+// thumbnail.
+func makeTplThumb(id int, app *App) error {
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, id, false, "", pq.StringArray{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
+	}
+	if len(tpls) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+	}
+	tpl := tpls[0]
+
+	// Render the template.
+	var body []byte
+	if tpl.Type == models.TemplateTypeTx {
+		out, err := renderTxTpl(tpl, dummySubscriber, nil, app)
+		if err != nil {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+		}
+		body = []byte(out.Body)
+	} else {
+		b, err := renderTplPreview(tpl.Body, nil, nil, dummySubscriber, app)
+		if err != nil {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+		}
+		body = b
+	}
+
+	img, err := app.screenshot.Render(string(body), tplThumbViewWidth, tplThumbViewHeight)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error rendering thumbnail: %v", err))
+	}
+	thumb, err := scaleTplThumb(img)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error resizing thumbnail: %v", err))
+	}
+
+	// The filename changes with every generation so that cached
+	// thumbnails aren't served.
+	fName := fmt.Sprintf("%s%d_%d.png", tplThumbPrefix, id, time.Now().Unix())
+	fName, err = app.media.Put(fName, "image/png", thumb)
+	if err != nil {
+		app.log.Errorf("error saving template thumbnail: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error saving thumbnail: %s", err))
+	}
+
+	var old string
+	if err := app.queries.UpdateTplThumb.Get(&old, id, fName); err != nil {
+		app.media.Delete(fName)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating template: %s", pqErrMsg(err)))
+	}
+	if old != "" && old != fName {
+		app.media.Delete(old)
+	}
+
+	return nil
+}
+
+// scaleTplThumb scales a rendered PNG image down to the thumbnail width.
+func scaleTplThumb(img []byte) (*bytes.Reader, error) {
+	src, err := imaging.Decode(bytes.NewReader(img))
+	if err != nil {
+		return nil, err
+	}
+	if src.Bounds().Dx() == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	var (
+		thumb = imaging.Resize(src, tplThumbWidth, 0, imaging.Lanczos)
+		out   bytes.Buffer
+	)
+	if err := imaging.Encode(&out, thumb, imaging.PNG); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(out.Bytes()), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// handleGetTemplateVariants handles retrieval of the language variants
+// of a template.
+func handleGetTemplateVariants(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		out   []models.TemplateVariant
+		id, _ = strconv.Atoi(c.Param("id"))
+		lang  = c.Param("lang")
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetTplVariants.Select(&out, id, lang); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template variants: %s", pqErrMsg(err)))
+	}
+
+	if lang != "" {
+		if len(out) == 0 {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Variant not found.")
+		}
+		return c.JSON(http.StatusOK, okResp{out[0]})
+	}
+
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateTemplateVariant handles the creation or modification of a
+// language variant of a template.
+func handleUpdateTemplateVariant(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		lang  = c.Param("lang")
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+	if !regexpLang.MatchString(lang) {
+		return newFieldError("lang", "Invalid language code.")
+	}
+
+	var o models.TemplateVariant
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, id, true, "", pq.StringArray{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
+	}
+	if len(tpls) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+	}
+	if tpls[0].Type != models.TemplateTypeCampaign {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"Language variants are only supported for campaign templates.")
+	}
+
+	// Compile MJML and blocks variants to HTML.
+	body, src, err := compileTplSource(o.Body, app)
+	if err != nil {
+		return newFieldError("body", err.Error())
+	}
+	o.Body, o.BodySource = body, src
+
+	if !regexpTplTag.MatchString(o.Body) {
+		return newFieldError("body",
+			fmt.Sprintf("Template body should contain the %s placeholder exactly once", tplTag))
+	}
+
+	if _, err := app.queries.UpsertTplVariant.Exec(id, lang, o.Body, o.BodySource); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating template variant: %s", pqErrMsg(err)))
+	}
+
+	return handleGetTemplateVariants(c)
+}
+
+// handleDeleteTemplateVariant handles the deletion of a language variant
+// of a template.
+func handleDeleteTemplateVariant(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		lang  = c.Param("lang")
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if _, err := app.queries.DeleteTplVariant.Exec(id, lang); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error deleting template variant: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
@@ -7,9 +7,14 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 
+	"github.com/knadh/listmonk/internal/blocks"
+	"github.com/knadh/listmonk/internal/linediff"
+	"github.com/knadh/listmonk/internal/mjml"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo"
+	"github.com/lib/pq"
 )
 
 const (
@@ -29,8 +34,29 @@ const (
 
 var (
 	regexpTplTag = regexp.MustCompile(`{{(\s+)?template\s+?"content"(\s+)?\.(\s+)?}}`)
+
+	regexpTplVarName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 )
 
+type tplRevisionsWrap struct {
+	Results []models.TemplateRevision `json:"results"`
+
+	Total   int `json:"total"`
+	PerPage int `json:"per_page"`
+	Page    int `json:"page"`
+}
+
+type tplFolder struct {
+	Folder string `db:"folder" json:"folder"`
+	Count  int    `db:"count" json:"count"`
+}
+
+type tplRevisionDiff struct {
+	From int             `json:"from"`
+	To   int             `json:"to"`
+	Diff []linediff.Line `json:"diff"`
+}
+
 // handleGetTemplates handles retrieval of templates.
 func handleGetTemplates(c echo.Context) error {
 	var (
@@ -40,25 +66,40 @@ func handleGetTemplates(c echo.Context) error {
 		id, _     = strconv.Atoi(c.Param("id"))
 		single    = false
 		noBody, _ = strconv.ParseBool(c.QueryParam("no_body"))
+		folder    = strings.TrimSpace(c.QueryParam("folder"))
+		tags      = c.QueryParams()["tag"]
 	)
 
 	// Fetch one list.
 	if id > 0 {
 		single = true
 	}
+	if tags == nil {
+		tags = []string{}
+	}
 
-	err := app.queries.GetTemplates.Select(&out, id, noBody)
+	err := app.queries.GetTemplates.Select(&out, id, noBody, folder, pq.StringArray(tags))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
 	}
 	if single && len(out) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Template not found.")
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
 	}
 
 	if len(out) == 0 {
 		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
 	}
+
+	for i := 0; i < len(out); i++ {
+		if out[i].Tags == nil {
+			out[i].Tags = make(pq.StringArray, 0)
+		}
+		if out[i].Thumb != "" {
+			out[i].ThumbURL = app.media.Get(out[i].Thumb)
+		}
+	}
+
 	if single {
 		return c.JSON(http.StatusOK, okResp{out[0]})
 	}
@@ -66,39 +107,117 @@ func handleGetTemplates(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// handleGetTemplateFolders handles retrieval of the template folders
+// and the number of templates in them.
+func handleGetTemplateFolders(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		out []tplFolder
+	)
+
+	if err := app.queries.GetTemplateFolders.Select(&out); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template folders: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // handlePreviewTemplate renders the HTML preview of a template.
 func handlePreviewTemplate(c echo.Context) error {
 	var (
-		app   = c.Get("app").(*App)
-		id, _ = strconv.Atoi(c.Param("id"))
-		body  = c.FormValue("body")
+		app     = c.Get("app").(*App)
+		id, _   = strconv.Atoi(c.Param("id"))
+		body    = c.FormValue("body")
+		fxID, _ = strconv.Atoi(c.FormValue("fixture_id"))
 
 		tpls []models.Template
+		vars models.TemplateVariables
+		fx   *models.TemplateFixture
 	)
 
+	// Sample data from a saved fixture of the template.
+	if fxID > 0 {
+		if id < 1 {
+			return newFieldError("id", "Invalid ID.")
+		}
+		f, err := getTplFixture(id, fxID, app)
+		if err != nil {
+			return err
+		}
+		fx = &f
+	}
+
 	if body != "" {
+		b, _, err := compileTplSource(body, app)
+		if err != nil {
+			return newFieldError("body", err.Error())
+		}
+		body = b
+
 		if !regexpTplTag.MatchString(body) {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return newFieldError("body",
 				fmt.Sprintf("Template body should contain the %s placeholder exactly once", tplTag))
 		}
 	} else {
 		if id < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+			return newFieldError("id", "Invalid ID.")
 		}
 
-		err := app.queries.GetTemplates.Select(&tpls, id, false)
+		err := app.queries.GetTemplates.Select(&tpls, id, false, "", pq.StringArray{})
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError,
 				fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
 		}
 
 		if len(tpls) == 0 {
-			return echo.NewHTTPError(http.StatusBadRequest, "Template not found.")
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+		}
+
+		// Transactional templates are rendered with the fixture's data,
+		// or no data.
+		if tpls[0].Type == models.TemplateTypeTx {
+			sub, err := getTplPreviewSubscriber(c, fx, app)
+			if err != nil {
+				return err
+			}
+
+			var data map[string]interface{}
+			if fx != nil {
+				data = fx.Data
+			}
+			out, err := renderTxTpl(tpls[0], sub, data, app)
+			if err != nil {
+				return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+			}
+			return c.HTML(http.StatusOK, out.Body)
 		}
 		body = tpls[0].Body
+		vars = tpls[0].Variables
 	}
 
-	// Compile the template.
+	// Render the message body with the requested subscriber, if any.
+	sub, err := getTplPreviewSubscriber(c, fx, app)
+	if err != nil {
+		return err
+	}
+
+	b, err := renderTplPreview(body, vars, fx, sub, app)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+	}
+
+	return c.HTML(http.StatusOK, string(b))
+}
+
+// renderTplPreview renders a campaign template body with a dummy campaign
+// for the given subscriber. The campaign fields and variable values are
+// taken from the fixture, if there's one.
+func renderTplPreview(body string, vars models.TemplateVariables, fx *models.TemplateFixture,
+	sub models.Subscriber, app *App) ([]byte, error) {
 	camp := models.Campaign{
 		UUID:         dummyUUID,
 		Name:         "Dummy Campaign",
@@ -107,19 +226,32 @@ func handlePreviewTemplate(c echo.Context) error {
 		TemplateBody: body,
 		Body:         dummyTpl,
 	}
+	if fx != nil {
+		if fx.Campaign.Name != "" {
+			camp.Name = fx.Campaign.Name
+		}
+		if fx.Campaign.Subject != "" {
+			camp.Subject = fx.Campaign.Subject
+		}
+		if fx.Campaign.FromEmail != "" {
+			camp.FromEmail = fx.Campaign.FromEmail
+		}
+		if fx.Campaign.Body != "" {
+			camp.Body = fx.Campaign.Body
+		}
+		camp.TemplateVariables = vars
+		camp.TemplateVars = fx.Data
+	}
 
 	if err := camp.CompileTemplate(app.manager.TemplateFuncs(&camp)); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Error compiling template: %v", err))
+		return nil, fmt.Errorf("Error compiling template: %v", err)
 	}
 
-	// Render the message body.
-	m := app.manager.NewCampaignMessage(&camp, dummySubscriber)
+	m := app.manager.NewCampaignMessage(&camp, sub)
 	if err := m.Render(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			fmt.Sprintf("Error rendering message: %v", err))
+		return nil, fmt.Errorf("Error rendering message: %v", err)
 	}
-
-	return c.HTML(http.StatusOK, string(m.Body()))
+	return m.Body(), nil
 }
 
 // handleCreateTemplate handles template creation.
@@ -132,19 +264,46 @@ func handleCreateTemplate(c echo.Context) error {
 	if err := c.Bind(&o); err != nil {
 		return err
 	}
+	if o.Type == "" {
+		o.Type = models.TemplateTypeCampaign
+	}
+
+	// Compile MJML and blocks templates to HTML.
+	if body, src, err := compileTplSource(o.Body, app); err != nil {
+		return newFieldError("body", err.Error())
+	} else if src != "" {
+		o.Body, o.BodySource = body, src
+	}
 
 	if err := validateTemplate(o); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return newValidationError(err)
+	}
+	if o.Type == models.TemplateTypeTx {
+		if _, _, err := compileTxTpl(o, app); err != nil {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+		}
 	}
 
 	// Insert and read ID.
 	var newID int
 	if err := app.queries.CreateTemplate.Get(&newID,
 		o.Name,
-		o.Body); err != nil {
+		o.Body,
+		o.BodySource,
+		o.Type,
+		o.Subject,
+		o.Variables,
+		strings.TrimSpace(o.Folder),
+		pq.StringArray(normalizeTags(o.Tags))); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "idx_templates_tx_name" {
+			return newHTTPError(http.StatusBadRequest, errCodeExists,
+				"A transactional template with the name already exists.")
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error template user: %v", pqErrMsg(err)))
 	}
+	recordTplRevision(newID, c, app)
+	go updateTplThumb(newID, app)
 
 	// Hand over to the GET handler to return the last insertion.
 	return handleGetTemplates(copyEchoCtx(c, map[string]string{
@@ -160,7 +319,7 @@ func handleUpdateTemplate(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	}
 
 	var o models.Template
@@ -168,20 +327,50 @@ func handleUpdateTemplate(c echo.Context) error {
 		return err
 	}
 
+	// The type of a template can't be changed.
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, id, true, "", pq.StringArray{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
+	}
+	if len(tpls) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+	}
+	o.Type = tpls[0].Type
+
+	// Compile MJML and blocks templates to HTML.
+	if body, src, err := compileTplSource(o.Body, app); err != nil {
+		return newFieldError("body", err.Error())
+	} else if src != "" {
+		o.Body, o.BodySource = body, src
+	}
+
 	if err := validateTemplate(o); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return newValidationError(err)
+	}
+	if o.Type == models.TemplateTypeTx {
+		if _, _, err := compileTxTpl(o, app); err != nil {
+			return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+		}
 	}
 
 	// TODO: PASSWORD HASHING.
-	res, err := app.queries.UpdateTemplate.Exec(o.ID, o.Name, o.Body)
+	res, err := app.queries.UpdateTemplate.Exec(id, o.Name, o.Body, o.BodySource, o.Subject, o.Variables,
+		strings.TrimSpace(o.Folder), pq.StringArray(normalizeTags(o.Tags)))
 	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "idx_templates_tx_name" {
+			return newHTTPError(http.StatusBadRequest, errCodeExists,
+				"A transactional template with the name already exists.")
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Error updating template: %s", pqErrMsg(err)))
 	}
 
 	if n, _ := res.RowsAffected(); n == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Template not found.")
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
 	}
+	recordTplRevision(id, c, app)
+	go updateTplThumb(id, app)
 
 	return handleGetTemplates(c)
 }
@@ -194,7 +383,20 @@ func handleTemplateSetDefault(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, id, true, "", pq.StringArray{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
+	}
+	if len(tpls) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+	}
+	if tpls[0].Type != models.TemplateTypeCampaign {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"A transactional template can't be the default template.")
 	}
 
 	_, err := app.queries.SetDefaultTemplate.Exec(id)
@@ -206,6 +408,64 @@ func handleTemplateSetDefault(c echo.Context) error {
 	return handleGetTemplates(c)
 }
 
+// handleTemplateSetFallback handles setting a template as the fallback
+// template that's used for campaigns whose template is missing.
+func handleTemplateSetFallback(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, id, true, "", pq.StringArray{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
+	}
+	if len(tpls) == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+	}
+	if tpls[0].Type != models.TemplateTypeCampaign {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"A transactional template can't be the fallback template.")
+	}
+
+	if _, err := app.queries.SetFallbackTpl.Exec(id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating template: %s", pqErrMsg(err)))
+	}
+
+	return handleGetTemplates(c)
+}
+
+// handleTemplateUnsetFallback handles unsetting the template as the fallback
+// template. The default template is used for campaigns whose template is
+// missing when there's no fallback.
+func handleTemplateUnsetFallback(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	res, err := app.queries.UnsetFallbackTpl.Exec(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating template: %s", pqErrMsg(err)))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "The template isn't the fallback template.")
+	}
+
+	return handleGetTemplates(c)
+}
+
 // handleDeleteTemplate handles template deletion.
 func handleDeleteTemplate(c echo.Context) error {
 	var (
@@ -214,9 +474,27 @@ func handleDeleteTemplate(c echo.Context) error {
 	)
 
 	if id < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ID.")
+		return newFieldError("id", "Invalid ID.")
 	} else if id == 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Cannot delete the primordial template.")
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Cannot delete the primordial template.")
+	}
+
+	// Templates of campaigns that are (or can be) sending can't be deleted.
+	var numCamps int
+	if err := app.queries.GetTplActiveCamps.Get(&numCamps, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template campaigns: %s", pqErrMsg(err)))
+	}
+	if numCamps > 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeConflict,
+			fmt.Sprintf("Cannot delete the template as it's used by %d scheduled, running, or paused campaign(s).", numCamps))
+	}
+
+	// Get the thumbnail to remove it from the media store.
+	var tpls []models.Template
+	if err := app.queries.GetTemplates.Select(&tpls, id, true, "", pq.StringArray{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
 	}
 
 	var delID int
@@ -226,27 +504,267 @@ func handleDeleteTemplate(c echo.Context) error {
 			return c.JSON(http.StatusOK, okResp{true})
 		}
 
-		return echo.NewHTTPError(http.StatusBadRequest,
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
 			fmt.Sprintf("Error deleting template: %v", err))
 	}
 
 	if delID == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			"Cannot delete the last, default, or non-existent template.")
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"Cannot delete the last, default, fallback, or non-existent template.")
+	}
+
+	if len(tpls) > 0 && tpls[0].Thumb != "" {
+		app.media.Delete(tpls[0].Thumb)
 	}
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// handleGetTemplateRevisions handles retrieval of a template's revisions.
+func handleGetTemplateRevisions(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		out tplRevisionsWrap
+
+		pg        = getPagination(c.QueryParams(), 20, 50)
+		id, _     = strconv.Atoi(c.Param("id"))
+		revID, _  = strconv.Atoi(c.Param("revID"))
+		noBody, _ = strconv.ParseBool(c.QueryParam("no_body"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := app.queries.GetTplRevisions.Select(&out.Results, id, revID, noBody,
+		pg.Offset, pg.Limit); err != nil {
+		getLogger(c).Errorf("error fetching template revisions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template revisions: %s", pqErrMsg(err)))
+	}
+
+	if revID > 0 {
+		if len(out.Results) == 0 {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Revision not found.")
+		}
+		return c.JSON(http.StatusOK, okResp{out.Results[0]})
+	}
+
+	if len(out.Results) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	// Meta.
+	out.Total = out.Results[0].Total
+	out.Page = pg.Page
+	out.PerPage = pg.PerPage
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDiffTemplateRevision returns a line diff of a template revision against
+// another revision (?to=revID) or, by default, the template's current body.
+func handleDiffTemplateRevision(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		id, _    = strconv.Atoi(c.Param("id"))
+		revID, _ = strconv.Atoi(c.Param("revID"))
+		toID, _  = strconv.Atoi(c.QueryParam("to"))
+	)
+
+	if id < 1 || revID < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	from, err := getTplRevisionBody(id, revID, app)
+	if err != nil {
+		return err
+	}
+
+	// Diff against the current body of the template by default.
+	var to string
+	if toID > 0 {
+		if to, err = getTplRevisionBody(id, toID, app); err != nil {
+			return err
+		}
+	} else {
+		var tpls []models.Template
+		if err := app.queries.GetTemplates.Select(&tpls, id, false, "", pq.StringArray{}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error fetching templates: %s", pqErrMsg(err)))
+		}
+		if len(tpls) == 0 {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Template not found.")
+		}
+		to = tpls[0].Body
+		if tpls[0].BodySource != "" {
+			to = tpls[0].BodySource
+		}
+	}
+
+	diff, ok := linediff.Diff(from, to)
+	if !ok {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			fmt.Sprintf("The revisions are too large to diff (over %d changed lines).", linediff.MaxLines))
+	}
+
+	return c.JSON(http.StatusOK, okResp{tplRevisionDiff{
+		From: revID,
+		To:   toID,
+		Diff: diff,
+	}})
+}
+
+// handleRestoreTemplateRevision restores a template to one of its revisions.
+func handleRestoreTemplateRevision(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		id, _    = strconv.Atoi(c.Param("id"))
+		revID, _ = strconv.Atoi(c.Param("revID"))
+	)
+
+	if id < 1 || revID < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	res, err := app.queries.RestoreTplRevision.Exec(id, revID)
+	if err != nil {
+		getLogger(c).Errorf("error restoring template revision: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error restoring template revision: %s", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Revision not found.")
+	}
+	recordTplRevision(id, c, app)
+	go updateTplThumb(id, app)
+
+	return handleGetTemplates(copyEchoCtx(c, map[string]string{
+		"id": c.Param("id"),
+	}))
+}
+
+// recordTplRevision records the current body of a template as a revision
+// authored by the requesting user. Errors are only logged as the template
+// itself has already been saved.
+func recordTplRevision(id int, c echo.Context, app *App) {
+	if _, err := app.queries.InsertTplRevision.Exec(id, getRequestUser(c)); err != nil {
+		getLogger(c).Errorf("error recording template revision: %v", err)
+	}
+}
+
+// getTplRevisionBody returns the body of a template revision, preferring
+// the MJML source if there's one.
+func getTplRevisionBody(id, revID int, app *App) (string, error) {
+	var out []models.TemplateRevision
+	if err := app.queries.GetTplRevisions.Select(&out, id, revID, false, 0, 1); err != nil {
+		app.log.Errorf("error fetching template revision: %v", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template revision: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return "", newHTTPError(http.StatusBadRequest, errCodeNotFound, "Revision not found.")
+	}
+
+	if out[0].BodySource != "" {
+		return out[0].BodySource, nil
+	}
+	return out[0].Body, nil
+}
+
 // validateTemplate validates template fields.
 func validateTemplate(o models.Template) error {
 	if !strHasLen(o.Name, 1, stdInputMaxLen) {
-		return errors.New("invalid length for `name`")
+		return fieldError{Field: "name", Message: "invalid length for `name`"}
+	}
+	if !strHasLen(o.Folder, 0, stdInputMaxLen) {
+		return fieldError{Field: "folder", Message: "invalid length for `folder`"}
 	}
 
-	if !regexpTplTag.MatchString(o.Body) {
-		return fmt.Errorf("template body should contain the %s placeholder exactly once", tplTag)
+	switch o.Type {
+	case models.TemplateTypeCampaign:
+		if !regexpTplTag.MatchString(o.Body) {
+			return fieldError{Field: "body",
+				Message: fmt.Sprintf("template body should contain the %s placeholder exactly once", tplTag)}
+		}
+	case models.TemplateTypeTx:
+		if !strHasLen(o.Subject, 1, stdInputMaxLen) {
+			return fieldError{Field: "subject", Message: "invalid length for `subject`"}
+		}
+		if o.Body == "" {
+			return fieldError{Field: "body", Message: "invalid length for `body`"}
+		}
+	default:
+		return fieldError{Field: "type", Message: "invalid template type"}
+	}
+
+	return validateTemplateVars(o.Variables)
+}
+
+// validateTemplateVars validates the variables declared by a template.
+func validateTemplateVars(vars models.TemplateVariables) error {
+	seen := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		if !regexpTplVarName.MatchString(v.Name) {
+			return fieldError{Field: "variables", Message: fmt.Sprintf("invalid variable name `%s`", v.Name)}
+		}
+		if seen[v.Name] {
+			return fieldError{Field: "variables", Message: fmt.Sprintf("duplicate variable `%s`", v.Name)}
+		}
+		seen[v.Name] = true
+
+		switch v.Type {
+		case models.TemplateVarString, models.TemplateVarNumber, models.TemplateVarBoolean,
+			models.TemplateVarObject, models.TemplateVarArray:
+		default:
+			return fieldError{Field: "variables",
+				Message: fmt.Sprintf("invalid type `%s` for variable `%s`", v.Type, v.Name)}
+		}
+
+		if v.Default != nil && !v.IsValid(v.Default) {
+			return fieldError{Field: "variables",
+				Message: fmt.Sprintf("default of variable `%s` should be of type %s", v.Name, v.Type)}
+		}
+		if !strHasLen(v.Description, 0, stdInputMaxLen) {
+			return fieldError{Field: "variables",
+				Message: fmt.Sprintf("invalid length for the description of variable `%s`", v.Name)}
+		}
 	}
 
 	return nil
 }
+
+// compileTplSource compiles a template body that's written in MJML or as a
+// blocks document to HTML. The returned source is the original body, or
+// empty if the body is already HTML.
+func compileTplSource(body string, app *App) (string, string, error) {
+	switch {
+	case mjml.IsMJML(body):
+		out, err := compileMJML(body, app)
+		return out, body, err
+	case blocks.IsBlocks(body):
+		out, err := blocks.Render([]byte(body))
+		return out, body, err
+	}
+	return body, "", nil
+}
+
+// compileCampaignBody compiles a campaign body of the mjml or blocks
+// content types to HTML. Other bodies are returned as-is.
+func compileCampaignBody(contentType, body string, app *App) (string, error) {
+	switch contentType {
+	case models.CampaignContentTypeMJML:
+		return compileMJML(body, app)
+	case models.CampaignContentTypeBlocks:
+		return blocks.Render([]byte(body))
+	}
+	return body, nil
+}
+
+// compileMJML compiles MJML markup to HTML using the configured mjml compiler.
+func compileMJML(src string, app *App) (string, error) {
+	if app.mjml == nil {
+		return "", errors.New("MJML is not enabled. Set `app.mjml_path` in the config")
+	}
+	return app.mjml.Compile(src)
+}
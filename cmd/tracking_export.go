@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// trackExportBatchSize is the number of tracking events fetched from the DB
+// at a time while streaming an export.
+const trackExportBatchSize = 1000
+
+// trackEvent is a raw open (view) or click event in tracking exports.
+type trackEvent struct {
+	ID             int64     `db:"id" json:"-"`
+	Type           string    `db:"type" json:"type"`
+	CampaignID     int       `db:"campaign_id" json:"campaign_id"`
+	CampaignUUID   string    `db:"campaign_uuid" json:"campaign_uuid"`
+	SubscriberUUID string    `db:"subscriber_uuid" json:"subscriber_uuid"`
+	URL            string    `db:"url" json:"url"`
+	IP             string    `db:"ip" json:"ip"`
+	Country        string    `db:"country" json:"country"`
+	Region         string    `db:"region" json:"region"`
+	Client         string    `db:"client" json:"client"`
+	Device         string    `db:"device" json:"device"`
+	UserAgent      string    `db:"user_agent" json:"user_agent"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+var trackExportHeader = []string{"type", "campaign_id", "campaign_uuid", "subscriber_uuid", "url",
+	"ip", "country", "region", "client", "device", "user_agent", "created_at"}
+
+// handleExportTrackingEvents handles the streaming export of the raw open
+// and click events of a campaign (`?campaign_id=`), or of all campaigns,
+// between the `?from=` and `?to=` dates (default: the last 30 days). The
+// events can be limited to `?type=view` or `?type=click`. They're exported
+// as CSV, or as newline delimited JSON with `?format=ndjson`.
+func handleExportTrackingEvents(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		campID, _ = strconv.Atoi(c.QueryParam("campaign_id"))
+		typ       = c.QueryParam("type")
+		format    = c.QueryParam("format")
+	)
+
+	if campID < 0 {
+		return newFieldError("campaign_id", "Invalid ID.")
+	}
+	if typ != "" && typ != "view" && typ != "click" {
+		return newFieldError("type", "Invalid type. Use view or click.")
+	}
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		return newFieldError("format", "Invalid format. Use csv or ndjson.")
+	}
+
+	var (
+		to   = time.Now()
+		from = to.AddDate(0, 0, -30)
+		err  error
+	)
+	if s := c.QueryParam("from"); s != "" {
+		if from, err = time.Parse(dateFormat, s); err != nil {
+			return newFieldError("from", "Invalid date. Use YYYY-MM-DD.")
+		}
+	}
+	if s := c.QueryParam("to"); s != "" {
+		t, err := time.Parse(dateFormat, s)
+		if err != nil {
+			return newFieldError("to", "Invalid date. Use YYYY-MM-DD.")
+		}
+		// The end date is inclusive.
+		to = t.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return newFieldError("from", "The from date should be before the to date.")
+	}
+
+	var stmts []*sqlx.Stmt
+	if typ == "" || typ == "view" {
+		stmts = append(stmts, app.queries.ExportCampaignViews)
+	}
+	if typ == "" || typ == "click" {
+		stmts = append(stmts, app.queries.ExportLinkClicks)
+	}
+
+	// Fetch the first batch before writing anything to the response so that
+	// query errors can still be returned as regular HTTP errors.
+	var out []trackEvent
+	if err := stmts[0].Select(&out, campID, from, to, 0, trackExportBatchSize); err != nil {
+		getLogger(c).Errorf("error querying tracking events for export: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			"Error querying tracking events: "+pqErrMsg(err))
+	}
+
+	h := c.Response().Header()
+	if format == "ndjson" {
+		h.Set("Content-Type", "application/x-ndjson")
+		h.Set("Content-Disposition", `attachment; filename="tracking-events.ndjson"`)
+	} else {
+		h.Set("Content-Type", "text/csv")
+		h.Set("Content-Disposition", `attachment; filename="tracking-events.csv"`)
+	}
+	h.Set("Cache-Control", "no-cache")
+	c.Response().WriteHeader(http.StatusOK)
+
+	var (
+		wr  = csv.NewWriter(c.Response())
+		enc = json.NewEncoder(c.Response())
+	)
+	if format == "csv" {
+		if err := wr.Write(trackExportHeader); err != nil {
+			return nil
+		}
+	}
+
+	for i, stmt := range stmts {
+		if i > 0 {
+			out = out[:0]
+			if err := stmt.Select(&out, campID, from, to, 0, trackExportBatchSize); err != nil {
+				getLogger(c).Errorf("error querying tracking events for export: %v", err)
+				return nil
+			}
+		}
+
+		for len(out) > 0 {
+			for _, e := range out {
+				var err error
+				if format == "ndjson" {
+					err = enc.Encode(e)
+				} else {
+					err = wr.Write([]string{e.Type, strconv.Itoa(e.CampaignID), e.CampaignUUID,
+						e.SubscriberUUID, csvCell(e.URL), e.IP, csvCell(e.Country), csvCell(e.Region),
+						csvCell(e.Client), csvCell(e.Device), csvCell(e.UserAgent),
+						formatExportTime(null.TimeFrom(e.CreatedAt))})
+				}
+				if err != nil {
+					getLogger(c).Errorf("error writing tracking event export: %v", err)
+					return nil
+				}
+			}
+			wr.Flush()
+			c.Response().Flush()
+
+			// Fetch the next batch.
+			lastID := out[len(out)-1].ID
+			out = out[:0]
+			if err := stmt.Select(&out, campID, from, to, lastID, trackExportBatchSize); err != nil {
+				getLogger(c).Errorf("error querying tracking events for export: %v", err)
+				return nil
+			}
+		}
+	}
+
+	wr.Flush()
+	return nil
+}
+
+// csvCell prefixes values that spreadsheets would evaluate as formulas
+// with a quote. URLs and user agents in tracking events are set by the
+// clients.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Interval at which raw tracking events older than their retention
+	// period are pruned.
+	trackingPruneInterval = time.Hour
+
+	// Minimum retention of the raw tracking events. Subscriber cohorts are
+	// recomputed from the start of the previous month and need its events.
+	trackingMinRetention = time.Hour * 24 * 90
+)
+
+// pruneTrackingEvents is a blocking function that deletes the raw campaign
+// views and link clicks older than their retention periods at intervals
+// after adding them to their daily counts. Events are pruned by whole days
+// so that the daily unique counts are of complete days. A retention of 0
+// keeps the events forever.
+func pruneTrackingEvents(viewsRetention, clicksRetention time.Duration, app *App) {
+	ticker := time.NewTicker(trackingPruneInterval)
+	for ; true; <-ticker.C {
+		if viewsRetention > 0 {
+			pruneTrackingTable(app.queries.PruneCampaignViews, "campaign views", viewsRetention, app)
+		}
+		if clicksRetention > 0 {
+			pruneTrackingTable(app.queries.PruneLinkClicks, "link clicks", clicksRetention, app)
+		}
+	}
+}
+
+// pruneTrackingTable runs a tracking event pruning query in batches till
+// there's nothing left to prune.
+func pruneTrackingTable(stmt *sqlx.Stmt, name string, retention time.Duration, app *App) {
+	before := time.Now().Add(-retention).Format(dateFormat)
+	for {
+		res, err := stmt.Exec(before)
+		if err != nil {
+			app.log.Errorf("error pruning %s: %v", name, err)
+			return
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			return
+		}
+		app.log.Infof("pruned %s into %d daily counts", name, n)
+	}
+}
+
+// trackingSince returns the date from which the raw views and clicks are
+// kept, which is when the reports of unique subscribers, locations, and
+// clients start. It's the zero time if neither is pruned.
+func trackingSince(app *App) time.Time {
+	var since time.Time
+	for _, r := range []time.Duration{app.constants.ViewsRetention, app.constants.ClicksRetention} {
+		if r <= 0 {
+			continue
+		}
+		// Events are pruned by whole days.
+		t := time.Now().Add(-r)
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		if t.After(since) {
+			since = t
+		}
+	}
+	return since
+}
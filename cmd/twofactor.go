@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/totp"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+const (
+	numRecoveryCodes = 10
+	totpIssuer       = "listmonk"
+)
+
+// totpSteps holds the last TOTP time step used by each user to prevent
+// the reuse of codes.
+type totpSteps struct {
+	sync.Mutex
+	steps map[int]int64
+}
+
+// handleEnrollTwoFactor generates a new TOTP secret for the user making
+// the request. 2FA is enabled only once a code from the secret is verified.
+func handleEnrollTwoFactor(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		u   = getSessionUser(c)
+	)
+
+	if u.ID == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"There's no user profile as authentication is disabled.")
+	}
+	if u.TOTPEnabled {
+		return newHTTPError(http.StatusBadRequest, errCodeConflict,
+			"2FA is already enabled. Disable it to enroll again.")
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		getLogger(c).Errorf("error generating TOTP secret: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error generating TOTP secret.")
+	}
+
+	if err := updateUserTOTP(u.ID, secret, false, nil, app); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Secret string `json:"secret"`
+		URL    string `json:"url"`
+	}{secret, totp.URL(totpIssuer, u.Username, secret)}})
+}
+
+// handleEnableTwoFactor verifies a code from the enrolled TOTP secret of
+// the user making the request, enables 2FA, and returns the recovery codes.
+func handleEnableTwoFactor(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req struct {
+			Code string `json:"code"`
+		}
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if getSessionUser(c).ID == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"There's no user profile as authentication is disabled.")
+	}
+
+	u, err := getUser(getSessionUser(c).ID, app)
+	if err != nil {
+		return err
+	}
+	if u.TOTPEnabled {
+		return newHTTPError(http.StatusBadRequest, errCodeConflict, "2FA is already enabled.")
+	}
+	if u.TOTPSecret == "" {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Enroll for 2FA first.")
+	}
+	if !app.totpSteps.validate(u, strings.TrimSpace(req.Code)) {
+		return newFieldError("code", "Invalid 2FA code.")
+	}
+
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		getLogger(c).Errorf("error generating recovery codes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error generating recovery codes.")
+	}
+	if err := updateUserTOTP(u.ID, u.TOTPSecret, true, hashes, app); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes}})
+}
+
+// handleRegenRecoveryCodes replaces the recovery codes of the user making
+// the request and returns the new codes.
+func handleRegenRecoveryCodes(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	if getSessionUser(c).ID == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"There's no user profile as authentication is disabled.")
+	}
+
+	u, err := getUser(getSessionUser(c).ID, app)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabled {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, "2FA is not enabled.")
+	}
+
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		getLogger(c).Errorf("error generating recovery codes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error generating recovery codes.")
+	}
+	if err := updateUserTOTP(u.ID, u.TOTPSecret, true, hashes, app); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes}})
+}
+
+// handleDisableTwoFactor disables 2FA for the user making the request.
+func handleDisableTwoFactor(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		u   = getSessionUser(c)
+	)
+
+	if u.ID == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"There's no user profile as authentication is disabled.")
+	}
+
+	if err := updateUserTOTP(u.ID, "", false, nil, app); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleResetUserTwoFactor disables 2FA for a user, eg: one who has lost
+// their authenticator and recovery codes.
+func handleResetUserTwoFactor(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := updateUserTOTP(id, "", false, nil, app); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// verifyTwoFactor verifies a TOTP code or a recovery code of a user with
+// 2FA enabled. A recovery code is removed once it's used.
+func verifyTwoFactor(u models.User, code string, app *App) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return false, nil
+	}
+
+	if len(code) == totp.Digits {
+		return app.totpSteps.validate(u, code), nil
+	}
+
+	var id int
+	if err := app.queries.ConsumeRecCode.Get(&id, u.ID, hashRecoveryCode(code)); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		app.log.Errorf("error checking recovery code: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error checking recovery code: %s", pqErrMsg(err)))
+	}
+	app.log.Warnf("user %s logged in with a recovery code", u.Username)
+
+	return true, nil
+}
+
+// updateUserTOTP updates the 2FA fields of a user and clears the
+// authentication cache.
+func updateUserTOTP(id int, secret string, enabled bool, recCodes []string, app *App) error {
+	if recCodes == nil {
+		recCodes = []string{}
+	}
+
+	res, err := app.queries.UpdateUserTOTP.Exec(id, secret, enabled, pq.StringArray(recCodes))
+	if err != nil {
+		app.log.Errorf("error updating user 2FA: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating user 2FA: %s", pqErrMsg(err)))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "User not found.")
+	}
+	app.authCache.clear()
+
+	return nil
+}
+
+// makeRecoveryCodes returns new random recovery codes and their hashes.
+func makeRecoveryCodes() ([]string, []string, error) {
+	var (
+		codes  = make([]string, 0, numRecoveryCodes)
+		hashes = make([]string, 0, numRecoveryCodes)
+		enc    = base32.StdEncoding.WithPadding(base32.NoPadding)
+	)
+	for i := 0; i < numRecoveryCodes; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))
+		code := s[:5] + "-" + s[5:10]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+func newTOTPSteps() *totpSteps {
+	return &totpSteps{steps: make(map[int]int64)}
+}
+
+// validate validates a TOTP code of a user and records its time step
+// so that it can't be reused.
+func (t *totpSteps) validate(u models.User, code string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	step, ok := totp.Validate(code, u.TOTPSecret, time.Now(), t.steps[u.ID])
+	if ok {
+		t.steps[u.ID] = step
+	}
+	return ok
+}
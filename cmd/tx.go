@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/tplfuncs"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// txMessageReq is a request to send a transactional message to a subscriber
+// or a raw e-mail address. The template is addressed by ID or name.
+type txMessageReq struct {
+	SubscriberID    int                    `json:"subscriber_id"`
+	SubscriberEmail string                 `json:"subscriber_email"`
+	ToEmail         string                 `json:"to_email"`
+	ToName          string                 `json:"to_name"`
+	TemplateID      int                    `json:"template_id"`
+	TemplateName    string                 `json:"template_name"`
+	FromEmail       string                 `json:"from_email"`
+	Messenger       string                 `json:"messenger"`
+	Data            map[string]interface{} `json:"data"`
+
+	// FixtureID is a saved fixture of the template whose subscriber and
+	// data are used for test rendering. Only applicable to rendering.
+	FixtureID int `json:"fixture_id"`
+}
+
+// txTplData is the data that's passed to transactional templates.
+type txTplData struct {
+	Subscriber models.Subscriber
+	Tx         map[string]interface{}
+}
+
+type txRendered struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type txLogWrap struct {
+	Results []models.TxLog `json:"results"`
+
+	Total   int `json:"total"`
+	PerPage int `json:"per_page"`
+	Page    int `json:"page"`
+}
+
+// handleSendTxMessage handles sending a transactional message to a
+// subscriber or a raw e-mail address with a transactional template.
+func handleSendTxMessage(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		o   txMessageReq
+	)
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if d := app.txLimit.Reserve(); d > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Transactional message rate limit exceeded.")
+	}
+
+	if o.TemplateID < 1 && o.TemplateName == "" {
+		return newFieldError("template_id", "`template_id` or `template_name` is required.")
+	}
+	if o.FromEmail == "" {
+		o.FromEmail = app.constants.FromEmail
+	} else if !regexFromAddress.MatchString(o.FromEmail) && !subimporter.IsEmail(o.FromEmail) {
+		return newFieldError("from_email", "Invalid `from_email`.")
+	}
+	if o.Messenger == "" {
+		o.Messenger = emailMsgr
+	}
+	if !app.manager.HasMessenger(o.Messenger) {
+		return newFieldError("messenger",
+			fmt.Sprintf("Unknown messenger %s", o.Messenger))
+	}
+
+	var tpl models.Template
+	if err := app.queries.GetTxTemplate.Get(&tpl, o.TemplateID, o.TemplateName); err != nil {
+		if err == sql.ErrNoRows {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Transactional template not found.")
+		}
+		getLogger(c).Errorf("error fetching transactional template: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template: %s", pqErrMsg(err)))
+	}
+
+	sub, err := getTxRecipient(o, app)
+	if err != nil {
+		return err
+	}
+	if sub.Status == models.SubscriberStatusBlockListed {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, "Subscriber is blocklisted.")
+	}
+
+	out, err := renderTxTpl(tpl, sub, o.Data, app)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+	}
+
+	// Record the message in the transactional log. The delivery status is
+	// updated by the manager once the message is pushed.
+	var logID int64
+	if err := app.queries.InsertTxLog.Get(&logID, tpl.ID, sub.ID, sub.Email, o.Messenger, out.Subject); err != nil {
+		getLogger(c).Errorf("error recording transactional message: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error recording message: %s", pqErrMsg(err)))
+	}
+
+	m := manager.Message{}
+	m.From = o.FromEmail
+	m.To = []string{sub.Email}
+	m.Subject = out.Subject
+	m.Body = []byte(out.Body)
+	m.Subscriber = sub
+	m.Messenger = o.Messenger
+	m.Priority = manager.PriorityHigh
+	m.TxLogID = logID
+	if err := app.manager.PushMessage(m); err != nil {
+		getLogger(c).Errorf("error sending transactional message (%s): %v", tpl.Name, err)
+		if _, err := app.queries.UpdateTxLog.Exec(logID, models.TxStatusFailed, 0, err.Error()); err != nil {
+			getLogger(c).Errorf("error updating transactional log: %v", err)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error sending message: %v", err))
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		ID int64 `json:"id"`
+	}{logID}})
+}
+
+// handleGetTxLog handles retrieval of the transactional message log.
+func handleGetTxLog(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		pg       = getPagination(c.QueryParams(), 20, 50)
+		out      txLogWrap
+		st       = c.FormValue("status")
+		tplID, _ = strconv.Atoi(c.FormValue("template_id"))
+		subID, _ = strconv.Atoi(c.FormValue("subscriber_id"))
+		email    = strings.ToLower(strings.TrimSpace(c.FormValue("email")))
+	)
+
+	switch st {
+	case "", models.TxStatusQueued, models.TxStatusSent, models.TxStatusFailed:
+	default:
+		return newFieldError("status", "Invalid `status`.")
+	}
+
+	if err := app.queries.QueryTxLog.Select(&out.Results, st, tplID, subID, email, pg.Offset, pg.Limit); err != nil {
+		getLogger(c).Errorf("error fetching transactional log: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching transactional log: %s", pqErrMsg(err)))
+	}
+	if len(out.Results) == 0 {
+		out.Results = []models.TxLog{}
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	out.Total = out.Results[0].Total
+	out.Page = pg.Page
+	out.PerPage = pg.PerPage
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleRenderTxTemplate renders a transactional template with the given
+// data and subscriber (or a dummy one) for testing.
+func handleRenderTxTemplate(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		o     txMessageReq
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	var tpl models.Template
+	if err := app.queries.GetTxTemplate.Get(&tpl, id, ""); err != nil {
+		if err == sql.ErrNoRows {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Transactional template not found.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching template: %s", pqErrMsg(err)))
+	}
+
+	// Values in the request take precedence over the fixture's.
+	sub := dummySubscriber
+	if o.FixtureID > 0 {
+		fx, err := getTplFixture(id, o.FixtureID, app)
+		if err != nil {
+			return err
+		}
+		sub = fixtureSubscriber(fx)
+
+		data := make(map[string]interface{}, len(fx.Data)+len(o.Data))
+		for k, v := range fx.Data {
+			data[k] = v
+		}
+		for k, v := range o.Data {
+			data[k] = v
+		}
+		o.Data = data
+	}
+	if o.SubscriberID > 0 || o.SubscriberEmail != "" {
+		s, err := getTxSubscriber(o.SubscriberID, o.SubscriberEmail, app)
+		if err != nil {
+			return err
+		}
+		sub = s
+	}
+
+	out, err := renderTxTpl(tpl, sub, o.Data, app)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// renderTxTpl validates the data against a transactional template's variable
+// schema and renders the template's subject and body.
+func renderTxTpl(tpl models.Template, sub models.Subscriber, data map[string]interface{}, app *App) (txRendered, error) {
+	data, err := tpl.Variables.Resolve(data)
+	if err != nil {
+		return txRendered{}, err
+	}
+
+	subjTpl, bodyTpl, err := compileTxTpl(tpl, app)
+	if err != nil {
+		return txRendered{}, err
+	}
+
+	var (
+		d    = txTplData{Subscriber: sub, Tx: data}
+		subj bytes.Buffer
+		body bytes.Buffer
+	)
+	if err := subjTpl.Execute(&subj, d); err != nil {
+		return txRendered{}, fmt.Errorf("error rendering subject: %v", err)
+	}
+	if err := bodyTpl.Execute(&body, d); err != nil {
+		return txRendered{}, fmt.Errorf("error rendering body: %v", err)
+	}
+
+	return txRendered{Subject: subj.String(), Body: body.String()}, nil
+}
+
+// compileTxTpl compiles the subject and body of a transactional template.
+func compileTxTpl(tpl models.Template, app *App) (*template.Template, *template.Template, error) {
+	f := template.FuncMap{
+		"RootURL": func() string {
+			return app.constants.RootURL
+		},
+		"Date": func(layout string) string {
+			if layout == "" {
+				layout = time.ANSIC
+			}
+			return time.Now().Format(layout)
+		},
+	}
+	for k, v := range tplfuncs.Funcs() {
+		if _, ok := f[k]; !ok {
+			f[k] = v
+		}
+	}
+
+	subj, err := template.New("subject").Funcs(f).Parse(tpl.Subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error compiling subject: %v", err)
+	}
+	body, err := template.New("tx").Funcs(f).Parse(tpl.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error compiling template: %v", err)
+	}
+
+	return subj, body, nil
+}
+
+// getTxRecipient returns the recipient of a transactional message. A raw
+// `to_email` that doesn't belong to a subscriber gets a subscriber with
+// no ID and attributes.
+func getTxRecipient(o txMessageReq, app *App) (models.Subscriber, error) {
+	if o.SubscriberID > 0 || o.SubscriberEmail != "" || o.ToEmail == "" {
+		return getTxSubscriber(o.SubscriberID, o.SubscriberEmail, app)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(o.ToEmail))
+	if !subimporter.IsEmail(email) {
+		return models.Subscriber{}, newFieldError("to_email", "Invalid `to_email`.")
+	}
+
+	var out models.Subscribers
+	if err := app.queries.GetSubscribersByEmails.Select(&out, pq.StringArray{email}); err != nil {
+		app.log.Errorf("error fetching subscriber: %v", err)
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching subscriber: %s", pqErrMsg(err)))
+	}
+	if len(out) > 0 {
+		return out[0], nil
+	}
+
+	name := strings.TrimSpace(o.ToName)
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+	return models.Subscriber{
+		Email:   email,
+		Name:    name,
+		Attribs: models.SubscriberAttribs{},
+	}, nil
+}
+
+// getTxSubscriber returns a subscriber by ID or e-mail.
+func getTxSubscriber(id int, email string, app *App) (models.Subscriber, error) {
+	if id > 0 {
+		return getSubscriber(id, app)
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return models.Subscriber{}, newFieldError("subscriber_id",
+			"`subscriber_id` or `subscriber_email` is required.")
+	}
+
+	var out models.Subscribers
+	if err := app.queries.GetSubscribersByEmails.Select(&out, pq.StringArray{email}); err != nil {
+		app.log.Errorf("error fetching subscriber: %v", err)
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching subscriber: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return models.Subscriber{}, newHTTPError(http.StatusBadRequest, errCodeNotFound, "Subscriber not found.")
+	}
+
+	return out[0], nil
+}
@@ -37,25 +37,25 @@ func checkUpdates(curVersion string, interval time.Duration, app *App) {
 	for ; true; <-ticker.C {
 		resp, err := http.Get(updateCheckURL)
 		if err != nil {
-			app.log.Printf("error checking for remote update: %v", err)
+			app.log.Errorf("error checking for remote update: %v", err)
 			continue
 		}
 
 		if resp.StatusCode != 200 {
-			app.log.Printf("non 200 response on remote update check: %d", resp.StatusCode)
+			app.log.Warnf("non 200 response on remote update check: %d", resp.StatusCode)
 			continue
 		}
 
 		b, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			app.log.Printf("error reading remote update payload: %v", err)
+			app.log.Errorf("error reading remote update payload: %v", err)
 			continue
 		}
 		resp.Body.Close()
 
 		var up remoteUpdateResp
 		if err := json.Unmarshal(b, &up); err != nil {
-			app.log.Printf("error unmarshalling remote update payload: %v", err)
+			app.log.Errorf("error unmarshalling remote update payload: %v", err)
 			continue
 		}
 
@@ -70,7 +70,7 @@ func checkUpdates(curVersion string, interval time.Duration, app *App) {
 				}
 				app.Unlock()
 
-				app.log.Printf("new update %s found", up.Version)
+				app.log.Infof("new update %s found", up.Version)
 			}
 		}
 	}
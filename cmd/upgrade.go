@@ -28,6 +28,7 @@ var migList = []migFunc{
 	{"v0.4.0", migrations.V0_4_0},
 	{"v0.7.0", migrations.V0_7_0},
 	{"v0.8.0", migrations.V0_8_0},
+	{"v0.9.0", migrations.V0_9_0},
 }
 
 // upgrade upgrades the database to the current version by running SQL migration files
@@ -53,13 +54,13 @@ func upgrade(db *sqlx.DB, fs stuffbin.FileSystem, prompt bool) {
 
 	// No migrations to run.
 	if len(toRun) == 0 {
-		lo.Printf("no upgrades to run. Database is up to date.")
+		lo.Infof("no upgrades to run. Database is up to date.")
 		return
 	}
 
 	// Execute migrations in succession.
 	for _, m := range toRun {
-		lo.Printf("running migration %s", m.version)
+		lo.Infof("running migration %s", m.version)
 		if err := m.fn(db, fs, ko); err != nil {
 			lo.Fatalf("error running migration %s: %v", m.version, err)
 		}
@@ -74,7 +75,7 @@ func upgrade(db *sqlx.DB, fs stuffbin.FileSystem, prompt bool) {
 		}
 	}
 
-	lo.Printf("upgrade complete")
+	lo.Infof("upgrade complete")
 }
 
 // checkUpgrade checks if the current database schema matches the expected
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	userPasswordMinLen = 8
+
+	// Duration for which successfully authenticated credentials are cached
+	// to not bcrypt the password on every request.
+	authCacheTTL = time.Minute
+)
+
+var reUsername = regexp.MustCompile("^[a-z0-9._@-]+$")
+
+// Hash that the passwords of unknown users are compared against so that
+// failed logins take as long whether or not the user exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("listmonk"), bcrypt.DefaultCost)
+
+// Roles of users.
+var userRoles = []string{models.UserRoleAdmin, models.UserRoleCampaignManager, models.UserRoleViewer}
+
+// Admin API routes that can only be accessed by admins.
+var adminRoutes = []string{
+	"/api/settings",
+	"/api/admin",
+	"/api/logs",
+	"/api/audit-log",
+	"/api/users",
+	"/api/webhooks",
+	"/api/roles",
+}
+
+// userReq represents a user create or update request. The password of
+// models.User is never marshalled.
+type userReq struct {
+	models.User
+
+	Password string `json:"password"`
+}
+
+// userCount represents the number of users and enabled admins.
+type userCount struct {
+	Total  int `db:"total"`
+	Admins int `db:"admins"`
+}
+
+// authCache caches successfully authenticated credentials.
+type authCache struct {
+	sync.Mutex
+	users map[string]authCacheItem
+}
+
+type authCacheItem struct {
+	user   models.User
+	expiry time.Time
+}
+
+// handleGetUsers handles retrieval of admin users.
+func handleGetUsers(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		out   []models.User
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if err := app.queries.GetUsers.Select(&out, id); err != nil {
+		getLogger(c).Errorf("error fetching users: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching users: %s", pqErrMsg(err)))
+	}
+
+	if id > 0 {
+		if len(out) == 0 {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "User not found.")
+		}
+		return c.JSON(http.StatusOK, okResp{out[0]})
+	}
+
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateUser handles admin user creation.
+func handleCreateUser(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		o   = userReq{}
+	)
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if o.Status == "" {
+		o.Status = models.UserStatusEnabled
+	}
+	o.Username = strings.ToLower(strings.TrimSpace(o.Username))
+
+	if err := validateUser(o, true); err != nil {
+		return err
+	}
+
+	// The first user is the one that enables authentication and should be
+	// an admin to not lock everyone out of the admin routes.
+	total, _, err := getUserCount(app)
+	if err != nil {
+		return err
+	}
+	if total == 0 && (o.Role != models.UserRoleAdmin || o.Status != models.UserStatusEnabled) {
+		return newFieldError("role",
+			"The first user should be an enabled admin.")
+	}
+
+	hash, err := hashPassword(o.Password)
+	if err != nil {
+		getLogger(c).Errorf("error hashing password: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error creating user.")
+	}
+
+	var newID int
+	if err := app.queries.CreateUser.Get(&newID, o.Username, strings.TrimSpace(o.Name),
+		strings.TrimSpace(o.Email), hash, o.Role, o.Status); err != nil {
+		getLogger(c).Errorf("error creating user: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating user: %s", pqErrMsg(err)))
+	}
+	app.authCache.clear()
+
+	app.Lock()
+	app.hasUsers = true
+	app.Unlock()
+
+	// Hand over to the GET handler to return the last insertion.
+	return handleGetUsers(copyEchoCtx(c, map[string]string{
+		"id": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// handleUpdateUser handles admin user modification. The password is
+// retained if it's empty.
+func handleUpdateUser(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	var o userReq
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := validateUser(o, false); err != nil {
+		return err
+	}
+
+	cur, err := getUser(id, app)
+	if err != nil {
+		return err
+	}
+
+	// Demoting or disabling the last enabled admin locks everyone out of
+	// the admin routes.
+	if o.Role != models.UserRoleAdmin || o.Status != models.UserStatusEnabled {
+		if err := checkLastAdmin(cur, app); err != nil {
+			return err
+		}
+	}
+
+	if err := updateUser(id, o, app); err != nil {
+		return err
+	}
+
+	// Log the user out of other sessions on a password change.
+	if o.Password != "" {
+		deleteUserSessions(c, id, app)
+	}
+
+	return handleGetUsers(c)
+}
+
+// handleDeleteUser handles admin user deletion.
+func handleDeleteUser(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if u := getSessionUser(c); u.ID == id {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid, "You can't delete yourself.")
+	}
+
+	cur, err := getUser(id, app)
+	if err != nil {
+		return err
+	}
+	if err := checkLastAdmin(cur, app); err != nil {
+		return err
+	}
+
+	if _, err := app.queries.DeleteUser.Exec(id); err != nil {
+		getLogger(c).Errorf("error deleting user: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error deleting user: %s", pqErrMsg(err)))
+	}
+	app.authCache.clear()
+
+	if total, _, err := getUserCount(app); err == nil {
+		app.Lock()
+		app.hasUsers = total > 0
+		app.Unlock()
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleGetProfile returns the user making the request.
+func handleGetProfile(c echo.Context) error {
+	return c.JSON(http.StatusOK, okResp{getSessionUser(c)})
+}
+
+// handleUpdateProfile handles the modification of the name, e-mail, and
+// the password of the user making the request.
+func handleUpdateProfile(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		u   = getSessionUser(c)
+	)
+
+	// Authentication is disabled and there's no user.
+	if u.ID == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeInvalid,
+			"There's no user profile as authentication is disabled.")
+	}
+
+	var o userReq
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	// The role and status can't be changed on the profile.
+	o.Role = u.Role
+	o.Status = u.Status
+	if err := validateUser(o, false); err != nil {
+		return err
+	}
+
+	if err := updateUser(u.ID, o, app); err != nil {
+		return err
+	}
+
+	// Log the user out of other sessions on a password change.
+	if o.Password != "" {
+		deleteUserSessions(c, u.ID, app)
+	}
+
+	out, err := getUser(u.ID, app)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// validateUser validates the fields of a user request. The password is
+// required only for new users.
+func validateUser(o userReq, isNew bool) error {
+	if isNew && (!strHasLen(o.Username, 1, stdInputMaxLen) || !reUsername.MatchString(o.Username)) {
+		return newFieldError("username", "Invalid username. Use lowercase letters, numbers, and . _ @ -")
+	}
+	if !strHasLen(o.Name, 0, stdInputMaxLen) {
+		return newFieldError("name", "Invalid length for the name field.")
+	}
+	if o.Email != "" && !subimporter.IsEmail(strings.TrimSpace(o.Email)) {
+		return newFieldError("email", "Invalid e-mail.")
+	}
+	if (isNew || o.Password != "") && !strHasLen(o.Password, userPasswordMinLen, stdInputMaxLen) {
+		return newFieldError("password",
+			fmt.Sprintf("Password should be at least %d characters.", userPasswordMinLen))
+	}
+	if !strSliceContains(o.Role, userRoles) {
+		return newFieldError("role", "Invalid role.")
+	}
+	if o.Status != models.UserStatusEnabled && o.Status != models.UserStatusDisabled {
+		return newFieldError("status", "Invalid status.")
+	}
+
+	return nil
+}
+
+// updateUser updates a user and clears the authentication cache.
+func updateUser(id int, o userReq, app *App) error {
+	var hash string
+	if o.Password != "" {
+		h, err := hashPassword(o.Password)
+		if err != nil {
+			app.log.Errorf("error hashing password: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error updating user.")
+		}
+		hash = h
+	}
+
+	res, err := app.queries.UpdateUser.Exec(id, strings.TrimSpace(o.Name),
+		strings.TrimSpace(o.Email), o.Role, hash, o.Status)
+	if err != nil {
+		app.log.Errorf("error updating user: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating user: %s", pqErrMsg(err)))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "User not found.")
+	}
+	app.authCache.clear()
+
+	return nil
+}
+
+// getUser fetches a user by ID.
+func getUser(id int, app *App) (models.User, error) {
+	var out []models.User
+	if err := app.queries.GetUsers.Select(&out, id); err != nil {
+		app.log.Errorf("error fetching user: %v", err)
+		return models.User{}, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching user: %s", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return models.User{}, newHTTPError(http.StatusBadRequest, errCodeNotFound, "User not found.")
+	}
+	return out[0], nil
+}
+
+// getUserCount returns the number of users and enabled admins.
+func getUserCount(app *App) (int, int, error) {
+	var out userCount
+	if err := app.queries.GetUserCount.Get(&out); err != nil {
+		app.log.Errorf("error counting users: %v", err)
+		return 0, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching users: %s", pqErrMsg(err)))
+	}
+	return out.Total, out.Admins, nil
+}
+
+// checkLastAdmin returns an error if the given user is the last enabled
+// admin, who can't be removed, demoted, or disabled.
+func checkLastAdmin(u models.User, app *App) error {
+	if u.Role != models.UserRoleAdmin || u.Status != models.UserStatusEnabled {
+		return nil
+	}
+
+	_, admins, err := getUserCount(app)
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return newHTTPError(http.StatusBadRequest, errCodeConflict,
+			"There should be at least one enabled admin.")
+	}
+	return nil
+}
+
+// authenticateUser checks the BasicAuth username and password of an API
+// client against the users in the DB and returns the user.
+func authenticateUser(username, password string, app *App) (models.User, bool, error) {
+	key := authCacheKey(username, password)
+	if u, ok := app.authCache.get(key); ok {
+		return u, true, nil
+	}
+
+	var u models.User
+	if err := app.queries.GetUserByUsername.Get(&u, username); err != nil {
+		if err != sql.ErrNoRows {
+			app.log.Errorf("error fetching user: %v", err)
+			return u, false, echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Error fetching user: %s", pqErrMsg(err)))
+		}
+	}
+
+	if !checkPassword(u, password) {
+		return u, false, nil
+	}
+
+	if _, err := app.queries.UpdateUserLogin.Exec(u.ID); err != nil {
+		app.log.Errorf("error updating user login: %v", err)
+	}
+	app.authCache.set(key, u)
+
+	return u, true, nil
+}
+
+// getSessionUser returns the authenticated user of a request.
+func getSessionUser(c echo.Context) models.User {
+	u, _ := c.Get("user").(models.User)
+	return u
+}
+
+// hashPassword returns the bcrypt hash of a password.
+// checkPassword returns whether password is the password of an enabled
+// user. Unknown users (zero IDs) and disabled users still go through
+// bcrypt so that the response time doesn't reveal which users exist.
+func checkPassword(u models.User, password string) bool {
+	hash := dummyPasswordHash
+	if u.ID != 0 {
+		hash = []byte(u.Password)
+	}
+	ok := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	return ok && u.ID != 0 && u.Status == models.UserStatusEnabled
+}
+
+func hashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func authCacheKey(username, password string) string {
+	h := sha256.Sum256([]byte(password))
+	return strings.ToLower(username) + ":" + hex.EncodeToString(h[:])
+}
+
+func newAuthCache() *authCache {
+	return &authCache{users: make(map[string]authCacheItem)}
+}
+
+func (a *authCache) get(key string) (models.User, bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	it, ok := a.users[key]
+	if !ok || time.Now().After(it.expiry) {
+		delete(a.users, key)
+		return models.User{}, false
+	}
+	return it.user, true
+}
+
+func (a *authCache) set(key string, u models.User) {
+	a.Lock()
+	a.users[key] = authCacheItem{user: u, expiry: time.Now().Add(authCacheTTL)}
+	a.Unlock()
+}
+
+// clear clears the cache so that changes to users take effect immediately.
+func (a *authCache) clear() {
+	a.Lock()
+	a.users = make(map[string]authCacheItem)
+	a.Unlock()
+}
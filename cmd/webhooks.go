@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/webhooks"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo"
+	"github.com/lib/pq"
+)
+
+// Kinds of webhooks that deliveries are recorded for.
+const (
+	hookKindWebhook = "webhook"
+	hookKindList    = "list"
+)
+
+const (
+	// Duration for which finished webhook deliveries are kept.
+	webhookDeliveryRetention = time.Hour * 24 * 30
+
+	// Interval at which old webhook deliveries are deleted.
+	webhookDeliveryPruneInterval = time.Hour
+)
+
+type webhookReq struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Secret  string   `json:"secret"`
+	Enabled bool     `json:"enabled"`
+}
+
+type webhookDeliveriesWrap struct {
+	Results []models.WebhookDelivery `json:"results"`
+
+	Total   int `json:"total"`
+	PerPage int `json:"per_page"`
+	Page    int `json:"page"`
+}
+
+// handleGetWebhooks handles retrieval of webhooks.
+func handleGetWebhooks(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		out   []models.Webhook
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if err := app.queries.GetWebhooks.Select(&out, id); err != nil {
+		getLogger(c).Errorf("error fetching webhooks: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching webhooks: %s", pqErrMsg(err)))
+	}
+
+	// Single webhook.
+	if id > 0 {
+		if len(out) == 0 {
+			return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Webhook not found.")
+		}
+		return c.JSON(http.StatusOK, okResp{out[0]})
+	}
+
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateWebhook handles the creation of a webhook.
+func handleCreateWebhook(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		o   = webhookReq{Enabled: true}
+	)
+
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o.Name = strings.TrimSpace(o.Name)
+	if err := validateWebhook(o); err != nil {
+		return err
+	}
+
+	var newID int
+	if err := app.queries.CreateWebhook.Get(&newID, o.Name, o.URL,
+		pq.StringArray(o.Events), o.Secret, o.Enabled); err != nil {
+		getLogger(c).Errorf("error creating webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error creating webhook: %s", pqErrMsg(err)))
+	}
+
+	// Hand over to the GET handler to return the last insertion.
+	return handleGetWebhooks(copyEchoCtx(c, map[string]string{
+		"id": fmt.Sprintf("%d", newID),
+	}))
+}
+
+// handleUpdateWebhook handles the modification of a webhook. An empty
+// secret retains the existing one.
+func handleUpdateWebhook(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	var o webhookReq
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o.Name = strings.TrimSpace(o.Name)
+	if err := validateWebhook(o); err != nil {
+		return err
+	}
+
+	res, err := app.queries.UpdateWebhook.Exec(id, o.Name, o.URL,
+		pq.StringArray(o.Events), o.Secret, o.Enabled)
+	if err != nil {
+		getLogger(c).Errorf("error updating webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error updating webhook: %s", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Webhook not found.")
+	}
+
+	return handleGetWebhooks(c)
+}
+
+// handleDeleteWebhook handles the deletion of a webhook along with its
+// deliveries.
+func handleDeleteWebhook(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	if _, err := app.queries.DeleteWebhook.Exec(id); err != nil {
+		getLogger(c).Errorf("error deleting webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error deleting webhook: %s", pqErrMsg(err)))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleGetWebhookDeliveries handles retrieval of the deliveries of webhooks
+// and list webhooks. Deliveries that failed after all their attempts have
+// the status `dead`.
+func handleGetWebhookDeliveries(c echo.Context) error {
+	var (
+		app           = c.Get("app").(*App)
+		pg            = getPagination(c.QueryParams(), 20, 50)
+		out           webhookDeliveriesWrap
+		hookID, _     = strconv.Atoi(c.FormValue("webhook_id"))
+		listHookID, _ = strconv.Atoi(c.FormValue("list_webhook_id"))
+		st            = c.FormValue("status")
+		event         = c.FormValue("event")
+	)
+
+	switch st {
+	case "", webhooks.StatusPending, webhooks.StatusSending, webhooks.StatusSuccess, webhooks.StatusDead:
+	default:
+		return newFieldError("status", "Invalid `status`.")
+	}
+
+	if err := app.queries.QueryWebhookDeliveries.Select(&out.Results, hookID, listHookID, st, event,
+		pg.Offset, pg.Limit); err != nil {
+		getLogger(c).Errorf("error fetching webhook deliveries: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error fetching webhook deliveries: %s", pqErrMsg(err)))
+	}
+	if len(out.Results) == 0 {
+		out.Results = []models.WebhookDelivery{}
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	out.Total = out.Results[0].Total
+	out.Page = pg.Page
+	out.PerPage = pg.PerPage
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleRetryWebhookDelivery handles the requeuing of a dead or finished
+// webhook delivery to be posted again.
+func handleRetryWebhookDelivery(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.ParseInt(c.Param("id"), 10, 64)
+	)
+
+	if id < 1 {
+		return newFieldError("id", "Invalid ID.")
+	}
+
+	res, err := app.queries.RetryWebhookDelivery.Exec(id)
+	if err != nil {
+		getLogger(c).Errorf("error retrying webhook delivery: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Error retrying webhook delivery: %s", pqErrMsg(err)))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newHTTPError(http.StatusBadRequest, errCodeNotFound, "Delivery not found or is still pending.")
+	}
+
+	app.webhooks.Notify()
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// validateWebhook validates a webhook's name, URL, and events.
+func validateWebhook(o webhookReq) error {
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return newFieldError("name", "Invalid length for `name`.")
+	}
+	return validateHook(o.URL, o.Events, o.Secret, webhooks.Events)
+}
+
+// validateHook validates the URL, events, and secret of a webhook or a
+// list webhook.
+func validateHook(hookURL string, events []string, secret string, allowed []string) error {
+	u, err := url.Parse(hookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newFieldError("url", "Invalid webhook URL.")
+	}
+
+	if len(events) == 0 {
+		return newFieldError("events", "Select at least one event.")
+	}
+	for _, e := range events {
+		if !strSliceContains(e, allowed) {
+			return newFieldError("events", fmt.Sprintf("Unknown webhook event: %s", e))
+		}
+	}
+
+	if !strHasLen(secret, 0, stdInputMaxLen) {
+		return newFieldError("secret", "Invalid length for the secret.")
+	}
+
+	return nil
+}
+
+// pushWebhooks queues an event for the webhooks that are subscribed to it.
+// Errors are only logged as webhooks shouldn't hold up the actions that
+// raise the events.
+func pushWebhooks(event string, data interface{}, app *App) {
+	var hooks []models.Webhook
+	if err := app.queries.GetWebhooksByEvent.Select(&hooks, event); err != nil {
+		app.log.Errorf("error fetching webhooks: %v", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	out := make([]webhooks.Hook, 0, len(hooks))
+	for _, h := range hooks {
+		out = append(out, webhooks.Hook{ID: h.ID, Kind: hookKindWebhook, URL: h.URL})
+	}
+	if err := app.webhooks.Push(out, event, data); err != nil {
+		app.log.Errorf("error queueing webhook: %v", err)
+	}
+}
+
+// pruneWebhookDeliveries is a blocking function that deletes finished
+// webhook deliveries older than the retention period at intervals.
+func pruneWebhookDeliveries(app *App) {
+	ticker := time.NewTicker(webhookDeliveryPruneInterval)
+	for ; true; <-ticker.C {
+		res, err := app.queries.DeleteWebhookDeliveries.Exec(time.Now().Add(-webhookDeliveryRetention))
+		if err != nil {
+			app.log.Errorf("error pruning webhook deliveries: %v", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			app.log.Infof("pruned %d old webhook deliveries", n)
+		}
+	}
+}
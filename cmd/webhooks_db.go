@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/knadh/listmonk/internal/webhooks"
+)
+
+// webhooksDB implements webhooks.Store over the primary database.
+type webhooksDB struct {
+	queries *Queries
+}
+
+func newWebhooksDB(q *Queries) *webhooksDB {
+	return &webhooksDB{
+		queries: q,
+	}
+}
+
+// CreateDelivery records a pending delivery of an event to a webhook or
+// a list webhook.
+func (w *webhooksDB) CreateDelivery(h webhooks.Hook, event string, payload []byte) error {
+	var hookID, listHookID int
+	if h.Kind == hookKindList {
+		listHookID = h.ID
+	} else {
+		hookID = h.ID
+	}
+
+	_, err := w.queries.CreateWebhookDelivery.Exec(hookID, listHookID, event, payload)
+	return err
+}
+
+// NextDeliveries retrieves a batch of due deliveries and marks them as
+// being sent.
+func (w *webhooksDB) NextDeliveries(limit int) ([]webhooks.Delivery, error) {
+	var out []webhooks.Delivery
+	err := w.queries.NextWebhookDeliveries.Select(&out, limit)
+	return out, err
+}
+
+// UpdateDelivery records the outcome of an attempt of a delivery.
+func (w *webhooksDB) UpdateDelivery(d webhooks.Delivery) error {
+	var next interface{}
+	if !d.NextAttemptAt.IsZero() {
+		next = d.NextAttemptAt
+	}
+
+	_, err := w.queries.UpdateWebhookDelivery.Exec(d.ID, d.Status, d.Attempts,
+		d.ResponseStatus, d.Response, next)
+	return err
+}
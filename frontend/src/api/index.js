@@ -16,11 +16,16 @@ const http = axios.create({
 });
 
 
-// Intercept requests to set the 'loading' state of a model.
+// Intercept requests to set the 'loading' state of a model and the
+// CSRF token of the session.
 http.interceptors.request.use((config) => {
   if ('loading' in config) {
     store.commit('setLoading', { model: config.loading, status: true });
   }
+  if (window.CONFIG && window.CONFIG.csrfToken) {
+    // eslint-disable-next-line no-param-reassign
+    config.headers['X-CSRF-Token'] = window.CONFIG.csrfToken;
+  }
   return config;
 }, (error) => Promise.reject(error));
 
@@ -55,6 +60,13 @@ http.interceptors.response.use((resp) => {
     store.commit('setLoading', { model: err.config.loading, status: false });
   }
 
+  // The session has expired. Log in again.
+  if (err.response && err.response.status === 401) {
+    const next = encodeURIComponent(window.location.pathname + window.location.search);
+    window.location.href = `/admin/login?next=${next}`;
+    return Promise.reject(err);
+  }
+
   let msg = '';
   if (err.response.data && err.response.data.message) {
     msg = err.response.data.message;
@@ -83,6 +95,8 @@ export const getHealth = () => http.get('/api/health',
 
 export const reloadApp = () => http.post('/api/admin/reload');
 
+export const logout = () => http.post('/api/logout');
+
 // Dashboard
 export const getDashboardCounts = () => http.get('/api/dashboard/counts',
   { loading: models.dashboard });
@@ -0,0 +1,274 @@
+// Package blocks renders structured "blocks" documents, as produced by
+// a drag-and-drop editor, to e-mail friendly (table based) HTML.
+//
+// Text and URL values are inserted as-is, like in HTML bodies, so that
+// they can contain Go template expressions, eg: {{ TrackLink "..." }}.
+package blocks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Version is the version of the document format.
+const Version = 1
+
+// Block types.
+const (
+	TypeHeading = "heading"
+	TypeText    = "text"
+	TypeImage   = "image"
+	TypeButton  = "button"
+	TypeDivider = "divider"
+	TypeSpacer  = "spacer"
+	TypeHTML    = "html"
+	TypeColumns = "columns"
+
+	// TypeContent is the placeholder for the campaign body in templates.
+	TypeContent = "content"
+)
+
+const (
+	defaultWidth = 600
+	contentTag   = `{{ template "content" . }}`
+)
+
+var (
+	regexpColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|rgba?\([0-9., %]+\))$`)
+	regexpFont  = regexp.MustCompile(`^[a-zA-Z0-9 ,'-]+$`)
+)
+
+// Doc represents a blocks document.
+type Doc struct {
+	Version  int      `json:"version"`
+	Settings Settings `json:"settings"`
+	Blocks   []Block  `json:"blocks"`
+}
+
+// Settings are the document wide styles.
+type Settings struct {
+	Width      int    `json:"width"`
+	Background string `json:"background"`
+	FontFamily string `json:"font_family"`
+	TextColor  string `json:"text_color"`
+}
+
+// Block represents a single block in a document. The fields that are
+// applicable depend on the type of the block.
+type Block struct {
+	Type string `json:"type"`
+
+	// Text of headings and buttons, and the HTML of text and html blocks.
+	Text  string `json:"text"`
+	Level int    `json:"level"`
+
+	// Image source or button link, and the optional link of an image.
+	URL  string `json:"url"`
+	Link string `json:"link"`
+	Alt  string `json:"alt"`
+
+	Align      string `json:"align"`
+	Color      string `json:"color"`
+	Background string `json:"background"`
+	Height     int    `json:"height"`
+
+	// Blocks of each column in a columns block.
+	Columns [][]Block `json:"columns"`
+}
+
+// IsBlocks checks whether the given source is a blocks document.
+func IsBlocks(src string) bool {
+	src = strings.TrimSpace(src)
+	if !strings.HasPrefix(src, "{") {
+		return false
+	}
+
+	var d struct {
+		Blocks json.RawMessage `json:"blocks"`
+	}
+	if err := json.Unmarshal([]byte(src), &d); err != nil {
+		return false
+	}
+	return len(d.Blocks) > 0
+}
+
+// Render parses a JSON blocks document and renders it to HTML.
+func Render(src []byte) (string, error) {
+	var d Doc
+	if err := json.Unmarshal(src, &d); err != nil {
+		return "", fmt.Errorf("invalid blocks document: %v", err)
+	}
+	return d.Render()
+}
+
+// Render renders the document to HTML.
+func (d Doc) Render() (string, error) {
+	if d.Version != Version {
+		return "", fmt.Errorf("unsupported blocks document version: %d", d.Version)
+	}
+	if len(d.Blocks) == 0 {
+		return "", errors.New("blocks document has no blocks")
+	}
+
+	width := d.Settings.Width
+	if width <= 0 {
+		width = defaultWidth
+	}
+
+	var (
+		b     strings.Builder
+		outer = "width:100%;"
+		inner = fmt.Sprintf("width:100%%;max-width:%dpx;", width)
+	)
+	if c := color(d.Settings.Background); c != "" {
+		outer += "background-color:" + c + ";"
+	}
+	if f := d.Settings.FontFamily; f != "" && regexpFont.MatchString(f) {
+		inner += "font-family:" + f + ";"
+	}
+	if c := color(d.Settings.TextColor); c != "" {
+		inner += "color:" + c + ";"
+	}
+
+	fmt.Fprintf(&b, `<table role="presentation" width="100%%" cellpadding="0" cellspacing="0" border="0" style="%s"><tr><td align="center">`, outer)
+	fmt.Fprintf(&b, `<table role="presentation" width="%d" cellpadding="0" cellspacing="0" border="0" style="%s">`, width, inner)
+	for i, bl := range d.Blocks {
+		b.WriteString("<tr>")
+		if err := renderCell(&b, bl, true); err != nil {
+			return "", fmt.Errorf("block %d: %v", i+1, err)
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</table></td></tr></table>")
+
+	return b.String(), nil
+}
+
+// renderCell renders a block in a table cell. Columns can't be nested.
+func renderCell(b *strings.Builder, bl Block, allowCols bool) error {
+	style := "padding:10px 20px;"
+	if c := color(bl.Background); c != "" && bl.Type != TypeButton {
+		style += "background-color:" + c + ";"
+	}
+
+	fmt.Fprintf(b, `<td align="%s" valign="top" style="%s">`, align(bl.Align), style)
+	if err := renderBlock(b, bl, allowCols); err != nil {
+		return err
+	}
+	b.WriteString("</td>")
+	return nil
+}
+
+func renderBlock(b *strings.Builder, bl Block, allowCols bool) error {
+	textStyle := ""
+	if c := color(bl.Color); c != "" {
+		textStyle = "color:" + c + ";"
+	}
+
+	switch bl.Type {
+	case TypeHeading:
+		lvl := bl.Level
+		if lvl < 1 || lvl > 4 {
+			lvl = 2
+		}
+		fmt.Fprintf(b, `<h%d style="margin:0;%s">%s</h%d>`, lvl, textStyle, bl.Text, lvl)
+
+	case TypeText:
+		fmt.Fprintf(b, `<div style="%s">%s</div>`, textStyle, bl.Text)
+
+	case TypeHTML:
+		b.WriteString(bl.Text)
+
+	case TypeImage:
+		if bl.URL == "" {
+			return errors.New("image has no url")
+		}
+		img := fmt.Sprintf(`<img src="%s" alt="%s" style="display:block;max-width:100%%;height:auto;border:0;" />`,
+			bl.URL, html.EscapeString(bl.Alt))
+		if bl.Link != "" {
+			img = fmt.Sprintf(`<a href="%s">%s</a>`, bl.Link, img)
+		}
+		b.WriteString(img)
+
+	case TypeButton:
+		if bl.URL == "" {
+			return errors.New("button has no url")
+		}
+		bg := color(bl.Background)
+		if bg == "" {
+			bg = "#0055d4"
+		}
+		fg := color(bl.Color)
+		if fg == "" {
+			fg = "#ffffff"
+		}
+		fmt.Fprintf(b, `<a href="%s" style="display:inline-block;padding:10px 24px;border-radius:3px;text-decoration:none;background-color:%s;color:%s;">%s</a>`,
+			bl.URL, bg, fg, bl.Text)
+
+	case TypeDivider:
+		c := color(bl.Color)
+		if c == "" {
+			c = "#eeeeee"
+		}
+		fmt.Fprintf(b, `<hr style="border:0;border-top:1px solid %s;margin:0;" />`, c)
+
+	case TypeSpacer:
+		h := bl.Height
+		if h <= 0 {
+			h = 20
+		}
+		fmt.Fprintf(b, `<div style="height:%dpx;line-height:%dpx;font-size:1px;">&nbsp;</div>`, h, h)
+
+	case TypeContent:
+		b.WriteString(contentTag)
+
+	case TypeColumns:
+		if !allowCols {
+			return errors.New("columns can't be nested")
+		}
+		if len(bl.Columns) == 0 {
+			return errors.New("columns block has no columns")
+		}
+
+		w := fmt.Sprintf("%d%%", 100/len(bl.Columns))
+		b.WriteString(`<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0"><tr>`)
+		for _, col := range bl.Columns {
+			fmt.Fprintf(b, `<td valign="top" style="width:%s;">`, w)
+			b.WriteString(`<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0">`)
+			for _, cb := range col {
+				b.WriteString("<tr>")
+				if err := renderCell(b, cb, false); err != nil {
+					return err
+				}
+				b.WriteString("</tr>")
+			}
+			b.WriteString("</table></td>")
+		}
+		b.WriteString("</tr></table>")
+
+	default:
+		return fmt.Errorf("unknown block type: %s", bl.Type)
+	}
+
+	return nil
+}
+
+func align(a string) string {
+	switch a {
+	case "center", "right":
+		return a
+	}
+	return "left"
+}
+
+// color returns the given CSS color if it's valid, or an empty string.
+func color(c string) string {
+	if c == "" || !regexpColor.MatchString(c) {
+		return ""
+	}
+	return c
+}
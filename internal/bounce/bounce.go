@@ -0,0 +1,233 @@
+// Package bounce processes bounces and complaints from mailboxes and
+// records them against subscribers.
+package bounce
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/logger"
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// Maximum number of messages fetched from a mailbox in one scan.
+	scanLimit = 200
+
+	defaultScanInterval = time.Minute * 15
+)
+
+// Opt represents bounce processing options.
+type Opt struct {
+	Mailboxes []mailbox.Opt
+
+	// User defined rules for classifying bounces. They're applied
+	// before the default rules.
+	Rules []Rule
+
+	// Maximum number of pending bounces. Bounces recorded when the
+	// queue is full are rejected.
+	QueueSize int
+
+	// Enable processing of notifications from e-mail services.
+	SESEnabled      bool
+	SendGridEnabled bool
+	MailgunEnabled  bool
+
+	// ARNs of the SNS topics that SES notifications are accepted from.
+	SESTopicArns []string
+
+	// Verification key of the SendGrid signed event webhook.
+	SendGridKey string
+
+	// Webhook signing key of the Mailgun account.
+	MailgunKey string
+
+	// VERP optionally matches the custom VERP return addresses of
+	// campaign messages in bounces from mailboxes.
+	VERP *VERP
+
+	// RecordBounceCB is called with every incoming bounce to record it.
+	RecordBounceCB func(models.Bounce) error
+}
+
+// Manager scans bounce mailboxes at intervals and records the bounces in
+// them, and bounces that are submitted to it by e-mail service webhooks,
+// with the record callback.
+type Manager struct {
+	// Notification processors of the enabled e-mail services.
+	SES      *SES
+	SendGrid *SendGrid
+	Mailgun  *Mailgun
+
+	opt   Opt
+	cls   *Classifier
+	queue chan models.Bounce
+	quit  chan bool
+	log   *logger.Logger
+
+	// Scanners and the record worker are waited on separately so that
+	// the queue is closed only after the scanners have stopped.
+	scanWg sync.WaitGroup
+	wg     sync.WaitGroup
+}
+
+// New returns a new instance of the bounce Manager.
+func New(o Opt, l *logger.Logger) (*Manager, error) {
+	if o.RecordBounceCB == nil {
+		return nil, errors.New("no bounce record callback")
+	}
+	if o.QueueSize < 1 {
+		o.QueueSize = 1000
+	}
+
+	// Validate the mailboxes.
+	for i, mb := range o.Mailboxes {
+		if _, err := mailbox.New(mb); err != nil {
+			return nil, err
+		}
+		if mb.ScanInterval < time.Minute {
+			o.Mailboxes[i].ScanInterval = defaultScanInterval
+		}
+	}
+
+	cls, err := NewClassifier(o.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		opt:   o,
+		cls:   cls,
+		queue: make(chan models.Bounce, o.QueueSize),
+		quit:  make(chan bool),
+		log:   l,
+	}
+	if o.SESEnabled {
+		m.SES = NewSES(o.SESTopicArns)
+	}
+	if o.SendGridEnabled {
+		sg, err := NewSendGrid(o.SendGridKey)
+		if err != nil {
+			return nil, err
+		}
+		m.SendGrid = sg
+	}
+	if o.MailgunEnabled {
+		mg, err := NewMailgun(o.MailgunKey)
+		if err != nil {
+			return nil, err
+		}
+		m.Mailgun = mg
+	}
+
+	return m, nil
+}
+
+// Run starts the mailbox scanners and the worker that records queued
+// bounces. It blocks until the manager is closed.
+func (m *Manager) Run() {
+	for _, mb := range m.opt.Mailboxes {
+		m.scanWg.Add(1)
+		go m.runScanner(mb)
+	}
+
+	m.wg.Add(1)
+	go m.worker()
+	m.wg.Wait()
+}
+
+// Record queues a bounce to be recorded. It doesn't block, and if the
+// queue is full, the bounce is rejected.
+func (m *Manager) Record(b models.Bounce) error {
+	select {
+	case m.queue <- b:
+	default:
+		return errors.New("bounce queue is full")
+	}
+	return nil
+}
+
+// Close stops the mailbox scanners and waits for the queued bounces
+// to be recorded.
+func (m *Manager) Close() {
+	close(m.quit)
+	m.scanWg.Wait()
+
+	close(m.queue)
+	m.wg.Wait()
+}
+
+func (m *Manager) worker() {
+	defer m.wg.Done()
+
+	for b := range m.queue {
+		b.Type = m.cls.Classify(b)
+		if err := m.opt.RecordBounceCB(b); err != nil {
+			m.log.Errorf("error recording bounce (%s): %v", b.Source, err)
+		}
+	}
+}
+
+// runScanner scans a mailbox at its scan interval until the manager is closed.
+func (m *Manager) runScanner(o mailbox.Opt) {
+	defer m.scanWg.Done()
+
+	t := time.NewTicker(o.ScanInterval)
+	defer t.Stop()
+
+	for {
+		m.scan(o)
+
+		select {
+		case <-t.C:
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// scan fetches messages from a mailbox and queues the bounces in them.
+// Messages that are recognised as bounces, or that can be ignored, are
+// deleted from the mailbox.
+func (m *Manager) scan(o mailbox.Opt) {
+	s, err := mailbox.New(o)
+	if err != nil {
+		m.log.Errorf("error initializing bounce mailbox %s: %v", o.Host, err)
+		return
+	}
+
+	num := 0
+	err = s.Scan(scanLimit, func(raw []byte) bool {
+		// Skip the remaining messages if the manager's closing.
+		select {
+		case <-m.quit:
+			return false
+		default:
+		}
+
+		b, err := ParseMessage(raw, m.opt.VERP)
+		if err == ErrIgnore {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+
+		b.Source = o.Type
+		if err := m.Record(b); err != nil {
+			m.log.Errorf("error queuing bounce from mailbox %s: %v", o.Host, err)
+			return false
+		}
+		num++
+		return true
+	})
+	if err != nil {
+		m.log.Errorf("error scanning bounce mailbox %s: %v", o.Host, err)
+	}
+	if num > 0 {
+		m.log.Infof("processed %d bounce(s) from mailbox %s", num, o.Host)
+	}
+}
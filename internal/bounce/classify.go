@@ -0,0 +1,136 @@
+package bounce
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Rule classifies a bounce by its SMTP status and diagnostic text. A rule
+// matches when all its non-empty conditions match.
+type Rule struct {
+	// Class is the bounce type assigned by the rule.
+	Class string `json:"class"`
+
+	// Status is a prefix of the enhanced status code (eg: 5.1, 5.7.1) or the
+	// basic SMTP reply code (eg: 550).
+	Status string `json:"status"`
+
+	// Match is a regular expression that's matched against the diagnostic text.
+	Match string `json:"match"`
+}
+
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// Classifier classifies bounces with user defined rules followed by
+// the default rules.
+type Classifier struct {
+	rules []rule
+}
+
+// defaultRules are applied after the user rules. The order matters.
+var defaultRules = []Rule{
+	{Class: models.BounceTypeAutoReply, Match: `(?i)auto.?reply|automatic reply|out of (the )?office|on vacation|away from (the )?office`},
+
+	// Full mailboxes have permanent codes, but are usually temporary.
+	{Class: models.BounceTypeSoft, Match: `(?i)mailbox (is )?full|over ?quota|quota exceeded|insufficient (disk )?space`},
+	{Class: models.BounceTypeSoft, Status: "5.2.2"},
+
+	// Rejections by policy, reputation, or blocklists are about the sender,
+	// not the recipient address.
+	{Class: models.BounceTypeBlock, Match: `(?i)block(ed|list)|blacklist|spamhaus|barracuda|spamcop|listed (at|on|in)|reputation|rejected.*polic|policy.*reject|access denied|not allowed to send`},
+	{Class: models.BounceTypeBlock, Status: "5.7"},
+	{Class: models.BounceTypeBlock, Status: "554"},
+
+	{Class: models.BounceTypeHard, Match: `(?i)user unknown|unknown user|no such (user|mailbox|recipient)|does not exist|doesn't exist|invalid (recipient|mailbox|address)|address rejected|mailbox unavailable|recipient not found|account (has been )?disabled`},
+	{Class: models.BounceTypeHard, Status: "5.1"},
+	{Class: models.BounceTypeHard, Status: "5.0.0"},
+	{Class: models.BounceTypeHard, Status: "550"},
+	{Class: models.BounceTypeHard, Status: "551"},
+	{Class: models.BounceTypeHard, Status: "553"},
+
+	{Class: models.BounceTypeSoft, Status: "4"},
+}
+
+// reSMTPCode matches the basic SMTP reply code in diagnostic text.
+var reSMTPCode = regexp.MustCompile(`\b([245][0-9][0-9])\b`)
+
+// NewClassifier compiles the given user rules and returns a Classifier.
+func NewClassifier(rules []Rule) (*Classifier, error) {
+	c := &Classifier{}
+	for i, r := range append(append([]Rule{}, rules...), defaultRules...) {
+		switch r.Class {
+		case models.BounceTypeSoft, models.BounceTypeHard, models.BounceTypeBlock, models.BounceTypeAutoReply:
+		default:
+			return nil, fmt.Errorf("unknown class in bounce rule %d: %s", i+1, r.Class)
+		}
+
+		r.Status = strings.TrimSpace(r.Status)
+		if r.Status == "" && r.Match == "" {
+			return nil, fmt.Errorf("bounce rule %d has no status or match", i+1)
+		}
+
+		var re *regexp.Regexp
+		if r.Match != "" {
+			var err error
+			if re, err = regexp.Compile(r.Match); err != nil {
+				return nil, fmt.Errorf("invalid match in bounce rule %d: %v", i+1, err)
+			}
+		}
+		c.rules = append(c.rules, rule{Rule: r, re: re})
+	}
+
+	return c, nil
+}
+
+// Classify returns the class of a bounce by its status and diagnostic text
+// in the bounce meta. If no rule matches, the bounce's existing type is
+// returned. Complaints aren't classified.
+func (c *Classifier) Classify(b models.Bounce) string {
+	if b.Type == models.BounceTypeComplaint {
+		return b.Type
+	}
+
+	var meta bounceMeta
+	if len(b.Meta) > 0 {
+		json.Unmarshal(b.Meta, &meta)
+	}
+
+	var (
+		status = strings.TrimSpace(meta.Status)
+		diag   = meta.Diagnostic + " " + meta.Reason + " " + meta.Subject
+		code   = ""
+	)
+	if m := reSMTPCode.FindStringSubmatch(meta.Diagnostic); m != nil {
+		code = m[1]
+	} else if reSMTPCode.MatchString(status) {
+		code = status
+	}
+
+	for _, r := range c.rules {
+		if r.Status != "" && !matchStatus(r.Status, status) && !strings.HasPrefix(code, r.Status) {
+			continue
+		}
+		if r.re != nil && !r.re.MatchString(diag) {
+			continue
+		}
+		return r.Class
+	}
+
+	return b.Type
+}
+
+// matchStatus checks whether the enhanced status code s (eg: 5.1.1) begins
+// with the status prefix p (eg: 5.1) on a component boundary.
+func matchStatus(p, s string) bool {
+	if !strings.HasPrefix(s, p) {
+		return false
+	}
+	return len(s) == len(p) || s[len(p)] == '.'
+}
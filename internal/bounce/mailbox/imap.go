@@ -0,0 +1,167 @@
+package mailbox
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imap is a minimal IMAP4rev1 (RFC 3501) client that implements just
+// enough of the protocol to fetch and delete messages from a folder.
+type imap struct {
+	opt  Opt
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// Scan fetches messages with UID FETCH, flags the accepted ones as
+// \Deleted, and expunges them before logging out.
+func (m *imap) Scan(limit int, fn ScanFunc) error {
+	conn, err := dial(m.opt)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m.conn = conn
+	m.r = bufio.NewReader(conn)
+	m.tag = 0
+
+	// Greeting.
+	l, err := m.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(l, "* OK") && !strings.HasPrefix(l, "* PREAUTH") {
+		return fmt.Errorf("unexpected IMAP greeting: %s", l)
+	}
+
+	if _, _, err := m.cmd("LOGIN %s %s", quote(m.opt.Username), quote(m.opt.Password)); err != nil {
+		return fmt.Errorf("login failed: %v", err)
+	}
+	if _, _, err := m.cmd("SELECT %s", quote(m.opt.Folder)); err != nil {
+		return err
+	}
+
+	// Get the message UIDs.
+	lines, _, err := m.cmd("UID SEARCH ALL")
+	if err != nil {
+		return err
+	}
+	var uids []string
+	for _, l := range lines {
+		if strings.HasPrefix(l, "* SEARCH") {
+			uids = append(uids, strings.Fields(l[len("* SEARCH"):])...)
+		}
+	}
+	if limit > 0 && len(uids) > limit {
+		uids = uids[:limit]
+	}
+
+	deleted := 0
+	for _, uid := range uids {
+		// Extend the deadline for every message.
+		conn.SetDeadline(time.Now().Add(m.opt.Timeout))
+
+		_, lits, err := m.cmd("UID FETCH %s (BODY.PEEK[])", uid)
+		if err != nil {
+			return err
+		}
+		if len(lits) == 0 {
+			continue
+		}
+
+		if fn(lits[0]) {
+			if _, _, err := m.cmd(`UID STORE %s +FLAGS.SILENT (\Deleted)`, uid); err != nil {
+				return err
+			}
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		if _, _, err := m.cmd("EXPUNGE"); err != nil {
+			return err
+		}
+	}
+
+	_, _, err = m.cmd("LOGOUT")
+	return err
+}
+
+// cmd sends a tagged command and reads the response up to the tagged
+// completion line. It returns the untagged response lines and the
+// literals ({n} strings) sent by the server.
+func (m *imap) cmd(format string, args ...interface{}) ([]string, [][]byte, error) {
+	m.tag++
+	tag := "a" + strconv.Itoa(m.tag)
+	if _, err := io.WriteString(m.conn, tag+" "+fmt.Sprintf(format, args...)+"\r\n"); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		lines []string
+		lits  [][]byte
+	)
+	for {
+		l, err := m.readLine()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if strings.HasPrefix(l, tag+" ") {
+			status := l[len(tag)+1:]
+			if !strings.HasPrefix(status, "OK") {
+				return nil, nil, fmt.Errorf("IMAP error: %s", status)
+			}
+			return lines, lits, nil
+		}
+		lines = append(lines, l)
+
+		// The line ends with a literal. Read it. The rest of the response
+		// follows on the next line.
+		if n, ok := literalSize(l); ok {
+			b := make([]byte, n)
+			if _, err := io.ReadFull(m.r, b); err != nil {
+				return nil, nil, err
+			}
+			lits = append(lits, b)
+		}
+	}
+}
+
+func (m *imap) readLine() (string, error) {
+	l, err := m.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(l, "\r\n"), nil
+}
+
+// literalSize returns the size n of a literal if the line ends with {n}.
+func literalSize(l string) (int, bool) {
+	if !strings.HasSuffix(l, "}") {
+		return 0, false
+	}
+	i := strings.LastIndex(l, "{")
+	if i == -1 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(l[i+1 : len(l)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// quote returns s as an IMAP quoted string.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,106 @@
+// Package mailbox implements minimal POP3 and IMAP clients for fetching
+// and deleting messages from bounce mailboxes.
+package mailbox
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	TypePOP  = "pop"
+	TypeIMAP = "imap"
+
+	defaultTimeout = time.Second * 30
+)
+
+// Opt represents the connection options for a mailbox.
+type Opt struct {
+	Type          string        `json:"type"`
+	Host          string        `json:"host"`
+	Port          int           `json:"port"`
+	Username      string        `json:"username"`
+	Password      string        `json:"password"`
+	TLSEnabled    bool          `json:"tls_enabled"`
+	TLSSkipVerify bool          `json:"tls_skip_verify"`
+	ScanInterval  time.Duration `json:"scan_interval"`
+
+	// Folder to scan on IMAP servers. Defaults to INBOX.
+	Folder string `json:"folder"`
+
+	Timeout time.Duration `json:"-"`
+}
+
+// ScanFunc is called with the raw bytes of every fetched message. If it
+// returns true, the message is deleted from the mailbox.
+type ScanFunc func(raw []byte) bool
+
+// Scanner represents a mailbox that can be scanned for messages.
+type Scanner interface {
+	// Scan logs into the mailbox, fetches up to limit messages, calls fn
+	// with each one, deletes the messages fn accepts, and logs out.
+	Scan(limit int, fn ScanFunc) error
+}
+
+// New returns a Scanner for the given mailbox options.
+func New(o Opt) (Scanner, error) {
+	if o.Host == "" {
+		return nil, errors.New("invalid mailbox host")
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+	}
+
+	switch o.Type {
+	case TypePOP:
+		if o.Port == 0 {
+			o.Port = 110
+			if o.TLSEnabled {
+				o.Port = 995
+			}
+		}
+		return &pop{opt: o}, nil
+	case TypeIMAP:
+		if o.Port == 0 {
+			o.Port = 143
+			if o.TLSEnabled {
+				o.Port = 993
+			}
+		}
+		if o.Folder == "" {
+			o.Folder = "INBOX"
+		}
+		return &imap{opt: o}, nil
+	}
+
+	return nil, fmt.Errorf("unknown mailbox type: %s", o.Type)
+}
+
+// dial connects to the mailbox server with implicit TLS if it's enabled.
+// The returned connection has a deadline of the configured timeout.
+func dial(o Opt) (net.Conn, error) {
+	var (
+		addr = net.JoinHostPort(o.Host, fmt.Sprintf("%d", o.Port))
+		d    = &net.Dialer{Timeout: o.Timeout}
+
+		conn net.Conn
+		err  error
+	)
+	if o.TLSEnabled {
+		conn, err = tls.DialWithDialer(d, "tcp", addr, &tls.Config{
+			ServerName:         o.Host,
+			InsecureSkipVerify: o.TLSSkipVerify,
+		})
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(o.Timeout))
+	return conn, nil
+}
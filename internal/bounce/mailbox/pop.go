@@ -0,0 +1,103 @@
+package mailbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pop is a minimal POP3 (RFC 1939) client.
+type pop struct {
+	opt Opt
+	c   *textproto.Conn
+}
+
+// Scan fetches messages with RETR and marks the accepted ones with DELE.
+// The deletions are committed by the server on QUIT.
+func (p *pop) Scan(limit int, fn ScanFunc) error {
+	conn, err := dial(p.opt)
+	if err != nil {
+		return err
+	}
+	p.c = textproto.NewConn(conn)
+	defer p.c.Close()
+
+	// Greeting.
+	if _, err := p.readResp(); err != nil {
+		return err
+	}
+	if _, err := p.cmd("USER %s", p.opt.Username); err != nil {
+		return err
+	}
+	if _, err := p.cmd("PASS %s", p.opt.Password); err != nil {
+		return fmt.Errorf("login failed: %v", err)
+	}
+
+	// Get the message numbers.
+	if _, err := p.cmd("LIST"); err != nil {
+		return err
+	}
+	lines, err := p.c.ReadDotLines()
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for _, l := range lines {
+		f := strings.Fields(l)
+		if len(f) == 0 {
+			continue
+		}
+		id, err := strconv.Atoi(f[0])
+		if err != nil {
+			return fmt.Errorf("invalid LIST response: %s", l)
+		}
+		ids = append(ids, id)
+	}
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	for _, id := range ids {
+		// Extend the deadline for every message.
+		conn.SetDeadline(time.Now().Add(p.opt.Timeout))
+
+		if _, err := p.cmd("RETR %d", id); err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(p.c.DotReader())
+		if err != nil {
+			return err
+		}
+
+		if fn(b) {
+			if _, err := p.cmd("DELE %d", id); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = p.cmd("QUIT")
+	return err
+}
+
+// cmd sends a command and returns the text of a +OK response.
+func (p *pop) cmd(format string, args ...interface{}) (string, error) {
+	if err := p.c.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return p.readResp()
+}
+
+func (p *pop) readResp() (string, error) {
+	l, err := p.c.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(l, "+OK") {
+		return strings.TrimSpace(l[3:]), nil
+	}
+	return "", fmt.Errorf("POP3 error: %s", strings.TrimSpace(strings.TrimPrefix(l, "-ERR")))
+}
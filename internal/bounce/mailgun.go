@@ -0,0 +1,179 @@
+package bounce
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Webhooks with timestamps older than this are rejected to prevent replays.
+const mailgunMaxAge = time.Minute * 15
+
+// mgWebhook is a Mailgun webhook payload.
+type mgWebhook struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+
+	Event struct {
+		Event     string `json:"event"`
+		Severity  string `json:"severity"`
+		Recipient string `json:"recipient"`
+		Reason    string `json:"reason"`
+
+		DeliveryStatus struct {
+			Code        int    `json:"code"`
+			Message     string `json:"message"`
+			Description string `json:"description"`
+		} `json:"delivery-status"`
+
+		// Variables attached to the message (v:).
+		UserVars struct {
+			CampaignUUID   string `json:"campaign_uuid"`
+			SubscriberUUID string `json:"subscriber_uuid"`
+		} `json:"user-variables"`
+	} `json:"event-data"`
+}
+
+// Mailgun processes Mailgun signed webhooks.
+type Mailgun struct {
+	key []byte
+
+	// Tokens of the webhooks seen within the max age, mapped to the
+	// time they expire, to reject replays.
+	tokens map[string]time.Time
+	mut    sync.Mutex
+}
+
+// NewMailgun returns a new Mailgun webhook processor. key is the
+// webhook signing key of the Mailgun account.
+func NewMailgun(key string) (*Mailgun, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, errors.New("invalid Mailgun webhook signing key")
+	}
+
+	return &Mailgun{key: []byte(key), tokens: make(map[string]time.Time)}, nil
+}
+
+// ProcessBounce verifies a Mailgun webhook payload and returns the bounce
+// or complaint in it. Events other than failures and complaints return no
+// bounces.
+func (m *Mailgun) ProcessBounce(b []byte) ([]models.Bounce, error) {
+	var w mgWebhook
+	if err := json.Unmarshal(b, &w); err != nil {
+		return nil, fmt.Errorf("error parsing Mailgun webhook: %v", err)
+	}
+
+	if err := m.verify(w); err != nil {
+		return nil, err
+	}
+
+	e := w.Event
+	var typ string
+	switch e.Event {
+	case "failed":
+		typ = models.BounceTypeSoft
+		if e.Severity == "permanent" {
+			typ = models.BounceTypeHard
+		}
+	case "complained":
+		typ = models.BounceTypeComplaint
+	default:
+		return nil, nil
+	}
+
+	var status string
+	if e.DeliveryStatus.Code > 0 {
+		status = strconv.Itoa(e.DeliveryStatus.Code)
+	}
+	diag := e.DeliveryStatus.Description
+	if diag == "" {
+		diag = e.DeliveryStatus.Message
+	}
+
+	meta, _ := json.Marshal(bounceMeta{
+		Action:     e.Event,
+		Status:     status,
+		Diagnostic: diag,
+		Recipient:  e.Recipient,
+		Reason:     e.Reason,
+	})
+	bn := models.Bounce{
+		Type:           typ,
+		Source:         "mailgun",
+		Meta:           meta,
+		CampaignUUID:   e.UserVars.CampaignUUID,
+		SubscriberUUID: e.UserVars.SubscriberUUID,
+	}
+	if bn.SubscriberUUID == "" {
+		bn.Email = strings.ToLower(e.Recipient)
+	}
+
+	return []models.Bounce{bn}, nil
+}
+
+// verify verifies the HMAC-SHA256 signature of the webhook's
+// timestamp and token, and the age of the timestamp.
+func (m *Mailgun) verify(w mgWebhook) error {
+	s := w.Signature
+
+	ts, err := strconv.ParseInt(s.Timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid Mailgun webhook timestamp")
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > mailgunMaxAge || d < -mailgunMaxAge {
+		return errors.New("Mailgun webhook timestamp is too old")
+	}
+
+	sig, err := hex.DecodeString(s.Signature)
+	if err != nil {
+		return errors.New("invalid Mailgun webhook signature")
+	}
+
+	h := hmac.New(sha256.New, m.key)
+	h.Write([]byte(s.Timestamp + s.Token))
+	if !hmac.Equal(h.Sum(nil), sig) {
+		return errors.New("Mailgun webhook signature verification failed")
+	}
+
+	if !m.useToken(s.Token) {
+		return errors.New("Mailgun webhook token has already been used")
+	}
+
+	return nil
+}
+
+// useToken records a webhook token and returns false if it has already
+// been seen within the max age. Expired tokens are cleaned up as new
+// ones are recorded.
+func (m *Mailgun) useToken(token string) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	now := time.Now()
+	if exp, ok := m.tokens[token]; ok && now.Before(exp) {
+		return false
+	}
+
+	for t, exp := range m.tokens {
+		if !now.Before(exp) {
+			delete(m.tokens, t)
+		}
+	}
+
+	// Timestamps are accepted up to the max age in either direction.
+	m.tokens[token] = now.Add(mailgunMaxAge * 2)
+	return true
+}
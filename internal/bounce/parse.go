@@ -0,0 +1,267 @@
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const uuidExp = `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`
+
+var (
+	// Message-Id of outgoing campaign messages: <campUUID.subUUID@domain>.
+	reMessageID = regexp.MustCompile(`<(` + uuidExp + `)\.(` + uuidExp + `)@`)
+
+	// VERP return address: bounces+campUUID.subUUID@domain.
+	reVERP = regexp.MustCompile(`\+(` + uuidExp + `)\.(` + uuidExp + `)@`)
+
+	// Original headers quoted in the body of non-standard bounce messages.
+	reCampHeader = regexp.MustCompile(`(?im)^` + models.EmailHeaderCampaignUUID + `:\s*(` + uuidExp + `)`)
+	reSubHeader  = regexp.MustCompile(`(?im)^` + models.EmailHeaderSubscriberUUID + `:\s*(` + uuidExp + `)`)
+
+	// Senders of non-standard bounce messages.
+	reDaemon = regexp.MustCompile(`(?i)mailer-daemon|postmaster`)
+
+	// Subjects of auto-replies that don't have the Auto-Submitted header.
+	reAutoReplySubj = regexp.MustCompile(`(?i)^(auto.?reply|automatic reply|out of (the )?office)`)
+)
+
+var (
+	// ErrNotBounce is returned when a message isn't a bounce or a complaint,
+	// or when the subscriber it's meant for can't be identified.
+	ErrNotBounce = errors.New("not a bounce")
+
+	// ErrIgnore is returned for delivery status notifications that aren't
+	// failures, for instance, delays. They can be safely deleted.
+	ErrIgnore = errors.New("not a failure notification")
+)
+
+// bounceMeta is the meta information recorded with a bounce.
+type bounceMeta struct {
+	Action       string `json:"action,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Diagnostic   string `json:"diagnostic,omitempty"`
+	Recipient    string `json:"recipient,omitempty"`
+	FeedbackType string `json:"feedback_type,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Subject      string `json:"subject,omitempty"`
+}
+
+// ParseMessage parses a raw e-mail message that's a delivery status
+// notification (RFC 3464), an abuse feedback report (RFC 5965), or an
+// auto-reply and returns a Bounce. The campaign and subscriber are identified by the listmonk headers
+// or the Message-Id of the original message, or the VERP address the
+// notification was sent to (matched against v, if it's not nil, or the
+// default bounces+campUUID.subUUID@ form). As a last resort, the subscriber
+// is identified by the failed recipient's e-mail.
+func ParseMessage(raw []byte, v *VERP) (models.Bounce, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return models.Bounce{}, ErrNotBounce
+	}
+
+	var (
+		b    = models.Bounce{Type: models.BounceTypeHard}
+		meta = bounceMeta{Subject: msg.Header.Get("Subject")}
+		orig textproto.MIMEHeader
+	)
+
+	mType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if mType == "multipart/report" && params["boundary"] != "" {
+		mr := multipart.NewReader(msg.Body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return b, ErrNotBounce
+			}
+
+			body, err := readPart(p)
+			if err != nil {
+				return b, ErrNotBounce
+			}
+
+			pType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			switch pType {
+			case "message/delivery-status":
+				parseDeliveryStatus(body, &meta)
+			case "message/feedback-report":
+				parseFeedbackReport(body, &meta)
+				b.Type = models.BounceTypeComplaint
+			case "message/rfc822", "text/rfc822-headers":
+				orig = readHeaders(body)
+			}
+		}
+
+		// Reports that a message isn't spam aren't complaints.
+		if b.Type == models.BounceTypeComplaint && meta.FeedbackType == "not-spam" {
+			return b, ErrIgnore
+		}
+
+		if b.Type != models.BounceTypeComplaint {
+			switch {
+			case meta.Action == "" && meta.Status == "":
+				return b, ErrNotBounce
+			case meta.Action != "" && !strings.EqualFold(meta.Action, "failed"):
+				return b, ErrIgnore
+			case !strings.HasPrefix(meta.Status, "5"):
+				b.Type = models.BounceTypeSoft
+			}
+		}
+	} else if isAutoReply(msg.Header) {
+		// Auto-replies (vacation messages etc.) refer to the original message
+		// by its Message-Id.
+		b.Type = models.BounceTypeAutoReply
+		meta.Reason = models.BounceTypeAutoReply
+
+		orig = textproto.MIMEHeader{}
+		for _, h := range []string{"In-Reply-To", "References"} {
+			if m := reMessageID.FindString(msg.Header.Get(h)); m != "" {
+				orig.Set("Message-Id", m)
+				break
+			}
+		}
+		if a, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+			meta.Recipient = strings.ToLower(a.Address)
+		}
+	} else {
+		// Non-standard bounces from mail servers that don't send DSNs. The
+		// original headers are usually quoted in the body.
+		if !reDaemon.MatchString(msg.Header.Get("From")) {
+			return b, ErrNotBounce
+		}
+		body, err := ioutil.ReadAll(msg.Body)
+		if err != nil {
+			return b, ErrNotBounce
+		}
+
+		orig = textproto.MIMEHeader{}
+		if m := reCampHeader.FindSubmatch(body); m != nil {
+			orig.Set(models.EmailHeaderCampaignUUID, string(m[1]))
+		}
+		if m := reSubHeader.FindSubmatch(body); m != nil {
+			orig.Set(models.EmailHeaderSubscriberUUID, string(m[1]))
+		}
+		if m := reMessageID.Find(body); m != nil {
+			orig.Set("Message-Id", string(m))
+		}
+	}
+
+	// Identify the campaign and the subscriber.
+	if orig != nil {
+		b.CampaignUUID = orig.Get(models.EmailHeaderCampaignUUID)
+		b.SubscriberUUID = orig.Get(models.EmailHeaderSubscriberUUID)
+
+		if b.SubscriberUUID == "" {
+			if m := reMessageID.FindStringSubmatch(orig.Get("Message-Id")); m != nil {
+				b.CampaignUUID, b.SubscriberUUID = m[1], m[2]
+			}
+		}
+	}
+	if b.SubscriberUUID == "" {
+		for _, h := range []string{"To", "Delivered-To", "X-Original-To"} {
+			val := msg.Header.Get(h)
+			if v != nil {
+				if camp, sub, ok := v.Parse(val); ok {
+					b.CampaignUUID, b.SubscriberUUID = camp, sub
+					break
+				}
+			}
+			if m := reVERP.FindStringSubmatch(val); m != nil {
+				b.CampaignUUID, b.SubscriberUUID = m[1], m[2]
+				break
+			}
+		}
+	}
+	if b.SubscriberUUID == "" {
+		if meta.Recipient == "" {
+			return b, ErrNotBounce
+		}
+		b.Email = meta.Recipient
+	}
+
+	b.Meta, _ = json.Marshal(meta)
+	return b, nil
+}
+
+// isAutoReply checks whether a message is an auto-reply by its headers.
+func isAutoReply(h mail.Header) bool {
+	if strings.HasPrefix(strings.ToLower(h.Get("Auto-Submitted")), "auto-replied") {
+		return true
+	}
+	if h.Get("X-Autoreply") != "" || h.Get("X-Autorespond") != "" {
+		return true
+	}
+	return reAutoReplySubj.MatchString(h.Get("Subject"))
+}
+
+// readPart reads a MIME part's body, decoding it if it's base64 encoded.
+// Quoted-printable parts are decoded by the multipart reader.
+func readPart(p *multipart.Part) ([]byte, error) {
+	var r io.Reader = p
+	if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+		r = base64.NewDecoder(base64.StdEncoding, p)
+	}
+	return ioutil.ReadAll(r)
+}
+
+// readHeaders parses the header block at the beginning of b, ignoring any
+// errors from truncated headers.
+func readHeaders(b []byte) textproto.MIMEHeader {
+	tp := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(b), strings.NewReader("\r\n\r\n"))))
+	h, _ := tp.ReadMIMEHeader()
+	return h
+}
+
+// parseDeliveryStatus parses the per-message and per-recipient field blocks
+// of a message/delivery-status part. Only the first recipient is considered
+// as listmonk sends one message per recipient.
+func parseDeliveryStatus(b []byte, meta *bounceMeta) {
+	tp := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(b), strings.NewReader("\r\n\r\n"))))
+	for {
+		h, err := tp.ReadMIMEHeader()
+		if len(h) > 0 && h.Get("Action") != "" {
+			meta.Action = strings.ToLower(h.Get("Action"))
+			meta.Status = h.Get("Status")
+			meta.Diagnostic = h.Get("Diagnostic-Code")
+			meta.Recipient = parseAddrField(h.Get("Final-Recipient"))
+			if meta.Recipient == "" {
+				meta.Recipient = parseAddrField(h.Get("Original-Recipient"))
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseFeedbackReport parses the fields of a message/feedback-report part.
+func parseFeedbackReport(b []byte, meta *bounceMeta) {
+	h := readHeaders(b)
+	meta.FeedbackType = strings.ToLower(h.Get("Feedback-Type"))
+	meta.Recipient = strings.Trim(h.Get("Original-Rcpt-To"), "<> ")
+}
+
+// parseAddrField parses an address-type field (eg: rfc822; user@domain.com)
+// and returns the address.
+func parseAddrField(s string) string {
+	if i := strings.Index(s, ";"); i != -1 {
+		s = s[i+1:]
+	}
+	return strings.ToLower(strings.Trim(s, "<> "))
+}
@@ -0,0 +1,171 @@
+package bounce
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Webhooks with timestamps older than this are rejected to prevent replays.
+const sendgridMaxAge = time.Minute * 15
+
+// sgEvent is an event in a SendGrid event webhook payload. Custom args
+// attached to the message are top-level fields.
+type sgEvent struct {
+	Event  string `json:"event"`
+	Email  string `json:"email"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+
+	CampaignUUID   string `json:"campaign_uuid"`
+	SubscriberUUID string `json:"subscriber_uuid"`
+}
+
+// SendGrid processes SendGrid signed event webhooks.
+type SendGrid struct {
+	pubKey *ecdsa.PublicKey
+}
+
+// NewSendGrid returns a new SendGrid event processor. key is the base64
+// encoded ECDSA verification key of the signed event webhook.
+func NewSendGrid(key string) (*SendGrid, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding SendGrid verification key: %v", err)
+	}
+
+	k, err := x509.ParsePKIXPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SendGrid verification key: %v", err)
+	}
+	pub, ok := k.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("SendGrid verification key isn't an ECDSA key")
+	}
+
+	return &SendGrid{pubKey: pub}, nil
+}
+
+// ProcessBounce verifies a SendGrid event webhook payload with its signature
+// and timestamp headers and returns the bounces and complaints in it, and the
+// number of deliveries by campaign UUID.
+func (s *SendGrid) ProcessBounce(sig, ts string, b []byte) ([]models.Bounce, map[string]int, error) {
+	if err := s.verify(sig, ts, b); err != nil {
+		return nil, nil, err
+	}
+
+	var events []sgEvent
+	if err := json.Unmarshal(b, &events); err != nil {
+		return nil, nil, fmt.Errorf("error parsing SendGrid events: %v", err)
+	}
+
+	var (
+		out       []models.Bounce
+		delivered = make(map[string]int)
+	)
+	for _, e := range events {
+		var typ string
+		switch e.Event {
+		case "delivered":
+			if e.CampaignUUID != "" {
+				delivered[e.CampaignUUID]++
+			}
+			continue
+
+		case "bounce":
+			// Blocks are temporary rejections by the receiving server.
+			typ = models.BounceTypeHard
+			if e.Type == "blocked" {
+				typ = models.BounceTypeSoft
+			}
+
+		case "dropped":
+			// Messages are dropped when the address previously bounced or
+			// complained, or is invalid. Drops for other reasons, for
+			// instance, unsubscribes at SendGrid, aren't bounces.
+			switch {
+			case strings.Contains(e.Reason, "Bounced Address"), strings.Contains(e.Reason, "Invalid"):
+				typ = models.BounceTypeHard
+			case strings.Contains(e.Reason, "Spam Reporting Address"):
+				typ = models.BounceTypeComplaint
+			default:
+				continue
+			}
+
+		case "spamreport":
+			typ = models.BounceTypeComplaint
+
+		default:
+			continue
+		}
+
+		meta, _ := json.Marshal(bounceMeta{
+			Action:     e.Event,
+			Status:     e.Status,
+			Diagnostic: e.Reason,
+			Recipient:  e.Email,
+			Reason:     e.Type,
+		})
+		bn := models.Bounce{
+			Type:           typ,
+			Source:         "sendgrid",
+			Meta:           meta,
+			CampaignUUID:   e.CampaignUUID,
+			SubscriberUUID: e.SubscriberUUID,
+		}
+		if bn.SubscriberUUID == "" {
+			bn.Email = strings.ToLower(e.Email)
+		}
+		out = append(out, bn)
+	}
+
+	return out, delivered, nil
+}
+
+// verify verifies the ECDSA signature of the timestamp and the payload,
+// and the age of the timestamp.
+func (s *SendGrid) verify(sig, ts string, b []byte) error {
+	if sig == "" || ts == "" {
+		return errors.New("missing SendGrid signature")
+	}
+
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid SendGrid webhook timestamp")
+	}
+	if d := time.Since(time.Unix(t, 0)); d > sendgridMaxAge || d < -sendgridMaxAge {
+		return errors.New("SendGrid webhook timestamp is too old")
+	}
+
+	sb, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return errors.New("invalid SendGrid signature")
+	}
+	var rs struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sb, &rs); err != nil {
+		return errors.New("invalid SendGrid signature")
+	}
+
+	h := sha256.New()
+	h.Write([]byte(ts))
+	h.Write(b)
+	if !ecdsa.Verify(s.pubKey, h.Sum(nil), rs.R, rs.S) {
+		return errors.New("SendGrid signature verification failed")
+	}
+
+	return nil
+}
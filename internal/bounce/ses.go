@@ -0,0 +1,355 @@
+package bounce
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// SNS message types.
+const (
+	snsTypeSubscription   = "SubscriptionConfirmation"
+	snsTypeUnsubscription = "UnsubscribeConfirmation"
+	snsTypeNotification   = "Notification"
+)
+
+// SNS certificates and subscription URLs are only accepted from these hosts.
+var reSNSHost = regexp.MustCompile(`^sns\.[a-z0-9\-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsMsg is the envelope of an SNS HTTP(s) message.
+type snsMsg struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// sesNotif is an SES bounce or complaint notification in an SNS message.
+// Notifications from SES event publishing have eventType instead of
+// notificationType.
+type sesNotif struct {
+	NotifType string `json:"notificationType"`
+	EventType string `json:"eventType"`
+
+	Bounce struct {
+		BounceType    string `json:"bounceType"`
+		BounceSubType string `json:"bounceSubType"`
+		Recipients    []struct {
+			Email      string `json:"emailAddress"`
+			Action     string `json:"action"`
+			Status     string `json:"status"`
+			Diagnostic string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+
+	Complaint struct {
+		FeedbackType string `json:"complaintFeedbackType"`
+		Recipients   []struct {
+			Email string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+
+	Mail struct {
+		MessageID string `json:"messageId"`
+		Headers   []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"headers"`
+	} `json:"mail"`
+}
+
+// SES processes Amazon SES bounce and complaint notifications
+// delivered by SNS.
+type SES struct {
+	c *http.Client
+
+	// ARNs of the SNS topics whose messages are accepted.
+	topics map[string]bool
+
+	certs map[string]*x509.Certificate
+	mut   sync.Mutex
+}
+
+// NewSES returns a new SES notification processor that accepts messages
+// from the given SNS topic ARNs.
+func NewSES(topicArns []string) *SES {
+	topics := make(map[string]bool, len(topicArns))
+	for _, t := range topicArns {
+		topics[strings.TrimSpace(t)] = true
+	}
+
+	return &SES{
+		c:      &http.Client{Timeout: time.Second * 10},
+		topics: topics,
+		certs:  make(map[string]*x509.Certificate),
+	}
+}
+
+// ProcessBounce verifies an SNS message and returns the bounces in it.
+// Subscription confirmations are confirmed by requesting the subscription
+// URL, and return no bounces.
+func (s *SES) ProcessBounce(b []byte) ([]models.Bounce, error) {
+	var m snsMsg
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("error parsing SNS message: %v", err)
+	}
+
+	if err := s.verify(m); err != nil {
+		return nil, err
+	}
+
+	// Messages, including subscription confirmations, are only accepted
+	// from the configured topics. Any AWS account can sign messages of its
+	// own topics.
+	if !s.topics[m.TopicArn] {
+		return nil, fmt.Errorf("SNS topic not allowed: %s", m.TopicArn)
+	}
+
+	switch m.Type {
+	case snsTypeSubscription:
+		return nil, s.confirm(m.SubscribeURL)
+	case snsTypeUnsubscription:
+		return nil, nil
+	case snsTypeNotification:
+	default:
+		return nil, fmt.Errorf("unknown SNS message type: %s", m.Type)
+	}
+
+	var n sesNotif
+	if err := json.Unmarshal([]byte(m.Message), &n); err != nil {
+		return nil, fmt.Errorf("error parsing SES notification: %v", err)
+	}
+	typ := n.NotifType
+	if typ == "" {
+		typ = n.EventType
+	}
+
+	// Campaign and subscriber headers, if SES is configured to include
+	// the original headers in notifications.
+	var campUUID, subUUID string
+	for _, h := range n.Mail.Headers {
+		switch {
+		case strings.EqualFold(h.Name, models.EmailHeaderCampaignUUID):
+			campUUID = h.Value
+		case strings.EqualFold(h.Name, models.EmailHeaderSubscriberUUID):
+			subUUID = h.Value
+		}
+	}
+
+	var out []models.Bounce
+	switch typ {
+	case "Bounce":
+		bType := models.BounceTypeSoft
+		if n.Bounce.BounceType == "Permanent" {
+			bType = models.BounceTypeHard
+		}
+
+		for _, r := range n.Bounce.Recipients {
+			meta, _ := json.Marshal(bounceMeta{
+				Action:     r.Action,
+				Status:     r.Status,
+				Diagnostic: r.Diagnostic,
+				Recipient:  r.Email,
+				Reason:     n.Bounce.BounceType + "/" + n.Bounce.BounceSubType,
+			})
+			out = append(out, s.makeBounce(bType, r.Email, campUUID, subUUID, meta))
+		}
+
+	case "Complaint":
+		for _, r := range n.Complaint.Recipients {
+			meta, _ := json.Marshal(bounceMeta{
+				Recipient:    r.Email,
+				FeedbackType: n.Complaint.FeedbackType,
+			})
+			out = append(out, s.makeBounce(models.BounceTypeComplaint, r.Email, campUUID, subUUID, meta))
+		}
+	}
+
+	return out, nil
+}
+
+func (s *SES) makeBounce(typ, email, campUUID, subUUID string, meta json.RawMessage) models.Bounce {
+	b := models.Bounce{
+		Type:         typ,
+		Source:       "ses",
+		Meta:         meta,
+		CampaignUUID: campUUID,
+	}
+
+	// The subscriber header applies only if the message had a single recipient,
+	// which is always the case with campaign messages.
+	if subUUID != "" {
+		b.SubscriberUUID = subUUID
+	} else {
+		b.Email = strings.ToLower(email)
+	}
+	return b
+}
+
+// verify verifies the signature of an SNS message.
+func (s *SES) verify(m snsMsg) error {
+	cert, err := s.getCert(m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	var (
+		h    hash.Hash
+		algo crypto.Hash
+	)
+	switch m.SignatureVersion {
+	case "1":
+		h, algo = sha1.New(), crypto.SHA1
+	case "2":
+		h, algo = sha256.New(), crypto.SHA256
+	default:
+		return fmt.Errorf("unknown SNS signature version: %s", m.SignatureVersion)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return errors.New("invalid SNS signature")
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("invalid SNS signing certificate")
+	}
+
+	io.WriteString(h, snsStringToSign(m))
+	if err := rsa.VerifyPKCS1v15(pub, algo, h.Sum(nil), sig); err != nil {
+		return errors.New("SNS signature verification failed")
+	}
+
+	return nil
+}
+
+// snsStringToSign returns the canonical string of an SNS message that's signed.
+// https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+func snsStringToSign(m snsMsg) string {
+	var (
+		sb  = strings.Builder{}
+		add = func(k, v string) {
+			sb.WriteString(k + "\n" + v + "\n")
+		}
+	)
+
+	add("Message", m.Message)
+	add("MessageId", m.MessageID)
+	if m.Type == snsTypeNotification {
+		if m.Subject != "" {
+			add("Subject", m.Subject)
+		}
+		add("Timestamp", m.Timestamp)
+		add("TopicArn", m.TopicArn)
+		add("Type", m.Type)
+		return sb.String()
+	}
+
+	add("SubscribeURL", m.SubscribeURL)
+	add("Timestamp", m.Timestamp)
+	add("Token", m.Token)
+	add("TopicArn", m.TopicArn)
+	add("Type", m.Type)
+	return sb.String()
+}
+
+// getCert fetches and caches the SNS signing certificate.
+func (s *SES) getCert(certURL string) (*x509.Certificate, error) {
+	if err := validateSNSURL(certURL); err != nil {
+		return nil, err
+	}
+
+	s.mut.Lock()
+	cert, ok := s.certs[certURL]
+	s.mut.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	resp, err := s.c.Get(certURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching SNS signing certificate: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching SNS signing certificate: %d", resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, fmt.Errorf("error reading SNS signing certificate: %v", err)
+	}
+
+	p, _ := pem.Decode(b)
+	if p == nil {
+		return nil, errors.New("invalid SNS signing certificate")
+	}
+	cert, err = x509.ParseCertificate(p.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SNS signing certificate: %v", err)
+	}
+
+	s.mut.Lock()
+	s.certs[certURL] = cert
+	s.mut.Unlock()
+
+	return cert, nil
+}
+
+// confirm confirms an SNS subscription by requesting its subscription URL.
+func (s *SES) confirm(subURL string) error {
+	if err := validateSNSURL(subURL); err != nil {
+		return err
+	}
+
+	resp, err := s.c.Get(subURL)
+	if err != nil {
+		return fmt.Errorf("error confirming SNS subscription: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error confirming SNS subscription: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// validateSNSURL checks that a URL is an HTTPS URL on an SNS host so that
+// arbitrary URLs in unverified messages aren't requested.
+func validateSNSURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "https" || !reSNSHost.MatchString(u.Hostname()) {
+		return fmt.Errorf("invalid SNS URL: %s", s)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package bounce
+
+import (
+	"errors"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+const (
+	verpCampaign   = "{campaign_uuid}"
+	verpSubscriber = "{subscriber_uuid}"
+
+	// DefaultVERPPattern is the default local part of VERP return addresses.
+	DefaultVERPPattern = "bounces+" + verpCampaign + "." + verpSubscriber
+)
+
+// VERP makes per-recipient VERP (Variable Envelope Return Path) addresses
+// from a pattern and parses the campaign and subscriber from them. The
+// pattern is the local part of the address with the {campaign_uuid} and
+// {subscriber_uuid} placeholders, eg: bounces+{campaign_uuid}.{subscriber_uuid}.
+type VERP struct {
+	pattern string
+	domain  string
+
+	re *regexp.Regexp
+
+	// Order of the campaign and subscriber submatches in re.
+	campIdx int
+	subIdx  int
+}
+
+// NewVERP returns a VERP with the given local part pattern and bounce
+// domain. If the domain is empty, the domain of the sender is used.
+func NewVERP(pattern, domain string) (*VERP, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		pattern = DefaultVERPPattern
+	}
+	if strings.Count(pattern, verpCampaign) != 1 || strings.Count(pattern, verpSubscriber) != 1 {
+		return nil, errors.New("VERP pattern should have {campaign_uuid} and {subscriber_uuid} once each")
+	}
+	if strings.ContainsAny(pattern, "@<> \t") {
+		return nil, errors.New("VERP pattern should be the local part of an address")
+	}
+
+	domain = strings.TrimPrefix(strings.TrimSpace(domain), "@")
+	if strings.ContainsAny(domain, "@<> \t") {
+		return nil, errors.New("invalid VERP domain")
+	}
+
+	v := &VERP{pattern: pattern, domain: domain, campIdx: 1, subIdx: 2}
+	if strings.Index(pattern, verpSubscriber) < strings.Index(pattern, verpCampaign) {
+		v.campIdx, v.subIdx = 2, 1
+	}
+
+	exp := regexp.QuoteMeta(pattern)
+	exp = strings.Replace(exp, regexp.QuoteMeta(verpCampaign), "("+uuidExp+")", 1)
+	exp = strings.Replace(exp, regexp.QuoteMeta(verpSubscriber), "("+uuidExp+")", 1)
+	v.re = regexp.MustCompile(`(?i)(?:^|[<\s,:])` + exp + `@`)
+
+	return v, nil
+}
+
+// Address returns the VERP address of a campaign message to a subscriber.
+// from is the message's From address whose domain is used if the VERP has
+// no domain. An empty string is returned if there's no domain.
+func (v *VERP) Address(campUUID, subUUID, from string) string {
+	domain := v.domain
+	if domain == "" {
+		a, err := mail.ParseAddress(from)
+		if err != nil {
+			return ""
+		}
+		i := strings.LastIndex(a.Address, "@")
+		if i < 0 {
+			return ""
+		}
+		domain = a.Address[i+1:]
+	}
+
+	local := strings.Replace(v.pattern, verpCampaign, campUUID, 1)
+	local = strings.Replace(local, verpSubscriber, subUUID, 1)
+	return local + "@" + domain
+}
+
+// Parse returns the campaign and subscriber UUIDs from a VERP address
+// (or a header with one).
+func (v *VERP) Parse(s string) (string, string, bool) {
+	m := v.re.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", false
+	}
+	return m[v.campIdx], m[v.subIdx], true
+}
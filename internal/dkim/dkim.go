@@ -0,0 +1,263 @@
+// Package dkim signs raw e-mail messages with DKIM (RFC 6376) using
+// relaxed/relaxed canonicalization and RSA-SHA256 or Ed25519-SHA256 keys.
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Headers that are signed if they're present in a message.
+var signHeaders = []string{
+	"from", "to", "cc", "subject", "date", "message-id", "reply-to",
+	"mime-version", "content-type", "content-transfer-encoding",
+	"list-unsubscribe", "list-unsubscribe-post",
+}
+
+var regexpWSP = regexp.MustCompile(`[ \t]+`)
+
+// Key is a DKIM signing key for a domain.
+type Key struct {
+	Domain     string `json:"domain"`
+	Selector   string `json:"selector"`
+	PrivateKey string `json:"private_key"`
+}
+
+// Signer signs messages with the key of the sender's domain.
+type Signer struct {
+	keys map[string]*signer
+}
+
+type signer struct {
+	domain   string
+	selector string
+	algo     string
+	key      crypto.Signer
+}
+
+// New returns a Signer with the given keys. Private keys are PEM encoded
+// PKCS#1 or PKCS#8 RSA keys, or PKCS#8 Ed25519 keys.
+func New(keys []Key) (*Signer, error) {
+	s := &Signer{keys: make(map[string]*signer, len(keys))}
+
+	for _, k := range keys {
+		domain := strings.ToLower(strings.TrimSpace(k.Domain))
+		if domain == "" || k.Selector == "" {
+			return nil, errors.New("DKIM domain and selector are required")
+		}
+
+		key, err := parseKey(k.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing DKIM key for %s: %v", domain, err)
+		}
+
+		sg := &signer{domain: domain, selector: k.Selector, key: key}
+		switch key.(type) {
+		case *rsa.PrivateKey:
+			sg.algo = "rsa-sha256"
+		case ed25519.PrivateKey:
+			sg.algo = "ed25519-sha256"
+		}
+		s.keys[domain] = sg
+	}
+
+	return s, nil
+}
+
+// Sign signs a raw message with the key of the domain in the From header
+// and returns the message with the DKIM-Signature header prepended. The
+// message is returned as-is if there's no key for the domain.
+func (s *Signer) Sign(msg []byte) ([]byte, error) {
+	msg = toCRLF(msg)
+
+	var (
+		hdr  []byte
+		body []byte
+	)
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		hdr, body = msg[:i+2], msg[i+4:]
+	} else {
+		hdr = msg
+	}
+	headers := parseHeaders(hdr)
+
+	// Pick the key by the From domain.
+	from, ok := lastHeader(headers, "from")
+	if !ok {
+		return nil, errors.New("no From header in message")
+	}
+	addr, err := mail.ParseAddress(strings.TrimSpace(from[strings.Index(from, ":")+1:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %v", err)
+	}
+	domain := strings.ToLower(addr.Address[strings.LastIndex(addr.Address, "@")+1:])
+
+	sg, ok := s.keys[domain]
+	if !ok {
+		return msg, nil
+	}
+
+	bh := sha256.Sum256(relaxedBody(body))
+
+	// The signed headers and their canonicalized forms.
+	var (
+		names  []string
+		hashed bytes.Buffer
+	)
+	for _, h := range signHeaders {
+		if v, ok := lastHeader(headers, h); ok {
+			names = append(names, h)
+			hashed.WriteString(relaxedHeader(v))
+			hashed.WriteString("\r\n")
+		}
+	}
+
+	sig := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		sg.algo, sg.domain, sg.selector, time.Now().Unix(),
+		strings.Join(names, ":"), base64.StdEncoding.EncodeToString(bh[:]))
+	hashed.WriteString(relaxedHeader(sig))
+
+	b, err := sg.sign(hashed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(sig)+len(b)+2+len(msg))
+	out = append(out, sig...)
+	out = append(out, b...)
+	out = append(out, "\r\n"...)
+	out = append(out, msg...)
+	return out, nil
+}
+
+// HasKey tells whether there's a key for the domain of an address.
+func (s *Signer) HasKey(addr string) bool {
+	a, err := mail.ParseAddress(addr)
+	if err != nil {
+		return false
+	}
+	_, ok := s.keys[strings.ToLower(a.Address[strings.LastIndex(a.Address, "@")+1:])]
+	return ok
+}
+
+func (sg *signer) sign(data []byte) (string, error) {
+	var (
+		sig []byte
+		err error
+	)
+	switch k := sg.key.(type) {
+	case ed25519.PrivateKey:
+		// Ed25519-SHA256 signs the SHA256 hash (RFC 8463).
+		h := sha256.Sum256(data)
+		sig = ed25519.Sign(k, h[:])
+	default:
+		h := sha256.Sum256(data)
+		sig, err = sg.key.Sign(rand.Reader, h[:], crypto.SHA256)
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// parseHeaders splits a header block into individual (unfolded) header
+// fields.
+func parseHeaders(b []byte) []string {
+	var out []string
+	for _, l := range strings.SplitAfter(string(b), "\r\n") {
+		if l == "" {
+			continue
+		}
+		if (l[0] == ' ' || l[0] == '\t') && len(out) > 0 {
+			out[len(out)-1] += l
+			continue
+		}
+		out = append(out, l)
+	}
+	for i, h := range out {
+		out[i] = strings.TrimSuffix(h, "\r\n")
+	}
+	return out
+}
+
+// lastHeader returns the last occurrence of a header field.
+func lastHeader(headers []string, name string) (string, bool) {
+	for i := len(headers) - 1; i >= 0; i-- {
+		h := headers[i]
+		n := strings.Index(h, ":")
+		if n > 0 && strings.EqualFold(strings.TrimSpace(h[:n]), name) {
+			return h, true
+		}
+	}
+	return "", false
+}
+
+// relaxedHeader canonicalizes a header field with the "relaxed" algorithm.
+func relaxedHeader(h string) string {
+	n := strings.Index(h, ":")
+	name := strings.ToLower(strings.TrimSpace(h[:n]))
+	val := strings.Replace(h[n+1:], "\r\n", "", -1)
+	val = strings.TrimSpace(regexpWSP.ReplaceAllString(val, " "))
+	return name + ":" + val
+}
+
+// relaxedBody canonicalizes a body with the "relaxed" algorithm.
+func relaxedBody(b []byte) []byte {
+	lines := strings.Split(string(b), "\r\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(regexpWSP.ReplaceAllString(l, " "), " ")
+	}
+
+	// Remove trailing empty lines.
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// toCRLF converts bare LF line endings to CRLF.
+func toCRLF(b []byte) []byte {
+	if !bytes.Contains(b, []byte("\n")) {
+		return b
+	}
+	b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(b, []byte("\n"), []byte("\r\n"), -1)
+}
+
+func parseKey(s string) (crypto.Signer, error) {
+	blk, _ := pem.Decode([]byte(s))
+	if blk == nil {
+		return nil, errors.New("invalid PEM key")
+	}
+
+	if k, err := x509.ParsePKCS1PrivateKey(blk.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch v := k.(type) {
+	case *rsa.PrivateKey:
+		return v, nil
+	case ed25519.PrivateKey:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", k)
+}
@@ -0,0 +1,200 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+// The message of the RFC 8463 Appendix A example.
+const testMsg = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game. Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+func TestRelaxedHeader(t *testing.T) {
+	// RFC 6376 3.4.5.
+	cases := []struct{ in, out string }{
+		{"A: X", "a:X"},
+		{"B : Y\t\r\n\tZ  ", "b:Y Z"},
+		{"Subject:  Is   dinner\tready?  ", "subject:Is dinner ready?"},
+	}
+	for _, c := range cases {
+		if got := relaxedHeader(c.in); got != c.out {
+			t.Errorf("%q: got %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+func TestRelaxedBody(t *testing.T) {
+	// RFC 6376 3.4.5.
+	cases := []struct{ in, out string }{
+		{" C \r\nD \t E\r\n\r\n\r\n", " C\r\nD E\r\n"},
+		{"", ""},
+		{"\r\n\r\n", ""},
+		{"a", "a\r\n"},
+	}
+	for _, c := range cases {
+		if got := string(relaxedBody([]byte(c.in))); got != c.out {
+			t.Errorf("%q: got %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name string
+		key  Key
+	}{
+		{"no domain", Key{Selector: "s", PrivateKey: rsaKeyPEM(t)}},
+		{"no selector", Key{Domain: "example.com", PrivateKey: rsaKeyPEM(t)}},
+		{"invalid key", Key{Domain: "example.com", Selector: "s", PrivateKey: "key"}},
+	}
+	for _, c := range cases {
+		if _, err := New([]Key{c.key}); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(edPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}))
+
+	rsaPEM := rsaKeyPEM(t)
+	rsaKey, _ := parseKey(rsaPEM)
+
+	cases := []struct {
+		name string
+		key  string
+		algo string
+		pub  crypto.PublicKey
+	}{
+		{"rsa", rsaPEM, "rsa-sha256", rsaKey.Public()},
+		{"ed25519", edPEM, "ed25519-sha256", edPub},
+	}
+
+	for _, c := range cases {
+		s, err := New([]Key{{Domain: "Football.example.com", Selector: "brisbane", PrivateKey: c.key}})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		out, err := s.Sign([]byte(testMsg))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !bytes.HasSuffix(out, []byte(testMsg)) {
+			t.Fatalf("%s: the message isn't preserved", c.name)
+		}
+
+		headers := parseHeaders(out[:bytes.Index(out, []byte("\r\n\r\n"))+2])
+		sig := headers[0]
+		tags := sigTags(sig)
+		for k, v := range map[string]string{
+			"a": c.algo,
+			"c": "relaxed/relaxed",
+			"d": "football.example.com",
+			"s": "brisbane",
+			"h": "from:to:subject:date:message-id",
+			// Body hash from RFC 8463 Appendix A.
+			"bh": "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=",
+		} {
+			if tags[k] != v {
+				t.Errorf("%s: %s=: got %q, want %q", c.name, k, tags[k], v)
+			}
+		}
+
+		// Verify the signature over the signed headers and the signature
+		// header without the value of b=.
+		var hashed bytes.Buffer
+		for _, h := range strings.Split(tags["h"], ":") {
+			v, _ := lastHeader(headers[1:], h)
+			hashed.WriteString(relaxedHeader(v) + "\r\n")
+		}
+		hashed.WriteString(relaxedHeader(strings.TrimSuffix(sig, tags["b"])))
+		h := sha256.Sum256(hashed.Bytes())
+
+		sb, err := base64.StdEncoding.DecodeString(tags["b"])
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		switch pub := c.pub.(type) {
+		case *rsa.PublicKey:
+			if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sb); err != nil {
+				t.Errorf("%s: invalid signature: %v", c.name, err)
+			}
+		case ed25519.PublicKey:
+			if !ed25519.Verify(pub, h[:], sb) {
+				t.Errorf("%s: invalid signature", c.name)
+			}
+		}
+	}
+}
+
+func TestSignOtherDomain(t *testing.T) {
+	s, err := New([]Key{{Domain: "example.com", Selector: "s", PrivateKey: rsaKeyPEM(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages of domains without keys are only converted to CRLF.
+	msg := strings.Replace(testMsg, "\r\n", "\n", -1)
+	out, err := s.Sign([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != testMsg {
+		t.Errorf("got %q", out)
+	}
+
+	if _, err := s.Sign([]byte("To: a@example.com\r\n\r\nbody")); err == nil {
+		t.Error("no From: expected an error")
+	}
+
+	if !s.HasKey("Joe <joe@Example.com>") || s.HasKey("joe@football.example.com") {
+		t.Error("HasKey: wrong result")
+	}
+}
+
+func rsaKeyPEM(t *testing.T) string {
+	k, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}))
+}
+
+// sigTags returns the tags of a DKIM-Signature header.
+func sigTags(h string) map[string]string {
+	out := make(map[string]string)
+	for _, t := range strings.Split(h[strings.Index(h, ":")+1:], ";") {
+		t = strings.Join(strings.Fields(t), "")
+		if i := strings.Index(t, "="); i > 0 {
+			out[t[:i]] = t[i+1:]
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+// Package events is an in-memory pub/sub hub that fans out application
+// events, such as campaign and import progress, to live subscribers.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	TypeCampaignProgress = "campaign.progress"
+	TypeCampaignStatus   = "campaign.status"
+	TypeImportProgress   = "import.progress"
+	TypeSubscription     = "subscription"
+	TypeError            = "error"
+)
+
+// Types is the list of all event types.
+var Types = []string{TypeCampaignProgress, TypeCampaignStatus, TypeImportProgress,
+	TypeSubscription, TypeError}
+
+// Event is a published event.
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+type sub struct {
+	ch    chan Event
+	types map[string]bool
+}
+
+// Hub fans out published events to subscribers.
+type Hub struct {
+	bufSize int
+	subs    map[*sub]struct{}
+	sync.RWMutex
+}
+
+// New returns a new Hub. bufSize is the number of events buffered for
+// each subscriber. Events to subscribers that fall behind are dropped.
+func New(bufSize int) *Hub {
+	return &Hub{
+		bufSize: bufSize,
+		subs:    make(map[*sub]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives the events of the given types
+// (all types if none are given) and a function that unsubscribes and
+// closes the channel.
+func (h *Hub) Subscribe(types []string) (<-chan Event, func()) {
+	s := &sub{ch: make(chan Event, h.bufSize)}
+	if len(types) > 0 {
+		s.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+
+	h.Lock()
+	h.subs[s] = struct{}{}
+	h.Unlock()
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			h.Lock()
+			delete(h.subs, s)
+			h.Unlock()
+			close(s.ch)
+		})
+	}
+}
+
+// Count returns the number of subscribers.
+func (h *Hub) Count() int {
+	h.RLock()
+	defer h.RUnlock()
+	return len(h.subs)
+}
+
+// Publish sends an event to the subscribers of its type. It doesn't block.
+func (h *Hub) Publish(typ string, data interface{}) {
+	h.RLock()
+	defer h.RUnlock()
+	if len(h.subs) == 0 {
+		return
+	}
+
+	e := Event{Type: typ, Timestamp: time.Now(), Data: data}
+	for s := range h.subs {
+		if s.types != nil && !s.types[typ] {
+			continue
+		}
+
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
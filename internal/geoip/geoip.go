@@ -0,0 +1,363 @@
+// Package geoip looks up the locations of IP addresses in local MaxMind DB
+// (MMDB) files such as GeoLite2-City and GeoLite2-Country. The whole file
+// is read into memory and lookups don't do any I/O.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// metaMarker marks the start of the metadata section at the end of the file.
+var metaMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Data types of the MMDB data section.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// DB is an opened MMDB file.
+type DB struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+
+	// Node at which IPv4 lookups start in IPv6 trees.
+	ipv4Start uint
+}
+
+// Location is the location of an IP.
+type Location struct {
+	// ISO 3166-1 code of the country, eg: US.
+	Country string `json:"country"`
+
+	// English name of the country's first level subdivision, eg: California.
+	Region string `json:"region"`
+}
+
+// Open reads and opens the MMDB file at the given path.
+func Open(path string) (*DB, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(b)
+}
+
+// New opens an MMDB file's bytes.
+func New(b []byte) (*DB, error) {
+	i := bytes.LastIndex(b, metaMarker)
+	if i < 0 {
+		return nil, errors.New("invalid MMDB file: metadata not found")
+	}
+	meta := b[i+len(metaMarker):]
+
+	v, _, err := decode(meta, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata: %v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MMDB metadata")
+	}
+
+	db := &DB{
+		buf:        b,
+		nodeCount:  toUint(m["node_count"]),
+		recordSize: toUint(m["record_size"]),
+		ipVersion:  toUint(m["ip_version"]),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported MMDB record size: %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported MMDB IP version: %d", db.ipVersion)
+	}
+
+	// The data section begins after the search tree and 16 null bytes.
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("invalid MMDB file: search tree is out of bounds")
+	}
+	db.data = b[treeSize+16 : i]
+
+	// IPv4 addresses are stored as ::a.b.c.d in IPv6 trees.
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for n := 0; n < 96 && node < db.nodeCount; n++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// Lookup returns the location of an IP. IPs that aren't in the DB
+// return an empty Location.
+func (db *DB) Lookup(ip net.IP) (Location, error) {
+	v, err := db.lookup(ip)
+	if err != nil || v == nil {
+		return Location{}, err
+	}
+
+	var (
+		out Location
+		m   = toMap(v)
+	)
+	if c := toMap(m["country"]); c != nil {
+		out.Country, _ = c["iso_code"].(string)
+	}
+	if subs, ok := m["subdivisions"].([]interface{}); ok && len(subs) > 0 {
+		out.Region, _ = toMap(toMap(subs[0])["names"])["en"].(string)
+	}
+	return out, nil
+}
+
+// lookup returns the decoded data record of an IP.
+func (db *DB) lookup(ip net.IP) (interface{}, error) {
+	var (
+		node = uint(0)
+		bits = ip.To4()
+	)
+	if bits != nil {
+		node = db.ipv4Start
+	} else {
+		if db.ipVersion == 4 {
+			return nil, nil
+		}
+		if bits = ip.To16(); bits == nil {
+			return nil, fmt.Errorf("invalid IP: %v", ip)
+		}
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+
+	// Not found.
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("invalid MMDB search tree")
+	}
+
+	off := node - db.nodeCount - 16
+	if off >= uint(len(db.data)) {
+		return nil, errors.New("invalid MMDB data pointer")
+	}
+	v, _, err := decode(db.data, off)
+	return v, err
+}
+
+// record returns the left (0) or the right (1) record of a node.
+func (db *DB) record(node, bit uint) uint {
+	b := db.buf[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// decode decodes the value at an offset in a data section and returns it
+// with the offset following it.
+func decode(data []byte, off uint) (interface{}, uint, error) {
+	if off >= uint(len(data)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	ctrl := data[off]
+	off++
+
+	typ := ctrl >> 5
+	if typ == typePointer {
+		p, next, err := decodePointer(data, ctrl, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := decode(data, p)
+		return v, next, err
+	}
+	if typ == typeExtended {
+		if off >= uint(len(data)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + data[off]
+		off++
+	}
+
+	size, off, err := decodeSize(data, ctrl, off)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := decode(data, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("invalid map key")
+			}
+			v, next, err := decode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := decode(data, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+
+	case typeBool:
+		return size != 0, off, nil
+
+	case typeContainer, typeEndMarker:
+		return nil, off, nil
+	}
+
+	if off+size > uint(len(data)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := data[off : off+size]
+	off += size
+
+	switch typ {
+	case typeString:
+		return string(b), off, nil
+	case typeBytes, typeUint128:
+		return b, off, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case typeUint16, typeUint32, typeUint64:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, nil
+	case typeInt32:
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), off, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type: %d", typ)
+}
+
+// decodePointer returns the offset a pointer points to and the offset
+// following the pointer.
+func decodePointer(data []byte, ctrl byte, off uint) (uint, uint, error) {
+	var (
+		size = uint(ctrl>>3)&0x3 + 1
+		p    = uint(ctrl & 0x7)
+	)
+	if off+size > uint(len(data)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	if size == 4 {
+		p = 0
+	}
+	for _, c := range data[off : off+size] {
+		p = p<<8 | uint(c)
+	}
+
+	switch size {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	return p, off + size, nil
+}
+
+// decodeSize returns the size of a value and the offset following it.
+func decodeSize(data []byte, ctrl byte, off uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, off, nil
+	}
+
+	n := size - 28
+	if off+n > uint(len(data)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	var v uint
+	for _, c := range data[off : off+n] {
+		v = v<<8 | uint(c)
+	}
+
+	switch size {
+	case 29:
+		return 29 + v, off + n, nil
+	case 30:
+		return 285 + v, off + n, nil
+	default:
+		return 65821 + v, off + n, nil
+	}
+}
+
+func toMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func toUint(v interface{}) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
@@ -0,0 +1,499 @@
+// Package graphql implements a minimal read-only GraphQL executor over
+// schemas defined with Go resolvers. It supports queries with aliases,
+// arguments, variables, fragments, and the @include and @skip directives,
+// but not mutations, subscriptions, or introspection beyond __typename.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Max depth of nested selections in a query.
+const maxDepth = 10
+
+// Object is an object type of a schema.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is a field of an object type.
+type Field struct {
+	// Type is the object type of the field's value, or of the items of the
+	// value if it's a slice. Fields without a type are scalars and their
+	// values are encoded as JSON as is.
+	Type *Object
+
+	// Resolve returns the value of the field. If it's nil, the value is
+	// read from the struct field or map key of the parent value that has
+	// the field's name as its JSON name.
+	Resolve ResolveFunc
+}
+
+// ResolveFunc resolves the value of a field.
+type ResolveFunc func(p Params) (interface{}, error)
+
+// Params are the params of a field resolution.
+type Params struct {
+	Context context.Context
+
+	// Source is the value of the parent object.
+	Source interface{}
+	Args   Args
+}
+
+// Args are the arguments of a field.
+type Args map[string]interface{}
+
+// Request is a GraphQL request.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Result is the result of a request.
+type Result struct {
+	Data   interface{} `json:"data"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// Error is an error of a request or of a field resolution.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// fieldMap is an ordered map of field results that preserves the order
+// of the selections in the JSON output.
+type fieldMap struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+type executor struct {
+	ctx    context.Context
+	doc    *document
+	vars   map[string]interface{}
+	errors []Error
+}
+
+// NewObject returns an object type with scalar fields for the JSON fields
+// of a struct value, including those of embedded structs.
+func NewObject(name string, v interface{}) *Object {
+	o := &Object{Name: name, Fields: make(map[string]*Field)}
+	for _, n := range jsonFields(reflect.TypeOf(v)) {
+		o.Fields[n] = &Field{}
+	}
+	return o
+}
+
+// Execute executes a query request against the query root type.
+func Execute(ctx context.Context, root *Object, req Request) *Result {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Result{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	// Pick the operation.
+	var op *operation
+	for _, o := range doc.operations {
+		if req.OperationName == "" || o.name == req.OperationName {
+			if op != nil {
+				return &Result{Errors: []Error{{Message: "operationName is required for documents with multiple operations"}}}
+			}
+			op = o
+		}
+	}
+	if op == nil {
+		return &Result{Errors: []Error{{Message: fmt.Sprintf("unknown operation %s", req.OperationName)}}}
+	}
+	if op.typ != "query" {
+		return &Result{Errors: []Error{{Message: fmt.Sprintf("%s operations are not supported", op.typ)}}}
+	}
+
+	// Variables.
+	vars := make(map[string]interface{})
+	for _, v := range op.vars {
+		val, ok := req.Variables[v.name]
+		if !ok && v.hasDefault {
+			val, ok = v.def, true
+		}
+		if v.nonNull && (!ok || val == nil) {
+			return &Result{Errors: []Error{{Message: fmt.Sprintf("variable $%s is required", v.name)}}}
+		}
+		vars[v.name] = normalize(val)
+	}
+
+	e := &executor{ctx: ctx, doc: doc, vars: vars}
+	if err := e.validate(root, op.selections, 0); err != nil {
+		return &Result{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	data := e.execObject(root, nil, op.selections, nil)
+	return &Result{Data: data, Errors: e.errors}
+}
+
+// Int returns an integer argument or def if it's not set.
+func (a Args) Int(key string, def int) int {
+	switch v := a[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return def
+}
+
+// String returns a string argument.
+func (a Args) String(key string) string {
+	switch v := a[key].(type) {
+	case string:
+		return v
+	case enum:
+		return string(v)
+	}
+	return ""
+}
+
+// Bool returns a boolean argument.
+func (a Args) Bool(key string) bool {
+	v, _ := a[key].(bool)
+	return v
+}
+
+// Strings returns a list argument of strings. A single string is
+// coerced into a list.
+func (a Args) Strings(key string) []string {
+	out := []string{}
+	switch v := a[key].(type) {
+	case []interface{}:
+		for _, i := range v {
+			switch s := i.(type) {
+			case string:
+				out = append(out, s)
+			case enum:
+				out = append(out, string(s))
+			}
+		}
+	case string, enum:
+		out = append(out, a.String(key))
+	}
+	return out
+}
+
+// MarshalJSON encodes the fields in order.
+func (f *fieldMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range f.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		val, err := json.Marshal(f.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// validate checks the selections of an object against its type.
+func (e *executor) validate(o *Object, sels []*selection, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("query is nested deeper than %d levels", maxDepth)
+	}
+
+	for _, s := range sels {
+		if s.spread != "" || s.inline {
+			f, err := e.fragmentSelections(s)
+			if err != nil {
+				return err
+			}
+			if err := e.validate(o, f, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if s.name == "__typename" {
+			continue
+		}
+
+		f, ok := o.Fields[s.name]
+		if !ok {
+			return fmt.Errorf("cannot query field %s on type %s", s.name, o.Name)
+		}
+		if f.Type == nil && len(s.selections) > 0 {
+			return fmt.Errorf("field %s of type %s can't have a selection", s.name, o.Name)
+		}
+		if f.Type != nil {
+			if len(s.selections) == 0 {
+				return fmt.Errorf("field %s of type %s should have a selection", s.name, o.Name)
+			}
+			if err := e.validate(f.Type, s.selections, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// execObject resolves the selected fields of an object value.
+func (e *executor) execObject(o *Object, src interface{}, sels []*selection, path []interface{}) *fieldMap {
+	out := &fieldMap{vals: make(map[string]interface{})}
+	e.collect(o, src, sels, path, out)
+	return out
+}
+
+func (e *executor) collect(o *Object, src interface{}, sels []*selection, path []interface{}, out *fieldMap) {
+	for _, s := range sels {
+		if !e.included(s) {
+			continue
+		}
+
+		if s.spread != "" || s.inline {
+			if s.on != "" && s.on != o.Name {
+				continue
+			}
+			if s.spread != "" && e.doc.fragments[s.spread].on != o.Name {
+				continue
+			}
+			f, _ := e.fragmentSelections(s)
+			e.collect(o, src, f, path, out)
+			continue
+		}
+
+		// Fields requested more than once are resolved once.
+		if _, ok := out.vals[s.alias]; ok {
+			continue
+		}
+		out.keys = append(out.keys, s.alias)
+
+		if s.name == "__typename" {
+			out.vals[s.alias] = o.Name
+			continue
+		}
+
+		fPath := append(append([]interface{}{}, path...), s.alias)
+		out.vals[s.alias] = e.execField(o.Fields[s.name], s, src, fPath)
+	}
+}
+
+// execField resolves a field and its selections.
+func (e *executor) execField(f *Field, s *selection, src interface{}, path []interface{}) interface{} {
+	var (
+		val interface{}
+		err error
+	)
+	if f.Resolve != nil {
+		args := make(Args, len(s.args))
+		for k, v := range s.args {
+			args[k] = e.value(v)
+		}
+		val, err = f.Resolve(Params{Context: e.ctx, Source: src, Args: args})
+	} else {
+		val = readField(src, s.name)
+	}
+	if err != nil {
+		e.errors = append(e.errors, Error{Message: err.Error(), Path: path})
+		return nil
+	}
+
+	if f.Type == nil || val == nil {
+		return val
+	}
+
+	// Lists of objects.
+	v := reflect.ValueOf(val)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		out := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			iPath := append(append([]interface{}{}, path...), i)
+			out[i] = e.execObject(f.Type, v.Index(i).Interface(), s.selections, iPath)
+		}
+		return out
+	}
+
+	return e.execObject(f.Type, val, s.selections, path)
+}
+
+// fragmentSelections returns the selections of a fragment spread or an
+// inline fragment.
+func (e *executor) fragmentSelections(s *selection) ([]*selection, error) {
+	if s.inline {
+		return s.selections, nil
+	}
+	f, ok := e.doc.fragments[s.spread]
+	if !ok {
+		return nil, fmt.Errorf("unknown fragment %s", s.spread)
+	}
+	return f.selections, nil
+}
+
+// included checks the @include and @skip directives of a selection.
+func (e *executor) included(s *selection) bool {
+	if d, ok := s.directives["include"]; ok {
+		if v, _ := e.value(d["if"]).(bool); !v {
+			return false
+		}
+	}
+	if d, ok := s.directives["skip"]; ok {
+		if v, _ := e.value(d["if"]).(bool); v {
+			return false
+		}
+	}
+	return true
+}
+
+// value substitutes the variables in an argument value.
+func (e *executor) value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case variable:
+		return e.vars[string(t)]
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, x := range t {
+			out[i] = e.value(x)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			out[k] = e.value(x)
+		}
+		return out
+	}
+	return v
+}
+
+// normalize converts whole numbers in JSON decoded variables to ints.
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case float64:
+		if t == float64(int(t)) {
+			return int(t)
+		}
+	case []interface{}:
+		for i, x := range t {
+			t[i] = normalize(x)
+		}
+	case map[string]interface{}:
+		for k, x := range t {
+			t[k] = normalize(x)
+		}
+	}
+	return v
+}
+
+// readField returns the value of a struct field or map key with the
+// given JSON name.
+func readField(src interface{}, name string) interface{} {
+	v := reflect.ValueOf(src)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		f := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if !f.IsValid() {
+			return nil
+		}
+		return f.Interface()
+
+	case reflect.Struct:
+		if f, ok := fieldByJSON(v, name); ok {
+			return f.Interface()
+		}
+	}
+	return nil
+}
+
+// fieldByJSON returns the field of a struct with the given JSON name.
+// Direct fields shadow the fields of embedded structs.
+func fieldByJSON(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+
+	var embedded []reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, v.Field(i))
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+
+	for _, e := range embedded {
+		if f, ok := fieldByJSON(e, name); ok {
+			return f, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// jsonFields returns the JSON field names of a struct type.
+func jsonFields(t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			out = append(out, jsonFields(f.Type)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		out = append(out, tag)
+	}
+	return out
+}
@@ -0,0 +1,425 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed GraphQL request document.
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	typ        string
+	name       string
+	vars       []*varDef
+	selections []*selection
+}
+
+type varDef struct {
+	name       string
+	nonNull    bool
+	def        interface{}
+	hasDefault bool
+}
+
+type fragment struct {
+	name       string
+	on         string
+	selections []*selection
+}
+
+// selection is a field, a fragment spread (spread), or an inline fragment
+// (on and selections without a name).
+type selection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	directives map[string]map[string]interface{}
+	selections []*selection
+
+	spread string
+	inline bool
+	on     string
+}
+
+// variable is a reference to a variable in a value.
+type variable string
+
+// enum is an enum value, which is passed to resolvers as a string.
+type enum string
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+// parse parses a GraphQL request document.
+func parse(src string) (doc *document, err error) {
+	p := &parser{src: src}
+
+	// Syntax errors are raised as panics by the parser and recovered here.
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(syntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	p.next()
+	doc = &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek(tokPunct, "{"):
+			doc.operations = append(doc.operations, &operation{typ: "query", selections: p.parseSelections()})
+		case p.peek(tokName, "query"), p.peek(tokName, "mutation"), p.peek(tokName, "subscription"):
+			doc.operations = append(doc.operations, p.parseOperation())
+		case p.peek(tokName, "fragment"):
+			f := p.parseFragment()
+			if _, ok := doc.fragments[f.name]; ok {
+				p.fail("duplicate fragment %s", f.name)
+			}
+			doc.fragments[f.name] = f
+		default:
+			p.fail("unexpected %s", p.tok.val)
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, syntaxError("no operations in the document")
+	}
+	return doc, nil
+}
+
+type syntaxError string
+
+func (e syntaxError) Error() string {
+	return "syntax error: " + string(e)
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(syntaxError(fmt.Sprintf(format, args...) + fmt.Sprintf(" at %d", p.tok.pos)))
+}
+
+func (p *parser) parseOperation() *operation {
+	op := &operation{typ: p.expect(tokName, "")}
+	if p.tok.kind == tokName {
+		op.name = p.expect(tokName, "")
+	}
+
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect(tokPunct, "$")
+			v := &varDef{name: p.expect(tokName, "")}
+			p.expect(tokPunct, ":")
+			v.nonNull = p.parseType()
+			if p.skip("=") {
+				v.def = p.parseValue(true)
+				v.hasDefault = true
+			}
+			op.vars = append(op.vars, v)
+		}
+	}
+	p.parseDirectives()
+
+	op.selections = p.parseSelections()
+	return op
+}
+
+// parseType parses a variable type and returns whether it's non-null.
+// Types aren't checked and values are coerced by resolvers.
+func (p *parser) parseType() bool {
+	if p.skip("[") {
+		p.parseType()
+		p.expect(tokPunct, "]")
+	} else {
+		p.expect(tokName, "")
+	}
+	return p.skip("!")
+}
+
+func (p *parser) parseFragment() *fragment {
+	p.expect(tokName, "fragment")
+	f := &fragment{name: p.expect(tokName, "")}
+	p.expect(tokName, "on")
+	f.on = p.expect(tokName, "")
+	p.parseDirectives()
+	f.selections = p.parseSelections()
+	return f
+}
+
+func (p *parser) parseSelections() []*selection {
+	p.expect(tokPunct, "{")
+
+	var out []*selection
+	for !p.skip("}") {
+		if p.skip("...") {
+			s := &selection{}
+			if p.peek(tokName, "on") {
+				p.next()
+				s.on = p.expect(tokName, "")
+			}
+			if p.tok.kind == tokName && s.on == "" {
+				s.spread = p.expect(tokName, "")
+				s.directives = p.parseDirectives()
+			} else {
+				s.inline = true
+				s.directives = p.parseDirectives()
+				s.selections = p.parseSelections()
+			}
+			out = append(out, s)
+			continue
+		}
+
+		s := &selection{name: p.expect(tokName, "")}
+		if p.skip(":") {
+			s.alias = s.name
+			s.name = p.expect(tokName, "")
+		} else {
+			s.alias = s.name
+		}
+		s.args = p.parseArgs()
+		s.directives = p.parseDirectives()
+		if p.peek(tokPunct, "{") {
+			s.selections = p.parseSelections()
+		}
+		out = append(out, s)
+	}
+
+	if len(out) == 0 {
+		p.fail("empty selection set")
+	}
+	return out
+}
+
+func (p *parser) parseArgs() map[string]interface{} {
+	args := make(map[string]interface{})
+	if !p.skip("(") {
+		return args
+	}
+	for !p.skip(")") {
+		name := p.expect(tokName, "")
+		p.expect(tokPunct, ":")
+		args[name] = p.parseValue(false)
+	}
+	return args
+}
+
+func (p *parser) parseDirectives() map[string]map[string]interface{} {
+	dirs := make(map[string]map[string]interface{})
+	for p.skip("@") {
+		name := p.expect(tokName, "")
+		dirs[name] = p.parseArgs()
+	}
+	return dirs
+}
+
+// parseValue parses a value. Constant values (variable defaults) can't
+// have variables.
+func (p *parser) parseValue(constant bool) interface{} {
+	t := p.tok
+	switch t.kind {
+	case tokInt:
+		p.next()
+		n, err := strconv.ParseInt(t.val, 10, 64)
+		if err != nil {
+			p.fail("invalid integer %s", t.val)
+		}
+		return int(n)
+	case tokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			p.fail("invalid number %s", t.val)
+		}
+		return f
+	case tokString:
+		p.next()
+		return t.val
+	case tokName:
+		p.next()
+		switch t.val {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enum(t.val)
+	}
+
+	switch {
+	case p.skip("$"):
+		if constant {
+			p.fail("unexpected variable")
+		}
+		return variable(p.expect(tokName, ""))
+	case p.skip("["):
+		out := []interface{}{}
+		for !p.skip("]") {
+			out = append(out, p.parseValue(constant))
+		}
+		return out
+	case p.skip("{"):
+		out := make(map[string]interface{})
+		for !p.skip("}") {
+			name := p.expect(tokName, "")
+			p.expect(tokPunct, ":")
+			out[name] = p.parseValue(constant)
+		}
+		return out
+	}
+
+	p.fail("unexpected %s", t.val)
+	return nil
+}
+
+// peek checks whether the current token is of the given kind and value.
+func (p *parser) peek(kind tokenKind, val string) bool {
+	return p.tok.kind == kind && p.tok.val == val
+}
+
+// skip skips the current token if it's the given punctuator.
+func (p *parser) skip(punct string) bool {
+	if p.peek(tokPunct, punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expect returns the value of the current token and advances if it's of
+// the given kind and value (any value if val is empty).
+func (p *parser) expect(kind tokenKind, val string) string {
+	if p.tok.kind != kind || (val != "" && p.tok.val != val) {
+		if p.tok.kind == tokEOF {
+			p.fail("unexpected end of document")
+		}
+		p.fail("unexpected %s", p.tok.val)
+	}
+	v := p.tok.val
+	p.next()
+	return v
+}
+
+// next reads the next token from the source.
+func (p *parser) next() {
+	// Skip whitespace, commas, and comments.
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = token{kind: tokPunct, val: "...", pos: start}
+
+	case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+		p.pos++
+		p.tok = token{kind: tokPunct, val: string(c), pos: start}
+
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokName, val: p.src[start:p.pos], pos: start}
+
+	case c == '-' || isDigit(c):
+		kind := tokInt
+		p.pos++
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == '.' || c == 'e' || c == 'E' || ((c == '+' || c == '-') && kind == tokFloat) {
+				kind = tokFloat
+			} else if !isDigit(c) {
+				break
+			}
+			p.pos++
+		}
+		p.tok = token{kind: kind, val: p.src[start:p.pos], pos: start}
+
+	case c == '"':
+		p.tok = token{kind: tokString, val: p.readString(), pos: start}
+
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.tok = token{kind: tokPunct, val: string(r), pos: start}
+		p.fail("unexpected character %q", r)
+	}
+}
+
+// readString reads a quoted string. Block strings aren't supported.
+func (p *parser) readString() string {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '\n':
+			p.fail("unterminated string")
+		case '"':
+			p.pos++
+			s, err := strconv.Unquote(p.src[start:p.pos])
+			if err != nil {
+				p.fail("invalid string")
+			}
+			return s
+		}
+		p.pos++
+	}
+	p.fail("unterminated string")
+	return ""
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,95 @@
+// Package linediff computes line diffs of texts.
+package linediff
+
+import "strings"
+
+// MaxLines is the maximum number of changed lines in each of the texts of
+// a diff. The LCS table of a diff takes the product of the numbers of
+// changed lines in memory.
+const MaxLines = 1000
+
+// Line is a line in a diff.
+type Line struct {
+	// Op is one of "=" (unchanged), "-" (removed) or "+" (added).
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff returns a line diff that turns a into b using the longest common
+// subsequence of their lines. The common lines at the start and the end
+// are skipped for the LCS, and if more than MaxLines lines remain in
+// either text, false is returned.
+func Diff(a, b string) ([]Line, bool) {
+	var (
+		al = strings.Split(a, "\n")
+		bl = strings.Split(b, "\n")
+	)
+
+	// Common prefix and suffix.
+	pre := 0
+	for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+		suf++
+	}
+
+	var (
+		am = al[pre : len(al)-suf]
+		bm = bl[pre : len(bl)-suf]
+	)
+	if len(am) > MaxLines || len(bm) > MaxLines {
+		return nil, false
+	}
+
+	// lcs[i][j] is the length of the LCS of am[i:] and bm[j:].
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	out := make([]Line, 0, len(al)+len(bm))
+	for _, l := range al[:pre] {
+		out = append(out, Line{Op: "=", Text: l})
+	}
+
+	i, j := 0, 0
+	for i < len(am) && j < len(bm) {
+		switch {
+		case am[i] == bm[j]:
+			out = append(out, Line{Op: "=", Text: am[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, Line{Op: "-", Text: am[i]})
+			i++
+		default:
+			out = append(out, Line{Op: "+", Text: bm[j]})
+			j++
+		}
+	}
+	for ; i < len(am); i++ {
+		out = append(out, Line{Op: "-", Text: am[i]})
+	}
+	for ; j < len(bm); j++ {
+		out = append(out, Line{Op: "+", Text: bm[j]})
+	}
+
+	for _, l := range al[len(al)-suf:] {
+		out = append(out, Line{Op: "=", Text: l})
+	}
+
+	return out, true
+}
@@ -0,0 +1,75 @@
+package linediff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+		out  string
+	}{
+		{"equal", "a\nb", "a\nb", "=a =b"},
+		{"empty", "", "", "="},
+		{"added", "a\nc", "a\nb\nc", "=a +b =c"},
+		{"removed", "a\nb\nc", "a\nc", "=a -b =c"},
+		{"changed", "a\nb\nc", "a\nx\nc", "=a -b +x =c"},
+		{"appended", "a\nb", "a\nb\nc", "=a =b +c"},
+		{"prepended", "b\nc", "a\nb\nc", "+a =b =c"},
+		{"replaced", "a\nb", "c\nd", "-a -b +c +d"},
+		{"moved", "a\nb\nc\nd", "b\nc\nd\na", "-a =b =c =d +a"},
+		{"repeated", "a\na\nb", "a\nb\nb", "=a -a +b =b"},
+	}
+
+	for _, c := range cases {
+		diff, ok := Diff(c.a, c.b)
+		if !ok {
+			t.Errorf("%s: diff refused", c.name)
+			continue
+		}
+
+		var out []string
+		for _, l := range diff {
+			out = append(out, l.Op+l.Text)
+		}
+		if got := strings.Join(out, " "); got != c.out {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.out)
+		}
+	}
+}
+
+func TestDiffMaxLines(t *testing.T) {
+	var (
+		long = strings.Repeat("x\n", MaxLines+1)
+		same = "head\n" + long + "tail"
+	)
+
+	// Unchanged lines don't count towards the limit.
+	diff, ok := Diff(same, same)
+	if !ok || len(diff) != MaxLines+3 {
+		t.Fatalf("unchanged text: got ok=%v with %d lines", ok, len(diff))
+	}
+
+	if _, ok := Diff("a", long); ok {
+		t.Fatal("expected the diff of too many changed lines to be refused")
+	}
+
+	// A small change between a large common prefix and suffix.
+	diff, ok = Diff("head\n"+long+"a\ntail", "head\n"+long+"b\ntail")
+	if !ok {
+		t.Fatal("diff of a small change refused")
+	}
+	var changed []Line
+	for _, l := range diff {
+		if l.Op != "=" {
+			changed = append(changed, l)
+		}
+	}
+	want := []Line{{Op: "-", Text: "a"}, {Op: "+", Text: "b"}}
+	if !reflect.DeepEqual(changed, want) {
+		t.Fatalf("got %v, want %v", changed, want)
+	}
+}
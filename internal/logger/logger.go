@@ -0,0 +1,226 @@
+// Package logger is a leveled logger that writes plain text or JSON lines
+// with structured context fields.
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level of a level name (debug, info, warn, error).
+func ParseLevel(s string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", s)
+}
+
+// out is the writer and the options shared by a logger and the loggers
+// derived from it with With().
+type out struct {
+	w      io.Writer
+	level  Level
+	format string
+	sync.Mutex
+}
+
+// Logger is a leveled, structured logger.
+type Logger struct {
+	out *out
+
+	// Context fields as key, value pairs.
+	fields []interface{}
+}
+
+// New returns a text logger that writes entries of the info level and
+// above to w.
+func New(w io.Writer) *Logger {
+	return &Logger{out: &out{w: w, level: LevelInfo, format: FormatText}}
+}
+
+// SetLevel sets the minimum level of the entries that are written. It
+// applies to all the loggers derived from the logger.
+func (l *Logger) SetLevel(lvl Level) {
+	l.out.Lock()
+	l.out.level = lvl
+	l.out.Unlock()
+}
+
+// SetFormat sets the format (text or json) of the entries. It applies to
+// all the loggers derived from the logger.
+func (l *Logger) SetFormat(f string) error {
+	if f != FormatText && f != FormatJSON {
+		return fmt.Errorf("unknown log format: %s", f)
+	}
+
+	l.out.Lock()
+	l.out.format = f
+	l.out.Unlock()
+	return nil
+}
+
+// With returns a logger that adds the given key, value pairs as fields to
+// its entries, eg: With("campaign_id", 1, "subscriber", "a@b.com").
+func (l *Logger) With(kv ...interface{}) *Logger {
+	f := make([]interface{}, 0, len(l.fields)+len(kv))
+	f = append(f, l.fields...)
+	f = append(f, kv...)
+	return &Logger{out: l.out, fields: f}
+}
+
+// Debugf logs a debug message.
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.output(2, LevelDebug, fmt.Sprintf(format, v...))
+}
+
+// Infof logs an info message.
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.output(2, LevelInfo, fmt.Sprintf(format, v...))
+}
+
+// Warnf logs a warning.
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.output(2, LevelWarn, fmt.Sprintf(format, v...))
+}
+
+// Errorf logs an error.
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.output(2, LevelError, fmt.Sprintf(format, v...))
+}
+
+// Fatalf logs an error and exits.
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.output(2, LevelError, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// Fatal logs an error with its operands formatted as fmt.Sprint does
+// and exits.
+func (l *Logger) Fatal(v ...interface{}) {
+	l.output(2, LevelError, fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+// output writes a log entry. calldepth is the number of stack frames to
+// skip to get to the caller whose file and line are logged.
+func (l *Logger) output(calldepth int, lvl Level, msg string) {
+	l.out.Lock()
+	defer l.out.Unlock()
+
+	if lvl < l.out.level {
+		return
+	}
+
+	caller := "???:0"
+	if _, file, line, ok := runtime.Caller(calldepth); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+
+	var (
+		now = time.Now()
+		b   bytes.Buffer
+	)
+	msg = strings.TrimSpace(msg)
+	if l.out.format == FormatJSON {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, now.Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSON(&b, lvl.String())
+		b.WriteString(`,"caller":`)
+		writeJSON(&b, caller)
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		for i := 0; i < len(l.fields); i += 2 {
+			b.WriteByte(',')
+			writeJSON(&b, fmt.Sprint(l.fields[i]))
+			b.WriteByte(':')
+			writeJSON(&b, fieldValue(l.fields, i))
+		}
+		b.WriteByte('}')
+	} else {
+		// The same prefix as the standard library's log.Ldate|log.Ltime|log.Lshortfile.
+		b.WriteString(now.Format("2006/01/02 15:04:05 "))
+		b.WriteString(caller)
+		b.WriteString(": ")
+		b.WriteString(strings.ToUpper(lvl.String()))
+		b.WriteByte(' ')
+		b.WriteString(msg)
+		for i := 0; i < len(l.fields); i += 2 {
+			b.WriteByte(' ')
+			b.WriteString(fmt.Sprint(l.fields[i]))
+			b.WriteByte('=')
+			b.WriteString(textValue(fieldValue(l.fields, i)))
+		}
+	}
+	b.WriteByte('\n')
+
+	// Log writers such as the UI's buffer expect a whole entry per write.
+	_, _ = l.out.w.Write(b.Bytes())
+}
+
+// fieldValue returns the value of the field key at fields[i]. Keys
+// without a value get an empty one.
+func fieldValue(fields []interface{}, i int) interface{} {
+	if i+1 >= len(fields) {
+		return ""
+	}
+	if err, ok := fields[i+1].(error); ok {
+		return err.Error()
+	}
+	return fields[i+1]
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		j, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(j)
+}
+
+// textValue formats a field value for text entries, quoting values with
+// spaces or quotes.
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
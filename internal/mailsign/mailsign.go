@@ -0,0 +1,215 @@
+// Package mailsign signs raw e-mail messages with S/MIME (RFC 8551) or
+// PGP/MIME (RFC 3156) by wrapping them in a multipart/signed entity with
+// a detached signature.
+package mailsign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+const (
+	TypeSMIME = "smime"
+	TypePGP   = "pgp"
+)
+
+// Key is a signing key of a sending identity. The identity is an e-mail
+// address or a domain, in which case, the key signs messages from all
+// the addresses on the domain that don't have their own keys.
+type Key struct {
+	Identity string `json:"identity"`
+	Type     string `json:"type"`
+
+	// Certificate is the PEM encoded S/MIME certificate, optionally followed
+	// by the intermediate certificates of its chain. Not applicable to PGP.
+	Certificate string `json:"certificate"`
+
+	// PrivateKey is the PEM encoded S/MIME private key or the ASCII armored
+	// PGP private key.
+	PrivateKey string `json:"private_key"`
+
+	// Passphrase optionally decrypts an encrypted PGP private key.
+	Passphrase string `json:"passphrase"`
+}
+
+// signer creates the detached signature of a MIME entity.
+type signer interface {
+	// protocol returns the protocol and micalg parameters of the
+	// multipart/signed Content-Type.
+	protocol() (string, string)
+
+	// sign returns the signature part of the multipart/signed message for
+	// the canonicalized entity.
+	sign(entity []byte) ([]byte, error)
+}
+
+// Signer signs messages with the key of the sender's address or domain.
+type Signer struct {
+	keys map[string]signer
+}
+
+// Headers that describe the content and are moved to the signed entity.
+var contentHeaders = map[string]bool{
+	"content-type":              true,
+	"content-transfer-encoding": true,
+	"content-disposition":       true,
+	"content-id":                true,
+	"content-description":       true,
+}
+
+// New returns a Signer with the given keys.
+func New(keys []Key) (*Signer, error) {
+	s := &Signer{keys: make(map[string]signer, len(keys))}
+
+	for _, k := range keys {
+		id := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(k.Identity), "@"))
+		if id == "" {
+			return nil, errors.New("message signing identity is required")
+		}
+
+		var (
+			sg  signer
+			err error
+		)
+		switch k.Type {
+		case TypeSMIME:
+			sg, err = newSMIME(k.Certificate, k.PrivateKey)
+		case TypePGP:
+			sg, err = newPGP(k.PrivateKey, k.Passphrase)
+		default:
+			return nil, fmt.Errorf("unknown message signing type for %s: %s", id, k.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s signing key for %s: %v", k.Type, id, err)
+		}
+		s.keys[id] = sg
+	}
+
+	return s, nil
+}
+
+// HasKey checks whether there's a key for the given From address.
+func (s *Signer) HasKey(from string) bool {
+	return s.getKey(from) != nil
+}
+
+// Sign signs a raw message with the key of the given From address and
+// returns the multipart/signed message. The message is returned as-is if
+// there's no key for the address.
+func (s *Signer) Sign(from string, msg []byte) ([]byte, error) {
+	sg := s.getKey(from)
+	if sg == nil {
+		return msg, nil
+	}
+
+	msg = toCRLF(msg)
+
+	var (
+		hdr  []byte
+		body []byte
+	)
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		hdr, body = msg[:i+2], msg[i+4:]
+	} else {
+		hdr = msg
+	}
+
+	// Split the headers into the message headers and the content headers
+	// of the entity that's signed.
+	var outer, entity bytes.Buffer
+	for _, h := range splitHeaders(hdr) {
+		name := strings.ToLower(strings.TrimSpace(h[:strings.Index(h+":", ":")]))
+		if contentHeaders[name] {
+			entity.WriteString(h)
+		} else {
+			outer.WriteString(h)
+		}
+	}
+	if entity.Len() == 0 {
+		entity.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	}
+	entity.WriteString("\r\n")
+	entity.Write(body)
+
+	sig, err := sg.sign(entity.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	var (
+		boundary      = hex.EncodeToString(b)
+		proto, micalg = sg.protocol()
+		out           bytes.Buffer
+	)
+	out.Write(outer.Bytes())
+	fmt.Fprintf(&out, "Content-Type: multipart/signed; protocol=\"%s\"; micalg=%s;\r\n boundary=\"%s\"\r\n\r\n",
+		proto, micalg, boundary)
+	fmt.Fprintf(&out, "--%s\r\n", boundary)
+	out.Write(entity.Bytes())
+	fmt.Fprintf(&out, "\r\n--%s\r\n", boundary)
+	out.Write(sig)
+	fmt.Fprintf(&out, "\r\n--%s--\r\n", boundary)
+
+	return out.Bytes(), nil
+}
+
+// getKey returns the key of an address or its domain.
+func (s *Signer) getKey(from string) signer {
+	a, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil
+	}
+	addr := strings.ToLower(a.Address)
+	if sg, ok := s.keys[addr]; ok {
+		return sg
+	}
+	return s.keys[addr[strings.LastIndex(addr, "@")+1:]]
+}
+
+// splitHeaders splits a header block into individual header fields with
+// their folded lines.
+func splitHeaders(b []byte) []string {
+	var out []string
+	for _, l := range strings.SplitAfter(string(b), "\r\n") {
+		if l == "" {
+			continue
+		}
+		if (l[0] == ' ' || l[0] == '\t') && len(out) > 0 {
+			out[len(out)-1] += l
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// toCRLF converts bare LF line endings to CRLF.
+func toCRLF(b []byte) []byte {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))
+}
+
+// encodeBase64 base64 encodes b in lines of 76 characters.
+func encodeBase64(b []byte) []byte {
+	var (
+		enc = []byte(base64.StdEncoding.EncodeToString(b))
+		out bytes.Buffer
+	)
+	for len(enc) > 76 {
+		out.Write(enc[:76])
+		out.WriteString("\r\n")
+		enc = enc[76:]
+	}
+	out.Write(enc)
+	return out.Bytes()
+}
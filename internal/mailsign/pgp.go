@@ -0,0 +1,70 @@
+package mailsign
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// pgp signs entities with a PGP private key.
+type pgp struct {
+	entity *openpgp.Entity
+	cfg    *packet.Config
+}
+
+func newPGP(armored, passphrase string) (*pgp, error) {
+	ents, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+
+	var ent *openpgp.Entity
+	for _, e := range ents {
+		if e.PrivateKey != nil {
+			ent = e
+			break
+		}
+	}
+	if ent == nil {
+		return nil, errors.New("no private key found")
+	}
+
+	// Decrypt the private key and subkeys.
+	if ent.PrivateKey.Encrypted {
+		if err := ent.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("error decrypting private key: %v", err)
+		}
+	}
+	for _, sk := range ent.Subkeys {
+		if sk.PrivateKey != nil && sk.PrivateKey.Encrypted {
+			if err := sk.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("error decrypting private subkey: %v", err)
+			}
+		}
+	}
+
+	return &pgp{entity: ent, cfg: &packet.Config{DefaultHash: crypto.SHA256}}, nil
+}
+
+func (p *pgp) protocol() (string, string) {
+	return "application/pgp-signature", "pgp-sha256"
+}
+
+func (p *pgp) sign(entity []byte) ([]byte, error) {
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, p.entity, bytes.NewReader(entity), p.cfg); err != nil {
+		return nil, fmt.Errorf("error PGP signing message: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n")
+	out.WriteString("Content-Description: OpenPGP digital signature\r\n")
+	out.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+	out.Write(toCRLF(sig.Bytes()))
+	return out.Bytes(), nil
+}
@@ -0,0 +1,238 @@
+package mailsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// CMS (RFC 5652) structures of a detached SignedData signature.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type encapContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// smime signs entities with an S/MIME certificate.
+type smime struct {
+	cert  *x509.Certificate
+	chain []*x509.Certificate
+	key   crypto.Signer
+}
+
+func newSMIME(certPEM, keyPEM string) (*smime, error) {
+	var (
+		s    = &smime{}
+		rest = []byte(certPEM)
+	)
+	for {
+		var b *pem.Block
+		b, rest = pem.Decode(rest)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if s.cert == nil {
+			s.cert = c
+		} else {
+			s.chain = append(s.chain, c)
+		}
+	}
+	if s.cert == nil {
+		return nil, errors.New("no certificate found")
+	}
+
+	b, _ := pem.Decode([]byte(keyPEM))
+	if b == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(b.Bytes); err == nil {
+		s.key = k
+	} else if k, err := x509.ParseECPrivateKey(b.Bytes); err == nil {
+		s.key = k
+	} else {
+		k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		sg, ok := k.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		s.key = sg
+	}
+
+	switch s.key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, errors.New("only RSA and ECDSA keys are supported")
+	}
+
+	return s, nil
+}
+
+func (s *smime) protocol() (string, string) {
+	return "application/pkcs7-signature", "sha-256"
+}
+
+func (s *smime) sign(entity []byte) ([]byte, error) {
+	sig, err := s.signCMS(entity)
+	if err != nil {
+		return nil, fmt.Errorf("error S/MIME signing message: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	out.WriteString("Content-Transfer-Encoding: base64\r\n")
+	out.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	out.Write(encodeBase64(sig))
+	return out.Bytes(), nil
+}
+
+// signCMS returns the DER encoded detached CMS SignedData signature of data.
+func (s *smime) signCMS(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	// Signed attributes.
+	attrs, err := marshalAttrs(
+		attrVal{typ: oidContentType, val: oidData},
+		attrVal{typ: oidSigningTime, val: time.Now().UTC()},
+		attrVal{typ: oidMessageDigest, val: digest[:]},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is over the DER SET OF the attributes and not their
+	// implicitly tagged form in the SignerInfo.
+	set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(set)
+	sig, err := s.key.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	sigAlgo := pkix.AlgorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}
+	if _, ok := s.key.(*ecdsa.PrivateKey); ok {
+		sigAlgo = pkix.AlgorithmIdentifier{Algorithm: oidECDSASHA256}
+	}
+
+	var certs []byte
+	for _, c := range append([]*x509.Certificate{s.cert}, s.chain...) {
+		certs = append(certs, c.Raw...)
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerial{Issuer: asn1.RawValue{FullBytes: s.cert.RawIssuer}, Serial: s.cert.SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: sigAlgo,
+			Signature:          sig,
+		}},
+	}
+	b, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b},
+	})
+}
+
+// attrVal is a signed attribute with a single value.
+type attrVal struct {
+	typ asn1.ObjectIdentifier
+	val interface{}
+}
+
+// marshalAttrs returns the concatenated DER encodings of the attributes
+// sorted as required for a DER SET OF.
+func marshalAttrs(vals ...attrVal) ([]byte, error) {
+	enc := make([][]byte, 0, len(vals))
+	for _, v := range vals {
+		b, err := asn1.Marshal(v.val)
+		if err != nil {
+			return nil, err
+		}
+		a, err := asn1.Marshal(attribute{Type: v.typ, Values: []asn1.RawValue{{FullBytes: b}}})
+		if err != nil {
+			return nil, err
+		}
+		enc = append(enc, a)
+	}
+	sort.Slice(enc, func(i, j int) bool {
+		return bytes.Compare(enc[i], enc[j]) < 0
+	})
+
+	return bytes.Join(enc, nil), nil
+}
@@ -0,0 +1,129 @@
+package manager
+
+import (
+	"errors"
+	"time"
+
+	"github.com/knadh/listmonk/internal/messenger"
+	"github.com/knadh/listmonk/models"
+)
+
+// greylistError wraps a greylisting reply to stop the immediate retries of
+// a message that's deferred instead.
+type greylistError struct {
+	err error
+}
+
+func (e *greylistError) Error() string {
+	return e.err.Error()
+}
+
+func (e *greylistError) Unwrap() error {
+	return e.err
+}
+
+// Temporary tells the retry policy to not retry the message immediately.
+func (e *greylistError) Temporary() bool {
+	return false
+}
+
+// isGreylisted checks whether a push error is a greylisting reply that
+// stopped the retries of a message.
+func isGreylisted(err error) bool {
+	var gErr *greylistError
+	return errors.As(err, &gErr)
+}
+
+// checkGreylisted wraps the error of a push attempt if it's a greylisting
+// reply and greylisted messages are deferred.
+func (m *Manager) checkGreylisted(err error) error {
+	if m.cfg.GreylistDelay > 0 && m.cfg.GreylistMaxDeferrals > 0 && messenger.IsGreylisted(err) {
+		return &greylistError{err: err}
+	}
+	return err
+}
+
+// deferCampaignMessage reschedules a greylisted campaign message to be sent
+// after the greylisting delay. It returns false if the message can't be
+// deferred any more and has failed.
+func (m *Manager) deferCampaignMessage(msg CampaignMessage, err error) bool {
+	if !isGreylisted(err) || msg.deferrals >= m.cfg.GreylistMaxDeferrals {
+		return false
+	}
+	msg.deferrals++
+
+	m.campLog(msg.Campaign).With("subscriber_uuid", msg.Subscriber.UUID).
+		Warnf("deferring greylisted message by %v (%d/%d): %v", m.cfg.GreylistDelay, msg.deferrals, m.cfg.GreylistMaxDeferrals, err)
+	m.updateQueued(0, msg.Campaign.ID, msg.Subscriber.ID, queueStatusQueued)
+	m.metrics[msg.Campaign.Messenger].deferral()
+	m.recordDeferral(msg.Campaign.ID, msg.Subscriber.Email)
+
+	go m.afterGreylistDelay(func() {
+		c, err := m.src.GetCampaign(msg.Campaign.ID)
+		if err != nil {
+			// The message remains in the durable queue and is sent
+			// when the manager restarts.
+			m.campLog(msg.Campaign).Errorf("error fetching campaign of deferred message: %v", err)
+			return
+		}
+
+		switch c.Status {
+		case models.CampaignStatusRunning, models.CampaignStatusFinished:
+			m.metrics[msg.Campaign.Messenger].queue(1)
+			m.campMsgQueue <- msg
+		case models.CampaignStatusPaused:
+			// Pending messages in the queue are sent when the campaign resumes.
+			m.updateQueued(0, c.ID, msg.Subscriber.ID, queueStatusPending)
+		default:
+			m.dequeue(0, c.ID, msg.Subscriber.ID)
+		}
+	})
+
+	return true
+}
+
+// deferMessage reschedules a greylisted arbitrary message to be sent after
+// the greylisting delay. It returns false if the message can't be deferred
+// any more and has failed.
+func (m *Manager) deferMessage(msg Message, err error) bool {
+	if !isGreylisted(err) || msg.deferrals >= m.cfg.GreylistMaxDeferrals {
+		return false
+	}
+	msg.deferrals++
+
+	m.logger.Warnf("deferring greylisted message '%s' by %v (%d/%d): %v",
+		msg.Subject, m.cfg.GreylistDelay, msg.deferrals, m.cfg.GreylistMaxDeferrals, err)
+	m.updateQueued(msg.queueID, 0, 0, queueStatusQueued)
+	m.metrics[msg.Messenger].deferral()
+	for _, to := range msg.To {
+		m.recordDeferral(0, to)
+	}
+
+	go m.afterGreylistDelay(func() {
+		m.metrics[msg.Messenger].queue(1)
+		m.queueFor(msg) <- msg
+	})
+
+	return true
+}
+
+// recordDeferral records a deferral in the data source for the
+// deliverability stats of recipient domains.
+func (m *Manager) recordDeferral(campID int, email string) {
+	if err := m.src.RecordDeferral(campID, email); err != nil {
+		m.logger.Errorf("error recording deferral: %v", err)
+	}
+}
+
+// afterGreylistDelay calls fn after the greylisting delay unless the
+// manager is closed.
+func (m *Manager) afterGreylistDelay(fn func()) {
+	t := time.NewTimer(m.cfg.GreylistDelay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		fn()
+	case <-m.quit:
+	}
+}
@@ -0,0 +1,33 @@
+package manager
+
+import (
+	"net/textproto"
+)
+
+// Header is an instance-wide header that's set on all outgoing messages.
+// It overrides the default header of the same name, and if its value is
+// empty, removes it.
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// setHeaders sets the default headers (X-Mailer, and Precedence and List-Id
+// on campaign messages) followed by the instance-wide headers on h.
+func (m *Manager) setHeaders(h textproto.MIMEHeader, camp bool) {
+	h.Set("X-Mailer", "listmonk")
+	if camp {
+		h.Set("Precedence", "bulk")
+		if m.cfg.ListID != "" {
+			h.Set("List-Id", "<"+m.cfg.ListID+">")
+		}
+	}
+
+	for _, hdr := range m.cfg.Headers {
+		if hdr.Value == "" {
+			h.Del(hdr.Name)
+			continue
+		}
+		h.Set(hdr.Name, hdr.Value)
+	}
+}
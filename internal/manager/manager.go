@@ -2,16 +2,21 @@ package manager
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"net/textproto"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/knadh/listmonk/internal/bounce"
+	"github.com/knadh/listmonk/internal/logger"
 	"github.com/knadh/listmonk/internal/messenger"
+	"github.com/knadh/listmonk/internal/ratelimit"
+	"github.com/knadh/listmonk/internal/tplfuncs"
 	"github.com/knadh/listmonk/models"
 )
 
@@ -23,6 +28,23 @@ const (
 	ContentTpl = "content"
 
 	dummyUUID = "00000000-0000-0000-0000-000000000000"
+
+	// Statuses of messages in the durable message queue.
+	queueStatusPending = "pending"
+	queueStatusQueued  = "queued"
+	queueStatusSending = "sending"
+
+	// State changes of messages in the durable queue are written in
+	// batches of this size, or at this interval, whichever is first.
+	queueWriteBatchSize = 1000
+	queueWriteInterval  = time.Second
+)
+
+// Priorities of arbitrary messages. Arbitrary messages are dispatched ahead
+// of campaign messages, and high priority ones ahead of the rest.
+const (
+	PriorityNormal = 0
+	PriorityHigh   = 1
 )
 
 // DataSource represents a data backend, such as a database,
@@ -33,6 +55,21 @@ type DataSource interface {
 	GetCampaign(campID int) (*models.Campaign, error)
 	UpdateCampaignStatus(campID int, status string) error
 	CreateLink(url string) (string, error)
+
+	// Durable message queue. NextSubscribers records the messages to the
+	// subscribers it returns in the queue. Campaign messages in the queue are
+	// identified by their campaign and subscriber IDs, and other messages
+	// by their queue IDs.
+	NextQueuedSubscribers(campID, limit int) ([]models.Subscriber, error)
+	QueueMessage(messenger string, msg []byte) (int64, error)
+	NextQueuedMessages(limit int) ([]QueuedMessage, error)
+	UpdateQueuedMessages(keys []QueueKey, status string) error
+	DeleteQueuedMessages(keys []QueueKey) error
+	ResetMessageQueue() (int, error)
+
+	// RecordDeferral records the deferral of a message to an e-mail
+	// address by its domain. campID is 0 for non-campaign messages.
+	RecordDeferral(campID int, email string) error
 }
 
 // Manager handles the scheduling, processing, and queuing of campaigns
@@ -42,7 +79,23 @@ type Manager struct {
 	src        DataSource
 	messengers map[string]messenger.Messenger
 	notifCB    models.AdminNotifCallback
-	logger     *log.Logger
+	logger     *logger.Logger
+
+	// Rate limiters of messengers (name => limiter) that have their own
+	// limits in addition to the global message rate.
+	limits map[string]*ratelimit.Limiter
+
+	// Retry policies of messengers (name => policy) for failed pushes.
+	retries map[string]messenger.Retry
+
+	// Throttles of recipient domains (domain => throttle).
+	domains map[string]*domainThrottle
+
+	// Runtime metrics of messengers (name => metrics).
+	metrics map[string]*msgrMetrics
+
+	// Optional callback that's called with the outcome of every message push.
+	deliveryCB DeliveryCallback
 
 	// Campaigns that are currently running.
 	camps      map[int]*models.Campaign
@@ -54,11 +107,26 @@ type Manager struct {
 	links      map[string]string
 	linksMutex sync.RWMutex
 
+	// Named partial templates (name => body) that templates include
+	// with the Partial function.
+	partials      map[string]string
+	partialsMutex sync.RWMutex
+
 	subFetchQueue      chan *models.Campaign
 	campMsgQueue       chan CampaignMessage
 	campMsgErrorQueue  chan msgError
 	campMsgErrorCounts map[int]int
+	campErrorsMutex    sync.RWMutex
 	msgQueue           chan Message
+	priorityMsgQueue   chan Message
+
+	// Pending state changes of messages in the durable queue, and the
+	// writer that's waited on to write them on close.
+	queueWrites  chan queueWrite
+	queueWriteWg sync.WaitGroup
+
+	// Closed when the manager is closed to stop pending deferrals.
+	quit chan struct{}
 }
 
 // CampaignMessage represents an instance of campaign message to be pushed out,
@@ -72,6 +140,14 @@ type CampaignMessage struct {
 	subject  string
 	body     []byte
 	unsubURL string
+	queuedAt time.Time
+
+	// Number of times the message has been deferred on greylisting.
+	deferrals int
+
+	// Number of links tracked with TrackLink so far while rendering the
+	// message's body, which is the position of the last one.
+	linkPos int
 }
 
 // Message represents a generic message to be pushed to a messenger.
@@ -81,6 +157,76 @@ type Message struct {
 
 	// Messenger is the messenger backend to use: email|postback.
 	Messenger string
+
+	// Priority is PriorityNormal or PriorityHigh, for instance, for
+	// transactional messages and opt-in confirmations.
+	Priority int
+
+	// TxLogID is the ID of the message in the transactional message log, if
+	// it's a transactional message. It's passed back to the delivery callback.
+	TxLogID int64
+
+	// ID of the message in the durable message queue.
+	queueID  int64
+	queuedAt time.Time
+
+	// Number of times the message has been deferred on greylisting.
+	deferrals int
+}
+
+// Delivery is the outcome of the push of a campaign or an arbitrary message.
+type Delivery struct {
+	Messenger string
+	Message   messenger.Message
+
+	// TxLogID is the transactional message log ID of the message, if any.
+	TxLogID int64
+
+	QueuedAt time.Time
+
+	// Number of attempts made to push the message and the final error, if any.
+	Attempts int
+	Err      error
+}
+
+// DeliveryCallback is called with the outcome of every message push.
+type DeliveryCallback func(d Delivery)
+
+// QueuedMessage is a non-campaign message in the durable message queue.
+type QueuedMessage struct {
+	ID        int64           `db:"id"`
+	Messenger string          `db:"messenger"`
+	Message   json.RawMessage `db:"message"`
+	CreatedAt time.Time       `db:"created_at"`
+}
+
+// QueueKey identifies a message in the durable queue. Campaign messages
+// are identified by their campaign and subscriber IDs, and other messages
+// by their queue IDs.
+type QueueKey struct {
+	ID           int64
+	CampaignID   int
+	SubscriberID int
+}
+
+// queueWrite is a state change of a message in the durable queue. An empty
+// status removes the message from the queue.
+type queueWrite struct {
+	key    QueueKey
+	status string
+}
+
+// queuedMsg is the payload of a Message in the durable message queue.
+type queuedMsg struct {
+	From        string             `json:"from"`
+	To          []string           `json:"to"`
+	Subject     string             `json:"subject"`
+	ContentType string             `json:"content_type"`
+	Body        []byte             `json:"body"`
+	Subscriber  *models.Subscriber `json:"subscriber,omitempty"`
+	Campaign    *models.Campaign   `json:"campaign,omitempty"`
+	Priority    int                `json:"priority"`
+	TxLogID     int64              `json:"tx_log_id"`
 }
 
 // Config has parameters for configuring the manager.
@@ -96,10 +242,28 @@ type Config struct {
 	IndividualTracking bool
 	LinkTrackURL       string
 	UnsubURL           string
+	UnsubOneClickURL   string
 	OptinURL           string
 	MessageURL         string
 	ViewTrackURL       string
 	UnsubHeader        bool
+
+	// VERP optionally sets per-recipient VERP return addresses on
+	// campaign messages.
+	VERP *bounce.VERP
+
+	// ListID is the List-Id (eg: listmonk.yoursite.com) of campaign messages.
+	ListID string
+
+	// Headers are set on all outgoing messages after the default headers.
+	Headers []Header
+
+	// GreylistDelay is the delay after which messages that are greylisted
+	// by the recipient's server are sent again instead of being retried
+	// immediately, at most GreylistMaxDeferrals times. 0 for either disables
+	// deferral.
+	GreylistDelay        time.Duration
+	GreylistMaxDeferrals int
 }
 
 type msgError struct {
@@ -108,7 +272,7 @@ type msgError struct {
 }
 
 // New returns a new instance of Mailer.
-func New(cfg Config, src DataSource, notifCB models.AdminNotifCallback, l *log.Logger) *Manager {
+func New(cfg Config, src DataSource, notifCB models.AdminNotifCallback, l *logger.Logger) *Manager {
 	if cfg.BatchSize < 1 {
 		cfg.BatchSize = 1000
 	}
@@ -125,13 +289,20 @@ func New(cfg Config, src DataSource, notifCB models.AdminNotifCallback, l *log.L
 		notifCB:            notifCB,
 		logger:             l,
 		messengers:         make(map[string]messenger.Messenger),
+		limits:             make(map[string]*ratelimit.Limiter),
+		retries:            make(map[string]messenger.Retry),
+		metrics:            make(map[string]*msgrMetrics),
 		camps:              make(map[int]*models.Campaign),
 		links:              make(map[string]string),
+		partials:           make(map[string]string),
 		subFetchQueue:      make(chan *models.Campaign, cfg.Concurrency),
 		campMsgQueue:       make(chan CampaignMessage, cfg.Concurrency*2),
 		msgQueue:           make(chan Message, cfg.Concurrency),
+		priorityMsgQueue:   make(chan Message, cfg.Concurrency),
+		queueWrites:        make(chan queueWrite, queueWriteBatchSize),
 		campMsgErrorQueue:  make(chan msgError, cfg.MaxSendErrors),
 		campMsgErrorCounts: make(map[int]int),
+		quit:               make(chan struct{}),
 	}
 }
 
@@ -147,6 +318,7 @@ func (m *Manager) NewCampaignMessage(c *models.Campaign, s models.Subscriber) Ca
 		from:     c.FromEmail,
 		to:       s.Email,
 		unsubURL: fmt.Sprintf(m.cfg.UnsubURL, c.UUID, s.UUID),
+		queuedAt: time.Now(),
 	}
 }
 
@@ -157,18 +329,75 @@ func (m *Manager) AddMessenger(msg messenger.Messenger) error {
 		return fmt.Errorf("messenger '%s' is already loaded", id)
 	}
 	m.messengers[id] = msg
+	m.metrics[id] = &msgrMetrics{}
 	return nil
 }
 
-// PushMessage pushes a Message to be sent out by the workers.
+// SetMessengerLimit sets the rate limiter of a messenger. Messages to the
+// messenger are held back by the workers till the limiter allows them.
+// It should be called before Run.
+func (m *Manager) SetMessengerLimit(id string, l *ratelimit.Limiter) {
+	if l == nil {
+		delete(m.limits, id)
+		return
+	}
+	m.limits[id] = l
+}
+
+// SetMessengerRetry sets the retry policy for failed pushes to a messenger.
+// Without a policy, a message is attempted only once. It should be called
+// before Run.
+func (m *Manager) SetMessengerRetry(id string, r messenger.Retry) {
+	m.retries[id] = r
+}
+
+// SetDeliveryCallback sets the callback that's called with the outcome of
+// every message push. It should be called before Run.
+func (m *Manager) SetDeliveryCallback(cb DeliveryCallback) {
+	m.deliveryCB = cb
+}
+
+// PushMessage records a Message in the durable message queue and pushes it
+// to be sent out by the workers.
 func (m *Manager) PushMessage(msg Message) error {
+	q := queuedMsg{
+		From:        msg.From,
+		To:          msg.To,
+		Subject:     msg.Subject,
+		ContentType: msg.ContentType,
+		Body:        msg.Body,
+		Campaign:    msg.Campaign,
+		Priority:    msg.Priority,
+		TxLogID:     msg.TxLogID,
+	}
+	if msg.Subscriber.ID > 0 {
+		q.Subscriber = &msg.Subscriber
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("error encoding message: %v", err)
+	}
+	id, err := m.src.QueueMessage(msg.Messenger, b)
+	if err != nil {
+		m.logger.Errorf("error queuing message '%s': %v", msg.Subject, err)
+		return errors.New("error queuing message")
+	}
+	msg.queueID = id
+	msg.queuedAt = time.Now()
+
 	t := time.NewTicker(time.Second * 3)
 	defer t.Stop()
 
+	m.metrics[msg.Messenger].queue(1)
 	select {
-	case m.msgQueue <- msg:
+	case m.queueFor(msg) <- msg:
 	case <-t.C:
-		m.logger.Println("message push timed out: %'s'", msg.Subject)
+		m.metrics[msg.Messenger].queue(-1)
+		m.logger.Warnf("message push timed out: '%s'", msg.Subject)
+
+		// The caller may retry the message. Remove it from the queue so
+		// that it isn't sent again on restart.
+		m.dequeue(id, 0, 0)
 		return errors.New("message push timed out")
 	}
 	return nil
@@ -187,6 +416,15 @@ func (m *Manager) HasRunningCampaigns() bool {
 	return len(m.camps) > 0
 }
 
+// CampaignErrors returns the number of messages of a campaign that have
+// failed to be sent. The count is reset when the campaign is paused for
+// exceeding the error threshold.
+func (m *Manager) CampaignErrors(id int) int {
+	m.campErrorsMutex.RLock()
+	defer m.campErrorsMutex.RUnlock()
+	return m.campMsgErrorCounts[id]
+}
+
 // Run is a blocking function (that should be invoked as a goroutine)
 // that scans the data source at regular intervals for pending campaigns,
 // and queues them for processing. The process queue fetches batches of
@@ -194,6 +432,18 @@ func (m *Manager) HasRunningCampaigns() bool {
 // until all subscribers are exhausted, at which point, a campaign is marked
 // as "finished".
 func (m *Manager) Run(tick time.Duration) {
+	// Recover messages that were in the durable queue when the manager
+	// last stopped.
+	n, err := m.src.ResetMessageQueue()
+	if err != nil {
+		m.logger.Errorf("error recovering message queue: %v", err)
+	} else if n > 0 {
+		m.logger.Infof("requeued %d messages that were being sent when listmonk stopped", n)
+	}
+	m.queueWriteWg.Add(1)
+	go m.writeQueueStates()
+	go m.requeueMessages()
+
 	go m.scanCampaigns(tick)
 
 	// Spawn N message workers.
@@ -205,7 +455,7 @@ func (m *Manager) Run(tick time.Duration) {
 	for c := range m.subFetchQueue {
 		has, err := m.nextSubscribers(c, m.cfg.BatchSize)
 		if err != nil {
-			m.logger.Printf("error processing campaign batch (%s): %v", c.Name, err)
+			m.campLog(c).Errorf("error processing campaign batch: %v", err)
 			continue
 		}
 
@@ -217,7 +467,7 @@ func (m *Manager) Run(tick time.Duration) {
 			// has changed or all subscribers have been processed.
 			newC, err := m.exhaustCampaign(c, "")
 			if err != nil {
-				m.logger.Printf("error exhausting campaign (%s): %v", c.Name, err)
+				m.campLog(c).Errorf("error exhausting campaign: %v", err)
 				continue
 			}
 			m.sendNotif(newC, newC.Status, "")
@@ -231,12 +481,35 @@ func (m *Manager) messageWorker() {
 	// Counter to keep track of the message / sec rate limit.
 	numMsg := 0
 	for {
+		// Pending high priority messages and then other arbitrary messages
+		// are picked up before campaign messages so that they aren't held up
+		// by large campaigns.
+		select {
+		case msg, ok := <-m.priorityMsgQueue:
+			if !ok {
+				return
+			}
+			m.sendMessage(msg)
+			continue
+		default:
+		}
+		select {
+		case msg, ok := <-m.msgQueue:
+			if !ok {
+				return
+			}
+			m.sendMessage(msg)
+			continue
+		default:
+		}
+
 		select {
 		// Campaign message.
 		case msg, ok := <-m.campMsgQueue:
 			if !ok {
 				return
 			}
+			m.metrics[msg.Campaign.Messenger].queue(-1)
 
 			// Pause on hitting the message rate.
 			if numMsg >= m.cfg.MessageRate {
@@ -256,17 +529,42 @@ func (m *Manager) messageWorker() {
 				Campaign:    msg.Campaign,
 			}
 
-			// Attach List-Unsubscribe headers?
+			// Attach List-Unsubscribe and Reply-To headers, and the campaign and
+			// subscriber identifiers that bounce processing uses to match
+			// bounced messages.
+			h := textproto.MIMEHeader{}
+			h.Set(models.EmailHeaderCampaignUUID, msg.Campaign.UUID)
+			h.Set(models.EmailHeaderSubscriberUUID, msg.Subscriber.UUID)
+			h.Set("Message-Id", makeMessageID(msg.Campaign.UUID, msg.Subscriber.UUID, msg.from))
 			if m.cfg.UnsubHeader {
-				h := textproto.MIMEHeader{}
+				// RFC 8058 one-click unsubscription. The URL should accept the
+				// POST without any interaction.
+				u := msg.unsubURL
+				if m.cfg.UnsubOneClickURL != "" {
+					u = fmt.Sprintf(m.cfg.UnsubOneClickURL, msg.Campaign.UUID, msg.Subscriber.UUID)
+				}
 				h.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
-				h.Set("List-Unsubscribe", `<`+msg.unsubURL+`>`)
-				out.Headers = h
+				h.Set("List-Unsubscribe", `<`+u+`>`)
+			}
+			if msg.Campaign.ReplyTo != "" {
+				h.Set("Reply-To", msg.Campaign.ReplyTo)
 			}
+			m.setHeaders(h, true)
+			out.Headers = h
 
-			if err := m.messengers[msg.Campaign.Messenger].Push(out); err != nil {
-				m.logger.Printf("error sending message in campaign %s: subscriber %s: %v",
-					msg.Campaign.Name, msg.Subscriber.UUID, err)
+			if m.cfg.VERP != nil {
+				out.Sender = m.cfg.VERP.Address(msg.Campaign.UUID, msg.Subscriber.UUID, msg.from)
+			}
+
+			m.markSending(0, msg.Campaign.ID, msg.Subscriber.ID)
+			n, err := m.push(msg.Campaign.Messenger, out)
+			if m.deferCampaignMessage(msg, err) {
+				continue
+			}
+			m.dequeue(0, msg.Campaign.ID, msg.Subscriber.ID)
+			if err != nil {
+				m.campLog(msg.Campaign).With("subscriber_uuid", msg.Subscriber.UUID).
+					Errorf("error sending message (%d attempts): %v", n, err)
 
 				select {
 				case m.campMsgErrorQueue <- msgError{camp: msg.Campaign, err: err}:
@@ -274,41 +572,252 @@ func (m *Manager) messageWorker() {
 				}
 			}
 
+			m.delivered(Delivery{
+				Messenger: msg.Campaign.Messenger,
+				Message:   out,
+				QueuedAt:  msg.queuedAt,
+				Attempts:  n,
+				Err:       err,
+			})
+
 		// Arbitrary message.
+		case msg, ok := <-m.priorityMsgQueue:
+			if !ok {
+				return
+			}
+			m.sendMessage(msg)
+
 		case msg, ok := <-m.msgQueue:
 			if !ok {
 				return
 			}
+			m.sendMessage(msg)
+		}
+	}
+}
 
-			err := m.messengers[msg.Messenger].Push(messenger.Message{
-				From:        msg.From,
-				To:          msg.To,
-				Subject:     msg.Subject,
-				ContentType: msg.ContentType,
-				Body:        msg.Body,
-				Subscriber:  msg.Subscriber,
-				Campaign:    msg.Campaign,
-			})
-			if err != nil {
-				m.logger.Printf("error sending message '%s': %v", msg.Subject, err)
+// sendMessage pushes an arbitrary message to its messenger.
+func (m *Manager) sendMessage(msg Message) {
+	m.metrics[msg.Messenger].queue(-1)
+
+	out := messenger.Message{
+		From:        msg.From,
+		To:          msg.To,
+		Subject:     msg.Subject,
+		ContentType: msg.ContentType,
+		Body:        msg.Body,
+		Headers:     textproto.MIMEHeader{},
+		Subscriber:  msg.Subscriber,
+		Campaign:    msg.Campaign,
+	}
+	m.setHeaders(out.Headers, false)
+
+	m.markSending(msg.queueID, 0, 0)
+	n, err := m.push(msg.Messenger, out)
+	if m.deferMessage(msg, err) {
+		return
+	}
+	m.dequeue(msg.queueID, 0, 0)
+	if err != nil {
+		m.logger.Errorf("error sending message '%s' (%d attempts): %v", msg.Subject, n, err)
+	}
+
+	m.delivered(Delivery{
+		Messenger: msg.Messenger,
+		Message:   out,
+		TxLogID:   msg.TxLogID,
+		QueuedAt:  msg.queuedAt,
+		Attempts:  n,
+		Err:       err,
+	})
+}
+
+// delivered records the outcome of a message push in the messenger's
+// metrics and calls the delivery callback, if there's one.
+func (m *Manager) delivered(d Delivery) {
+	m.metrics[d.Messenger].done(d.Attempts, d.Err)
+	if m.deliveryCB != nil {
+		m.deliveryCB(d)
+	}
+}
+
+// queueFor returns the worker queue for an arbitrary message by its priority.
+func (m *Manager) queueFor(msg Message) chan Message {
+	if msg.Priority >= PriorityHigh {
+		return m.priorityMsgQueue
+	}
+	return m.msgQueue
+}
+
+// push pushes a message to a messenger within the messenger's rate limits,
+// retrying transient failures as per the messenger's retry policy. It
+// returns the number of attempts made.
+func (m *Manager) push(id string, msg messenger.Message) (int, error) {
+	var (
+		msgr = m.messengers[id]
+		l    = m.limits[id]
+		mt   = m.metrics[id]
+	)
+	n, err := m.retries[id].Do(func() error {
+		waited := mt.wait()
+		release := m.throttle(msg.To)
+		defer release()
+
+		l.Wait()
+		waited()
+
+		pushed := mt.push()
+		defer pushed()
+		return m.checkGreylisted(msgr.Push(msg))
+	})
+	if err == nil && n > 1 {
+		m.logger.Infof("sent message '%s' to %s after %d attempts", msg.Subject, strings.Join(msg.To, ", "), n)
+	}
+	return n, err
+}
+
+// markSending marks a message in the durable queue as being sent. Messages
+// that were being sent when the manager stopped are reconciled with the
+// delivery log on startup.
+func (m *Manager) markSending(id int64, campID, subID int) {
+	m.updateQueued(id, campID, subID, queueStatusSending)
+}
+
+// updateQueued updates the status of a message in the durable queue.
+// The change is written asynchronously in a batch.
+func (m *Manager) updateQueued(id int64, campID, subID int, status string) {
+	m.queueWrites <- queueWrite{key: QueueKey{ID: id, CampaignID: campID, SubscriberID: subID}, status: status}
+}
+
+// dequeue removes a message that has been sent (or has failed) from the
+// durable queue. The change is written asynchronously in a batch.
+func (m *Manager) dequeue(id int64, campID, subID int) {
+	m.queueWrites <- queueWrite{key: QueueKey{ID: id, CampaignID: campID, SubscriberID: subID}}
+}
+
+// writeQueueStates is a blocking function that writes the state changes of
+// messages in the durable queue to the data source in batches. Only the last
+// change of a message in a batch is written.
+func (m *Manager) writeQueueStates() {
+	defer m.queueWriteWg.Done()
+
+	var (
+		t     = time.NewTicker(queueWriteInterval)
+		batch = make(map[QueueKey]string)
+	)
+	defer t.Stop()
+
+	for {
+		select {
+		case w := <-m.queueWrites:
+			batch[w.key] = w.status
+			if len(batch) < queueWriteBatchSize {
+				continue
+			}
+
+		case <-t.C:
+
+		case <-m.quit:
+			// Write the remaining changes.
+			for {
+				select {
+				case w := <-m.queueWrites:
+					batch[w.key] = w.status
+				default:
+					m.flushQueueStates(batch)
+					return
+				}
 			}
 		}
+
+		m.flushQueueStates(batch)
+		batch = make(map[QueueKey]string)
+	}
+}
+
+// flushQueueStates writes a batch of state changes of messages in the
+// durable queue to the data source.
+func (m *Manager) flushQueueStates(batch map[QueueKey]string) {
+	byStatus := make(map[string][]QueueKey)
+	for k, s := range batch {
+		byStatus[s] = append(byStatus[s], k)
+	}
+
+	for s, keys := range byStatus {
+		if s == "" {
+			if err := m.src.DeleteQueuedMessages(keys); err != nil {
+				m.logger.Errorf("error removing queued messages: %v", err)
+			}
+			continue
+		}
+
+		if err := m.src.UpdateQueuedMessages(keys, s); err != nil {
+			m.logger.Errorf("error updating queued messages: %v", err)
+		}
+	}
+}
+
+// requeueMessages pushes the non-campaign messages that were pending in the
+// durable queue when the manager last stopped to the workers.
+func (m *Manager) requeueMessages() {
+	total := 0
+	for {
+		msgs, err := m.src.NextQueuedMessages(m.cfg.BatchSize)
+		if err != nil {
+			m.logger.Errorf("error fetching queued messages: %v", err)
+			return
+		}
+		if len(msgs) == 0 {
+			break
+		}
+
+		for _, q := range msgs {
+			var p queuedMsg
+			if err := json.Unmarshal(q.Message, &p); err != nil {
+				m.logger.Errorf("error decoding queued message %d: %v", q.ID, err)
+				m.dequeue(q.ID, 0, 0)
+				continue
+			}
+
+			msg := Message{Messenger: q.Messenger, Priority: p.Priority, TxLogID: p.TxLogID, queueID: q.ID, queuedAt: q.CreatedAt}
+			msg.From = p.From
+			msg.To = p.To
+			msg.Subject = p.Subject
+			msg.ContentType = p.ContentType
+			msg.Body = p.Body
+			msg.Campaign = p.Campaign
+			if p.Subscriber != nil {
+				msg.Subscriber = *p.Subscriber
+			}
+			m.metrics[msg.Messenger].queue(1)
+			m.queueFor(msg) <- msg
+		}
+		total += len(msgs)
+	}
+
+	if total > 0 {
+		m.logger.Infof("requeued %d pending messages", total)
 	}
 }
 
 // TemplateFuncs returns the template functions to be applied into
 // compiled campaign templates.
 func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
-	return template.FuncMap{
+	f := template.FuncMap{
 		"TrackLink": func(url string, msg *CampaignMessage) string {
 			subUUID := msg.Subscriber.UUID
 			if !m.cfg.IndividualTracking {
 				subUUID = dummyUUID
 			}
 
-			return m.trackLink(url, msg.Campaign.UUID, subUUID)
+			msg.linkPos++
+			return m.trackLink(url, msg.Campaign.UUID, subUUID, msg.linkPos)
 		},
 		"TrackView": func(msg *CampaignMessage) template.HTML {
+			if !msg.Campaign.TrackOpens {
+				return ""
+			}
+
 			subUUID := msg.Subscriber.UUID
 			if !m.cfg.IndividualTracking {
 				subUUID = dummyUUID
@@ -334,14 +843,77 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 			}
 			return time.Now().Format(layout)
 		},
+		"Var": func(name string) interface{} {
+			return c.Vars[name]
+		},
 	}
+
+	// The function library. Built-in functions take precedence.
+	for k, v := range tplfuncs.Funcs() {
+		if _, ok := f[k]; !ok {
+			f[k] = v
+		}
+	}
+
+	// Partials are compiled once for the campaign on first use. They can't
+	// include other partials, which rules out recursive includes.
+	var (
+		partials = make(map[string]*template.Template)
+		mut      sync.Mutex
+		pf       = make(template.FuncMap, len(f))
+	)
+	for k, v := range f {
+		pf[k] = v
+	}
+	f["Partial"] = func(name string, msg *CampaignMessage) (template.HTML, error) {
+		mut.Lock()
+		tpl, ok := partials[name]
+		if !ok {
+			m.partialsMutex.RLock()
+			body, ok := m.partials[name]
+			m.partialsMutex.RUnlock()
+			if !ok {
+				mut.Unlock()
+				return "", fmt.Errorf("unknown partial: %s", name)
+			}
+
+			t, err := models.CompilePartial(name, body, pf)
+			if err != nil {
+				mut.Unlock()
+				return "", err
+			}
+			partials[name] = t
+			tpl = t
+		}
+		mut.Unlock()
+
+		var out bytes.Buffer
+		if err := tpl.Execute(&out, msg); err != nil {
+			return "", fmt.Errorf("error rendering partial %s: %v", name, err)
+		}
+		return template.HTML(out.String()), nil
+	}
+
+	return f
+}
+
+// SetPartials sets the named partial templates (name => body) that
+// templates can include with {{ Partial "name" }}.
+func (m *Manager) SetPartials(p map[string]string) {
+	m.partialsMutex.Lock()
+	m.partials = p
+	m.partialsMutex.Unlock()
 }
 
-// Close closes and exits the campaign manager.
+// Close closes and exits the campaign manager after writing the pending
+// state changes of the durable queue.
 func (m *Manager) Close() {
+	close(m.quit)
 	close(m.subFetchQueue)
 	close(m.campMsgErrorQueue)
 	close(m.msgQueue)
+	close(m.priorityMsgQueue)
+	m.queueWriteWg.Wait()
 }
 
 // scanCampaigns is a blocking function that periodically scans the data source
@@ -354,16 +926,16 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 		case <-t.C:
 			campaigns, err := m.src.NextCampaigns(m.getPendingCampaignIDs())
 			if err != nil {
-				m.logger.Printf("error fetching campaigns: %v", err)
+				m.logger.Errorf("error fetching campaigns: %v", err)
 				continue
 			}
 
 			for _, c := range campaigns {
 				if err := m.addCampaign(c); err != nil {
-					m.logger.Printf("error processing campaign (%s): %v", c.Name, err)
+					m.campLog(c).Errorf("error processing campaign: %v", err)
 					continue
 				}
-				m.logger.Printf("start processing campaign (%s)", c.Name)
+				m.campLog(c).Infof("start processing campaign")
 
 				// If subscriber processing is busy, move on. Blocking and waiting
 				// can end up in a race condition where the waiting campaign's
@@ -380,20 +952,24 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 			if !ok {
 				return
 			}
+			m.campErrorsMutex.Lock()
+			m.campMsgErrorCounts[e.camp.ID]++
+			n := m.campMsgErrorCounts[e.camp.ID]
+			m.campErrorsMutex.Unlock()
 			if m.cfg.MaxSendErrors < 1 {
 				continue
 			}
 
 			// If the error threshold is met, pause the campaign.
-			m.campMsgErrorCounts[e.camp.ID]++
-			if m.campMsgErrorCounts[e.camp.ID] >= m.cfg.MaxSendErrors {
-				m.logger.Printf("error counted exceeded %d. pausing campaign %s",
-					m.cfg.MaxSendErrors, e.camp.Name)
+			if n >= m.cfg.MaxSendErrors {
+				m.campLog(e.camp).Warnf("error count exceeded %d. pausing campaign", m.cfg.MaxSendErrors)
 
 				if m.isCampaignProcessing(e.camp.ID) {
 					m.exhaustCampaign(e.camp, models.CampaignStatusPaused)
 				}
+				m.campErrorsMutex.Lock()
 				delete(m.campMsgErrorCounts, e.camp.ID)
+				m.campErrorsMutex.Unlock()
 
 				// Notify admins.
 				m.sendNotif(e.camp, models.CampaignStatusPaused, "Too many errors")
@@ -439,10 +1015,19 @@ func (m *Manager) getPendingCampaignIDs() []int64 {
 // in the current batch or not. This can happen when all the subscribers
 // have been processed, or if a campaign has been paused or cancelled abruptly.
 func (m *Manager) nextSubscribers(c *models.Campaign, batchSize int) (bool, error) {
-	// Fetch a batch of subscribers.
-	subs, err := m.src.NextSubscribers(c.ID, batchSize)
+	// Messages that were pending in the durable queue when the manager last
+	// stopped are sent before fetching a new batch of subscribers.
+	subs, err := m.src.NextQueuedSubscribers(c.ID, batchSize)
 	if err != nil {
-		return false, fmt.Errorf("error fetching campaign subscribers (%s): %v", c.Name, err)
+		return false, fmt.Errorf("error fetching queued campaign subscribers (%s): %v", c.Name, err)
+	}
+
+	// Fetch a batch of subscribers.
+	if len(subs) == 0 {
+		subs, err = m.src.NextSubscribers(c.ID, batchSize)
+		if err != nil {
+			return false, fmt.Errorf("error fetching campaign subscribers (%s): %v", c.Name, err)
+		}
 	}
 
 	// There are no subscribers.
@@ -454,12 +1039,14 @@ func (m *Manager) nextSubscribers(c *models.Campaign, batchSize int) (bool, erro
 	for _, s := range subs {
 		msg := m.NewCampaignMessage(c, s)
 		if err := msg.Render(); err != nil {
-			m.logger.Printf("error rendering message (%s) (%s): %v", c.Name, s.Email, err)
+			m.campLog(c).With("subscriber_uuid", s.UUID).Errorf("error rendering message: %v", err)
+			m.dequeue(0, c.ID, s.ID)
 			continue
 		}
 
 		// Push the message to the queue while blocking and waiting until
 		// the queue is drained.
+		m.metrics[c.Messenger].queue(1)
 		m.campMsgQueue <- msg
 	}
 
@@ -483,9 +1070,9 @@ func (m *Manager) exhaustCampaign(c *models.Campaign, status string) (*models.Ca
 	// without further checks.
 	if status != "" {
 		if err := m.src.UpdateCampaignStatus(c.ID, status); err != nil {
-			m.logger.Printf("error updating campaign (%s) status to %s: %v", c.Name, status, err)
+			m.campLog(c).Errorf("error updating campaign status to %s: %v", status, err)
 		} else {
-			m.logger.Printf("set campaign (%s) to %s", c.Name, status)
+			m.campLog(c).Infof("set campaign to %s", status)
 		}
 		return c, nil
 	}
@@ -500,12 +1087,12 @@ func (m *Manager) exhaustCampaign(c *models.Campaign, status string) (*models.Ca
 	if cm.Status == models.CampaignStatusRunning {
 		cm.Status = models.CampaignStatusFinished
 		if err := m.src.UpdateCampaignStatus(c.ID, models.CampaignStatusFinished); err != nil {
-			m.logger.Printf("error finishing campaign (%s): %v", c.Name, err)
+			m.campLog(c).Errorf("error finishing campaign: %v", err)
 		} else {
-			m.logger.Printf("campaign (%s) finished", c.Name)
+			m.campLog(c).Infof("campaign finished")
 		}
 	} else {
-		m.logger.Printf("stop processing campaign (%s)", c.Name)
+		m.campLog(c).Infof("stop processing campaign")
 	}
 
 	return cm, nil
@@ -513,18 +1100,18 @@ func (m *Manager) exhaustCampaign(c *models.Campaign, status string) (*models.Ca
 
 // trackLink register a URL and return its UUID to be used in message templates
 // for tracking links.
-func (m *Manager) trackLink(url, campUUID, subUUID string) string {
+func (m *Manager) trackLink(url, campUUID, subUUID string, pos int) string {
 	m.linksMutex.RLock()
 	if uu, ok := m.links[url]; ok {
 		m.linksMutex.RUnlock()
-		return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+		return makeTrackURL(m.cfg.LinkTrackURL, uu, campUUID, subUUID, pos)
 	}
 	m.linksMutex.RUnlock()
 
 	// Register link.
 	uu, err := m.src.CreateLink(url)
 	if err != nil {
-		m.logger.Printf("error registering tracking for link '%s': %v", url, err)
+		m.logger.Errorf("error registering tracking for link '%s': %v", url, err)
 
 		// If the registration fails, fail over to the original URL.
 		return url
@@ -534,7 +1121,33 @@ func (m *Manager) trackLink(url, campUUID, subUUID string) string {
 	m.links[url] = uu
 	m.linksMutex.Unlock()
 
-	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+	return makeTrackURL(m.cfg.LinkTrackURL, uu, campUUID, subUUID, pos)
+}
+
+// makeTrackURL returns the tracking URL of a link with its position in the
+// message, if there's one, in the `p` query param.
+func makeTrackURL(tpl, linkUUID, campUUID, subUUID string, pos int) string {
+	u := fmt.Sprintf(tpl, linkUUID, campUUID, subUUID)
+	if pos > 0 {
+		u += "?p=" + strconv.Itoa(pos)
+	}
+	return u
+}
+
+// makeMessageID returns a Message-Id of the form <campUUID.subUUID@domain>
+// that identifies the campaign and subscriber in bounced messages that don't
+// carry the original headers.
+func makeMessageID(campUUID, subUUID, from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i != -1 {
+		domain = strings.Trim(from[i+1:], "> ")
+	}
+	return "<" + campUUID + "." + subUUID + "@" + domain + ">"
+}
+
+// campLog returns the logger with the context fields of a campaign.
+func (m *Manager) campLog(c *models.Campaign) *logger.Logger {
+	return m.logger.With("campaign_id", c.ID, "campaign", c.Name)
 }
 
 // sendNotif sends a notification to registered admin e-mails.
@@ -567,7 +1180,11 @@ func (m *CampaignMessage) Render() error {
 		out.Reset()
 	}
 
-	if err := m.Campaign.Tpl.ExecuteTemplate(&out, models.BaseTpl, m); err != nil {
+	// Link positions are counted from the top of the body.
+	m.linkPos = 0
+
+	// Use the template variant in the subscriber's language, if there's one.
+	if err := m.Campaign.LangTpl(m.Subscriber.Lang()).ExecuteTemplate(&out, models.BaseTpl, m); err != nil {
 		return err
 	}
 	m.body = out.Bytes()
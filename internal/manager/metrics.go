@@ -0,0 +1,190 @@
+package manager
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/messenger"
+)
+
+// Weight of the latest push in the moving averages of push durations.
+const metricsAvgWeight = 0.1
+
+// MessengerMetrics represents the runtime metrics of a messenger. The time
+// messages spend waiting on the rate limits and throttles is app-side while
+// the push latency is the time taken by the messenger and its provider.
+type MessengerMetrics struct {
+	Messenger string `json:"messenger"`
+
+	// Connections of messengers that have connection pools that are in use
+	// and the maximum. -1 if the messenger doesn't report them.
+	ActiveConns int `json:"active_conns"`
+	MaxConns    int `json:"max_conns"`
+
+	// Messages in the worker queues, waiting on the rate limits and
+	// throttles, and being pushed to the messenger.
+	Queued   int `json:"queued"`
+	Waiting  int `json:"waiting"`
+	InFlight int `json:"in_flight"`
+
+	Sent     int `json:"sent"`
+	Errors   int `json:"errors"`
+	Retries  int `json:"retries"`
+	Deferred int `json:"deferred"`
+
+	// Moving averages of the wait and push durations in milliseconds.
+	AvgWait    float64 `json:"avg_wait"`
+	AvgLatency float64 `json:"avg_latency"`
+}
+
+// Metrics represents the runtime metrics of the messengers and the
+// depth of the worker queues.
+type Metrics struct {
+	Messengers []MessengerMetrics `json:"messengers"`
+
+	CampaignQueue int `json:"campaign_queue"`
+	MessageQueue  int `json:"message_queue"`
+	PriorityQueue int `json:"priority_queue"`
+}
+
+// msgrMetrics tracks the runtime metrics of a messenger.
+type msgrMetrics struct {
+	sync.Mutex
+
+	queued   int
+	waiting  int
+	inFlight int
+	sent     int
+	errors   int
+	retries  int
+	deferred int
+
+	avgWait    float64
+	avgLatency float64
+}
+
+// Metrics returns the runtime metrics of the messengers.
+func (m *Manager) Metrics() Metrics {
+	out := Metrics{
+		Messengers:    make([]MessengerMetrics, 0, len(m.metrics)),
+		CampaignQueue: len(m.campMsgQueue),
+		MessageQueue:  len(m.msgQueue),
+		PriorityQueue: len(m.priorityMsgQueue),
+	}
+
+	for id, mt := range m.metrics {
+		o := MessengerMetrics{Messenger: id, ActiveConns: -1, MaxConns: -1}
+		if c, ok := m.messengers[id].(messenger.ConnCounter); ok {
+			o.ActiveConns, o.MaxConns = c.Conns()
+		}
+
+		mt.Lock()
+		o.Queued = mt.queued
+		o.Waiting = mt.waiting
+		o.InFlight = mt.inFlight
+		o.Sent = mt.sent
+		o.Errors = mt.errors
+		o.Retries = mt.retries
+		o.Deferred = mt.deferred
+		o.AvgWait = mt.avgWait
+		o.AvgLatency = mt.avgLatency
+		mt.Unlock()
+
+		out.Messengers = append(out.Messengers, o)
+	}
+	sort.Slice(out.Messengers, func(i, j int) bool {
+		return out.Messengers[i].Messenger < out.Messengers[j].Messenger
+	})
+
+	return out
+}
+
+// queue records a message being added to (n = 1) or removed from (n = -1)
+// the worker queues.
+func (mt *msgrMetrics) queue(n int) {
+	if mt == nil {
+		return
+	}
+	mt.Lock()
+	mt.queued += n
+	mt.Unlock()
+}
+
+// wait records a push attempt that's waiting on the limits and returns a
+// function that records the end of the wait.
+func (mt *msgrMetrics) wait() func() {
+	if mt == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	mt.Lock()
+	mt.waiting++
+	mt.Unlock()
+
+	return func() {
+		mt.Lock()
+		mt.waiting--
+		mt.avgWait = movingAvg(mt.avgWait, time.Since(start))
+		mt.Unlock()
+	}
+}
+
+// push records a push attempt that's in flight and returns a function that
+// records the end of the attempt.
+func (mt *msgrMetrics) push() func() {
+	if mt == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	mt.Lock()
+	mt.inFlight++
+	mt.Unlock()
+
+	return func() {
+		mt.Lock()
+		mt.inFlight--
+		mt.avgLatency = movingAvg(mt.avgLatency, time.Since(start))
+		mt.Unlock()
+	}
+}
+
+// done records the outcome of a message push after n attempts.
+func (mt *msgrMetrics) done(n int, err error) {
+	if mt == nil {
+		return
+	}
+
+	mt.Lock()
+	if err != nil {
+		mt.errors++
+	} else {
+		mt.sent++
+	}
+	if n > 1 {
+		mt.retries += n - 1
+	}
+	mt.Unlock()
+}
+
+// deferral records a message being deferred on greylisting.
+func (mt *msgrMetrics) deferral() {
+	if mt == nil {
+		return
+	}
+	mt.Lock()
+	mt.deferred++
+	mt.Unlock()
+}
+
+// movingAvg returns the exponentially weighted moving average of durations
+// in milliseconds with the latest duration.
+func movingAvg(avg float64, d time.Duration) float64 {
+	ms := float64(d) / float64(time.Millisecond)
+	if avg == 0 {
+		return ms
+	}
+	return avg + metricsAvgWeight*(ms-avg)
+}
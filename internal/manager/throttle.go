@@ -0,0 +1,101 @@
+package manager
+
+import (
+	"net/mail"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/ratelimit"
+)
+
+// DomainLimit caps the concurrency and the rate of messages to recipients
+// on a group of domains, for instance, to slow down sends to providers
+// that defer messages over their rate limits. The caps are shared by all
+// the domains in the group. Subdomains of the domains are also matched.
+type DomainLimit struct {
+	Domains []string `json:"domains"`
+
+	// Maximum number of messages being sent at a time. 0 is unlimited.
+	Concurrency int `json:"concurrency"`
+
+	// Maximum number of messages per second and per hour. 0 is unlimited.
+	MessageRate int `json:"message_rate"`
+	HourlyLimit int `json:"hourly_limit"`
+}
+
+// domainThrottle holds back messages to a group of domains.
+type domainThrottle struct {
+	sem   chan struct{}
+	limit *ratelimit.Limiter
+}
+
+// SetDomainLimits sets the per-recipient-domain throttles. It should be
+// called before Run.
+func (m *Manager) SetDomainLimits(limits []DomainLimit) {
+	m.domains = make(map[string]*domainThrottle)
+	for _, d := range limits {
+		t := &domainThrottle{limit: ratelimit.New(d.MessageRate, d.HourlyLimit)}
+		if d.Concurrency > 0 {
+			t.sem = make(chan struct{}, d.Concurrency)
+		}
+		if t.sem == nil && t.limit == nil {
+			continue
+		}
+
+		for _, dom := range d.Domains {
+			dom = strings.ToLower(strings.TrimSpace(dom))
+			if dom != "" {
+				m.domains[dom] = t
+			}
+		}
+	}
+}
+
+// throttle waits till a message to the given recipient is allowed by its
+// domain's throttle and returns a function that releases the concurrency
+// slot once the message is sent.
+func (m *Manager) throttle(to []string) func() {
+	t := m.getDomainThrottle(to)
+	if t == nil {
+		return func() {}
+	}
+
+	if t.sem != nil {
+		t.sem <- struct{}{}
+	}
+	t.limit.Wait()
+
+	return func() {
+		if t.sem != nil {
+			<-t.sem
+		}
+	}
+}
+
+// getDomainThrottle returns the throttle of the domain of the first
+// recipient or any of its parent domains.
+func (m *Manager) getDomainThrottle(to []string) *domainThrottle {
+	if len(m.domains) == 0 || len(to) == 0 {
+		return nil
+	}
+
+	addr := to[0]
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return nil
+	}
+
+	dom := strings.ToLower(addr[i+1:])
+	for {
+		if t, ok := m.domains[dom]; ok {
+			return t
+		}
+		i := strings.Index(dom, ".")
+		if i < 0 {
+			return nil
+		}
+		dom = dom[i+1:]
+	}
+}
@@ -0,0 +1,235 @@
+// Package markdown renders a commonly used subset of Markdown to HTML
+// that's safe to insert into e-mails.
+//
+// Raw HTML in the source is escaped and not rendered, and only http(s),
+// mailto and relative URLs are allowed in links and images, so the output
+// doesn't need further sanitization. Supported syntax: ATX headings,
+// paragraphs, hard line breaks (two trailing spaces), block quotes,
+// ordered and unordered lists (not nested), fenced code blocks, horizontal
+// rules, code spans, links, images, bold and italics.
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	regexpHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	regexpRule    = regexp.MustCompile(`^\s{0,3}([-*_])(\s*([-*_])){2,}\s*$`)
+	regexpUL      = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+	regexpOL      = regexp.MustCompile(`^\s{0,3}(\d{1,9})[.)]\s+(.*)$`)
+	regexpQuote   = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	regexpFence   = regexp.MustCompile("^\\s{0,3}(```|~~~)")
+
+	regexpCode   = regexp.MustCompile("`([^`]+)`")
+	regexpImage  = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+	regexpLink   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	regexpBold   = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|\b__(\S(?:.*?\S)?)__\b`)
+	regexpItalic = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*|\b_(\S(?:[^_]*?\S)?)_\b`)
+
+	// Placeholders for rendered inline elements that shouldn't be processed
+	// further. \x00 can't occur in the (escaped) source.
+	regexpPlaceholder = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+// Render renders Markdown to HTML.
+func Render(src string) string {
+	src = strings.Replace(src, "\r\n", "\n", -1)
+	src = strings.Replace(src, "\x00", "", -1)
+
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"))
+	return b.String()
+}
+
+// renderBlocks renders block level elements.
+func renderBlocks(b *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case regexpFence.MatchString(line):
+			fence := regexpFence.FindStringSubmatch(line)[1]
+			var code []string
+			i++
+			for ; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+					i++
+					break
+				}
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+
+		case regexpHeading.MatchString(line):
+			m := regexpHeading.FindStringSubmatch(line)
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", len(m[1]), renderInline(m[2]), len(m[1]))
+			i++
+
+		case regexpRule.MatchString(line):
+			b.WriteString("<hr />\n")
+			i++
+
+		case regexpQuote.MatchString(line):
+			var quote []string
+			for ; i < len(lines) && regexpQuote.MatchString(lines[i]); i++ {
+				quote = append(quote, regexpQuote.FindStringSubmatch(lines[i])[1])
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quote)
+			b.WriteString("</blockquote>\n")
+
+		case regexpUL.MatchString(line):
+			i = renderList(b, lines, i, regexpUL, "ul")
+
+		case regexpOL.MatchString(line):
+			i = renderList(b, lines, i, regexpOL, "ol")
+
+		default:
+			// A paragraph runs till a blank line or the start of another block.
+			var para []string
+			for ; i < len(lines); i++ {
+				l := lines[i]
+				if strings.TrimSpace(l) == "" || (len(para) > 0 && isBlockStart(l)) {
+					break
+				}
+				para = append(para, l)
+			}
+			b.WriteString("<p>")
+			for n, l := range para {
+				if n > 0 {
+					if strings.HasSuffix(para[n-1], "  ") {
+						b.WriteString("<br />")
+					}
+					b.WriteString("\n")
+				}
+				b.WriteString(renderInline(strings.TrimSpace(l)))
+			}
+			b.WriteString("</p>\n")
+		}
+	}
+}
+
+// renderList renders consecutive list items starting at line i and returns
+// the index of the line after the list. Indented lines continue an item.
+func renderList(b *strings.Builder, lines []string, i int, re *regexp.Regexp, tag string) int {
+	var (
+		items [][]string
+		start = 1
+	)
+	if tag == "ol" {
+		start, _ = strconv.Atoi(regexpOL.FindStringSubmatch(lines[i])[1])
+	}
+
+	for ; i < len(lines); i++ {
+		l := lines[i]
+		if m := re.FindStringSubmatch(l); m != nil {
+			items = append(items, []string{m[len(m)-1]})
+			continue
+		}
+		if strings.TrimSpace(l) != "" && (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && !isBlockStart(l) {
+			items[len(items)-1] = append(items[len(items)-1], strings.TrimSpace(l))
+			continue
+		}
+		break
+	}
+
+	if tag == "ol" && start != 1 {
+		fmt.Fprintf(b, "<ol start=\"%d\">\n", start)
+	} else {
+		fmt.Fprintf(b, "<%s>\n", tag)
+	}
+	for _, it := range items {
+		b.WriteString("<li>")
+		b.WriteString(renderInline(strings.Join(it, " ")))
+		b.WriteString("</li>\n")
+	}
+	fmt.Fprintf(b, "</%s>\n", tag)
+
+	return i
+}
+
+func isBlockStart(l string) bool {
+	return regexpHeading.MatchString(l) || regexpRule.MatchString(l) ||
+		regexpQuote.MatchString(l) || regexpFence.MatchString(l) ||
+		regexpUL.MatchString(l) || regexpOL.MatchString(l)
+}
+
+// renderInline renders the inline elements in a line of text.
+func renderInline(s string) string {
+	var (
+		parts []string
+		hold  = func(h string) string {
+			parts = append(parts, h)
+			return "\x00" + strconv.Itoa(len(parts)-1) + "\x00"
+		}
+	)
+
+	// Code spans are rendered as-is.
+	s = regexpCode.ReplaceAllStringFunc(s, func(m string) string {
+		return hold("<code>" + html.EscapeString(regexpCode.FindStringSubmatch(m)[1]) + "</code>")
+	})
+
+	s = html.EscapeString(s)
+
+	s = regexpImage.ReplaceAllStringFunc(s, func(m string) string {
+		sm := regexpImage.FindStringSubmatch(m)
+		u, ok := safeURL(sm[2])
+		if !ok {
+			return sm[1]
+		}
+		return hold(`<img src="` + u + `" alt="` + sm[1] + `" style="max-width:100%;" />`)
+	})
+
+	s = regexpLink.ReplaceAllStringFunc(s, func(m string) string {
+		sm := regexpLink.FindStringSubmatch(m)
+		u, ok := safeURL(sm[2])
+		if !ok {
+			return renderEmphasis(sm[1])
+		}
+		return hold(`<a href="` + u + `">` + renderEmphasis(sm[1]) + `</a>`)
+	})
+
+	s = renderEmphasis(s)
+
+	// Restore the placeholders. Link texts can contain placeholders of
+	// images and code spans.
+	for regexpPlaceholder.MatchString(s) {
+		s = regexpPlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+			n, _ := strconv.Atoi(regexpPlaceholder.FindStringSubmatch(m)[1])
+			return parts[n]
+		})
+	}
+
+	return s
+}
+
+func renderEmphasis(s string) string {
+	s = regexpBold.ReplaceAllString(s, "<strong>$1$2</strong>")
+	return regexpItalic.ReplaceAllString(s, "<em>$1$2</em>")
+}
+
+// safeURL checks whether an (HTML escaped) URL is http(s), mailto or
+// relative.
+func safeURL(u string) (string, bool) {
+	p, err := url.Parse(html.UnescapeString(u))
+	if err != nil {
+		return "", false
+	}
+
+	switch strings.ToLower(p.Scheme) {
+	case "", "http", "https", "mailto":
+		return u, true
+	}
+	return "", false
+}
@@ -0,0 +1,66 @@
+package markdown
+
+import "testing"
+
+func TestSafeURL(t *testing.T) {
+	cases := []struct {
+		in string
+		ok bool
+	}{
+		{"https://listmonk.app/docs?a=1&amp;b=2", true},
+		{"http://listmonk.app", true},
+		{"HTTPS://listmonk.app", true},
+		{"mailto:hello@listmonk.app", true},
+		{"/relative/path", true},
+		{"#anchor", true},
+		{"//listmonk.app/x", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"file:///etc/passwd", false},
+		{"ftp://listmonk.app", false},
+		{"java\x01script:alert(1)", false},
+
+		// Escaped entities are unescaped before the scheme is checked.
+		{"javascript&#58;alert(1)", false},
+		{"&#106;avascript:alert(1)", false},
+	}
+
+	for _, c := range cases {
+		u, ok := safeURL(c.in)
+		if ok != c.ok {
+			t.Errorf("%q: got %v, want %v", c.in, ok, c.ok)
+		}
+		if ok && u != c.in {
+			t.Errorf("%q: got URL %q", c.in, u)
+		}
+	}
+}
+
+func TestRenderURLs(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"link", "[docs](https://listmonk.app)",
+			`<p><a href="https://listmonk.app">docs</a></p>`},
+		{"image", "![logo](/logo.png)",
+			`<p><img src="/logo.png" alt="logo" style="max-width:100%;" /></p>`},
+		{"script link", "[click](javascript:void)",
+			`<p>click</p>`},
+		{"script image", "![x](JAVASCRIPT:void)",
+			`<p>x</p>`},
+		{"quote in URL", `[x](https://a.com/"onmouseover="alert(1))`,
+			`<p><a href="https://a.com/&#34;onmouseover=&#34;alert(1">x</a>)</p>`},
+		{"raw HTML", `<a href="javascript:alert(1)">x</a>`,
+			`<p>&lt;a href=&#34;javascript:alert(1)&#34;&gt;x&lt;/a&gt;</p>`},
+	}
+
+	for _, c := range cases {
+		if got := Render(c.in); got != c.out+"\n" {
+			t.Errorf("%s:\ngot  %s\nwant %s", c.name, got, c.out)
+		}
+	}
+}
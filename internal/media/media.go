@@ -23,4 +23,5 @@ type Store interface {
 	Put(string, string, io.ReadSeeker) (string, error)
 	Delete(string) error
 	Get(string) string
+	GetBlob(string) ([]byte, error)
 }
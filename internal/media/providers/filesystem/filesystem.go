@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -71,6 +72,11 @@ func (c *Client) Get(name string) string {
 	return fmt.Sprintf("%s%s/%s", c.opts.RootURL, c.opts.UploadURI, name)
 }
 
+// GetBlob accepts a filename and reads the file's contents from disk.
+func (c *Client) GetBlob(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(getDir(c.opts.UploadPath), filepath.Base(name)))
+}
+
 // Delete accepts a filename and removes it from disk.
 func (c *Client) Delete(file string) error {
 	dir := getDir(c.opts.UploadPath)
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"strings"
 	"time"
 
@@ -13,6 +15,9 @@ import (
 
 const amznS3PublicURL = "https://%s.s3.%s.amazonaws.com%s"
 
+// Timeout of downloading a file.
+const getBlobTimeout = time.Minute
+
 // Opts represents AWS S3 specific params
 type Opts struct {
 	AccessKey  string        `koanf:"aws_access_key_id"`
@@ -29,6 +34,7 @@ type Opts struct {
 type Client struct {
 	s3   *simples3.S3
 	opts Opts
+	hc   *http.Client
 }
 
 // NewS3Store initialises store for S3 provider. It takes in the AWS configuration
@@ -52,6 +58,7 @@ func NewS3Store(opts Opts) (media.Store, error) {
 	return &Client{
 		s3:   s3svc,
 		opts: opts,
+		hc:   &http.Client{Timeout: getBlobTimeout},
 	}, nil
 }
 
@@ -99,6 +106,21 @@ func (c *Client) Get(name string) string {
 	return url
 }
 
+// GetBlob accepts the filename of the object stored and downloads
+// its contents from S3 over its (optionally pre-signed) URL.
+func (c *Client) GetBlob(name string) ([]byte, error) {
+	resp, err := c.hc.Get(c.Get(name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-OK response from S3: %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
 // Delete accepts the filename of the object and deletes from S3.
 func (c *Client) Delete(name string) error {
 	err := c.s3.FileDelete(simples3.DeleteInput{
@@ -3,12 +3,17 @@ package email
 import (
 	"crypto/tls"
 	"fmt"
-	"math/rand"
 	"net/smtp"
 	"net/textproto"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jaytaylor/html2text"
+	"github.com/knadh/listmonk/internal/dkim"
+	"github.com/knadh/listmonk/internal/mailsign"
 	"github.com/knadh/listmonk/internal/messenger"
+	"github.com/knadh/listmonk/internal/ratelimit"
 	"github.com/knadh/smtppool"
 )
 
@@ -24,22 +29,51 @@ type Server struct {
 	TLSSkipVerify bool              `json:"tls_skip_verify"`
 	EmailHeaders  map[string]string `json:"email_headers"`
 
+	// Weight is the relative share of messages sent via the server when
+	// there are multiple servers. Default is 1.
+	Weight int `json:"weight"`
+
+	// MessageRate and HourlyLimit optionally cap the number of messages
+	// sent via the server per second and per hour. 0 is unlimited.
+	MessageRate int `json:"message_rate"`
+	HourlyLimit int `json:"hourly_limit"`
+
+	// DKIM optionally signs messages from the domains that it has keys for.
+	// Signed messages are sent via a separate connection pool as smtppool
+	// builds the MIME message while sending.
+	DKIM *dkim.Signer `json:"-"`
+
+	// Signer optionally S/MIME or PGP signs messages from the senders that
+	// it has keys for. Like DKIM signed messages, they're sent via the
+	// separate connection pool.
+	Signer *mailsign.Signer `json:"-"`
+
 	// Rest of the options are embedded directly from the smtppool lib.
 	// The JSON tag is for config unmarshal to work.
 	smtppool.Opt `json:",squash"`
 
-	pool *smtppool.Pool
+	pool   *smtppool.Pool
+	raw    *rawPool
+	health *health
+	limit  *ratelimit.Limiter
+
+	// Number of connections that are sending messages.
+	active int32
 }
 
 // Emailer is the SMTP e-mail messenger.
 type Emailer struct {
 	servers []*Server
+
+	closeOnce sync.Once
+	quit      chan bool
 }
 
 // New returns an SMTP e-mail Messenger backend with a the given SMTP servers.
 func New(servers ...Server) (*Emailer, error) {
 	e := &Emailer{
 		servers: make([]*Server, 0, len(servers)),
+		quit:    make(chan bool),
 	}
 
 	for _, srv := range servers {
@@ -73,6 +107,13 @@ func New(servers ...Server) (*Emailer, error) {
 			return nil, err
 		}
 
+		if s.Weight < 1 {
+			s.Weight = 1
+		}
+		s.health = &health{healthy: true}
+		s.raw = newRawPool(s.MaxConns)
+		s.limit = ratelimit.New(s.MessageRate, s.HourlyLimit)
+
 		s.pool = pool
 		e.servers = append(e.servers, &s)
 	}
@@ -87,18 +128,54 @@ func (e *Emailer) Name() string {
 
 // Push pushes a message to the server.
 func (e *Emailer) Push(m messenger.Message) error {
-	// If there are more than one SMTP servers, send to a random
-	// one from the list.
-	var (
-		ln  = len(e.servers)
-		srv *Server
-	)
-	if ln > 1 {
-		srv = e.servers[rand.Intn(ln)]
+	// If there are more than one SMTP servers, send to a healthy one
+	// picked randomly by weight. If all the servers are at their rate
+	// limits, wait till one is available.
+	var srv *Server
+	for {
+		s, wait := e.pickServer()
+		if wait == 0 {
+			srv = s
+			break
+		}
+		time.Sleep(wait)
+	}
+
+	em, err := MakeEmail(m, srv.EmailFormat, srv.EmailHeaders)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt32(&srv.active, 1)
+	if srv.signs(m.From) {
+		err = srv.sendSigned(em)
 	} else {
-		srv = e.servers[0]
+		err = srv.pool.Send(em)
 	}
+	atomic.AddInt32(&srv.active, -1)
+	if err != nil {
+		srv.health.recordSend(err)
+		return err
+	}
+	srv.health.recordSend(nil)
+	return nil
+}
 
+// Conns returns the number of connections of all the servers that are
+// sending messages and the maximum number of connections.
+func (e *Emailer) Conns() (int, int) {
+	var active, max int
+	for _, s := range e.servers {
+		active += int(atomic.LoadInt32(&s.active))
+		max += s.MaxConns
+	}
+	return active, max
+}
+
+// MakeEmail makes an e-mail with HTML and/or plain text bodies
+// (format = html|plain|both) from a message. The headers are added to the
+// message's headers.
+func MakeEmail(m messenger.Message, format string, headers map[string]string) (smtppool.Email, error) {
 	// Are there attachments?
 	var files []smtppool.Attachment
 	if m.Attachments != nil {
@@ -117,13 +194,14 @@ func (e *Emailer) Push(m messenger.Message) error {
 	mtext, err := html2text.FromString(string(m.Body),
 		html2text.Options{PrettyTables: true})
 	if err != nil {
-		return err
+		return smtppool.Email{}, err
 	}
 
 	em := smtppool.Email{
 		From:        m.From,
 		To:          m.To,
 		Subject:     m.Subject,
+		Sender:      m.Sender,
 		Attachments: files,
 	}
 
@@ -133,14 +211,12 @@ func (e *Emailer) Push(m messenger.Message) error {
 		em.Headers = m.Headers
 	}
 
-	// Attach SMTP level headers.
-	if len(srv.EmailHeaders) > 0 {
-		for k, v := range srv.EmailHeaders {
-			em.Headers.Set(k, v)
-		}
+	// Attach server level headers.
+	for k, v := range headers {
+		em.Headers.Set(k, v)
 	}
 
-	switch srv.EmailFormat {
+	switch format {
 	case "html":
 		em.HTML = m.Body
 	case "plain":
@@ -150,7 +226,7 @@ func (e *Emailer) Push(m messenger.Message) error {
 		em.Text = []byte(mtext)
 	}
 
-	return srv.pool.Send(em)
+	return em, nil
 }
 
 // Flush flushes the message queue to the server.
@@ -158,10 +234,14 @@ func (e *Emailer) Flush() error {
 	return nil
 }
 
-// Close closes the SMTP pools.
+// Close stops the health checks and closes the SMTP pools.
 func (e *Emailer) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.quit)
+	})
 	for _, s := range e.servers {
 		s.pool.Close()
+		s.raw.close()
 	}
 	return nil
 }
@@ -0,0 +1,280 @@
+package email
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/knadh/listmonk/internal/messenger"
+)
+
+// Number of consecutive failed sends after which a server is marked
+// unhealthy till the next successful health check.
+const maxSendFailures = 5
+
+// ServerStatus represents the health and usage of an SMTP server.
+type ServerStatus struct {
+	Host        string    `json:"host"`
+	Port        int       `json:"port"`
+	Username    string    `json:"username"`
+	Weight      int       `json:"weight"`
+	MessageRate int       `json:"message_rate"`
+	HourlyLimit int       `json:"hourly_limit"`
+	Healthy     bool      `json:"healthy"`
+	LastChecked time.Time `json:"last_checked"`
+	LastError   string    `json:"last_error"`
+	Sent        int       `json:"sent"`
+	Failed      int       `json:"failed"`
+	ActiveConns int       `json:"active_conns"`
+	MaxConns    int       `json:"max_conns"`
+}
+
+// TestResult is the result of a live connectivity test of an SMTP server.
+type TestResult struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error"`
+
+	// Duration of the test in milliseconds.
+	Duration int64 `json:"duration"`
+}
+
+// health tracks the health of an SMTP server from periodic checks and
+// the results of sends.
+type health struct {
+	sync.RWMutex
+
+	healthy     bool
+	lastChecked time.Time
+	lastErr     string
+	sendErrs    int
+	sent        int
+	failed      int
+}
+
+// recordSend records the result of a send. A server is marked unhealthy
+// after consecutive failures. Greylisting replies are about the recipient
+// and not the server, and don't count towards the failures.
+func (h *health) recordSend(err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if err == nil {
+		h.sent++
+		h.sendErrs = 0
+		return
+	}
+
+	h.failed++
+	h.lastErr = err.Error()
+	if messenger.IsGreylisted(err) {
+		return
+	}
+	h.sendErrs++
+	if h.sendErrs >= maxSendFailures {
+		h.healthy = false
+	}
+}
+
+// recordCheck records the result of a health check.
+func (h *health) recordCheck(err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.lastChecked = time.Now()
+	if err != nil {
+		h.healthy = false
+		h.lastErr = err.Error()
+		return
+	}
+	h.healthy = true
+	h.sendErrs = 0
+}
+
+func (h *health) isHealthy() bool {
+	h.RLock()
+	defer h.RUnlock()
+	return h.healthy
+}
+
+// RunHealthChecks checks the connectivity of all the servers at the given
+// interval in the background till the Emailer is closed. Unhealthy servers
+// are skipped while sending.
+func (e *Emailer) RunHealthChecks(interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				for _, s := range e.servers {
+					s.health.recordCheck(s.check())
+				}
+			case <-e.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Test connects to all the servers and runs through the SMTP handshake
+// (HELO, STARTTLS, AUTH). If probe isn't nil, it's sent via every server
+// that passes the handshake. The results are recorded as health checks.
+func (e *Emailer) Test(probe *messenger.Message) []TestResult {
+	var (
+		out = make([]TestResult, len(e.servers))
+		wg  sync.WaitGroup
+	)
+	for i, s := range e.servers {
+		wg.Add(1)
+		go func(i int, s *Server) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := s.test(probe)
+			s.health.recordCheck(err)
+
+			out[i] = TestResult{
+				Host:     s.Host,
+				Port:     s.Port,
+				Username: s.Username,
+				OK:       err == nil,
+				Duration: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				out[i].Error = err.Error()
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	return out
+}
+
+// Status returns the health and usage of the servers.
+func (e *Emailer) Status() []ServerStatus {
+	out := make([]ServerStatus, 0, len(e.servers))
+	for _, s := range e.servers {
+		s.health.RLock()
+		out = append(out, ServerStatus{
+			Host:        s.Host,
+			Port:        s.Port,
+			Username:    s.Username,
+			Weight:      s.Weight,
+			MessageRate: s.MessageRate,
+			HourlyLimit: s.HourlyLimit,
+			Healthy:     s.health.healthy,
+			LastChecked: s.health.lastChecked,
+			LastError:   s.health.lastErr,
+			Sent:        s.health.sent,
+			Failed:      s.health.failed,
+			ActiveConns: int(atomic.LoadInt32(&s.active)),
+			MaxConns:    s.MaxConns,
+		})
+		s.health.RUnlock()
+	}
+	return out
+}
+
+// pickServer picks a random healthy server by weight that is within its
+// rate limits and counts a message against its limits. If no server is
+// healthy, all servers are considered so that sends are still attempted.
+// If all the servers are at their limits, no server is picked and the
+// duration to wait before trying again is returned.
+func (e *Emailer) pickServer() (*Server, time.Duration) {
+	srvs := make([]*Server, 0, len(e.servers))
+	for _, s := range e.servers {
+		if s.health.isHealthy() {
+			srvs = append(srvs, s)
+		}
+	}
+	if len(srvs) == 0 {
+		srvs = e.servers
+	}
+
+	var (
+		avail = make([]*Server, 0, len(srvs))
+		total = 0
+		wait  time.Duration
+	)
+	for _, s := range srvs {
+		d := s.limit.Next()
+		if d == 0 {
+			avail = append(avail, s)
+			total += s.Weight
+			continue
+		}
+		if wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	if len(avail) == 0 {
+		return nil, wait
+	}
+
+	srv := avail[len(avail)-1]
+	n := rand.Intn(total)
+	for _, s := range avail {
+		if n < s.Weight {
+			srv = s
+			break
+		}
+		n -= s.Weight
+	}
+
+	// Another worker may have used up the server's limit since the check.
+	if d := srv.limit.Reserve(); d > 0 {
+		return nil, time.Millisecond
+	}
+	return srv, 0
+}
+
+// check connects to the server and runs through the SMTP handshake
+// (HELO, STARTTLS, AUTH) without sending a message.
+func (s *Server) check() error {
+	cl, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	return cl.Quit()
+}
+
+// test connects to the server, runs through the SMTP handshake, and
+// optionally sends a probe message on the same connection.
+func (s *Server) test(probe *messenger.Message) error {
+	cl, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	if probe != nil {
+		em, err := MakeEmail(*probe, s.EmailFormat, s.EmailHeaders)
+		if err != nil {
+			return err
+		}
+		msg, err := em.Bytes()
+		if err != nil {
+			return err
+		}
+		if msg, err = s.sign(probe.From, msg); err != nil {
+			return err
+		}
+
+		from, to, err := Envelope(em)
+		if err != nil {
+			return err
+		}
+		c := &rawConn{cl: cl}
+		if err := c.send(from, to, msg); err != nil {
+			return err
+		}
+	}
+
+	return cl.Quit()
+}
@@ -0,0 +1,252 @@
+package email
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/knadh/smtppool"
+)
+
+// Default timeout for connecting to a server when the server has no wait
+// timeout.
+const defaultDialTimeout = time.Second * 10
+
+// rawPool is a pool of SMTP connections for sending pre-built (DKIM
+// signed) messages.
+type rawPool struct {
+	conns chan *rawConn
+	sem   chan struct{}
+}
+
+type rawConn struct {
+	cl       *smtp.Client
+	lastUsed time.Time
+}
+
+func newRawPool(maxConns int) *rawPool {
+	if maxConns < 1 {
+		maxConns = 1
+	}
+	return &rawPool{
+		conns: make(chan *rawConn, maxConns),
+		sem:   make(chan struct{}, maxConns),
+	}
+}
+
+// signs checks whether messages from the given address are signed.
+func (s *Server) signs(from string) bool {
+	return (s.DKIM != nil && s.DKIM.HasKey(from)) || (s.Signer != nil && s.Signer.HasKey(from))
+}
+
+// sign S/MIME or PGP signs and then DKIM signs a raw message from the given
+// address with the keys that the server has.
+func (s *Server) sign(from string, msg []byte) ([]byte, error) {
+	var err error
+	if s.Signer != nil {
+		if msg, err = s.Signer.Sign(from, msg); err != nil {
+			return nil, err
+		}
+	}
+	if s.DKIM != nil {
+		if msg, err = s.DKIM.Sign(msg); err != nil {
+			return nil, fmt.Errorf("error DKIM signing message: %v", err)
+		}
+	}
+	return msg, nil
+}
+
+// sendSigned builds, signs, and sends an e-mail. Failed sends are retried
+// on a new connection.
+func (s *Server) sendSigned(em smtppool.Email) error {
+	b, err := em.Bytes()
+	if err != nil {
+		return err
+	}
+	msg, err := s.sign(em.From, b)
+	if err != nil {
+		return err
+	}
+
+	from, to, err := Envelope(em)
+	if err != nil {
+		return err
+	}
+
+	retries := s.MaxMessageRetries
+	if retries < 1 {
+		retries = 1
+	}
+	for n := 1; ; n++ {
+		err = s.sendRaw(from, to, msg)
+		if err == nil || n >= retries {
+			return err
+		}
+	}
+}
+
+// Envelope returns the envelope sender and recipient addresses of an e-mail.
+// The sender is the e-mail's Sender, if it's set, or From.
+func Envelope(em smtppool.Email) (string, []string, error) {
+	sender := em.From
+	if em.Sender != "" {
+		sender = em.Sender
+	}
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return "", nil, err
+	}
+	to := make([]string, 0, len(em.To))
+	for _, t := range em.To {
+		a, err := mail.ParseAddress(t)
+		if err != nil {
+			return "", nil, err
+		}
+		to = append(to, a.Address)
+	}
+	return from.Address, to, nil
+}
+
+// sendRaw sends a raw message using a pooled connection.
+func (s *Server) sendRaw(from string, to []string, msg []byte) error {
+	s.raw.sem <- struct{}{}
+	defer func() { <-s.raw.sem }()
+
+	c, err := s.getRawConn()
+	if err != nil {
+		return err
+	}
+
+	if err := c.send(from, to, msg); err != nil {
+		c.cl.Close()
+		return err
+	}
+
+	c.lastUsed = time.Now()
+	select {
+	case s.raw.conns <- c:
+	default:
+		c.cl.Quit()
+	}
+	return nil
+}
+
+// getRawConn returns an idle connection from the pool or a new one.
+// Connections that have been idle for longer than the idle timeout
+// are closed.
+func (s *Server) getRawConn() (*rawConn, error) {
+	for {
+		select {
+		case c := <-s.raw.conns:
+			if s.IdleTimeout > 0 && time.Since(c.lastUsed) > s.IdleTimeout {
+				c.cl.Close()
+				continue
+			}
+			if err := c.cl.Reset(); err != nil {
+				c.cl.Close()
+				continue
+			}
+			return c, nil
+		default:
+			cl, err := s.dial()
+			if err != nil {
+				return nil, err
+			}
+			return &rawConn{cl: cl}, nil
+		}
+	}
+}
+
+func (c *rawConn) send(from string, to []string, msg []byte) error {
+	if err := c.cl.Mail(from); err != nil {
+		return err
+	}
+	for _, t := range to {
+		if err := c.cl.Rcpt(t); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.cl.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (p *rawPool) close() {
+	for {
+		select {
+		case c := <-p.conns:
+			c.cl.Quit()
+		default:
+			return
+		}
+	}
+}
+
+// dial connects to the server and runs through the SMTP handshake
+// (HELO, STARTTLS, AUTH).
+func (s *Server) dial() (*smtp.Client, error) {
+	timeout := s.PoolWaitTimeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// The deadline applies to the handshake and is cleared after it.
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	cl, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := s.handshake(cl, addr); err != nil {
+		cl.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return cl, nil
+}
+
+func (s *Server) handshake(cl *smtp.Client, addr string) error {
+	if s.HelloHostname != "" {
+		if err := cl.Hello(s.HelloHostname); err != nil {
+			return err
+		}
+	}
+
+	if s.TLSConfig != nil {
+		if ok, _ := cl.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("STARTTLS not supported by %s", addr)
+		}
+		if err := cl.StartTLS(s.TLSConfig.Clone()); err != nil {
+			return err
+		}
+	}
+
+	if s.Auth != nil {
+		if ok, _ := cl.Extension("AUTH"); !ok {
+			return fmt.Errorf("AUTH not supported by %s", addr)
+		}
+		if err := cl.Auth(s.Auth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
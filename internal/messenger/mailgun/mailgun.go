@@ -0,0 +1,252 @@
+// Package mailgun is a messenger that sends e-mails via the Mailgun HTTP
+// API instead of SMTP.
+package mailgun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/dkim"
+	"github.com/knadh/listmonk/internal/mailsign"
+	"github.com/knadh/listmonk/internal/messenger"
+	"github.com/knadh/listmonk/internal/messenger/email"
+)
+
+// Mailgun allows a maximum of three tags per message.
+const maxTags = 3
+
+// API hosts by region.
+var regionURLs = map[string]string{
+	"":   "https://api.mailgun.net",
+	"us": "https://api.mailgun.net",
+	"eu": "https://api.eu.mailgun.net",
+}
+
+// Mailgun error descriptions by HTTP status.
+var mgErrors = map[int]string{
+	http.StatusBadRequest:            "bad request",
+	http.StatusUnauthorized:          "invalid API key",
+	http.StatusPaymentRequired:       "request failed",
+	http.StatusForbidden:             "forbidden (is the domain verified and active?)",
+	http.StatusNotFound:              "domain not found (is the region correct?)",
+	http.StatusRequestEntityTooLarge: "message too large",
+	http.StatusTooManyRequests:       "rate limited",
+}
+
+// Options represents Mailgun messenger options.
+type Options struct {
+	Name   string `json:"name"`
+	APIKey string `json:"api_key"`
+
+	// Sending domain and its region (us or eu).
+	Domain string `json:"domain"`
+	Region string `json:"region"`
+
+	// Tags are added to every message along with the campaign's tags.
+	Tags []string `json:"tags"`
+
+	EmailFormat string `json:"email_format"`
+
+	MaxConns int           `json:"max_conns"`
+	Timeout  time.Duration `json:"timeout"`
+
+	// DKIM optionally signs messages from the domains that it has keys for.
+	DKIM *dkim.Signer `json:"-"`
+
+	// Signer optionally S/MIME or PGP signs messages from the senders
+	// that it has keys for.
+	Signer *mailsign.Signer `json:"-"`
+}
+
+// Mailgun is the Mailgun API messenger.
+type Mailgun struct {
+	o   Options
+	url string
+	c   *http.Client
+}
+
+// New returns a new instance of the Mailgun messenger.
+func New(o Options) (*Mailgun, error) {
+	if o.APIKey == "" || o.Domain == "" {
+		return nil, fmt.Errorf("no API key or domain for Mailgun messenger %s", o.Name)
+	}
+	root, ok := regionURLs[strings.ToLower(o.Region)]
+	if !ok {
+		return nil, fmt.Errorf("unknown Mailgun region '%s'", o.Region)
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 10
+	}
+
+	return &Mailgun{
+		o:   o,
+		url: fmt.Sprintf("%s/v3/%s/messages.mime", root, o.Domain),
+		c: &http.Client{
+			Timeout: o.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   o.MaxConns,
+				MaxConnsPerHost:       o.MaxConns,
+				ResponseHeaderTimeout: o.Timeout,
+				IdleConnTimeout:       o.Timeout,
+			},
+		},
+	}, nil
+}
+
+// Name returns the messenger's name.
+func (g *Mailgun) Name() string {
+	return g.o.Name
+}
+
+// Push sends a message as MIME with the Mailgun API. Rate limit and
+// server errors are retried.
+func (g *Mailgun) Push(m messenger.Message) error {
+	em, err := email.MakeEmail(m, g.o.EmailFormat, nil)
+	if err != nil {
+		return err
+	}
+	raw, err := em.Bytes()
+	if err != nil {
+		return err
+	}
+	if g.o.Signer != nil {
+		if raw, err = g.o.Signer.Sign(m.From, raw); err != nil {
+			return err
+		}
+	}
+	if g.o.DKIM != nil {
+		if raw, err = g.o.DKIM.Sign(raw); err != nil {
+			return fmt.Errorf("error DKIM signing message: %v", err)
+		}
+	}
+
+	var (
+		body bytes.Buffer
+		w    = multipart.NewWriter(&body)
+	)
+	for _, to := range m.To {
+		w.WriteField("to", to)
+	}
+	for _, t := range g.tags(m) {
+		w.WriteField("o:tag", t)
+	}
+
+	// Custom variables are posted back in Mailgun webhooks.
+	if m.Subscriber.UUID != "" {
+		w.WriteField("v:subscriber_uuid", m.Subscriber.UUID)
+	}
+	if m.Campaign != nil {
+		w.WriteField("v:campaign_uuid", m.Campaign.UUID)
+	}
+
+	f, err := w.CreateFormFile("message", "message.mime")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return g.exec(body.Bytes(), w.FormDataContentType())
+}
+
+// Flush flushes the message queue to the server.
+func (g *Mailgun) Flush() error {
+	return nil
+}
+
+// Close closes idle HTTP connections.
+func (g *Mailgun) Close() error {
+	g.c.CloseIdleConnections()
+	return nil
+}
+
+func (g *Mailgun) exec(body []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPost, g.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("api", g.o.APIKey)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "listmonk")
+
+	r, err := g.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode == http.StatusOK {
+		return nil
+	}
+	return parseError(r.StatusCode, b)
+}
+
+// tags returns the configured tags and the campaign's tags within
+// Mailgun's limit.
+func (g *Mailgun) tags(m messenger.Message) []string {
+	var (
+		out  = make([]string, 0, maxTags)
+		seen = make(map[string]bool)
+		all  = g.o.Tags
+	)
+	if m.Campaign != nil {
+		all = append(append([]string{}, all...), m.Campaign.Tags...)
+	}
+
+	for _, t := range all {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+		if len(out) == maxTags {
+			break
+		}
+	}
+	return out
+}
+
+// Error represents an error returned by the Mailgun API.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	desc, ok := mgErrors[e.Status]
+	if !ok {
+		desc = "error"
+	}
+	return fmt.Sprintf("Mailgun %s (HTTP %d): %s", desc, e.Status, e.Message)
+}
+
+// Temporary tells whether the request can be retried.
+func (e *Error) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError
+}
+
+// parseError parses the JSON error response of the Mailgun API.
+func parseError(status int, b []byte) error {
+	var r struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil || r.Message == "" {
+		return &Error{Status: status, Message: strings.TrimSpace(string(b))}
+	}
+	return &Error{Status: status, Message: r.Message}
+}
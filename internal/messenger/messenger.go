@@ -15,6 +15,13 @@ type Messenger interface {
 	Close() error
 }
 
+// ConnCounter is optionally implemented by messengers that keep pools of
+// connections to report the number of connections that are in use and the
+// maximum number of connections.
+type ConnCounter interface {
+	Conns() (active, max int)
+}
+
 // Message is the message pushed to a Messenger.
 type Message struct {
 	From        string
@@ -25,6 +32,10 @@ type Message struct {
 	Headers     textproto.MIMEHeader
 	Attachments []Attachment
 
+	// Sender is the optional envelope sender (Return-Path) of the message,
+	// for instance, a VERP address. If it's empty, From is used.
+	Sender string
+
 	Subscriber models.Subscriber
 
 	// Campaign is generally the same instance for a large number of subscribers.
@@ -39,15 +39,33 @@ type recipient struct {
 
 // Options represents HTTP Postback server options.
 type Options struct {
-	Name     string        `json:"name"`
-	Username string        `json:"username"`
-	Password string        `json:"password"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// AuthHeader is an optional raw Authorization header value,
+	// eg: "Bearer xxx". It takes precedence over BasicAuth.
+	AuthHeader string `json:"auth_header"`
+
 	RootURL  string        `json:"root_url"`
 	MaxConns int           `json:"max_conns"`
-	Retries  int           `json:"retries"`
 	Timeout  time.Duration `json:"timeout"`
 }
 
+// httpError is a non-2xx response from the Postback server.
+type httpError struct {
+	status int
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("non-OK response from Postback server: %d", e.status)
+}
+
+// Temporary tells whether the request can be retried.
+func (e *httpError) Temporary() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= http.StatusInternalServerError
+}
+
 // Postback represents an HTTP Message server.
 type Postback struct {
 	authStr string
@@ -58,7 +76,9 @@ type Postback struct {
 // New returns a new instance of the HTTP Postback messenger.
 func New(o Options) (*Postback, error) {
 	authStr := ""
-	if o.Username != "" && o.Password != "" {
+	if o.AuthHeader != "" {
+		authStr = o.AuthHeader
+	} else if o.Username != "" && o.Password != "" {
 		authStr = fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString(
 			[]byte(o.Username+":"+o.Password)))
 	}
@@ -148,7 +168,7 @@ func (p *Postback) exec(method, rURL string, reqBody []byte, headers http.Header
 	}
 	req.Header.Set("User-Agent", "listmonk")
 
-	// Optional BasicAuth.
+	// Optional BasicAuth or custom auth header.
 	if p.authStr != "" {
 		req.Header.Set("Authorization", p.authStr)
 	}
@@ -175,8 +195,8 @@ func (p *Postback) exec(method, rURL string, reqBody []byte, headers http.Header
 		r.Body.Close()
 	}()
 
-	if r.StatusCode != http.StatusOK {
-		return fmt.Errorf("non-OK response from Postback server: %d", r.StatusCode)
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return &httpError{status: r.StatusCode}
 	}
 
 	return nil
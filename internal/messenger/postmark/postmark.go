@@ -0,0 +1,253 @@
+// Package postmark is a messenger that sends e-mails via the Postmark API.
+// Campaign messages go to the broadcast message stream and other messages
+// (transactional, notifications) to the transactional stream.
+package postmark
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jaytaylor/html2text"
+	"github.com/knadh/listmonk/internal/messenger"
+)
+
+const (
+	apiURL = "https://api.postmarkapp.com/email"
+
+	defaultBroadcastStream = "broadcast"
+	defaultTxStream        = "outbound"
+)
+
+// Postmark API error descriptions by error code.
+var pmErrors = map[int]string{
+	10:   "invalid server token",
+	300:  "invalid e-mail request",
+	400:  "sender signature not found",
+	401:  "sender signature not confirmed",
+	405:  "not allowed to send (out of credits?)",
+	406:  "recipient is inactive (bounced, spam complaint or unsubscribed)",
+	412:  "account pending approval",
+	413:  "account suspended",
+	1235: "message stream not found",
+}
+
+// Options represents Postmark messenger options.
+type Options struct {
+	Name string `json:"name"`
+
+	// Message stream and server token for campaigns.
+	BroadcastStream string `json:"broadcast_stream"`
+	BroadcastToken  string `json:"broadcast_token"`
+
+	// Message stream and server token for transactional and other
+	// messages. The broadcast token is used if there's no token.
+	TxStream string `json:"tx_stream"`
+	TxToken  string `json:"tx_token"`
+
+	EmailFormat string `json:"email_format"`
+
+	MaxConns int           `json:"max_conns"`
+	Timeout  time.Duration `json:"timeout"`
+}
+
+// Postmark is the Postmark API messenger.
+type Postmark struct {
+	o Options
+	c *http.Client
+}
+
+type message struct {
+	From          string            `json:"From"`
+	To            string            `json:"To"`
+	Subject       string            `json:"Subject"`
+	HTMLBody      string            `json:"HtmlBody,omitempty"`
+	TextBody      string            `json:"TextBody,omitempty"`
+	Headers       []header          `json:"Headers,omitempty"`
+	Metadata      map[string]string `json:"Metadata,omitempty"`
+	MessageStream string            `json:"MessageStream"`
+	Attachments   []attachment      `json:"Attachments,omitempty"`
+}
+
+type header struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type attachment struct {
+	Name        string `json:"Name"`
+	Content     string `json:"Content"`
+	ContentType string `json:"ContentType"`
+}
+
+// New returns a new instance of the Postmark messenger.
+func New(o Options) (*Postmark, error) {
+	if o.BroadcastToken == "" && o.TxToken == "" {
+		return nil, fmt.Errorf("no server token for Postmark messenger %s", o.Name)
+	}
+	if o.TxToken == "" {
+		o.TxToken = o.BroadcastToken
+	}
+	if o.BroadcastToken == "" {
+		o.BroadcastToken = o.TxToken
+	}
+	if o.BroadcastStream == "" {
+		o.BroadcastStream = defaultBroadcastStream
+	}
+	if o.TxStream == "" {
+		o.TxStream = defaultTxStream
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 10
+	}
+
+	return &Postmark{
+		o: o,
+		c: &http.Client{
+			Timeout: o.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   o.MaxConns,
+				MaxConnsPerHost:       o.MaxConns,
+				ResponseHeaderTimeout: o.Timeout,
+				IdleConnTimeout:       o.Timeout,
+			},
+		},
+	}, nil
+}
+
+// Name returns the messenger's name.
+func (p *Postmark) Name() string {
+	return p.o.Name
+}
+
+// Push sends a message to the stream for its type. Rate limit and server
+// errors are retried.
+func (p *Postmark) Push(m messenger.Message) error {
+	msg := message{
+		From:     m.From,
+		To:       strings.Join(m.To, ","),
+		Subject:  m.Subject,
+		Metadata: make(map[string]string),
+	}
+
+	// Campaigns go to the broadcast stream.
+	token := p.o.TxToken
+	msg.MessageStream = p.o.TxStream
+	if m.Campaign != nil {
+		token = p.o.BroadcastToken
+		msg.MessageStream = p.o.BroadcastStream
+		msg.Metadata["campaign_uuid"] = m.Campaign.UUID
+	}
+	if m.Subscriber.UUID != "" {
+		msg.Metadata["subscriber_uuid"] = m.Subscriber.UUID
+	}
+
+	if p.o.EmailFormat != "plain" {
+		msg.HTMLBody = string(m.Body)
+	}
+	if p.o.EmailFormat != "html" {
+		txt, err := html2text.FromString(string(m.Body), html2text.Options{PrettyTables: true})
+		if err != nil {
+			return err
+		}
+		msg.TextBody = txt
+	}
+
+	for k, v := range m.Headers {
+		for _, val := range v {
+			msg.Headers = append(msg.Headers, header{Name: k, Value: val})
+		}
+	}
+
+	for _, a := range m.Attachments {
+		ct := a.Header.Get("Content-Type")
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		msg.Attachments = append(msg.Attachments, attachment{
+			Name:        a.Name,
+			Content:     base64.StdEncoding.EncodeToString(a.Content),
+			ContentType: ct,
+		})
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return p.exec(b, token)
+}
+
+// Flush flushes the message queue to the server.
+func (p *Postmark) Flush() error {
+	return nil
+}
+
+// Close closes idle HTTP connections.
+func (p *Postmark) Close() error {
+	p.c.CloseIdleConnections()
+	return nil
+}
+
+func (p *Postmark) exec(body []byte, token string) error {
+	req, err := http.NewRequest(http.MethodPost, apiURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Postmark-Server-Token", token)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "listmonk")
+
+	r, err := p.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode == http.StatusOK {
+		return nil
+	}
+	return parseError(r.StatusCode, b)
+}
+
+// Error represents an error returned by the Postmark API.
+type Error struct {
+	Status  int
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if desc, ok := pmErrors[e.Code]; ok {
+		return fmt.Sprintf("Postmark: %s (%d): %s", desc, e.Code, e.Message)
+	}
+	return fmt.Sprintf("Postmark error (HTTP %d, code %d): %s", e.Status, e.Code, e.Message)
+}
+
+// Temporary tells whether the request can be retried.
+func (e *Error) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError
+}
+
+// parseError parses the JSON error response of the Postmark API.
+func parseError(status int, b []byte) error {
+	var r struct {
+		ErrorCode int    `json:"ErrorCode"`
+		Message   string `json:"Message"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return &Error{Status: status, Message: strings.TrimSpace(string(b))}
+	}
+	return &Error{Status: status, Code: r.ErrorCode, Message: r.Message}
+}
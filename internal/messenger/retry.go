@@ -0,0 +1,116 @@
+package messenger
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = time.Minute
+)
+
+// Phrases in the SMTP replies of servers that greylist messages.
+var greylistReplies = []string{
+	"greylist", "graylist", "grey-list", "gray-list", "grey list", "gray list",
+	"try again later", "retry later", "please retry", "temporarily deferred",
+}
+
+// Retry is the policy for retrying failed pushes of a message. Only
+// transient errors are retried. The wait between attempts starts at Backoff
+// and doubles with every attempt up to MaxBackoff.
+type Retry struct {
+	// MaxAttempts is the maximum number of attempts for a message,
+	// including the first one. Default is 1.
+	MaxAttempts int           `json:"max_msg_retries"`
+	Backoff     time.Duration `json:"retry_backoff"`
+	MaxBackoff  time.Duration `json:"retry_max_backoff"`
+}
+
+// Do calls fn till it succeeds, returns a permanent error, or the attempts
+// are exhausted. It returns the number of attempts made and the last error.
+func (r Retry) Do(fn func() error) (int, error) {
+	for n := 1; ; n++ {
+		err := fn()
+		if err == nil || n >= r.MaxAttempts || !IsTemporary(err) {
+			return n, err
+		}
+
+		time.Sleep(r.Wait(n))
+	}
+}
+
+// Wait returns the duration to wait after the nth failed attempt.
+func (r Retry) Wait(n int) time.Duration {
+	var (
+		wait   = r.Backoff
+		maxDur = r.MaxBackoff
+	)
+	if wait <= 0 {
+		wait = defaultRetryBackoff
+	}
+	if maxDur <= 0 {
+		maxDur = defaultRetryMaxBackoff
+	}
+
+	for i := 1; i < n && wait < maxDur; i++ {
+		wait *= 2
+	}
+	if wait > maxDur {
+		wait = maxDur
+	}
+	return wait
+}
+
+// IsTemporary checks whether a push error is transient and the push can be
+// retried. Network errors, errors that report themselves as temporary
+// (eg: HTTP 429 and 5xx from messenger APIs), and SMTP 4xx replies are
+// transient.
+func IsTemporary(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var tErr interface{ Temporary() bool }
+	if errors.As(err, &tErr) {
+		return tErr.Temporary()
+	}
+
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 400 && smtpErr.Code < 500
+	}
+
+	return false
+}
+
+// IsGreylisted checks whether a push error is an SMTP 450 or 451 reply by a
+// server that greylists the message. Greylisted messages are accepted when
+// they're retried after the server's greylisting window, typically, a few
+// minutes, and not when they're retried immediately.
+func IsGreylisted(err error) bool {
+	var smtpErr *textproto.Error
+	if !errors.As(err, &smtpErr) || (smtpErr.Code != 450 && smtpErr.Code != 451) {
+		return false
+	}
+
+	msg := strings.ToLower(smtpErr.Msg)
+	for _, r := range greylistReplies {
+		if strings.Contains(msg, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package sendgrid
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// payload is the request body of the v3 Mail Send API.
+type payload struct {
+	Personalizations []personalization `json:"personalizations"`
+	From             address           `json:"from"`
+	Subject          string            `json:"subject"`
+	Content          []content         `json:"content"`
+	Attachments      []attachment      `json:"attachments,omitempty"`
+	Categories       []string          `json:"categories,omitempty"`
+}
+
+type personalization struct {
+	To         []address         `json:"to"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	CustomArgs map[string]string `json:"custom_args,omitempty"`
+}
+
+type address struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type content struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type attachment struct {
+	Content     string `json:"content"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+}
+
+// Error represents an error returned by the SendGrid API.
+type Error struct {
+	Status   int
+	Messages []string
+}
+
+type errorResp struct {
+	Errors []struct {
+		Message string `json:"message"`
+		Field   string `json:"field"`
+	} `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("SendGrid error (HTTP %d)", e.Status)
+	}
+	return fmt.Sprintf("SendGrid error (HTTP %d): %s", e.Status, strings.Join(e.Messages, "; "))
+}
+
+// Temporary tells whether the request can be retried.
+func (e *Error) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError
+}
+
+// parseError parses the JSON error response of the SendGrid API.
+func parseError(status int, b []byte) error {
+	var r errorResp
+	if err := json.Unmarshal(b, &r); err != nil {
+		return &Error{Status: status, Messages: []string{strings.TrimSpace(string(b))}}
+	}
+
+	e := &Error{Status: status}
+	for _, er := range r.Errors {
+		msg := er.Message
+		if er.Field != "" {
+			msg = er.Field + ": " + msg
+		}
+		e.Messages = append(e.Messages, msg)
+	}
+	return e
+}
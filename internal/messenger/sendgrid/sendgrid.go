@@ -0,0 +1,380 @@
+// Package sendgrid is a messenger that sends e-mails via the SendGrid v3
+// Mail Send API. Concurrently pushed messages with the same sender,
+// subject and body are batched into a single API request.
+package sendgrid
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaytaylor/html2text"
+	"github.com/knadh/listmonk/internal/messenger"
+)
+
+const (
+	defaultRootURL = "https://api.sendgrid.com"
+
+	// SendGrid's limits on the number of personalizations in a request and
+	// the number of categories in a message.
+	maxBatchSize  = 1000
+	maxCategories = 10
+)
+
+// Headers that can't be set as custom headers on SendGrid.
+var reservedHeaders = map[string]bool{
+	"to": true, "from": true, "subject": true, "cc": true, "bcc": true,
+	"reply-to": true, "content-type": true, "content-transfer-encoding": true,
+	"received": true, "dkim-signature": true, "x-sg-id": true, "x-sg-eid": true,
+}
+
+// Options represents SendGrid messenger options.
+type Options struct {
+	Name    string `json:"name"`
+	APIKey  string `json:"api_key"`
+	RootURL string `json:"root_url"`
+
+	// Categories are added to every message along with the campaign's tags.
+	Categories []string `json:"categories"`
+
+	EmailFormat string `json:"email_format"`
+
+	// Maximum number of messages in a batch and the maximum time to wait
+	// for a batch to fill up. A batch size of 1 disables batching.
+	BatchSize int           `json:"batch_size"`
+	BatchWait time.Duration `json:"batch_wait"`
+
+	MaxConns int           `json:"max_conns"`
+	Timeout  time.Duration `json:"timeout"`
+}
+
+// SendGrid is the SendGrid API messenger.
+type SendGrid struct {
+	o Options
+	c *http.Client
+
+	queue chan job
+	sem   chan struct{}
+	quit  chan bool
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// job is a message queued for batching and the channel on which the
+// result of its send is returned.
+type job struct {
+	msg  messenger.Message
+	done chan error
+}
+
+// New returns a new instance of the SendGrid messenger.
+func New(o Options) (*SendGrid, error) {
+	if o.APIKey == "" {
+		return nil, fmt.Errorf("no API key for SendGrid messenger %s", o.Name)
+	}
+	if o.RootURL == "" {
+		o.RootURL = defaultRootURL
+	}
+	o.RootURL = strings.TrimRight(o.RootURL, "/")
+	if o.BatchSize < 1 {
+		o.BatchSize = 1
+	} else if o.BatchSize > maxBatchSize {
+		o.BatchSize = maxBatchSize
+	}
+	if o.BatchWait == 0 {
+		o.BatchWait = time.Millisecond * 500
+	}
+	if o.MaxConns < 1 {
+		o.MaxConns = 1
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 10
+	}
+
+	s := &SendGrid{
+		o: o,
+		c: &http.Client{
+			Timeout: o.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   o.MaxConns,
+				MaxConnsPerHost:       o.MaxConns,
+				ResponseHeaderTimeout: o.Timeout,
+				IdleConnTimeout:       o.Timeout,
+			},
+		},
+		queue: make(chan job, o.BatchSize),
+		sem:   make(chan struct{}, o.MaxConns),
+		quit:  make(chan bool),
+	}
+
+	if o.BatchSize > 1 {
+		s.wg.Add(1)
+		go s.batch()
+	}
+
+	return s, nil
+}
+
+// Name returns the messenger's name.
+func (s *SendGrid) Name() string {
+	return s.o.Name
+}
+
+// Push sends a message. If batching is enabled, the message is queued and
+// Push blocks till the batch it's in is sent.
+func (s *SendGrid) Push(m messenger.Message) error {
+	if s.o.BatchSize == 1 {
+		return s.send([]messenger.Message{m})
+	}
+
+	j := job{msg: m, done: make(chan error, 1)}
+	select {
+	case s.queue <- j:
+	case <-s.quit:
+		return errors.New("SendGrid messenger is closed")
+	}
+	return <-j.done
+}
+
+// Flush flushes the message queue to the server.
+func (s *SendGrid) Flush() error {
+	return nil
+}
+
+// Close sends the queued messages and closes idle HTTP connections.
+func (s *SendGrid) Close() error {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+	s.wg.Wait()
+	s.c.CloseIdleConnections()
+	return nil
+}
+
+// batch collects queued messages and sends them when the batch is full or
+// the batch wait time has passed.
+func (s *SendGrid) batch() {
+	defer s.wg.Done()
+
+	var (
+		jobs []job
+		wait <-chan time.Time
+	)
+	for {
+		select {
+		case j := <-s.queue:
+			jobs = append(jobs, j)
+			if len(jobs) >= s.o.BatchSize {
+				s.dispatch(jobs)
+				jobs, wait = nil, nil
+			} else if wait == nil {
+				wait = time.After(s.o.BatchWait)
+			}
+
+		case <-wait:
+			s.dispatch(jobs)
+			jobs, wait = nil, nil
+
+		case <-s.quit:
+			s.dispatch(jobs)
+			return
+		}
+	}
+}
+
+// dispatch groups jobs with identical messages and sends each group
+// as a single request in the background.
+func (s *SendGrid) dispatch(jobs []job) {
+	if len(jobs) == 0 {
+		return
+	}
+
+	var (
+		keys   []string
+		groups = make(map[string][]job)
+	)
+	for _, j := range jobs {
+		// Messages with attachments aren't batched.
+		k := fmt.Sprintf("%s\x00%s\x00%s", j.msg.From, j.msg.Subject, j.msg.Body)
+		if len(j.msg.Attachments) > 0 {
+			k = fmt.Sprintf("%p", j.done)
+		}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], j)
+	}
+
+	for _, k := range keys {
+		g := groups[k]
+		s.sem <- struct{}{}
+		s.wg.Add(1)
+		go func() {
+			defer func() {
+				<-s.sem
+				s.wg.Done()
+			}()
+
+			msgs := make([]messenger.Message, len(g))
+			for i, j := range g {
+				msgs[i] = j.msg
+			}
+			err := s.send(msgs)
+			for _, j := range g {
+				j.done <- err
+			}
+		}()
+	}
+}
+
+// send sends messages that have the same sender, subject and body in
+// a single request. Rate limit and server errors are retried.
+func (s *SendGrid) send(msgs []messenger.Message) error {
+	b, err := s.makePayload(msgs)
+	if err != nil {
+		return err
+	}
+
+	return s.exec(b)
+}
+
+func (s *SendGrid) exec(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.o.RootURL+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.o.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "listmonk")
+
+	r, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode >= 200 && r.StatusCode < 300 {
+		return nil
+	}
+	return parseError(r.StatusCode, b)
+}
+
+// makePayload makes the Mail Send request payload for messages that share
+// the sender, subject and body. Every message becomes a personalization.
+func (s *SendGrid) makePayload(msgs []messenger.Message) ([]byte, error) {
+	m := msgs[0]
+
+	from, err := parseAddr(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %v", err)
+	}
+
+	p := payload{
+		From:       from,
+		Subject:    m.Subject,
+		Categories: s.categories(m),
+	}
+
+	// Content. SendGrid requires text/plain to be first.
+	if s.o.EmailFormat != "html" {
+		txt, err := html2text.FromString(string(m.Body), html2text.Options{PrettyTables: true})
+		if err != nil {
+			return nil, err
+		}
+		p.Content = append(p.Content, content{Type: "text/plain", Value: txt})
+	}
+	if s.o.EmailFormat != "plain" {
+		p.Content = append(p.Content, content{Type: "text/html", Value: string(m.Body)})
+	}
+
+	for _, a := range m.Attachments {
+		p.Attachments = append(p.Attachments, attachment{
+			Content:     base64.StdEncoding.EncodeToString(a.Content),
+			Filename:    a.Name,
+			Disposition: "attachment",
+		})
+	}
+
+	for _, msg := range msgs {
+		var pr personalization
+		for _, t := range msg.To {
+			a, err := parseAddr(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient address: %v", err)
+			}
+			pr.To = append(pr.To, a)
+		}
+
+		for k, v := range msg.Headers {
+			if len(v) == 0 || reservedHeaders[strings.ToLower(k)] {
+				continue
+			}
+			if pr.Headers == nil {
+				pr.Headers = make(map[string]string)
+			}
+			pr.Headers[k] = v[0]
+		}
+
+		// Custom args are posted back in SendGrid event webhooks.
+		pr.CustomArgs = make(map[string]string)
+		if msg.Subscriber.UUID != "" {
+			pr.CustomArgs["subscriber_uuid"] = msg.Subscriber.UUID
+		}
+		if msg.Campaign != nil {
+			pr.CustomArgs["campaign_uuid"] = msg.Campaign.UUID
+		}
+
+		p.Personalizations = append(p.Personalizations, pr)
+	}
+
+	return json.Marshal(p)
+}
+
+// categories returns the configured categories and the campaign's tags
+// within SendGrid's limit.
+func (s *SendGrid) categories(m messenger.Message) []string {
+	var (
+		out  = make([]string, 0, maxCategories)
+		seen = make(map[string]bool)
+		all  = s.o.Categories
+	)
+	if m.Campaign != nil {
+		all = append(append([]string{}, all...), m.Campaign.Tags...)
+	}
+
+	for _, c := range all {
+		c = strings.TrimSpace(c)
+		if c == "" || seen[c] {
+			continue
+		}
+		if len(c) > 255 {
+			c = c[:255]
+		}
+		seen[c] = true
+		out = append(out, c)
+		if len(out) == maxCategories {
+			break
+		}
+	}
+	return out
+}
+
+func parseAddr(s string) (address, error) {
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return address{}, err
+	}
+	return address{Email: a.Address, Name: a.Name}, nil
+}
@@ -0,0 +1,177 @@
+// Package sendmail is a messenger that sends e-mails by piping them to a
+// local sendmail compatible binary (sendmail, postfix, exim, msmtp etc.)
+// instead of SMTP, for instance, to relay via a local MTA.
+package sendmail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/dkim"
+	"github.com/knadh/listmonk/internal/mailsign"
+	"github.com/knadh/listmonk/internal/messenger"
+	"github.com/knadh/listmonk/internal/messenger/email"
+)
+
+// DefaultPath is the path of the binary when none is configured.
+const DefaultPath = "/usr/sbin/sendmail"
+
+const (
+	defaultTimeout = time.Second * 30
+
+	// sysexits.h exit code that sendmail compatible binaries exit with
+	// on transient failures, eg: when the MTA's queue is unavailable.
+	exTempFail = 75
+)
+
+// Default arguments. -i stops the binary from treating a line with a
+// single dot as the end of the message.
+var defaultArgs = []string{"-i"}
+
+// Options represents sendmail messenger options.
+type Options struct {
+	Name string `json:"name"`
+
+	// Path is the absolute path to the binary. Args are passed to it before
+	// the envelope sender (-f) and the recipients.
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+
+	EmailFormat string        `json:"email_format"`
+	Timeout     time.Duration `json:"timeout"`
+
+	// DKIM optionally signs messages from the domains that it has keys for.
+	DKIM *dkim.Signer `json:"-"`
+
+	// Signer optionally S/MIME or PGP signs messages from the senders
+	// that it has keys for.
+	Signer *mailsign.Signer `json:"-"`
+}
+
+// Sendmail is the sendmail binary messenger.
+type Sendmail struct {
+	o Options
+}
+
+// New returns a new instance of the sendmail messenger.
+func New(o Options) (*Sendmail, error) {
+	if o.Path == "" {
+		o.Path = DefaultPath
+	}
+	if !filepath.IsAbs(o.Path) {
+		return nil, fmt.Errorf("sendmail path for messenger %s should be absolute", o.Name)
+	}
+	if _, err := exec.LookPath(o.Path); err != nil {
+		return nil, fmt.Errorf("invalid sendmail binary for messenger %s: %v", o.Name, err)
+	}
+	if o.Args == nil {
+		o.Args = defaultArgs
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+	}
+
+	return &Sendmail{o: o}, nil
+}
+
+// Name returns the messenger's name.
+func (s *Sendmail) Name() string {
+	return s.o.Name
+}
+
+// Push builds a message and pipes it to the sendmail binary with the
+// envelope sender and the recipients as arguments.
+func (s *Sendmail) Push(m messenger.Message) error {
+	em, err := email.MakeEmail(m, s.o.EmailFormat, nil)
+	if err != nil {
+		return err
+	}
+	raw, err := em.Bytes()
+	if err != nil {
+		return err
+	}
+	if s.o.Signer != nil {
+		if raw, err = s.o.Signer.Sign(m.From, raw); err != nil {
+			return err
+		}
+	}
+	if s.o.DKIM != nil {
+		if raw, err = s.o.DKIM.Sign(raw); err != nil {
+			return fmt.Errorf("error DKIM signing message: %v", err)
+		}
+	}
+
+	from, to, err := email.Envelope(em)
+	if err != nil {
+		return err
+	}
+
+	return s.exec(from, to, raw)
+}
+
+// Flush flushes the message queue to the server.
+func (s *Sendmail) Flush() error {
+	return nil
+}
+
+// Close closes the messenger.
+func (s *Sendmail) Close() error {
+	return nil
+}
+
+func (s *Sendmail) exec(from string, to []string, msg []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.o.Timeout)
+	defer cancel()
+
+	args := make([]string, 0, len(s.o.Args)+len(to)+3)
+	args = append(args, s.o.Args...)
+	args = append(args, "-f", from, "--")
+	args = append(args, to...)
+
+	var (
+		cmd = exec.CommandContext(ctx, s.o.Path, args...)
+		out bytes.Buffer
+	)
+	cmd.Stdin = bytes.NewReader(msg)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return &Error{Code: exTempFail, Message: "timed out"}
+	}
+
+	var exErr *exec.ExitError
+	if errors.As(err, &exErr) {
+		msg := strings.TrimSpace(out.String())
+		if msg == "" {
+			msg = exErr.Error()
+		}
+		return &Error{Code: exErr.ExitCode(), Message: msg}
+	}
+	return err
+}
+
+// Error represents a failed run of the sendmail binary.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sendmail exited with %d: %s", e.Code, e.Message)
+}
+
+// Temporary tells whether the message can be retried.
+func (e *Error) Temporary() bool {
+	return e.Code == exTempFail
+}
@@ -0,0 +1,175 @@
+package ses
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ecsCredsURL  = "http://169.254.170.2"
+	imdsURL      = "http://169.254.169.254/latest"
+	imdsTokenTTL = "21600"
+
+	// Temporary credentials are refreshed this long before they expire.
+	credsExpiryWindow = time.Minute * 5
+)
+
+// creds is a set of AWS credentials.
+type creds struct {
+	AccessKey string
+	SecretKey string
+	Token     string
+	Expiry    time.Time
+}
+
+// credentials provides static credentials or fetches and caches temporary
+// IAM role credentials.
+type credentials struct {
+	sync.Mutex
+
+	static bool
+	cur    creds
+	c      *http.Client
+}
+
+// roleCreds is the credentials response of the ECS and EC2 metadata
+// endpoints.
+type roleCreds struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func newCredentials(accessKey, secretKey string, c *http.Client) *credentials {
+	cr := &credentials{c: c}
+
+	switch {
+	case accessKey != "":
+		cr.static = true
+		cr.cur = creds{AccessKey: accessKey, SecretKey: secretKey}
+	case os.Getenv("AWS_ACCESS_KEY_ID") != "":
+		cr.static = true
+		cr.cur = creds{
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:     os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+
+	return cr
+}
+
+// get returns the current credentials, fetching IAM role credentials
+// if they're missing or about to expire.
+func (cr *credentials) get() (creds, error) {
+	cr.Lock()
+	defer cr.Unlock()
+
+	if cr.static || (cr.cur.AccessKey != "" && time.Now().Add(credsExpiryWindow).Before(cr.cur.Expiry)) {
+		return cr.cur, nil
+	}
+
+	var (
+		rc  roleCreds
+		err error
+	)
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		hdr := http.Header{}
+		if tok := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); tok != "" {
+			hdr.Set("Authorization", tok)
+		}
+		err = cr.getJSON(ecsCredsURL+uri, hdr, &rc)
+	} else {
+		rc, err = cr.getEC2Creds()
+	}
+	if err != nil {
+		return creds{}, err
+	}
+	if rc.AccessKeyID == "" {
+		return creds{}, errors.New("no credentials found for the IAM role")
+	}
+
+	cr.cur = creds{
+		AccessKey: rc.AccessKeyID,
+		SecretKey: rc.SecretAccessKey,
+		Token:     rc.Token,
+		Expiry:    rc.Expiration,
+	}
+	return cr.cur, nil
+}
+
+// getEC2Creds fetches the credentials of the EC2 instance's IAM role from
+// the instance metadata service (IMDSv2).
+func (cr *credentials) getEC2Creds() (roleCreds, error) {
+	req, err := http.NewRequest(http.MethodPut, imdsURL+"/api/token", nil)
+	if err != nil {
+		return roleCreds{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", imdsTokenTTL)
+	token, err := cr.do(req)
+	if err != nil {
+		return roleCreds{}, fmt.Errorf("error getting instance metadata token: %v", err)
+	}
+
+	hdr := http.Header{}
+	hdr.Set("X-aws-ec2-metadata-token", string(token))
+
+	req, err = http.NewRequest(http.MethodGet, imdsURL+"/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return roleCreds{}, err
+	}
+	req.Header = hdr
+	role, err := cr.do(req)
+	if err != nil {
+		return roleCreds{}, fmt.Errorf("error getting instance IAM role: %v", err)
+	}
+
+	// The first role is used if there are multiple.
+	name := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	if name == "" {
+		return roleCreds{}, errors.New("no IAM role attached to the instance")
+	}
+
+	var rc roleCreds
+	err = cr.getJSON(imdsURL+"/meta-data/iam/security-credentials/"+name, hdr, &rc)
+	return rc, err
+}
+
+func (cr *credentials) getJSON(u string, hdr http.Header, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = hdr
+
+	b, err := cr.do(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (cr *credentials) do(req *http.Request) ([]byte, error) {
+	r, err := cr.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %d", req.URL.Path, r.StatusCode)
+	}
+	return b, nil
+}
@@ -0,0 +1,61 @@
+package ses
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+)
+
+// sesErrors maps SES error codes to descriptive messages.
+var sesErrors = map[string]string{
+	"MessageRejected":                        "message rejected by SES",
+	"MailFromDomainNotVerifiedException":     "the MAIL FROM domain is not verified in SES",
+	"ConfigurationSetDoesNotExist":           "the SES configuration set does not exist",
+	"ConfigurationSetSendingPausedException": "sending is paused for the SES configuration set",
+	"AccountSendingPausedException":          "sending is paused for the SES account",
+	"Throttling":                             "SES sending rate exceeded",
+	"InvalidClientTokenId":                   "invalid AWS access key",
+	"SignatureDoesNotMatch":                  "invalid AWS secret key",
+	"AccessDenied":                           "the AWS credentials don't have access to SES",
+	"ExpiredToken":                           "the AWS session token has expired",
+}
+
+// Error represents an error returned by the SES API.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+type errorResp struct {
+	Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+func (e *Error) Error() string {
+	msg, ok := sesErrors[e.Code]
+	if !ok {
+		msg = "SES error"
+	}
+	if e.Code == "" {
+		return fmt.Sprintf("%s (HTTP %d): %s", msg, e.Status, e.Message)
+	}
+	return fmt.Sprintf("%s (%s): %s", msg, e.Code, e.Message)
+}
+
+// Temporary tells whether the request can be retried.
+func (e *Error) Temporary() bool {
+	return e.Code == "Throttling" || e.Code == "ServiceUnavailable" ||
+		e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError
+}
+
+// parseError parses the XML error response of the SES API.
+func parseError(status int, b []byte) error {
+	var r errorResp
+	if err := xml.Unmarshal(b, &r); err != nil {
+		return &Error{Status: status, Message: string(b)}
+	}
+	return &Error{Status: status, Code: r.Error.Code, Message: r.Error.Message}
+}
@@ -0,0 +1,175 @@
+// Package ses is a messenger that sends e-mails via the Amazon SES HTTP API
+// (SendRawEmail) instead of SMTP.
+package ses
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/dkim"
+	"github.com/knadh/listmonk/internal/mailsign"
+	"github.com/knadh/listmonk/internal/messenger"
+	"github.com/knadh/listmonk/internal/messenger/email"
+)
+
+// Options represents SES messenger options.
+type Options struct {
+	Name string `json:"name"`
+
+	// AWS region of the SES endpoint, eg: us-east-1.
+	Region string `json:"region"`
+
+	// Optional IAM credentials. If they're not set, the credentials are
+	// picked up from the AWS_* environment variables, or the IAM role of
+	// the ECS task or EC2 instance.
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+
+	// Optional SES configuration set to send with (for event publishing,
+	// dedicated IPs etc.)
+	ConfigurationSet string `json:"configuration_set"`
+
+	EmailFormat string `json:"email_format"`
+
+	MaxConns int           `json:"max_conns"`
+	Timeout  time.Duration `json:"timeout"`
+
+	// DKIM optionally signs messages from the domains that it has keys for.
+	DKIM *dkim.Signer `json:"-"`
+
+	// Signer optionally S/MIME or PGP signs messages from the senders
+	// that it has keys for.
+	Signer *mailsign.Signer `json:"-"`
+}
+
+// SES is the Amazon SES API messenger.
+type SES struct {
+	o     Options
+	url   string
+	host  string
+	creds *credentials
+	c     *http.Client
+}
+
+// New returns a new instance of the SES messenger.
+func New(o Options) (*SES, error) {
+	if o.Region == "" {
+		return nil, fmt.Errorf("no region for SES messenger %s", o.Name)
+	}
+	if (o.AccessKey == "") != (o.SecretKey == "") {
+		return nil, fmt.Errorf("both access_key and secret_key should be set for SES messenger %s", o.Name)
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 10
+	}
+
+	c := &http.Client{
+		Timeout: o.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost:   o.MaxConns,
+			MaxConnsPerHost:       o.MaxConns,
+			ResponseHeaderTimeout: o.Timeout,
+			IdleConnTimeout:       o.Timeout,
+		},
+	}
+
+	host := fmt.Sprintf("email.%s.amazonaws.com", o.Region)
+	return &SES{
+		o:     o,
+		url:   "https://" + host + "/",
+		host:  host,
+		creds: newCredentials(o.AccessKey, o.SecretKey, c),
+		c:     c,
+	}, nil
+}
+
+// Name returns the messenger's name.
+func (s *SES) Name() string {
+	return s.o.Name
+}
+
+// Push sends a message with the SES SendRawEmail API. Throttling and server
+// errors are retried.
+func (s *SES) Push(m messenger.Message) error {
+	em, err := email.MakeEmail(m, s.o.EmailFormat, nil)
+	if err != nil {
+		return err
+	}
+	raw, err := em.Bytes()
+	if err != nil {
+		return err
+	}
+	if s.o.Signer != nil {
+		if raw, err = s.o.Signer.Sign(m.From, raw); err != nil {
+			return err
+		}
+	}
+	if s.o.DKIM != nil {
+		if raw, err = s.o.DKIM.Sign(raw); err != nil {
+			return fmt.Errorf("error DKIM signing message: %v", err)
+		}
+	}
+
+	p := url.Values{}
+	p.Set("Action", "SendRawEmail")
+	p.Set("Version", "2010-12-01")
+	p.Set("Source", m.From)
+	p.Set("RawMessage.Data", base64.StdEncoding.EncodeToString(raw))
+	for i, to := range m.To {
+		p.Set("Destinations.member."+strconv.Itoa(i+1), to)
+	}
+	if s.o.ConfigurationSet != "" {
+		p.Set("ConfigurationSetName", s.o.ConfigurationSet)
+	}
+	body := p.Encode()
+
+	return s.exec(body)
+}
+
+// Flush flushes the message queue to the server.
+func (s *SES) Flush() error {
+	return nil
+}
+
+// Close closes idle HTTP connections.
+func (s *SES) Close() error {
+	s.c.CloseIdleConnections()
+	return nil
+}
+
+// exec makes a signed request to the SES API.
+func (s *SES) exec(body string) error {
+	cr, err := s.creds.get()
+	if err != nil {
+		return fmt.Errorf("error getting AWS credentials: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	req.Header.Set("User-Agent", "listmonk")
+	signRequest(req, []byte(body), s.host, s.o.Region, cr, time.Now().UTC())
+
+	r, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode == http.StatusOK {
+		return nil
+	}
+	return parseError(r.StatusCode, b)
+}
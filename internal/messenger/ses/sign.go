@@ -0,0 +1,91 @@
+package ses
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sigAlgorithm = "AWS4-HMAC-SHA256"
+	sigService   = "ses"
+)
+
+// signRequest signs a POST request to the / path of SES with AWS Signature
+// Version 4.
+func signRequest(req *http.Request, body []byte, host, region string, cr creds, t time.Time) {
+	sign(req, body, host, region, sigService, cr, t)
+}
+
+// sign signs a POST request to the / path of the given AWS service with
+// AWS Signature Version 4.
+func sign(req *http.Request, body []byte, host, region, service string, cr creds, t time.Time) {
+	var (
+		amzDate = t.Format("20060102T150405Z")
+		date    = t.Format("20060102")
+		scope   = date + "/" + region + "/" + service + "/aws4_request"
+	)
+
+	req.Header.Set("Host", host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if cr.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", cr.Token)
+	}
+
+	// Canonical headers are lowercased and sorted.
+	hdrs := []string{"content-type", "host", "x-amz-date"}
+	if cr.Token != "" {
+		hdrs = append(hdrs, "x-amz-security-token")
+	}
+	var canonHdrs strings.Builder
+	for _, h := range hdrs {
+		v := req.Header.Get(h)
+		canonHdrs.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHdrs := strings.Join(hdrs, ";")
+
+	canonReq := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		canonHdrs.String(),
+		signedHdrs,
+		hashHex(body),
+	}, "\n")
+
+	strToSign := strings.Join([]string{
+		sigAlgorithm,
+		amzDate,
+		scope,
+		hashHex([]byte(canonReq)),
+	}, "\n")
+
+	sig := hex.EncodeToString(hmacSHA256(signingKey(cr.SecretKey, date, region, service), strToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigAlgorithm, cr.AccessKey, scope, signedHdrs, sig))
+}
+
+// signingKey derives the key that signs the requests to a service in a
+// region on a date.
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,47 @@
+package ses
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The vectors are from AWS's Signature Version 4 test suite and docs.
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+func TestSigningKey(t *testing.T) {
+	key := hex.EncodeToString(signingKey(testSecretKey, "20150830", "us-east-1", "iam"))
+	if want := "c4afb1cc5771d871763a393e44b703571b55cc28424d1a5e86da6ed3c154a4b9"; key != want {
+		t.Errorf("got %s, want %s", key, want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// post-x-www-form-urlencoded
+	body := "Param1=value1"
+	req, err := http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	sign(req, []byte(body), "example.amazonaws.com", "us-east-1", "service",
+		creds{AccessKey: testAccessKey, SecretKey: testSecretKey},
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	if got, want := req.Header.Get("X-Amz-Date"), "20150830T123600Z"; got != want {
+		t.Errorf("X-Amz-Date: got %s, want %s", got, want)
+	}
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization:\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+// Package sms is a messenger that sends campaign bodies as plain text SMS
+// to the phone numbers in a subscriber attribute via an SMS gateway.
+package sms
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jaytaylor/html2text"
+	"github.com/knadh/listmonk/internal/messenger"
+)
+
+const defaultPhoneAttrib = "phone"
+
+var (
+	// Characters that are commonly used to format phone numbers.
+	phoneReplacer = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+	regexpPhone   = regexp.MustCompile(`^\+[1-9][0-9]{5,14}$`)
+)
+
+// Gateway is an SMS gateway that sends a text message to a phone number.
+type Gateway interface {
+	Send(to, body string) error
+}
+
+// Options represents SMS messenger options.
+type Options struct {
+	Name string `json:"name"`
+
+	// Gateway to use. Only twilio is supported at the moment.
+	Provider string `json:"provider"`
+
+	// PhoneAttrib is the subscriber attribute that holds the E.164 phone
+	// number (eg: +14155550100). Default is "phone".
+	PhoneAttrib string `json:"phone_attrib"`
+
+	Twilio TwilioOptions `json:"twilio"`
+
+	MaxConns int           `json:"max_conns"`
+	Timeout  time.Duration `json:"timeout"`
+}
+
+// SMS is the SMS messenger.
+type SMS struct {
+	o  Options
+	gw Gateway
+}
+
+// New returns a new instance of the SMS messenger.
+func New(o Options) (*SMS, error) {
+	if o.PhoneAttrib == "" {
+		o.PhoneAttrib = defaultPhoneAttrib
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 10
+	}
+
+	var (
+		gw  Gateway
+		err error
+	)
+	switch o.Provider {
+	case "twilio":
+		gw, err = NewTwilio(o.Twilio, o.MaxConns, o.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown SMS provider '%s'", o.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMS{o: o, gw: gw}, nil
+}
+
+// Name returns the messenger's name.
+func (s *SMS) Name() string {
+	return s.o.Name
+}
+
+// Push sends the message body as plain text to the subscriber's phone
+// number. Temporary gateway errors are retried.
+func (s *SMS) Push(m messenger.Message) error {
+	to, err := s.phone(m)
+	if err != nil {
+		return err
+	}
+
+	body := string(m.Body)
+	if m.ContentType != "plain" {
+		body, err = html2text.FromString(body, html2text.Options{})
+		if err != nil {
+			return err
+		}
+	}
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return errors.New("empty SMS body")
+	}
+
+	return s.gw.Send(to, body)
+}
+
+// Flush flushes the message queue to the server.
+func (s *SMS) Flush() error {
+	return nil
+}
+
+// Close closes the gateway's idle connections.
+func (s *SMS) Close() error {
+	if c, ok := s.gw.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// phone returns the normalized phone number of the message's subscriber.
+func (s *SMS) phone(m messenger.Message) (string, error) {
+	v, ok := m.Subscriber.Attribs[s.o.PhoneAttrib]
+	if !ok || v == nil {
+		return "", fmt.Errorf("subscriber %s has no `%s` attribute", m.Subscriber.UUID, s.o.PhoneAttrib)
+	}
+
+	var ph string
+	switch n := v.(type) {
+	case string:
+		ph = n
+	case float64:
+		ph = fmt.Sprintf("+%.0f", n)
+	default:
+		ph = fmt.Sprintf("%v", v)
+	}
+
+	ph = phoneReplacer.Replace(strings.TrimSpace(ph))
+	if strings.HasPrefix(ph, "00") {
+		ph = "+" + ph[2:]
+	}
+	if !regexpPhone.MatchString(ph) {
+		return "", fmt.Errorf("invalid phone number '%v' for subscriber %s (should be in the +<country code><number> format)",
+			v, m.Subscriber.UUID)
+	}
+	return ph, nil
+}
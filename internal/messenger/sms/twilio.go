@@ -0,0 +1,121 @@
+package sms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const twilioURL = "https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json"
+
+// TwilioOptions represents Twilio gateway options.
+type TwilioOptions struct {
+	AccountSID string `json:"account_sid"`
+	AuthToken  string `json:"auth_token"`
+
+	// The sender's phone number, or a Messaging Service SID, which takes
+	// precedence.
+	From                string `json:"from"`
+	MessagingServiceSID string `json:"messaging_service_sid"`
+}
+
+// Twilio is the Twilio SMS gateway.
+type Twilio struct {
+	o   TwilioOptions
+	url string
+	c   *http.Client
+}
+
+// TwilioError represents an error returned by the Twilio API.
+type TwilioError struct {
+	Status  int
+	Code    int
+	Message string
+}
+
+// NewTwilio returns a new instance of the Twilio gateway.
+func NewTwilio(o TwilioOptions, maxConns int, timeout time.Duration) (*Twilio, error) {
+	if o.AccountSID == "" || o.AuthToken == "" {
+		return nil, errors.New("no Twilio account_sid or auth_token")
+	}
+	if o.From == "" && o.MessagingServiceSID == "" {
+		return nil, errors.New("no Twilio from number or messaging_service_sid")
+	}
+
+	return &Twilio{
+		o:   o,
+		url: fmt.Sprintf(twilioURL, url.PathEscape(o.AccountSID)),
+		c: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   maxConns,
+				MaxConnsPerHost:       maxConns,
+				ResponseHeaderTimeout: timeout,
+				IdleConnTimeout:       timeout,
+			},
+		},
+	}, nil
+}
+
+// Send sends a text message.
+func (t *Twilio) Send(to, body string) error {
+	p := url.Values{}
+	p.Set("To", to)
+	p.Set("Body", body)
+	if t.o.MessagingServiceSID != "" {
+		p.Set("MessagingServiceSid", t.o.MessagingServiceSID)
+	} else {
+		p.Set("From", t.o.From)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, strings.NewReader(p.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.o.AccountSID, t.o.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "listmonk")
+
+	r, err := t.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode >= 200 && r.StatusCode < 300 {
+		return nil
+	}
+
+	var er struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(b, &er); err != nil || er.Message == "" {
+		er.Message = strings.TrimSpace(string(b))
+	}
+	return &TwilioError{Status: r.StatusCode, Code: er.Code, Message: er.Message}
+}
+
+// Close closes idle HTTP connections.
+func (t *Twilio) Close() error {
+	t.c.CloseIdleConnections()
+	return nil
+}
+
+func (e *TwilioError) Error() string {
+	return fmt.Sprintf("Twilio error (HTTP %d, code %d): %s", e.Status, e.Code, e.Message)
+}
+
+// Temporary tells whether the request can be retried.
+func (e *TwilioError) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError
+}
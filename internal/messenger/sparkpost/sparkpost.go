@@ -0,0 +1,302 @@
+// Package sparkpost is a messenger that sends e-mails via the SparkPost
+// Transmissions API.
+package sparkpost
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jaytaylor/html2text"
+	"github.com/knadh/listmonk/internal/messenger"
+)
+
+// SparkPost limits campaign IDs to 64 bytes.
+const maxCampaignIDLen = 64
+
+// API hosts by region.
+var regionURLs = map[string]string{
+	"":   "https://api.sparkpost.com",
+	"us": "https://api.sparkpost.com",
+	"eu": "https://api.eu.sparkpost.com",
+}
+
+// Options represents SparkPost messenger options.
+type Options struct {
+	Name   string `json:"name"`
+	APIKey string `json:"api_key"`
+	Region string `json:"region"`
+
+	// Substitutions sends the subscriber's fields as recipient substitution
+	// data (name, email, uuid, attribs) for use with SparkPost's {{ }}
+	// template language in the (already rendered) message.
+	Substitutions bool `json:"substitutions"`
+
+	EmailFormat string `json:"email_format"`
+
+	MaxConns int           `json:"max_conns"`
+	Timeout  time.Duration `json:"timeout"`
+}
+
+// SparkPost is the SparkPost API messenger.
+type SparkPost struct {
+	o   Options
+	url string
+	c   *http.Client
+}
+
+type transmission struct {
+	Options    txOptions         `json:"options"`
+	CampaignID string            `json:"campaign_id,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Recipients []recipient       `json:"recipients"`
+	Content    content           `json:"content"`
+}
+
+type txOptions struct {
+	Transactional bool `json:"transactional"`
+}
+
+type recipient struct {
+	Address          address                `json:"address"`
+	Metadata         map[string]string      `json:"metadata,omitempty"`
+	SubstitutionData map[string]interface{} `json:"substitution_data,omitempty"`
+}
+
+type address struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type content struct {
+	From        address           `json:"from"`
+	Subject     string            `json:"subject"`
+	HTML        string            `json:"html,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Attachments []attachment      `json:"attachments,omitempty"`
+}
+
+type attachment struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// New returns a new instance of the SparkPost messenger.
+func New(o Options) (*SparkPost, error) {
+	if o.APIKey == "" {
+		return nil, fmt.Errorf("no API key for SparkPost messenger %s", o.Name)
+	}
+	root, ok := regionURLs[strings.ToLower(o.Region)]
+	if !ok {
+		return nil, fmt.Errorf("unknown SparkPost region '%s'", o.Region)
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 10
+	}
+
+	return &SparkPost{
+		o:   o,
+		url: root + "/api/v1/transmissions",
+		c: &http.Client{
+			Timeout: o.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   o.MaxConns,
+				MaxConnsPerHost:       o.MaxConns,
+				ResponseHeaderTimeout: o.Timeout,
+				IdleConnTimeout:       o.Timeout,
+			},
+		},
+	}, nil
+}
+
+// Name returns the messenger's name.
+func (s *SparkPost) Name() string {
+	return s.o.Name
+}
+
+// Push sends a message as a transmission. Rate limit and server errors
+// are retried.
+func (s *SparkPost) Push(m messenger.Message) error {
+	from, err := parseAddr(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %v", err)
+	}
+
+	t := transmission{
+		Content: content{
+			From:    from,
+			Subject: m.Subject,
+		},
+		Metadata: make(map[string]string),
+	}
+
+	// Campaign messages are tagged with the campaign for SparkPost's
+	// reports. Other messages are transactional.
+	if m.Campaign != nil {
+		t.CampaignID = m.Campaign.Name
+		if len(t.CampaignID) > maxCampaignIDLen {
+			t.CampaignID = t.CampaignID[:maxCampaignIDLen]
+		}
+		t.Metadata["campaign_uuid"] = m.Campaign.UUID
+		t.Metadata["campaign_name"] = m.Campaign.Name
+	} else {
+		t.Options.Transactional = true
+	}
+
+	if s.o.EmailFormat != "plain" {
+		t.Content.HTML = string(m.Body)
+	}
+	if s.o.EmailFormat != "html" {
+		txt, err := html2text.FromString(string(m.Body), html2text.Options{PrettyTables: true})
+		if err != nil {
+			return err
+		}
+		t.Content.Text = txt
+	}
+
+	for k, v := range m.Headers {
+		if len(v) > 0 {
+			if t.Content.Headers == nil {
+				t.Content.Headers = make(map[string]string)
+			}
+			t.Content.Headers[k] = v[0]
+		}
+	}
+
+	for _, a := range m.Attachments {
+		typ := a.Header.Get("Content-Type")
+		if typ == "" {
+			typ = "application/octet-stream"
+		}
+		t.Content.Attachments = append(t.Content.Attachments, attachment{
+			Name: a.Name,
+			Type: typ,
+			Data: base64.StdEncoding.EncodeToString(a.Content),
+		})
+	}
+
+	for _, to := range m.To {
+		addr, err := parseAddr(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient address: %v", err)
+		}
+
+		r := recipient{Address: addr}
+		if m.Subscriber.UUID != "" {
+			r.Metadata = map[string]string{"subscriber_uuid": m.Subscriber.UUID}
+		}
+		if s.o.Substitutions {
+			r.SubstitutionData = map[string]interface{}{
+				"uuid":    m.Subscriber.UUID,
+				"email":   m.Subscriber.Email,
+				"name":    m.Subscriber.Name,
+				"attribs": m.Subscriber.Attribs,
+			}
+		}
+		t.Recipients = append(t.Recipients, r)
+	}
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return s.exec(b)
+}
+
+// Flush flushes the message queue to the server.
+func (s *SparkPost) Flush() error {
+	return nil
+}
+
+// Close closes idle HTTP connections.
+func (s *SparkPost) Close() error {
+	s.c.CloseIdleConnections()
+	return nil
+}
+
+func (s *SparkPost) exec(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", s.o.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "listmonk")
+
+	r, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode == http.StatusOK {
+		return nil
+	}
+	return parseError(r.StatusCode, b)
+}
+
+func parseAddr(s string) (address, error) {
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return address{}, err
+	}
+	return address{Email: a.Address, Name: a.Name}, nil
+}
+
+// Error represents an error returned by the SparkPost API.
+type Error struct {
+	Status   int
+	Messages []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("SparkPost error (HTTP %d)", e.Status)
+	}
+	return fmt.Sprintf("SparkPost error (HTTP %d): %s", e.Status, strings.Join(e.Messages, "; "))
+}
+
+// Temporary tells whether the request can be retried.
+func (e *Error) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError
+}
+
+// parseError parses the JSON error response of the SparkPost API.
+func parseError(status int, b []byte) error {
+	var r struct {
+		Errors []struct {
+			Message     string `json:"message"`
+			Description string `json:"description"`
+			Code        string `json:"code"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return &Error{Status: status, Messages: []string{strings.TrimSpace(string(b))}}
+	}
+
+	e := &Error{Status: status}
+	for _, er := range r.Errors {
+		msg := er.Message
+		if er.Description != "" {
+			msg += ": " + er.Description
+		}
+		if er.Code != "" {
+			msg += " (" + er.Code + ")"
+		}
+		e.Messages = append(e.Messages, msg)
+	}
+	return e
+}
@@ -0,0 +1,635 @@
+package migrations
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf"
+	"github.com/knadh/stuffbin"
+)
+
+// V0_9_0 performs the DB migrations for v.0.9.0.
+func V0_9_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf) error {
+	_, err := db.Exec(`
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS query TEXT NOT NULL DEFAULT '';
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS synced_at TIMESTAMP WITH TIME ZONE NULL;
+
+	CREATE TABLE IF NOT EXISTS list_groups (
+		id              SERIAL PRIMARY KEY,
+		name            TEXT NOT NULL UNIQUE,
+
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS group_id INTEGER NULL
+		REFERENCES list_groups(id) ON DELETE SET NULL ON UPDATE CASCADE;
+	CREATE INDEX IF NOT EXISTS idx_lists_group_id ON lists(group_id);
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT false;
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS from_email TEXT NOT NULL DEFAULT '';
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS reply_to TEXT NOT NULL DEFAULT '';
+	ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS reply_to TEXT NOT NULL DEFAULT '';
+
+	DO $$
+	BEGIN
+		CREATE TYPE list_unsub_action AS ENUM ('list', 'all', 'blocklist');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS unsub_action list_unsub_action NOT NULL DEFAULT 'list';
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS show_in_preferences BOOLEAN NOT NULL DEFAULT false;
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS optin_email_subject TEXT NOT NULL DEFAULT '';
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS optin_email_body TEXT NOT NULL DEFAULT '';
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS optin_page_message TEXT NOT NULL DEFAULT '';
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS optin_confirm_message TEXT NOT NULL DEFAULT '';
+
+	DO $$
+	BEGIN
+		CREATE TYPE list_cap_action AS ENUM ('reject', 'waitlist');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS max_subscribers INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS cap_action list_cap_action NOT NULL DEFAULT 'reject';
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS cap_message TEXT NOT NULL DEFAULT '';
+	CREATE TABLE IF NOT EXISTS list_waitlist (
+		subscriber_id      INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		list_id            INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		created_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+		PRIMARY KEY(subscriber_id, list_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_list_waitlist_list_id ON list_waitlist(list_id);
+	CREATE OR REPLACE FUNCTION cap_list_subscriptions() RETURNS TRIGGER AS $$
+	DECLARE
+		l RECORD;
+	BEGIN
+		SELECT max_subscribers, cap_action INTO l FROM lists WHERE id = NEW.list_id;
+		IF NOT FOUND OR l.max_subscribers = 0 OR NEW.status = 'unsubscribed' THEN
+			RETURN NEW;
+		END IF;
+
+		-- Existing subscriptions are upserted as usual.
+		IF EXISTS (SELECT 1 FROM subscriber_lists WHERE subscriber_id = NEW.subscriber_id AND list_id = NEW.list_id) THEN
+			RETURN NEW;
+		END IF;
+		IF (SELECT COUNT(*) FROM subscriber_lists WHERE list_id = NEW.list_id AND status != 'unsubscribed') < l.max_subscribers THEN
+			RETURN NEW;
+		END IF;
+
+		-- The list is full. Waitlist the subscriber or skip the subscription.
+		IF l.cap_action = 'waitlist' THEN
+			INSERT INTO list_waitlist (subscriber_id, list_id) VALUES (NEW.subscriber_id, NEW.list_id)
+				ON CONFLICT (subscriber_id, list_id) DO NOTHING;
+		END IF;
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql;
+	DROP TRIGGER IF EXISTS trg_cap_list_subscriptions ON subscriber_lists;
+	CREATE TRIGGER trg_cap_list_subscriptions BEFORE INSERT ON subscriber_lists
+		FOR EACH ROW EXECUTE PROCEDURE cap_list_subscriptions();
+
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS fields JSONB NOT NULL DEFAULT '[]';
+
+	CREATE TABLE IF NOT EXISTS list_webhooks (
+		id              SERIAL PRIMARY KEY,
+		list_id         INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		url             TEXT NOT NULL,
+		events          TEXT[] NOT NULL DEFAULT '{}',
+		secret          TEXT NOT NULL DEFAULT '',
+		enabled         BOOLEAN NOT NULL DEFAULT true,
+
+		created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_list_webhooks_list_id ON list_webhooks(list_id);
+
+	CREATE TABLE IF NOT EXISTS list_snapshots (
+		list_id          INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		date             DATE NOT NULL,
+		unconfirmed      INTEGER NOT NULL DEFAULT 0,
+		confirmed        INTEGER NOT NULL DEFAULT 0,
+		unsubscribed     INTEGER NOT NULL DEFAULT 0,
+
+		PRIMARY KEY(list_id, date)
+	);
+
+	CREATE TABLE IF NOT EXISTS subscription_events (
+		subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		list_id          INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		status           subscription_status NOT NULL,
+		created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_sub_events_list_id ON subscription_events(list_id, created_at);
+
+	-- Seed the log with the existing subscriptions. As their status changes
+	-- weren't logged, they're recorded by the date of their last update.
+	INSERT INTO subscription_events (subscriber_id, list_id, status, created_at)
+		SELECT subscriber_id, list_id, 'unconfirmed', created_at FROM subscriber_lists
+			WHERE list_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM subscription_events)
+		UNION ALL
+		SELECT subscriber_id, list_id, status, updated_at FROM subscriber_lists
+			WHERE list_id IS NOT NULL AND status != 'unconfirmed' AND NOT EXISTS (SELECT 1 FROM subscription_events);
+
+	CREATE OR REPLACE FUNCTION log_subscription_event() RETURNS TRIGGER AS $$
+	BEGIN
+		IF NEW.list_id IS NULL THEN
+			RETURN NEW;
+		END IF;
+		IF TG_OP = 'UPDATE' THEN
+			IF NEW.status = OLD.status THEN
+				RETURN NEW;
+			END IF;
+		END IF;
+		INSERT INTO subscription_events (subscriber_id, list_id, status) VALUES (NEW.subscriber_id, NEW.list_id, NEW.status);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+	DROP TRIGGER IF EXISTS trg_subscription_events ON subscriber_lists;
+	CREATE TRIGGER trg_subscription_events AFTER INSERT OR UPDATE OF status ON subscriber_lists
+		FOR EACH ROW EXECUTE PROCEDURE log_subscription_event();
+
+	CREATE TABLE IF NOT EXISTS subscriber_cohorts (
+		cohort           DATE NOT NULL,
+		month            DATE NOT NULL,
+		subscribers      INTEGER NOT NULL DEFAULT 0,
+		opened           INTEGER NOT NULL DEFAULT 0,
+		clicked          INTEGER NOT NULL DEFAULT 0,
+		engaged          INTEGER NOT NULL DEFAULT 0,
+
+		PRIMARY KEY(cohort, month)
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id              SERIAL PRIMARY KEY,
+		name            TEXT NOT NULL,
+		url             TEXT NOT NULL,
+		events          TEXT[] NOT NULL DEFAULT '{}',
+		secret          TEXT NOT NULL DEFAULT '',
+		enabled         BOOLEAN NOT NULL DEFAULT true,
+
+		created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+	DO $$
+	BEGIN
+		CREATE TYPE webhook_delivery_status AS ENUM ('pending', 'sending', 'success', 'dead');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id               BIGSERIAL PRIMARY KEY,
+		webhook_id       INTEGER NULL REFERENCES webhooks(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		list_webhook_id  INTEGER NULL REFERENCES list_webhooks(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		event            TEXT NOT NULL,
+		payload          JSONB NOT NULL DEFAULT '{}',
+		status           webhook_delivery_status NOT NULL DEFAULT 'pending',
+		attempts         INTEGER NOT NULL DEFAULT 0,
+		response_status  INTEGER NOT NULL DEFAULT 0,
+		response         TEXT NOT NULL DEFAULT '',
+		next_attempt_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_list_webhook_id ON webhook_deliveries(list_webhook_id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_created_at ON webhook_deliveries(created_at);
+
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+	INSERT INTO settings (key, value) VALUES ('app.enable_public_list_directory', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('ses', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('sendgrid', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('mailgun', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('postmark', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('sparkpost', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('sendmail', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('sms', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('dkim', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('message_signing', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.enabled', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.actions', '{"soft": {"count": 2, "action": "none"}, "hard": {"count": 1, "action": "blocklist"}, "block": {"count": 0, "action": "none"}, "autoreply": {"count": 0, "action": "none"}}')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.mailboxes', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.rules', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.ses_enabled', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.ses_topic_arns', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.sendgrid_enabled', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.sendgrid_key', '""')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.mailgun_enabled', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.mailgun_key', '""')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.verp_enabled', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.verp_pattern', '"bounces+{campaign_uuid}.{subscriber_uuid}"')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('bounce.verp_domain', '""')
+		ON CONFLICT DO NOTHING;
+	ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS delivered INT NOT NULL DEFAULT 0;
+
+	DO $$
+	BEGIN
+		CREATE TYPE bounce_type AS ENUM ('soft', 'hard', 'block', 'autoreply');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	CREATE TABLE IF NOT EXISTS bounces (
+		id              SERIAL PRIMARY KEY,
+		subscriber_id   INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		campaign_id     INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		type            bounce_type NOT NULL DEFAULT 'hard',
+		source          TEXT NOT NULL DEFAULT '',
+		meta            JSONB NOT NULL DEFAULT '{}',
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_bounces_sub_id ON bounces(subscriber_id);
+	CREATE INDEX IF NOT EXISTS idx_bounces_camp_id ON bounces(campaign_id);
+
+	CREATE TABLE IF NOT EXISTS complaints (
+		id              SERIAL PRIMARY KEY,
+		subscriber_id   INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		campaign_id     INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		source          TEXT NOT NULL DEFAULT '',
+		meta            JSONB NOT NULL DEFAULT '{}',
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_complaints_sub_id ON complaints(subscriber_id);
+	CREATE INDEX IF NOT EXISTS idx_complaints_camp_id ON complaints(campaign_id);
+
+	CREATE TABLE IF NOT EXISTS deferrals (
+		id              BIGSERIAL PRIMARY KEY,
+		campaign_id     INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		domain          TEXT NOT NULL,
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_deferrals_camp_id ON deferrals(campaign_id);
+	CREATE INDEX IF NOT EXISTS idx_deferrals_created_at ON deferrals(created_at);
+
+	CREATE TABLE IF NOT EXISTS conversions (
+		id              BIGSERIAL PRIMARY KEY,
+		campaign_id     INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		subscriber_id   INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		meta            JSONB NOT NULL DEFAULT '{}',
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_conversions_camp_id ON conversions(campaign_id);
+	CREATE INDEX IF NOT EXISTS idx_conversions_sub_id ON conversions(subscriber_id);
+
+	CREATE TABLE IF NOT EXISTS unsubscriptions (
+		id              BIGSERIAL PRIMARY KEY,
+		campaign_id     INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		subscriber_id   INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_unsubs_camp_id ON unsubscriptions(campaign_id);
+	CREATE INDEX IF NOT EXISTS idx_unsubs_sub_id ON unsubscriptions(subscriber_id);
+	ALTER TABLE unsubscriptions ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+
+	DO $$
+	BEGIN
+		CREATE TYPE message_queue_status AS ENUM ('pending', 'queued', 'sending');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	CREATE TABLE IF NOT EXISTS message_queue (
+		id              BIGSERIAL PRIMARY KEY,
+		campaign_id     INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		subscriber_id   INTEGER NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		messenger       TEXT NOT NULL,
+		message         JSONB NOT NULL DEFAULT '{}',
+		status          message_queue_status NOT NULL DEFAULT 'queued',
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_message_queue_camp_sub ON message_queue(campaign_id, subscriber_id);
+	CREATE INDEX IF NOT EXISTS idx_message_queue_status ON message_queue(status);
+
+	INSERT INTO settings (key, value) VALUES ('app.tx_message_rate', '0')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.tx_hourly_limit', '0')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.domain_limits', '[]')
+		ON CONFLICT DO NOTHING;
+	DO $$
+	BEGIN
+		CREATE TYPE tx_status AS ENUM ('queued', 'sent', 'failed');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	CREATE TABLE IF NOT EXISTS tx_log (
+		id              BIGSERIAL PRIMARY KEY,
+		template_id     INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		subscriber_id   INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		email           TEXT NOT NULL,
+		messenger       TEXT NOT NULL,
+		subject         TEXT NOT NULL DEFAULT '',
+		status          tx_status NOT NULL DEFAULT 'queued',
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		error           TEXT NOT NULL DEFAULT '',
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_tx_log_sub_id ON tx_log(subscriber_id);
+	CREATE INDEX IF NOT EXISTS idx_tx_log_email ON tx_log(email);
+	CREATE INDEX IF NOT EXISTS idx_tx_log_status ON tx_log(status);
+
+	INSERT INTO settings (key, value) VALUES ('app.delivery_log', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.delivery_log_retention', '"720h"')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.message_headers', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.greylist_delay', '"5m"')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.greylist_max_deferrals', '3')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.dynamic_list_sync_interval', '"15m"')
+		ON CONFLICT DO NOTHING;
+	DO $$
+	BEGIN
+		CREATE TYPE delivery_status AS ENUM ('sent', 'failed');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	CREATE TABLE IF NOT EXISTS delivery_log (
+		id              BIGSERIAL PRIMARY KEY,
+		campaign_id     INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		subscriber_id   INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		email           TEXT NOT NULL,
+		messenger       TEXT NOT NULL,
+		subject         TEXT NOT NULL DEFAULT '',
+		status          delivery_status NOT NULL,
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		response        TEXT NOT NULL DEFAULT '',
+		queued_at       TIMESTAMP WITH TIME ZONE NULL,
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_delivery_log_camp_id ON delivery_log(campaign_id);
+	CREATE INDEX IF NOT EXISTS idx_delivery_log_sub_id ON delivery_log(subscriber_id);
+	CREATE INDEX IF NOT EXISTS idx_delivery_log_status ON delivery_log(status);
+	CREATE INDEX IF NOT EXISTS idx_delivery_log_created_at ON delivery_log(created_at);
+
+	DO $$
+	BEGIN
+		CREATE TYPE user_role AS ENUM ('admin', 'campaign_manager', 'viewer');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	DO $$
+	BEGIN
+		CREATE TYPE user_status AS ENUM ('enabled', 'disabled');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	CREATE TABLE IF NOT EXISTS users (
+		id              SERIAL PRIMARY KEY,
+		username        TEXT NOT NULL UNIQUE,
+		name            TEXT NOT NULL DEFAULT '',
+		email           TEXT NOT NULL DEFAULT '',
+		password        TEXT NOT NULL,
+		role            user_role NOT NULL DEFAULT 'viewer',
+		status          user_status NOT NULL DEFAULT 'enabled',
+		last_login_at   TIMESTAMP WITH TIME ZONE NULL,
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS recovery_codes TEXT[] NOT NULL DEFAULT '{}';
+
+	CREATE TABLE IF NOT EXISTS role_permissions (
+		role            user_role NOT NULL PRIMARY KEY,
+		permissions     TEXT[] NOT NULL DEFAULT '{}',
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	INSERT INTO role_permissions (role, permissions) VALUES
+		('campaign_manager', '{subscribers:get,subscribers:manage,subscribers:import,subscribers:export,lists:get,lists:manage,campaigns:get,campaigns:manage,campaigns:send,templates:get,templates:manage,media:get,media:manage,tx:get,tx:send}'),
+		('viewer', '{subscribers:get,lists:get,campaigns:get,templates:get,media:get,tx:get}')
+		ON CONFLICT DO NOTHING;
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		id              TEXT NOT NULL PRIMARY KEY,
+		user_id         INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		csrf_token      TEXT NOT NULL,
+		ip              TEXT NOT NULL DEFAULT '',
+		user_agent      TEXT NOT NULL DEFAULT '',
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		expires_at      TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+	CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+	CREATE TABLE IF NOT EXISTS api_usage (
+		user_id         INTEGER NOT NULL PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		requests        BIGINT NOT NULL DEFAULT 0,
+		errors          BIGINT NOT NULL DEFAULT 0,
+		last_ip         TEXT NOT NULL DEFAULT '',
+		last_seen_at    TIMESTAMP WITH TIME ZONE NULL,
+		last_error_at   TIMESTAMP WITH TIME ZONE NULL
+	);
+	INSERT INTO settings (key, value) VALUES ('app.session_lifetime', '"24h"')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.session_cookie_secure', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.session_cookie_samesite', '"lax"')
+		ON CONFLICT DO NOTHING;
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id              BIGSERIAL PRIMARY KEY,
+		user_id         INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		username        TEXT NOT NULL DEFAULT '',
+		action          TEXT NOT NULL,
+		meta            JSONB NOT NULL DEFAULT '{}',
+		ip              TEXT NOT NULL DEFAULT '',
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_username ON audit_log(username);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+	INSERT INTO settings (key, value) VALUES ('app.audit_log_retention', '"2160h"')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.log_level', '"info"')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.log_format', '"text"')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.read_only', 'false')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.read_only_reason', '""')
+		ON CONFLICT DO NOTHING;
+
+	INSERT INTO settings (key, value) VALUES ('app.rate_limit_public', '0')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.rate_limit_tracking', '0')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.rate_limit_api', '0')
+		ON CONFLICT DO NOTHING;
+
+	INSERT INTO settings (key, value) VALUES ('app.cors_origins', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.cors_methods', '["GET", "POST", "PUT", "DELETE"]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.cors_headers', '["Content-Type", "Authorization"]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.legacy_api_sunset', '""')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.admin_allowed_ips', '[]')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.trusted_proxies', '[]')
+		ON CONFLICT DO NOTHING;
+
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS max_campaigns INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE lists ADD COLUMN IF NOT EXISTS max_campaigns_days INTEGER NOT NULL DEFAULT 0;
+
+	ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS body_source TEXT NOT NULL DEFAULT '';
+	ALTER TABLE templates ADD COLUMN IF NOT EXISTS body_source TEXT NOT NULL DEFAULT '';
+
+	DO $$
+	BEGIN
+		CREATE TYPE template_type AS ENUM ('campaign', 'tx');
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	ALTER TABLE templates ADD COLUMN IF NOT EXISTS type template_type NOT NULL DEFAULT 'campaign';
+	ALTER TABLE templates ADD COLUMN IF NOT EXISTS subject TEXT NOT NULL DEFAULT '';
+	ALTER TABLE templates ADD COLUMN IF NOT EXISTS variables JSONB NOT NULL DEFAULT '[]';
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_templates_tx_name ON templates (name) WHERE type = 'tx';
+	ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS template_vars JSONB NOT NULL DEFAULT '{}';
+	ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS track_opens BOOLEAN NOT NULL DEFAULT true;
+	ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS country TEXT NOT NULL DEFAULT '';
+	ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT '';
+	ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS country TEXT NOT NULL DEFAULT '';
+	ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT '';
+	ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS client TEXT NOT NULL DEFAULT '';
+	ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS device TEXT NOT NULL DEFAULT '';
+	ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS client TEXT NOT NULL DEFAULT '';
+	ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS device TEXT NOT NULL DEFAULT '';
+	ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+	ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+	ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS id BIGSERIAL PRIMARY KEY;
+	ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS id BIGSERIAL PRIMARY KEY;
+	CREATE INDEX IF NOT EXISTS idx_views_created_at ON campaign_views(created_at);
+	CREATE INDEX IF NOT EXISTS idx_clicks_created_at ON link_clicks(created_at);
+	ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS position INTEGER NULL;
+
+	CREATE TABLE IF NOT EXISTS campaign_views_daily (
+		campaign_id     INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		date            DATE NOT NULL,
+		views           INTEGER NOT NULL DEFAULT 0,
+
+		PRIMARY KEY(campaign_id, date)
+	);
+	CREATE TABLE IF NOT EXISTS link_clicks_daily (
+		campaign_id     INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		link_id         INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		date            DATE NOT NULL,
+		clicks          INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_clicks_daily ON link_clicks_daily(COALESCE(campaign_id, 0), link_id, date);
+	INSERT INTO settings (key, value) VALUES ('app.views_retention', '"0"')
+		ON CONFLICT DO NOTHING;
+	INSERT INTO settings (key, value) VALUES ('app.clicks_retention', '"0"')
+		ON CONFLICT DO NOTHING;
+
+	INSERT INTO settings (key, value) VALUES ('privacy.ip_truncation', '"partial"')
+		ON CONFLICT DO NOTHING;
+	ALTER TABLE templates ADD COLUMN IF NOT EXISTS thumb TEXT NOT NULL DEFAULT '';
+	ALTER TABLE templates ADD COLUMN IF NOT EXISTS folder TEXT NOT NULL DEFAULT '';
+	ALTER TABLE templates ADD COLUMN IF NOT EXISTS tags VARCHAR(100)[];
+	ALTER TABLE templates ADD COLUMN IF NOT EXISTS is_fallback BOOLEAN NOT NULL DEFAULT false;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_templates_fallback ON templates (is_fallback) WHERE is_fallback = true;
+
+	CREATE TABLE IF NOT EXISTS template_revisions (
+		id              SERIAL PRIMARY KEY,
+		template_id     INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		body            TEXT NOT NULL,
+		body_source     TEXT NOT NULL DEFAULT '',
+		author          TEXT NOT NULL DEFAULT '',
+		created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_tpl_revisions_tpl_id ON template_revisions(template_id);
+
+	CREATE TABLE IF NOT EXISTS template_variants (
+		id              SERIAL PRIMARY KEY,
+		template_id     INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		lang            TEXT NOT NULL,
+		body            TEXT NOT NULL,
+		body_source     TEXT NOT NULL DEFAULT '',
+
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+		UNIQUE(template_id, lang)
+	);
+
+	CREATE TABLE IF NOT EXISTS template_fixtures (
+		id              SERIAL PRIMARY KEY,
+		template_id     INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		name            TEXT NOT NULL,
+		subscriber      JSONB NOT NULL DEFAULT '{}',
+		campaign        JSONB NOT NULL DEFAULT '{}',
+		data            JSONB NOT NULL DEFAULT '{}',
+
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+		UNIQUE(template_id, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS template_partials (
+		id              SERIAL PRIMARY KEY,
+		name            TEXT NOT NULL UNIQUE,
+		body            TEXT NOT NULL,
+
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS system_templates (
+		id              SERIAL PRIMARY KEY,
+		name            TEXT NOT NULL,
+		lang            TEXT NOT NULL,
+		subject         TEXT NOT NULL DEFAULT '',
+		body            TEXT NOT NULL,
+
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+		UNIQUE(name, lang)
+	);
+
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key             TEXT NOT NULL,
+		user_id         INTEGER NOT NULL DEFAULT 0,
+		request_hash    TEXT NOT NULL,
+		status          INTEGER NULL,
+		response        BYTEA NULL,
+		created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+		PRIMARY KEY(user_id, key)
+	);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+	-- Record the existing templates as their first revisions.
+	INSERT INTO template_revisions (template_id, body, body_source)
+		SELECT id, body, body_source FROM templates
+		WHERE NOT EXISTS (SELECT 1 FROM template_revisions WHERE template_id = templates.id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Enum values can't be added inside a transaction block (multi-statement Exec)
+	// on older Postgres versions.
+	if _, err := db.Exec(`ALTER TYPE content_type ADD VALUE IF NOT EXISTS 'mjml'`); err != nil {
+		return err
+	}
+	_, err = db.Exec(`ALTER TYPE content_type ADD VALUE IF NOT EXISTS 'blocks'`)
+	return err
+}
@@ -0,0 +1,67 @@
+// Package mjml compiles MJML (https://mjml.io) markup to responsive HTML
+// using the external mjml command line tool.
+package mjml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Compiler compiles MJML markup to HTML.
+type Compiler struct {
+	path    string
+	timeout time.Duration
+}
+
+// New returns a new Compiler that invokes the mjml binary at the given path.
+func New(path string, timeout time.Duration) *Compiler {
+	return &Compiler{path: path, timeout: timeout}
+}
+
+// IsMJML checks whether the given markup is an MJML document.
+func IsMJML(src string) bool {
+	return strings.HasPrefix(strings.TrimSpace(src), "<mjml")
+}
+
+// Compile compiles MJML markup to HTML. Go template expressions in the
+// markup are passed through as-is.
+func (c *Compiler) Compile(src string) (string, error) {
+	if !IsMJML(src) {
+		return "", errors.New("markup should begin with an <mjml> tag")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var (
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	)
+
+	// -i reads the input from stdin and -s writes the output to stdout.
+	cmd := exec.CommandContext(ctx, c.path, "-i", "-s", "--config.validationLevel=soft")
+	cmd.Stdin = strings.NewReader(src)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", errors.New("timed out compiling MJML")
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("error compiling MJML: %s", msg)
+		}
+		return "", fmt.Errorf("error compiling MJML: %v", err)
+	}
+
+	out := strings.TrimSpace(stdout.String())
+	if out == "" {
+		return "", errors.New("MJML compiled to an empty document")
+	}
+	return out, nil
+}
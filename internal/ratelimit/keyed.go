@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// KeyedLimiter limits the number of events of each key, eg: a client IP,
+// in a fixed window. A nil KeyedLimiter allows everything.
+type KeyedLimiter struct {
+	limit  int
+	window time.Duration
+
+	keys      map[string]*keyWindow
+	lastPrune time.Time
+
+	mut sync.Mutex
+}
+
+// Status is the state of a key's window after an event is counted.
+type Status struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// Reset is the duration after which the key's window resets.
+	Reset time.Duration
+}
+
+type keyWindow struct {
+	start time.Time
+	count int
+}
+
+// NewKeyed returns a KeyedLimiter that allows limit events of each key in
+// a window. If the limit is 0, nil is returned.
+func NewKeyed(limit int, window time.Duration) *KeyedLimiter {
+	if limit < 1 || window <= 0 {
+		return nil
+	}
+	return &KeyedLimiter{
+		limit:  limit,
+		window: window,
+		keys:   make(map[string]*keyWindow),
+	}
+}
+
+// Reserve counts an event of a key and returns the state of its window.
+// Events over the limit are not counted.
+func (l *KeyedLimiter) Reserve(key string) Status {
+	if l == nil {
+		return Status{Allowed: true}
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	w, ok := l.keys[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &keyWindow{start: now}
+		l.keys[key] = w
+	}
+
+	st := Status{
+		Limit: l.limit,
+		Reset: w.start.Add(l.window).Sub(now),
+	}
+	if w.count >= l.limit {
+		return st
+	}
+
+	w.count++
+	st.Allowed = true
+	st.Remaining = l.limit - w.count
+	return st
+}
+
+// Exceeded checks whether a key has reached the limit in its window
+// without counting an event.
+func (l *KeyedLimiter) Exceeded(key string) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	w, ok := l.keys[key]
+	return ok && time.Since(w.start) < l.window && w.count >= l.limit
+}
+
+// prune deletes the expired windows once every window so that the keys
+// of clients that have gone away don't pile up.
+func (l *KeyedLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	l.lastPrune = now
+
+	for k, w := range l.keys {
+		if now.Sub(w.start) >= l.window {
+			delete(l.keys, k)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package ratelimit implements simple fixed window limiters with
+// per-second and hourly caps for outgoing messages and per-key caps for
+// incoming requests.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter limits the number of events per second and per hour. A nil
+// Limiter allows everything.
+type Limiter struct {
+	perSec  int
+	perHour int
+
+	secStart  time.Time
+	secCount  int
+	hourStart time.Time
+	hourCount int
+
+	mut sync.Mutex
+}
+
+// New returns a Limiter with the given per-second and hourly caps. A cap
+// of 0 is unlimited. If both the caps are 0, nil is returned.
+func New(perSec, perHour int) *Limiter {
+	if perSec < 1 && perHour < 1 {
+		return nil
+	}
+	return &Limiter{perSec: perSec, perHour: perHour}
+}
+
+// Next returns the duration after which an event will be allowed without
+// counting an event. 0 means an event is allowed right away.
+func (l *Limiter) Next() time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.next(time.Now())
+}
+
+// Reserve counts an event and returns 0 if it's allowed. Otherwise, it
+// returns the duration after which an event will be allowed.
+func (l *Limiter) Reserve() time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	now := time.Now()
+	if d := l.next(now); d > 0 {
+		return d
+	}
+
+	l.secCount++
+	l.hourCount++
+	return 0
+}
+
+// Wait blocks until an event is allowed and counts it.
+func (l *Limiter) Wait() {
+	for {
+		d := l.Reserve()
+		if d == 0 {
+			return
+		}
+		time.Sleep(d)
+	}
+}
+
+// next resets the expired windows and returns the duration till the
+// next allowed event.
+func (l *Limiter) next(now time.Time) time.Duration {
+	if now.Sub(l.secStart) >= time.Second {
+		l.secStart = now
+		l.secCount = 0
+	}
+	if now.Sub(l.hourStart) >= time.Hour {
+		l.hourStart = now
+		l.hourCount = 0
+	}
+
+	var d time.Duration
+	if l.perHour > 0 && l.hourCount >= l.perHour {
+		d = l.hourStart.Add(time.Hour).Sub(now)
+	} else if l.perSec > 0 && l.secCount >= l.perSec {
+		d = l.secStart.Add(time.Second).Sub(now)
+	}
+	return d
+}
@@ -0,0 +1,69 @@
+// Package screenshot renders HTML documents to images using the external
+// wkhtmltoimage (https://wkhtmltopdf.org) command line tool.
+package screenshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Renderer renders HTML to PNG images.
+type Renderer struct {
+	path    string
+	timeout time.Duration
+}
+
+// New returns a new Renderer that invokes the wkhtmltoimage binary at the
+// given path.
+func New(path string, timeout time.Duration) *Renderer {
+	return &Renderer{path: path, timeout: timeout}
+}
+
+// Render renders an HTML document to a PNG image of the given viewport
+// width and height. Scripts, plugins, and local files are not loaded.
+// Remote resources such as images and stylesheets are fetched like in a
+// browser as wkhtmltoimage can't block them.
+func (r *Renderer) Render(html string, width, height int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var (
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	)
+
+	// "-" as the input and output reads from stdin and writes to stdout.
+	cmd := exec.CommandContext(ctx, r.path,
+		"--quiet",
+		"--format", "png",
+		"--width", strconv.Itoa(width),
+		"--height", strconv.Itoa(height),
+		"--disable-javascript",
+		"--disable-plugins",
+		"--disable-local-file-access",
+		"-", "-")
+	cmd.Stdin = strings.NewReader(html)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.New("timed out rendering image")
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("error rendering image: %s", msg)
+		}
+		return nil, fmt.Errorf("error rendering image: %v", err)
+	}
+
+	if stdout.Len() == 0 {
+		return nil, errors.New("rendered an empty image")
+	}
+	return stdout.Bytes(), nil
+}
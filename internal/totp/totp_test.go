@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Base32 of the RFC 6238 Appendix B SHA1 secret "12345678901234567890".
+const testSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC's 8 digit codes truncated to the last 6 digits.
+var rfcVectors = []struct {
+	t    int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, v := range rfcVectors {
+		c, err := Code(testSecret, Step(time.Unix(v.t, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != v.code {
+			t.Errorf("%d: got %s, want %s", v.t, c, v.code)
+		}
+	}
+
+	// Lowercase and padded secrets are accepted.
+	if c, _ := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq====", Step(time.Unix(59, 0))); c != "287082" {
+		t.Errorf("lowercase secret: got %s", c)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret: expected an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// Code of the step of 1111111109 (37037036).
+	const code = "081804"
+	step := Step(time.Unix(1111111109, 0))
+	per := int64(Period / time.Second)
+
+	cases := []struct {
+		name     string
+		code     string
+		t        int64
+		lastStep int64
+		step     int64
+		ok       bool
+	}{
+		{"current step", code, 1111111109, 0, step, true},
+		{"previous step", code, 1111111109 + per, 0, step, true},
+		{"next step", code, 1111111109 - per, 0, step, true},
+		{"beyond skew after", code, 1111111109 + 2*per, 0, 0, false},
+		{"beyond skew before", code, 1111111109 - 2*per, 0, 0, false},
+		{"wrong code", "123456", 1111111109, 0, 0, false},
+		{"short code", "08180", 1111111109, 0, 0, false},
+		{"long code", "0818040", 1111111109, 0, 0, false},
+		{"replayed step", code, 1111111109, step, 0, false},
+		{"replayed later step", code, 1111111109, step + 1, 0, false},
+		{"earlier last step", code, 1111111109, step - 1, step, true},
+	}
+
+	for _, c := range cases {
+		s, ok := Validate(c.code, testSecret, time.Unix(c.t, 0), c.lastStep)
+		if ok != c.ok || s != c.step {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", c.name, s, ok, c.step, c.ok)
+		}
+	}
+}
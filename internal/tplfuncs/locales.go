@@ -0,0 +1,87 @@
+package tplfuncs
+
+import "strings"
+
+// dateNames are the month and day names of a language, starting with
+// January and Sunday respectively.
+type dateNames struct {
+	months      [12]string
+	monthsShort [12]string
+	days        [7]string
+	daysShort   [7]string
+}
+
+var (
+	enNames = dateNames{
+		months:      [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		monthsShort: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		days:        [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		daysShort:   [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+	}
+
+	langNames = map[string]dateNames{
+		"de": {
+			months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+			monthsShort: [12]string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun", "Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+			days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+			daysShort:   [7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+		},
+		"es": {
+			months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+			monthsShort: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+			days:        [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+			daysShort:   [7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+		},
+		"fr": {
+			months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+			monthsShort: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+			days:        [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+			daysShort:   [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+		},
+		"it": {
+			months:      [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+			monthsShort: [12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+			days:        [7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+			daysShort:   [7]string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+		},
+		"nl": {
+			months:      [12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+			monthsShort: [12]string{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+			days:        [7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+			daysShort:   [7]string{"zo", "ma", "di", "wo", "do", "vr", "za"},
+		},
+		"pt": {
+			months:      [12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+			monthsShort: [12]string{"jan", "fev", "mar", "abr", "mai", "jun", "jul", "ago", "set", "out", "nov", "dez"},
+			days:        [7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+			daysShort:   [7]string{"dom", "seg", "ter", "qua", "qui", "sex", "sáb"},
+		},
+	}
+
+	// dateReplacers replace the English month and day names in formatted
+	// dates with those of a language.
+	dateReplacers = makeDateReplacers()
+)
+
+func makeDateReplacers() map[string]*strings.Replacer {
+	out := make(map[string]*strings.Replacer, len(langNames))
+	for lang, n := range langNames {
+		// Full names come before abbreviations as the replacer picks the
+		// first matching pair, eg: "Monday" over "Mon".
+		var pairs []string
+		for i := range n.months {
+			pairs = append(pairs, enNames.months[i], n.months[i])
+		}
+		for i := range n.days {
+			pairs = append(pairs, enNames.days[i], n.days[i])
+		}
+		for i := range n.monthsShort {
+			pairs = append(pairs, enNames.monthsShort[i], n.monthsShort[i])
+		}
+		for i := range n.daysShort {
+			pairs = append(pairs, enNames.daysShort[i], n.daysShort[i])
+		}
+		out[lang] = strings.NewReplacer(pairs...)
+	}
+	return out
+}
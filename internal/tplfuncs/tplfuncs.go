@@ -0,0 +1,390 @@
+// Package tplfuncs is a library of general purpose functions (dates,
+// strings, arithmetic, defaults, URL encoding, Markdown) for campaign and
+// transactional templates.
+package tplfuncs
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/knadh/listmonk/internal/markdown"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Func describes a template function.
+type Func struct {
+	Name        string `json:"name"`
+	Signature   string `json:"signature"`
+	Description string `json:"description"`
+	Example     string `json:"example"`
+
+	Fn interface{} `json:"-"`
+}
+
+// library is the list of functions in the order they're documented.
+var library = []Func{
+	// Dates.
+	{
+		Name:        "Now",
+		Signature:   "Now() time.Time",
+		Description: "Current time.",
+		Example:     `{{ Now | FormatDate "2006" "" }}`,
+		Fn:          time.Now,
+	},
+	{
+		Name:      "FormatDate",
+		Signature: "FormatDate(layout, lang string, date) string",
+		Description: "Formats a date (time, RFC3339 string or Unix timestamp) with a Go layout. " +
+			"Month and day names are translated to the language (eg: fr, de) if it's supported.",
+		Example: `{{ .Subscriber.CreatedAt | FormatDate "2 January 2006" "fr" }}`,
+		Fn:      formatDate,
+	},
+
+	// Strings.
+	{
+		Name:        "Upper",
+		Signature:   "Upper(s string) string",
+		Description: "Converts a string to upper case.",
+		Example:     `{{ .Subscriber.Name | Upper }}`,
+		Fn:          strings.ToUpper,
+	},
+	{
+		Name:        "Lower",
+		Signature:   "Lower(s string) string",
+		Description: "Converts a string to lower case.",
+		Example:     `{{ .Subscriber.Email | Lower }}`,
+		Fn:          strings.ToLower,
+	},
+	{
+		Name:        "Title",
+		Signature:   "Title(s string) string",
+		Description: "Capitalizes the first letter of every word in a string.",
+		Example:     `{{ .Subscriber.Name | Title }}`,
+		Fn:          title,
+	},
+	{
+		Name:        "Trim",
+		Signature:   "Trim(s string) string",
+		Description: "Removes leading and trailing whitespace.",
+		Example:     `{{ .Subscriber.Name | Trim }}`,
+		Fn:          strings.TrimSpace,
+	},
+	{
+		Name:        "Truncate",
+		Signature:   "Truncate(n int, s string) string",
+		Description: "Shortens a string to n characters, ending it with an ellipsis if it's cut.",
+		Example:     `{{ .Subject | Truncate 20 }}`,
+		Fn:          truncate,
+	},
+	{
+		Name:        "Replace",
+		Signature:   "Replace(old, new, s string) string",
+		Description: "Replaces all occurrences of old in a string with new.",
+		Example:     `{{ .Subscriber.Name | Replace " " "-" }}`,
+		Fn:          replace,
+	},
+	{
+		Name:        "Contains",
+		Signature:   "Contains(substr, s string) bool",
+		Description: "Checks whether a string contains substr.",
+		Example:     `{{ if .Subscriber.Email | Contains "@example.com" }}...{{ end }}`,
+		Fn:          contains,
+	},
+
+	{
+		Name:      "Markdown",
+		Signature: "Markdown(s string) HTML",
+		Description: "Renders Markdown to HTML. Raw HTML in the Markdown is escaped and " +
+			"only http(s), mailto and relative links are allowed.",
+		Example: `{{ .Subscriber.Attribs.bio | Markdown }}`,
+		Fn:      renderMarkdown,
+	},
+
+	// Arithmetic.
+	{
+		Name:        "Add",
+		Signature:   "Add(a, b number) number",
+		Description: "Adds two numbers.",
+		Example:     `{{ Add .Subscriber.Attribs.points 10 }}`,
+		Fn:          add,
+	},
+	{
+		Name:        "Sub",
+		Signature:   "Sub(a, b number) number",
+		Description: "Subtracts b from a.",
+		Example:     `{{ Sub 100 .Subscriber.Attribs.points }}`,
+		Fn:          sub,
+	},
+	{
+		Name:        "Mul",
+		Signature:   "Mul(a, b number) number",
+		Description: "Multiplies two numbers.",
+		Example:     `{{ Mul .Subscriber.Attribs.price 1.18 }}`,
+		Fn:          mul,
+	},
+	{
+		Name:        "Div",
+		Signature:   "Div(a, b number) number",
+		Description: "Divides a by b. Integers are divided as integers.",
+		Example:     `{{ Div .Subscriber.Attribs.points 2 }}`,
+		Fn:          div,
+	},
+	{
+		Name:        "Mod",
+		Signature:   "Mod(a, b int) int",
+		Description: "Remainder of a divided by b.",
+		Example:     `{{ Mod .Subscriber.ID 2 }}`,
+		Fn:          mod,
+	},
+
+	// Defaults.
+	{
+		Name:        "Default",
+		Signature:   "Default(def, val) any",
+		Description: "Returns val, or def if val is empty (nil, zero, empty string, list or map).",
+		Example:     `{{ .Subscriber.Attribs.city | Default "your city" }}`,
+		Fn:          defaultVal,
+	},
+	{
+		Name:        "Coalesce",
+		Signature:   "Coalesce(vals ...) any",
+		Description: "Returns the first value that isn't empty.",
+		Example:     `{{ Coalesce .Subscriber.Attribs.nickname .Subscriber.Name "there" }}`,
+		Fn:          coalesce,
+	},
+
+	// URLs.
+	{
+		Name:        "URLEncode",
+		Signature:   "URLEncode(s string) string",
+		Description: "Encodes a string for use in a URL query.",
+		Example:     `https://example.com/?ref={{ .Subscriber.Email | URLEncode }}`,
+		Fn:          url.QueryEscape,
+	},
+	{
+		Name:        "PathEncode",
+		Signature:   "PathEncode(s string) string",
+		Description: "Encodes a string for use in a URL path segment.",
+		Example:     `https://example.com/u/{{ .Subscriber.Name | PathEncode }}`,
+		Fn:          url.PathEscape,
+	},
+}
+
+// Funcs returns the library's functions for adding to a template FuncMap.
+func Funcs() map[string]interface{} {
+	out := make(map[string]interface{}, len(library))
+	for _, f := range library {
+		out[f.Name] = f.Fn
+	}
+	return out
+}
+
+// Docs returns the descriptions of the library's functions.
+func Docs() []Func {
+	out := make([]Func, len(library))
+	copy(out, library)
+	return out
+}
+
+// formatDate formats a date with a layout and translates the month and
+// day names to the given language.
+func formatDate(layout, lang string, d interface{}) (string, error) {
+	t, err := toTime(d)
+	if err != nil {
+		return "", err
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+
+	// Fall back to the base language of regional codes, eg: fr-CA.
+	if _, ok := dateReplacers[lang]; !ok {
+		lang = strings.SplitN(lang, "-", 2)[0]
+	}
+
+	out := t.Format(layout)
+	if r, ok := dateReplacers[lang]; ok {
+		out = r.Replace(out)
+	}
+	return out, nil
+}
+
+func toTime(d interface{}) (time.Time, error) {
+	switch v := d.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, nil
+		}
+		return *v, nil
+	case null.Time:
+		return v.Time, nil
+	case string:
+		if v == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, v)
+	case nil:
+		return time.Time{}, nil
+	}
+
+	n, err := toFloat(d)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date: %v", d)
+	}
+	return time.Unix(int64(n), 0), nil
+}
+
+func title(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		r, n := utf8.DecodeRuneInString(w)
+		words[i] = strings.ToUpper(string(r)) + w[n:]
+	}
+	return strings.Join(words, " ")
+}
+
+func truncate(n int, s string) string {
+	if n < 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
+
+func replace(old, new, s string) string {
+	return strings.Replace(s, old, new, -1)
+}
+
+func contains(substr, s string) bool {
+	return strings.Contains(s, substr)
+}
+
+// renderMarkdown renders a Markdown string, or any other value as a
+// string, to HTML.
+func renderMarkdown(s interface{}) template.HTML {
+	switch v := s.(type) {
+	case nil:
+		return ""
+	case string:
+		return template.HTML(markdown.Render(v))
+	}
+	return template.HTML(markdown.Render(fmt.Sprint(s)))
+}
+
+func add(a, b interface{}) (interface{}, error) {
+	return arith(a, b, func(x, y int64) (int64, error) { return x + y, nil },
+		func(x, y float64) float64 { return x + y })
+}
+
+func sub(a, b interface{}) (interface{}, error) {
+	return arith(a, b, func(x, y int64) (int64, error) { return x - y, nil },
+		func(x, y float64) float64 { return x - y })
+}
+
+func mul(a, b interface{}) (interface{}, error) {
+	return arith(a, b, func(x, y int64) (int64, error) { return x * y, nil },
+		func(x, y float64) float64 { return x * y })
+}
+
+func div(a, b interface{}) (interface{}, error) {
+	if n, err := toFloat(b); err == nil && n == 0 {
+		return nil, errors.New("division by zero")
+	}
+	return arith(a, b, func(x, y int64) (int64, error) { return x / y, nil },
+		func(x, y float64) float64 { return x / y })
+}
+
+func mod(a, b interface{}) (interface{}, error) {
+	return arith(a, b, func(x, y int64) (int64, error) {
+		if y == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return x % y, nil
+	}, func(x, y float64) float64 { return math.Mod(x, y) })
+}
+
+// arith applies the integer operation if both the numbers are integers,
+// and the float operation otherwise. JSON numbers (subscriber attributes)
+// are float64s, and those without fractions are treated as integers.
+func arith(a, b interface{}, iFn func(x, y int64) (int64, error), fFn func(x, y float64) float64) (interface{}, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return nil, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if x == math.Trunc(x) && y == math.Trunc(y) &&
+		math.Abs(x) < 1<<53 && math.Abs(y) < 1<<53 {
+		return iFn(int64(x), int64(y))
+	}
+	return fFn(x, y), nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a number: %q", n)
+		}
+		return f, nil
+	case nil:
+		return 0, nil
+	}
+
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(r.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(r.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return r.Float(), nil
+	}
+	return 0, fmt.Errorf("not a number: %v", v)
+}
+
+func defaultVal(def, val interface{}) interface{} {
+	if isEmpty(val) {
+		return def
+	}
+	return val
+}
+
+func coalesce(vals ...interface{}) interface{} {
+	for _, v := range vals {
+		if !isEmpty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// isEmpty checks whether a value is nil or the zero value of its type,
+// or an empty string, slice or map.
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return r.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return r.IsNil()
+	}
+	return r.IsZero()
+}
@@ -0,0 +1,102 @@
+// Package useragent classifies the User-Agents of the requests of e-mail
+// clients, their image proxies, and web browsers by client and device.
+package useragent
+
+import "strings"
+
+// Device classes.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// Agent is a classified User-Agent. Fields that can't be identified are
+// empty.
+type Agent struct {
+	Client string `json:"client"`
+	Device string `json:"device"`
+}
+
+// client is a rule that identifies a client by a substring of User-Agents.
+type client struct {
+	name     string
+	contains string
+
+	// Substrings that the User-Agent shouldn't contain.
+	excludes []string
+}
+
+// Client rules in the order of precedence. E-mail clients are matched
+// before browsers as webviews and proxies mimic browser User-Agents.
+var clients = []client{
+	// Image proxies that fetch images on behalf of webmail and apps.
+	{name: "Gmail", contains: "GoogleImageProxy"},
+	{name: "Yahoo Mail", contains: "YahooMailProxy"},
+
+	{name: "Outlook", contains: "Outlook-iOS"},
+	{name: "Outlook", contains: "Outlook-Android"},
+	{name: "Outlook", contains: "Microsoft Outlook"},
+	{name: "Outlook", contains: "MSOffice"},
+	{name: "Outlook", contains: "ms-office"},
+	{name: "Thunderbird", contains: "Thunderbird"},
+	{name: "Samsung Email", contains: "SamsungEmail"},
+
+	// Apple Mail on macOS and iOS uses WebKit without identifying as Safari.
+	{name: "Apple Mail", contains: "AppleWebKit", excludes: []string{"Safari", "Chrome", "Android"}},
+
+	{name: "Edge", contains: "Edg/"},
+	{name: "Opera", contains: "OPR/"},
+	{name: "Samsung Internet", contains: "SamsungBrowser"},
+	{name: "Firefox", contains: "Firefox/"},
+	{name: "Chrome", contains: "Chrome/"},
+	{name: "Chrome", contains: "CriOS/"},
+	{name: "Safari", contains: "Safari/"},
+}
+
+// Substrings of the User-Agents of crawlers and link scanners, which
+// often open messages and follow their links to check them.
+var bots = []string{"bot", "crawler", "spider", "scanner", "preview", "curl/", "wget/", "python-", "go-http-client"}
+
+// Parse classifies a User-Agent.
+func Parse(ua string) Agent {
+	if ua == "" {
+		return Agent{}
+	}
+
+	var out Agent
+	for _, c := range clients {
+		if strings.Contains(ua, c.contains) && !containsAny(ua, c.excludes) {
+			out.Client = c.name
+			break
+		}
+	}
+
+	lower := strings.ToLower(ua)
+	switch {
+	case containsAny(lower, bots):
+		out.Device = DeviceBot
+	case strings.Contains(ua, "ImageProxy") || strings.Contains(ua, "MailProxy"):
+		// Proxies hide the device of the client.
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile")):
+		out.Device = DeviceTablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "Android"):
+		out.Device = DeviceMobile
+	case strings.Contains(ua, "Windows") || strings.Contains(ua, "Macintosh") ||
+		strings.Contains(ua, "X11") || strings.Contains(ua, "CrOS") || strings.Contains(ua, "Linux"):
+		out.Device = DeviceDesktop
+	}
+
+	return out
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,327 @@
+// Package webhooks delivers events to HTTP endpoints. Events are recorded
+// in a Store as deliveries, one per endpoint, which are posted by a pool of
+// workers and retried with exponential backoff. Deliveries that fail after
+// the maximum number of attempts are marked as dead.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/logger"
+)
+
+// Events that webhooks can subscribe to.
+const (
+	EventSubscribe      = "subscribe"
+	EventUnsubscribe    = "unsubscribe"
+	EventConfirm        = "confirm"
+	EventCampaignStatus = "campaign.status"
+	EventBounce         = "bounce"
+)
+
+// Events is the list of all supported events.
+var Events = []string{EventSubscribe, EventUnsubscribe, EventConfirm, EventCampaignStatus, EventBounce}
+
+// ListEvents is the list of subscription events that are specific to lists.
+var ListEvents = []string{EventSubscribe, EventUnsubscribe, EventConfirm}
+
+// Delivery statuses.
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSuccess = "success"
+	StatusDead    = "dead"
+)
+
+// HTTP headers of posted events.
+const (
+	// SignatureHeader carries the HMAC-SHA256 signature of the request body
+	// when a webhook has a secret.
+	SignatureHeader = "X-Listmonk-Signature"
+	EventHeader     = "X-Listmonk-Event"
+
+	// DeliveryHeader carries the ID of the delivery, which is the same
+	// across retries, for endpoints to discard duplicates.
+	DeliveryHeader = "X-Listmonk-Delivery"
+)
+
+// Maximum length of the response body that's recorded for a delivery.
+const maxRespLen = 1024
+
+// Opt represents the dispatcher options.
+type Opt struct {
+	// Number of concurrent workers posting to webhook endpoints.
+	Workers int
+
+	// Number of due deliveries fetched from the store at a time.
+	BatchSize int
+
+	// Interval at which the store is checked for due deliveries.
+	PollInterval time.Duration
+
+	Timeout time.Duration
+
+	// Maximum number of attempts of a delivery before it's marked as dead.
+	MaxAttempts int
+
+	// Wait before the first retry of a delivery. It's doubled for every
+	// subsequent retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Hook represents a webhook endpoint. Kind is an application defined type
+// of the endpoint that's passed back to the Store with the ID. The URL and
+// secret of a delivery are fetched from the Store when it's posted so that
+// changes to the endpoint apply to pending deliveries.
+type Hook struct {
+	ID   int
+	Kind string
+	URL  string
+}
+
+// Event represents the payload that's posted as JSON to a webhook.
+type Event struct {
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Delivery represents an attempt to post an event to a webhook.
+type Delivery struct {
+	ID       int64           `db:"id"`
+	Event    string          `db:"event"`
+	Payload  json.RawMessage `db:"payload"`
+	URL      string          `db:"url"`
+	Secret   string          `db:"secret"`
+	Attempts int             `db:"attempts"`
+
+	// Outcome of the last attempt.
+	Status         string    `db:"status"`
+	ResponseStatus int       `db:"response_status"`
+	Response       string    `db:"response"`
+	NextAttemptAt  time.Time `db:"next_attempt_at"`
+}
+
+// Store records deliveries.
+type Store interface {
+	// CreateDelivery records a pending delivery of an event to a hook.
+	CreateDelivery(h Hook, event string, payload []byte) error
+
+	// NextDeliveries returns up to limit pending deliveries that are due
+	// and marks them as being sent.
+	NextDeliveries(limit int) ([]Delivery, error)
+
+	// UpdateDelivery records the outcome of an attempt of a delivery.
+	UpdateDelivery(d Delivery) error
+}
+
+// Dispatcher posts events to webhooks asynchronously.
+type Dispatcher struct {
+	opt   Opt
+	store Store
+	c     *http.Client
+	log   *logger.Logger
+
+	queue  chan Delivery
+	notify chan struct{}
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New returns a new instance of Dispatcher.
+func New(o Opt, s Store, l *logger.Logger) *Dispatcher {
+	if o.Workers < 1 {
+		o.Workers = 1
+	}
+	if o.BatchSize < 1 {
+		o.BatchSize = 100
+	}
+	if o.MaxAttempts < 1 {
+		o.MaxAttempts = 1
+	}
+
+	return &Dispatcher{
+		opt:   o,
+		store: s,
+		c: &http.Client{
+			Timeout: o.Timeout,
+		},
+		log:    l,
+		queue:  make(chan Delivery, o.BatchSize),
+		notify: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+}
+
+// Run spawns the workers that post deliveries and feeds them the due
+// deliveries from the store. It blocks until the dispatcher is closed.
+func (d *Dispatcher) Run() {
+	for i := 0; i < d.opt.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+
+	t := time.NewTicker(d.opt.PollInterval)
+	defer t.Stop()
+
+	for {
+		d.fetch()
+
+		select {
+		case <-d.quit:
+			close(d.queue)
+			d.wg.Wait()
+			return
+		case <-t.C:
+		case <-d.notify:
+		}
+	}
+}
+
+// Push records the delivery of an event to the given webhooks. The events
+// are posted asynchronously.
+func (d *Dispatcher) Push(hooks []Hook, event string, data interface{}) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(Event{
+		Event:     event,
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		if err := d.store.CreateDelivery(h, event, b); err != nil {
+			return fmt.Errorf("error recording '%s' event for %s: %v", event, h.URL, err)
+		}
+	}
+
+	d.Notify()
+	return nil
+}
+
+// Notify wakes up the dispatcher to check for due deliveries, eg: after
+// deliveries are retried.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops fetching deliveries and waits for the ones being posted.
+func (d *Dispatcher) Close() {
+	close(d.quit)
+	d.wg.Wait()
+	d.c.CloseIdleConnections()
+}
+
+// fetch queues the due deliveries for the workers till there are no more.
+func (d *Dispatcher) fetch() {
+	for {
+		dls, err := d.store.NextDeliveries(d.opt.BatchSize)
+		if err != nil {
+			d.log.Errorf("error fetching webhook deliveries: %v", err)
+			return
+		}
+
+		for _, dl := range dls {
+			d.queue <- dl
+		}
+		if len(dls) < d.opt.BatchSize {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+
+	for dl := range d.queue {
+		dl.Attempts++
+		dl.ResponseStatus, dl.Response = d.post(dl)
+
+		switch {
+		case dl.ResponseStatus >= 200 && dl.ResponseStatus <= 299:
+			dl.Status = StatusSuccess
+		case dl.Attempts >= d.opt.MaxAttempts:
+			dl.Status = StatusDead
+			d.log.Errorf("error posting webhook to %s after %d attempts: %s", dl.URL, dl.Attempts, dl.Response)
+		default:
+			dl.Status = StatusPending
+			dl.NextAttemptAt = time.Now().Add(d.backoff(dl.Attempts))
+		}
+
+		if err := d.store.UpdateDelivery(dl); err != nil {
+			d.log.Errorf("error updating webhook delivery: %v", err)
+		}
+	}
+}
+
+// backoff returns the wait before the retry after the given number of
+// attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.opt.Backoff
+	for i := 1; i < attempts && b < d.opt.MaxBackoff; i++ {
+		b *= 2
+	}
+	if d.opt.MaxBackoff > 0 && b > d.opt.MaxBackoff {
+		b = d.opt.MaxBackoff
+	}
+	return b
+}
+
+// post posts a delivery and returns the response's status code (0 if
+// the request failed) and its body or the error.
+func (d *Dispatcher) post(dl Delivery) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, dl.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("User-Agent", "listmonk")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(dl.ID, 10))
+
+	// Sign the body if there's a secret.
+	if dl.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(dl.Payload, dl.Secret))
+	}
+
+	r, err := d.c.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer func() {
+		// Drain and close the body to let the Transport reuse the connection
+		io.Copy(ioutil.Discard, r.Body)
+		r.Body.Close()
+	}()
+
+	b, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxRespLen))
+	return r.StatusCode, strings.ToValidUTF8(strings.Replace(string(b), "\x00", "", -1), "")
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of a payload.
+func Sign(payload []byte, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
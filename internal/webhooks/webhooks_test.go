@@ -0,0 +1,71 @@
+package webhooks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/logger"
+)
+
+func TestSign(t *testing.T) {
+	cases := []struct {
+		name    string
+		payload string
+		secret  string
+		out     string
+	}{
+		// RFC 4231 test case 2.
+		{"rfc4231", "what do ya want for nothing?", "Jefe",
+			"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"empty payload", "", "secret",
+			"f9e66e179b6747ae54108f82f8ade8b3c25d76fd30afde6c395822c530196169"},
+	}
+
+	for _, c := range cases {
+		if got := Sign([]byte(c.payload), c.secret); got != c.out {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.out)
+		}
+	}
+}
+
+func TestPostSignature(t *testing.T) {
+	var hdr http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	d := New(Opt{Timeout: time.Second}, nil, logger.New(ioutil.Discard))
+	payload := []byte(`{"event":"subscriber.created"}`)
+
+	cases := []struct {
+		name   string
+		secret string
+		sig    string
+	}{
+		{"signed", "secret", Sign(payload, "secret")},
+		{"unsigned", "", ""},
+	}
+
+	for _, c := range cases {
+		status, resp := d.post(Delivery{ID: 7, Event: "subscriber.created", Payload: payload, URL: srv.URL, Secret: c.secret})
+		if status != http.StatusOK || resp != "ok" {
+			t.Fatalf("%s: got (%d, %q)", c.name, status, resp)
+		}
+		if got := hdr.Get(SignatureHeader); got != c.sig {
+			t.Errorf("%s: signature: got %q, want %q", c.name, got, c.sig)
+		}
+		if string(body) != string(payload) {
+			t.Errorf("%s: body: got %s", c.name, body)
+		}
+		if hdr.Get(EventHeader) != "subscriber.created" || hdr.Get(DeliveryHeader) != "7" {
+			t.Errorf("%s: headers: got %v", c.name, hdr)
+		}
+	}
+}
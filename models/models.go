@@ -29,14 +29,17 @@ const (
 	SubscriptionStatusUnsubscribed = "unsubscribed"
 
 	// Campaign.
-	CampaignStatusDraft     = "draft"
-	CampaignStatusScheduled = "scheduled"
-	CampaignStatusRunning   = "running"
-	CampaignStatusPaused    = "paused"
-	CampaignStatusFinished  = "finished"
-	CampaignStatusCancelled = "cancelled"
-	CampaignTypeRegular     = "regular"
-	CampaignTypeOptin       = "optin"
+	CampaignStatusDraft       = "draft"
+	CampaignStatusScheduled   = "scheduled"
+	CampaignStatusRunning     = "running"
+	CampaignStatusPaused      = "paused"
+	CampaignStatusFinished    = "finished"
+	CampaignStatusCancelled   = "cancelled"
+	CampaignTypeRegular       = "regular"
+	CampaignTypeOptin         = "optin"
+	CampaignContentTypePlain  = "plain"
+	CampaignContentTypeMJML   = "mjml"
+	CampaignContentTypeBlocks = "blocks"
 
 	// List.
 	ListTypePrivate = "private"
@@ -44,11 +47,47 @@ const (
 	ListOptinSingle = "single"
 	ListOptinDouble = "double"
 
+	ListUnsubActionList      = "list"
+	ListUnsubActionAll       = "all"
+	ListUnsubActionBlocklist = "blocklist"
+	ListCapActionReject      = "reject"
+	ListCapActionWaitlist    = "waitlist"
+
 	// User.
-	UserTypeSuperadmin = "superadmin"
-	UserTypeUser       = "user"
-	UserStatusEnabled  = "enabled"
-	UserStatusDisabled = "disabled"
+	UserRoleAdmin           = "admin"
+	UserRoleCampaignManager = "campaign_manager"
+	UserRoleViewer          = "viewer"
+	UserStatusEnabled       = "enabled"
+	UserStatusDisabled      = "disabled"
+
+	// Template.
+	TemplateTypeCampaign = "campaign"
+	TemplateTypeTx       = "tx"
+	TemplateVarString    = "string"
+	TemplateVarNumber    = "number"
+	TemplateVarBoolean   = "boolean"
+	TemplateVarObject    = "object"
+	TemplateVarArray     = "array"
+
+	// Bounce.
+	BounceTypeSoft      = "soft"
+	BounceTypeHard      = "hard"
+	BounceTypeBlock     = "block"
+	BounceTypeAutoReply = "autoreply"
+	BounceTypeComplaint = "complaint"
+
+	// Transactional message.
+	TxStatusQueued = "queued"
+	TxStatusSent   = "sent"
+	TxStatusFailed = "failed"
+
+	// Message delivery.
+	DeliveryStatusSent   = "sent"
+	DeliveryStatusFailed = "failed"
+
+	// Headers attached to outgoing campaign messages.
+	EmailHeaderCampaignUUID   = "X-Listmonk-Campaign"
+	EmailHeaderSubscriberUUID = "X-Listmonk-Subscriber"
 
 	// BaseTpl is the name of the base template.
 	BaseTpl = "base"
@@ -77,8 +116,15 @@ var regTplFuncs = []regTplFunc{
 		regExp:  regexp.MustCompile(`{{(\s+)?(TrackView|UnsubscribeURL|OptinURL|MessageURL)(\s+)?}}`),
 		replace: `{{ $2 . }}`,
 	},
+	regTplFunc{
+		regExp:  regexp.MustCompile("{{(\\s+)?Partial\\s+?(\"|`)(.+?)(\"|`)(\\s+)?}}"),
+		replace: `{{ Partial "$3" . }}`,
+	},
 }
 
+// regTrackLinkURL matches the URLs of TrackLink calls in templates.
+var regTrackLinkURL = regexp.MustCompile("{{(\\s+)?TrackLink\\s+?(\"|`)(.+?)(\"|`)")
+
 // AdminNotifCallback is a callback function that's called
 // when a campaign's status changes.
 type AdminNotifCallback func(subject string, data interface{}) error
@@ -94,11 +140,41 @@ type Base struct {
 type User struct {
 	Base
 
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Password string `json:"-"`
-	Type     string `json:"type"`
-	Status   string `json:"status"`
+	Username    string    `db:"username" json:"username"`
+	Name        string    `db:"name" json:"name"`
+	Email       string    `db:"email" json:"email"`
+	Password    string    `db:"password" json:"-"`
+	Role        string    `db:"role" json:"role"`
+	Status      string    `db:"status" json:"status"`
+	LastLoginAt null.Time `db:"last_login_at" json:"last_login_at"`
+
+	TOTPEnabled   bool           `db:"totp_enabled" json:"totp_enabled"`
+	TOTPSecret    string         `db:"totp_secret" json:"-"`
+	RecoveryCodes pq.StringArray `db:"recovery_codes" json:"-"`
+}
+
+// Role represents the permissions of a user role.
+type Role struct {
+	Role        string         `db:"role" json:"role"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+	UpdatedAt   null.Time      `db:"updated_at" json:"updated_at"`
+}
+
+// APIUsage represents the usage of the API by a user's BasicAuth
+// credentials.
+type APIUsage struct {
+	UserID      int       `db:"user_id" json:"user_id"`
+	Username    string    `db:"username" json:"username"`
+	Role        string    `db:"role" json:"role"`
+	Status      string    `db:"status" json:"status"`
+	Requests    int64     `db:"requests" json:"requests"`
+	Errors      int64     `db:"errors" json:"errors"`
+	LastIP      string    `db:"last_ip" json:"last_ip"`
+	LastSeenAt  null.Time `db:"last_seen_at" json:"last_seen_at"`
+	LastErrorAt null.Time `db:"last_error_at" json:"last_error_at"`
+
+	// Ratio of the failed requests to the total requests.
+	ErrorRate float64 `db:"-" json:"error_rate"`
 }
 
 // Subscriber represents an e-mail subscriber.
@@ -128,18 +204,52 @@ type SubscriberAttribs map[string]interface{}
 // Subscribers represents a slice of Subscriber.
 type Subscribers []Subscriber
 
+// SubscriberExport represents a subscriber record that is exported to raw data.
+type SubscriberExport struct {
+	Base
+
+	UUID    string            `db:"uuid" json:"uuid"`
+	Email   string            `db:"email" json:"email"`
+	Name    string            `db:"name" json:"name"`
+	Attribs SubscriberAttribs `db:"attribs" json:"attribs"`
+	Status  string            `db:"status" json:"status"`
+}
+
 // List represents a mailing list.
 type List struct {
 	Base
 
 	UUID            string         `db:"uuid" json:"uuid"`
 	Name            string         `db:"name" json:"name"`
+	Description     string         `db:"description" json:"description"`
 	Type            string         `db:"type" json:"type"`
 	Optin           string         `db:"optin" json:"optin"`
 	Tags            pq.StringArray `db:"tags" json:"tags"`
+	Query           string         `db:"query" json:"query"`
+	SyncedAt        null.Time      `db:"synced_at" json:"synced_at"`
+	GroupID         null.Int       `db:"group_id" json:"group_id"`
+	Archived        bool           `db:"archived" json:"archived"`
+	FromEmail       string         `db:"from_email" json:"from_email"`
+	ReplyTo         string         `db:"reply_to" json:"reply_to"`
+	UnsubAction     string         `db:"unsub_action" json:"unsub_action"`
+	ShowInPrefs     bool           `db:"show_in_preferences" json:"show_in_preferences"`
+	MaxSubscribers  int            `db:"max_subscribers" json:"max_subscribers"`
+	CapAction       string         `db:"cap_action" json:"cap_action"`
+	CapMessage      string         `db:"cap_message" json:"cap_message"`
+	Fields          ListFields     `db:"fields" json:"fields"`
 	SubscriberCount int            `db:"subscriber_count" json:"subscriber_count"`
 	SubscriberID    int            `db:"subscriber_id" json:"-"`
 
+	// Send frequency rule. At most MaxCampaigns campaigns in MaxCampaignsDays days.
+	MaxCampaigns     int `db:"max_campaigns" json:"max_campaigns"`
+	MaxCampaignsDays int `db:"max_campaigns_days" json:"max_campaigns_days"`
+
+	// Optional overrides for the double opt-in confirmation e-mail and pages.
+	OptinEmailSubject   string `db:"optin_email_subject" json:"optin_email_subject"`
+	OptinEmailBody      string `db:"optin_email_body" json:"optin_email_body"`
+	OptinPageMessage    string `db:"optin_page_message" json:"optin_page_message"`
+	OptinConfirmMessage string `db:"optin_confirm_message" json:"optin_confirm_message"`
+
 	// This is only relevant when querying the lists of a subscriber.
 	SubscriptionStatus string `db:"subscription_status" json:"subscription_status,omitempty"`
 
@@ -148,6 +258,75 @@ type List struct {
 	Total int `db:"total" json:"-"`
 }
 
+// ListField represents a subscriber attribute that's collected,
+// and optionally required, when subscribing to a list.
+type ListField struct {
+	Key      string `json:"key"`
+	Label    string `json:"label"`
+	Required bool   `json:"required"`
+}
+
+// ListFields represents a slice of ListField.
+type ListFields []ListField
+
+// ListGroup represents a named group of lists.
+type ListGroup struct {
+	Base
+
+	Name      string `db:"name" json:"name"`
+	ListCount int    `db:"list_count" json:"list_count"`
+}
+
+// ListWebhook represents an HTTP endpoint that receives subscription
+// events of a single list.
+type ListWebhook struct {
+	Base
+
+	ListID  int            `db:"list_id" json:"list_id"`
+	URL     string         `db:"url" json:"url"`
+	Events  pq.StringArray `db:"events" json:"events"`
+	Secret  string         `db:"secret" json:"-"`
+	Enabled bool           `db:"enabled" json:"enabled"`
+
+	// These are only relevant when dispatching events.
+	ListUUID string `db:"list_uuid" json:"-"`
+	ListName string `db:"list_name" json:"-"`
+}
+
+// Webhook represents an endpoint that events are posted to.
+type Webhook struct {
+	Base
+
+	Name    string         `db:"name" json:"name"`
+	URL     string         `db:"url" json:"url"`
+	Events  pq.StringArray `db:"events" json:"events"`
+	Secret  string         `db:"secret" json:"-"`
+	Enabled bool           `db:"enabled" json:"enabled"`
+}
+
+// WebhookDelivery represents the delivery of an event to a webhook or a
+// list webhook.
+type WebhookDelivery struct {
+	ID             int64           `db:"id" json:"id"`
+	WebhookID      null.Int        `db:"webhook_id" json:"webhook_id"`
+	ListWebhookID  null.Int        `db:"list_webhook_id" json:"list_webhook_id"`
+	WebhookName    string          `db:"webhook_name" json:"webhook_name"`
+	URL            string          `db:"url" json:"url"`
+	Event          string          `db:"event" json:"event"`
+	Payload        json.RawMessage `db:"payload" json:"payload"`
+	Status         string          `db:"status" json:"status"`
+	Attempts       int             `db:"attempts" json:"attempts"`
+	ResponseStatus int             `db:"response_status" json:"response_status"`
+	Response       string          `db:"response" json:"response"`
+	NextAttemptAt  null.Time       `db:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt      null.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt      null.Time       `db:"updated_at" json:"updated_at"`
+
+	// Pseudofield for getting the total number of deliveries
+	// in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
 // Campaign represents an e-mail campaign.
 type Campaign struct {
 	Base
@@ -158,19 +337,33 @@ type Campaign struct {
 	Name        string         `db:"name" json:"name"`
 	Subject     string         `db:"subject" json:"subject"`
 	FromEmail   string         `db:"from_email" json:"from_email"`
+	ReplyTo     string         `db:"reply_to" json:"reply_to"`
 	Body        string         `db:"body" json:"body"`
+	BodySource  string         `db:"body_source" json:"body_source"`
 	SendAt      null.Time      `db:"send_at" json:"send_at"`
 	Status      string         `db:"status" json:"status"`
 	ContentType string         `db:"content_type" json:"content_type"`
 	Tags        pq.StringArray `db:"tags" json:"tags"`
 	TemplateID  int            `db:"template_id" json:"template_id"`
 	Messenger   string         `db:"messenger" json:"messenger"`
+	TrackOpens  bool           `db:"track_opens" json:"track_opens"`
 
 	// TemplateBody is joined in from templates by the next-campaigns query.
 	TemplateBody string             `db:"template_body" json:"-"`
 	Tpl          *template.Template `json:"-"`
 	SubjectTpl   *template.Template `json:"-"`
 
+	// TemplateVars are the values of the variables declared by the template
+	// (TemplateVariables) and Vars, the values resolved with the defaults.
+	TemplateVars      TemplateVarValues      `db:"template_vars" json:"template_vars"`
+	TemplateVariables TemplateVariables      `db:"template_variables" json:"-"`
+	Vars              map[string]interface{} `json:"-"`
+
+	// TemplateVariants (lang => body) are the language variants of the
+	// template and LangTpls their compiled templates.
+	TemplateVariants TemplateVariants              `db:"template_variants" json:"-"`
+	LangTpls         map[string]*template.Template `json:"-"`
+
 	// Pseudofield for getting the total number of subscribers
 	// in searches and queries.
 	Total int `db:"total" json:"-"`
@@ -178,9 +371,18 @@ type Campaign struct {
 
 // CampaignMeta contains fields tracking a campaign's progress.
 type CampaignMeta struct {
-	CampaignID int `db:"campaign_id" json:"-"`
-	Views      int `db:"views" json:"views"`
-	Clicks     int `db:"clicks" json:"clicks"`
+	CampaignID  int `db:"campaign_id" json:"-"`
+	Views       int `db:"views" json:"views"`
+	UniqueViews int `db:"unique_views" json:"unique_views"`
+	Clicks      int `db:"clicks" json:"clicks"`
+	Bounces     int `db:"bounces" json:"bounces"`
+	Complaints  int `db:"complaints" json:"complaints"`
+
+	// Complaints per message sent.
+	ComplaintRate float64 `db:"complaint_rate" json:"complaint_rate"`
+
+	// Number of messages reported as delivered by the messenger's service.
+	Delivered int `db:"delivered" json:"delivered"`
 
 	// This is a list of {list_id, name} pairs unlike Subscriber.Lists[]
 	// because lists can be deleted after a campaign is finished, resulting
@@ -204,6 +406,197 @@ type Template struct {
 	Name      string `db:"name" json:"name"`
 	Body      string `db:"body" json:"body,omitempty"`
 	IsDefault bool   `db:"is_default" json:"is_default"`
+
+	// IsFallback marks the template that's used for campaigns whose
+	// template is missing.
+	IsFallback bool `db:"is_fallback" json:"is_fallback"`
+
+	// BodySource is the MJML source of Body, if the template is written in MJML.
+	BodySource string `db:"body_source" json:"body_source,omitempty"`
+
+	// Type is campaign or tx (transactional). Subject is only applicable
+	// to transactional templates.
+	Type      string            `db:"type" json:"type"`
+	Subject   string            `db:"subject" json:"subject"`
+	Variables TemplateVariables `db:"variables" json:"variables"`
+
+	Folder string         `db:"folder" json:"folder"`
+	Tags   pq.StringArray `db:"tags" json:"tags"`
+
+	// Thumb is the filename of the template's preview image in the media
+	// store, if one has been generated.
+	Thumb    string `db:"thumb" json:"-"`
+	ThumbURL string `json:"thumb_url"`
+}
+
+// TemplateVariant represents a language variant of a template.
+type TemplateVariant struct {
+	Base
+
+	TemplateID int    `db:"template_id" json:"template_id"`
+	Lang       string `db:"lang" json:"lang"`
+	Body       string `db:"body" json:"body"`
+	BodySource string `db:"body_source" json:"body_source,omitempty"`
+}
+
+// TemplateVariants is a map of language => template body.
+type TemplateVariants map[string]string
+
+// TemplateVariable is a variable that a template declares. Campaigns
+// set the values of their template's variables, and transactional
+// messages pass them as data.
+type TemplateVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// string|number|boolean|object|array
+	Type     string      `json:"type"`
+	Default  interface{} `json:"default"`
+	Required bool        `json:"required"`
+}
+
+// TemplateVariables represents the variables declared by a template.
+type TemplateVariables []TemplateVariable
+
+// TemplateVarValues is a map of template variable name => value.
+type TemplateVarValues map[string]interface{}
+
+// TemplateFixture represents a named set of sample data for previewing
+// and test rendering a template.
+type TemplateFixture struct {
+	Base
+
+	TemplateID int                       `db:"template_id" json:"template_id"`
+	Name       string                    `db:"name" json:"name"`
+	Subscriber TemplateFixtureSubscriber `db:"subscriber" json:"subscriber"`
+	Campaign   TemplateFixtureCampaign   `db:"campaign" json:"campaign"`
+
+	// Values of the template's variables, or the data of transactional
+	// templates.
+	Data TemplateVarValues `db:"data" json:"data"`
+}
+
+// TemplateFixtureSubscriber is the fake subscriber of a template fixture.
+type TemplateFixtureSubscriber struct {
+	Name    string            `json:"name"`
+	Email   string            `json:"email"`
+	Attribs SubscriberAttribs `json:"attribs"`
+}
+
+// TemplateFixtureCampaign is the fake campaign of a template fixture.
+// Empty fields are replaced with dummy values.
+type TemplateFixtureCampaign struct {
+	Name      string `json:"name"`
+	Subject   string `json:"subject"`
+	FromEmail string `json:"from_email"`
+	Body      string `json:"body"`
+}
+
+// TemplateRevision represents a saved version of a template's body.
+type TemplateRevision struct {
+	ID         int       `db:"id" json:"id"`
+	TemplateID int       `db:"template_id" json:"template_id"`
+	Body       string    `db:"body" json:"body,omitempty"`
+	BodySource string    `db:"body_source" json:"body_source,omitempty"`
+	Author     string    `db:"author" json:"author"`
+	CreatedAt  null.Time `db:"created_at" json:"created_at"`
+
+	// Pseudofield for getting the total number of revisions
+	// in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// TemplatePartial represents a named, reusable block that templates
+// include with {{ Partial "name" }}.
+type TemplatePartial struct {
+	Base
+
+	Name string `db:"name" json:"name"`
+	Body string `db:"body" json:"body"`
+}
+
+// SystemTemplate represents a language specific override of a built-in
+// e-mail template that's sent to subscribers.
+type SystemTemplate struct {
+	Base
+
+	Name    string `db:"name" json:"name"`
+	Lang    string `db:"lang" json:"lang"`
+	Subject string `db:"subject" json:"subject"`
+	Body    string `db:"body" json:"body"`
+}
+
+// Bounce represents a bounce or complaint notification for a subscriber
+// from a mailbox or a webhook. Complaints (Type complaint) are recorded
+// separately from bounces.
+type Bounce struct {
+	ID        int             `db:"id" json:"id"`
+	Type      string          `db:"type" json:"type"`
+	Source    string          `db:"source" json:"source"`
+	Meta      json.RawMessage `db:"meta" json:"meta"`
+	CreatedAt null.Time       `db:"created_at" json:"created_at"`
+
+	// The subscriber is identified by the UUID, or the e-mail if there's
+	// no UUID. The campaign is optional.
+	Email          string `db:"email" json:"email"`
+	SubscriberUUID string `db:"subscriber_uuid" json:"subscriber_uuid"`
+	CampaignUUID   string `db:"campaign_uuid" json:"campaign_uuid"`
+}
+
+// TxLog represents a transactional message in the transactional message log.
+type TxLog struct {
+	ID           int64     `db:"id" json:"id"`
+	TemplateID   null.Int  `db:"template_id" json:"template_id"`
+	TemplateName string    `db:"template_name" json:"template_name"`
+	SubscriberID null.Int  `db:"subscriber_id" json:"subscriber_id"`
+	Email        string    `db:"email" json:"email"`
+	Messenger    string    `db:"messenger" json:"messenger"`
+	Subject      string    `db:"subject" json:"subject"`
+	Status       string    `db:"status" json:"status"`
+	Attempts     int       `db:"attempts" json:"attempts"`
+	Error        string    `db:"error" json:"error"`
+	CreatedAt    null.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    null.Time `db:"updated_at" json:"updated_at"`
+
+	// Pseudofield for getting the total number of log entries
+	// in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// DeliveryLog represents the delivery of an outgoing message in the
+// delivery log.
+type DeliveryLog struct {
+	ID           int64     `db:"id" json:"id"`
+	CampaignID   null.Int  `db:"campaign_id" json:"campaign_id"`
+	CampaignName string    `db:"campaign_name" json:"campaign_name"`
+	SubscriberID null.Int  `db:"subscriber_id" json:"subscriber_id"`
+	Email        string    `db:"email" json:"email"`
+	Messenger    string    `db:"messenger" json:"messenger"`
+	Subject      string    `db:"subject" json:"subject"`
+	Status       string    `db:"status" json:"status"`
+	Attempts     int       `db:"attempts" json:"attempts"`
+	Response     string    `db:"response" json:"response"`
+	QueuedAt     null.Time `db:"queued_at" json:"queued_at"`
+	CreatedAt    null.Time `db:"created_at" json:"created_at"`
+
+	// Pseudofield for getting the total number of log entries
+	// in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// AuditLog represents an administrative action recorded in the audit log.
+type AuditLog struct {
+	ID        int64          `db:"id" json:"id"`
+	UserID    null.Int       `db:"user_id" json:"user_id"`
+	Username  string         `db:"username" json:"username"`
+	Action    string         `db:"action" json:"action"`
+	Meta      types.JSONText `db:"meta" json:"meta"`
+	IP        string         `db:"ip" json:"ip"`
+	CreatedAt null.Time      `db:"created_at" json:"created_at"`
+
+	// Pseudofield for getting the total number of log entries
+	// in paginated queries.
+	Total int `db:"total" json:"-"`
 }
 
 // GetIDs returns the list of subscriber IDs.
@@ -251,6 +644,138 @@ func (s SubscriberAttribs) Scan(src interface{}) error {
 	return fmt.Errorf("Could not not decode type %T -> %T", src, s)
 }
 
+// Value returns the JSON marshalled ListFields.
+func (f ListFields) Value() (driver.Value, error) {
+	if f == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(f)
+}
+
+// Scan unmarshals JSON into ListFields.
+func (f *ListFields) Scan(src interface{}) error {
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, f)
+	}
+	return fmt.Errorf("Could not not decode type %T -> %T", src, f)
+}
+
+// Scan unmarshals JSON into TemplateVariants.
+func (v *TemplateVariants) Scan(src interface{}) error {
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, v)
+	}
+	return fmt.Errorf("Could not not decode type %T -> %T", src, v)
+}
+
+// Value returns the JSON marshalled TemplateVariables.
+func (v TemplateVariables) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(v)
+}
+
+// Scan unmarshals JSON into TemplateVariables.
+func (v *TemplateVariables) Scan(src interface{}) error {
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, v)
+	}
+	return fmt.Errorf("Could not not decode type %T -> %T", src, v)
+}
+
+// Value returns the JSON marshalled TemplateVarValues.
+func (v TemplateVarValues) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(v)
+}
+
+// Scan unmarshals JSON into TemplateVarValues.
+func (v *TemplateVarValues) Scan(src interface{}) error {
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, v)
+	}
+	return fmt.Errorf("Could not not decode type %T -> %T", src, v)
+}
+
+// Value returns the JSON marshalled TemplateFixtureSubscriber.
+func (s TemplateFixtureSubscriber) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan unmarshals JSON into TemplateFixtureSubscriber.
+func (s *TemplateFixtureSubscriber) Scan(src interface{}) error {
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, s)
+	}
+	return fmt.Errorf("Could not not decode type %T -> %T", src, s)
+}
+
+// Value returns the JSON marshalled TemplateFixtureCampaign.
+func (c TemplateFixtureCampaign) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Scan unmarshals JSON into TemplateFixtureCampaign.
+func (c *TemplateFixtureCampaign) Scan(src interface{}) error {
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, c)
+	}
+	return fmt.Errorf("Could not not decode type %T -> %T", src, c)
+}
+
+// Resolve checks the given values against the declared variables and
+// returns them with the defaults of the variables that aren't set. Values
+// of undeclared variables are retained.
+func (v TemplateVariables) Resolve(values map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(values)+len(v))
+	for k, val := range values {
+		out[k] = val
+	}
+
+	for _, t := range v {
+		val, ok := out[t.Name]
+		if !ok || val == nil {
+			if t.Default == nil {
+				if t.Required {
+					return nil, fmt.Errorf("missing value for the required variable `%s`", t.Name)
+				}
+				continue
+			}
+			val = t.Default
+			out[t.Name] = val
+		}
+
+		if !t.IsValid(val) {
+			return nil, fmt.Errorf("variable `%s` should be of type %s", t.Name, t.Type)
+		}
+	}
+	return out, nil
+}
+
+// IsValid checks whether a (JSON decoded) value is of the variable's type.
+func (t TemplateVariable) IsValid(val interface{}) bool {
+	ok := false
+	switch t.Type {
+	case TemplateVarString:
+		_, ok = val.(string)
+	case TemplateVarNumber:
+		switch val.(type) {
+		case float64, int:
+			ok = true
+		}
+	case TemplateVarBoolean:
+		_, ok = val.(bool)
+	case TemplateVarObject:
+		_, ok = val.(map[string]interface{})
+	case TemplateVarArray:
+		_, ok = val.([]interface{})
+	}
+	return ok
+}
+
 // GetIDs returns the list of campaign IDs.
 func (camps Campaigns) GetIDs() []int {
 	IDs := make([]int, len(camps))
@@ -277,6 +802,10 @@ func (camps Campaigns) LoadStats(stmt *sqlx.Stmt) error {
 			camps[i].Lists = c.Lists
 			camps[i].Views = c.Views
 			camps[i].Clicks = c.Clicks
+			camps[i].Bounces = c.Bounces
+			camps[i].Complaints = c.Complaints
+			camps[i].ComplaintRate = c.ComplaintRate
+			camps[i].Delivered = c.Delivered
 		}
 	}
 
@@ -286,46 +815,132 @@ func (camps Campaigns) LoadStats(stmt *sqlx.Stmt) error {
 // CompileTemplate compiles a campaign body template into its base
 // template and sets the resultant template to Campaign.Tpl.
 func (c *Campaign) CompileTemplate(f template.FuncMap) error {
+	// Resolve the template variables and fail early on missing values.
+	vars, err := c.TemplateVariables.Resolve(c.TemplateVars)
+	if err != nil {
+		return err
+	}
+	c.Vars = vars
+
+	out, err := c.compileTemplate(c.TemplateBody, f)
+	if err != nil {
+		return err
+	}
+
+	// Compile the language variants of the template.
+	c.LangTpls = make(map[string]*template.Template, len(c.TemplateVariants))
+	for lang, body := range c.TemplateVariants {
+		tpl, err := c.compileTemplate(body, f)
+		if err != nil {
+			return fmt.Errorf("error compiling template variant (%s): %v", lang, err)
+		}
+		c.LangTpls[lang] = tpl
+	}
+
+	// If the subject line has a template string, compile it.
+	if strings.Contains(c.Subject, "{{") {
+		subj := c.Subject
+		for _, r := range regTplFuncs {
+			subj = r.regExp.ReplaceAllString(subj, r.replace)
+		}
+		subjTpl, err := template.New(ContentTpl).Funcs(f).Parse(subj)
+		if err != nil {
+			return fmt.Errorf("error compiling subject: %v", err)
+		}
+		c.SubjectTpl = subjTpl
+	}
+
+	c.Tpl = out
+	return nil
+}
+
+// compileTemplate compiles a base template with the campaign message
+// inserted into it.
+func (c *Campaign) compileTemplate(tplBody string, f template.FuncMap) (*template.Template, error) {
 	// Compile the base template.
-	body := c.TemplateBody
+	body := tplBody
 	for _, r := range regTplFuncs {
 		body = r.regExp.ReplaceAllString(body, r.replace)
 	}
 	baseTPL, err := template.New(BaseTpl).Funcs(f).Parse(body)
 	if err != nil {
-		return fmt.Errorf("error compiling base template: %v", err)
+		return nil, fmt.Errorf("error compiling base template: %v", err)
 	}
 
-	// Compile the campaign message.
+	// Compile the campaign message. If open tracking is enabled and neither
+	// the template nor the message has the tracking pixel, it's appended to
+	// the message.
 	body = c.Body
+	if c.TrackOpens && c.ContentType != CampaignContentTypePlain &&
+		!strings.Contains(tplBody, "TrackView") && !strings.Contains(body, "TrackView") {
+		body += `{{ TrackView . }}`
+	}
 	for _, r := range regTplFuncs {
 		body = r.regExp.ReplaceAllString(body, r.replace)
 	}
 	msgTpl, err := template.New(ContentTpl).Funcs(f).Parse(body)
 	if err != nil {
-		return fmt.Errorf("error compiling message: %v", err)
+		return nil, fmt.Errorf("error compiling message: %v", err)
 	}
 
 	out, err := baseTPL.AddParseTree(ContentTpl, msgTpl.Tree)
 	if err != nil {
-		return fmt.Errorf("error inserting child template: %v", err)
+		return nil, fmt.Errorf("error inserting child template: %v", err)
 	}
+	return out, nil
+}
 
-	// If the subject line has a template string, compile it.
-	if strings.Contains(c.Subject, "{{") {
-		subj := c.Subject
-		for _, r := range regTplFuncs {
-			subj = r.regExp.ReplaceAllString(subj, r.replace)
+// TrackedLinks returns the unique URLs that are tracked with TrackLink in
+// the campaign's body.
+func (c *Campaign) TrackedLinks() []string {
+	var (
+		out  []string
+		seen = make(map[string]bool)
+	)
+	for _, m := range regTrackLinkURL.FindAllStringSubmatch(c.Body, -1) {
+		if !seen[m[3]] {
+			seen[m[3]] = true
+			out = append(out, m[3])
 		}
-		subjTpl, err := template.New(ContentTpl).Funcs(f).Parse(subj)
-		if err != nil {
-			return fmt.Errorf("error compiling subject: %v", err)
+	}
+	return out
+}
+
+// LangTpl returns the compiled template for a language, falling back to the
+// base language (eg: fr for fr-CA), and then to the default template.
+func (c *Campaign) LangTpl(lang string) *template.Template {
+	if lang != "" {
+		if t, ok := c.LangTpls[lang]; ok {
+			return t
+		}
+		if i := strings.IndexByte(lang, '-'); i > 0 {
+			if t, ok := c.LangTpls[lang[:i]]; ok {
+				return t
+			}
 		}
-		c.SubjectTpl = subjTpl
 	}
+	return c.Tpl
+}
 
-	c.Tpl = out
-	return nil
+// CompilePartial compiles the body of a named partial template.
+func CompilePartial(name, body string, f template.FuncMap) (*template.Template, error) {
+	for _, r := range regTplFuncs {
+		body = r.regExp.ReplaceAllString(body, r.replace)
+	}
+	tpl, err := template.New(name).Funcs(f).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling partial: %v", err)
+	}
+	return tpl, nil
+}
+
+// Lang returns the subscriber's preferred language from the `lang`
+// attribute, if it's set.
+func (s Subscriber) Lang() string {
+	if l, ok := s.Attribs["lang"].(string); ok {
+		return strings.TrimSpace(l)
+	}
+	return ""
 }
 
 // FirstName splits the name by spaces and returns the first chunk
@@ -56,9 +56,11 @@ WITH sub AS (
     returning id
 ),
 listIDs AS (
+    -- Archived lists don't accept new subscriptions.
     SELECT id FROM lists WHERE
         (CASE WHEN ARRAY_LENGTH($6::INT[], 1) > 0 THEN id=ANY($6)
               ELSE uuid=ANY($7::UUID[]) END)
+        AND archived = false
 ),
 subs AS (
     INSERT INTO subscriber_lists (subscriber_id, list_id, status)
@@ -160,7 +162,8 @@ listIDs AS (
     SELECT id FROM lists WHERE uuid = ANY($2::UUID[])
 )
 UPDATE subscriber_lists SET status='confirmed', updated_at=NOW()
-    WHERE subscriber_id = (SELECT id FROM subID) AND list_id = ANY(SELECT id FROM listIDs);
+    WHERE subscriber_id = (SELECT id FROM subID) AND list_id = ANY(SELECT id FROM listIDs)
+    RETURNING list_id;
 
 -- name: unsubscribe-subscribers-from-lists
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
@@ -170,19 +173,49 @@ UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
 -- Unsubscribes a subscriber given a campaign UUID (from all the lists in the campaign) and the subscriber UUID.
 -- If $3 is TRUE, then all subscriptions of the subscriber is blocklisted
 -- and all existing subscriptions, irrespective of lists, unsubscribed.
-WITH lists AS (
-    SELECT list_id FROM campaign_lists
-    LEFT JOIN campaigns ON (campaign_lists.campaign_id = campaigns.id)
-    WHERE campaigns.uuid = $1
+-- Otherwise, the strictest unsub_action of the campaign's lists applies.
+-- The IDs of the lists that were unsubscribed from are returned and the unsubscription
+-- is recorded against the campaign with the reason ($4) given, if any.
+WITH camp AS (
+    SELECT id FROM campaigns WHERE uuid = $1
+),
+campLists AS (
+    SELECT campaign_lists.list_id, lists.unsub_action FROM campaign_lists
+    LEFT JOIN lists ON (lists.id = campaign_lists.list_id)
+    WHERE campaign_lists.campaign_id = (SELECT id FROM camp)
+),
+action AS (
+    SELECT (CASE
+        WHEN $3 IS TRUE OR 'blocklist' = ANY(SELECT unsub_action FROM campLists) THEN 'blocklist'
+        WHEN 'all' = ANY(SELECT unsub_action FROM campLists) THEN 'all'
+        ELSE 'list'
+    END) AS val
 ),
 sub AS (
-    UPDATE subscribers SET status = (CASE WHEN $3 IS TRUE THEN 'blocklisted' ELSE status END)
+    UPDATE subscribers SET status = (CASE WHEN (SELECT val FROM action) = 'blocklist' THEN 'blocklisted' ELSE status END)
     WHERE uuid = $2 RETURNING id
+),
+unsub AS (
+    UPDATE subscriber_lists SET status = 'unsubscribed', updated_at = NOW() WHERE
+        subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed' AND
+        -- Unsubscribe from the campaign's lists, otherwise all lists.
+        CASE WHEN (SELECT val FROM action) = 'list' THEN list_id = ANY(SELECT list_id FROM campLists) ELSE list_id != 0 END
+        RETURNING list_id
+),
+rec AS (
+    INSERT INTO unsubscriptions (campaign_id, subscriber_id, reason)
+        SELECT camp.id, sub.id, $4 FROM camp, sub WHERE EXISTS (SELECT 1 FROM unsub)
 )
-UPDATE subscriber_lists SET status = 'unsubscribed' WHERE
-    subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed' AND
-    -- If $3 is false, unsubscribe from the campaign's lists, otherwise all lists.
-    CASE WHEN $3 IS FALSE THEN list_id = ANY(SELECT list_id FROM lists) ELSE list_id != 0 END;
+SELECT list_id FROM unsub;
+
+-- name: get-subscriber-preference-lists
+-- Returns a subscriber's subscriptions to be shown on the public preferences page,
+-- that is, public lists and private lists that are configured to be shown.
+SELECT lists.*, subscriber_lists.status AS subscription_status FROM lists
+    INNER JOIN subscriber_lists ON (subscriber_lists.list_id = lists.id)
+    WHERE subscriber_lists.subscriber_id = (SELECT id FROM subscribers WHERE uuid = $1)
+    AND (lists.type = 'public' OR lists.show_in_preferences = true)
+    ORDER BY lists.name;
 
 -- privacy
 -- name: export-subscriber-data
@@ -238,6 +271,37 @@ SELECT COUNT(*) OVER () AS total, subscribers.* FROM subscribers
     %s
     ORDER BY %s %s OFFSET $2 LIMIT $3;
 
+-- name: query-subscribers-for-export
+-- raw: true
+-- Unprepared statement for issuring arbitrary WHERE conditions for
+-- fetching subscribers in batches for a bulk CSV export. Batches are
+-- paginated by the last seen subscriber ID ($2). If there are list IDs ($1),
+-- only the subscribers in any of the lists are fetched.
+-- %s = arbitrary expression
+SELECT subscribers.id, subscribers.uuid, subscribers.email, subscribers.name,
+    subscribers.status, subscribers.attribs, subscribers.created_at, subscribers.updated_at
+    FROM subscribers
+    WHERE (CARDINALITY($1::INT[]) = 0 OR subscribers.id = ANY(
+        SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY($1::INT[])
+    ))
+    AND subscribers.id > $2
+    %s
+    ORDER BY subscribers.id ASC LIMIT $3;
+
+-- name: query-subscriber-attrib-keys-for-export
+-- raw: true
+-- Returns the union of all top level attribute keys across the subscribers
+-- matched by an arbitrary WHERE condition. This is used to flatten attributes
+-- into individual columns in CSV exports.
+-- %s = arbitrary expression
+SELECT DISTINCT JSONB_OBJECT_KEYS(subscribers.attribs) AS key FROM subscribers
+    WHERE (CARDINALITY($1::INT[]) = 0 OR subscribers.id = ANY(
+        SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY($1::INT[])
+    ))
+    AND JSONB_TYPEOF(subscribers.attribs) = 'object'
+    %s
+    ORDER BY key;
+
 -- name: query-subscribers-template
 -- raw: true
 -- This raw query is reused in multiple queries (blocklist, add to list, delete)
@@ -279,6 +343,26 @@ INSERT INTO subscriber_lists (subscriber_id, list_id)
     (SELECT a, b FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($3::INT[]) b)
     ON CONFLICT (subscriber_id, list_id) DO NOTHING;
 
+-- name: sync-dynamic-list-by-query
+-- raw: true
+-- Refreshes the subscriptions of a dynamic list ($3) to match the subscribers
+-- returned by its query. Existing subscriptions and their statuses are retained.
+-- Subscribers who no longer match are removed, except for unsubscriptions, which
+-- are kept so that the subscribers aren't resubscribed if they match again.
+-- Blocklisted subscribers are never added.
+WITH subs AS (%s),
+del AS (
+    DELETE FROM subscriber_lists WHERE list_id = $3 AND status != 'unsubscribed'
+    AND NOT(subscriber_id = ANY(ARRAY(SELECT id FROM subs)))
+),
+synced AS (
+    UPDATE lists SET synced_at=NOW() WHERE id = $3
+)
+INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+    (SELECT id, $3::INT, 'confirmed' FROM subscribers
+        WHERE id = ANY(ARRAY(SELECT id FROM subs)) AND status != 'blocklisted')
+    ON CONFLICT (subscriber_id, list_id) DO NOTHING;
+
 -- name: delete-subscriptions-by-query
 -- raw: true
 WITH subs AS (%s)
@@ -298,8 +382,24 @@ SELECT COUNT(*) OVER () AS total, lists.*, COUNT(subscriber_lists.subscriber_id)
     FROM lists LEFT JOIN subscriber_lists
 	ON (subscriber_lists.list_id = lists.id AND subscriber_lists.status != 'unsubscribed')
     WHERE ($1 = 0 OR id = $1)
+    AND ($4 = 0 OR lists.group_id = $4)
+    -- Archived lists are only returned when explicitly requested or fetched by ID.
+    AND ($1 > 0 OR (CASE WHEN $5 = 'all' THEN TRUE
+                         WHEN $5 = 'true' THEN lists.archived
+                         ELSE NOT lists.archived END))
+    -- Optional name search and tag filters.
+    AND ($6 = '' OR lists.name ILIKE $6)
+    AND (CARDINALITY($7::VARCHAR(100)[]) = 0 OR lists.tags && $7::VARCHAR(100)[])
     GROUP BY lists.id ORDER BY %s %s OFFSET $2 LIMIT (CASE WHEN $3 = 0 THEN NULL ELSE $3 END);
 
+-- name: get-public-lists
+-- Returns the non-archived public lists for the public list directory.
+SELECT lists.*, COUNT(subscriber_lists.subscriber_id) AS subscriber_count
+    FROM lists LEFT JOIN subscriber_lists
+    ON (subscriber_lists.list_id = lists.id AND subscriber_lists.status != 'unsubscribed')
+    WHERE lists.type = 'public' AND lists.archived = false
+    GROUP BY lists.id ORDER BY lists.name;
+
 -- name: get-lists-by-optin
 -- Can have a list of IDs or a list of UUIDs.
 SELECT * FROM lists WHERE (CASE WHEN $1 != '' THEN optin=$1::list_optin ELSE TRUE END) AND
@@ -308,7 +408,13 @@ SELECT * FROM lists WHERE (CASE WHEN $1 != '' THEN optin=$1::list_optin ELSE TRU
     END) ORDER BY name;
 
 -- name: create-list
-INSERT INTO lists (uuid, name, type, optin, tags) VALUES($1, $2, $3, $4, $5) RETURNING id;
+INSERT INTO lists (uuid, name, type, optin, tags, query, group_id, from_email, reply_to,
+    unsub_action, show_in_preferences, optin_email_subject, optin_email_body,
+    optin_page_message, optin_confirm_message, max_subscribers, cap_action, cap_message, fields, description,
+    max_campaigns, max_campaigns_days)
+    VALUES($1, $2, $3, $4, $5, $6, NULLIF($7, 0), $8, $9,
+        (CASE WHEN $10 != '' THEN $10::list_unsub_action ELSE 'list' END), $11, $12, $13, $14, $15,
+        $16, (CASE WHEN $17 != '' THEN $17::list_cap_action ELSE 'reject' END), $18, $19, $20, $21, $22) RETURNING id;
 
 -- name: update-list
 UPDATE lists SET
@@ -316,15 +422,254 @@ UPDATE lists SET
     type=(CASE WHEN $3 != '' THEN $3::list_type ELSE type END),
     optin=(CASE WHEN $4 != '' THEN $4::list_optin ELSE optin END),
     tags=$5::VARCHAR(100)[],
+    query=$6,
+    group_id=NULLIF($7, 0),
+    from_email=$8,
+    reply_to=$9,
+    unsub_action=(CASE WHEN $10 != '' THEN $10::list_unsub_action ELSE unsub_action END),
+    show_in_preferences=$11,
+    optin_email_subject=$12,
+    optin_email_body=$13,
+    optin_page_message=$14,
+    optin_confirm_message=$15,
+    max_subscribers=$16,
+    cap_action=(CASE WHEN $17 != '' THEN $17::list_cap_action ELSE cap_action END),
+    cap_message=$18,
+    fields=$19,
+    description=$20,
+    max_campaigns=$21,
+    max_campaigns_days=$22,
     updated_at=NOW()
 WHERE id = $1;
 
+-- name: get-campaign-dynamic-lists
+-- Returns the dynamic (query backed) lists of campaigns that are about to be
+-- picked up for processing, excluding the campaigns that are already being processed,
+-- that haven't been synced in the last $2 seconds.
+SELECT DISTINCT lists.* FROM lists
+    INNER JOIN campaign_lists ON (campaign_lists.list_id = lists.id)
+    INNER JOIN campaigns ON (campaigns.id = campaign_lists.campaign_id)
+    WHERE lists.query != ''
+    AND (lists.synced_at IS NULL OR lists.synced_at <= NOW() - MAKE_INTERVAL(secs => $2))
+    AND (campaigns.status='running' OR (campaigns.status='scheduled' AND NOW() >= campaigns.send_at))
+    AND NOT(campaigns.id = ANY($1::INT[]));
+
+-- name: clone-list
+-- Creates a copy of a list ($1) with its settings and optionally ($4) its subscriptions.
+WITH l AS (
+    INSERT INTO lists (uuid, name, type, optin, tags, query, group_id, from_email, reply_to,
+        unsub_action, show_in_preferences, optin_email_subject, optin_email_body,
+        optin_page_message, optin_confirm_message, max_subscribers, cap_action, cap_message, fields, description,
+        max_campaigns, max_campaigns_days)
+        SELECT $2, (CASE WHEN $3 != '' THEN $3 ELSE name || ' (copy)' END),
+            type, optin, tags, query, group_id, from_email, reply_to,
+            unsub_action, show_in_preferences, optin_email_subject, optin_email_body,
+            optin_page_message, optin_confirm_message, max_subscribers, cap_action, cap_message, fields, description,
+            max_campaigns, max_campaigns_days
+        FROM lists WHERE id = $1
+    RETURNING id
+),
+subs AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+        SELECT subscriber_id, (SELECT id FROM l), status FROM subscriber_lists
+        WHERE $4 = true AND list_id = $1
+)
+SELECT id FROM l;
+
+-- name: merge-lists
+-- Merges the subscriptions of list $2 into list $1. Per subscriber, the earliest
+-- subscription date is retained and a confirmed subscription in either list is
+-- retained unless the subscriber has unsubscribed from $1. Campaign references
+-- to $2 are repointed to $1 and $2 is archived.
+WITH subs AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, created_at, updated_at)
+        SELECT subscriber_id, $1, status, created_at, NOW() FROM subscriber_lists WHERE list_id = $2
+    ON CONFLICT (subscriber_id, list_id) DO UPDATE SET
+        status = (CASE
+            WHEN subscriber_lists.status = 'unsubscribed' THEN subscriber_lists.status
+            WHEN EXCLUDED.status = 'confirmed' THEN EXCLUDED.status
+            ELSE subscriber_lists.status
+        END),
+        created_at = LEAST(subscriber_lists.created_at, EXCLUDED.created_at),
+        updated_at = NOW()
+),
+delCamps AS (
+    -- Campaigns that already reference $1 only lose their reference to $2.
+    DELETE FROM campaign_lists WHERE list_id = $2
+        AND campaign_id = ANY(SELECT campaign_id FROM campaign_lists WHERE list_id = $1)
+),
+camps AS (
+    UPDATE campaign_lists SET list_id = $1, list_name = (SELECT name FROM lists WHERE id = $1)
+        WHERE list_id = $2
+        AND NOT(campaign_id = ANY(SELECT campaign_id FROM campaign_lists WHERE list_id = $1))
+)
+UPDATE lists SET archived = true, updated_at = NOW()
+    WHERE id = $2 AND EXISTS (SELECT 1 FROM lists WHERE id = $1);
+
+-- name: archive-list
+UPDATE lists SET archived=$2, updated_at=NOW() WHERE id = $1;
+
+-- name: get-archived-lists
+SELECT * FROM lists WHERE archived = true AND id = ANY($1::INT[]) ORDER BY name;
+
+-- name: get-list-growth-stats
+-- Returns a daily time series of new subscriptions, confirmations, and unsubscriptions
+-- of a list ($1) between two dates ($2, $3) from the subscription event log.
+-- Subscriptions are counted by the date the subscribers joined the list, and
+-- confirmations and unsubscriptions by the date of the status change.
+WITH days AS (
+    SELECT GENERATE_SERIES($2::DATE, $3::DATE, '1 day'::INTERVAL)::DATE AS date
+),
+subs AS (
+    SELECT created_at::DATE AS date, COUNT(*) AS count FROM subscriber_lists
+    WHERE list_id = $1 AND created_at::DATE BETWEEN $2::DATE AND $3::DATE
+    GROUP BY date
+),
+events AS (
+    SELECT created_at::DATE AS date,
+        COUNT(*) FILTER (WHERE status = 'confirmed') AS confirms,
+        COUNT(*) FILTER (WHERE status = 'unsubscribed') AS unsubs
+    FROM subscription_events
+    WHERE list_id = $1 AND created_at::DATE BETWEEN $2::DATE AND $3::DATE
+    GROUP BY date
+)
+SELECT days.date::TEXT AS date,
+    COALESCE(subs.count, 0) AS subscriptions,
+    COALESCE(events.confirms, 0) AS confirmations,
+    COALESCE(events.unsubs, 0) AS unsubscriptions
+FROM days
+LEFT JOIN subs ON (subs.date = days.date)
+LEFT JOIN events ON (events.date = days.date)
+ORDER BY days.date;
+
+-- name: record-list-snapshots
+-- Records the subscription counts of all lists by status as the snapshot of the day,
+-- updating the day's snapshot if it's already been recorded.
+INSERT INTO list_snapshots (list_id, date, unconfirmed, confirmed, unsubscribed)
+    SELECT lists.id, CURRENT_DATE,
+        COUNT(*) FILTER (WHERE subscriber_lists.status = 'unconfirmed'),
+        COUNT(*) FILTER (WHERE subscriber_lists.status = 'confirmed'),
+        COUNT(*) FILTER (WHERE subscriber_lists.status = 'unsubscribed')
+    FROM lists
+    LEFT JOIN subscriber_lists ON (subscriber_lists.list_id = lists.id)
+    GROUP BY lists.id
+ON CONFLICT (list_id, date) DO UPDATE SET unconfirmed = EXCLUDED.unconfirmed,
+    confirmed = EXCLUDED.confirmed, unsubscribed = EXCLUDED.unsubscribed;
+
+-- name: get-list-snapshots
+-- Returns the daily snapshots of a list ($1) between two dates ($2, $3) with the change in
+-- the number of subscribers who haven't unsubscribed since the previous snapshot.
+WITH snaps AS (
+    SELECT date, unconfirmed, confirmed, unsubscribed,
+        (unconfirmed + confirmed) - LAG(unconfirmed + confirmed) OVER (ORDER BY date) AS net_change
+    FROM list_snapshots
+    WHERE list_id = $1 AND date BETWEEN $2::DATE - 1 AND $3::DATE
+)
+SELECT date::TEXT AS date, unconfirmed, confirmed, unsubscribed, COALESCE(net_change, 0) AS net_change
+    FROM snaps WHERE date >= $2::DATE ORDER BY date;
+
+-- name: get-capped-lists
+-- Returns the lists among the given UUIDs that have reached their subscriber cap.
+SELECT lists.*, COUNT(subscriber_lists.subscriber_id) AS subscriber_count FROM lists
+    LEFT JOIN subscriber_lists
+    ON (subscriber_lists.list_id = lists.id AND subscriber_lists.status != 'unsubscribed')
+    WHERE lists.uuid = ANY($1::UUID[]) AND lists.max_subscribers > 0
+    GROUP BY lists.id
+    HAVING COUNT(subscriber_lists.subscriber_id) >= lists.max_subscribers;
+
+-- name: add-to-list-waitlist
+INSERT INTO list_waitlist (subscriber_id, list_id)
+    (SELECT $1, UNNEST($2::INT[]))
+    ON CONFLICT (subscriber_id, list_id) DO NOTHING;
+
+-- name: get-list-waitlist
+SELECT COUNT(*) OVER () AS total, subscribers.*, list_waitlist.created_at AS waitlisted_at
+    FROM list_waitlist
+    INNER JOIN subscribers ON (subscribers.id = list_waitlist.subscriber_id)
+    WHERE list_waitlist.list_id = $1
+    ORDER BY list_waitlist.created_at OFFSET $2 LIMIT $3;
+
+-- name: promote-list-waitlists
+-- Moves waitlisted subscribers, earliest first, into the lists that have space under
+-- their subscriber caps or are no longer capped. Returns the promoted subscriptions.
+WITH space AS (
+    SELECT lists.id, (CASE WHEN lists.max_subscribers = 0 THEN NULL
+        ELSE lists.max_subscribers - (SELECT COUNT(*) FROM subscriber_lists
+            WHERE list_id = lists.id AND status != 'unsubscribed') END) AS free
+    FROM lists
+    WHERE lists.archived = false AND EXISTS (SELECT 1 FROM list_waitlist WHERE list_id = lists.id)
+),
+next AS (
+    SELECT w.subscriber_id, w.list_id FROM (
+        SELECT list_waitlist.subscriber_id, list_waitlist.list_id,
+            ROW_NUMBER() OVER (PARTITION BY list_waitlist.list_id ORDER BY list_waitlist.created_at, list_waitlist.subscriber_id) AS n
+        FROM list_waitlist
+        INNER JOIN subscribers ON (subscribers.id = list_waitlist.subscriber_id AND subscribers.status != 'blocklisted')
+    ) w
+    INNER JOIN space ON (space.id = w.list_id)
+    WHERE space.free IS NULL OR w.n <= space.free
+),
+del AS (
+    DELETE FROM list_waitlist WHERE (subscriber_id, list_id) IN (SELECT subscriber_id, list_id FROM next)
+)
+INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+    (SELECT subscriber_id, list_id, 'unconfirmed' FROM next)
+    ON CONFLICT (subscriber_id, list_id) DO NOTHING
+    RETURNING subscriber_id, list_id;
+
+-- name: get-list-webhooks
+SELECT * FROM list_webhooks WHERE list_id = $1 ORDER BY id;
+
+-- name: get-list-webhooks-by-event
+-- Returns the enabled webhooks of the given lists (IDs $2 or UUIDs $3) that are
+-- subscribed to the event $1, along with the list they belong to.
+SELECT list_webhooks.*, lists.uuid AS list_uuid, lists.name AS list_name FROM list_webhooks
+    INNER JOIN lists ON (lists.id = list_webhooks.list_id)
+    WHERE list_webhooks.enabled = true AND $1 = ANY(list_webhooks.events)
+    AND (CASE WHEN ARRAY_LENGTH($2::INT[], 1) > 0 THEN lists.id = ANY($2)
+        ELSE lists.uuid = ANY($3::UUID[]) END);
+
+-- name: create-list-webhook
+INSERT INTO list_webhooks (list_id, url, events, secret, enabled) VALUES($1, $2, $3, $4, $5)
+    RETURNING id;
+
+-- name: update-list-webhook
+UPDATE list_webhooks SET
+    url=$3,
+    events=$4,
+    secret=(CASE WHEN $5 != '' THEN $5 ELSE secret END),
+    enabled=$6,
+    updated_at=NOW()
+WHERE id = $1 AND list_id = $2;
+
+-- name: delete-list-webhook
+DELETE FROM list_webhooks WHERE id = $1 AND list_id = $2;
+
 -- name: update-lists-date
 UPDATE lists SET updated_at=NOW() WHERE id = ANY($1);
 
 -- name: delete-lists
 DELETE FROM lists WHERE id = ALL($1);
 
+-- list groups
+-- name: get-list-groups
+SELECT list_groups.*, COUNT(lists.id) AS list_count
+    FROM list_groups LEFT JOIN lists ON (lists.group_id = list_groups.id)
+    WHERE ($1 = 0 OR list_groups.id = $1)
+    GROUP BY list_groups.id ORDER BY list_groups.name;
+
+-- name: get-list-ids-by-groups
+SELECT id FROM lists WHERE group_id = ANY($1::INT[]) AND archived = false;
+
+-- name: create-list-group
+INSERT INTO list_groups (name) VALUES($1) RETURNING id;
+
+-- name: update-list-group
+UPDATE list_groups SET name=$2, updated_at=NOW() WHERE id = $1;
+
+-- name: delete-list-group
+DELETE FROM list_groups WHERE id = $1;
+
 
 -- campaigns
 -- name: create-campaign
@@ -356,8 +701,8 @@ counts AS (
     AND subscribers.status='enabled'
 ),
 camp AS (
-    INSERT INTO campaigns (uuid, type, name, subject, from_email, body, content_type, send_at, tags, messenger, template_id, to_send, max_subscriber_id)
-        SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, (SELECT id FROM tpl), (SELECT to_send FROM counts), (SELECT max_sub_id FROM counts)
+    INSERT INTO campaigns (uuid, type, name, subject, from_email, body, content_type, send_at, tags, messenger, template_id, to_send, max_subscriber_id, reply_to, body_source, template_vars, track_opens)
+        SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, (SELECT id FROM tpl), (SELECT to_send FROM counts), (SELECT max_sub_id FROM counts), $13, $14, $15, $16
         RETURNING id
 )
 INSERT INTO campaign_lists (campaign_id, list_id, list_name)
@@ -374,7 +719,7 @@ INSERT INTO campaign_lists (campaign_id, list_id, list_name)
 SELECT  campaigns.id, campaigns.uuid, campaigns.name, campaigns.subject, campaigns.from_email,
         campaigns.messenger, campaigns.started_at, campaigns.to_send, campaigns.sent, campaigns.type,
         campaigns.body, campaigns.send_at, campaigns.status, campaigns.content_type, campaigns.tags,
-        campaigns.template_id, campaigns.created_at, campaigns.updated_at,
+        campaigns.template_id, campaigns.track_opens, campaigns.created_at, campaigns.updated_at,
         COUNT(*) OVER () AS total,
         (
             SELECT COALESCE(ARRAY_TO_JSON(ARRAY_AGG(l)), '[]') FROM (
@@ -391,7 +736,10 @@ ORDER BY %s %s OFFSET $4 LIMIT $5;
 
 -- name: get-campaign
 SELECT campaigns.*,
-    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body
+    COALESCE(templates.body, (SELECT body FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1)) AS template_body,
+    COALESCE(templates.variables, (SELECT variables FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1)) AS template_variables,
+    (SELECT COALESCE(JSON_OBJECT_AGG(lang, body), '{}') FROM template_variants
+        WHERE template_id = COALESCE(templates.id, (SELECT id FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1))) AS template_variants
     FROM campaigns
     LEFT JOIN templates ON (templates.id = campaigns.template_id)
     WHERE CASE WHEN $1 > 0 THEN campaigns.id = $1 ELSE uuid = $2 END;
@@ -401,31 +749,140 @@ SELECT campaigns.*,
 -- The query returns results in the same order as the given campaign IDs, and for non-existent campaign IDs,
 -- the query still returns a row with 0 values. Thus, for lazy loading, the application simply iterate on the results in
 -- the same order as the list of campaigns it would've queried and attach the results.
+-- Views and clicks include the daily counts of the raw events that have been pruned. Unique
+-- views can't be added up across days and are only of the raw views in the retention period.
 WITH lists AS (
     SELECT campaign_id, JSON_AGG(JSON_BUILD_OBJECT('id', list_id, 'name', list_name)) AS lists FROM campaign_lists
     WHERE campaign_id = ANY($1) GROUP BY campaign_id
 ), views AS (
-    SELECT campaign_id, COUNT(campaign_id) as num FROM campaign_views
+    SELECT campaign_id, SUM(num)::BIGINT AS num, SUM(uniq)::BIGINT AS uniq FROM (
+        SELECT campaign_id, COUNT(campaign_id) as num, COUNT(DISTINCT subscriber_id) AS uniq FROM campaign_views
+        WHERE campaign_id = ANY($1)
+        GROUP BY campaign_id
+        UNION ALL
+        SELECT campaign_id, SUM(views), 0 FROM campaign_views_daily
+        WHERE campaign_id = ANY($1)
+        GROUP BY campaign_id
+    ) v GROUP BY campaign_id
+),
+clicks AS (
+    SELECT campaign_id, SUM(num)::BIGINT AS num FROM (
+        SELECT campaign_id, COUNT(campaign_id) as num FROM link_clicks
+        WHERE campaign_id = ANY($1)
+        GROUP BY campaign_id
+        UNION ALL
+        SELECT campaign_id, SUM(clicks) FROM link_clicks_daily
+        WHERE campaign_id = ANY($1)
+        GROUP BY campaign_id
+    ) c GROUP BY campaign_id
+),
+bounces AS (
+    SELECT campaign_id, COUNT(campaign_id) as num FROM bounces
     WHERE campaign_id = ANY($1)
     GROUP BY campaign_id
 ),
-clicks AS (
-    SELECT campaign_id, COUNT(campaign_id) as num FROM link_clicks
+complaints AS (
+    SELECT campaign_id, COUNT(campaign_id) as num FROM complaints
     WHERE campaign_id = ANY($1)
     GROUP BY campaign_id
 )
 SELECT id as campaign_id,
     COALESCE(v.num, 0) AS views,
+    COALESCE(v.uniq, 0) AS unique_views,
     COALESCE(c.num, 0) AS clicks,
+    COALESCE(b.num, 0) AS bounces,
+    COALESCE(cm.num, 0) AS complaints,
+    -- Complaints per message sent.
+    COALESCE(cm.num::FLOAT / NULLIF((SELECT sent FROM campaigns WHERE campaigns.id = x.id), 0), 0) AS complaint_rate,
+    COALESCE((SELECT delivered FROM campaigns WHERE campaigns.id = x.id), 0) AS delivered,
     COALESCE(l.lists, '[]') AS lists
 FROM (SELECT id FROM UNNEST($1) AS id) x
 LEFT JOIN lists AS l ON (l.campaign_id = id)
 LEFT JOIN views AS v ON (v.campaign_id = id)
 LEFT JOIN clicks AS c ON (c.campaign_id = id)
+LEFT JOIN bounces AS b ON (b.campaign_id = id)
+LEFT JOIN complaints AS cm ON (cm.campaign_id = id)
 ORDER BY ARRAY_POSITION($1, id);
 
+-- name: record-deferral
+INSERT INTO deferrals (campaign_id, domain) VALUES (NULLIF($1, 0), LOWER(SPLIT_PART($2, '@', 2)));
+
+-- name: get-domain-deliverability
+-- Returns the bounces, deferrals, and complaints of a campaign ($1), or of all messages if it's 0,
+-- by recipient domain between $2 and $3. The events are bucketed by the interval $4 (hour, day,
+-- week, month), or totalled if it's empty. Complaints of deleted subscribers have no domain.
+WITH events AS (
+    SELECT LOWER(SPLIT_PART(subscribers.email, '@', 2)) AS domain, bounces.created_at,
+        (CASE WHEN bounces.type IN ('hard', 'block') THEN 'hard_bounce' ELSE 'bounce' END) AS type
+        FROM bounces INNER JOIN subscribers ON (subscribers.id = bounces.subscriber_id)
+        WHERE ($1 = 0 OR bounces.campaign_id = $1) AND bounces.created_at >= $2 AND bounces.created_at < $3
+    UNION ALL
+    SELECT domain, created_at, 'deferral' FROM deferrals
+        WHERE ($1 = 0 OR campaign_id = $1) AND created_at >= $2 AND created_at < $3
+    UNION ALL
+    SELECT COALESCE(LOWER(SPLIT_PART(subscribers.email, '@', 2)), ''), complaints.created_at, 'complaint'
+        FROM complaints LEFT JOIN subscribers ON (subscribers.id = complaints.subscriber_id)
+        WHERE ($1 = 0 OR complaints.campaign_id = $1) AND complaints.created_at >= $2 AND complaints.created_at < $3
+)
+SELECT domain,
+    (CASE WHEN $4::TEXT = '' THEN NULL ELSE DATE_TRUNC($4::TEXT, created_at) END) AS timestamp,
+    COUNT(*) FILTER (WHERE type IN ('bounce', 'hard_bounce')) AS bounces,
+    COUNT(*) FILTER (WHERE type = 'hard_bounce') AS hard_bounces,
+    COUNT(*) FILTER (WHERE type = 'deferral') AS deferrals,
+    COUNT(*) FILTER (WHERE type = 'complaint') AS complaints
+    FROM events
+    GROUP BY 1, 2
+    ORDER BY 2 NULLS FIRST, COUNT(*) DESC, 1;
+
+-- name: get-campaign-comparison
+-- Returns the metrics of the campaigns with the given IDs ($1), or if there are none, of the
+-- campaigns started between $2 and $3, ordered by their start. Opens and clicks are unique
+-- subscribers, which are only recorded with individual tracking, of the raw views and clicks
+-- in the retention period. Messages are counted as delivered as reported by the messenger,
+-- or as the messages sent that didn't bounce.
+WITH camps AS (
+    SELECT id, name, status, started_at, sent, delivered FROM campaigns
+    WHERE CASE WHEN CARDINALITY($1::INT[]) > 0 THEN id = ANY($1::INT[])
+        ELSE started_at >= $2 AND started_at < $3 END
+), views AS (
+    SELECT campaign_id, COUNT(DISTINCT subscriber_id) AS num FROM campaign_views
+    WHERE campaign_id = ANY(SELECT id FROM camps)
+    GROUP BY campaign_id
+),
+clicks AS (
+    SELECT campaign_id, COUNT(DISTINCT subscriber_id) AS num FROM link_clicks
+    WHERE campaign_id = ANY(SELECT id FROM camps)
+    GROUP BY campaign_id
+),
+unsubs AS (
+    SELECT campaign_id, COUNT(*) AS num FROM unsubscriptions
+    WHERE campaign_id = ANY(SELECT id FROM camps)
+    GROUP BY campaign_id
+),
+bounces AS (
+    SELECT campaign_id, COUNT(DISTINCT subscriber_id) AS num FROM bounces
+    WHERE campaign_id = ANY(SELECT id FROM camps)
+    GROUP BY campaign_id
+)
+SELECT camps.id, camps.name, camps.status, camps.started_at, camps.sent,
+    (CASE WHEN camps.delivered > 0 THEN camps.delivered
+        ELSE GREATEST(camps.sent - COALESCE(b.num, 0), 0) END) AS delivered,
+    COALESCE(v.num, 0) AS opens,
+    COALESCE(c.num, 0) AS clicks,
+    COALESCE(u.num, 0) AS unsubscribes,
+    COALESCE(b.num, 0) AS bounces
+FROM camps
+LEFT JOIN views AS v ON (v.campaign_id = camps.id)
+LEFT JOIN clicks AS c ON (c.campaign_id = camps.id)
+LEFT JOIN unsubs AS u ON (u.campaign_id = camps.id)
+LEFT JOIN bounces AS b ON (b.campaign_id = camps.id)
+ORDER BY camps.started_at NULLS LAST, camps.id;
+
 -- name: get-campaign-for-preview
-SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body,
+SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1)) AS template_body,
+    COALESCE(templates.variables, (SELECT variables FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1)) AS template_variables,
+    (SELECT COALESCE(JSON_OBJECT_AGG(lang, body), '{}') FROM template_variants
+        WHERE template_id = COALESCE(templates.id, (SELECT id FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1))) AS template_variants,
 (
 	SELECT COALESCE(ARRAY_TO_JSON(ARRAY_AGG(l)), '[]') FROM (
 		SELECT COALESCE(campaign_lists.list_id, 0) AS id,
@@ -442,6 +899,11 @@ SELECT id, status, to_send, sent, started_at, updated_at
     FROM campaigns
     WHERE status=$1;
 
+-- name: get-campaign-progress
+SELECT id, status, to_send, sent, started_at, updated_at
+    FROM campaigns
+    WHERE id=$1;
+
 -- name: next-campaigns
 -- Retreives campaigns that are running (or scheduled and the time's up) and need
 -- to be processed. It updates the to_send count and max_subscriber_id of the campaign,
@@ -450,8 +912,11 @@ SELECT id, status, to_send, sent, started_at, updated_at
 -- In addition, it finds the max_subscriber_id, the upper limit across all lists of
 -- a campaign. This is used to fetch and slice subscribers for the campaign in next-subscriber-campaigns.
 WITH camps AS (
-    -- Get all running campaigns and their template bodies (if the template's deleted, the default template body instead)
-    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body
+    -- Get all running campaigns and their template bodies (if the template's deleted, the fallback or default template body instead)
+    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1)) AS template_body,
+    COALESCE(templates.variables, (SELECT variables FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1)) AS template_variables,
+    (SELECT COALESCE(JSON_OBJECT_AGG(lang, body), '{}') FROM template_variants
+        WHERE template_id = COALESCE(templates.id, (SELECT id FROM templates WHERE is_fallback OR is_default ORDER BY is_fallback DESC LIMIT 1))) AS template_variants
     FROM campaigns
     LEFT JOIN templates ON (templates.id = campaigns.template_id)
     WHERE (status='running' OR (status='scheduled' AND NOW() >= campaigns.send_at))
@@ -502,9 +967,10 @@ SELECT * FROM camps;
 -- name: next-campaign-subscribers
 -- Returns a batch of subscribers in a given campaign starting from the last checkpoint
 -- (last_subscriber_id). Every fetch updates the checkpoint and the sent count, which means
--- every fetch returns a new batch of subscribers until all rows are exhausted.
+-- every fetch returns a new batch of subscribers until all rows are exhausted. The messages
+-- to the batch are recorded in the message queue till they're sent.
 WITH camps AS (
-    SELECT last_subscriber_id, max_subscriber_id, type
+    SELECT last_subscriber_id, max_subscriber_id, type, messenger
     FROM campaigns
     WHERE id=$1 AND status='running'
 ),
@@ -545,9 +1011,85 @@ u AS (
         sent = sent + (SELECT COUNT(id) FROM subs),
         updated_at = NOW()
     WHERE (SELECT COUNT(id) FROM subs) > 0 AND id=$1
+),
+q AS (
+    INSERT INTO message_queue (campaign_id, subscriber_id, messenger)
+        SELECT $1, id, (SELECT messenger FROM camps) FROM subs
+        ON CONFLICT DO NOTHING
 )
 SELECT * FROM subs;
 
+-- name: next-queued-campaign-subscribers
+-- Returns a batch of subscribers in a running campaign whose messages were pending in the
+-- message queue when listmonk stopped, and marks them queued.
+WITH q AS (
+    UPDATE message_queue SET status='queued', updated_at=NOW()
+    WHERE id IN (
+        SELECT message_queue.id FROM message_queue
+        INNER JOIN subscribers ON (subscribers.id = message_queue.subscriber_id)
+        WHERE message_queue.campaign_id=$1 AND message_queue.status='pending'
+            AND subscribers.status != 'blocklisted'
+            AND (SELECT status FROM campaigns WHERE id=$1) = 'running'
+        ORDER BY message_queue.subscriber_id LIMIT $2
+    )
+    RETURNING subscriber_id
+)
+SELECT subscribers.* FROM subscribers
+    INNER JOIN q ON (q.subscriber_id = subscribers.id)
+    ORDER BY subscribers.id;
+
+-- name: queue-message
+INSERT INTO message_queue (messenger, message) VALUES($1, $2) RETURNING id;
+
+-- name: next-queued-messages
+-- Returns a batch of non-campaign messages that were pending in the message queue
+-- when listmonk stopped, and marks them queued.
+UPDATE message_queue SET status='queued', updated_at=NOW()
+    WHERE id IN (
+        SELECT id FROM message_queue
+        WHERE campaign_id IS NULL AND status='pending'
+        ORDER BY id LIMIT $1
+    )
+    RETURNING id, messenger, message, created_at;
+
+-- name: update-queued-messages-status
+-- Updates the status ($4) of messages in the queue by their IDs ($1), or campaign ($2)
+-- and subscriber ($3) ID pairs.
+UPDATE message_queue SET status=$4, updated_at=NOW()
+    WHERE id = ANY($1::BIGINT[])
+    OR (campaign_id, subscriber_id) IN (SELECT * FROM UNNEST($2::INT[], $3::INT[]));
+
+-- name: delete-queued-messages
+-- Deletes sent messages from the queue by their IDs ($1), or campaign ($2) and
+-- subscriber ($3) ID pairs.
+DELETE FROM message_queue
+    WHERE id = ANY($1::BIGINT[])
+    OR (campaign_id, subscriber_id) IN (SELECT * FROM UNNEST($2::INT[], $3::INT[]));
+
+-- name: reset-message-queue
+-- Recovers the message queue on startup. The messages of campaigns that are no longer
+-- running or paused, and of blocklisted subscribers are dropped. Campaign messages that
+-- were being sent and have been recorded in the delivery log went out (or failed) and are
+-- dropped too. The rest are marked pending to be sent again, including the messages that
+-- were being sent as it's unknown whether they went out.
+-- Returns the number of messages that were being sent and are sent again.
+WITH d AS (
+    DELETE FROM message_queue WHERE
+        campaign_id IN (SELECT id FROM campaigns WHERE status NOT IN ('running', 'paused'))
+        OR subscriber_id IN (SELECT id FROM subscribers WHERE status='blocklisted')
+        OR (status='sending' AND EXISTS (
+            SELECT 1 FROM delivery_log WHERE delivery_log.campaign_id = message_queue.campaign_id
+            AND delivery_log.subscriber_id = message_queue.subscriber_id
+            AND delivery_log.created_at >= message_queue.created_at
+        ))
+    RETURNING id
+),
+u AS (
+    UPDATE message_queue SET status='pending', updated_at=NOW()
+    WHERE status IN ('queued', 'sending') AND id NOT IN (SELECT id FROM d)
+)
+SELECT COUNT(*) FROM message_queue WHERE status='sending' AND id NOT IN (SELECT id FROM d);
+
 -- name: get-one-campaign-subscriber
 SELECT * FROM subscribers
 LEFT JOIN subscriber_lists ON (subscribers.id = subscriber_lists.subscriber_id AND subscriber_lists.status != 'unsubscribed')
@@ -563,12 +1105,16 @@ WITH camp AS (
         subject=(CASE WHEN $3 != '' THEN $3 ELSE subject END),
         from_email=(CASE WHEN $4 != '' THEN $4 ELSE from_email END),
         body=(CASE WHEN $5 != '' THEN $5 ELSE body END),
+        body_source=(CASE WHEN $5 != '' THEN $14 ELSE body_source END),
         content_type=(CASE WHEN $6 != '' THEN $6::content_type ELSE content_type END),
         send_at=(CASE WHEN $8 THEN $7::TIMESTAMP WITH TIME ZONE WHEN NOT $8 THEN NULL ELSE send_at END),
         status=(CASE WHEN NOT $8 THEN 'draft' ELSE status END),
         tags=$9::VARCHAR(100)[],
         messenger=(CASE WHEN $10 != '' THEN $10 ELSE messenger END),
         template_id=(CASE WHEN $11 != 0 THEN $11 ELSE template_id END),
+        reply_to=$13,
+        template_vars=$15,
+        track_opens=$16,
         updated_at=NOW()
     WHERE id = $1 RETURNING id
 ),
@@ -588,6 +1134,25 @@ UPDATE campaigns SET
     updated_at=NOW()
 WHERE id=$1;
 
+-- name: get-campaign-frequency-violations
+-- Returns the lists of a campaign ($1) whose send frequency rule (at most max_campaigns
+-- campaigns in max_campaigns_days days) would be violated by sending the campaign at $2.
+-- Other campaigns on the list that have been sent, or are scheduled to be sent, within
+-- the window on either side of $2 are counted.
+SELECT lists.* FROM lists
+    INNER JOIN campaign_lists ON (campaign_lists.list_id = lists.id AND campaign_lists.campaign_id = $1)
+    WHERE lists.max_campaigns > 0 AND lists.max_campaigns_days > 0
+    AND (
+        SELECT COUNT(*) FROM campaign_lists cl
+        INNER JOIN campaigns ON (campaigns.id = cl.campaign_id)
+        WHERE cl.list_id = lists.id AND campaigns.id != $1
+        AND campaigns.status IN ('scheduled', 'running', 'paused', 'finished')
+        AND COALESCE(campaigns.started_at, campaigns.send_at) >
+            $2::TIMESTAMP WITH TIME ZONE - MAKE_INTERVAL(days => lists.max_campaigns_days)
+        AND COALESCE(campaigns.started_at, campaigns.send_at) <
+            $2::TIMESTAMP WITH TIME ZONE + MAKE_INTERVAL(days => lists.max_campaigns_days)
+    ) >= lists.max_campaigns;
+
 -- name: update-campaign-status
 UPDATE campaigns SET status=$2, updated_at=NOW() WHERE id = $1;
 
@@ -595,68 +1160,447 @@ UPDATE campaigns SET status=$2, updated_at=NOW() WHERE id = $1;
 DELETE FROM campaigns WHERE id=$1;
 
 -- name: register-campaign-view
+-- Repeated views by a subscriber within a minute, eg: pixel reloads and prefetches
+-- by mail clients, are debounced. This is best-effort and not idempotent: concurrent
+-- requests can both pass the NOT EXISTS check and record a view each, which only
+-- slightly inflates the total views as unique views are counted by subscriber.
+-- Anonymous views are always recorded.
 WITH view AS (
     SELECT campaigns.id as campaign_id, subscribers.id AS subscriber_id FROM campaigns
     LEFT JOIN subscribers ON (CASE WHEN $2::TEXT != '' THEN subscribers.uuid = $2::UUID ELSE FALSE END)
     WHERE campaigns.uuid = $1
 )
-INSERT INTO campaign_views (campaign_id, subscriber_id)
-    VALUES((SELECT campaign_id FROM view), (SELECT subscriber_id FROM view));
+INSERT INTO campaign_views (campaign_id, subscriber_id, ip, country, region, client, device, user_agent)
+    SELECT campaign_id, subscriber_id, $3, $4, $5, $6, $7, $8 FROM view WHERE NOT EXISTS (
+        SELECT 1 FROM campaign_views v WHERE v.campaign_id = view.campaign_id
+            AND v.subscriber_id = view.subscriber_id AND v.created_at > NOW() - INTERVAL '1 minute'
+    );
+
+-- name: export-campaign-views
+-- Returns a batch of raw views of a campaign ($1), or of all campaigns if it's 0, between $2 and $3
+-- for exports. Batches are paginated by the last seen ID ($4).
+SELECT campaign_views.id, 'view' AS type, campaigns.id AS campaign_id, campaigns.uuid AS campaign_uuid,
+    COALESCE(subscribers.uuid::TEXT, '') AS subscriber_uuid, '' AS url,
+    campaign_views.ip, campaign_views.country, campaign_views.region, campaign_views.client,
+    campaign_views.device, campaign_views.user_agent, campaign_views.created_at
+    FROM campaign_views
+    INNER JOIN campaigns ON (campaigns.id = campaign_views.campaign_id)
+    LEFT JOIN subscribers ON (subscribers.id = campaign_views.subscriber_id)
+    WHERE ($1 = 0 OR campaign_views.campaign_id = $1)
+        AND campaign_views.created_at >= $2 AND campaign_views.created_at < $3
+        AND campaign_views.id > $4
+    ORDER BY campaign_views.id LIMIT $5;
+
+-- name: export-link-clicks
+-- Returns a batch of raw link clicks of a campaign ($1), or of all campaigns if it's 0, between $2
+-- and $3 for exports. Batches are paginated by the last seen ID ($4).
+SELECT link_clicks.id, 'click' AS type, COALESCE(campaigns.id, 0) AS campaign_id,
+    COALESCE(campaigns.uuid::TEXT, '') AS campaign_uuid,
+    COALESCE(subscribers.uuid::TEXT, '') AS subscriber_uuid, links.url,
+    link_clicks.ip, link_clicks.country, link_clicks.region, link_clicks.client,
+    link_clicks.device, link_clicks.user_agent, link_clicks.created_at
+    FROM link_clicks
+    INNER JOIN links ON (links.id = link_clicks.link_id)
+    LEFT JOIN campaigns ON (campaigns.id = link_clicks.campaign_id)
+    LEFT JOIN subscribers ON (subscribers.id = link_clicks.subscriber_id)
+    WHERE ($1 = 0 OR link_clicks.campaign_id = $1)
+        AND link_clicks.created_at >= $2 AND link_clicks.created_at < $3
+        AND link_clicks.id > $4
+    ORDER BY link_clicks.id LIMIT $5;
+
+-- name: get-campaign-view-stats
+-- Returns the total and unique views of a campaign and their timeline in buckets of
+-- $2 (hour, day, week, month). Unique views are counted only with individual tracking. Only
+-- the raw views in the retention period are counted.
+WITH v AS (
+    SELECT subscriber_id, created_at FROM campaign_views WHERE campaign_id = $1
+),
+tl AS (
+    SELECT DATE_TRUNC($2, created_at) AS timestamp, COUNT(*) AS views,
+        COUNT(DISTINCT subscriber_id) AS unique_views
+    FROM v GROUP BY 1 ORDER BY 1
+)
+SELECT (SELECT COUNT(*) FROM v) AS views,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM v) AS unique_views,
+    COALESCE((SELECT JSON_AGG(tl) FROM tl), '[]') AS timeline;
+
+-- name: get-subscriber-view-stats
+-- Returns the views of a subscriber per campaign in the views retention period.
+SELECT campaign_views.campaign_id, campaigns.name AS campaign_name, COUNT(*) AS views,
+    MIN(campaign_views.created_at) AS first_view_at, MAX(campaign_views.created_at) AS last_view_at
+    FROM campaign_views
+    INNER JOIN campaigns ON (campaigns.id = campaign_views.campaign_id)
+    WHERE campaign_views.subscriber_id = $1
+    GROUP BY campaign_views.campaign_id, campaigns.name
+    ORDER BY last_view_at DESC;
+
+-- name: get-subscriber-engagement
+-- Returns the lifetime engagement of a subscriber. The campaigns received are the ones in the
+-- delivery log, the ones the subscriber opened or clicked, and the regular campaigns to the
+-- subscriber's lists that had been sent up to the subscriber while they were subscribed.
+-- Opens and clicks are of the raw views and clicks that haven't been pruned.
+WITH sub AS (
+    SELECT id FROM subscribers WHERE id = $1
+),
+views AS (
+    SELECT campaign_id, created_at FROM campaign_views WHERE subscriber_id = $1
+),
+clicks AS (
+    SELECT campaign_id, created_at FROM link_clicks WHERE subscriber_id = $1
+),
+received AS (
+    SELECT campaign_id FROM delivery_log WHERE subscriber_id = $1 AND status = 'sent'
+    UNION SELECT campaign_id FROM views
+    UNION SELECT campaign_id FROM clicks
+    UNION
+    SELECT c.id FROM campaigns c
+        INNER JOIN campaign_lists cl ON (cl.campaign_id = c.id)
+        INNER JOIN subscriber_lists sl ON (sl.list_id = cl.list_id AND sl.subscriber_id = $1)
+        WHERE c.type = 'regular' AND c.started_at IS NOT NULL
+        AND $1 <= LEAST(c.max_subscriber_id, c.last_subscriber_id)
+        AND (sl.status != 'unsubscribed' OR sl.updated_at > c.started_at)
+)
+SELECT sub.id AS subscriber_id,
+    (SELECT COUNT(campaign_id) FROM received) AS campaigns_received,
+    (SELECT COUNT(DISTINCT campaign_id) FROM views) AS campaigns_opened,
+    (SELECT COUNT(DISTINCT campaign_id) FROM clicks) AS campaigns_clicked,
+    (SELECT COUNT(*) FROM views) AS opens,
+    (SELECT COUNT(*) FROM clicks) AS clicks,
+    (SELECT MAX(created_at) FROM views) AS last_open_at,
+    (SELECT MAX(created_at) FROM clicks) AS last_click_at
+    FROM sub;
+
+-- name: record-subscriber-cohorts
+-- Records the subscribers of each monthly signup cohort who opened and clicked campaigns in
+-- every month since the cohort's. The months from the one before the last recorded month are
+-- recomputed to include late activity, and if nothing has been recorded, all months are.
+-- Activity is only recorded per subscriber with individual tracking. The tracking retention
+-- is at least 90 days so that the recomputed months have all their raw views and clicks.
+WITH since AS (
+    SELECT COALESCE((MAX(month) - INTERVAL '1 month')::DATE, '-infinity') AS date FROM subscriber_cohorts
+),
+subs AS (
+    SELECT id, DATE_TRUNC('month', created_at)::DATE AS cohort FROM subscribers
+),
+sizes AS (
+    SELECT cohort, COUNT(*) AS num FROM subs GROUP BY cohort
+),
+months AS (
+    SELECT sizes.cohort, sizes.num, m::DATE AS month FROM sizes, since,
+        GENERATE_SERIES(GREATEST(sizes.cohort, since.date), DATE_TRUNC('month', NOW())::DATE, '1 month') m
+),
+views AS (
+    SELECT DISTINCT subscriber_id, DATE_TRUNC('month', created_at)::DATE AS month FROM campaign_views
+    WHERE subscriber_id IS NOT NULL AND created_at >= (SELECT date FROM since)
+),
+clicks AS (
+    SELECT DISTINCT subscriber_id, DATE_TRUNC('month', created_at)::DATE AS month FROM link_clicks
+    WHERE subscriber_id IS NOT NULL AND created_at >= (SELECT date FROM since)
+),
+activity AS (
+    SELECT COALESCE(v.subscriber_id, c.subscriber_id) AS subscriber_id, COALESCE(v.month, c.month) AS month,
+        v.subscriber_id IS NOT NULL AS opened, c.subscriber_id IS NOT NULL AS clicked
+    FROM views v FULL OUTER JOIN clicks c ON (c.subscriber_id = v.subscriber_id AND c.month = v.month)
+),
+eng AS (
+    SELECT subs.cohort, activity.month,
+        COUNT(*) FILTER (WHERE activity.opened) AS opened,
+        COUNT(*) FILTER (WHERE activity.clicked) AS clicked,
+        COUNT(*) AS engaged
+    FROM activity JOIN subs ON (subs.id = activity.subscriber_id)
+    GROUP BY subs.cohort, activity.month
+)
+INSERT INTO subscriber_cohorts (cohort, month, subscribers, opened, clicked, engaged)
+    SELECT months.cohort, months.month, months.num,
+        COALESCE(eng.opened, 0), COALESCE(eng.clicked, 0), COALESCE(eng.engaged, 0)
+    FROM months
+    LEFT JOIN eng ON (eng.cohort = months.cohort AND eng.month = months.month)
+ON CONFLICT (cohort, month) DO UPDATE SET subscribers = EXCLUDED.subscribers,
+    opened = EXCLUDED.opened, clicked = EXCLUDED.clicked, engaged = EXCLUDED.engaged;
+
+-- name: get-subscriber-cohorts
+-- Returns the monthly engagement of the signup cohorts between two months ($1, $2).
+SELECT TO_CHAR(cohort, 'YYYY-MM') AS cohort, TO_CHAR(month, 'YYYY-MM') AS month,
+    ((DATE_PART('year', month) - DATE_PART('year', cohort)) * 12 +
+        DATE_PART('month', month) - DATE_PART('month', cohort))::INT AS month_index,
+    subscribers, opened, clicked, engaged
+    FROM subscriber_cohorts
+    WHERE cohort BETWEEN $1::DATE AND $2::DATE
+    ORDER BY cohort, month;
 
 -- users
 -- name: get-users
-SELECT * FROM users WHERE $1 = 0 OR id = $1 OFFSET $2 LIMIT $3;
+-- Returns all the admin users or a single user by ID ($1).
+SELECT * FROM users WHERE ($1 = 0 OR id = $1) ORDER BY username;
+
+-- name: get-user-by-username
+SELECT * FROM users WHERE username = LOWER($1);
+
+-- name: get-user-count
+-- Returns the number of users and the number of enabled admins.
+SELECT COUNT(*) AS total,
+    COUNT(*) FILTER (WHERE role = 'admin' AND status = 'enabled') AS admins
+    FROM users;
 
 -- name: create-user
-INSERT INTO users (email, name, password, type, status) VALUES($1, $2, $3, $4, $5) RETURNING id;
+INSERT INTO users (username, name, email, password, role, status)
+    VALUES(LOWER($1), $2, $3, $4, $5, $6) RETURNING id;
 
 -- name: update-user
+-- Updates a user. The password ($5) is retained if it's empty.
 UPDATE users SET
-    email=(CASE WHEN $2 != '' THEN $2 ELSE email END),
-    name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
-    password=(CASE WHEN $4 != '' THEN $4 ELSE password END),
-    type=(CASE WHEN $5 != '' THEN $5::user_type ELSE type END),
-    status=(CASE WHEN $6 != '' THEN $6::user_status ELSE status END),
+    name=$2,
+    email=$3,
+    role=$4,
+    password=(CASE WHEN $5 != '' THEN $5 ELSE password END),
+    status=$6,
     updated_at=NOW()
 WHERE id = $1;
 
+-- name: update-user-login
+UPDATE users SET last_login_at=NOW() WHERE id = $1;
+
+-- name: update-user-totp
+-- Sets the TOTP secret, 2FA status, and the hashed recovery codes of a user.
+UPDATE users SET totp_secret=$2, totp_enabled=$3, recovery_codes=$4, updated_at=NOW() WHERE id = $1;
+
+-- name: consume-user-recovery-code
+-- Removes a hashed recovery code ($2) of a user if it exists.
+UPDATE users SET recovery_codes=ARRAY_REMOVE(recovery_codes, $2)
+    WHERE id = $1 AND $2 = ANY(recovery_codes) RETURNING id;
+
 -- name: delete-user
--- Delete a user, except for the primordial super admin.
-DELETE FROM users WHERE $1 != 1 AND id=$1;
+DELETE FROM users WHERE id = $1;
+
+-- name: create-session
+INSERT INTO sessions (id, user_id, csrf_token, ip, user_agent, expires_at) VALUES($1, $2, $3, $4, $5, $6);
+
+-- name: get-session
+-- Returns the enabled user and the CSRF token of an unexpired session.
+SELECT users.*, sessions.csrf_token FROM sessions
+    INNER JOIN users ON (users.id = sessions.user_id)
+    WHERE sessions.id = $1 AND sessions.expires_at > NOW() AND users.status = 'enabled';
+
+-- name: delete-session
+DELETE FROM sessions WHERE id = $1;
+
+-- name: delete-user-sessions
+-- Deletes the sessions of a user except for the given one ($2).
+DELETE FROM sessions WHERE user_id = $1 AND id != $2;
+
+-- name: delete-expired-sessions
+DELETE FROM sessions WHERE expires_at <= NOW();
+
+-- name: get-roles
+SELECT role, permissions, updated_at FROM role_permissions ORDER BY role;
+
+-- name: update-role
+INSERT INTO role_permissions (role, permissions) VALUES($1, $2)
+    ON CONFLICT (role) DO UPDATE SET permissions = $2, updated_at = NOW()
+    RETURNING role, permissions, updated_at;
+
+-- name: upsert-api-usage
+-- Adds the request ($2) and error ($3) counts of an API user since the last update.
+-- Users that have been deleted in the meantime are skipped.
+INSERT INTO api_usage (user_id, requests, errors, last_ip, last_seen_at, last_error_at)
+    SELECT $1, $2, $3, $4, $5, $6 WHERE EXISTS (SELECT 1 FROM users WHERE id = $1)
+    ON CONFLICT (user_id) DO UPDATE SET
+        requests = api_usage.requests + EXCLUDED.requests,
+        errors = api_usage.errors + EXCLUDED.errors,
+        last_ip = EXCLUDED.last_ip,
+        last_seen_at = GREATEST(api_usage.last_seen_at, EXCLUDED.last_seen_at),
+        last_error_at = GREATEST(api_usage.last_error_at, EXCLUDED.last_error_at);
+
+-- name: get-api-usage
+-- Returns the API usage of all users including the ones that have never made
+-- API requests so that unused credentials can be identified.
+SELECT users.id AS user_id, users.username, users.role, users.status,
+    COALESCE(api_usage.requests, 0) AS requests, COALESCE(api_usage.errors, 0) AS errors,
+    COALESCE(api_usage.last_ip, '') AS last_ip, api_usage.last_seen_at, api_usage.last_error_at
+    FROM users
+    LEFT JOIN api_usage ON (api_usage.user_id = users.id)
+    ORDER BY users.username;
 
 
 -- templates
+-- name: get-template-active-campaigns
+-- Returns the number of scheduled, running or paused campaigns that use a template.
+SELECT COUNT(*) FROM campaigns WHERE template_id = $1 AND status IN ('scheduled', 'running', 'paused');
+
 -- name: get-templates
--- Only if the second param ($2) is true, body is returned.
+-- Only if the second param ($2) is true, body is returned. Optionally filtered
+-- by folder ($3) and tags ($4, any of).
 SELECT id, name, (CASE WHEN $2 = false THEN body ELSE '' END) as body,
-    is_default, created_at, updated_at
-    FROM templates WHERE $1 = 0 OR id = $1
+    (CASE WHEN $2 = false THEN body_source ELSE '' END) as body_source,
+    is_default, is_fallback, type, subject, variables, thumb, folder, tags, created_at, updated_at
+    FROM templates WHERE ($1 = 0 OR id = $1)
+    AND ($3 = '' OR folder = $3)
+    AND (CARDINALITY($4::VARCHAR(100)[]) = 0 OR tags && $4::VARCHAR(100)[])
     ORDER BY created_at;
 
+-- name: get-template-folders
+SELECT folder, COUNT(*) AS count FROM templates WHERE folder != ''
+    GROUP BY folder ORDER BY folder;
+
+-- name: get-tx-template
+-- Gets a transactional template by id ($1) or name ($2).
+SELECT * FROM templates WHERE type = 'tx' AND
+    (CASE WHEN $1 > 0 THEN id = $1 ELSE name = $2 END);
+
 -- name: create-template
-INSERT INTO templates (name, body) VALUES($1, $2) RETURNING id;
+INSERT INTO templates (name, body, body_source, type, subject, variables, folder, tags)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8::VARCHAR(100)[]) RETURNING id;
 
 -- name: update-template
+-- The type of a template can't be changed.
 UPDATE templates SET
+    name=(CASE WHEN $2 != '' THEN $2 ELSE name END),
+    body=(CASE WHEN $3 != '' THEN $3 ELSE body END),
+    body_source=(CASE WHEN $3 != '' THEN $4 ELSE body_source END),
+    subject=$5,
+    variables=$6,
+    folder=$7,
+    tags=$8::VARCHAR(100)[],
+    updated_at=NOW()
+WHERE id = $1;
+
+-- name: update-template-thumb
+-- Returns the previous thumb.
+UPDATE templates SET thumb=$2 FROM (SELECT thumb FROM templates WHERE id = $1) old
+    WHERE templates.id = $1 RETURNING old.thumb;
+
+-- name: get-template-variants
+SELECT * FROM template_variants WHERE template_id = $1 AND ($2 = '' OR lang = $2) ORDER BY lang;
+
+-- name: upsert-template-variant
+INSERT INTO template_variants (template_id, lang, body, body_source) VALUES($1, $2, $3, $4)
+    ON CONFLICT (template_id, lang) DO UPDATE SET body=$3, body_source=$4, updated_at=NOW()
+    RETURNING id;
+
+-- name: delete-template-variant
+DELETE FROM template_variants WHERE template_id = $1 AND lang = $2;
+
+-- name: get-template-fixtures
+SELECT * FROM template_fixtures WHERE template_id = $1 AND ($2 = 0 OR id = $2) ORDER BY name;
+
+-- name: create-template-fixture
+INSERT INTO template_fixtures (template_id, name, subscriber, campaign, data)
+    VALUES($1, $2, $3, $4, $5) RETURNING id;
+
+-- name: update-template-fixture
+UPDATE template_fixtures SET
+    name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
+    subscriber=$4,
+    campaign=$5,
+    data=$6,
+    updated_at=NOW()
+WHERE template_id = $1 AND id = $2;
+
+-- name: delete-template-fixture
+DELETE FROM template_fixtures WHERE template_id = $1 AND id = $2;
+
+-- name: get-template-partials
+SELECT * FROM template_partials WHERE $1 = 0 OR id = $1 ORDER BY name;
+
+-- name: create-template-partial
+INSERT INTO template_partials (name, body) VALUES($1, $2) RETURNING id;
+
+-- name: update-template-partial
+UPDATE template_partials SET
     name=(CASE WHEN $2 != '' THEN $2 ELSE name END),
     body=(CASE WHEN $3 != '' THEN $3 ELSE body END),
     updated_at=NOW()
 WHERE id = $1;
 
+-- name: delete-template-partial
+DELETE FROM template_partials WHERE id = $1;
+
+-- name: get-template-partial-refs
+-- Returns the templates, template language variants, system templates, and campaigns that
+-- haven't finished or been cancelled that include the partial with the name $1.
+WITH re AS (
+    SELECT 'Partial\s+["`]' || $1 || '["`]' AS re
+)
+SELECT 'template' AS type, name FROM templates, re WHERE body ~ re.re
+UNION ALL
+SELECT 'template variant', templates.name || ' (' || v.lang || ')' FROM template_variants v
+    JOIN templates ON (templates.id = v.template_id), re
+    WHERE v.body ~ re.re
+UNION ALL
+SELECT 'system template', name || ' (' || lang || ')' FROM system_templates, re WHERE body ~ re.re
+UNION ALL
+SELECT 'campaign', name FROM campaigns, re
+    WHERE status NOT IN ('finished', 'cancelled') AND body ~ re.re
+ORDER BY type, name;
+
+-- name: get-system-templates
+SELECT * FROM system_templates WHERE $1 = '' OR name = $1 ORDER BY name, lang;
+
+-- name: get-system-template
+-- Gets a system template in the first available language of the given
+-- languages ($2) in order of preference.
+SELECT * FROM system_templates WHERE name = $1 AND lang = ANY($2::TEXT[])
+    ORDER BY ARRAY_POSITION($2::TEXT[], lang) LIMIT 1;
+
+-- name: upsert-system-template
+INSERT INTO system_templates (name, lang, subject, body) VALUES($1, $2, $3, $4)
+    ON CONFLICT (name, lang) DO UPDATE SET subject=$3, body=$4, updated_at=NOW()
+    RETURNING id;
+
+-- name: delete-system-template
+DELETE FROM system_templates WHERE name = $1 AND lang = $2;
+
+-- name: insert-template-revision
+-- Records the current body of a template ($1) as a revision by the author $2,
+-- unless it's unchanged from the last revision.
+INSERT INTO template_revisions (template_id, body, body_source, author)
+    SELECT id, body, body_source, $2 FROM templates WHERE id = $1
+    AND body IS DISTINCT FROM (SELECT body FROM template_revisions WHERE template_id = $1 ORDER BY id DESC LIMIT 1);
+
+-- name: get-template-revisions
+-- Returns all revisions of a template ($1) or a single revision ($2). Only if the
+-- third param ($3) is true, bodies are skipped.
+SELECT COUNT(*) OVER () AS total, id, template_id, author, created_at,
+    (CASE WHEN $3 = false THEN body ELSE '' END) AS body,
+    (CASE WHEN $3 = false THEN body_source ELSE '' END) AS body_source
+    FROM template_revisions WHERE template_id = $1 AND ($2 = 0 OR id = $2)
+    ORDER BY id DESC OFFSET $4 LIMIT (CASE WHEN $5 = 0 THEN NULL ELSE $5 END);
+
+-- name: restore-template-revision
+-- Restores a template ($1) to the body of one of its revisions ($2).
+UPDATE templates SET body = r.body, body_source = r.body_source, updated_at = NOW()
+    FROM template_revisions r
+    WHERE templates.id = $1 AND r.id = $2 AND r.template_id = $1;
+
 -- name: set-default-template
 WITH u AS (
     UPDATE templates SET is_default=true WHERE id=$1 RETURNING id
 )
 UPDATE templates SET is_default=false WHERE id != $1;
 
+-- name: set-fallback-template
+-- Sets the template ($1) as the fallback template.
+WITH u AS (
+    UPDATE templates SET is_fallback=true WHERE id=$1 RETURNING id
+)
+UPDATE templates SET is_fallback=false WHERE id != $1;
+
+-- name: unset-fallback-template
+-- Unsets the fallback template if it's the template $1.
+UPDATE templates SET is_fallback=false WHERE id=$1 AND is_fallback;
+
 -- name: delete-template
--- Delete a template as long as there's more than one. One deletion, set all campaigns
--- with that template to the default template instead.
+-- Delete a template as long as there's more than one and it's not the default or fallback
+-- template or used by active campaigns. One deletion, set all campaigns with that template
+-- to the fallback or default template instead.
 WITH tpl AS (
-    DELETE FROM templates WHERE id = $1 AND (SELECT COUNT(id) FROM templates) > 1 AND is_default = false RETURNING id
+    DELETE FROM templates WHERE id = $1 AND (SELECT COUNT(id) FROM templates) > 1
+    AND is_default = false AND is_fallback = false
+    AND NOT EXISTS (SELECT 1 FROM campaigns WHERE template_id = $1 AND status IN ('scheduled', 'running', 'paused'))
+    RETURNING id
 ),
 def AS (
-    SELECT id FROM templates WHERE is_default = true LIMIT 1
+    SELECT id FROM templates WHERE (is_fallback OR is_default) AND id != $1 ORDER BY is_fallback DESC LIMIT 1
 )
 UPDATE campaigns SET template_id = (SELECT id FROM def) WHERE (SELECT id FROM tpl) > 0 AND template_id = $1
     RETURNING (SELECT id FROM tpl);
@@ -680,27 +1624,230 @@ INSERT INTO links (uuid, url) VALUES($1, $2) ON CONFLICT (url) DO UPDATE SET url
 WITH link AS(
     SELECT id, url FROM links WHERE uuid = $1
 )
-INSERT INTO link_clicks (campaign_id, subscriber_id, link_id) VALUES(
+INSERT INTO link_clicks (campaign_id, subscriber_id, link_id, ip, country, region, client, device, user_agent, position) VALUES(
     (SELECT id FROM campaigns WHERE uuid = $2),
     (SELECT id FROM subscribers WHERE
         (CASE WHEN $3::TEXT != '' THEN subscribers.uuid = $3::UUID ELSE FALSE END)
     ),
-    (SELECT id FROM link)
+    (SELECT id FROM link),
+    $4, $5, $6, $7, $8, $9, NULLIF($10::INT, 0)
 ) RETURNING (SELECT url FROM link);
 
+-- name: get-campaign-link-counts
+-- Returns the click counts of the links in a campaign, most clicked first, including the
+-- daily counts of the raw clicks that have been pruned.
+SELECT links.url, SUM(c.num)::BIGINT AS count FROM (
+    SELECT link_id, COUNT(*) AS num FROM link_clicks WHERE campaign_id = $1 GROUP BY link_id
+    UNION ALL
+    SELECT link_id, SUM(clicks) FROM link_clicks_daily WHERE campaign_id = $1 GROUP BY link_id
+) c
+    JOIN links ON (links.id = c.link_id)
+    GROUP BY links.url ORDER BY count DESC LIMIT $2;
+
+-- name: get-campaign-link-stats
+-- Returns the links clicked in a campaign, most clicked first, with their total and
+-- unique clicks, first and last click times, and the top $2 subscribers by clicks.
+-- Unique clicks and subscribers are counted only with individual tracking. Only the raw
+-- clicks in the retention period are counted.
+WITH clicks AS (
+    SELECT link_id, subscriber_id, created_at FROM link_clicks WHERE campaign_id = $1
+),
+subs AS (
+    SELECT link_id, subscriber_id, COUNT(*) AS clicks,
+        ROW_NUMBER() OVER (PARTITION BY link_id ORDER BY COUNT(*) DESC, subscriber_id) AS rank
+    FROM clicks WHERE subscriber_id IS NOT NULL
+    GROUP BY link_id, subscriber_id
+)
+SELECT links.url, COUNT(*) AS clicks, COUNT(DISTINCT clicks.subscriber_id) AS unique_clicks,
+    MIN(clicks.created_at) AS first_click_at, MAX(clicks.created_at) AS last_click_at,
+    COALESCE((
+        SELECT JSON_AGG(JSON_BUILD_OBJECT('id', s.id, 'uuid', s.uuid, 'email', s.email,
+            'name', s.name, 'clicks', subs.clicks) ORDER BY subs.rank)
+        FROM subs JOIN subscribers s ON (s.id = subs.subscriber_id)
+        WHERE subs.link_id = links.id AND subs.rank <= $2
+    ), '[]') AS top_subscribers
+    FROM clicks
+    JOIN links ON (links.id = clicks.link_id)
+    GROUP BY links.id, links.url
+    ORDER BY clicks DESC, links.url;
+
+-- name: get-campaign-geo-stats
+-- Returns the views and clicks of a campaign by country, or by country and region if $2 is true.
+-- The daily counts of pruned events have no location, so only the raw events are counted.
+WITH v AS (
+    SELECT country, (CASE WHEN $2 THEN region ELSE '' END) AS region, COUNT(*) AS views
+    FROM campaign_views WHERE campaign_id = $1 GROUP BY 1, 2
+),
+c AS (
+    SELECT country, (CASE WHEN $2 THEN region ELSE '' END) AS region, COUNT(*) AS clicks
+    FROM link_clicks WHERE campaign_id = $1 GROUP BY 1, 2
+)
+SELECT COALESCE(v.country, c.country) AS country, COALESCE(v.region, c.region) AS region,
+    COALESCE(v.views, 0) AS views, COALESCE(c.clicks, 0) AS clicks
+    FROM v FULL OUTER JOIN c ON (c.country = v.country AND c.region = v.region)
+    ORDER BY views DESC, clicks DESC, country, region;
+
+-- name: get-campaign-client-stats
+-- Returns the views and clicks of a campaign by e-mail client or browser, or by device class if $2 is true.
+-- Like the geo stats, it only counts the raw events in the retention period.
+WITH v AS (
+    SELECT (CASE WHEN $2 THEN device ELSE client END) AS name, COUNT(*) AS views
+    FROM campaign_views WHERE campaign_id = $1 GROUP BY 1
+),
+c AS (
+    SELECT (CASE WHEN $2 THEN device ELSE client END) AS name, COUNT(*) AS clicks
+    FROM link_clicks WHERE campaign_id = $1 GROUP BY 1
+)
+SELECT COALESCE(v.name, c.name) AS name, COALESCE(v.views, 0) AS views, COALESCE(c.clicks, 0) AS clicks
+    FROM v FULL OUTER JOIN c ON (c.name = v.name)
+    ORDER BY views DESC, clicks DESC, name;
+
+-- name: get-campaign-unsubscribe-stats
+-- Returns the unsubscriptions from a campaign, their rate of the messages sent, the time between
+-- the delivery of the message and the unsubscription, and the reasons given. Messages are taken
+-- to be delivered when they were logged as sent, or when the campaign started if they weren't.
+WITH u AS (
+    SELECT u.reason, EXTRACT(EPOCH FROM u.created_at - COALESCE(
+        (SELECT MIN(d.created_at) FROM delivery_log d WHERE d.campaign_id = u.campaign_id
+            AND d.subscriber_id = u.subscriber_id AND d.status = 'sent'),
+        c.started_at, c.created_at)) AS secs
+    FROM unsubscriptions u
+    JOIN campaigns c ON (c.id = u.campaign_id)
+    WHERE u.campaign_id = $1
+),
+t AS (
+    SELECT (CASE WHEN secs < 3600 THEN 1 WHEN secs < 21600 THEN 2 WHEN secs < 86400 THEN 3
+        WHEN secs < 259200 THEN 4 WHEN secs < 604800 THEN 5 ELSE 6 END) AS n, COUNT(*) AS count
+    FROM u GROUP BY n
+)
+SELECT JSON_BUILD_OBJECT('unsubscribes', (SELECT COUNT(*) FROM u),
+                        'sent', c.sent,
+                        'rate', (CASE WHEN c.sent > 0 THEN ROUND((SELECT COUNT(*) FROM u) * 100.0 / c.sent, 2) ELSE 0 END),
+                        'median_seconds', (SELECT COALESCE(ROUND(PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY GREATEST(secs, 0)))::BIGINT, 0) FROM u),
+                        'timing', (
+                            SELECT JSON_AGG(JSON_BUILD_OBJECT('after', b.label, 'count', COALESCE(t.count, 0)) ORDER BY b.n)
+                            FROM (VALUES (1, '< 1h'), (2, '1h - 6h'), (3, '6h - 24h'), (4, '1d - 3d'), (5, '3d - 7d'), (6, '> 7d')) AS b(n, label)
+                            LEFT JOIN t ON (t.n = b.n)
+                        ),
+                        'reasons', COALESCE((
+                            SELECT JSON_AGG(r ORDER BY r.count DESC, r.reason) FROM
+                            (SELECT reason, COUNT(*) AS count FROM u WHERE reason != '' GROUP BY reason) r
+                        ), '[]'))
+    FROM campaigns c WHERE c.id = $1;
+
+-- name: record-conversion
+-- Records a conversion attributed to a campaign ($1) by a subscriber looked up by UUID ($2),
+-- or e-mail ($3) if there's no UUID, or by no one if there's neither. Returns no rows if the
+-- campaign or the given subscriber doesn't exist.
+WITH sub AS (
+    SELECT id FROM subscribers WHERE
+        (CASE WHEN $2::TEXT != '' THEN uuid = $2::UUID ELSE email = LOWER($3) END)
+)
+INSERT INTO conversions (campaign_id, subscriber_id, meta)
+    SELECT campaigns.id, (SELECT id FROM sub), $4::JSONB FROM campaigns
+    WHERE campaigns.uuid = $1 AND (($2 = '' AND $3 = '') OR EXISTS (SELECT 1 FROM sub))
+    RETURNING id;
+
+-- name: get-campaign-funnel
+-- Returns the unique subscribers at each stage of a campaign's funnel. Queued are the subscribers
+-- the campaign was to be sent to, and delivered are the messages reported as delivered by the
+-- messenger, or the messages sent that didn't bounce. Opens, clicks, and conversions are only
+-- recorded per subscriber with individual tracking. Opens and clicks are of the raw views and
+-- clicks in the retention period.
+SELECT c.to_send AS queued,
+    (CASE WHEN c.delivered > 0 THEN c.delivered
+        ELSE GREATEST(c.sent - (SELECT COUNT(DISTINCT subscriber_id) FROM bounces WHERE campaign_id = c.id), 0) END) AS delivered,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM campaign_views WHERE campaign_id = c.id) AS opened,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM link_clicks WHERE campaign_id = c.id) AS clicked,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM conversions WHERE campaign_id = c.id) AS converted,
+    (SELECT COUNT(*) FROM conversions WHERE campaign_id = c.id) AS conversions
+    FROM campaigns c WHERE c.id = $1;
+
+-- name: get-campaign-link-heatmap
+-- Returns the clicks of a campaign by the position of the link in the rendered message. A
+-- position may have more than one URL if the message's content varies across subscribers.
+-- Positions aren't kept in the daily counts, so clicks that have been pruned aren't included.
+SELECT link_clicks.position, links.url, COUNT(*) AS clicks,
+    COUNT(DISTINCT link_clicks.subscriber_id) AS unique_clicks
+    FROM link_clicks
+    JOIN links ON (links.id = link_clicks.link_id)
+    WHERE link_clicks.campaign_id = $1 AND link_clicks.position IS NOT NULL
+    GROUP BY link_clicks.position, links.url
+    ORDER BY link_clicks.position, clicks DESC;
+
+-- bounces
+-- name: record-bounce
+-- Records a bounce against a subscriber looked up by UUID, or e-mail if there's
+-- no UUID, and returns the subscriber's ID and the number of bounces of the type
+-- including the new one. Returns no rows if the subscriber doesn't exist.
+WITH sub AS (
+    SELECT id FROM subscribers WHERE
+        (CASE WHEN $1::TEXT != '' THEN uuid = $1::UUID ELSE email = LOWER($2) END)
+),
+camp AS (
+    SELECT id FROM campaigns WHERE
+        (CASE WHEN $3::TEXT != '' THEN uuid = $3::UUID ELSE FALSE END)
+),
+b AS (
+    INSERT INTO bounces (subscriber_id, campaign_id, type, source, meta)
+        SELECT (SELECT id FROM sub), (SELECT id FROM camp), $4::bounce_type, $5, $6::JSONB
+        WHERE EXISTS (SELECT 1 FROM sub)
+)
+SELECT sub.id AS subscriber_id,
+    (SELECT COUNT(*) FROM bounces WHERE subscriber_id = sub.id AND type = $4::bounce_type) + 1 AS count
+    FROM sub;
+
+-- name: record-complaint
+-- Records a complaint against a subscriber looked up by UUID, or e-mail if there's
+-- no UUID, and suppresses the subscriber by blocklisting them and unsubscribing
+-- them from all lists. Returns no rows if the subscriber doesn't exist.
+WITH sub AS (
+    SELECT id FROM subscribers WHERE
+        (CASE WHEN $1::TEXT != '' THEN uuid = $1::UUID ELSE email = LOWER($2) END)
+),
+camp AS (
+    SELECT id FROM campaigns WHERE
+        (CASE WHEN $3::TEXT != '' THEN uuid = $3::UUID ELSE FALSE END)
+),
+c AS (
+    INSERT INTO complaints (subscriber_id, campaign_id, source, meta)
+        SELECT (SELECT id FROM sub), (SELECT id FROM camp), $4, $5::JSONB
+        WHERE EXISTS (SELECT 1 FROM sub)
+),
+b AS (
+    UPDATE subscribers SET status='blocklisted', updated_at=NOW()
+    WHERE id = (SELECT id FROM sub)
+),
+u AS (
+    UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+    WHERE subscriber_id = (SELECT id FROM sub)
+)
+SELECT id AS subscriber_id FROM sub;
+
+-- name: register-campaign-delivery
+-- Increments the count of messages in a campaign reported as delivered by the messenger's service.
+UPDATE campaigns SET delivered = delivered + $2 WHERE uuid = $1;
+
 -- name: get-dashboard-charts
+-- The daily counts include the raw views and clicks that have been pruned.
 WITH clicks AS (
     -- Clicks by day for the last 3 months
     SELECT JSON_AGG(ROW_TO_JSON(row))
-    FROM (SELECT COUNT(*) AS count, created_at::DATE as date
-          FROM link_clicks GROUP by date ORDER BY date DESC LIMIT 100
+    FROM (SELECT SUM(num)::BIGINT AS count, date FROM (
+              SELECT COUNT(*) AS num, created_at::DATE as date FROM link_clicks GROUP BY date
+              UNION ALL
+              SELECT SUM(clicks), date FROM link_clicks_daily GROUP BY date
+          ) c GROUP by date ORDER BY date DESC LIMIT 100
     ) row
 ),
 views AS (
     -- Views by day for the last 3 months
     SELECT JSON_AGG(ROW_TO_JSON(row))
-    FROM (SELECT COUNT(*) AS count, created_at::DATE as date
-          FROM campaign_views GROUP by date ORDER BY date DESC LIMIT 100
+    FROM (SELECT SUM(num)::BIGINT AS count, date FROM (
+              SELECT COUNT(*) AS num, created_at::DATE as date FROM campaign_views GROUP BY date
+              UNION ALL
+              SELECT SUM(views), date FROM campaign_views_daily GROUP BY date
+          ) v GROUP by date ORDER BY date DESC LIMIT 100
     ) row
 )
 SELECT JSON_BUILD_OBJECT('link_clicks', COALESCE((SELECT * FROM clicks), '[]'),
@@ -732,6 +1879,50 @@ SELECT JSON_BUILD_OBJECT('subscribers', JSON_BUILD_OBJECT(
                         ),
                         'messages', (SELECT SUM(sent) AS messages FROM campaigns));
 
+-- name: get-dashboard-stats
+-- Returns instance-wide aggregates. Changes such as subscribers added and lost, and campaigns
+-- finished, are counted from the start of the current period ($1 = day, week, month, or year).
+-- Open and click rates are the average percentage of unique openers and clickers of the
+-- campaigns finished in the period. The period can't start before the tracking retention period.
+WITH p AS (
+    SELECT DATE_TRUNC($1::TEXT, NOW()) AS since
+),
+camps AS (
+    SELECT c.id, c.sent,
+        (SELECT COUNT(DISTINCT subscriber_id) FROM campaign_views WHERE campaign_id = c.id) AS opens,
+        (SELECT COUNT(DISTINCT subscriber_id) FROM link_clicks WHERE campaign_id = c.id) AS clicks
+    FROM campaigns c, p
+    WHERE c.status = 'finished' AND c.updated_at >= p.since
+),
+lost AS (
+    -- Subscribers who unsubscribed from a list or were blocklisted.
+    SELECT subscriber_id AS id FROM subscriber_lists, p
+        WHERE status = 'unsubscribed' AND updated_at >= p.since
+    UNION
+    SELECT id FROM subscribers, p WHERE status = 'blocklisted' AND updated_at >= p.since
+)
+SELECT JSON_BUILD_OBJECT('period', $1::TEXT,
+                        'since', (SELECT since FROM p),
+                        'subscribers', JSON_BUILD_OBJECT(
+                            'total', (SELECT COUNT(*) FROM subscribers),
+                            'by_status', COALESCE((
+                                SELECT JSON_OBJECT_AGG (status, num) FROM
+                                (SELECT status, COUNT(*) AS num FROM subscribers GROUP BY status) r
+                            ), '{}'),
+                            'added', (SELECT COUNT(*) FROM subscribers, p WHERE created_at >= p.since),
+                            'lost', (SELECT COUNT(*) FROM lost)
+                        ),
+                        'campaigns', JSON_BUILD_OBJECT(
+                            'sent', (SELECT COUNT(*) FROM camps)
+                        ),
+                        'messages', JSON_BUILD_OBJECT(
+                            'sent', (SELECT COALESCE(SUM(sent), 0) FROM camps)
+                        ),
+                        'rates', JSON_BUILD_OBJECT(
+                            'open', (SELECT COALESCE(ROUND(AVG(opens * 100.0 / sent), 2), 0) FROM camps WHERE sent > 0),
+                            'click', (SELECT COALESCE(ROUND(AVG(clicks * 100.0 / sent), 2), 0) FROM camps WHERE sent > 0)
+                        ));
+
 -- name: get-settings
 SELECT JSON_OBJECT_AGG(key, value) AS settings
     FROM (
@@ -739,6 +1930,204 @@ SELECT JSON_OBJECT_AGG(key, value) AS settings
     ) t;
 
 -- name: update-settings
-UPDATE settings AS s SET value = c.value
+UPDATE settings AS s SET value = c.value,
+    -- Only the settings whose values change are marked as updated.
+    updated_at = (CASE WHEN s.value != c.value THEN NOW() ELSE s.updated_at END)
     -- For each key in the incoming JSON map, update the row with the key and its value.
     FROM(SELECT * FROM JSONB_EACH($1)) AS c(key, value) WHERE s.key = c.key;
+
+-- name: get-settings-updated-at
+SELECT MAX(updated_at) FROM settings;
+
+-- name: insert-tx-log
+INSERT INTO tx_log (template_id, subscriber_id, email, messenger, subject)
+    VALUES(NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5) RETURNING id;
+
+-- name: update-tx-log
+UPDATE tx_log SET status=$2, attempts=$3, error=$4, updated_at=NOW() WHERE id=$1;
+
+-- name: query-tx-log
+-- Returns the transactional message log optionally filtered by status ($1), template ($2),
+-- subscriber ($3), and recipient e-mail ($4).
+SELECT COUNT(*) OVER () AS total, tx_log.*, COALESCE(templates.name, '') AS template_name
+    FROM tx_log
+    LEFT JOIN templates ON (templates.id = tx_log.template_id)
+    WHERE ($1 = '' OR tx_log.status = $1::tx_status)
+        AND ($2 = 0 OR tx_log.template_id = $2)
+        AND ($3 = 0 OR tx_log.subscriber_id = $3)
+        AND ($4 = '' OR tx_log.email = LOWER($4))
+    ORDER BY tx_log.id DESC OFFSET $5 LIMIT (CASE WHEN $6 = 0 THEN NULL ELSE $6 END);
+
+-- name: insert-delivery-log
+INSERT INTO delivery_log (campaign_id, subscriber_id, email, messenger, subject, status, attempts, response, queued_at)
+    VALUES(NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9);
+
+-- name: query-delivery-log
+-- Returns the delivery log optionally filtered by campaign ($1), subscriber ($2), status ($3),
+-- and recipient e-mail ($4).
+SELECT COUNT(*) OVER () AS total, delivery_log.*, COALESCE(campaigns.name, '') AS campaign_name
+    FROM delivery_log
+    LEFT JOIN campaigns ON (campaigns.id = delivery_log.campaign_id)
+    WHERE ($1 = 0 OR delivery_log.campaign_id = $1)
+        AND ($2 = 0 OR delivery_log.subscriber_id = $2)
+        AND ($3 = '' OR delivery_log.status = $3::delivery_status)
+        AND ($4 = '' OR delivery_log.email ILIKE $4)
+    ORDER BY delivery_log.id DESC OFFSET $5 LIMIT (CASE WHEN $6 = 0 THEN NULL ELSE $6 END);
+
+-- name: delete-delivery-log
+-- Deletes the delivery log entries older than the given timestamp.
+DELETE FROM delivery_log WHERE created_at < $1;
+
+-- tracking retention
+-- name: prune-campaign-views
+-- Deletes the raw campaign views from before the date $1, up to 30 days of them at a time from
+-- the oldest, and adds them to the daily counts. Returns the number of daily counts updated,
+-- which is 0 when there's nothing left to prune.
+WITH del AS (
+    DELETE FROM campaign_views
+    WHERE created_at < LEAST($1::DATE, (SELECT MIN(created_at)::DATE + 30 FROM campaign_views))
+    RETURNING campaign_id, created_at
+)
+INSERT INTO campaign_views_daily (campaign_id, date, views)
+    SELECT campaign_id, created_at::DATE, COUNT(*) FROM del
+    GROUP BY campaign_id, created_at::DATE
+ON CONFLICT (campaign_id, date) DO UPDATE SET views = campaign_views_daily.views + EXCLUDED.views;
+
+-- name: prune-link-clicks
+-- Deletes the raw link clicks from before the date $1, up to 30 days of them at a time from
+-- the oldest, and adds them to the daily counts. Returns the number of daily counts updated,
+-- which is 0 when there's nothing left to prune.
+WITH del AS (
+    DELETE FROM link_clicks
+    WHERE created_at < LEAST($1::DATE, (SELECT MIN(created_at)::DATE + 30 FROM link_clicks))
+    RETURNING campaign_id, link_id, created_at
+)
+INSERT INTO link_clicks_daily (campaign_id, link_id, date, clicks)
+    SELECT campaign_id, link_id, created_at::DATE, COUNT(*) FROM del
+    GROUP BY campaign_id, link_id, created_at::DATE
+ON CONFLICT ((COALESCE(campaign_id, 0)), link_id, date) DO UPDATE SET clicks = link_clicks_daily.clicks + EXCLUDED.clicks;
+
+-- audit log
+-- name: insert-audit-log
+INSERT INTO audit_log (user_id, username, action, meta, ip) VALUES(NULLIF($1, 0), $2, $3, $4, $5);
+
+-- name: query-audit-log
+-- Returns the audit log optionally filtered by username ($1), action or action prefix ($2),
+-- eg: campaign matches campaign.create, and a date range ($3, $4).
+SELECT COUNT(*) OVER () AS total, audit_log.* FROM audit_log
+    WHERE ($1 = '' OR username = LOWER($1))
+        AND ($2 = '' OR action = $2 OR action LIKE $2 || '.%')
+        AND ($3::TIMESTAMP WITH TIME ZONE IS NULL OR created_at >= $3)
+        AND ($4::TIMESTAMP WITH TIME ZONE IS NULL OR created_at < $4)
+    ORDER BY audit_log.id DESC OFFSET $5 LIMIT (CASE WHEN $6 = 0 THEN NULL ELSE $6 END);
+
+-- name: delete-audit-log
+-- Deletes the audit log entries older than the given timestamp.
+DELETE FROM audit_log WHERE created_at < $1;
+
+-- idempotency keys
+-- name: insert-idempotency-key
+-- Claims an idempotency key for a request. Returns no rows if the key already exists.
+INSERT INTO idempotency_keys (key, user_id, request_hash) VALUES($1, $2, $3)
+    ON CONFLICT DO NOTHING RETURNING key;
+
+-- name: get-idempotency-key
+SELECT * FROM idempotency_keys WHERE key = $1 AND user_id = $2;
+
+-- name: update-idempotency-key
+-- Records the response of the request of a claimed idempotency key.
+UPDATE idempotency_keys SET status = $3, response = $4 WHERE key = $1 AND user_id = $2;
+
+-- name: delete-idempotency-key
+DELETE FROM idempotency_keys WHERE key = $1 AND user_id = $2;
+
+-- name: delete-expired-idempotency-keys
+-- Deletes the idempotency keys older than the given timestamp.
+DELETE FROM idempotency_keys WHERE created_at < $1;
+
+-- webhooks
+-- name: get-webhooks
+SELECT * FROM webhooks WHERE ($1 = 0 OR id = $1) ORDER BY id;
+
+-- name: get-webhooks-by-event
+-- Returns the enabled webhooks that are subscribed to the event $1.
+SELECT * FROM webhooks WHERE enabled = true AND $1 = ANY(events);
+
+-- name: create-webhook
+INSERT INTO webhooks (name, url, events, secret, enabled) VALUES($1, $2, $3, $4, $5)
+    RETURNING id;
+
+-- name: update-webhook
+UPDATE webhooks SET
+    name=$2,
+    url=$3,
+    events=$4,
+    secret=(CASE WHEN $5 != '' THEN $5 ELSE secret END),
+    enabled=$6,
+    updated_at=NOW()
+WHERE id = $1;
+
+-- name: delete-webhook
+DELETE FROM webhooks WHERE id = $1;
+
+-- name: create-webhook-delivery
+-- Records a delivery to a webhook ($1) or a list webhook ($2).
+INSERT INTO webhook_deliveries (webhook_id, list_webhook_id, event, payload)
+    VALUES(NULLIF($1, 0), NULLIF($2, 0), $3, $4);
+
+-- name: next-webhook-deliveries
+-- Returns up to $1 pending deliveries that are due along with the URLs
+-- and secrets of their webhooks, and marks them as being sent.
+WITH d AS (
+    UPDATE webhook_deliveries SET status='sending', updated_at=NOW()
+    WHERE id IN (
+        SELECT id FROM webhook_deliveries WHERE status='pending' AND next_attempt_at <= NOW()
+        ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *
+)
+SELECT d.id, d.event, d.payload, d.attempts,
+    COALESCE(webhooks.url, list_webhooks.url, '') AS url,
+    COALESCE(webhooks.secret, list_webhooks.secret, '') AS secret
+    FROM d
+    LEFT JOIN webhooks ON (webhooks.id = d.webhook_id)
+    LEFT JOIN list_webhooks ON (list_webhooks.id = d.list_webhook_id)
+    ORDER BY d.id;
+
+-- name: update-webhook-delivery
+UPDATE webhook_deliveries SET
+    status=$2,
+    attempts=$3,
+    response_status=$4,
+    response=$5,
+    next_attempt_at=COALESCE($6, next_attempt_at),
+    updated_at=NOW()
+WHERE id = $1;
+
+-- name: reset-webhook-deliveries
+-- Requeues the deliveries that were being sent when the app was stopped.
+UPDATE webhook_deliveries SET status='pending' WHERE status='sending';
+
+-- name: query-webhook-deliveries
+-- Returns the deliveries optionally filtered by webhook ($1), list webhook ($2),
+-- status ($3), and event ($4).
+SELECT COUNT(*) OVER () AS total, webhook_deliveries.*,
+    COALESCE(webhooks.url, list_webhooks.url, '') AS url,
+    COALESCE(webhooks.name, '') AS webhook_name
+    FROM webhook_deliveries
+    LEFT JOIN webhooks ON (webhooks.id = webhook_deliveries.webhook_id)
+    LEFT JOIN list_webhooks ON (list_webhooks.id = webhook_deliveries.list_webhook_id)
+    WHERE ($1 = 0 OR webhook_deliveries.webhook_id = $1)
+        AND ($2 = 0 OR webhook_deliveries.list_webhook_id = $2)
+        AND ($3 = '' OR webhook_deliveries.status = $3::webhook_delivery_status)
+        AND ($4 = '' OR webhook_deliveries.event = $4)
+    ORDER BY webhook_deliveries.id DESC OFFSET $5 LIMIT (CASE WHEN $6 = 0 THEN NULL ELSE $6 END);
+
+-- name: retry-webhook-delivery
+-- Queues a dead or delivered delivery to be posted again.
+UPDATE webhook_deliveries SET status='pending', attempts=0, next_attempt_at=NOW(), updated_at=NOW()
+    WHERE id = $1 AND status IN ('dead', 'success');
+
+-- name: delete-webhook-deliveries
+-- Deletes the finished deliveries older than the given timestamp.
+DELETE FROM webhook_deliveries WHERE created_at < $1 AND status IN ('success', 'dead');
@@ -1,10 +1,20 @@
 DROP TYPE IF EXISTS list_type CASCADE; CREATE TYPE list_type AS ENUM ('public', 'private', 'temporary');
 DROP TYPE IF EXISTS list_optin CASCADE; CREATE TYPE list_optin AS ENUM ('single', 'double');
+DROP TYPE IF EXISTS list_unsub_action CASCADE; CREATE TYPE list_unsub_action AS ENUM ('list', 'all', 'blocklist');
+DROP TYPE IF EXISTS list_cap_action CASCADE; CREATE TYPE list_cap_action AS ENUM ('reject', 'waitlist');
 DROP TYPE IF EXISTS subscriber_status CASCADE; CREATE TYPE subscriber_status AS ENUM ('enabled', 'disabled', 'blocklisted');
 DROP TYPE IF EXISTS subscription_status CASCADE; CREATE TYPE subscription_status AS ENUM ('unconfirmed', 'confirmed', 'unsubscribed');
 DROP TYPE IF EXISTS campaign_status CASCADE; CREATE TYPE campaign_status AS ENUM ('draft', 'running', 'scheduled', 'paused', 'cancelled', 'finished');
 DROP TYPE IF EXISTS campaign_type CASCADE; CREATE TYPE campaign_type AS ENUM ('regular', 'optin');
-DROP TYPE IF EXISTS content_type CASCADE; CREATE TYPE content_type AS ENUM ('richtext', 'html', 'plain');
+DROP TYPE IF EXISTS content_type CASCADE; CREATE TYPE content_type AS ENUM ('richtext', 'html', 'plain', 'mjml', 'blocks');
+DROP TYPE IF EXISTS template_type CASCADE; CREATE TYPE template_type AS ENUM ('campaign', 'tx');
+DROP TYPE IF EXISTS bounce_type CASCADE; CREATE TYPE bounce_type AS ENUM ('soft', 'hard', 'block', 'autoreply');
+DROP TYPE IF EXISTS message_queue_status CASCADE; CREATE TYPE message_queue_status AS ENUM ('pending', 'queued', 'sending');
+DROP TYPE IF EXISTS tx_status CASCADE; CREATE TYPE tx_status AS ENUM ('queued', 'sent', 'failed');
+DROP TYPE IF EXISTS delivery_status CASCADE; CREATE TYPE delivery_status AS ENUM ('sent', 'failed');
+DROP TYPE IF EXISTS webhook_delivery_status CASCADE; CREATE TYPE webhook_delivery_status AS ENUM ('pending', 'sending', 'success', 'dead');
+DROP TYPE IF EXISTS user_role CASCADE; CREATE TYPE user_role AS ENUM ('admin', 'campaign_manager', 'viewer');
+DROP TYPE IF EXISTS user_status CASCADE; CREATE TYPE user_status AS ENUM ('enabled', 'disabled');
 
 -- subscribers
 DROP TABLE IF EXISTS subscribers CASCADE;
@@ -23,19 +33,69 @@ CREATE TABLE subscribers (
 DROP INDEX IF EXISTS idx_subs_email; CREATE UNIQUE INDEX idx_subs_email ON subscribers(LOWER(email));
 DROP INDEX IF EXISTS idx_subs_status; CREATE INDEX idx_subs_status ON subscribers(status);
 
+-- list groups
+DROP TABLE IF EXISTS list_groups CASCADE;
+CREATE TABLE list_groups (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL UNIQUE,
+
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
 -- lists
 DROP TABLE IF EXISTS lists CASCADE;
 CREATE TABLE lists (
     id              SERIAL PRIMARY KEY,
     uuid            uuid NOT NULL UNIQUE,
     name            TEXT NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
     type            list_type NOT NULL,
     optin           list_optin NOT NULL DEFAULT 'single',
     tags            VARCHAR(100)[],
 
+    -- Arbitrary subscriber SQL expression for dynamic lists whose
+    -- subscriptions are refreshed from the query at send time.
+    query           TEXT NOT NULL DEFAULT '',
+    synced_at       TIMESTAMP WITH TIME ZONE NULL,
+    group_id        INTEGER NULL REFERENCES list_groups(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    archived        BOOLEAN NOT NULL DEFAULT false,
+
+    -- Default sender identity inherited by campaigns targeting the list.
+    from_email      TEXT NOT NULL DEFAULT '',
+    reply_to        TEXT NOT NULL DEFAULT '',
+
+    -- What the unsubscribe link does: unsubscribe from the list, from all lists, or blocklist.
+    unsub_action    list_unsub_action NOT NULL DEFAULT 'list',
+
+    -- Whether subscriptions to a private list are shown on the public preferences page.
+    show_in_preferences BOOLEAN NOT NULL DEFAULT false,
+
+    -- Optional overrides for the double opt-in confirmation e-mail and pages.
+    optin_email_subject   TEXT NOT NULL DEFAULT '',
+    optin_email_body      TEXT NOT NULL DEFAULT '',
+    optin_page_message    TEXT NOT NULL DEFAULT '',
+    optin_confirm_message TEXT NOT NULL DEFAULT '',
+
+    -- Optional cap on the number of subscribers (0 = no cap) and what happens
+    -- to public signups beyond it.
+    max_subscribers INTEGER NOT NULL DEFAULT 0,
+    cap_action      list_cap_action NOT NULL DEFAULT 'reject',
+    cap_message     TEXT NOT NULL DEFAULT '',
+
+    -- Subscriber attributes collected (and optionally required) at signup.
+    -- [{"key": "", "label": "", "required": false}]
+    fields          JSONB NOT NULL DEFAULT '[]',
+
+    -- Optional send frequency rule: at most max_campaigns campaigns
+    -- in max_campaigns_days days (0 = no limit).
+    max_campaigns      INTEGER NOT NULL DEFAULT 0,
+    max_campaigns_days INTEGER NOT NULL DEFAULT 0,
+
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
+DROP INDEX IF EXISTS idx_lists_group_id; CREATE INDEX idx_lists_group_id ON lists(group_id);
 
 DROP TABLE IF EXISTS subscriber_lists CASCADE;
 CREATE TABLE subscriber_lists (
@@ -52,18 +112,275 @@ DROP INDEX IF EXISTS idx_sub_lists_sub_id; CREATE INDEX idx_sub_lists_sub_id ON
 DROP INDEX IF EXISTS idx_sub_lists_list_id; CREATE INDEX idx_sub_lists_list_id ON subscriber_lists(list_id);
 DROP INDEX IF EXISTS idx_sub_lists_status; CREATE INDEX idx_sub_lists_status ON subscriber_lists(status);
 
+-- list_waitlist
+DROP TABLE IF EXISTS list_waitlist CASCADE;
+CREATE TABLE list_waitlist (
+    subscriber_id      INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    list_id            INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    created_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    PRIMARY KEY(subscriber_id, list_id)
+);
+DROP INDEX IF EXISTS idx_list_waitlist_list_id; CREATE INDEX idx_list_waitlist_list_id ON list_waitlist(list_id);
+
+-- Subscriptions to lists that have reached their subscriber cap, by any means (API,
+-- imports, public forms etc.), are either skipped or waitlisted per the list's cap_action.
+CREATE OR REPLACE FUNCTION cap_list_subscriptions() RETURNS TRIGGER AS $$
+DECLARE
+    l RECORD;
+BEGIN
+    SELECT max_subscribers, cap_action INTO l FROM lists WHERE id = NEW.list_id;
+    IF NOT FOUND OR l.max_subscribers = 0 OR NEW.status = 'unsubscribed' THEN
+        RETURN NEW;
+    END IF;
+
+    -- Existing subscriptions are upserted as usual.
+    IF EXISTS (SELECT 1 FROM subscriber_lists WHERE subscriber_id = NEW.subscriber_id AND list_id = NEW.list_id) THEN
+        RETURN NEW;
+    END IF;
+    IF (SELECT COUNT(*) FROM subscriber_lists WHERE list_id = NEW.list_id AND status != 'unsubscribed') < l.max_subscribers THEN
+        RETURN NEW;
+    END IF;
+
+    -- The list is full. Waitlist the subscriber or skip the subscription.
+    IF l.cap_action = 'waitlist' THEN
+        INSERT INTO list_waitlist (subscriber_id, list_id) VALUES (NEW.subscriber_id, NEW.list_id)
+            ON CONFLICT (subscriber_id, list_id) DO NOTHING;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS trg_cap_list_subscriptions ON subscriber_lists;
+CREATE TRIGGER trg_cap_list_subscriptions BEFORE INSERT ON subscriber_lists
+    FOR EACH ROW EXECUTE PROCEDURE cap_list_subscriptions();
+
+-- subscription_events
+-- Log of the subscriptions and subscription status changes of lists for computing
+-- their growth. Rows are recorded by a trigger on subscriber_lists.
+DROP TABLE IF EXISTS subscription_events CASCADE;
+CREATE TABLE subscription_events (
+    -- Subscribers may be deleted, but the list's growth history should remain.
+    subscriber_id      INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    list_id            INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    status             subscription_status NOT NULL,
+    created_at         TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_sub_events_list_id; CREATE INDEX idx_sub_events_list_id ON subscription_events(list_id, created_at);
+
+CREATE OR REPLACE FUNCTION log_subscription_event() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.list_id IS NULL THEN
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'UPDATE' THEN
+        IF NEW.status = OLD.status THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+    INSERT INTO subscription_events (subscriber_id, list_id, status) VALUES (NEW.subscriber_id, NEW.list_id, NEW.status);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS trg_subscription_events ON subscriber_lists;
+CREATE TRIGGER trg_subscription_events AFTER INSERT OR UPDATE OF status ON subscriber_lists
+    FOR EACH ROW EXECUTE PROCEDURE log_subscription_event();
+
+-- list_snapshots
+-- Daily snapshots of the subscription counts of lists by status.
+DROP TABLE IF EXISTS list_snapshots CASCADE;
+CREATE TABLE list_snapshots (
+    list_id            INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    date               DATE NOT NULL,
+    unconfirmed        INTEGER NOT NULL DEFAULT 0,
+    confirmed          INTEGER NOT NULL DEFAULT 0,
+    unsubscribed       INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(list_id, date)
+);
+
+-- subscriber_cohorts
+-- Monthly engagement of the cohorts of subscribers who signed up in the same month.
+-- Subscribers counts the cohort's subscribers as of the last time the month was recorded.
+DROP TABLE IF EXISTS subscriber_cohorts CASCADE;
+CREATE TABLE subscriber_cohorts (
+    cohort             DATE NOT NULL,
+    month              DATE NOT NULL,
+    subscribers        INTEGER NOT NULL DEFAULT 0,
+    opened             INTEGER NOT NULL DEFAULT 0,
+    clicked            INTEGER NOT NULL DEFAULT 0,
+
+    -- Subscribers who opened or clicked.
+    engaged            INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(cohort, month)
+);
+
+-- list_webhooks
+DROP TABLE IF EXISTS list_webhooks CASCADE;
+CREATE TABLE list_webhooks (
+    id              SERIAL PRIMARY KEY,
+    list_id         INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    url             TEXT NOT NULL,
+    events          TEXT[] NOT NULL DEFAULT '{}',
+    secret          TEXT NOT NULL DEFAULT '',
+    enabled         BOOLEAN NOT NULL DEFAULT true,
+
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_list_webhooks_list_id; CREATE INDEX idx_list_webhooks_list_id ON list_webhooks(list_id);
+
+-- webhooks
+DROP TABLE IF EXISTS webhooks CASCADE;
+CREATE TABLE webhooks (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    url             TEXT NOT NULL,
+    events          TEXT[] NOT NULL DEFAULT '{}',
+    secret          TEXT NOT NULL DEFAULT '',
+    enabled         BOOLEAN NOT NULL DEFAULT true,
+
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- deliveries of events to webhooks or list webhooks
+DROP TABLE IF EXISTS webhook_deliveries CASCADE;
+CREATE TABLE webhook_deliveries (
+    id               BIGSERIAL PRIMARY KEY,
+    webhook_id       INTEGER NULL REFERENCES webhooks(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    list_webhook_id  INTEGER NULL REFERENCES list_webhooks(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    event            TEXT NOT NULL,
+    payload          JSONB NOT NULL DEFAULT '{}',
+    status           webhook_delivery_status NOT NULL DEFAULT 'pending',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+
+    -- Outcome of the last attempt. The status is 0 if the request failed.
+    response_status  INTEGER NOT NULL DEFAULT 0,
+    response         TEXT NOT NULL DEFAULT '',
+
+    next_attempt_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_webhook_deliveries_webhook_id; CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+DROP INDEX IF EXISTS idx_webhook_deliveries_list_webhook_id; CREATE INDEX idx_webhook_deliveries_list_webhook_id ON webhook_deliveries(list_webhook_id);
+DROP INDEX IF EXISTS idx_webhook_deliveries_status; CREATE INDEX idx_webhook_deliveries_status ON webhook_deliveries(status, next_attempt_at);
+DROP INDEX IF EXISTS idx_webhook_deliveries_created_at; CREATE INDEX idx_webhook_deliveries_created_at ON webhook_deliveries(created_at);
+
 -- templates
 DROP TABLE IF EXISTS templates CASCADE;
 CREATE TABLE templates (
     id              SERIAL PRIMARY KEY,
     name            TEXT NOT NULL,
     body            TEXT NOT NULL,
+
+    -- The MJML source of the body, if the template is written in MJML.
+    -- body holds the HTML compiled from it.
+    body_source     TEXT NOT NULL DEFAULT '',
     is_default      BOOLEAN NOT NULL DEFAULT false,
 
+    -- The fallback template is used for campaigns whose template is missing.
+    -- If there's none, the default template is used.
+    is_fallback     BOOLEAN NOT NULL DEFAULT false,
+
+    -- Transactional (tx) templates can't be used for campaigns. They're
+    -- addressed by name and have a subject.
+    type            template_type NOT NULL DEFAULT 'campaign',
+    subject         TEXT NOT NULL DEFAULT '',
+
+    -- Variables ({name, type, default ...}) that the template declares.
+    variables       JSONB NOT NULL DEFAULT '[]',
+
+    -- Filename of the preview image in the media store.
+    thumb           TEXT NOT NULL DEFAULT '',
+
+    -- Folder and tags for organising templates.
+    folder          TEXT NOT NULL DEFAULT '',
+    tags            VARCHAR(100)[],
+
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 CREATE UNIQUE INDEX ON templates (is_default) WHERE is_default = true;
+CREATE UNIQUE INDEX idx_templates_fallback ON templates (is_fallback) WHERE is_fallback = true;
+CREATE UNIQUE INDEX idx_templates_tx_name ON templates (name) WHERE type = 'tx';
+
+-- template_variants: language variants of templates that are used for
+-- subscribers with a matching `lang` attribute.
+DROP TABLE IF EXISTS template_variants CASCADE;
+CREATE TABLE template_variants (
+    id              SERIAL PRIMARY KEY,
+    template_id     INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    lang            TEXT NOT NULL,
+    body            TEXT NOT NULL,
+    body_source     TEXT NOT NULL DEFAULT '',
+
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    UNIQUE(template_id, lang)
+);
+
+-- template_fixtures: named sample data for previewing and test rendering
+-- templates.
+DROP TABLE IF EXISTS template_fixtures CASCADE;
+CREATE TABLE template_fixtures (
+    id              SERIAL PRIMARY KEY,
+    template_id     INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    name            TEXT NOT NULL,
+
+    -- Fake subscriber {name, email, attribs} and campaign fields {name, subject, from_email, body}.
+    subscriber      JSONB NOT NULL DEFAULT '{}',
+    campaign        JSONB NOT NULL DEFAULT '{}',
+
+    -- Template variable values, or the data of transactional templates.
+    data            JSONB NOT NULL DEFAULT '{}',
+
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    UNIQUE(template_id, name)
+);
+
+-- template_partials
+DROP TABLE IF EXISTS template_partials CASCADE;
+CREATE TABLE template_partials (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL UNIQUE,
+    body            TEXT NOT NULL,
+
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- system_templates: per-language overrides of the built-in e-mails
+-- sent to subscribers (eg: opt-in confirmation).
+DROP TABLE IF EXISTS system_templates CASCADE;
+CREATE TABLE system_templates (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    lang            TEXT NOT NULL,
+    subject         TEXT NOT NULL DEFAULT '',
+    body            TEXT NOT NULL,
+
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    UNIQUE(name, lang)
+);
+
+-- template_revisions
+DROP TABLE IF EXISTS template_revisions CASCADE;
+CREATE TABLE template_revisions (
+    id              SERIAL PRIMARY KEY,
+    template_id     INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    body            TEXT NOT NULL,
+    body_source     TEXT NOT NULL DEFAULT '',
+    author          TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_tpl_revisions_tpl_id; CREATE INDEX idx_tpl_revisions_tpl_id ON template_revisions(template_id);
 
 
 -- campaigns
@@ -74,8 +391,19 @@ CREATE TABLE campaigns (
     name             TEXT NOT NULL,
     subject          TEXT NOT NULL,
     from_email       TEXT NOT NULL,
+    reply_to         TEXT NOT NULL DEFAULT '',
     body             TEXT NOT NULL,
     content_type     content_type NOT NULL DEFAULT 'richtext',
+
+    -- The MJML source of the body when content_type is 'mjml'. body
+    -- holds the HTML compiled from it.
+    body_source      TEXT NOT NULL DEFAULT '',
+
+    -- Values of the variables declared by the campaign's template.
+    template_vars    JSONB NOT NULL DEFAULT '{}',
+
+    -- Whether the open tracking pixel is embedded in the campaign's messages.
+    track_opens      BOOLEAN NOT NULL DEFAULT true,
     send_at          TIMESTAMP WITH TIME ZONE,
     status           campaign_status NOT NULL DEFAULT 'draft',
     tags             VARCHAR(100)[],
@@ -91,6 +419,7 @@ CREATE TABLE campaigns (
     -- Progress and stats.
     to_send            INT NOT NULL DEFAULT 0,
     sent               INT NOT NULL DEFAULT 0,
+    delivered          INT NOT NULL DEFAULT 0,
     max_subscriber_id  INT NOT NULL DEFAULT 0,
     last_subscriber_id INT NOT NULL DEFAULT 0,
 
@@ -114,14 +443,27 @@ DROP INDEX IF EXISTS idx_camp_lists_list_id; CREATE INDEX idx_camp_lists_list_id
 
 DROP TABLE IF EXISTS campaign_views CASCADE;
 CREATE TABLE campaign_views (
+    id               BIGSERIAL PRIMARY KEY,
     campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
 
     -- Subscribers may be deleted, but the view counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- IP of the request truncated as per privacy.ip_truncation, and its location
+    -- if a GeoIP DB is configured.
+    ip               TEXT NOT NULL DEFAULT '',
+    country          TEXT NOT NULL DEFAULT '',
+    region           TEXT NOT NULL DEFAULT '',
+
+    -- E-mail client or browser, and device class from the request's User-Agent.
+    client           TEXT NOT NULL DEFAULT '',
+    device           TEXT NOT NULL DEFAULT '',
+    user_agent       TEXT NOT NULL DEFAULT '',
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_views_camp_id; CREATE INDEX idx_views_camp_id ON campaign_views(campaign_id);
 DROP INDEX IF EXISTS idx_views_subscriber_id; CREATE INDEX idx_views_subscriber_id ON campaign_views(subscriber_id);
+DROP INDEX IF EXISTS idx_views_created_at; CREATE INDEX idx_views_created_at ON campaign_views(created_at);
 
 -- media
 DROP TABLE IF EXISTS media CASCADE;
@@ -145,16 +487,284 @@ CREATE TABLE links (
 
 DROP TABLE IF EXISTS link_clicks CASCADE;
 CREATE TABLE link_clicks (
+    id               BIGSERIAL PRIMARY KEY,
     campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
     link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
 
     -- Subscribers may be deleted, but the link counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    ip               TEXT NOT NULL DEFAULT '',
+    country          TEXT NOT NULL DEFAULT '',
+    region           TEXT NOT NULL DEFAULT '',
+    client           TEXT NOT NULL DEFAULT '',
+    device           TEXT NOT NULL DEFAULT '',
+    user_agent       TEXT NOT NULL DEFAULT '',
+
+    -- Position of the link among the tracked links in the rendered message,
+    -- starting at 1, for click heatmaps. Unknown for older links.
+    position         INTEGER NULL,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_clicks_camp_id; CREATE INDEX idx_clicks_camp_id ON link_clicks(campaign_id);
 DROP INDEX IF EXISTS idx_clicks_link_id; CREATE INDEX idx_clicks_link_id ON link_clicks(link_id);
 DROP INDEX IF EXISTS idx_clicks_sub_id; CREATE INDEX idx_clicks_sub_id ON link_clicks(subscriber_id);
+DROP INDEX IF EXISTS idx_clicks_created_at; CREATE INDEX idx_clicks_created_at ON link_clicks(created_at);
+
+-- campaign_views_daily, link_clicks_daily
+-- Daily counts of the raw views and clicks that have been pruned after the retention period.
+-- There are no unique counts as the subscribers of different days can't be told apart.
+DROP TABLE IF EXISTS campaign_views_daily CASCADE;
+CREATE TABLE campaign_views_daily (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    date             DATE NOT NULL,
+    views            INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(campaign_id, date)
+);
+
+DROP TABLE IF EXISTS link_clicks_daily CASCADE;
+CREATE TABLE link_clicks_daily (
+    campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    date             DATE NOT NULL,
+    clicks           INTEGER NOT NULL DEFAULT 0
+);
+DROP INDEX IF EXISTS idx_clicks_daily; CREATE UNIQUE INDEX idx_clicks_daily ON link_clicks_daily(COALESCE(campaign_id, 0), link_id, date);
+
+-- bounces
+DROP TABLE IF EXISTS bounces CASCADE;
+CREATE TABLE bounces (
+    id               SERIAL PRIMARY KEY,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    type             bounce_type NOT NULL DEFAULT 'hard',
+    source           TEXT NOT NULL DEFAULT '',
+    meta             JSONB NOT NULL DEFAULT '{}',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_bounces_sub_id; CREATE INDEX idx_bounces_sub_id ON bounces(subscriber_id);
+DROP INDEX IF EXISTS idx_bounces_camp_id; CREATE INDEX idx_bounces_camp_id ON bounces(campaign_id);
+
+-- unsubscriptions
+-- Unsubscriptions from the links in campaigns.
+DROP TABLE IF EXISTS unsubscriptions CASCADE;
+CREATE TABLE unsubscriptions (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Subscribers may be deleted, but the unsubscription counts should remain.
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- Reason given by the subscriber on the unsubscribe page, if any.
+    reason           TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_unsubs_camp_id; CREATE INDEX idx_unsubs_camp_id ON unsubscriptions(campaign_id);
+DROP INDEX IF EXISTS idx_unsubs_sub_id; CREATE INDEX idx_unsubs_sub_id ON unsubscriptions(subscriber_id);
+
+-- deferrals
+-- Temporary rejections (greylisting) of messages by the recipients' servers.
+DROP TABLE IF EXISTS deferrals CASCADE;
+CREATE TABLE deferrals (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    domain           TEXT NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_deferrals_camp_id; CREATE INDEX idx_deferrals_camp_id ON deferrals(campaign_id);
+DROP INDEX IF EXISTS idx_deferrals_created_at; CREATE INDEX idx_deferrals_created_at ON deferrals(created_at);
+
+-- conversions
+-- Conversions attributed to campaigns that are reported by external systems.
+DROP TABLE IF EXISTS conversions CASCADE;
+CREATE TABLE conversions (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Subscribers may be deleted, but the conversion counts should remain.
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    meta             JSONB NOT NULL DEFAULT '{}',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_conversions_camp_id; CREATE INDEX idx_conversions_camp_id ON conversions(campaign_id);
+DROP INDEX IF EXISTS idx_conversions_sub_id; CREATE INDEX idx_conversions_sub_id ON conversions(subscriber_id);
+
+-- complaints
+DROP TABLE IF EXISTS complaints CASCADE;
+CREATE TABLE complaints (
+    id               SERIAL PRIMARY KEY,
+
+    -- Subscribers may be deleted, but the complaint counts should remain.
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    source           TEXT NOT NULL DEFAULT '',
+    meta             JSONB NOT NULL DEFAULT '{}',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_complaints_sub_id; CREATE INDEX idx_complaints_sub_id ON complaints(subscriber_id);
+DROP INDEX IF EXISTS idx_complaints_camp_id; CREATE INDEX idx_complaints_camp_id ON complaints(campaign_id);
+
+-- message queue
+-- Outgoing messages are persisted here till they're sent so that they survive restarts.
+-- Campaign messages are rendered when they're sent and only the campaign and subscriber are recorded.
+DROP TABLE IF EXISTS message_queue CASCADE;
+CREATE TABLE message_queue (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    messenger        TEXT NOT NULL,
+    message          JSONB NOT NULL DEFAULT '{}',
+    status           message_queue_status NOT NULL DEFAULT 'queued',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_message_queue_camp_sub; CREATE UNIQUE INDEX idx_message_queue_camp_sub ON message_queue(campaign_id, subscriber_id);
+DROP INDEX IF EXISTS idx_message_queue_status; CREATE INDEX idx_message_queue_status ON message_queue(status);
+
+-- transactional message log
+DROP TABLE IF EXISTS tx_log CASCADE;
+CREATE TABLE tx_log (
+    id               BIGSERIAL PRIMARY KEY,
+    template_id      INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- Messages may be sent to raw addresses that aren't subscribers.
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    email            TEXT NOT NULL,
+    messenger        TEXT NOT NULL,
+    subject          TEXT NOT NULL DEFAULT '',
+    status           tx_status NOT NULL DEFAULT 'queued',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    error            TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_tx_log_sub_id; CREATE INDEX idx_tx_log_sub_id ON tx_log(subscriber_id);
+DROP INDEX IF EXISTS idx_tx_log_email; CREATE INDEX idx_tx_log_email ON tx_log(email);
+DROP INDEX IF EXISTS idx_tx_log_status; CREATE INDEX idx_tx_log_status ON tx_log(status);
+
+-- delivery log of outgoing messages
+DROP TABLE IF EXISTS delivery_log CASCADE;
+CREATE TABLE delivery_log (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    email            TEXT NOT NULL,
+    messenger        TEXT NOT NULL,
+    subject          TEXT NOT NULL DEFAULT '',
+    status           delivery_status NOT NULL,
+    attempts         INTEGER NOT NULL DEFAULT 0,
+
+    -- Error response of the last attempt.
+    response         TEXT NOT NULL DEFAULT '',
+    queued_at        TIMESTAMP WITH TIME ZONE NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_delivery_log_camp_id; CREATE INDEX idx_delivery_log_camp_id ON delivery_log(campaign_id);
+DROP INDEX IF EXISTS idx_delivery_log_sub_id; CREATE INDEX idx_delivery_log_sub_id ON delivery_log(subscriber_id);
+DROP INDEX IF EXISTS idx_delivery_log_status; CREATE INDEX idx_delivery_log_status ON delivery_log(status);
+DROP INDEX IF EXISTS idx_delivery_log_created_at; CREATE INDEX idx_delivery_log_created_at ON delivery_log(created_at);
+
+-- admin users
+DROP TABLE IF EXISTS users CASCADE;
+CREATE TABLE users (
+    id               SERIAL PRIMARY KEY,
+    username         TEXT NOT NULL UNIQUE,
+    name             TEXT NOT NULL DEFAULT '',
+    email            TEXT NOT NULL DEFAULT '',
+
+    -- bcrypt hash of the password.
+    password         TEXT NOT NULL,
+    role             user_role NOT NULL DEFAULT 'viewer',
+    status           user_status NOT NULL DEFAULT 'enabled',
+
+    -- Optional TOTP two-factor authentication. The secret is set on enrollment
+    -- and 2FA is enabled once a code from it is verified. Recovery codes are
+    -- stored as SHA-256 hashes and are removed once used.
+    totp_secret      TEXT NOT NULL DEFAULT '',
+    totp_enabled     BOOLEAN NOT NULL DEFAULT false,
+    recovery_codes   TEXT[] NOT NULL DEFAULT '{}',
+    last_login_at    TIMESTAMP WITH TIME ZONE NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- permissions of the non-admin user roles. admins have all the permissions.
+DROP TABLE IF EXISTS role_permissions CASCADE;
+CREATE TABLE role_permissions (
+    role             user_role NOT NULL PRIMARY KEY,
+    permissions      TEXT[] NOT NULL DEFAULT '{}',
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+INSERT INTO role_permissions (role, permissions) VALUES
+    ('campaign_manager', '{subscribers:get,subscribers:manage,subscribers:import,subscribers:export,lists:get,lists:manage,campaigns:get,campaigns:manage,campaigns:send,templates:get,templates:manage,media:get,media:manage,tx:get,tx:send}'),
+    ('viewer', '{subscribers:get,lists:get,campaigns:get,templates:get,media:get,tx:get}');
+
+-- admin user sessions
+DROP TABLE IF EXISTS sessions CASCADE;
+CREATE TABLE sessions (
+    -- SHA-256 hash of the session token in the cookie.
+    id               TEXT NOT NULL PRIMARY KEY,
+    user_id          INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    csrf_token       TEXT NOT NULL,
+    ip               TEXT NOT NULL DEFAULT '',
+    user_agent       TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at       TIMESTAMP WITH TIME ZONE NOT NULL
+);
+DROP INDEX IF EXISTS idx_sessions_user_id; CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+DROP INDEX IF EXISTS idx_sessions_expires_at; CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+
+-- request counts of the API clients (BasicAuth) of admin users
+DROP TABLE IF EXISTS api_usage CASCADE;
+CREATE TABLE api_usage (
+    user_id          INTEGER NOT NULL PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    requests         BIGINT NOT NULL DEFAULT 0,
+
+    -- Requests that failed with 4xx or 5xx responses.
+    errors           BIGINT NOT NULL DEFAULT 0,
+    last_ip          TEXT NOT NULL DEFAULT '',
+    last_seen_at     TIMESTAMP WITH TIME ZONE NULL,
+    last_error_at    TIMESTAMP WITH TIME ZONE NULL
+);
+
+-- append-only audit log of administrative actions
+DROP TABLE IF EXISTS audit_log CASCADE;
+CREATE TABLE audit_log (
+    id               BIGSERIAL PRIMARY KEY,
+    user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- The username is retained after the user is deleted.
+    username         TEXT NOT NULL DEFAULT '',
+    action           TEXT NOT NULL,
+
+    -- Route params (eg: the ID of the object) and any details of the action.
+    meta             JSONB NOT NULL DEFAULT '{}',
+    ip               TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_audit_log_username; CREATE INDEX idx_audit_log_username ON audit_log(username);
+DROP INDEX IF EXISTS idx_audit_log_action; CREATE INDEX idx_audit_log_action ON audit_log(action);
+DROP INDEX IF EXISTS idx_audit_log_created_at; CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
+
+-- responses of API requests made with idempotency keys that are replayed on retries
+DROP TABLE IF EXISTS idempotency_keys CASCADE;
+CREATE TABLE idempotency_keys (
+    key              TEXT NOT NULL,
+
+    -- Keys are scoped to users. 0 is for requests when auth is disabled.
+    user_id          INTEGER NOT NULL DEFAULT 0,
+
+    -- SHA-256 hash of the request method, path, and body.
+    request_hash     TEXT NOT NULL,
+
+    -- The response is NULL while the request is being processed.
+    status           INTEGER NULL,
+    response         BYTEA NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    PRIMARY KEY(user_id, key)
+);
+DROP INDEX IF EXISTS idx_idempotency_keys_created_at; CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys(created_at);
 
 -- settings
 DROP TABLE IF EXISTS settings CASCADE;
@@ -173,12 +783,42 @@ INSERT INTO settings (key, value) VALUES
     ('app.message_rate', '10'),
     ('app.batch_size', '1000'),
     ('app.max_send_errors', '1000'),
+    ('app.tx_message_rate', '0'),
+    ('app.tx_hourly_limit', '0'),
+    ('app.rate_limit_public', '0'),
+    ('app.rate_limit_tracking', '0'),
+    ('app.rate_limit_api', '0'),
+    ('app.cors_origins', '[]'),
+    ('app.cors_methods', '["GET", "POST", "PUT", "DELETE"]'),
+    ('app.cors_headers', '["Content-Type", "Authorization"]'),
+    ('app.legacy_api_sunset', '""'),
+    ('app.admin_allowed_ips', '[]'),
+    ('app.trusted_proxies', '[]'),
+    ('app.domain_limits', '[]'),
+    ('app.delivery_log', 'false'),
+    ('app.delivery_log_retention', '"720h"'),
+    ('app.audit_log_retention', '"2160h"'),
+    ('app.log_level', '"info"'),
+    ('app.log_format', '"text"'),
+    ('app.views_retention', '"0"'),
+    ('app.clicks_retention', '"0"'),
+    ('app.read_only', 'false'),
+    ('app.read_only_reason', '""'),
+    ('app.session_lifetime', '"24h"'),
+    ('app.session_cookie_secure', 'false'),
+    ('app.session_cookie_samesite', '"lax"'),
+    ('app.message_headers', '[]'),
+    ('app.greylist_delay', '"5m"'),
+    ('app.greylist_max_deferrals', '3'),
+    ('app.dynamic_list_sync_interval', '"15m"'),
     ('app.notify_emails', '["admin1@mysite.com", "admin2@mysite.com"]'),
+    ('app.enable_public_list_directory', 'false'),
     ('privacy.individual_tracking', 'false'),
     ('privacy.unsubscribe_header', 'true'),
     ('privacy.allow_blocklist', 'true'),
     ('privacy.allow_export', 'true'),
     ('privacy.allow_wipe', 'true'),
+    ('privacy.ip_truncation', '"partial"'),
     ('privacy.exportable', '["profile", "subscriptions", "campaign_views", "link_clicks"]'),
     ('upload.provider', '"filesystem"'),
     ('upload.filesystem.upload_path', '"uploads"'),
@@ -194,4 +834,26 @@ INSERT INTO settings (key, value) VALUES
     ('smtp',
         '[{"enabled":true, "host":"smtp.yoursite.com","port":25,"auth_protocol":"cram","username":"username","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"tls_enabled":true,"tls_skip_verify":false,"email_headers":[]},
           {"enabled":false, "host":"smtp2.yoursite.com","port":587,"auth_protocol":"plain","username":"username","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"tls_enabled":false,"tls_skip_verify":false,"email_headers":[]}]'),
-    ('messengers', '[]');
+    ('messengers', '[]'),
+    ('ses', '[]'),
+    ('sendgrid', '[]'),
+    ('mailgun', '[]'),
+    ('postmark', '[]'),
+    ('sparkpost', '[]'),
+    ('sendmail', '[]'),
+    ('sms', '[]'),
+    ('dkim', '[]'),
+    ('message_signing', '[]'),
+    ('bounce.enabled', 'false'),
+    ('bounce.ses_enabled', 'false'),
+    ('bounce.ses_topic_arns', '[]'),
+    ('bounce.sendgrid_enabled', 'false'),
+    ('bounce.sendgrid_key', '""'),
+    ('bounce.mailgun_enabled', 'false'),
+    ('bounce.mailgun_key', '""'),
+    ('bounce.verp_enabled', 'false'),
+    ('bounce.verp_pattern', '"bounces+{campaign_uuid}.{subscriber_uuid}"'),
+    ('bounce.verp_domain', '""'),
+    ('bounce.actions', '{"soft": {"count": 2, "action": "none"}, "hard": {"count": 1, "action": "blocklist"}, "block": {"count": 0, "action": "none"}, "autoreply": {"count": 0, "action": "none"}}'),
+    ('bounce.mailboxes', '[]'),
+    ('bounce.rules', '[]');